@@ -0,0 +1,175 @@
+// tundokuctl は運用者向けの API (/v1/admin/*) を呼ぶコマンドラインツール。
+//
+//	go run ./cmd/tundokuctl [-url URL] <command> [args]
+//
+//	users                     ユーザーの一覧 (本の数・最終活動日時つき)
+//	failures <userId>         ユーザーに送れなかった通知
+//	books <userId>            ユーザーの本をすべて出力する
+//	cron-dry-run              期限チェックのドライラン (誰に何を送るか)
+//	redrive [-max N]          デッドレターの期限切れイベントを発行し直す
+//	rotate-cron-secret        CRON_SECRET を新しい値に替えて出力する
+//	llm-usage [YYYY-MM]       生成AIを使った量と料金の見積もり (省略時は今月)
+//
+// 接続先は -url か TUNDOKU_URL、トークンは環境変数 ADMIN_TOKEN と CRON_SECRET (cron-dry-run) から読む
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+const defaultURL = "http://localhost:8081"
+
+func main() {
+	baseURL := flag.String("url", envOr("TUNDOKU_URL", defaultURL), "API のベースURL (TUNDOKU_URL)")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	c := client{
+		baseURL: *baseURL,
+		http:    &http.Client{Timeout: 90 * time.Second},
+	}
+	if err := run(c, flag.Arg(0), flag.Args()[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "tundokuctl:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprint(os.Stderr, `usage: tundokuctl [-url URL] <command> [args]
+
+commands:
+  users                  ユーザーの一覧 (本の数・最終活動日時つき)
+  failures <userId>      ユーザーに送れなかった通知
+  books <userId>         ユーザーの本をすべて出力する
+  cron-dry-run           期限チェックのドライラン (CRON_SECRET が必要)
+  redrive [-max N]       デッドレターの期限切れイベントを発行し直す
+  rotate-cron-secret     CRON_SECRET を新しい値に替えて出力する
+  llm-usage [YYYY-MM]    生成AIを使った量と料金の見積もり (省略時は今月)
+
+ADMIN_TOKEN に /v1/admin/* のトークンを設定しておくこと
+`)
+}
+
+func run(c client, command string, args []string) error {
+	switch command {
+	case "users":
+		return c.print(http.MethodGet, "/v1/admin/users", nil, adminAuth())
+	case "failures":
+		if len(args) != 1 {
+			return errors.New("usage: failures <userId>")
+		}
+		return c.print(http.MethodGet, "/v1/admin/users/notification-failures", url.Values{"userId": {args[0]}}, adminAuth())
+	case "books":
+		if len(args) != 1 {
+			return errors.New("usage: books <userId>")
+		}
+		return c.print(http.MethodGet, "/v1/books", url.Values{"userId": {args[0]}}, adminAuth())
+	case "cron-dry-run":
+		secret := os.Getenv("CRON_SECRET")
+		return c.print(http.MethodGet, "/v1/cron/check", url.Values{"dryRun": {"true"}}, "Bearer "+secret)
+	case "redrive":
+		fs := flag.NewFlagSet("redrive", flag.ExitOnError)
+		max := fs.Int("max", 100, "発行し直す最大の件数 (1〜1000)")
+		fs.Parse(args)
+		return c.print(http.MethodPost, "/v1/admin/dead-letters/redrive", url.Values{"max": {strconv.Itoa(*max)}}, adminAuth())
+	case "rotate-cron-secret":
+		return c.print(http.MethodPost, "/v1/admin/cron-secret/rotate", nil, adminAuth())
+	case "llm-usage":
+		var query url.Values
+		if len(args) > 0 {
+			query = url.Values{"month": {args[0]}}
+		}
+		return c.print(http.MethodGet, "/v1/admin/llm-usage", query, adminAuth())
+	default:
+		usage()
+		return fmt.Errorf("unknown command %q", command)
+	}
+}
+
+func adminAuth() string {
+	return "Bearer " + os.Getenv("ADMIN_TOKEN")
+}
+
+func envOr(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+// client は API を呼んで結果の JSON を整形して出力する
+type client struct {
+	baseURL string
+	http    *http.Client
+}
+
+// problem は API のエラー (RFC 9457 の application/problem+json)
+type problem struct {
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail"`
+	// CorrelationID はサーバーのログを探すための相関ID
+	CorrelationID string `json:"correlationId"`
+}
+
+func (c client) print(method, path string, query url.Values, authorization string) error {
+	u := c.baseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", authorization)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= 300 {
+		var p problem
+		if json.Unmarshal(body, &p) == nil && p.Title != "" {
+			msg := fmt.Sprintf("%s %s: %d %s", method, path, resp.StatusCode, p.Title)
+			if p.Detail != "" {
+				msg += ": " + p.Detail
+			}
+			if p.CorrelationID != "" {
+				msg += " (correlation ID " + p.CorrelationID + ")"
+			}
+			return errors.New(msg)
+		}
+		return fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+
+	var out bytes.Buffer
+	if err := json.Indent(&out, body, "", "  "); err != nil {
+		// JSON でなければそのまま出す
+		_, err := os.Stdout.Write(body)
+		return err
+	}
+	out.WriteByte('\n')
+	_, err = out.WriteTo(os.Stdout)
+	return err
+}
@@ -0,0 +1,135 @@
+// Package analytics はファネルの分析用のプロダクトイベント (book_registered など) の記録。
+// 発行元を待たせないよう Writer がバッファに溜め、裏でまとめて Sink (BigQuery・Firestore) に書く。
+// バッファがあふれたときや書き込みに失敗したときは捨てる (分析用なので取りこぼしは許す)
+package analytics
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// イベント名
+const (
+	BookRegistered = "book_registered"
+	InsultSent     = "insult_sent"
+	BookCompleted  = "book_completed"
+	SnoozeUsed     = "snooze_used" // 読み終えていない本の期限を延ばした
+	InsultFeedback = "insult_feedback"
+)
+
+// Event はプロダクトイベント1件
+type Event struct {
+	Name   string                 `json:"name" firestore:"name"`
+	UserID string                 `json:"userId" firestore:"userId"`
+	BookID string                 `json:"bookId,omitempty" firestore:"bookId,omitempty"`
+	Props  map[string]interface{} `json:"props,omitempty" firestore:"props,omitempty"`
+	At     time.Time              `json:"at" firestore:"at"`
+}
+
+// Sink はイベントをまとめて書き込む先
+type Sink interface {
+	Write(ctx context.Context, events []Event) error
+}
+
+const (
+	// batchSize は Sink に一度に渡すイベントの上限
+	batchSize = 500
+	// writeTimeout は1回の書き込みの時間の上限
+	writeTimeout = 30 * time.Second
+)
+
+// Writer はイベントをバッファに溜め、batchSize 件溜まるか flushInterval ごとに Sink に書く。
+// nil の Writer にも Emit・Close でき、何もしない
+type Writer struct {
+	sink          Sink
+	logger        *log.Logger
+	flushInterval time.Duration
+	events        chan Event
+	done          chan struct{}
+	closeOnce     sync.Once
+	dropped       atomic.Int64
+}
+
+// NewWriter は bufferSize 件まで溜められる Writer を作り、書き込みを始める。使い終わったら Close する
+func NewWriter(sink Sink, bufferSize int, flushInterval time.Duration, logger *log.Logger) *Writer {
+	w := &Writer{
+		sink:          sink,
+		logger:        logger,
+		flushInterval: flushInterval,
+		events:        make(chan Event, bufferSize),
+		done:          make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// Emit は e をバッファに積む。待たずに戻り、バッファがいっぱいなら捨てる
+func (w *Writer) Emit(e Event) {
+	if w == nil {
+		return
+	}
+	if e.At.IsZero() {
+		e.At = time.Now()
+	}
+	select {
+	case w.events <- e:
+	default:
+		if n := w.dropped.Add(1); n == 1 || n%1000 == 0 {
+			w.logger.Printf("Analytics buffer full; %d events dropped so far", n)
+		}
+	}
+}
+
+// Close はバッファに残ったイベントを書き出して止める。ctx が終わったら書き出しを待たずに戻る。
+// Close のあとに Emit してはいけない
+func (w *Writer) Close(ctx context.Context) error {
+	if w == nil {
+		return nil
+	}
+	w.closeOnce.Do(func() { close(w.events) })
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (w *Writer) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]Event, 0, batchSize)
+	for {
+		select {
+		case e, ok := <-w.events:
+			if !ok {
+				w.flush(batch)
+				return
+			}
+			batch = append(batch, e)
+			if len(batch) >= batchSize {
+				batch = w.flush(batch)
+			}
+		case <-ticker.C:
+			batch = w.flush(batch)
+		}
+	}
+}
+
+// flush は batch を書き込み、空にした batch を返す
+func (w *Writer) flush(batch []Event) []Event {
+	if len(batch) == 0 {
+		return batch
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), writeTimeout)
+	defer cancel()
+	if err := w.sink.Write(ctx, batch); err != nil {
+		w.logger.Printf("Error writing %d analytics events: %v", len(batch), err)
+	}
+	return batch[:0]
+}
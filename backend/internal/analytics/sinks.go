@@ -0,0 +1,111 @@
+package analytics
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/google/uuid"
+	bigquery "google.golang.org/api/bigquery/v2"
+)
+
+// FirestoreSink は collection にイベントを1件1ドキュメントで書く
+type FirestoreSink struct {
+	Client     *firestore.Client
+	Collection string
+}
+
+func (s FirestoreSink) Write(ctx context.Context, events []Event) error {
+	bw := s.Client.BulkWriter(ctx)
+	jobs := make([]*firestore.BulkWriterJob, 0, len(events))
+	for _, e := range events {
+		job, err := bw.Create(s.Client.Collection(s.Collection).NewDoc(), e)
+		if err != nil {
+			bw.End()
+			return err
+		}
+		jobs = append(jobs, job)
+	}
+	bw.End()
+
+	failed := 0
+	var firstErr error
+	for _, job := range jobs {
+		if _, err := job.Results(); err != nil {
+			failed++
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	if firstErr != nil {
+		return fmt.Errorf("%d of %d events failed: %w", failed, len(events), firstErr)
+	}
+	return nil
+}
+
+// BigQuerySink はストリーミング挿入 (tabledata.insertAll) でテーブルに書く。テーブルのスキーマは
+//
+//	name STRING, user_id STRING, book_id STRING, props JSON, at TIMESTAMP
+type BigQuerySink struct {
+	Service *bigquery.Service
+	Project string
+	Dataset string
+	Table   string
+}
+
+func (s BigQuerySink) Write(ctx context.Context, events []Event) error {
+	rows := make([]*bigquery.TableDataInsertAllRequestRows, len(events))
+	for i, e := range events {
+		row := map[string]bigquery.JsonValue{
+			"name":    e.Name,
+			"user_id": e.UserID,
+			"at":      e.At.UTC().Format(time.RFC3339Nano),
+		}
+		if e.BookID != "" {
+			row["book_id"] = e.BookID
+		}
+		if len(e.Props) > 0 {
+			props, err := json.Marshal(e.Props)
+			if err != nil {
+				return fmt.Errorf("error encoding props of %s: %w", e.Name, err)
+			}
+			row["props"] = string(props)
+		}
+		// 再送したときに二重に入らないよう、イベントごとに挿入IDを付ける
+		rows[i] = &bigquery.TableDataInsertAllRequestRows{InsertId: uuid.NewString(), Json: row}
+	}
+
+	resp, err := s.Service.Tabledata.InsertAll(s.Project, s.Dataset, s.Table, &bigquery.TableDataInsertAllRequest{
+		Rows:            rows,
+		SkipInvalidRows: true, // 1行の不備でほかの行を巻き込まない
+	}).Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+	if len(resp.InsertErrors) > 0 {
+		first := resp.InsertErrors[0]
+		reason := ""
+		if len(first.Errors) > 0 {
+			reason = first.Errors[0].Message
+		}
+		return fmt.Errorf("%d of %d rows rejected (row %d: %s)", len(resp.InsertErrors), len(events), first.Index, reason)
+	}
+	return nil
+}
+
+// LogSink はイベントをログに出す (ローカル開発用)
+type LogSink struct {
+	Logger *log.Logger
+}
+
+func (s LogSink) Write(_ context.Context, events []Event) error {
+	for _, e := range events {
+		props, _ := json.Marshal(e.Props)
+		s.Logger.Printf("[analytics] %s user=%s book=%s %s", e.Name, e.UserID, e.BookID, props)
+	}
+	return nil
+}
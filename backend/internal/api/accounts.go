@@ -0,0 +1,328 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"firebase.google.com/go/v4/auth"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"tundoku-killer/backend/internal/store"
+)
+
+// 1つのアカウントに複数のログイン方法をつなぐ。
+//   - LINE でできたアカウントに Google やメールアドレスをつなぐのは、フロントエンドで Firebase の linkWithCredential を使う
+//   - Google やメールアドレスでできたアカウントに LINE をつなぐのは /v1/auth/link/line。以降 LINE でログインすると同じアカウントになる
+//   - 別々にできてしまったアカウントは /v1/auth/merge で1つにまとめる
+// LINE でできたアカウントは UID が LINE のユーザーIDなので、それ以外のアカウントへの LINE の送信先は users/{uid}.lineUserId に持つ
+
+// mergedCollections はアカウントをまとめるときに userId を付け替えるコレクション
+var mergedCollections = []string{"books", "insults", "webhooks", "pointLedger", "readingSessions", "deadlineNegotiations", "loans", "purchaseBanOverrides", "calendarFeeds", "activityFeeds", "bookNotes", "priceHistory"}
+
+// LineLink は LINE のユーザーIDと、つないだアカウントの対応。lineLinks/{lineUserId} に保存する
+type LineLink struct {
+	UserID   string    `json:"userId" firestore:"userId"`
+	LinkedAt time.Time `json:"linkedAt" firestore:"linkedAt"`
+}
+
+// linkedUserID は LINE のユーザーIDでログインしたときのアカウントの UID を返す。つないでいなければ LINE のユーザーIDそのもの
+func (s *Server) linkedUserID(ctx context.Context, lineUserID string) (string, error) {
+	doc, err := s.firestoreClient.Collection("lineLinks").Doc(lineUserID).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return lineUserID, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("error fetching LINE link: %w", err)
+	}
+	var link LineLink
+	if err := doc.DataTo(&link); err != nil {
+		return "", fmt.Errorf("error parsing LINE link: %w", err)
+	}
+	return link.UserID, nil
+}
+
+// notificationTarget は通知の送り先。LINE のユーザーID (またはグループID) かメールアドレスのどちらか
+type notificationTarget struct {
+	lineID   string
+	email    string
+	disabled bool // 停止中のアカウント。どこにも送らない
+}
+
+// notificationTargetFor は userID への通知の送り先を返す。
+// LINE をつないだアカウントならその LINE のユーザーID、LINE をつないでいない Google のアカウントならメールアドレス、
+// それ以外 (LINE でできたアカウントや LINE グループのID) は userID をそのまま LINE の送信先にする。
+// 停止中のアカウントなら disabled
+func (s *Server) notificationTargetFor(ctx context.Context, userID string) notificationTarget {
+	profile, err := s.getProfile(ctx, userID)
+	if err != nil {
+		s.logger.Printf("Error resolving notification target for %s: %v", userID, err)
+		return notificationTarget{lineID: userID}
+	}
+	switch {
+	case profile.DisabledAt != nil:
+		return notificationTarget{disabled: true}
+	case profile.LineUserID != "":
+		return notificationTarget{lineID: profile.LineUserID}
+	case profile.Provider == "google" && profile.Email != "":
+		return notificationTarget{email: profile.Email}
+	}
+	return notificationTarget{lineID: userID}
+}
+
+// setLineUserID は uid のプロフィールに LINE の送信先を記録する
+func (s *Server) setLineUserID(ctx context.Context, uid, lineUserID string, now time.Time) error {
+	profile, err := s.getProfile(ctx, uid)
+	if err != nil {
+		return err
+	}
+	if profile.CreatedAt.IsZero() {
+		profile.CreatedAt = now
+	}
+	profile.LineUserID = lineUserID
+	profile.UpdatedAt = now
+	return s.userRepo.SaveProfile(ctx, profile)
+}
+
+// verifyIDToken は Firebase の ID トークンを検証して UID を返す
+func (s *Server) verifyIDToken(ctx context.Context, idToken string) (string, error) {
+	client, err := s.firebaseApp.Auth(ctx)
+	if err != nil {
+		return "", err
+	}
+	token, err := client.VerifyIDToken(ctx, idToken)
+	if err != nil {
+		return "", err
+	}
+	return token.UID, nil
+}
+
+// handleLinkLine は ID トークンのアカウントに LINE をつなぐ
+func (s *Server) handleLinkLine(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req linkLineRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid JSON body: %v", err))
+		return
+	}
+	if err := req.Validate(); err != nil {
+		writeValidationError(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	uid, err := s.verifyIDToken(ctx, req.IDToken)
+	if err != nil {
+		writeProblem(w, r, http.StatusUnauthorized, "Invalid ID token")
+		return
+	}
+	if uid == req.LineUserID {
+		writeProblem(w, r, http.StatusConflict, "This account already signs in with LINE")
+		return
+	}
+
+	// ここでLINEアクセストークンの検証を行う (今回はモック)
+
+	// LINE 側に既に本があれば、つなぐのではなくまとめる必要がある
+	books, err := s.listBooks(ctx, req.LineUserID)
+	if err != nil {
+		writeServerError(w, r, err, "Failed to check existing LINE account")
+		return
+	}
+	if len(books) > 0 {
+		writeProblem(w, r, http.StatusConflict, "This LINE account already has books; merge the accounts instead")
+		return
+	}
+
+	now := time.Now()
+	if _, err := s.firestoreClient.Collection("lineLinks").Doc(req.LineUserID).Set(ctx, LineLink{UserID: uid, LinkedAt: now}); err != nil {
+		writeServerError(w, r, err, "Failed to link LINE account")
+		return
+	}
+	if err := s.setLineUserID(ctx, uid, req.LineUserID, now); err != nil {
+		writeServerError(w, r, err, "Failed to save profile")
+		return
+	}
+	s.logger.Printf("LINE account linked to %s", uid)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"userId": uid, "lineUserId": req.LineUserID})
+}
+
+// handleMergeAccounts は secondaryIdToken のアカウントの本などを idToken のアカウントに移し、
+// secondary のログイン方法を primary につなぎ直して secondary を削除する
+func (s *Server) handleMergeAccounts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req mergeAccountsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid JSON body: %v", err))
+		return
+	}
+	if err := req.Validate(); err != nil {
+		writeValidationError(w, r, err)
+		return
+	}
+
+	// 呼び出し元が切断しても移行を途中で打ち切らない
+	ctx := context.WithoutCancel(r.Context())
+
+	// 両方のアカウントにログインできることを確かめる
+	primary, err := s.verifyIDToken(ctx, req.IDToken)
+	if err != nil {
+		writeProblem(w, r, http.StatusUnauthorized, "Invalid ID token")
+		return
+	}
+	secondary, err := s.verifyIDToken(ctx, req.SecondaryIDToken)
+	if err != nil {
+		writeProblem(w, r, http.StatusUnauthorized, "Invalid secondary ID token")
+		return
+	}
+	if primary == secondary {
+		writeProblem(w, r, http.StatusBadRequest, "Both tokens belong to the same account")
+		return
+	}
+
+	client, err := s.firebaseApp.Auth(ctx)
+	if err != nil {
+		writeServerError(w, r, err, "Failed to initialize authentication")
+		return
+	}
+	secondaryUser, err := client.GetUser(ctx, secondary)
+	if err != nil {
+		writeServerError(w, r, err, "Failed to fetch secondary account")
+		return
+	}
+
+	moved, err := s.moveUserData(ctx, secondary, primary)
+	if err != nil {
+		writeServerError(w, r, err, "Failed to move data")
+		return
+	}
+
+	// ログイン方法は同時に2つのアカウントにはつなげないので、先に secondary を消す
+	if err := client.DeleteUser(ctx, secondary); err != nil {
+		writeServerError(w, r, err, "Failed to delete secondary account")
+		return
+	}
+
+	// パスワードは移せないので、フロントエンドでつなぎ直してもらう
+	relink := []string{}
+	for _, p := range secondaryUser.ProviderUserInfo {
+		if p.ProviderID == "password" {
+			relink = append(relink, p.ProviderID)
+			continue
+		}
+		_, err := client.UpdateUser(ctx, primary, (&auth.UserToUpdate{}).ProviderToLink(&auth.UserProvider{
+			UID:         p.UID,
+			ProviderID:  p.ProviderID,
+			Email:       p.Email,
+			DisplayName: p.DisplayName,
+			PhotoURL:    p.PhotoURL,
+		}))
+		if err != nil {
+			s.logger.Printf("Error linking %s to %s: %v", p.ProviderID, primary, err)
+			relink = append(relink, p.ProviderID)
+		}
+	}
+
+	// LINE でできたアカウント (ログイン方法を持たないカスタムトークンのユーザー) なら LINE をつなぐ
+	if len(secondaryUser.ProviderUserInfo) == 0 {
+		now := time.Now()
+		if _, err := s.firestoreClient.Collection("lineLinks").Doc(secondary).Set(ctx, LineLink{UserID: primary, LinkedAt: now}); err != nil {
+			s.logger.Printf("Error linking LINE %s to %s: %v", secondary, primary, err)
+		}
+		if err := s.setLineUserID(ctx, primary, secondary, now); err != nil {
+			s.logger.Printf("Error saving LINE recipient for %s: %v", primary, err)
+		}
+	}
+
+	s.invalidateStats(ctx, primary)
+	s.logger.Printf("Account %s merged into %s (%d documents moved)", secondary, primary, moved)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"userId": primary,
+		"moved":  moved,
+		"relink": relink,
+	})
+}
+
+// moveUserData は mergedCollections の from のドキュメントを to に付け替え、ポイントの合計を足し合わせる。
+// 付け替えた件数を返す
+func (s *Server) moveUserData(ctx context.Context, from, to string) (int, error) {
+	bw := s.firestoreClient.BulkWriter(ctx)
+	var jobs []*firestore.BulkWriterJob
+	staleKeys := []string{store.BookListKey(from), store.BookListKey(to)} // 付け替えた本のキャッシュ
+
+	for _, collection := range mergedCollections {
+		iter := s.firestoreClient.Collection(collection).Where("userId", "==", from).Documents(ctx)
+		for {
+			doc, err := iter.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				iter.Stop()
+				bw.End()
+				return 0, fmt.Errorf("error listing %s: %w", collection, err)
+			}
+			job, err := bw.Update(doc.Ref, []firestore.Update{{Path: "userId", Value: to}})
+			if err != nil {
+				iter.Stop()
+				bw.End()
+				return 0, err
+			}
+			jobs = append(jobs, job)
+			if collection == "books" {
+				staleKeys = append(staleKeys, store.BookKey(doc.Ref.ID))
+			}
+		}
+		iter.Stop()
+	}
+	bw.End()
+	if err := s.cache.Delete(ctx, staleKeys...); err != nil {
+		s.logger.Printf("Error invalidating cached books of %s and %s: %v", from, to, err)
+	}
+
+	moved := 0
+	for _, job := range jobs {
+		if _, err := job.Results(); err != nil {
+			s.logger.Printf("Error moving document to %s: %v", to, err)
+			continue
+		}
+		moved++
+	}
+	// 本を直接書き換えたので、両方の本棚のバージョンを進めて一覧のキャッシュを捨てさせる
+	if err := s.shelfVersions.Bump(ctx, from, to); err != nil {
+		s.logger.Printf("Error bumping shelf versions of %s and %s: %v", from, to, err)
+	}
+
+	total, err := s.userPointTotal(ctx, from)
+	if err != nil {
+		return moved, err
+	}
+	if total != 0 {
+		if _, err := s.firestoreClient.Collection("userPoints").Doc(to).Set(ctx, map[string]interface{}{
+			"userId": to,
+			"total":  firestore.Increment(total),
+		}, firestore.MergeAll); err != nil {
+			return moved, err
+		}
+	}
+	if _, err := s.firestoreClient.Collection("userPoints").Doc(from).Delete(ctx); err != nil {
+		s.logger.Printf("Error deleting points of %s: %v", from, err)
+	}
+	return moved, nil
+}
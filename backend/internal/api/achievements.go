@@ -0,0 +1,250 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/google/uuid"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"tundoku-killer/backend/internal/cron"
+)
+
+// 実績 (バッジ)。本の読了イベントを購読して判定し、解除したらLINEで祝う。
+// 「30日間煽られなかった」のように時間の経過で満たすものは、毎日の cron でも判定する
+
+// achievementsLease は実績の一括判定の二重実行を防ぐロックの名前
+const achievementsLease = "achievements"
+
+// Achievement は実績の定義
+type Achievement struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// UnlockedAchievement は解除済みの実績。userAchievements/{userId}_{achievementId} に保存する
+type UnlockedAchievement struct {
+	UserID        string    `json:"userId" firestore:"userId"`
+	AchievementID string    `json:"achievementId" firestore:"achievementId"`
+	UnlockedAt    time.Time `json:"unlockedAt" firestore:"unlockedAt"`
+}
+
+// achievementProgress は実績の判定に使うユーザーの状況
+type achievementProgress struct {
+	Total, Completed, Unread int
+	FirstCreated             *time.Time // 最初に本を登録した日時
+	LastCompleted            *time.Time // 最後に本を読み終えた日時
+	LastInsult               *time.Time // 最後に煽られた日時
+	Now                      time.Time
+}
+
+// achievementRules は実績の定義と解除の条件。ID は保存に使うので変えない
+var achievementRules = []struct {
+	Achievement
+	unlocked func(p achievementProgress) bool
+}{
+	{
+		Achievement{ID: "first-completion", Name: "はじめの一冊", Description: "本を1冊読み終えた"},
+		func(p achievementProgress) bool { return p.Completed >= 1 },
+	},
+	{
+		Achievement{ID: "ten-books", Name: "十冊斬り", Description: "本を10冊読み終えた"},
+		func(p achievementProgress) bool { return p.Completed >= 10 },
+	},
+	{
+		Achievement{ID: "streak-30", Name: "30日間の平穏", Description: "30日間一度も煽られなかった"},
+		func(p achievementProgress) bool {
+			since := p.Now.AddDate(0, 0, -30)
+			return p.FirstCreated != nil && !p.FirstCreated.After(since) &&
+				(p.LastInsult == nil || p.LastInsult.Before(since))
+		},
+	},
+	{
+		Achievement{ID: "zero-tsundoku-week", Name: "積読ゼロの一週間", Description: "未読の本が1冊もない状態を7日間保った"},
+		func(p achievementProgress) bool {
+			return p.Total > 0 && p.Unread == 0 && p.LastCompleted != nil &&
+				!p.LastCompleted.After(p.Now.AddDate(0, 0, -7))
+		},
+	},
+}
+
+// AchievementStatus は実績の一覧の1行
+type AchievementStatus struct {
+	Achievement
+	Unlocked   bool       `json:"unlocked"`
+	UnlockedAt *time.Time `json:"unlockedAt,omitempty"`
+}
+
+// handleAchievements は ?userId= のユーザーの、すべての実績と解除状況を返す
+func (s *Server) handleAchievements(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	userID := r.URL.Query().Get("userId")
+	if userID == "" {
+		writeProblem(w, r, http.StatusBadRequest, "userId query parameter is required")
+		return
+	}
+
+	docs, err := s.firestoreClient.Collection("userAchievements").Where("userId", "==", userID).Documents(r.Context()).GetAll()
+	if err != nil {
+		writeServerError(w, r, err, "Failed to retrieve achievements")
+		return
+	}
+	unlocked := make(map[string]time.Time, len(docs))
+	for _, doc := range docs {
+		var a UnlockedAchievement
+		if err := doc.DataTo(&a); err != nil {
+			s.logger.Printf("Error parsing achievement %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		unlocked[a.AchievementID] = a.UnlockedAt
+	}
+
+	result := make([]AchievementStatus, len(achievementRules))
+	for i, rule := range achievementRules {
+		result[i] = AchievementStatus{Achievement: rule.Achievement}
+		if at, ok := unlocked[rule.ID]; ok {
+			result[i].Unlocked = true
+			result[i].UnlockedAt = &at
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// evaluateAchievements は userID の状況を調べ、新たに条件を満たした実績を解除して AchievementUnlocked を発行する
+func (s *Server) evaluateAchievements(ctx context.Context, userID string, now time.Time) error {
+	progress, err := s.loadAchievementProgress(ctx, userID, now)
+	if err != nil {
+		return err
+	}
+
+	for _, rule := range achievementRules {
+		if !rule.unlocked(progress) {
+			continue
+		}
+		record := UnlockedAchievement{UserID: userID, AchievementID: rule.ID, UnlockedAt: now}
+		// Create は既にあれば失敗するので、同じ実績を二度祝わない
+		_, err := s.firestoreClient.Collection("userAchievements").Doc(userID+"_"+rule.ID).Create(ctx, record)
+		if status.Code(err) == codes.AlreadyExists {
+			continue
+		}
+		if err != nil {
+			s.logger.Printf("Error unlocking achievement %s for %s: %v", rule.ID, userID, err)
+			continue
+		}
+		s.logger.Printf("Achievement %s unlocked for %s", rule.ID, userID)
+		eventBus.Publish(ctx, AchievementUnlocked{UserID: userID, Achievement: rule.Achievement, UnlockedAt: now})
+	}
+	return nil
+}
+
+func (s *Server) loadAchievementProgress(ctx context.Context, userID string, now time.Time) (achievementProgress, error) {
+	p := achievementProgress{Now: now}
+
+	books, err := s.listBooks(ctx, userID)
+	if err != nil {
+		return p, err
+	}
+	for _, book := range books {
+		if book.OnWishlist() {
+			continue
+		}
+		p.Total++
+		if book.CreatedAt != nil && (p.FirstCreated == nil || book.CreatedAt.Before(*p.FirstCreated)) {
+			p.FirstCreated = book.CreatedAt
+		}
+		if book.Status != "completed" {
+			p.Unread++
+			continue
+		}
+		p.Completed++
+		if book.CompletedAt != nil && (p.LastCompleted == nil || book.CompletedAt.After(*p.LastCompleted)) {
+			p.LastCompleted = book.CompletedAt
+		}
+	}
+
+	iter := s.firestoreClient.Collection("insults").
+		Where("userId", "==", userID).
+		OrderBy("sentAt", firestore.Desc).
+		Limit(1).
+		Documents(ctx)
+	defer iter.Stop()
+	doc, err := iter.Next()
+	if err != nil && err != iterator.Done {
+		return p, err
+	}
+	if err == nil {
+		var rec InsultRecord
+		if err := doc.DataTo(&rec); err == nil {
+			p.LastInsult = &rec.SentAt
+		}
+	}
+	return p, nil
+}
+
+// handleAchievementsCron は全ユーザーの実績を判定する。時間の経過で満たす実績のために1日1回呼ぶ
+func (s *Server) handleAchievementsCron(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodGet {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	ctx := context.WithoutCancel(r.Context())
+
+	if !s.authorizeCron(r) {
+		writeProblem(w, r, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	runID := uuid.NewString()
+	if err := s.cron.AcquireLease(ctx, achievementsLease, runID, cron.LeaseTTL); err != nil {
+		if errors.Is(err, cron.ErrLeaseHeld) {
+			writeProblem(w, r, http.StatusConflict, "Another achievement evaluation is already running")
+			return
+		}
+		writeServerError(w, r, err, "Failed to acquire lock")
+		return
+	}
+	defer s.cron.ReleaseLease(ctx, achievementsLease, runID)
+
+	userIDs, err := s.listUserIDs(ctx)
+	if err != nil {
+		writeServerError(w, r, err, "Failed to list users")
+		return
+	}
+
+	now := time.Now()
+	deadline := now.Add(cron.TimeBudget)
+	evaluated, failed := 0, 0
+	for _, userID := range userIDs {
+		if time.Now().After(deadline) {
+			break
+		}
+		if err := s.evaluateAchievements(ctx, userID, now); err != nil {
+			s.logger.Printf("Error evaluating achievements for %s: %v", userID, err)
+			failed++
+		}
+		evaluated++
+	}
+
+	s.logger.Printf("Achievements evaluated for %d/%d users (%d failed)", evaluated, len(userIDs), failed)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"users":     len(userIDs),
+		"evaluated": evaluated,
+		"failed":    failed,
+		"done":      evaluated == len(userIDs),
+	})
+}
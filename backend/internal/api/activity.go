@@ -0,0 +1,253 @@
+package api
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/firestore"
+
+	"tundoku-killer/backend/internal/cron"
+)
+
+// 最近の活動 (本の登録・読了・煽られたこと) の Atom / RSS フィード。トークン付きの URL を Slack の RSS アプリや
+// フィードリーダー、ブログの「いま読んでいる本」に登録して使う。トークンは activityFeeds/{token} に保存する (feedtokens.go)
+
+// maxActivityEntries はフィードに載せる活動の数
+const maxActivityEntries = 50
+
+// 活動の種類
+const (
+	activityRegistered = "registered"
+	activityCompleted  = "completed"
+	activityInsulted   = "insulted"
+)
+
+// Activity はフィードに載せる1件の活動
+type Activity struct {
+	ID      string // フィードの中で一意な ID
+	Kind    string // activityRegistered など
+	Title   string
+	Content string
+	At      time.Time
+}
+
+// handleActivityFeedToken はフィードのトークンの取得 (GET ?userId=)・発行 (POST)・取り消し (DELETE ?userId=) を行う
+func (s *Server) handleActivityFeedToken(w http.ResponseWriter, r *http.Request) {
+	s.handleFeedToken(w, r, "activityFeeds", "/activity.atom")
+}
+
+// recentActivity は userID の最近の活動を新しい順に最大 maxActivityEntries 件返す
+func (s *Server) recentActivity(ctx context.Context, userID string) ([]Activity, error) {
+	books, err := s.listBooks(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	titles := make(map[string]string, len(books))
+	var activities []Activity
+	for _, book := range books {
+		titles[book.BookID] = book.Title
+		if book.CreatedAt != nil {
+			content := fmt.Sprintf("『%s』(%s) を積みました。期限は%sです。", book.Title, book.Author, book.Deadline.In(cron.Location).Format("2006年1月2日"))
+			if book.OnWishlist() {
+				content = fmt.Sprintf("『%s』(%s) をウィッシュリストに入れました。", book.Title, book.Author)
+			}
+			activities = append(activities, Activity{
+				ID:      "registered:" + book.BookID,
+				Kind:    activityRegistered,
+				Title:   fmt.Sprintf("『%s』を登録", book.Title),
+				Content: content,
+				At:      *book.CreatedAt,
+			})
+		}
+		if book.Status == "completed" && book.CompletedAt != nil {
+			activities = append(activities, Activity{
+				ID:      "completed:" + book.BookID,
+				Kind:    activityCompleted,
+				Title:   fmt.Sprintf("『%s』を読了", book.Title),
+				Content: fmt.Sprintf("『%s』(%s) を読み終えました。", book.Title, book.Author),
+				At:      *book.CompletedAt,
+			})
+		}
+	}
+
+	docs, err := s.firestoreClient.Collection("insults").
+		Where("userId", "==", userID).
+		OrderBy("sentAt", firestore.Desc).
+		Limit(maxActivityEntries).
+		Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("error fetching insults: %w", err)
+	}
+	for _, doc := range docs {
+		var rec InsultRecord
+		if err := doc.DataTo(&rec); err != nil {
+			s.logger.Printf("Error parsing insult %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		title := "期限切れで煽られた"
+		if t, ok := titles[rec.BookID]; ok {
+			title = fmt.Sprintf("『%s』の期限切れで煽られた", t)
+		}
+		activities = append(activities, Activity{
+			ID:      "insulted:" + doc.Ref.ID,
+			Kind:    activityInsulted,
+			Title:   title,
+			Content: rec.Message,
+			At:      rec.SentAt,
+		})
+	}
+
+	sort.SliceStable(activities, func(i, j int) bool { return activities[i].At.After(activities[j].At) })
+	if len(activities) > maxActivityEntries {
+		activities = activities[:maxActivityEntries]
+	}
+	return activities, nil
+}
+
+// atomFeed は Atom (RFC 4287) のフィード
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  atomAuthor  `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	ID       string       `xml:"id"`
+	Title    string       `xml:"title"`
+	Updated  string       `xml:"updated"`
+	Category atomCategory `xml:"category"`
+	Content  string       `xml:"content"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+// rssFeed は RSS 2.0 のフィード
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	GUID        rssGUID `xml:"guid"`
+	Title       string  `xml:"title"`
+	Category    string  `xml:"category"`
+	Description string  `xml:"description"`
+	PubDate     string  `xml:"pubDate"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// handleActivityAtom はトークンの持ち主の最近の活動を Atom で返す (GET ?token=)
+func (s *Server) handleActivityAtom(w http.ResponseWriter, r *http.Request) {
+	s.serveActivity(w, r, "application/atom+xml; charset=utf-8", func(name, userID string, activities []Activity) interface{} {
+		feed := atomFeed{
+			ID:      "urn:tundoku-killer:activity:" + userID,
+			Title:   name + "の積読の記録",
+			Updated: time.Now().UTC().Format(time.RFC3339),
+			Author:  atomAuthor{Name: name},
+			Entries: make([]atomEntry, 0, len(activities)),
+		}
+		if len(activities) > 0 {
+			feed.Updated = activities[0].At.UTC().Format(time.RFC3339)
+		}
+		for _, a := range activities {
+			feed.Entries = append(feed.Entries, atomEntry{
+				ID:       "urn:tundoku-killer:activity:" + userID + ":" + a.ID,
+				Title:    a.Title,
+				Updated:  a.At.UTC().Format(time.RFC3339),
+				Category: atomCategory{Term: a.Kind},
+				Content:  a.Content,
+			})
+		}
+		return feed
+	})
+}
+
+// handleActivityRSS はトークンの持ち主の最近の活動を RSS 2.0 で返す (GET ?token=)
+func (s *Server) handleActivityRSS(w http.ResponseWriter, r *http.Request) {
+	s.serveActivity(w, r, "application/rss+xml; charset=utf-8", func(name, userID string, activities []Activity) interface{} {
+		feed := rssFeed{
+			Version: "2.0",
+			Channel: rssChannel{
+				Title:       name + "の積読の記録",
+				Link:        s.cfg.PublicBaseURL,
+				Description: "本の登録・読了と、期限切れで煽られた記録",
+				Items:       make([]rssItem, 0, len(activities)),
+			},
+		}
+		for _, a := range activities {
+			feed.Channel.Items = append(feed.Channel.Items, rssItem{
+				GUID:        rssGUID{Value: "urn:tundoku-killer:activity:" + userID + ":" + a.ID},
+				Title:       a.Title,
+				Category:    a.Kind,
+				Description: a.Content,
+				PubDate:     a.At.UTC().Format(time.RFC1123Z),
+			})
+		}
+		return feed
+	})
+}
+
+// serveActivity はトークンの持ち主の最近の活動を render で XML にして返す。フィードリーダーが取りに来るので、ログインは要らない
+func (s *Server) serveActivity(w http.ResponseWriter, r *http.Request, contentType string, render func(name, userID string, activities []Activity) interface{}) {
+	if r.Method != http.MethodGet {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		writeProblem(w, r, http.StatusBadRequest, "token query parameter is required")
+		return
+	}
+	ctx := r.Context()
+	userID, err := s.feedOwner(ctx, "activityFeeds", token)
+	if errors.Is(err, errFeedNotFound) {
+		writeProblem(w, r, http.StatusNotFound, "Activity feed not found")
+		return
+	}
+	if err != nil {
+		writeServerError(w, r, err, "Failed to retrieve activity feed")
+		return
+	}
+
+	activities, err := s.recentActivity(ctx, userID)
+	if err != nil {
+		writeServerError(w, r, err, "Failed to retrieve activity")
+		return
+	}
+	settings, err := s.getSettings(ctx, userID)
+	if err != nil {
+		s.logger.Printf("Error fetching settings for %s: %v", userID, err)
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Write([]byte(xml.Header))
+	if err := xml.NewEncoder(w).Encode(render(settings.Name(), userID, activities)); err != nil {
+		s.logger.Printf("Error encoding activity feed for %s: %v", userID, err)
+	}
+}
@@ -0,0 +1,357 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"firebase.google.com/go/v4/auth"
+	pubsub "google.golang.org/api/pubsub/v1"
+
+	"tundoku-killer/backend/internal/secrets"
+	"tundoku-killer/backend/internal/validation"
+)
+
+// 運用者向けの API (ADMIN_TOKEN が必要)。cmd/tundokuctl から呼ぶ
+
+const (
+	defaultRedriveMax = 100
+	maxRedriveMax     = 1000
+)
+
+// AdminUser は運用者向けのユーザーの概要
+type AdminUser struct {
+	UserID      string `json:"userId"`
+	DisplayName string `json:"displayName,omitempty"`
+	Disabled    bool   `json:"disabled"`
+	Books       int    `json:"books"`
+	Unread      int    `json:"unread"` // 未読・読書中・煽られ中 (読了していない本)
+	Completed   int    `json:"completed"`
+	// LastActivity は本の登録・読了とプロフィールの更新のうち最も新しい日時
+	LastActivity *time.Time `json:"lastActivity,omitempty"`
+}
+
+// handleAdminUsers はプロフィールか本のあるユーザーを、本の数と最終活動日時つきで最近活動した順に返す
+func (s *Server) handleAdminUsers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	users, err := s.userOverview(r.Context())
+	if err != nil {
+		writeServerError(w, r, err, "Failed to list users")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"users": users})
+}
+
+// userOverview は本とプロフィールを全件読んでユーザーごとに集計する
+func (s *Server) userOverview(ctx context.Context) ([]AdminUser, error) {
+	byID := make(map[string]*AdminUser)
+	user := func(userID string) *AdminUser {
+		u, ok := byID[userID]
+		if !ok {
+			u = &AdminUser{UserID: userID}
+			byID[userID] = u
+		}
+		return u
+	}
+	touch := func(u *AdminUser, t time.Time) {
+		if !t.IsZero() && (u.LastActivity == nil || t.After(*u.LastActivity)) {
+			u.LastActivity = &t
+		}
+	}
+
+	userIDs, err := s.bookRepo.ListUserIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for from := 0; from < len(userIDs); from += usersPerBookQuery {
+		books, err := s.bookRepo.ListByUsers(ctx, userIDs[from:min(from+usersPerBookQuery, len(userIDs))])
+		if err != nil {
+			return nil, err
+		}
+		for _, book := range books {
+			if book.UserID == "" {
+				continue // 壊れた本は整合性チェックで報告する
+			}
+			u := user(book.UserID)
+			u.Books++
+			if book.Status == "completed" {
+				u.Completed++
+			} else {
+				u.Unread++
+			}
+			if book.CreatedAt != nil {
+				touch(u, *book.CreatedAt)
+			}
+			if book.CompletedAt != nil {
+				touch(u, *book.CompletedAt)
+			}
+		}
+	}
+
+	profiles, err := s.userRepo.ListProfiles(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, profile := range profiles {
+		u := user(profile.UserID)
+		u.DisplayName = profile.DisplayName
+		u.Disabled = profile.DisabledAt != nil
+		touch(u, profile.UpdatedAt)
+	}
+
+	users := make([]AdminUser, 0, len(byID))
+	for _, u := range byID {
+		users = append(users, *u)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		a, b := users[i].LastActivity, users[j].LastActivity
+		if (a == nil) != (b == nil) {
+			return a != nil
+		}
+		if a != nil && !a.Equal(*b) {
+			return a.After(*b)
+		}
+		return users[i].UserID < users[j].UserID
+	})
+	return users, nil
+}
+
+const (
+	defaultNotificationFailuresLimit = 50
+	maxNotificationFailuresLimit     = 500
+)
+
+// handleNotificationFailures は ?userId= のユーザーに送れなかった通知を新しい順に最大 ?limit= 件返す
+func (s *Server) handleNotificationFailures(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	userID := r.URL.Query().Get("userId")
+	if userID == "" {
+		writeProblem(w, r, http.StatusBadRequest, "userId query parameter is required")
+		return
+	}
+	limit := defaultNotificationFailuresLimit
+	var v validation.Validator
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		v.Check(err == nil, "limit", "must be an integer")
+		v.Range("limit", n, 1, maxNotificationFailuresLimit)
+		limit = n
+	}
+	if err := v.Err(); err != nil {
+		writeValidationError(w, r, err)
+		return
+	}
+
+	docs, err := s.firestoreClient.Collection("notificationFailures").
+		Where("userId", "==", userID).
+		OrderBy("at", firestore.Desc).
+		Limit(limit).
+		Documents(r.Context()).GetAll()
+	if err != nil {
+		writeServerError(w, r, err, "Failed to list notification failures")
+		return
+	}
+	failures := make([]NotificationFailure, 0, len(docs))
+	for _, doc := range docs {
+		var f NotificationFailure
+		if err := doc.DataTo(&f); err != nil {
+			s.logger.Printf("Error parsing notification failure %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		failures = append(failures, f)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"failures": failures})
+}
+
+// handleDisableUser はアカウントを停止 (POST) ・再開 (DELETE ?userId=) する。
+// 停止すると Firebase Auth のユーザーも無効にしてログインできなくし、LINE・メールの通知も止める。本などのデータは消さない
+func (s *Server) handleDisableUser(w http.ResponseWriter, r *http.Request) {
+	var userID, reason string
+	switch r.Method {
+	case http.MethodPost:
+		var req disableUserRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid JSON body: %v", err))
+			return
+		}
+		if err := req.Validate(); err != nil {
+			writeValidationError(w, r, err)
+			return
+		}
+		userID, reason = req.UserID, req.Reason
+	case http.MethodDelete:
+		userID = r.URL.Query().Get("userId")
+		if userID == "" {
+			writeProblem(w, r, http.StatusBadRequest, "userId query parameter is required")
+			return
+		}
+	default:
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	disable := r.Method == http.MethodPost
+
+	ctx := context.WithoutCancel(r.Context())
+	profile, err := s.getProfile(ctx, userID)
+	if err != nil {
+		writeServerError(w, r, err, "Failed to retrieve profile")
+		return
+	}
+	now := time.Now()
+	if disable {
+		profile.DisabledAt = &now
+		profile.DisabledReason = reason
+	} else {
+		profile.DisabledAt = nil
+		profile.DisabledReason = ""
+	}
+	profile.UpdatedAt = now
+	if profile.CreatedAt.IsZero() {
+		profile.CreatedAt = now
+	}
+
+	// 先に Auth を無効にする。プロフィールの保存に失敗しても、ログインだけは止まっている状態にする
+	client, err := s.firebaseApp.Auth(ctx)
+	if err != nil {
+		writeServerError(w, r, err, "Failed to initialize Firebase Auth")
+		return
+	}
+	if _, err := client.UpdateUser(ctx, userID, (&auth.UserToUpdate{}).Disabled(disable)); err != nil && !auth.IsUserNotFound(err) {
+		// LINE グループのIDなど、Auth のユーザーが無いこともある
+		writeServerError(w, r, err, "Failed to update Firebase Auth user")
+		return
+	}
+	if err := s.userRepo.SaveProfile(ctx, profile); err != nil {
+		writeServerError(w, r, err, "Failed to save profile")
+		return
+	}
+
+	if disable {
+		s.logger.Printf("User %s disabled: %s", userID, reason)
+	} else {
+		s.logger.Printf("User %s re-enabled", userID)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(profile)
+}
+
+// handleRedriveDeadLetters はデッドレタートピックに溜まった期限切れのイベントを最大 ?max= 件取り出し、
+// 元のトピックに発行し直す。発行し直せたものだけ確認応答 (ack) する
+func (s *Server) handleRedriveDeadLetters(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	subscription := s.cfg.PubSub.DeadLetterSubscription
+	if s.pubsubService == nil || subscription == "" {
+		writeProblem(w, r, http.StatusNotImplemented, "PUBSUB_TOPIC and PUBSUB_DEAD_LETTER_SUBSCRIPTION are not configured")
+		return
+	}
+
+	limit := defaultRedriveMax
+	var v validation.Validator
+	if raw := r.URL.Query().Get("max"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		v.Check(err == nil, "max", "must be an integer")
+		v.Range("max", n, 1, maxRedriveMax)
+		limit = n
+	}
+	if err := v.Err(); err != nil {
+		writeValidationError(w, r, err)
+		return
+	}
+
+	ctx := context.WithoutCancel(r.Context())
+	resp, err := s.pubsubService.Projects.Subscriptions.Pull(subscription, &pubsub.PullRequest{
+		MaxMessages:       int64(limit),
+		ReturnImmediately: true,
+	}).Context(ctx).Do()
+	if err != nil {
+		writeServerError(w, r, err, "Failed to pull dead letters")
+		return
+	}
+
+	var acks []string
+	failed := 0
+	for _, received := range resp.ReceivedMessages {
+		msg := received.Message
+		_, err := s.pubsubService.Projects.Topics.Publish(s.pubsubTopic, &pubsub.PublishRequest{
+			Messages: []*pubsub.PubsubMessage{{Data: msg.Data, Attributes: msg.Attributes}},
+		}).Context(ctx).Do()
+		if err != nil {
+			// ack しなければ確認応答の期限が過ぎたあとにデッドレターに戻る
+			s.logger.Printf("Error redriving dead letter %s: %v", msg.MessageId, err)
+			failed++
+			continue
+		}
+		acks = append(acks, received.AckId)
+	}
+	if len(acks) > 0 {
+		_, err := s.pubsubService.Projects.Subscriptions.Acknowledge(subscription, &pubsub.AcknowledgeRequest{AckIds: acks}).Context(ctx).Do()
+		if err != nil {
+			// 発行し直した分はもう一度流れることになるが、煽りは周期ごとに1回なので二重には送らない
+			s.logger.Printf("Error acknowledging %d redriven dead letters: %v", len(acks), err)
+		}
+	}
+
+	s.logger.Printf("Redrove %d dead letters (%d failed)", len(acks), failed)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"pulled":   len(resp.ReceivedMessages),
+		"redriven": len(acks),
+		"failed":   failed,
+	})
+}
+
+// handleRotateCronSecret は CRON_SECRET を新しいランダムな値に替え、その値を返す。
+// CRON_SECRET が "sm://name" のように Secret Manager の最新のバージョンを参照しているときだけ使える。
+// このインスタンスはすぐに、他のインスタンスは SECRETS_REFRESH_INTERVAL 以内に新しい値に切り替わる
+func (s *Server) handleRotateCronSecret(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		writeServerError(w, r, err, "Failed to generate cron secret")
+		return
+	}
+	secret := hex.EncodeToString(raw)
+
+	err := s.secrets.Rotate(context.WithoutCancel(r.Context()), "CRON_SECRET", secret)
+	if errors.Is(err, secrets.ErrNotRotatable) {
+		writeProblem(w, r, http.StatusConflict, "CRON_SECRET must reference the latest version of a Secret Manager secret (sm://name)")
+		return
+	}
+	if err != nil {
+		writeServerError(w, r, err, "Failed to rotate cron secret")
+		return
+	}
+
+	s.logger.Printf("CRON_SECRET rotated")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"cronSecret":       secret,
+		"propagatesWithin": s.cfg.Secrets.RefreshInterval.String(),
+	})
+}
@@ -0,0 +1,346 @@
+package api
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"tundoku-killer/backend/internal/isbn"
+	"tundoku-killer/backend/internal/store"
+	"tundoku-killer/backend/internal/validation"
+)
+
+// Amazon の注文履歴の CSV から本を買った記録を拾い、登録の候補として返す。保存はしない。
+// 候補はそのまま POST /v1/books に期限を足して送れるよう、書名・ISBN・価格・買った日・店を埋めておく。
+// 受け付けるのは「データのリクエスト」で届く Retail.OrderHistory.*.csv と、以前の注文履歴レポートの CSV。
+// 新しい形式には商品の分類が無いので、ASIN が ISBN-10 になっている紙の本と、商品名に「Kindle版」とある電子書籍だけを本とみなす
+
+const (
+	maxAmazonRows = 20000
+	// openBDBatchSize は openBD にまとめて問い合わせる ISBN の数
+	openBDBatchSize = 500
+)
+
+// amazonBookCategories は以前の注文履歴レポートの Category のうち本のもの
+var amazonBookCategories = map[string]bool{
+	"paperback": true, "hardcover": true, "mass market paperback": true, "kindle edition": true, "board book": true,
+	"comic": true, "単行本": true, "単行本（ソフトカバー）": true, "文庫": true, "新書": true, "コミック": true,
+	"大型本": true, "ムック": true, "kindle版": true, "雑誌": true,
+}
+
+// AmazonCandidate は注文履歴から見つけた、登録の候補の本
+type AmazonCandidate struct {
+	Title         string    `json:"title"`
+	Author        string    `json:"author,omitempty"` // openBD か国立国会図書館サーチで分かれば
+	ISBN          string    `json:"isbn,omitempty"`
+	ASIN          string    `json:"asin"`
+	Price         int       `json:"price,omitempty"` // 円。円以外で買ったものは 0
+	PurchasedAt   time.Time `json:"purchasedAt"`
+	PurchaseStore string    `json:"purchaseStore"`
+	Ebook         bool      `json:"ebook"`
+	Format        string    `json:"format"` // ebook なら store.FormatEbook、それ以外は store.FormatPaper
+	OrderID       string    `json:"orderId,omitempty"`
+}
+
+// AmazonOnShelf は候補のうち、もう本棚にある本
+type AmazonOnShelf struct {
+	AmazonCandidate
+	BookID string `json:"bookId"`
+}
+
+// AmazonImportResult は注文履歴の読み取りの結果
+type AmazonImportResult struct {
+	Candidates []AmazonCandidate `json:"candidates"` // 買った日の新しい順
+	OnShelf    []AmazonOnShelf   `json:"onShelf"`
+	TotalBooks int               `json:"totalBooks"` // 注文履歴にあった本の数 (本棚にある本も含む)
+	TotalPrice int               `json:"totalPrice"` // その合計金額 (円)
+	Orders     int               `json:"orders"`     // 読んだ注文の行の数
+}
+
+// handleAmazonImport は Amazon の注文履歴の CSV から本を買った記録を拾い、登録の候補を返す (POST ?userId=, text/csv)
+func (s *Server) handleAmazonImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	userID := r.URL.Query().Get("userId")
+	var v validation.Validator
+	v.Required("userId", userID)
+	v.MaxLength("userId", userID, maxIDLength)
+	if err := v.Err(); err != nil {
+		writeValidationError(w, r, err)
+		return
+	}
+
+	purchases, orders, err := parseAmazonOrders(r.Body)
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			writeProblem(w, r, http.StatusRequestEntityTooLarge, "CSV too large; split the order history by year")
+			return
+		}
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid Amazon order history: %v", err))
+		return
+	}
+
+	ctx := r.Context()
+	books, err := s.listBooks(ctx, userID)
+	if err != nil {
+		writeServerError(w, r, err, "Failed to retrieve books")
+		return
+	}
+	s.fillFromCatalog(ctx, purchases)
+
+	result := AmazonImportResult{Candidates: []AmazonCandidate{}, OnShelf: []AmazonOnShelf{}, Orders: orders}
+	for _, c := range purchases {
+		result.TotalBooks++
+		result.TotalPrice += c.Price
+		if book, ok := amazonOnShelf(books, c); ok {
+			result.OnShelf = append(result.OnShelf, AmazonOnShelf{AmazonCandidate: c, BookID: book.BookID})
+			continue
+		}
+		result.Candidates = append(result.Candidates, c)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// parseAmazonOrders は注文履歴の CSV から本を買った記録を拾い、同じ本は最初に買ったときの1件にまとめて新しい順に返す。
+// 読んだ注文の行の数も返す。キャンセルした注文は飛ばす
+func parseAmazonOrders(body io.Reader) ([]AmazonCandidate, int, error) {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, 0, errors.New("empty file")
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))] = i
+	}
+	column := func(names ...string) int {
+		for _, name := range names {
+			if i, ok := columns[name]; ok {
+				return i
+			}
+		}
+		return -1
+	}
+	var (
+		dateCol     = column("Order Date")
+		asinCol     = column("ASIN", "ASIN/ISBN")
+		titleCol    = column("Product Name", "Title")
+		categoryCol = column("Category")
+		priceCol    = column("Unit Price", "Purchase Price Per Unit")
+		currencyCol = column("Currency")
+		statusCol   = column("Order Status")
+		orderCol    = column("Order ID")
+	)
+	if dateCol < 0 || asinCol < 0 || titleCol < 0 {
+		return nil, 0, errors.New(`missing "Order Date", "ASIN" or "Product Name" column`)
+	}
+
+	byASIN := make(map[string]int)
+	var purchases []AmazonCandidate
+	orders := 0
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		orders++
+		if orders > maxAmazonRows {
+			return nil, 0, fmt.Errorf("more than %d rows", maxAmazonRows)
+		}
+		field := func(i int) string {
+			if i >= 0 && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		status := strings.ToLower(field(statusCol))
+		if strings.Contains(status, "cancel") || strings.Contains(status, "キャンセル") {
+			continue
+		}
+		asin := strings.ToUpper(field(asinCol))
+		title := field(titleCol)
+		code := ""
+		if isbn.Valid10(asin) {
+			code = asin
+		}
+		ebook := strings.Contains(title, "Kindle版") || strings.Contains(title, "Kindle Edition")
+		if categoryCol >= 0 {
+			category := strings.ToLower(field(categoryCol))
+			if !amazonBookCategories[category] {
+				continue
+			}
+			ebook = ebook || strings.Contains(category, "kindle")
+		} else if code == "" && !ebook {
+			continue
+		}
+		purchasedAt, ok := parseAmazonDate(field(dateCol))
+		if !ok || asin == "" || title == "" {
+			continue
+		}
+
+		c := AmazonCandidate{
+			Title:         truncateRunes(amazonTitle(title), maxTitleLength),
+			ISBN:          code,
+			ASIN:          asin,
+			PurchasedAt:   purchasedAt,
+			PurchaseStore: "Amazon",
+			Ebook:         ebook,
+			Format:        store.FormatPaper,
+			OrderID:       field(orderCol),
+		}
+		if ebook {
+			c.Format = store.FormatEbook
+		}
+		if currency := field(currencyCol); currency == "" || currency == "JPY" {
+			c.Price = parseYen(field(priceCol))
+		}
+		if i, ok := byASIN[asin]; ok {
+			if c.PurchasedAt.Before(purchases[i].PurchasedAt) {
+				purchases[i] = c
+			}
+			continue
+		}
+		byASIN[asin] = len(purchases)
+		purchases = append(purchases, c)
+	}
+	sort.SliceStable(purchases, func(i, j int) bool { return purchases[i].PurchasedAt.After(purchases[j].PurchasedAt) })
+	return purchases, orders, nil
+}
+
+// parseAmazonDate は注文日を読む。新しい形式は RFC 3339、以前のレポートは "01/02/06"
+func parseAmazonDate(s string) (time.Time, bool) {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05 MST", "01/02/06", "2006/01/02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// parseYen は "1,980" "￥1,980" "1980.0" のような金額を円にする。読めなければ 0
+func parseYen(s string) int {
+	s = strings.NewReplacer("￥", "", "¥", "", ",", "", "円", "").Replace(strings.TrimSpace(s))
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f < 0 || f > maxPrice {
+		return 0
+	}
+	return int(math.Round(f))
+}
+
+// amazonTitle は商品名から電子書籍の版の注記を除く
+func amazonTitle(name string) string {
+	for _, suffix := range []string{" Kindle版", " Kindle Edition", "Kindle版"} {
+		name = strings.TrimSpace(strings.TrimSuffix(name, suffix))
+	}
+	return name
+}
+
+// amazonOnShelf は c がもう本棚にあればその本を返す。ISBN か、レーベルや副題を除いた書名で比べる
+func amazonOnShelf(books []store.Book, c AmazonCandidate) (store.Book, bool) {
+	for _, book := range books {
+		if isbn.Equal(book.ISBN, c.ISBN) {
+			return book, true
+		}
+	}
+	return matchKindleTitle(books, c.Title)
+}
+
+// fillFromCatalog は ISBN の分かる候補の書名と著者を openBD で埋める。Amazon の商品名は宣伝文句が付いて長いことがあるため。
+// openBD に無かった本は、maxNDLLookups 冊まで国立国会図書館サーチで調べる。調べられなくても候補はそのまま返す
+func (s *Server) fillFromCatalog(ctx context.Context, candidates []AmazonCandidate) {
+	byISBN := make(map[string][]int)
+	var isbns []string
+	for i, c := range candidates {
+		if c.ISBN == "" {
+			continue
+		}
+		if _, ok := byISBN[c.ISBN]; !ok {
+			isbns = append(isbns, c.ISBN)
+		}
+		byISBN[c.ISBN] = append(byISBN[c.ISBN], i)
+	}
+
+	found := make(map[string]bool, len(isbns))
+	for start := 0; start < len(isbns); start += openBDBatchSize {
+		batch := isbns[start:min(start+openBDBatchSize, len(isbns))]
+		var results []*struct {
+			Summary struct {
+				Title  string `json:"title"`
+				Author string `json:"author"`
+			} `json:"summary"`
+		}
+		lookupCtx, cancel := context.WithTimeout(ctx, priceLookupTimeout)
+		err := getJSON(lookupCtx, "https://api.openbd.jp/v1/get?isbn="+url.QueryEscape(strings.Join(batch, ",")), &results)
+		cancel()
+		if err != nil {
+			s.logger.Printf("Error looking up %d ISBNs on openBD: %v", len(batch), err)
+			break
+		}
+		for i, result := range results {
+			if result == nil || i >= len(batch) {
+				continue
+			}
+			found[batch[i]] = true
+			for _, j := range byISBN[batch[i]] {
+				if title := strings.TrimSpace(result.Summary.Title); title != "" {
+					candidates[j].Title = truncateRunes(title, maxTitleLength)
+				}
+				candidates[j].Author = truncateRunes(openBDAuthor(result.Summary.Author), maxAuthorLength)
+			}
+		}
+	}
+
+	lookups := 0
+	for _, code := range isbns {
+		if found[code] {
+			continue
+		}
+		if lookups++; lookups > maxNDLLookups {
+			break
+		}
+		lookupCtx, cancel := context.WithTimeout(ctx, priceLookupTimeout)
+		record, ok, err := s.lookupNDL(lookupCtx, code)
+		cancel()
+		if err != nil {
+			s.logger.Printf("NDL lookup failed for %s: %v", code, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		for _, j := range byISBN[code] {
+			candidates[j].Title = record.Title
+			candidates[j].Author = record.Author
+		}
+	}
+}
+
+// openBDAuthor は openBD の著者 ("太宰治／著 山田太郎／解説") から最初の著者の名前を取り出す
+func openBDAuthor(author string) string {
+	name, _, _ := strings.Cut(author, "／")
+	return strings.TrimSpace(name)
+}
@@ -0,0 +1,129 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"tundoku-killer/backend/internal/config"
+)
+
+// Amazon Product Advertising API 5.0 で本の今の価格を調べる。ウィッシュリストの本の値下がりの見張り (pricewatch.go) が使う。
+// 紙の本の ASIN は ISBN-10 なので、ISBN からそのまま問い合わせられる。リクエストには AWS 署名バージョン4で署名する
+
+const (
+	amazonPAService = "ProductAdvertisingAPI"
+	amazonPATarget  = "com.amazon.paapi5.v1.ProductAdvertisingAPIv1.GetItems"
+	amazonPAPath    = "/paapi5/getitems"
+)
+
+// lookupAmazonPrice は asin の商品の今の価格 (円) を調べる。出品がなければ 0
+func lookupAmazonPrice(ctx context.Context, c config.AmazonPAConfig, asin string) (int, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"ItemIds":     []string{asin},
+		"ItemIdType":  "ASIN",
+		"PartnerTag":  c.PartnerTag,
+		"PartnerType": "Associates",
+		"Marketplace": "www." + strings.TrimPrefix(c.Host, "webservices."),
+		"Resources":   []string{"OffersV2.Listings.Price"},
+	})
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://"+c.Host+amazonPAPath, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("Content-Encoding", "amz-1.0")
+	req.Header.Set("X-Amz-Target", amazonPATarget)
+	signAmazonPA(req, body, c, time.Now())
+
+	resp, err := tracedHTTPClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("unexpected status %d from %s", resp.StatusCode, req.URL.Host)
+	}
+
+	// 見つからない商品は 200 で Errors (ItemNotAccessible など) だけが返る
+	var result struct {
+		ItemsResult struct {
+			Items []struct {
+				OffersV2 struct {
+					Listings []struct {
+						Price struct {
+							Money struct {
+								Amount   float64 `json:"Amount"`
+								Currency string  `json:"Currency"`
+							} `json:"Money"`
+						} `json:"Price"`
+					} `json:"Listings"`
+				} `json:"OffersV2"`
+			} `json:"Items"`
+		} `json:"ItemsResult"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, err
+	}
+	for _, item := range result.ItemsResult.Items {
+		for _, listing := range item.OffersV2.Listings {
+			if money := listing.Price.Money; money.Amount > 0 && money.Currency == "JPY" {
+				return int(math.Round(money.Amount)), nil
+			}
+		}
+	}
+	return 0, nil
+}
+
+// signAmazonPA は AWS 署名バージョン4で req に署名する。署名するヘッダーは content-encoding・host・x-amz-date・x-amz-target
+func signAmazonPA(req *http.Request, body []byte, c config.AmazonPAConfig, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	const signedHeaders = "content-encoding;host;x-amz-date;x-amz-target"
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"content-encoding:" + req.Header.Get("Content-Encoding"),
+		"host:" + req.URL.Host,
+		"x-amz-date:" + amzDate,
+		"x-amz-target:" + req.Header.Get("X-Amz-Target"),
+		"",
+		signedHeaders,
+		sha256Hex(body),
+	}, "\n")
+	scope := date + "/" + c.Region + "/" + amazonPAService + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonical))
+
+	key := []byte("AWS4" + c.SecretKey)
+	for _, part := range []string{date, c.Region, amazonPAService, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.AccessKey, scope, signedHeaders, signature))
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
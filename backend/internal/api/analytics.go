@@ -0,0 +1,119 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	bigquery "google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/option"
+
+	"tundoku-killer/backend/internal/analytics"
+	"tundoku-killer/backend/internal/events"
+	"tundoku-killer/backend/internal/store"
+)
+
+// analyticsCloseTimeout は終了時に溜まったプロダクトイベントの書き出しを待つ時間の上限
+const analyticsCloseTimeout = 10 * time.Second
+
+// initAnalytics は ANALYTICS_SINK に応じてプロダクトイベントの Writer を作る
+func (s *Server) initAnalytics(ctx context.Context) error {
+	var sink analytics.Sink
+	switch s.cfg.Analytics.Sink {
+	case "none":
+		return nil
+	case "log":
+		sink = analytics.LogSink{Logger: s.logger}
+	case "firestore":
+		sink = analytics.FirestoreSink{Client: s.firestoreClient, Collection: "analyticsEvents"}
+	case "bigquery":
+		parts := strings.SplitN(s.cfg.Analytics.BigQueryTable, ".", 3)
+		if len(parts) != 3 {
+			return errors.New("ANALYTICS_BIGQUERY_TABLE must be project.dataset.table")
+		}
+		svc, err := bigquery.NewService(ctx,
+			option.WithCredentialsJSON([]byte(s.cfg.Firebase.ServiceAccountKeyJSON)),
+			option.WithScopes(bigquery.BigqueryInsertdataScope))
+		if err != nil {
+			return fmt.Errorf("error creating BigQuery client: %w", err)
+		}
+		sink = analytics.BigQuerySink{Service: svc, Project: parts[0], Dataset: parts[1], Table: parts[2]}
+	default:
+		return fmt.Errorf("unknown ANALYTICS_SINK %q", s.cfg.Analytics.Sink)
+	}
+
+	s.analytics = analytics.NewWriter(sink, s.cfg.Analytics.BufferSize, s.cfg.Analytics.FlushInterval, s.logger)
+	s.logger.Printf("Writing product events to %s", s.cfg.Analytics.Sink)
+	return nil
+}
+
+// registerAnalytics はドメインイベントをプロダクトイベントに変換して記録する購読者を登録する
+func (s *Server) registerAnalytics(bus *events.Bus) {
+	if s.analytics == nil {
+		return
+	}
+
+	events.Subscribe(bus, "analytics", func(_ context.Context, e BookRegistered) {
+		props := map[string]interface{}{
+			"status":    e.Book.Status,
+			"ownership": e.Book.Ownership,
+			"hasPrice":  e.Book.Price > 0,
+		}
+		if !e.Book.OnWishlist() {
+			props["daysToDeadline"] = daysBetween(time.Now(), e.Book.Deadline)
+		}
+		if e.Book.GroupID != "" {
+			props["groupId"] = e.Book.GroupID
+		}
+		s.analytics.Emit(analytics.Event{Name: analytics.BookRegistered, UserID: e.Book.UserID, BookID: e.Book.BookID, Props: props})
+	})
+	events.Subscribe(bus, "analytics", func(_ context.Context, e InsultSent) {
+		props := map[string]interface{}{
+			"insultLevel": e.Book.InsultLevel,
+			"cycle":       e.Cycle,
+			"daysOverdue": daysBetween(e.Book.Deadline, e.SentAt),
+		}
+		if e.Variant != "" {
+			props["variant"] = e.Variant
+		}
+		if e.Template != "" {
+			props["template"] = e.Template
+		}
+		s.analytics.Emit(analytics.Event{Name: analytics.InsultSent, UserID: e.Book.UserID, BookID: e.Book.BookID, Props: props, At: e.SentAt})
+	})
+	events.Subscribe(bus, "analytics", func(_ context.Context, e BookCompleted) {
+		s.emitBookCompleted(e.Book)
+	})
+	events.Subscribe(bus, "analytics", func(_ context.Context, e BookUpdated) {
+		if e.Book.Status == "completed" && e.Previous.Status != "completed" {
+			s.emitBookCompleted(e.Book)
+			return
+		}
+		// 読み終えていない本の期限を後ろにずらしたら、スヌーズとみなす (ウィッシュリストから外して期限を決めたときは除く)
+		if e.Book.Status != "completed" && !e.Previous.OnWishlist() && e.Book.Deadline.After(e.Previous.Deadline) {
+			s.analytics.Emit(analytics.Event{Name: analytics.SnoozeUsed, UserID: e.Book.UserID, BookID: e.Book.BookID, Props: map[string]interface{}{
+				"daysExtended": daysBetween(e.Previous.Deadline, e.Book.Deadline),
+				"wasOverdue":   e.Previous.Deadline.Before(time.Now()),
+				"insultLevel":  e.Book.InsultLevel,
+			}})
+		}
+	})
+}
+
+// emitBookCompleted は book_completed を記録する。時刻は読了日時にする
+func (s *Server) emitBookCompleted(book store.Book) {
+	props := map[string]interface{}{
+		"insultLevel":   book.InsultLevel,
+		"afterDeadline": book.CompletedAt != nil && book.CompletedAt.After(book.Deadline),
+	}
+	if book.CreatedAt != nil && book.CompletedAt != nil {
+		props["daysToComplete"] = daysBetween(*book.CreatedAt, *book.CompletedAt)
+	}
+	event := analytics.Event{Name: analytics.BookCompleted, UserID: book.UserID, BookID: book.BookID, Props: props}
+	if book.CompletedAt != nil {
+		event.At = *book.CompletedAt
+	}
+	s.analytics.Emit(event)
+}
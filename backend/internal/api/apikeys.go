@@ -0,0 +1,349 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// スクリプトや外部連携が LINE ログインなしでユーザーの本を読み書きするための API キー。
+// キーは X-API-Key ヘッダーで受け取る。キーそのものは発行時のレスポンスでしか返さず、
+// apiKeys コレクションには SHA-256 のハッシュだけを保存する
+
+const (
+	apiKeyScopeRead  = "read"  // GET だけ
+	apiKeyScopeWrite = "write" // 本の登録・更新・削除・読了も
+
+	// apiKeyPrefix は発行するキーの接頭辞 (ログやシークレットスキャンで見分けるため)
+	apiKeyPrefix = "tk_"
+	// apiKeyHintLength は一覧で見せるキーの先頭の文字数 (どのキーかを見分けるため)
+	apiKeyHintLength = 10
+)
+
+// APIKey は apiKeys コレクションに保存する API キー。Key は発行時のレスポンスでしか返さない
+type APIKey struct {
+	KeyID      string     `json:"keyId" firestore:"keyId"`
+	UserID     string     `json:"userId" firestore:"userId"`
+	Name       string     `json:"name" firestore:"name"`
+	Scope      string     `json:"scope" firestore:"scope"`
+	Hint       string     `json:"hint" firestore:"hint"` // キーの先頭 (tk_xxxxxxx)
+	Hash       string     `json:"-" firestore:"hash"`
+	Key        string     `json:"key,omitempty" firestore:"-"`
+	CreatedAt  time.Time  `json:"createdAt" firestore:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty" firestore:"lastUsedAt"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty" firestore:"revokedAt"`
+}
+
+// allows は k で method のリクエストができるかを返す
+func (k APIKey) allows(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead:
+		return true
+	}
+	return k.Scope == apiKeyScopeWrite
+}
+
+var (
+	errInvalidAPIKey   = errors.New("invalid API key")
+	errAPIKeyOtherUser = errors.New("API key used for another user")
+)
+
+// hashAPIKey は保存・照合に使うキーのハッシュを返す
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+type apiKeyUserKey struct{}
+
+// apiKeyUser は API キーで認証したリクエストなら、キーの持ち主のユーザーIDを返す
+func apiKeyUser(ctx context.Context) (string, bool) {
+	userID, ok := ctx.Value(apiKeyUserKey{}).(string)
+	return userID, ok
+}
+
+// apiKeyAuth は X-API-Key があればキーを確かめ、リクエストの userId をキーの持ち主に固定してから next を呼ぶ。
+// X-API-Key がなければそのまま next を呼ぶ (userId を信じる従来どおりの扱い)
+func (s *Server) apiKeyAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		raw := r.Header.Get("X-API-Key")
+		if raw == "" || r.Method == http.MethodOptions {
+			next(w, r)
+			return
+		}
+
+		ctx := r.Context()
+		key, err := s.lookupAPIKey(ctx, raw)
+		if errors.Is(err, errInvalidAPIKey) {
+			writeProblem(w, r, http.StatusUnauthorized, "Invalid or revoked API key")
+			return
+		}
+		if err != nil {
+			writeServerError(w, r, err, "Failed to verify API key")
+			return
+		}
+		if !key.allows(r.Method) {
+			writeProblem(w, r, http.StatusForbidden, "This API key is read-only")
+			return
+		}
+		if err := bindUserID(r, key.UserID); errors.Is(err, errAPIKeyOtherUser) {
+			writeProblem(w, r, http.StatusForbidden, "This API key cannot access another user's data")
+			return
+		} else if err != nil {
+			writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("Failed to read request body: %v", err))
+			return
+		}
+		s.touchAPIKey(ctx, key.KeyID)
+
+		next(w, r.WithContext(context.WithValue(ctx, apiKeyUserKey{}, key.UserID)))
+	}
+}
+
+// bindUserID はクエリと JSON 本文の userId を userID にそろえる。
+// 省略されていれば userID を入れ、別のユーザーが指定されていれば errAPIKeyOtherUser
+func bindUserID(r *http.Request, userID string) error {
+	q := r.URL.Query()
+	switch q.Get("userId") {
+	case "":
+		q.Set("userId", userID)
+		r.URL.RawQuery = q.Encode()
+	case userID:
+	default:
+		return errAPIKeyOtherUser
+	}
+	if viewer := q.Get("viewerId"); viewer != "" && viewer != userID {
+		return errAPIKeyOtherUser
+	}
+
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	// JSON のオブジェクトでなければ、そのままハンドラーに任せる (400 になる)
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil || fields == nil {
+		return nil
+	}
+	if raw, ok := fields["userId"]; ok {
+		var got string
+		if err := json.Unmarshal(raw, &got); err != nil || (got != "" && got != userID) {
+			return errAPIKeyOtherUser
+		}
+	}
+	fields["userId"], _ = json.Marshal(userID)
+	body, _ = json.Marshal(fields)
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	return nil
+}
+
+// lookupAPIKey は raw のハッシュで有効な API キーを探す。見つからないか失効していれば errInvalidAPIKey
+func (s *Server) lookupAPIKey(ctx context.Context, raw string) (APIKey, error) {
+	iter := s.firestoreClient.Collection("apiKeys").
+		Where("hash", "==", hashAPIKey(raw)).
+		Limit(1).
+		Documents(ctx)
+	defer iter.Stop()
+
+	doc, err := iter.Next()
+	if err == iterator.Done {
+		return APIKey{}, errInvalidAPIKey
+	}
+	if err != nil {
+		return APIKey{}, err
+	}
+	var key APIKey
+	if err := doc.DataTo(&key); err != nil {
+		return APIKey{}, err
+	}
+	if key.RevokedAt != nil {
+		return APIKey{}, errInvalidAPIKey
+	}
+	return key, nil
+}
+
+// touchAPIKey はキーの最終利用日時をバックグラウンドで記録する。失敗してもリクエストには影響させない
+func (s *Server) touchAPIKey(ctx context.Context, keyID string) {
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		_, err := s.firestoreClient.Collection("apiKeys").Doc(keyID).Update(ctx, []firestore.Update{
+			{Path: "lastUsedAt", Value: time.Now()},
+		})
+		if err != nil {
+			s.logger.Printf("Error recording API key use %s: %v", keyID, err)
+		}
+	}()
+}
+
+// handleAPIKeys は API キーの一覧・発行・失効を振り分ける
+func (s *Server) handleAPIKeys(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.handleListAPIKeys(w, r)
+	case http.MethodPost:
+		s.handleIssueAPIKey(w, r)
+	case http.MethodDelete:
+		s.handleRevokeAPIKey(w, r)
+	default:
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// handleListAPIKeys は ?userId= のユーザーの API キーを返す (キーそのものは含めない)
+func (s *Server) handleListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	userID := r.URL.Query().Get("userId")
+	if userID == "" {
+		writeProblem(w, r, http.StatusBadRequest, "userId query parameter is required")
+		return
+	}
+
+	keys, err := s.listAPIKeys(r.Context(), userID)
+	if err != nil {
+		writeServerError(w, r, err, "Failed to retrieve API keys")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+// handleIssueAPIKey は API キーを発行し、キーそのものを1度だけ返す
+func (s *Server) handleIssueAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req issueAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid JSON body: %v", err))
+		return
+	}
+	if err := req.Validate(); err != nil {
+		writeValidationError(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	existing, err := s.listAPIKeys(ctx, req.UserID)
+	if err != nil {
+		writeServerError(w, r, err, "Failed to retrieve API keys")
+		return
+	}
+	active := 0
+	for _, k := range existing {
+		if k.RevokedAt == nil {
+			active++
+		}
+	}
+	if active >= maxAPIKeysPerUser {
+		writeProblem(w, r, http.StatusConflict, fmt.Sprintf("At most %d API keys can be active", maxAPIKeysPerUser))
+		return
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		writeServerError(w, r, err, "Failed to generate API key")
+		return
+	}
+	raw := apiKeyPrefix + hex.EncodeToString(secret)
+
+	docRef := s.firestoreClient.Collection("apiKeys").NewDoc()
+	key := APIKey{
+		KeyID:     docRef.ID,
+		UserID:    req.UserID,
+		Name:      req.Name,
+		Scope:     req.Scope,
+		Hint:      raw[:apiKeyHintLength],
+		Hash:      hashAPIKey(raw),
+		CreatedAt: time.Now(),
+	}
+	if _, err := docRef.Set(ctx, key); err != nil {
+		writeServerError(w, r, err, "Failed to save API key")
+		return
+	}
+
+	s.logger.Printf("API key issued: %s (user: %s, scope: %s)", key.KeyID, key.UserID, key.Scope)
+	key.Key = raw
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(key)
+}
+
+// handleRevokeAPIKey はユーザーの API キーを失効させる。記録は残し、以後そのキーは 401 になる
+func (s *Server) handleRevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req revokeAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid JSON body: %v", err))
+		return
+	}
+	if err := req.Validate(); err != nil {
+		writeValidationError(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	docRef := s.firestoreClient.Collection("apiKeys").Doc(req.KeyID)
+	doc, err := docRef.Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		writeProblem(w, r, http.StatusNotFound, "API key not found")
+		return
+	}
+	if err != nil {
+		writeServerError(w, r, err, "Failed to retrieve API key")
+		return
+	}
+	var key APIKey
+	if err := doc.DataTo(&key); err != nil {
+		writeServerError(w, r, err, "Failed to parse API key")
+		return
+	}
+	if key.UserID != req.UserID {
+		writeProblem(w, r, http.StatusUnauthorized, "Unauthorized to revoke this API key")
+		return
+	}
+
+	if key.RevokedAt == nil {
+		if _, err := docRef.Update(ctx, []firestore.Update{{Path: "revokedAt", Value: time.Now()}}); err != nil {
+			writeServerError(w, r, err, "Failed to revoke API key")
+			return
+		}
+		s.logger.Printf("API key revoked: %s", req.KeyID)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listAPIKeys は userID の API キーを失効したものも含めてすべて返す
+func (s *Server) listAPIKeys(ctx context.Context, userID string) ([]APIKey, error) {
+	docs, err := s.firestoreClient.Collection("apiKeys").
+		Where("userId", "==", userID).
+		Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []APIKey{}
+	for _, doc := range docs {
+		var key APIKey
+		if err := doc.DataTo(&key); err != nil {
+			s.logger.Printf("Error parsing API key %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
@@ -0,0 +1,202 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"tundoku-killer/backend/internal/cron"
+	"tundoku-killer/backend/internal/store"
+	"tundoku-killer/backend/internal/validation"
+)
+
+// 読み終えてから COMPLETED_RETENTION が過ぎた本を、ユーザー・読了年ごとのアーカイブ (bookArchives/{userId}_{year}) に
+// 要約だけ移してから本棚から消す。books コレクションと cron の走査を、今も読んでいる本の数に見合った大きさに保つため
+
+// archiveLease は読了本のアーカイブの二重実行を防ぐロックの名前
+const archiveLease = "archiveCompleted"
+
+// ArchivedBook はアーカイブに残す本の要約
+type ArchivedBook struct {
+	BookID      string     `json:"bookId" firestore:"bookId"`
+	Title       string     `json:"title" firestore:"title"`
+	Author      string     `json:"author" firestore:"author"`
+	ISBN        string     `json:"isbn,omitempty" firestore:"isbn,omitempty"`
+	Pages       int        `json:"pages,omitempty" firestore:"pages,omitempty"`
+	Price       int        `json:"price,omitempty" firestore:"price,omitempty"`
+	CreatedAt   *time.Time `json:"createdAt,omitempty" firestore:"createdAt,omitempty"`
+	CompletedAt time.Time  `json:"completedAt" firestore:"completedAt"`
+}
+
+// BookArchive はユーザーがある年に読み終えた本のアーカイブ。bookArchives/{userId}_{year} に保存する
+type BookArchive struct {
+	UserID    string         `json:"userId" firestore:"userId"`
+	Year      int            `json:"year" firestore:"year"`
+	Books     []ArchivedBook `json:"books" firestore:"books"`
+	UpdatedAt time.Time      `json:"updatedAt" firestore:"updatedAt"`
+}
+
+func archiveDocID(userID string, year int) string {
+	return userID + "_" + strconv.Itoa(year)
+}
+
+// archiveBook は本の要約をアーカイブに追記してから、本棚から消す。
+// 追記は ArrayUnion なので、削除に失敗して次回もう一度追記しても重複しない
+func (s *Server) archiveBook(ctx context.Context, book store.Book) error {
+	completedAt := book.CompletedAt.In(cron.Location)
+	entry := ArchivedBook{
+		BookID:      book.BookID,
+		Title:       book.Title,
+		Author:      book.Author,
+		ISBN:        book.ISBN,
+		Pages:       book.Pages,
+		Price:       book.Price,
+		CreatedAt:   book.CreatedAt,
+		CompletedAt: *book.CompletedAt,
+	}
+	ref := s.firestoreClient.Collection("bookArchives").Doc(archiveDocID(book.UserID, completedAt.Year()))
+	_, err := ref.Set(ctx, map[string]interface{}{
+		"userId":    book.UserID,
+		"year":      completedAt.Year(),
+		"books":     firestore.ArrayUnion(entry),
+		"updatedAt": time.Now(),
+	}, firestore.MergeAll)
+	if err != nil {
+		return err
+	}
+	return s.bookRepo.Delete(ctx, book.BookID)
+}
+
+// handleArchiveCompleted は読み終えてから保存期間が過ぎた本をアーカイブに移す。1日1回呼ぶ
+func (s *Server) handleArchiveCompleted(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodGet {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	ctx := context.WithoutCancel(r.Context())
+
+	if !s.authorizeCron(r) {
+		writeProblem(w, r, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	runID := uuid.NewString()
+	if err := s.cron.AcquireLease(ctx, archiveLease, runID, cron.LeaseTTL); err != nil {
+		if errors.Is(err, cron.ErrLeaseHeld) {
+			writeProblem(w, r, http.StatusConflict, "Another archive run is already in progress")
+			return
+		}
+		writeServerError(w, r, err, "Failed to acquire lock")
+		return
+	}
+	defer s.cron.ReleaseLease(ctx, archiveLease, runID)
+
+	now := time.Now()
+	cutoff := now.Add(-s.cfg.Cron.CompletedRetention)
+	deadline := now.Add(cron.TimeBudget)
+	archived, failed := 0, 0
+	done := false
+	for !done && failed == 0 && time.Now().Before(deadline) {
+		books, err := s.bookRepo.QueryCompletedBefore(ctx, cutoff, cron.PageSize)
+		if err != nil {
+			writeServerError(w, r, err, "Failed to query completed books")
+			return
+		}
+		done = len(books) < cron.PageSize
+		for _, book := range books {
+			if time.Now().After(deadline) {
+				done = false
+				break
+			}
+			if err := s.archiveBook(ctx, book); err != nil {
+				// 同じ本が次のページの先頭にまた来るので、失敗したらこの回は打ち切る
+				s.logger.Printf("Error archiving book %s: %v", book.BookID, err)
+				failed++
+				done = false
+				break
+			}
+			archived++
+		}
+	}
+
+	s.logger.Printf("Archived %d completed books older than %s (%d failed)", archived, cutoff.Format(time.RFC3339), failed)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"cutoff":   cutoff,
+		"archived": archived,
+		"failed":   failed,
+		"done":     done,
+	})
+}
+
+// handleBookArchive は ?userId= のユーザーのアーカイブを、読了年の新しい順に返す。?year= で1年分に絞れる
+func (s *Server) handleBookArchive(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	userID := query.Get("userId")
+	var v validation.Validator
+	v.Required("userId", userID)
+	v.MaxLength("userId", userID, maxIDLength)
+	year := 0
+	if raw := query.Get("year"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		v.Check(err == nil && n > 0, "year", "must be a positive integer")
+		year = n
+	}
+	if err := v.Err(); err != nil {
+		writeValidationError(w, r, err)
+		return
+	}
+
+	archives := []BookArchive{}
+	if year > 0 {
+		doc, err := s.firestoreClient.Collection("bookArchives").Doc(archiveDocID(userID, year)).Get(r.Context())
+		if err != nil && status.Code(err) != codes.NotFound {
+			writeServerError(w, r, err, "Failed to retrieve archive")
+			return
+		}
+		if err == nil {
+			var a BookArchive
+			if err := doc.DataTo(&a); err != nil {
+				writeServerError(w, r, err, "Failed to parse archive")
+				return
+			}
+			archives = append(archives, a)
+		}
+	} else {
+		docs, err := s.firestoreClient.Collection("bookArchives").Where("userId", "==", userID).Documents(r.Context()).GetAll()
+		if err != nil {
+			writeServerError(w, r, err, "Failed to retrieve archive")
+			return
+		}
+		for _, doc := range docs {
+			var a BookArchive
+			if err := doc.DataTo(&a); err != nil {
+				s.logger.Printf("Error parsing archive %s: %v", doc.Ref.ID, err)
+				continue
+			}
+			archives = append(archives, a)
+		}
+		sort.Slice(archives, func(i, j int) bool { return archives[i].Year > archives[j].Year })
+	}
+	for _, a := range archives {
+		sort.Slice(a.Books, func(i, j int) bool { return a.Books[i].CompletedAt.Before(a.Books[j].CompletedAt) })
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(archives)
+}
@@ -0,0 +1,224 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	firestoreadmin "google.golang.org/api/firestore/v1"
+	"google.golang.org/api/option"
+
+	"tundoku-killer/backend/internal/validation"
+)
+
+// Firestore のマネージドエクスポートによるバックアップ。BACKUP_BUCKET に日時ごとのフォルダを作って書き出し、
+// 開始した操作を backups/{backupId} に記録する。悪いデプロイや掃除のジョブの誤爆から本棚を戻せるようにするため。
+// 復元は gcloud firestore import で行う
+
+// バックアップの状態
+const (
+	BackupRunning   = "running"
+	BackupSucceeded = "succeeded"
+	BackupFailed    = "failed"
+)
+
+const (
+	defaultBackupListLimit = 20
+	maxBackupListLimit     = 100
+)
+
+// errBackupsDisabled は BACKUP_BUCKET が未設定のときのエラー
+var errBackupsDisabled = errors.New("backups are not configured")
+
+// Backup はエクスポート1回分の記録。backups/{backupId} に保存する
+type Backup struct {
+	BackupID    string     `json:"backupId" firestore:"backupId"`
+	Operation   string     `json:"operation" firestore:"operation"` // エクスポートの長時間実行オペレーションの名前
+	OutputURI   string     `json:"outputUri" firestore:"outputUri"`
+	Collections []string   `json:"collections,omitempty" firestore:"collections,omitempty"` // 空ならすべて
+	Trigger     string     `json:"trigger" firestore:"trigger"`                             // "cron" か "admin"
+	State       string     `json:"state" firestore:"state"`
+	Error       string     `json:"error,omitempty" firestore:"error,omitempty"`
+	StartedAt   time.Time  `json:"startedAt" firestore:"startedAt"`
+	CompletedAt *time.Time `json:"completedAt,omitempty" firestore:"completedAt,omitempty"`
+}
+
+// initBackups は BACKUP_BUCKET が設定されていれば Firestore Admin API のクライアントを初期化する
+func (s *Server) initBackups(ctx context.Context) error {
+	if s.cfg.Backup.Bucket == "" {
+		s.logger.Printf("BACKUP_BUCKET not set; Firestore backups are disabled")
+		return nil
+	}
+
+	project := s.cfg.Firebase.ProjectID
+	if project == "" {
+		var key struct {
+			ProjectID string `json:"project_id"`
+		}
+		if err := json.Unmarshal([]byte(s.cfg.Firebase.ServiceAccountKeyJSON), &key); err != nil || key.ProjectID == "" {
+			return errors.New("set GOOGLE_CLOUD_PROJECT or use a service account key with project_id")
+		}
+		project = key.ProjectID
+	}
+
+	svc, err := firestoreadmin.NewService(ctx, option.WithCredentialsJSON([]byte(s.cfg.Firebase.ServiceAccountKeyJSON)))
+	if err != nil {
+		return fmt.Errorf("error creating Firestore Admin client: %w", err)
+	}
+	s.backupService = svc
+	s.backupDatabase = "projects/" + project + "/databases/(default)"
+	return nil
+}
+
+// startBackup はエクスポートを開始して記録する。エクスポートは非同期で進み、状態は一覧を取るときに確かめる
+func (s *Server) startBackup(ctx context.Context, trigger string) (Backup, error) {
+	if s.backupService == nil {
+		return Backup{}, errBackupsDisabled
+	}
+
+	now := time.Now().UTC()
+	backup := Backup{
+		BackupID:    now.Format("20060102-150405"),
+		OutputURI:   s.cfg.Backup.Bucket + "/" + now.Format("2006-01-02T15-04-05Z"),
+		Collections: s.cfg.Backup.Collections,
+		Trigger:     trigger,
+		State:       BackupRunning,
+		StartedAt:   now,
+	}
+	op, err := s.backupService.Projects.Databases.ExportDocuments(s.backupDatabase, &firestoreadmin.GoogleFirestoreAdminV1ExportDocumentsRequest{
+		OutputUriPrefix: backup.OutputURI,
+		CollectionIds:   backup.Collections,
+	}).Context(ctx).Do()
+	if err != nil {
+		return Backup{}, fmt.Errorf("error starting export: %w", err)
+	}
+	backup.Operation = op.Name
+
+	if _, err := s.firestoreClient.Collection("backups").Doc(backup.BackupID).Set(ctx, backup); err != nil {
+		// エクスポート自体は進んでいるので、記録に失敗してもバックアップは返す
+		s.logger.Printf("Error recording backup %s: %v", backup.BackupID, err)
+	}
+	s.logger.Printf("Backup %s started (%s -> %s)", backup.BackupID, trigger, backup.OutputURI)
+	return backup, nil
+}
+
+// refreshBackup は実行中のバックアップのオペレーションを確かめ、終わっていれば記録を更新する
+func (s *Server) refreshBackup(ctx context.Context, backup *Backup) {
+	if backup.State != BackupRunning || backup.Operation == "" {
+		return
+	}
+	op, err := s.backupService.Projects.Databases.Operations.Get(backup.Operation).Context(ctx).Do()
+	if err != nil {
+		s.logger.Printf("Error checking backup %s: %v", backup.BackupID, err)
+		return
+	}
+	if !op.Done {
+		return
+	}
+
+	now := time.Now()
+	backup.State = BackupSucceeded
+	backup.CompletedAt = &now
+	if op.Error != nil {
+		backup.State = BackupFailed
+		backup.Error = op.Error.Message
+	}
+	_, err = s.firestoreClient.Collection("backups").Doc(backup.BackupID).Update(ctx, []firestore.Update{
+		{Path: "state", Value: backup.State},
+		{Path: "error", Value: backup.Error},
+		{Path: "completedAt", Value: now},
+	})
+	if err != nil {
+		s.logger.Printf("Error updating backup %s: %v", backup.BackupID, err)
+	}
+}
+
+// handleBackupCron は定期実行からバックアップを開始する。1日1回呼ぶ
+func (s *Server) handleBackupCron(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodGet {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if !s.authorizeCron(r) {
+		writeProblem(w, r, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	s.writeStartedBackup(w, r, "cron")
+}
+
+// handleBackups は GET で最近のバックアップを新しい順に返し、POST でバックアップを開始する
+func (s *Server) handleBackups(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.handleListBackups(w, r)
+	case http.MethodPost:
+		s.writeStartedBackup(w, r, "admin")
+	default:
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+func (s *Server) writeStartedBackup(w http.ResponseWriter, r *http.Request, trigger string) {
+	backup, err := s.startBackup(context.WithoutCancel(r.Context()), trigger)
+	if errors.Is(err, errBackupsDisabled) {
+		writeProblem(w, r, http.StatusNotImplemented, "Backups are not configured")
+		return
+	}
+	if err != nil {
+		writeServerError(w, r, err, "Failed to start backup")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(backup)
+}
+
+func (s *Server) handleListBackups(w http.ResponseWriter, r *http.Request) {
+	if s.backupService == nil {
+		writeProblem(w, r, http.StatusNotImplemented, "Backups are not configured")
+		return
+	}
+
+	limit := defaultBackupListLimit
+	var v validation.Validator
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		v.Check(err == nil, "limit", "must be an integer")
+		v.Range("limit", n, 1, maxBackupListLimit)
+		limit = n
+	}
+	if err := v.Err(); err != nil {
+		writeValidationError(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	docs, err := s.firestoreClient.Collection("backups").
+		OrderBy("startedAt", firestore.Desc).
+		Limit(limit).
+		Documents(ctx).GetAll()
+	if err != nil {
+		writeServerError(w, r, err, "Failed to retrieve backups")
+		return
+	}
+
+	backups := make([]Backup, 0, len(docs))
+	for _, doc := range docs {
+		var b Backup
+		if err := doc.DataTo(&b); err != nil {
+			s.logger.Printf("Error parsing backup %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		s.refreshBackup(ctx, &b)
+		backups = append(backups, b)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(backups)
+}
@@ -0,0 +1,145 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"tundoku-killer/backend/internal/insult"
+)
+
+// 煽り文に入れない語句・話題。運用者が全員分を blocklists/global に、ユーザーが自分の分を設定 (blockedTerms) に書く。
+// 用意された煽り文は当たるものを選ばず、生成AIの文面は当たれば用意された煽り文に切り替える
+
+// globalBlocklistTTL は全員分の語句をプロセス内に置く時間
+const globalBlocklistTTL = 5 * time.Minute
+
+// RejectedError.Category に入る、どちらの語句に当たったか
+const (
+	blocklistGlobal = "adminBlocklist"
+	blocklistUser   = "userBlocklist"
+)
+
+// Blocklist は blocklists/global に保存する全員分の語句
+type Blocklist struct {
+	Terms     []string  `json:"terms" firestore:"terms"`
+	UpdatedAt time.Time `json:"updatedAt" firestore:"updatedAt"`
+}
+
+// globalBlocklist は blocklists/global を globalBlocklistTTL の間キャッシュする
+type globalBlocklist struct {
+	client *firestore.Client
+
+	mu      sync.Mutex
+	list    Blocklist
+	expires time.Time
+}
+
+// load は全員分の語句を返す。キャッシュが切れていれば読み直す
+func (g *globalBlocklist) load(ctx context.Context) (Blocklist, error) {
+	now := time.Now()
+	g.mu.Lock()
+	list, expires := g.list, g.expires
+	g.mu.Unlock()
+	if now.Before(expires) {
+		return list, nil
+	}
+
+	list = Blocklist{}
+	doc, err := g.client.Collection("blocklists").Doc("global").Get(ctx)
+	switch {
+	case status.Code(err) == codes.NotFound:
+	case err != nil:
+		return Blocklist{}, err
+	default:
+		if err := doc.DataTo(&list); err != nil {
+			return Blocklist{}, fmt.Errorf("error parsing blocklist: %w", err)
+		}
+	}
+	g.mu.Lock()
+	g.list, g.expires = list, now.Add(globalBlocklistTTL)
+	g.mu.Unlock()
+	return list, nil
+}
+
+// invalidate はキャッシュを捨てる。書き換えたインスタンスではすぐに反映させる
+func (g *globalBlocklist) invalidate() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.expires = time.Time{}
+}
+
+// recipientBlocklist は userID に送る煽り文で止める語句 (全員分と本人の分)。どちらもなければ nil。
+// 読めなかった分は飛ばす (煽りを止めるほどのことではない)
+func (s *Server) recipientBlocklist(ctx context.Context, userID string) *insult.Blocklist {
+	terms := map[string][]string{}
+	if global, err := s.blocklist.load(ctx); err != nil {
+		s.logger.Printf("Error loading global blocklist: %v", err)
+	} else {
+		terms[blocklistGlobal] = global.Terms
+	}
+	if settings, err := s.userRepo.GetSettings(ctx, userID); err != nil {
+		s.logger.Printf("Error loading blocked terms for %s: %v", userID, err)
+	} else {
+		terms[blocklistUser] = settings.BlockedTerms
+	}
+	return insult.NewBlocklist(terms)
+}
+
+// isRejected は err が語句に当たって煽り文を送らなかったことによるものかを返す
+func isRejected(err error) bool {
+	var rejected *insult.RejectedError
+	return errors.As(err, &rejected)
+}
+
+// handleBlocklist は全員分の語句の取得 (GET) と置き換え (PUT) を行う
+func (s *Server) handleBlocklist(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.blocklist.invalidate()
+		list, err := s.blocklist.load(r.Context())
+		if err != nil {
+			writeServerError(w, r, err, "Failed to load blocklist")
+			return
+		}
+		if list.Terms == nil {
+			list.Terms = []string{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+
+	case http.MethodPut:
+		var req blocklistRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid JSON body: %v", err))
+			return
+		}
+		if err := req.Validate(); err != nil {
+			writeValidationError(w, r, err)
+			return
+		}
+		list := Blocklist{Terms: req.Terms, UpdatedAt: time.Now()}
+		if list.Terms == nil {
+			list.Terms = []string{}
+		}
+		if _, err := s.firestoreClient.Collection("blocklists").Doc("global").Set(r.Context(), list); err != nil {
+			writeServerError(w, r, err, "Failed to save blocklist")
+			return
+		}
+		s.blocklist.invalidate()
+		s.logger.Printf("Global blocklist updated (%d terms)", len(list.Terms))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+
+	default:
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
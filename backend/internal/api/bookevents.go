@@ -0,0 +1,208 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"tundoku-killer/backend/internal/events"
+	"tundoku-killer/backend/internal/store"
+)
+
+// 本と煽りに関するドメインイベント。books.go や processOverdueBook はこれを発行するだけで、
+// SSE・Webhook・統計・煽りの履歴などの後続の処理は registerEventSubscribers で購読する
+
+// eventBus はドメインイベントのバス
+var eventBus = events.New()
+
+// BookRegistered は本が登録されたときに発行する
+type BookRegistered struct {
+	Book store.Book
+}
+
+// BooksImported は Goodreads などから本をまとめて取り込んだときに発行する。取り込んだ本の BookRegistered は発行しない
+type BooksImported struct {
+	UserID string
+	Books  []store.Book
+}
+
+// BookUpdated は本の内容が更新されたときに発行する
+type BookUpdated struct {
+	Book     store.Book
+	Previous store.Book // 更新前の内容
+}
+
+// BookDeleted は本が削除されたときに発行する
+type BookDeleted struct {
+	UserID string
+	BookID string
+}
+
+// BookCompleted は本が読了になったときに発行する
+type BookCompleted struct {
+	Book store.Book
+}
+
+// InsultSent は期限切れの本の煽り文をLINEで送ったときに発行する。Book.Status は "insulted"
+type InsultSent struct {
+	InsultID string // insults に保存する履歴のID
+	Book     store.Book
+	Message  string
+	Variant  string // A/B テストの種類。テストしていなければ ""
+	Template string // 用意された煽り文のID。Gemini などなら ""
+	Cycle    string
+	SentAt   time.Time
+}
+
+// ProofAttached は読了した本に証拠写真を結びつけたときに発行する
+type ProofAttached struct {
+	Proof CompletionProof
+	Book  store.Book
+}
+
+// SessionLogged は読書の記録を保存したときに発行する
+type SessionLogged struct {
+	Session ReadingSession
+}
+
+// AchievementUnlocked は実績を解除したときに発行する
+type AchievementUnlocked struct {
+	UserID      string
+	Achievement Achievement
+	UnlockedAt  time.Time
+}
+
+func (BookRegistered) EventName() string { return "book.registered" }
+func (BooksImported) EventName() string  { return "books.imported" }
+func (BookUpdated) EventName() string    { return "book.updated" }
+func (BookDeleted) EventName() string    { return "book.deleted" }
+func (BookCompleted) EventName() string  { return "book.completed" }
+func (InsultSent) EventName() string     { return "insult.sent" }
+func (ProofAttached) EventName() string  { return "proof.attached" }
+func (SessionLogged) EventName() string  { return "session.logged" }
+
+func (AchievementUnlocked) EventName() string { return "achievement.unlocked" }
+
+// registerEventSubscribers は後続の処理をバスに登録する
+func (s *Server) registerEventSubscribers(bus *events.Bus) {
+	// SSE: 接続中の画面へのリアルタイム配信
+	events.Subscribe(bus, "sse", func(_ context.Context, e BookRegistered) {
+		publishBookStatus(e.Book.UserID, e.Book.BookID, e.Book.Status)
+	})
+	events.Subscribe(bus, "sse", func(_ context.Context, e BookUpdated) {
+		publishBookStatus(e.Book.UserID, e.Book.BookID, e.Book.Status)
+	})
+	events.Subscribe(bus, "sse", func(_ context.Context, e BookDeleted) {
+		publishBookStatus(e.UserID, e.BookID, "deleted")
+	})
+	events.Subscribe(bus, "sse", func(_ context.Context, e BookCompleted) {
+		publishBookStatus(e.Book.UserID, e.Book.BookID, e.Book.Status)
+	})
+	events.Subscribe(bus, "sse", func(_ context.Context, e InsultSent) {
+		liveEvents.publish(LiveEvent{Type: eventInsultSent, UserID: e.Book.UserID, BookID: e.Book.BookID, Message: e.Message, At: e.SentAt})
+		publishBookStatus(e.Book.UserID, e.Book.BookID, e.Book.Status)
+	})
+
+	// Webhook: ユーザーが登録した外部URLへの配信
+	events.Subscribe(bus, "webhooks", func(ctx context.Context, e BookRegistered) {
+		s.notifyWebhooks(ctx, webhookBookRegistered, e.Book)
+	})
+	events.Subscribe(bus, "webhooks", func(ctx context.Context, e BookCompleted) {
+		s.notifyWebhooks(ctx, webhookBookCompleted, e.Book)
+	})
+	events.Subscribe(bus, "webhooks", func(ctx context.Context, e InsultSent) {
+		s.notifyWebhooks(ctx, webhookBookOverdue, e.Book)
+	})
+
+	// 統計: 本が変わったらキャッシュした集計を捨てる
+	events.Subscribe(bus, "stats", func(ctx context.Context, e BookRegistered) { s.invalidateStats(ctx, e.Book.UserID) })
+	events.Subscribe(bus, "stats", func(ctx context.Context, e BooksImported) { s.invalidateStats(ctx, e.UserID) })
+	events.Subscribe(bus, "stats", func(ctx context.Context, e BookUpdated) { s.invalidateStats(ctx, e.Book.UserID) })
+	events.Subscribe(bus, "stats", func(ctx context.Context, e BookDeleted) { s.invalidateStats(ctx, e.UserID) })
+	events.Subscribe(bus, "stats", func(ctx context.Context, e BookCompleted) { s.invalidateStats(ctx, e.Book.UserID) })
+	events.Subscribe(bus, "stats", func(ctx context.Context, e SessionLogged) { s.invalidateStats(ctx, e.Session.UserID) })
+	events.Subscribe(bus, "stats", func(ctx context.Context, e InsultSent) { s.invalidateStats(ctx, e.Book.UserID) })
+
+	// 煽りの履歴 (ダッシュボード・GraphQL 用)
+	events.Subscribe(bus, "insultHistory", func(ctx context.Context, e InsultSent) {
+		s.recordInsult(ctx, e)
+	})
+
+	// 生成AIが書いた煽り文の使い回し: 煽る必要のなくなった本の文面を消す
+	events.Subscribe(bus, "insultCache", func(ctx context.Context, e BookDeleted) { s.forgetInsult(ctx, e.BookID) })
+	events.Subscribe(bus, "insultCache", func(ctx context.Context, e BookCompleted) { s.forgetInsult(ctx, e.Book.BookID) })
+
+	// 読了クイズ: 答える必要のなくなった本のクイズを消す
+	events.Subscribe(bus, "quiz", func(ctx context.Context, e BookDeleted) { s.forgetQuiz(ctx, e.BookID) })
+	events.Subscribe(bus, "quiz", func(ctx context.Context, e BookCompleted) { s.forgetQuiz(ctx, e.Book.BookID) })
+
+	// ストリーク: 読書の記録を付けた日を数える
+	events.Subscribe(bus, "streaks", func(ctx context.Context, e SessionLogged) { s.extendStreak(ctx, e.Session) })
+
+	// 読書タイマー: 消した本のタイマーを捨てる
+	events.Subscribe(bus, "timers", func(ctx context.Context, e BookDeleted) { s.discardTimer(ctx, e.UserID, e.BookID) })
+
+	// カレンダーのフィード: 本が変わったら作り直す
+	events.Subscribe(bus, "calendar", func(ctx context.Context, e BookRegistered) { s.forgetCalendar(ctx, e.Book.UserID) })
+	events.Subscribe(bus, "calendar", func(ctx context.Context, e BooksImported) { s.forgetCalendar(ctx, e.UserID) })
+	events.Subscribe(bus, "calendar", func(ctx context.Context, e BookUpdated) { s.forgetCalendar(ctx, e.Book.UserID) })
+	events.Subscribe(bus, "calendar", func(ctx context.Context, e BookDeleted) { s.forgetCalendar(ctx, e.UserID) })
+	events.Subscribe(bus, "calendar", func(ctx context.Context, e BookCompleted) { s.forgetCalendar(ctx, e.Book.UserID) })
+
+	// 貸し出し: 消した本の貸し出しの記録を消す
+	events.Subscribe(bus, "loans", func(ctx context.Context, e BookDeleted) { s.deleteLoans(ctx, e.BookID) })
+
+	// ハイライトとメモ: 消した本のノートを消す
+	events.Subscribe(bus, "notes", func(ctx context.Context, e BookDeleted) { s.deleteNotes(ctx, e.BookID) })
+
+	// 価格の記録: 消した本の記録を消す
+	events.Subscribe(bus, "prices", func(ctx context.Context, e BookDeleted) { s.deletePriceHistory(ctx, e.BookID) })
+
+	// 読む順番: 消した本を、先に読む本から外す
+	events.Subscribe(bus, "dependencies", func(ctx context.Context, e BookDeleted) { s.dropDependency(ctx, e.UserID, e.BookID) })
+
+	// 読了の証拠写真: 見張り役と読書会に知らせ、本を消したら写真も消す
+	events.Subscribe(bus, "proofs", func(ctx context.Context, e ProofAttached) { s.announceProof(ctx, e) })
+	events.Subscribe(bus, "proofs", func(ctx context.Context, e BookDeleted) { s.deleteProofs(ctx, e.BookID) })
+
+	// 登録の確認: 生成AIに書かせた紹介文を添えて送る (BOOK_TEASERS)
+	events.Subscribe(bus, "teaser", func(ctx context.Context, e BookRegistered) { s.sendRegistrationTeaser(ctx, e.Book) })
+
+	// 見張り役: 期限切れの通知のコピーを友達にも送る
+	events.Subscribe(bus, "partners", func(ctx context.Context, e InsultSent) {
+		s.notifyPartners(ctx, e.Book)
+	})
+
+	// ポイント: 読了で加点、煽られたら減点
+	events.Subscribe(bus, "points", func(ctx context.Context, e BookCompleted) { s.awardCompletion(ctx, e.Book) })
+	events.Subscribe(bus, "points", func(ctx context.Context, e BookUpdated) {
+		if e.Book.Status == "completed" {
+			s.awardCompletion(ctx, e.Book)
+		}
+	})
+	events.Subscribe(bus, "points", func(ctx context.Context, e InsultSent) { s.penalizeInsult(ctx, e.Book, e.Cycle, e.SentAt) })
+
+	// 実績: 読み終えたら判定し、解除したらLINEで祝う
+	events.Subscribe(bus, "achievements", func(ctx context.Context, e BookCompleted) {
+		if err := s.evaluateAchievements(ctx, e.Book.UserID, time.Now()); err != nil {
+			s.logger.Printf("Error evaluating achievements for %s: %v", e.Book.UserID, err)
+		}
+	})
+	events.Subscribe(bus, "achievements", func(ctx context.Context, e BookUpdated) {
+		if e.Book.Status != "completed" {
+			return
+		}
+		if err := s.evaluateAchievements(ctx, e.Book.UserID, time.Now()); err != nil {
+			s.logger.Printf("Error evaluating achievements for %s: %v", e.Book.UserID, err)
+		}
+	})
+	events.Subscribe(bus, "line", func(ctx context.Context, e AchievementUnlocked) {
+		message := fmt.Sprintf("実績「%s」を解除しました。%s。…たまにはやるじゃないですか。", e.Achievement.Name, e.Achievement.Description)
+		if err := s.sendLineMessage(ctx, e.UserID, message); err != nil {
+			s.logger.Printf("Error sending achievement message to %s: %v", e.UserID, err)
+		}
+	})
+
+	// 分析: ファネルの分析用のプロダクトイベント (ANALYTICS_SINK)
+	s.registerAnalytics(bus)
+}
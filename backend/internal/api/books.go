@@ -0,0 +1,391 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"tundoku-killer/backend/internal/isbn"
+	"tundoku-killer/backend/internal/store"
+	"tundoku-killer/backend/internal/validation"
+)
+
+// REST と gRPC の両方から使う本の操作。入力チェックと所持者チェックもここで行う
+
+var errNotBookOwner = errors.New("book belongs to another user")
+
+// errQuizRequired は難しいモードのユーザーが、クイズに正解せずに本を読了にしようとしたときのエラー
+var errQuizRequired = errors.New("answer the quiz to complete this book in hard mode")
+
+// listBooks は userID が登録した本をすべて返す
+func (s *Server) listBooks(ctx context.Context, userID string) ([]store.Book, error) {
+	return s.bookRepo.List(ctx, userID)
+}
+
+// registerBook は本を検証して保存し、採番したIDを設定した本を返す
+func (s *Server) registerBook(ctx context.Context, book store.Book) (store.Book, error) {
+	// デフォルト値を設定
+	if book.Status == "" {
+		book.Status = "unread"
+	}
+	if book.Ownership == "" {
+		book.Ownership = store.OwnershipOwned
+	}
+	// ISBN はハイフンを除いて保存する (検査数字は validateNewBook で確かめる)
+	book.ISBN = isbn.Normalize(book.ISBN)
+	book.Tags = normalizeTags(book.Tags)
+	// 入力チェック (必須項目・文字数・ステータス・期限が未来か)
+	if err := validateNewBook(book, time.Now()); err != nil {
+		return store.Book{}, err
+	}
+
+	// 未読の本の上限 (unreadHardCap) を超えるなら断る
+	if err := s.checkUnreadCap(ctx, book); err != nil {
+		return store.Book{}, err
+	}
+
+	// 先に読む本は自分の本棚の本だけ (登録したばかりの本に依存する本はないので、循環はしない)
+	if len(book.DependsOn) > 0 {
+		books, err := s.listBooks(ctx, book.UserID)
+		if err != nil {
+			return store.Book{}, err
+		}
+		if err := checkDependencies(books, book, book.DependsOn); err != nil {
+			return store.Book{}, err
+		}
+	}
+
+	// 価格が未指定なら ISBN から調べる (見つからなくても登録は続ける)
+	if book.Price == 0 && book.ISBN != "" {
+		price, err := s.lookupPrice(ctx, book.ISBN)
+		if err != nil {
+			s.logger.Printf("Error looking up price for ISBN %s: %v", book.ISBN, err)
+		}
+		book.Price = price
+	}
+
+	// ウィッシュリストの本には期限を付けない (持つことにしたときに /v1/books/{id}/ownership で決める)
+	if book.OnWishlist() {
+		book.Deadline = time.Time{}
+	}
+	// 図書館の返却期限は、貸出期間を計算する /v1/books/{id}/library で設定する
+	book.Library = nil
+	book.LibraryNudgedAt = nil
+	book.PriceWatch = nil
+
+	// 登録日時・読了日時はサーバー側で記録する (統計に使う)
+	now := time.Now()
+	book.CreatedAt = &now
+	book.CompletedAt = nil
+	if book.Status == "completed" {
+		book.CompletedAt = &now
+	}
+
+	// 採番したIDを設定して保存
+	book, err := s.bookRepo.Create(ctx, book)
+	if err != nil {
+		return store.Book{}, err
+	}
+
+	// Upstashへのスケジュール登録処理は削除 (GitHub ActionsのCronで定期チェックするため)
+	s.logger.Printf("Book registered: %s (Deadline: %v)", book.Title, book.Deadline)
+	eventBus.Publish(ctx, BookRegistered{Book: book})
+	return book, nil
+}
+
+// updateBook は本の全項目を上書きする。book.UserID が所持者と一致しなければ errNotBookOwner
+func (s *Server) updateBook(ctx context.Context, book store.Book) error {
+	book.Tags = normalizeTags(book.Tags)
+	if err := validateBookUpdate(book); err != nil {
+		return err
+	}
+
+	// 更新前にその本の所持者かチェックする（簡易セキュリティ）
+	existing, err := s.ownedBook(ctx, book.BookID, book.UserID)
+	if err != nil {
+		return err
+	}
+
+	if book.Status == "completed" && existing.Status != "completed" {
+		if err := s.requireQuiz(ctx, existing); err != nil {
+			return err
+		}
+	}
+
+	// 登録日時・読了日時・読書会・誓約はクライアントからは変更させない (統計・読書会の進捗・支払いの督促に使う)。
+	// 先に読む本は循環を確かめる /v1/books/{id}/dependencies で変える
+	book.GroupID = existing.GroupID
+	book.Pledge = existing.Pledge
+	book.DependsOn = existing.DependsOn
+	// 買った日と店は /v1/books/{id}/purchase で変える (価格はここでも変えられる)
+	book.PurchasedAt = existing.PurchasedAt
+	book.PurchaseStore = existing.PurchaseStore
+	// 図書館の返却期限は /v1/books/{id}/library で変える
+	book.Library = existing.Library
+	book.LibraryNudgedAt = existing.LibraryNudgedAt
+	// 値下がりの見張りは /v1/books/{id}/price-watch で変える
+	book.PriceWatch = existing.PriceWatch
+	// 持ち方はウィッシュリストから外すときに期限を決めさせるため /v1/books/{id}/ownership で変える。ウィッシュリストの本に期限はない
+	book.Ownership = existing.Ownership
+	if book.OnWishlist() {
+		book.Deadline = time.Time{}
+	} else if book.Deadline.IsZero() {
+		var v validation.Validator
+		v.Check(false, "deadline", "is required")
+		return v.Err()
+	}
+	book.CreatedAt = existing.CreatedAt
+	book.CompletedAt = existing.CompletedAt
+	if book.Status == "completed" && book.CompletedAt == nil {
+		now := time.Now()
+		book.CompletedAt = &now
+		releasePledge(&book, now)
+	} else if book.Status != "completed" {
+		book.CompletedAt = nil
+	}
+
+	if err := s.bookRepo.Update(ctx, book); err != nil { // 全て上書き
+		return err
+	}
+
+	s.logger.Printf("Book updated: %s (ID: %s)", book.Title, book.BookID)
+	eventBus.Publish(ctx, BookUpdated{Book: book, Previous: existing})
+	return nil
+}
+
+// deleteBook は userID が所持している本を削除する
+func (s *Server) deleteBook(ctx context.Context, req deleteBookRequest) error {
+	if err := req.Validate(); err != nil {
+		return err
+	}
+
+	// 削除前に所持者チェック
+	if _, err := s.ownedBook(ctx, req.BookID, req.UserID); err != nil {
+		return err
+	}
+
+	if err := s.bookRepo.Delete(ctx, req.BookID); err != nil {
+		return err
+	}
+
+	s.logger.Printf("Book deleted: %s", req.BookID)
+	eventBus.Publish(ctx, BookDeleted{UserID: req.UserID, BookID: req.BookID})
+	return nil
+}
+
+// completeBook は本のステータスを "completed" に更新する
+func (s *Server) completeBook(ctx context.Context, req completeBookRequest) error {
+	if err := req.Validate(); err != nil {
+		return err
+	}
+
+	// 通知先のユーザーを知るために先に読み込む
+	book, err := s.bookRepo.Get(ctx, req.BookID)
+	if err != nil {
+		return err
+	}
+	// API キーでは、キーの持ち主の本しか読了にできない
+	if userID, ok := apiKeyUser(ctx); ok && book.UserID != userID {
+		return errNotBookOwner
+	}
+	if err := s.requireQuiz(ctx, book); err != nil {
+		return err
+	}
+	return s.markCompleted(ctx, book)
+}
+
+// markCompleted は本のステータスを "completed" に更新し、読了日時を記録する。期限前なら誓約も解除する
+func (s *Server) markCompleted(ctx context.Context, book store.Book) error {
+	completedAt := time.Now()
+	completed := "completed"
+	patch := store.BookPatch{Status: &completed, CompletedAt: &completedAt}
+	if releasePledge(&book, completedAt) {
+		patch.Pledge = book.Pledge
+	}
+	if err := s.bookRepo.Patch(ctx, book.BookID, patch); err != nil {
+		return err
+	}
+
+	s.logger.Printf("Book %s marked as completed.", book.BookID)
+	book.Status = completed
+	book.CompletedAt = &completedAt
+	eventBus.Publish(ctx, BookCompleted{Book: book})
+	return nil
+}
+
+// ownedBook は bookID の本が userID のものであることを確認して、現在の内容を返す
+func (s *Server) ownedBook(ctx context.Context, bookID, userID string) (store.Book, error) {
+	book, err := s.bookRepo.Get(ctx, bookID)
+	if err != nil {
+		return store.Book{}, err
+	}
+	if book.UserID != userID {
+		return store.Book{}, errNotBookOwner
+	}
+	return book, nil
+}
+
+// handleBooks は /api/books へのリクエストをHTTPメソッドに応じて振り分ける
+func (s *Server) handleBooks(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.handleGetBooks(w, r)
+	case http.MethodPost:
+		s.handleRegisterBook(w, r)
+	case http.MethodPut:
+		s.handleUpdateBook(w, r)
+	case http.MethodDelete:
+		s.handleDeleteBook(w, r)
+	default:
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// handleUpdateBook は書籍情報を更新する
+func (s *Server) handleUpdateBook(w http.ResponseWriter, r *http.Request) {
+	var book store.Book
+	if err := json.NewDecoder(r.Body).Decode(&book); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid JSON body: %v", err))
+		return
+	}
+
+	if err := s.updateBook(r.Context(), book); err != nil {
+		writeBookError(w, r, err, "Failed to update book")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Book updated successfully"})
+}
+
+// handleDeleteBook は書籍を削除する
+func (s *Server) handleDeleteBook(w http.ResponseWriter, r *http.Request) {
+	var reqBody deleteBookRequest
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid JSON body: %v", err))
+		return
+	}
+
+	if err := s.deleteBook(r.Context(), reqBody); err != nil {
+		writeBookError(w, r, err, "Failed to delete book")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+}
+
+// handleGetBooks は登録済みの書籍リストを取得する
+func (s *Server) handleGetBooks(w http.ResponseWriter, r *http.Request) {
+	userId := r.URL.Query().Get("userId")
+
+	if userId == "" {
+		writeProblem(w, r, http.StatusBadRequest, "userId query parameter is required")
+		return
+	}
+
+	// 自分の本棚は、本棚のバージョンが変わっていなければ一覧を読まずに 304 を返す (フロントエンドのポーリング対策)
+	viewerID := r.URL.Query().Get("viewerId")
+	if viewerID == "" || viewerID == userId {
+		version, err := s.shelfVersions.Get(r.Context(), userId)
+		if err != nil {
+			s.logger.Printf("Error fetching shelf version for %s: %v", userId, err)
+		} else if notModified(w, r, shelfETag(userId, version), version.UpdatedAt) {
+			return
+		}
+	}
+
+	// 他のユーザーの本棚は、共有されている場合だけ見られる
+	books, err := s.sharedShelf(r.Context(), userId, viewerID)
+	if errors.Is(err, errShelfNotShared) {
+		writeProblem(w, r, http.StatusForbidden, "This shelf is not shared with you")
+		return
+	}
+	if err != nil {
+		writeServerError(w, r, err, "Failed to retrieve books")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(books)
+}
+
+// handleRegisterBook は書籍登録リクエストを処理する
+func (s *Server) handleRegisterBook(w http.ResponseWriter, r *http.Request) {
+	// リクエストボディのパース
+	var book store.Book
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("Failed to read request body: %v", err))
+		return
+	}
+	if err := json.Unmarshal(body, &book); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid JSON body: %v", err))
+		return
+	}
+
+	// 購入禁止モードなら、?override=true がなければ登録を止める。押し切ったら記録する。
+	// 押し切れない未読の本の上限は registerBook で確かめる (どの登録の経路もそこを通る)
+	ban, err := s.purchaseBan(r.Context(), book)
+	if err != nil {
+		writeServerError(w, r, err, "Failed to check purchase ban")
+		return
+	}
+	if ban != nil && r.URL.Query().Get("override") != "true" {
+		writePurchaseBan(w, r, ban)
+		return
+	}
+
+	book, err = s.registerBook(r.Context(), book)
+	if err != nil {
+		writeBookError(w, r, err, "Failed to save book")
+		return
+	}
+	if ban != nil {
+		s.recordPurchaseBanOverride(r.Context(), book, ban)
+	}
+
+	// 成功レスポンスを返す。先に読む本より期限が前なら警告を添える
+	resp := map[string]interface{}{"message": "Book registered successfully", "bookId": book.BookID}
+	if warnings := s.dependencyWarnings(r.Context(), book); len(warnings) > 0 {
+		resp["warnings"] = warnings
+	}
+	// 同じ作品が (紙の本と電子書籍のように形式が違っても) 本棚にあれば知らせる
+	if duplicate := s.duplicateWarning(r.Context(), book); duplicate != nil {
+		resp["duplicate"] = duplicate
+	}
+	// ウィッシュリストの本が近所の図書館にあれば、買う前に借りるよう勧める
+	if nudge := s.libraryNudge(r.Context(), book); nudge != nil {
+		resp["library"] = nudge
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleCompleteBook は書籍のステータスを "completed" に更新する
+func (s *Server) handleCompleteBook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var reqBody completeBookRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid JSON body: %v", err))
+		return
+	}
+
+	if err := s.completeBook(r.Context(), reqBody); err != nil {
+		writeBookError(w, r, err, "Failed to update book status")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Book marked as completed"})
+}
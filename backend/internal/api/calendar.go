@@ -0,0 +1,160 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"tundoku-killer/backend/internal/cron"
+	"tundoku-killer/backend/internal/store"
+)
+
+// 期限の iCalendar フィード。トークン付きの URL (/v1/calendar.ics?token=) を Google カレンダーや Apple のカレンダーに
+// 登録すると、読み終えていない本の期限と図書館の返却期限が終日の予定として入り、3日前と前日の朝に通知が出る。
+// トークンは calendarFeeds/{token} に保存する (feedtokens.go)。
+// 生成したフィードはキャッシュに置き、本が変わったら捨てて次の取得で作り直す
+
+// calendarAlarms は予定の通知のタイミング (終日の予定の始まり = 当日の0時から)
+var calendarAlarms = []struct {
+	Trigger     string
+	Description string
+}{
+	{"-P3D", "期限まであと3日"},
+	{"-PT15H", "期限は明日"}, // 前日の朝9時
+}
+
+func calendarCacheKey(userID string) string {
+	return "calendar:" + userID
+}
+
+// handleCalendarFeed はフィードのトークンの取得 (GET ?userId=)・発行 (POST)・取り消し (DELETE ?userId=) を行う
+func (s *Server) handleCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	s.handleFeedToken(w, r, "calendarFeeds", "/calendar.ics")
+}
+
+// handleCalendarICS はトークンの持ち主の期限を iCalendar で返す (GET ?token=)。カレンダーのアプリが取りに来るので、ログインは要らない
+func (s *Server) handleCalendarICS(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		writeProblem(w, r, http.StatusBadRequest, "token query parameter is required")
+		return
+	}
+	ctx := r.Context()
+	userID, err := s.feedOwner(ctx, "calendarFeeds", token)
+	if errors.Is(err, errFeedNotFound) {
+		writeProblem(w, r, http.StatusNotFound, "Calendar feed not found")
+		return
+	}
+	if err != nil {
+		writeServerError(w, r, err, "Failed to retrieve calendar feed")
+		return
+	}
+
+	ics, ok, err := s.cache.Get(ctx, calendarCacheKey(userID))
+	if err != nil || !ok {
+		books, err := s.listBooks(ctx, userID)
+		if err != nil {
+			writeServerError(w, r, err, "Failed to retrieve books")
+			return
+		}
+		ics = []byte(buildCalendar(books, time.Now()))
+		if err := s.cache.Set(ctx, calendarCacheKey(userID), ics, s.cfg.Cache.TTL); err != nil {
+			s.logger.Printf("Error caching calendar for %s: %v", userID, err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `inline; filename="tundoku.ics"`)
+	w.Write(ics)
+}
+
+// forgetCalendar は本が変わったとき、キャッシュしたフィードを捨てる
+func (s *Server) forgetCalendar(ctx context.Context, userID string) {
+	if err := s.cache.Delete(ctx, calendarCacheKey(userID)); err != nil {
+		s.logger.Printf("Error invalidating calendar for %s: %v", userID, err)
+	}
+}
+
+// buildCalendar は books の読了の期限と図書館の返却期限を iCalendar (RFC 5545) にする。
+// 読み終えた本・諦めた本・ウィッシュリストの本の読了の期限は載せない
+func buildCalendar(books []store.Book, now time.Time) string {
+	var b strings.Builder
+	writeICSLine(&b, "BEGIN:VCALENDAR")
+	writeICSLine(&b, "VERSION:2.0")
+	writeICSLine(&b, "PRODID:-//tundoku-killer//deadlines//JA")
+	writeICSLine(&b, "CALSCALE:GREGORIAN")
+	writeICSLine(&b, "METHOD:PUBLISH")
+	writeICSLine(&b, "X-WR-CALNAME:積読の期限")
+	writeICSLine(&b, "X-WR-TIMEZONE:Asia/Tokyo")
+	writeICSLine(&b, "REFRESH-INTERVAL;VALUE=DURATION:PT1H")
+	writeICSLine(&b, "X-PUBLISHED-TTL:PT1H")
+
+	stamp := now.UTC().Format("20060102T150405Z")
+	for _, book := range books {
+		if book.Status != "completed" && !book.Abandoned() && !book.OnWishlist() && !book.Deadline.IsZero() {
+			summary := fmt.Sprintf("読了期限:『%s』", book.Title)
+			description := fmt.Sprintf("『%s』(%s) の読了期限です。読み終えていなければ煽られます。", book.Title, book.Author)
+			writeICSEvent(&b, book.BookID+"-deadline@tundoku-killer", stamp, book.Deadline, summary, description)
+		}
+		if book.Library != nil {
+			library := "図書館"
+			if book.Library.SystemName != "" {
+				library = book.Library.SystemName
+			}
+			summary := fmt.Sprintf("返却期限:『%s』", book.Title)
+			description := fmt.Sprintf("%sから借りた『%s』の返却期限です。", library, book.Title)
+			writeICSEvent(&b, book.BookID+"-library@tundoku-killer", stamp, book.Library.DueDate, summary, description)
+		}
+	}
+	writeICSLine(&b, "END:VCALENDAR")
+	return b.String()
+}
+
+// writeICSEvent は on の日 (JST) の終日の予定を通知付きで書く
+func writeICSEvent(b *strings.Builder, uid, stamp string, on time.Time, summary, description string) {
+	day := on.In(cron.Location)
+	writeICSLine(b, "BEGIN:VEVENT")
+	writeICSLine(b, "UID:"+uid)
+	writeICSLine(b, "DTSTAMP:"+stamp)
+	writeICSLine(b, "DTSTART;VALUE=DATE:"+day.Format("20060102"))
+	writeICSLine(b, "DTEND;VALUE=DATE:"+day.AddDate(0, 0, 1).Format("20060102"))
+	writeICSLine(b, "SUMMARY:"+escapeICSText(summary))
+	writeICSLine(b, "DESCRIPTION:"+escapeICSText(description))
+	writeICSLine(b, "TRANSP:TRANSPARENT")
+	for _, alarm := range calendarAlarms {
+		writeICSLine(b, "BEGIN:VALARM")
+		writeICSLine(b, "ACTION:DISPLAY")
+		writeICSLine(b, "DESCRIPTION:"+escapeICSText(alarm.Description+": "+summary))
+		writeICSLine(b, "TRIGGER:"+alarm.Trigger)
+		writeICSLine(b, "END:VALARM")
+	}
+	writeICSLine(b, "END:VEVENT")
+}
+
+// escapeICSText は TEXT の値の \ ; , と改行をエスケープする
+func escapeICSText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(s)
+}
+
+// writeICSLine は1行を CRLF で書く。75オクテットを超える行は、UTF-8 の文字の途中で切らないように折り返す
+func writeICSLine(b *strings.Builder, line string) {
+	const maxOctets = 75
+	width := 0
+	for _, r := range line {
+		n := len(string(r))
+		if width+n > maxOctets {
+			b.WriteString("\r\n ")
+			width = 1
+		}
+		b.WriteRune(r)
+		width += n
+	}
+	b.WriteString("\r\n")
+}
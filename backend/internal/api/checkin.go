@@ -0,0 +1,247 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"tundoku-killer/backend/internal/cron"
+	"tundoku-killer/backend/internal/store"
+)
+
+// 毎晩のチェックイン (「今日は本Yを Xページ読んだ」。オーディオブックは「X分聞いた」)。読書の記録を保存し、連続して読んだ日数 (ストリーク) を進めて、
+// 一言を返す。API (POST /v1/checkin) か、LINE のクイックリプライ (Webhook の postback) で受け付ける。
+// ストリークは読書の記録 (SessionLogged) の購読者が readingStreaks/{userId} に数える
+
+// postbackCheckin はチェックインのクイックリプライの postback の data の action。
+// data は "action=checkin&bookId={bookId}&pages={pages}" (オーディオブックは pages の代わりに minutes={minutes})
+const postbackCheckin = "checkin"
+
+// ReadingStreak は読書の記録を付けた日の連続日数
+type ReadingStreak struct {
+	UserID  string `json:"userId" firestore:"userId"`
+	Current int    `json:"current" firestore:"current"`
+	Longest int    `json:"longest" firestore:"longest"`
+	LastDay string `json:"lastDay" firestore:"lastDay"` // 最後に記録した日 (JST の "2006-01-02")
+}
+
+// CheckIn はチェックインの結果
+type CheckIn struct {
+	Message   string         `json:"message"`
+	Session   ReadingSession `json:"session"`
+	Streak    ReadingStreak  `json:"streak"`
+	PagesRead int            `json:"pagesRead"`       // この本の読書の記録の合計 (オーディオブックなら 0)
+	Pages     int            `json:"pages,omitempty"` // この本のページ数 (分かれば)
+	// オーディオブックの聞いた分数の合計と、本の長さ (分かれば)
+	MinutesListened int `json:"minutesListened,omitempty"`
+	Minutes         int `json:"minutes,omitempty"`
+}
+
+// handleCheckin は今日読んだページ数 (オーディオブックは聞いた分数) を記録し、ストリークと一言を返す
+func (s *Server) handleCheckin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req checkinRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid JSON body: %v", err))
+		return
+	}
+	if err := req.Validate(); err != nil {
+		writeValidationError(w, r, err)
+		return
+	}
+	checkin, err := s.checkIn(r.Context(), req.UserID, req.BookID, req.Pages, req.Minutes)
+	if errors.Is(err, errBookCompleted) {
+		writeProblem(w, r, http.StatusConflict, "Book is already completed")
+		return
+	}
+	if err != nil {
+		writeBookError(w, r, err, "Failed to check in")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(checkin)
+}
+
+// errBookCompleted は読了済みの本にチェックインしようとしたときのエラー
+var errBookCompleted = errors.New("book is already completed")
+
+// checkIn は userID が bookID の本を今日 pages ページ (オーディオブックは minutes 分) 読んだと記録する
+func (s *Server) checkIn(ctx context.Context, userID, bookID string, pages, minutes int) (CheckIn, error) {
+	book, err := s.ownedBook(ctx, bookID, userID)
+	if err != nil {
+		return CheckIn{}, err
+	}
+	if book.Status == "completed" {
+		return CheckIn{}, errBookCompleted
+	}
+	if err := validateProgress(book, pages, minutes); err != nil {
+		return CheckIn{}, err
+	}
+	session, err := s.logReadingSession(ctx, book, pages, minutes)
+	if err != nil {
+		return CheckIn{}, err
+	}
+	checkin := CheckIn{Session: session, Pages: book.Pages}
+	if book.Audiobook() {
+		checkin.Pages, checkin.Minutes = 0, book.Minutes
+	}
+	// ストリークは SessionLogged の購読者が数え終えている (バスは同期)
+	if checkin.Streak, err = s.readingStreak(ctx, userID); err != nil {
+		s.logger.Printf("Error fetching streak for %s: %v", userID, err)
+	}
+	if sessions, err := s.readingSessions(ctx, userID); err != nil {
+		s.logger.Printf("Error fetching reading sessions for %s: %v", userID, err)
+	} else {
+		for _, past := range sessions {
+			if past.BookID != book.BookID {
+				continue
+			}
+			if book.Audiobook() {
+				checkin.MinutesListened += past.Minutes
+			} else {
+				checkin.PagesRead += past.Pages
+			}
+		}
+	}
+	checkin.Message = checkinMessage(book, checkin)
+	return checkin, nil
+}
+
+// checkinMessage はチェックインへの一言を作る
+func checkinMessage(book store.Book, c CheckIn) string {
+	var b strings.Builder
+	read := c.PagesRead
+	if book.Audiobook() {
+		read = c.MinutesListened
+		fmt.Fprintf(&b, "『%s』を%s聞きました。", book.Title, progressText(book, c.Session.Minutes))
+	} else {
+		fmt.Fprintf(&b, "『%s』を%dページ。", book.Title, c.Session.Pages)
+	}
+	switch {
+	case c.Streak.Current >= 2 && c.Streak.Current == c.Streak.Longest:
+		fmt.Fprintf(&b, "%d日連続、自己ベスト更新中です。", c.Streak.Current)
+	case c.Streak.Current >= 2:
+		fmt.Fprintf(&b, "%d日連続です (最長は%d日)。", c.Streak.Current, c.Streak.Longest)
+	default:
+		if book.Audiobook() {
+			b.WriteString("明日も聞けば、それが習慣の始まりです。")
+		} else {
+			b.WriteString("明日も読めば、それが習慣の始まりです。")
+		}
+	}
+	if length := book.Length(); length > 0 {
+		if left := length - read; left > 0 {
+			fmt.Fprintf(&b, "残り%s。", progressText(book, left))
+		} else if book.Audiobook() {
+			b.WriteString("もう最後まで聞いたはずです。読了にしてください。")
+		} else {
+			b.WriteString("もう最後まで読んだはずです。読了にしてください。")
+		}
+	}
+	return b.String()
+}
+
+// readingStreak は userID のストリークを返す。記録がなければゼロ値
+func (s *Server) readingStreak(ctx context.Context, userID string) (ReadingStreak, error) {
+	doc, err := s.firestoreClient.Collection("readingStreaks").Doc(userID).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return ReadingStreak{UserID: userID}, nil
+	}
+	if err != nil {
+		return ReadingStreak{}, fmt.Errorf("error fetching streak: %w", err)
+	}
+	var streak ReadingStreak
+	if err := doc.DataTo(&streak); err != nil {
+		return ReadingStreak{}, fmt.Errorf("error parsing streak: %w", err)
+	}
+	return streak, nil
+}
+
+// extendStreak は session を記録した日 (JST) をストリークに数える。同じ日の2回目以降は何もしない
+func (s *Server) extendStreak(ctx context.Context, session ReadingSession) {
+	day := session.ReadAt.In(cron.Location)
+	today := day.Format("2006-01-02")
+	yesterday := day.AddDate(0, 0, -1).Format("2006-01-02")
+	ref := s.firestoreClient.Collection("readingStreaks").Doc(session.UserID)
+	err := s.firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		streak := ReadingStreak{UserID: session.UserID}
+		doc, err := tx.Get(ref)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if err == nil {
+			if err := doc.DataTo(&streak); err != nil {
+				return err
+			}
+		}
+		switch streak.LastDay {
+		case today:
+			return nil
+		case yesterday:
+			streak.Current++
+		default:
+			streak.Current = 1
+		}
+		streak.LastDay = today
+		streak.Longest = max(streak.Longest, streak.Current)
+		return tx.Set(ref, streak)
+	})
+	if err != nil {
+		s.logger.Printf("Error extending streak for %s: %v", session.UserID, err)
+	}
+}
+
+// currentStreak は now (JST) の時点でまだ途切れていない連続日数を返す。今日か昨日に記録していなければ 0
+func currentStreak(streak ReadingStreak, now time.Time) int {
+	day := now.In(cron.Location)
+	if streak.LastDay == day.Format("2006-01-02") || streak.LastDay == day.AddDate(0, 0, -1).Format("2006-01-02") {
+		return streak.Current
+	}
+	return 0
+}
+
+// handleCheckinPostback は LINE のクイックリプライからのチェックインを処理し、一言を LINE で返す
+func (s *Server) handleCheckinPostback(ctx context.Context, lineUserID string, data url.Values) {
+	var pages, minutes int
+	if raw := data.Get("minutes"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > 24*60 {
+			s.logger.Printf("Ignoring check-in with invalid minutes %q", raw)
+			return
+		}
+		minutes = n
+	} else {
+		n, err := strconv.Atoi(data.Get("pages"))
+		if err != nil || n < 1 || n > maxPages {
+			s.logger.Printf("Ignoring check-in with invalid pages %q", data.Get("pages"))
+			return
+		}
+		pages = n
+	}
+	userID, err := s.linkedUserID(ctx, lineUserID)
+	if err != nil {
+		s.logger.Printf("Error resolving LINE user %s: %v", lineUserID, err)
+		return
+	}
+	checkin, err := s.checkIn(ctx, userID, data.Get("bookId"), pages, minutes)
+	if err != nil {
+		s.logger.Printf("Error checking in from LINE user %s: %v", lineUserID, err)
+		return
+	}
+	if err := s.sendLineMessage(ctx, lineUserID, checkin.Message); err != nil {
+		s.logger.Printf("Error replying to check-in from %s: %v", lineUserID, err)
+	}
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"tundoku-killer/backend/internal/store"
+)
+
+func TestCurrentStreak(t *testing.T) {
+	// 2024-06-16 01:00 JST (UTC ではまだ 15 日)
+	now := time.Date(2024, 6, 15, 16, 0, 0, 0, time.UTC)
+	tests := []struct {
+		lastDay string
+		want    int
+	}{
+		{"2024-06-16", 5}, // 今日
+		{"2024-06-15", 5}, // 昨日
+		{"2024-06-14", 0}, // 途切れた
+		{"", 0},
+	}
+	for _, tt := range tests {
+		if got := currentStreak(ReadingStreak{Current: 5, Longest: 8, LastDay: tt.lastDay}, now); got != tt.want {
+			t.Errorf("currentStreak(LastDay %q) = %d; want %d", tt.lastDay, got, tt.want)
+		}
+	}
+}
+
+func TestCheckinMessage(t *testing.T) {
+	paper := store.Book{Title: "三体", Pages: 300}
+	audio := store.Book{Title: "三体", Format: store.FormatAudiobook, Minutes: 600}
+	tests := []struct {
+		name    string
+		book    store.Book
+		checkin CheckIn
+		want    string
+	}{
+		{
+			name:    "自己ベスト更新中",
+			book:    paper,
+			checkin: CheckIn{Session: ReadingSession{Pages: 20}, Streak: ReadingStreak{Current: 3, Longest: 3}, PagesRead: 120},
+			want:    "『三体』を20ページ。3日連続、自己ベスト更新中です。残り180ページ。",
+		},
+		{
+			name:    "最長に届いていない",
+			book:    paper,
+			checkin: CheckIn{Session: ReadingSession{Pages: 20}, Streak: ReadingStreak{Current: 3, Longest: 5}, PagesRead: 300},
+			want:    "『三体』を20ページ。3日連続です (最長は5日)。もう最後まで読んだはずです。読了にしてください。",
+		},
+		{
+			name:    "ページ数が分からない",
+			book:    store.Book{Title: "三体"},
+			checkin: CheckIn{Session: ReadingSession{Pages: 20}, Streak: ReadingStreak{Current: 1, Longest: 4}},
+			want:    "『三体』を20ページ。明日も読めば、それが習慣の始まりです。",
+		},
+		{
+			name:    "オーディオブック",
+			book:    audio,
+			checkin: CheckIn{Session: ReadingSession{Minutes: 45}, Streak: ReadingStreak{Current: 1, Longest: 1}, MinutesListened: 510},
+			want:    "『三体』を45分聞きました。明日も聞けば、それが習慣の始まりです。残り1時間30分。",
+		},
+		{
+			name:    "オーディオブックを聞き終えた",
+			book:    audio,
+			checkin: CheckIn{Session: ReadingSession{Minutes: 120}, Streak: ReadingStreak{Current: 1, Longest: 1}, MinutesListened: 600},
+			want:    "『三体』を2時間聞きました。明日も聞けば、それが習慣の始まりです。もう最後まで聞いたはずです。読了にしてください。",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := checkinMessage(tt.book, tt.checkin); got != tt.want {
+				t.Errorf("checkinMessage = %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProgressText(t *testing.T) {
+	audio := store.Book{Format: store.FormatAudiobook}
+	tests := []struct {
+		book store.Book
+		n    int
+		want string
+	}{
+		{store.Book{}, 30, "30ページ"},
+		{store.Book{Format: store.FormatEbook}, 90, "90ページ"},
+		{audio, 45, "45分"},
+		{audio, 120, "2時間"},
+		{audio, 90, "1時間30分"},
+	}
+	for _, tt := range tests {
+		if got := progressText(tt.book, tt.n); got != tt.want {
+			t.Errorf("progressText(%q, %d) = %q; want %q", tt.book.Format, tt.n, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"tundoku-killer/backend/internal/store"
+)
+
+// shelfETag は userID の本棚のバージョンから ETag を作る。gzip の有無で本文のバイト列が変わるので弱い ETag にする
+func shelfETag(userID string, version store.ShelfVersion) string {
+	return fmt.Sprintf(`W/"%s-%d"`, userID, version.Version)
+}
+
+// notModified は ETag・Last-Modified を付け、If-None-Match (なければ If-Modified-Since) が一致すれば 304 を返して true を返す。
+// modified がゼロ値 (まだ1度も書き換えていない) なら Last-Modified は付けない
+func notModified(w http.ResponseWriter, r *http.Request, etag string, modified time.Time) bool {
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "private, no-cache")
+	if !modified.IsZero() {
+		w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if !etagMatches(inm, etag) {
+			return false
+		}
+	} else if ims := r.Header.Get("If-Modified-Since"); ims != "" && !modified.IsZero() {
+		since, err := http.ParseTime(ims)
+		if err != nil || modified.Truncate(time.Second).After(since) {
+			return false
+		}
+	} else {
+		return false
+	}
+
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// etagMatches は If-None-Match のいずれかが etag と弱い比較で一致するかを返す
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
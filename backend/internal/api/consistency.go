@@ -0,0 +1,191 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+
+	"tundoku-killer/backend/internal/store"
+	"tundoku-killer/backend/internal/validation"
+)
+
+// 本のドキュメントの整合性チェック。入力の検証が厳しくなる前に書かれた古いドキュメントには、
+// bookId の食い違いや未知のステータス、文字列のままの期限などが残っているため、それを洗い出して直す
+
+// maxReportedViolations はレスポンスに載せる違反の数
+const maxReportedViolations = 500
+
+// Violation は本のドキュメント1件の1項目の不整合
+type Violation struct {
+	BookID  string `json:"bookId"`
+	UserID  string `json:"userId,omitempty"`
+	Field   string `json:"field"`
+	Problem string `json:"problem"`
+	Fixable bool   `json:"fixable"`
+	Fixed   bool   `json:"fixed"`
+}
+
+// ConsistencyReport は整合性チェックの結果
+type ConsistencyReport struct {
+	Fix        bool        `json:"fix"`
+	Scanned    int         `json:"scanned"`
+	Violations int         `json:"violations"`
+	Fixed      int         `json:"fixed"`
+	Failed     int         `json:"failed"`
+	Items      []Violation `json:"items"` // 先頭の maxReportedViolations 件
+}
+
+// checkBookDoc は本のドキュメントの生のデータを検証し、違反と、直すための更新を返す
+func checkBookDoc(docID string, data map[string]interface{}) ([]Violation, []firestore.Update) {
+	var (
+		violations []Violation
+		updates    []firestore.Update
+	)
+	userID, _ := data["userId"].(string)
+	report := func(field, problem string, fix *firestore.Update) {
+		violations = append(violations, Violation{BookID: docID, UserID: userID, Field: field, Problem: problem, Fixable: fix != nil})
+		if fix != nil {
+			updates = append(updates, *fix)
+		}
+	}
+
+	if bookID, _ := data["bookId"].(string); bookID != docID {
+		report("bookId", "does not match the document ID", &firestore.Update{Path: "bookId", Value: docID})
+	}
+
+	if userID == "" {
+		report("userId", "is missing", nil)
+	}
+
+	status, _ := data["status"].(string)
+	if !slices.Contains(bookStatuses, status) {
+		// 読了日時があれば読み終えた本、なければ未読として扱う
+		fixed := "unread"
+		if _, ok := data["completedAt"].(time.Time); ok {
+			fixed = "completed"
+		}
+		report("status", "is not one of "+strings.Join(bookStatuses, ", "), &firestore.Update{Path: "status", Value: fixed})
+	}
+
+	switch deadline := data["deadline"].(type) {
+	case time.Time:
+		if deadline.IsZero() {
+			report("deadline", "is the zero time", nil)
+		}
+	case string:
+		// 文字列で保存された期限は、RFC 3339 として読めればタイムスタンプに直す
+		if t, err := time.Parse(time.RFC3339, deadline); err == nil {
+			report("deadline", "is stored as a string", &firestore.Update{Path: "deadline", Value: t})
+		} else {
+			report("deadline", "is not a valid timestamp", nil)
+		}
+	case nil:
+		report("deadline", "is missing", nil)
+	default:
+		report("deadline", "is not a timestamp", nil)
+	}
+
+	switch level := data["insultLevel"].(type) {
+	case int64:
+		if level < 0 || level > maxInsultLevel {
+			clamped := min(max(level, 0), maxInsultLevel)
+			report("insultLevel", "is out of range", &firestore.Update{Path: "insultLevel", Value: clamped})
+		}
+	case nil:
+		report("insultLevel", "is missing", &firestore.Update{Path: "insultLevel", Value: 0})
+	default:
+		report("insultLevel", "is not an integer", &firestore.Update{Path: "insultLevel", Value: 0})
+	}
+
+	return violations, updates
+}
+
+// handleCheckConsistency はすべての本のドキュメントを検証して違反を返す。?fix=true なら直せるものは直す。
+// Firestore のドキュメントの型を検査するので、本を SQL に保存しているときは 501
+func (s *Server) handleCheckConsistency(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if !s.requireFirestoreBooks(w, r) {
+		return
+	}
+
+	var v validation.Validator
+	v.OneOf("fix", r.URL.Query().Get("fix"), "", "true", "false")
+	if err := v.Err(); err != nil {
+		writeValidationError(w, r, err)
+		return
+	}
+	report := ConsistencyReport{Fix: r.URL.Query().Get("fix") == "true", Items: []Violation{}}
+
+	ctx := context.WithoutCancel(r.Context())
+	iter := s.firestoreClient.Collection("books").Documents(ctx)
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			writeServerError(w, r, err, "Failed to scan books")
+			return
+		}
+		report.Scanned++
+
+		violations, updates := checkBookDoc(doc.Ref.ID, doc.Data())
+		if len(violations) == 0 {
+			continue
+		}
+		report.Violations += len(violations)
+		if report.Fix && len(updates) > 0 {
+			if err := s.fixBookDoc(ctx, doc.Ref, violations[0].UserID, updates); err != nil {
+				s.logger.Printf("Error fixing book %s: %v", doc.Ref.ID, err)
+				report.Failed++
+			} else {
+				for i := range violations {
+					violations[i].Fixed = violations[i].Fixable
+				}
+				report.Fixed += len(updates)
+			}
+		}
+		for _, violation := range violations {
+			if len(report.Items) < maxReportedViolations {
+				report.Items = append(report.Items, violation)
+			}
+		}
+	}
+
+	s.logger.Printf("Consistency check (fix=%t): %d books scanned, %d violations, %d fixed, %d failed",
+		report.Fix, report.Scanned, report.Violations, report.Fixed, report.Failed)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// fixBookDoc は本のドキュメントを直接書き換え、キャッシュと本棚のバージョンを更新する
+func (s *Server) fixBookDoc(ctx context.Context, ref *firestore.DocumentRef, userID string, updates []firestore.Update) error {
+	if _, err := ref.Update(ctx, updates); err != nil {
+		return err
+	}
+	if userID != "" {
+		s.bookRewritten(ctx, userID, ref.ID)
+	}
+	return nil
+}
+
+// bookRewritten はリポジトリを通さずに書き換えた本のキャッシュを捨て、本棚のバージョンを進める
+func (s *Server) bookRewritten(ctx context.Context, userID, bookID string) {
+	if err := s.cache.Delete(ctx, store.BookKey(bookID), store.BookListKey(userID)); err != nil {
+		s.logger.Printf("Error invalidating cached book %s: %v", bookID, err)
+	}
+	if err := s.shelfVersions.Bump(ctx, userID); err != nil {
+		s.logger.Printf("Error bumping shelf version of %s: %v", userID, err)
+	}
+	s.invalidateStats(ctx, userID)
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"net/http"
+
+	"tundoku-killer/backend/internal/config"
+)
+
+// corsConfig は ALLOWED_ORIGINS などから読み込んだCORSの設定
+type corsConfig struct {
+	allowAll         bool            // ALLOWED_ORIGINS が "*" を含む (開発用)
+	allowedOrigins   map[string]bool // 許可するオリジン ("https://example.com" 形式)
+	allowCredentials bool            // CORS_ALLOW_CREDENTIALS=true で Cookie/Authorization 付きのリクエストを許可
+	production       bool            // APP_ENV=production なら許可されないオリジンに403を返す
+}
+
+// newCORSConfig は ALLOWED_ORIGINS などの設定からCORSの設定を作る
+func newCORSConfig(c config.CORSConfig, production bool) corsConfig {
+	cc := corsConfig{
+		allowedOrigins:   make(map[string]bool),
+		allowCredentials: c.AllowCredentials,
+		production:       production,
+	}
+	for _, origin := range c.AllowedOrigins {
+		if origin == "*" {
+			cc.allowAll = true
+			continue
+		}
+		cc.allowedOrigins[origin] = true
+	}
+	// 未設定ならどのオリジンも許可しない。設定し忘れて全許可にならないよう、開発でも "*" を明示する
+	return cc
+}
+
+// corsMiddleware はCORSヘッダーを追加するミドルウェア
+func (s *Server) corsMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		w.Header().Add("Vary", "Origin")
+
+		switch {
+		case origin == "":
+			// ブラウザ以外 (cron、サーバー間通信) からのリクエストはCORSの対象外
+		case s.cors.allowedOrigins[origin] || (s.cors.allowAll && s.cors.allowCredentials):
+			// 認証情報付きのリクエストでは "*" が使えないため、オリジンをそのまま返す
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			if s.cors.allowCredentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+		case s.cors.allowAll:
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		case s.cors.production:
+			s.logger.Printf("Rejected request from disallowed origin: %s", origin)
+			writeProblem(w, r, http.StatusForbidden, "Origin not allowed")
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
+		w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-API-Key, If-None-Match, If-Modified-Since")
+		w.Header().Set("Access-Control-Expose-Headers", "ETag, Last-Modified")
+
+		// プリフライトリクエスト (OPTIONS) の処理
+		if r.Method == "OPTIONS" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		next(w, r)
+	}
+}
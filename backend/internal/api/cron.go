@@ -0,0 +1,282 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/google/uuid"
+
+	"tundoku-killer/backend/internal/cron"
+	"tundoku-killer/backend/internal/store"
+)
+
+// cronSecret は CRON_SECRET を返す。ファイルや Secret Manager で回転していれば読み直した値
+func (s *Server) cronSecret() string {
+	if s.secrets == nil {
+		return s.cfg.Cron.Secret
+	}
+	return s.secrets.Getenv("CRON_SECRET")
+}
+
+// authorizeCron は Authorization ヘッダーが CRON_SECRET と一致するか確認する
+func (s *Server) authorizeCron(r *http.Request) bool {
+	cronSecret := s.cronSecret()
+	return cronSecret == "" || r.Header.Get("Authorization") == "Bearer "+cronSecret
+}
+
+// plannedInsult はドライランで返す、煽る予定の1冊分の計画
+type plannedInsult struct {
+	BookID      string    `json:"bookId"`
+	UserID      string    `json:"userId"`
+	Title       string    `json:"title"`
+	Deadline    time.Time `json:"deadline"`
+	InsultLevel int       `json:"insultLevel"`
+	Message     string    `json:"message"`           // 送信される煽り文のプレビュー。生成AIに書かせる種類は生成済みの文面か用意された煽り文
+	Variant     string    `json:"variant,omitempty"` // A/B テストの種類 (プレビューに使った種類)
+}
+
+// handleCheckDeadlinesDryRun は期限チェックを実行した場合に誰に何を送るかを返す。
+// ロックの取得・再開位置の保存・送信・ステータス更新・生成AIの呼び出しは一切行わない (煽り文は previewInsult のプレビュー)
+func (s *Server) handleCheckDeadlinesDryRun(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	now := time.Now()
+	cycle := cron.Cycle(now)
+	cursor := r.URL.Query().Get("cursor")
+
+	plan := []plannedInsult{}
+	scanned, skipped := 0, 0
+	done := false
+	for !done {
+		books, err := s.bookRepo.QueryOverdue(ctx, now, cursor, cron.PageSize)
+		if err != nil {
+			writeServerError(w, r, err, "Failed to query books")
+			return
+		}
+
+		for _, book := range books {
+			scanned++
+
+			if !isOverdue(book, now) {
+				continue
+			}
+			if book.LastInsultCycle == cycle {
+				skipped++
+				continue
+			}
+
+			generated, err := s.previewInsult(ctx, book)
+			if err != nil {
+				generated.Text = fmt.Sprintf("(error generating insult: %v)", err)
+			}
+			plan = append(plan, plannedInsult{
+				BookID:      book.BookID,
+				UserID:      book.UserID,
+				Title:       book.Title,
+				Deadline:    book.Deadline,
+				InsultLevel: book.InsultLevel,
+				Message:     generated.Text,
+				Variant:     generated.Variant,
+			})
+		}
+
+		if len(books) < cron.PageSize {
+			done = true
+			cursor = ""
+			break
+		}
+		cursor = books[len(books)-1].BookID
+		if time.Since(now) > cron.TimeBudget {
+			break
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"dryRun":  true,
+		"cycle":   cycle,
+		"scanned": scanned,
+		"skipped": skipped, // この周期で既に煽った本
+		"done":    done,
+		"cursor":  cursor,
+		"plan":    plan,
+	})
+}
+
+// handleCronRuns は直近のcron実行履歴を新しい順に返す (?limit= で件数指定、最大100)
+func (s *Server) handleCronRuns(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if !s.authorizeCron(r) {
+		writeProblem(w, r, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	ctx := r.Context()
+
+	limit := 20
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 100 {
+			writeProblem(w, r, http.StatusBadRequest, "limit must be between 1 and 100")
+			return
+		}
+		limit = n
+	}
+
+	docs, err := s.firestoreClient.Collection("cronRuns").
+		OrderBy("startedAt", firestore.Desc).
+		Limit(limit).
+		Documents(ctx).GetAll()
+	if err != nil {
+		writeServerError(w, r, err, "Failed to retrieve cron runs")
+		return
+	}
+
+	runs := []cron.Run{}
+	for _, doc := range docs {
+		var run cron.Run
+		if err := doc.DataTo(&run); err != nil {
+			s.logger.Printf("Error parsing cron run %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		runs = append(runs, run)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(runs)
+}
+
+// handleCheckDeadlines は定期的に実行され、期限切れの未読本をチェックする
+func (s *Server) handleCheckDeadlines(w http.ResponseWriter, r *http.Request) {
+	// 呼び出し元が切断しても実行を途中で打ち切らない (トレースは引き継ぐ)
+	ctx := context.WithoutCancel(r.Context())
+
+	// 簡易的な認証: 環境変数 CRON_SECRET と一致するか確認
+	if !s.authorizeCron(r) {
+		writeProblem(w, r, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	// ?dryRun=true の場合は送信も更新もせず、煽る予定の本と文面だけを返す
+	if r.URL.Query().Get("dryRun") == "true" {
+		s.handleCheckDeadlinesDryRun(w, r)
+		return
+	}
+
+	// 同時に複数のcronが走って二重送信しないよう、Firestore上のロックを取得する
+	runID := uuid.NewString()
+	if err := s.cron.AcquireLease(ctx, "cronCheck", runID, cron.LeaseTTL); err != nil {
+		if errors.Is(err, cron.ErrLeaseHeld) {
+			writeProblem(w, r, http.StatusConflict, "Another deadline check is already running")
+			return
+		}
+		writeServerError(w, r, err, "Failed to acquire lock")
+		return
+	}
+	defer s.cron.ReleaseLease(ctx, "cronCheck", runID)
+	// 生成AIを使った量を実行ごとに集計できるようにする
+	ctx = withCronRun(ctx, runID)
+
+	// 今回の周期。同じ周期で処理済みの本はスキップするので、何度呼ばれても安全
+	cycle := cron.Cycle(time.Now())
+
+	// 前回の実行が時間切れで途中終了していれば、その続きから再開する
+	cursor := r.URL.Query().Get("cursor")
+	if cursor == "" {
+		cursor = s.cron.LoadCursor(ctx, cycle)
+	}
+
+	// 実行結果は成功・失敗に関わらず cronRuns に記録する
+	startedAt := time.Now()
+	run := cron.Run{RunID: runID, Cycle: cycle, StartedAt: startedAt}
+	defer func() {
+		run.FinishedAt = time.Now()
+		s.cron.SaveRun(ctx, run)
+	}()
+
+	// 同期処理の場合、ステータス更新は BulkWriter でまとめて書き込む
+	var batch *cron.StatusBatch
+	if s.pubsubService == nil {
+		batch = cron.NewStatusBatch(s.bookRepo, s.logger)
+	}
+
+	// 煽り文の生成と送信は並列数・レートを制限したワーカープールで行う
+	pool := cron.NewPool(ctx, s.cfg.Cron.Concurrency, s.cfg.Cron.RateLimit, s.logger, func(ctx context.Context, book store.Book) error {
+		return s.dispatchOverdueBook(ctx, book, cycle, batch)
+	})
+
+	// ワーカーの終了とステータス更新の書き込みを待ち、結果を run に集計する
+	drain := func() {
+		run.Dispatched, run.Failed = pool.Wait()
+		if batch != nil {
+			if failed := batch.Flush(ctx); failed > 0 {
+				s.logger.Printf("%d status updates failed in this run", failed)
+				run.Failed += failed
+			}
+		}
+	}
+
+	// 期限切れの "unread" または "insulted" の本をページ単位で取得
+	for !run.Done {
+		books, err := s.bookRepo.QueryOverdue(ctx, startedAt, cursor, cron.PageSize)
+		if err != nil {
+			s.logger.Printf("Error querying books page after %q: %v", cursor, err)
+			drain()
+			s.cron.SaveCursor(ctx, cycle, cursor)
+			run.Error = err.Error()
+			writeServerError(w, r, err, "Failed to query books")
+			return
+		}
+
+		for _, book := range books {
+			run.Scanned++
+
+			// 今回の周期で既に煽った本はスキップ
+			if book.LastInsultCycle == cycle {
+				continue
+			}
+
+			// 期限切れチェック (インデックス未作成でフォールバックした場合と、ウィッシュリストの本のために必要)
+			if isOverdue(book, startedAt) {
+				s.logger.Printf("Found expired book: %s (ID: %s, User: %s, InsultLevel: %d)", book.Title, book.BookID, book.UserID, book.InsultLevel)
+				run.Expired++
+
+				// 煽り文の生成と送信は Pub/Sub のコンシューマ側で行う (未設定時はワーカーが処理)
+				pool.Submit(book)
+			}
+		}
+
+		if len(books) < cron.PageSize {
+			run.Done = true
+			cursor = ""
+			break
+		}
+		cursor = books[len(books)-1].BookID
+
+		// 時間切れが近ければ、続きは次回の呼び出しに回す
+		if time.Since(startedAt) > cron.TimeBudget {
+			break
+		}
+	}
+	drain()
+	s.cron.SaveCursor(ctx, cycle, cursor)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": fmt.Sprintf("Checked deadlines. Found %d expired books.", run.Expired),
+		"runId":   runID,
+		"scanned": run.Scanned,
+		"failed":  run.Failed,
+		"done":    run.Done,
+		"cursor":  cursor, // done=false の場合、?cursor= に渡すか再度呼び出せば続きから処理する
+	})
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"crypto/subtle"
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+)
+
+var startedAt = time.Now()
+
+// /debug/vars に標準の memstats・cmdline に加えて出す値
+func init() {
+	expvar.Publish("goroutines", expvar.Func(func() any { return runtime.NumGoroutine() }))
+	expvar.Publish("uptimeSeconds", expvar.Func(func() any { return int64(time.Since(startedAt).Seconds()) }))
+}
+
+// adminToken は ADMIN_TOKEN を返す。ファイルや Secret Manager で回転していれば読み直した値
+func (s *Server) adminToken() string {
+	if s.secrets == nil {
+		return s.cfg.AdminToken
+	}
+	return s.secrets.Getenv("ADMIN_TOKEN")
+}
+
+// requireAdmin は Authorization ヘッダーが ADMIN_TOKEN と一致するときだけ next を呼ぶ。
+// ADMIN_TOKEN が未設定ならエンドポイントがないものとして 404 を返す
+func (s *Server) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := s.adminToken()
+		if token == "" {
+			http.NotFound(w, r)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+			writeProblem(w, r, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// registerDebugRoutes は本番で cron や Firestore の走査が詰まったときに CPU・ヒーププロファイルを取るための
+// /debug/pprof と、ゴルーチン数やメモリの統計を返す /debug/vars を登録する。
+// 例: go tool pprof -http=: -H "Authorization: Bearer $ADMIN_TOKEN" https://.../debug/pprof/profile?seconds=20
+// (seconds は HTTP_WRITE_TIMEOUT より短くする)
+func (s *Server) registerDebugRoutes() {
+	s.mux.HandleFunc("/debug/pprof/", s.requireAdmin(pprof.Index))
+	s.mux.HandleFunc("/debug/pprof/cmdline", s.requireAdmin(pprof.Cmdline))
+	s.mux.HandleFunc("/debug/pprof/profile", s.requireAdmin(pprof.Profile))
+	s.mux.HandleFunc("/debug/pprof/symbol", s.requireAdmin(pprof.Symbol))
+	s.mux.HandleFunc("/debug/pprof/trace", s.requireAdmin(pprof.Trace))
+	s.mux.HandleFunc("/debug/vars", s.requireAdmin(expvar.Handler().ServeHTTP))
+}
@@ -0,0 +1,258 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"tundoku-killer/backend/internal/cron"
+	"tundoku-killer/backend/internal/store"
+	"tundoku-killer/backend/internal/validation"
+)
+
+// 本の読む順番 (「Aを読んでからB」)。store.Book.DependsOn に先に読む本のIDを持ち、そのうち読み終えていない本が
+// 残っている間は「ブロック中」として、次に読む本 (/v1/books/next) の候補から外す。依存が循環する設定は受け付けない。
+// 先に読む本より前に期限を設定したら、登録・設定の応答に警告を添える
+
+// maxDependencies は1冊に設定できる先に読む本の数
+const maxDependencies = 20
+
+var errDependencyCycle = errors.New("dependencies would form a cycle")
+
+// BlockedBook は先に読む本が読み終わっていない本
+type BlockedBook struct {
+	BookID    string   `json:"bookId"`
+	Title     string   `json:"title"`
+	BlockedBy []string `json:"blockedBy"` // 読み終えていない、先に読む本のID
+}
+
+// DeadlineWarning は先に読む本より前に期限を設定したときの警告
+type DeadlineWarning struct {
+	BookID   string    `json:"bookId"` // 先に読む本
+	Title    string    `json:"title"`
+	Deadline time.Time `json:"deadline"`
+	Message  string    `json:"message"`
+}
+
+// booksByID は本をIDで引けるようにする
+func booksByID(books []store.Book) map[string]store.Book {
+	byID := make(map[string]store.Book, len(books))
+	for _, book := range books {
+		byID[book.BookID] = book
+	}
+	return byID
+}
+
+// blockers は book の先に読む本のうち、読み終えていない本のIDを返す。削除された本と諦めた本は数えない
+func blockers(book store.Book, byID map[string]store.Book) []string {
+	ids := []string{}
+	for _, id := range book.DependsOn {
+		if dep, ok := byID[id]; ok && dep.Status != "completed" && !dep.Abandoned() {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// createsCycle は bookID の本の先に読む本を dependsOn にすると、依存をたどって bookID に戻るかを返す
+func createsCycle(byID map[string]store.Book, bookID string, dependsOn []string) bool {
+	seen := map[string]bool{}
+	stack := append([]string(nil), dependsOn...)
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if id == bookID {
+			return true
+		}
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		stack = append(stack, byID[id].DependsOn...)
+	}
+	return false
+}
+
+// deadlineWarnings は book の期限が、読み終えていない先に読む本の期限より前なら警告を返す
+func deadlineWarnings(book store.Book, byID map[string]store.Book) []DeadlineWarning {
+	warnings := []DeadlineWarning{}
+	for _, id := range blockers(book, byID) {
+		dep := byID[id]
+		if !dep.Deadline.After(book.Deadline) {
+			continue
+		}
+		warnings = append(warnings, DeadlineWarning{
+			BookID:   dep.BookID,
+			Title:    dep.Title,
+			Deadline: dep.Deadline,
+			Message: fmt.Sprintf("『%s』の期限 (%s) は、先に読む『%s』の期限 (%s) より前です。",
+				book.Title, book.Deadline.In(cron.Location).Format("1月2日"), dep.Title, dep.Deadline.In(cron.Location).Format("1月2日")),
+		})
+	}
+	return warnings
+}
+
+// checkDependencies は book の先に読む本を dependsOn にできるかを確かめる。
+// どれも book の所持者の本で、重複がなく、依存が循環しないこと
+func checkDependencies(books []store.Book, book store.Book, dependsOn []string) error {
+	byID := booksByID(books)
+	var v validation.Validator
+	seen := map[string]bool{}
+	for _, id := range dependsOn {
+		dep, ok := byID[id]
+		v.Check(ok && dep.UserID == book.UserID, "dependsOn", fmt.Sprintf("book %s is not on your shelf", id))
+		v.Check(!seen[id], "dependsOn", fmt.Sprintf("book %s is listed more than once", id))
+		seen[id] = true
+	}
+	if err := v.Err(); err != nil {
+		return err
+	}
+	if createsCycle(byID, book.BookID, dependsOn) {
+		return errDependencyCycle
+	}
+	return nil
+}
+
+// dependencyWarnings は登録した本や、ウィッシュリストから外した本の期限についての警告を返す。先に読む本がなければ読み込まない
+func (s *Server) dependencyWarnings(ctx context.Context, book store.Book) []DeadlineWarning {
+	if len(book.DependsOn) == 0 {
+		return nil
+	}
+	books, err := s.listBooks(ctx, book.UserID)
+	if err != nil {
+		s.logger.Printf("Error fetching books for %s: %v", book.UserID, err)
+		return nil
+	}
+	return deadlineWarnings(book, booksByID(books))
+}
+
+// handleBookDependencies は {id} の本の先に読む本を設定する (PUT)。空のリストで外す
+func (s *Server) handleBookDependencies(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req bookDependenciesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid JSON body: %v", err))
+		return
+	}
+	if err := req.Validate(); err != nil {
+		writeValidationError(w, r, err)
+		return
+	}
+	ctx := r.Context()
+	book, err := s.ownedBook(ctx, r.PathValue("id"), req.UserID)
+	if err != nil {
+		writeBookError(w, r, err, "Failed to retrieve book")
+		return
+	}
+	books, err := s.listBooks(ctx, req.UserID)
+	if err != nil {
+		writeServerError(w, r, err, "Failed to retrieve books")
+		return
+	}
+	if err := checkDependencies(books, book, req.DependsOn); err != nil {
+		writeBookError(w, r, err, "Failed to check dependencies")
+		return
+	}
+
+	dependsOn := append([]string{}, req.DependsOn...)
+	if err := s.bookRepo.Patch(ctx, book.BookID, store.BookPatch{DependsOn: &dependsOn}); err != nil {
+		writeBookError(w, r, err, "Failed to update dependencies")
+		return
+	}
+	updated := book
+	updated.DependsOn = req.DependsOn
+	eventBus.Publish(ctx, BookUpdated{Book: updated, Previous: book})
+
+	byID := booksByID(books)
+	byID[updated.BookID] = updated
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"book":      updated,
+		"blockedBy": blockers(updated, byID),
+		"warnings":  deadlineWarnings(updated, byID),
+	})
+}
+
+// handleNextBook は ?userId= の次に読む本を返す。ウィッシュリストの本とブロック中の本を除き、読書中の本、期限の近い本の順に選ぶ
+func (s *Server) handleNextBook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	userID := r.URL.Query().Get("userId")
+	if userID == "" {
+		writeProblem(w, r, http.StatusBadRequest, "userId query parameter is required")
+		return
+	}
+	books, err := s.listBooks(r.Context(), userID)
+	if err != nil {
+		writeServerError(w, r, err, "Failed to retrieve books")
+		return
+	}
+
+	next, blocked := readNext(books)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"next":    next, // 読める本がなければ null
+		"blocked": blocked,
+	})
+}
+
+// readNext は読み終えていない本から次に読む本と、ブロック中の本を返す
+func readNext(books []store.Book) (*store.Book, []BlockedBook) {
+	byID := booksByID(books)
+	var candidates []store.Book
+	blocked := []BlockedBook{}
+	for _, book := range books {
+		if book.Status == "completed" || book.Abandoned() || book.OnWishlist() {
+			continue
+		}
+		if ids := blockers(book, byID); len(ids) > 0 {
+			blocked = append(blocked, BlockedBook{BookID: book.BookID, Title: book.Title, BlockedBy: ids})
+			continue
+		}
+		candidates = append(candidates, book)
+	}
+	if len(candidates) == 0 {
+		return nil, blocked
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if ri, rj := candidates[i].Status == "reading", candidates[j].Status == "reading"; ri != rj {
+			return ri
+		}
+		return candidates[i].Deadline.Before(candidates[j].Deadline)
+	})
+	return &candidates[0], blocked
+}
+
+// dropDependency は本を消したとき、その本を先に読む本にしていた userID の本から外す
+func (s *Server) dropDependency(ctx context.Context, userID, bookID string) {
+	books, err := s.listBooks(ctx, userID)
+	if err != nil {
+		s.logger.Printf("Error fetching books for %s: %v", userID, err)
+		return
+	}
+	patches := map[string]store.BookPatch{}
+	for _, book := range books {
+		var kept []string
+		for _, id := range book.DependsOn {
+			if id != bookID {
+				kept = append(kept, id)
+			}
+		}
+		if len(kept) != len(book.DependsOn) {
+			kept = append([]string{}, kept...)
+			patches[book.BookID] = store.BookPatch{DependsOn: &kept}
+		}
+	}
+	for id, err := range s.bookRepo.PatchAll(ctx, patches) {
+		s.logger.Printf("Error dropping dependency %s from book %s: %v", bookID, id, err)
+	}
+}
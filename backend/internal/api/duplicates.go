@@ -0,0 +1,147 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+
+	"tundoku-killer/backend/internal/isbn"
+	"tundoku-killer/backend/internal/recommend"
+	"tundoku-killer/backend/internal/store"
+)
+
+// 同じ本の二重登録の警告。登録した本が本棚の本と同じ作品なら、紙の本と Kindle 版のように形式が違っても、
+// 登録はそのまま行い、POST /v1/books の応答の duplicate で知らせる。
+// ISBN が同じ (ISBN-10 と ISBN-13 は同じとみなす) か、レーベル・副題・版の注記を除いた書名と著者が同じなら同じ作品とみなす。
+// 巻数の違う本 (「(1)」と「(2)」) は別の本
+
+// 同じ作品とみなした理由
+const (
+	duplicateMatchISBN  = "isbn"  // ISBN が同じ (同じ版)
+	duplicateMatchTitle = "title" // 書名と著者が同じ (別の版や形式のことが多い)
+)
+
+// DuplicateBook は本棚にある、登録した本と同じ作品の本
+type DuplicateBook struct {
+	BookID    string `json:"bookId"`
+	Title     string `json:"title"`
+	Author    string `json:"author,omitempty"`
+	Format    string `json:"format,omitempty"`
+	Status    string `json:"status"`
+	Ownership string `json:"ownership,omitempty"`
+	Match     string `json:"match"` // duplicateMatchISBN か duplicateMatchTitle
+}
+
+// DuplicateWarning は同じ作品が本棚にあることの警告。登録の応答に添える
+type DuplicateWarning struct {
+	Message    string          `json:"message"`
+	Duplicates []DuplicateBook `json:"duplicates"`
+}
+
+// duplicateWarning は登録した book と同じ作品が本棚にあれば警告を返す。無いか調べられなければ nil (登録は済んでいるので止めない)
+func (s *Server) duplicateWarning(ctx context.Context, book store.Book) *DuplicateWarning {
+	if book.UserID == "" {
+		return nil
+	}
+	books, err := s.listBooks(ctx, book.UserID)
+	if err != nil {
+		s.logger.Printf("Error checking duplicates of book %s: %v", book.BookID, err)
+		return nil
+	}
+	duplicates := findDuplicates(books, book)
+	if len(duplicates) == 0 {
+		return nil
+	}
+	return &DuplicateWarning{Message: duplicateMessage(book, duplicates), Duplicates: duplicates}
+}
+
+// findDuplicates は books から book と同じ作品の本を探す。book 自身は除く
+func findDuplicates(books []store.Book, book store.Book) []DuplicateBook {
+	key := workKey(book.Title)
+	var duplicates []DuplicateBook
+	for _, b := range books {
+		if book.BookID != "" && b.BookID == book.BookID {
+			continue
+		}
+		match := ""
+		switch {
+		case book.ISBN != "" && isbn.Equal(b.ISBN, book.ISBN):
+			match = duplicateMatchISBN
+		case key != "" && workKey(b.Title) == key && sameAuthor(b.Author, book.Author):
+			match = duplicateMatchTitle
+		default:
+			continue
+		}
+		duplicates = append(duplicates, DuplicateBook{
+			BookID:    b.BookID,
+			Title:     b.Title,
+			Author:    b.Author,
+			Format:    b.Format,
+			Status:    b.Status,
+			Ownership: b.Ownership,
+			Match:     match,
+		})
+	}
+	return duplicates
+}
+
+// workKey は版や形式をまたいで書名を比べるためのキー。全角・半角をそろえ、レーベル・副題・版の注記を除く。
+// 注記と一緒に消える巻数は、書名に含まれる数字を後ろに足して残す
+func workKey(title string) string {
+	title = norm.NFKC.String(title)
+	base := kindleTitleKey(title, true)
+	if base == "" {
+		return ""
+	}
+	var digits strings.Builder
+	for _, r := range title {
+		if unicode.IsDigit(r) {
+			digits.WriteRune(r)
+		} else if digits.Len() > 0 && !strings.HasSuffix(digits.String(), "/") {
+			digits.WriteByte('/')
+		}
+	}
+	return base + "#" + strings.TrimSuffix(digits.String(), "/")
+}
+
+// sameAuthor は著者が同じかを返す。どちらかが分からなければ書名だけで判断する
+func sameAuthor(a, b string) bool {
+	a, b = recommend.Key(norm.NFKC.String(a)), recommend.Key(norm.NFKC.String(b))
+	return a == "" || b == "" || a == b
+}
+
+// duplicateMessage は同じ作品が本棚にあることを知らせる文面
+func duplicateMessage(book store.Book, duplicates []DuplicateBook) string {
+	d := duplicates[0]
+	where := "もう本棚にあります"
+	if label := formatLabel(d.Format); label != "" && d.Format != book.Format {
+		where = label + "でもう本棚にあります"
+	}
+	switch {
+	case d.Status == "completed":
+		where += " (読了済み)"
+	case d.Ownership == store.OwnershipWishlist:
+		where += " (ウィッシュリスト)"
+	case d.Status == "abandoned":
+		where += " (読むのをやめた本)"
+	default:
+		where += " (未読)"
+	}
+	return fmt.Sprintf("『%s』は%s。同じ本を2冊積むつもりですか。", d.Title, where)
+}
+
+// formatLabel は本の形式の表示名。分からなければ空
+func formatLabel(format string) string {
+	switch format {
+	case store.FormatPaper:
+		return "紙の本"
+	case store.FormatEbook:
+		return "電子書籍"
+	case store.FormatAudiobook:
+		return "オーディオブック"
+	}
+	return ""
+}
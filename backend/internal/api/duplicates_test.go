@@ -0,0 +1,131 @@
+package api
+
+import (
+	"strings"
+	"testing"
+
+	"tundoku-killer/backend/internal/store"
+)
+
+func TestFindDuplicates(t *testing.T) {
+	shelf := []store.Book{
+		{BookID: "paper", Title: "三体", Author: "劉 慈欣", ISBN: "9784152098702", Format: store.FormatPaper, Status: "unread"},
+		{BookID: "vol1", Title: "ワンピース (1)", Author: "尾田栄一郎", Status: "completed"},
+		{BookID: "other", Title: "火星の人", Author: "アンディ・ウィアー", Status: "unread"},
+	}
+	tests := []struct {
+		name  string
+		book  store.Book
+		want  []string
+		match string
+	}{
+		{
+			name:  "ISBN-10 と ISBN-13 は同じ",
+			book:  store.Book{Title: "三体 (ハヤカワ文庫SF)", ISBN: "4152098708"},
+			want:  []string{"paper"},
+			match: duplicateMatchISBN,
+		},
+		{
+			name:  "書名と著者が同じなら形式が違っても同じ作品",
+			book:  store.Book{Title: "三体", Author: "劉慈欣", Format: store.FormatEbook},
+			want:  []string{"paper"},
+			match: duplicateMatchTitle,
+		},
+		{
+			name:  "著者が分からなければ書名だけで判断する",
+			book:  store.Book{Title: "火星の人"},
+			want:  []string{"other"},
+			match: duplicateMatchTitle,
+		},
+		{
+			name: "著者が違えば別の本",
+			book: store.Book{Title: "火星の人", Author: "別の人"},
+		},
+		{
+			name: "巻数が違えば別の本",
+			book: store.Book{Title: "ワンピース (2)", Author: "尾田栄一郎"},
+		},
+		{
+			name:  "全角の巻数も同じ巻とみなす",
+			book:  store.Book{Title: "ワンピース （１）", Author: "尾田栄一郎"},
+			want:  []string{"vol1"},
+			match: duplicateMatchTitle,
+		},
+		{
+			name: "登録した本自身は除く",
+			book: shelf[0],
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := findDuplicates(shelf, tt.book)
+			var ids []string
+			for _, d := range got {
+				ids = append(ids, d.BookID)
+				if d.Match != tt.match {
+					t.Errorf("match of %s = %q; want %q", d.BookID, d.Match, tt.match)
+				}
+			}
+			if strings.Join(ids, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("findDuplicates = %v; want %v", ids, tt.want)
+			}
+		})
+	}
+}
+
+func TestWorkKey(t *testing.T) {
+	tests := []struct {
+		a, b string
+		same bool
+	}{
+		{"三体", "三体", true},
+		{"ワンピース 1", "ワンピース １", true},
+		{"ワンピース 1", "ワンピース 2", false},
+		{"三体", "三体II 黒暗森林", false},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		if got := workKey(tt.a) == workKey(tt.b); got != tt.same {
+			t.Errorf("workKey(%q) == workKey(%q) is %v (%q, %q); want %v", tt.a, tt.b, got, workKey(tt.a), workKey(tt.b), tt.same)
+		}
+	}
+}
+
+func TestDuplicateMessage(t *testing.T) {
+	tests := []struct {
+		name      string
+		book      store.Book
+		duplicate DuplicateBook
+		want      string
+	}{
+		{
+			name:      "同じ形式",
+			book:      store.Book{Format: store.FormatPaper},
+			duplicate: DuplicateBook{Title: "三体", Format: store.FormatPaper, Status: "unread"},
+			want:      "『三体』はもう本棚にあります (未読)。同じ本を2冊積むつもりですか。",
+		},
+		{
+			name:      "形式が違えば形式を添える",
+			book:      store.Book{Format: store.FormatEbook},
+			duplicate: DuplicateBook{Title: "三体", Format: store.FormatAudiobook, Status: "completed"},
+			want:      "『三体』はオーディオブックでもう本棚にあります (読了済み)。同じ本を2冊積むつもりですか。",
+		},
+		{
+			name:      "ウィッシュリスト",
+			duplicate: DuplicateBook{Title: "三体", Status: "unread", Ownership: store.OwnershipWishlist},
+			want:      "『三体』はもう本棚にあります (ウィッシュリスト)。同じ本を2冊積むつもりですか。",
+		},
+		{
+			name:      "読むのをやめた本",
+			duplicate: DuplicateBook{Title: "三体", Format: store.FormatPaper, Status: "abandoned"},
+			want:      "『三体』は紙の本でもう本棚にあります (読むのをやめた本)。同じ本を2冊積むつもりですか。",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := duplicateMessage(tt.book, []DuplicateBook{tt.duplicate}); got != tt.want {
+				t.Errorf("duplicateMessage = %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
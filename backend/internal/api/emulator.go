@@ -0,0 +1,109 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	firebase "firebase.google.com/go/v4"
+	"google.golang.org/api/option"
+
+	"tundoku-killer/backend/internal/config"
+	"tundoku-killer/backend/internal/store"
+)
+
+// ローカル開発用の Firebase エミュレーター対応。
+// FIRESTORE_EMULATOR_HOST があればサービスアカウントなしでエミュレーターにつなぐ
+// (FIREBASE_AUTH_EMULATOR_HOST もあればカスタムトークンも Auth エミュレーターで発行する)。
+// 起動とサンプルデータの投入は backend/Makefile の make emulators / make seed / make dev (データの生成は seed.go)
+
+// newFirebaseApp は Firebase App を作る。エミュレーター使用時はサービスアカウントを求めない
+// (GOOGLE_CLOUD_PROJECT が無ければ "demo-tundoku"。"demo-" で始まるIDなら本番のリソースに一切つながない)
+func newFirebaseApp(ctx context.Context, c config.FirebaseConfig) (*firebase.App, error) {
+	if c.UsingEmulator() {
+		log.Printf("Using Firestore emulator at %s (project %s)", c.EmulatorHost, c.ProjectID)
+		return firebase.NewApp(ctx, &firebase.Config{ProjectID: c.ProjectID}, option.WithoutAuthentication())
+	}
+	return firebase.NewApp(ctx, nil, option.WithCredentialsJSON([]byte(c.ServiceAccountKeyJSON)))
+}
+
+// seedUser はサンプルのユーザー1人分
+type seedUser struct {
+	userID      string
+	displayName string
+	settings    store.UserSettings
+}
+
+var seedUsers = []seedUser{
+	{userID: "demo-user-1", displayName: "積読太郎", settings: store.UserSettings{LeaderboardVisible: true, ShameWall: shameWallNamed}},
+	{userID: "demo-user-2", displayName: "読了花子", settings: store.UserSettings{LeaderboardVisible: true}},
+	{userID: "demo-user-3", displayName: "", settings: store.UserSettings{ShameWall: shameWallAnonymous}},
+}
+
+// seedBooks はユーザーごとのサンプルの本。期限は実行時点からの日数で、負なら期限切れ
+var seedBooks = []struct {
+	userID      string
+	title       string
+	author      string
+	days        int
+	status      string
+	insultLevel int
+	pages       int
+}{
+	{"demo-user-1", "カラマーゾフの兄弟", "ドストエフスキー", -30, "insulted", 5, 1200},
+	{"demo-user-1", "失われた時を求めて", "プルースト", -3, "unread", 0, 3000},
+	{"demo-user-1", "コンテナ物語", "マルク・レビンソン", 7, "unread", 0, 560},
+	{"demo-user-1", "リーダブルコード", "Dustin Boswell", -10, "completed", 1, 260},
+	{"demo-user-2", "こころ", "夏目漱石", 14, "reading", 0, 300},
+	{"demo-user-2", "銀河鉄道の夜", "宮沢賢治", -5, "completed", 0, 200},
+	{"demo-user-2", "サピエンス全史", "ユヴァル・ノア・ハラリ", -1, "unread", 0, 600},
+	{"demo-user-3", "ゲーデル、エッシャー、バッハ", "ダグラス・ホフスタッター", -90, "insulted", 12, 800},
+}
+
+// seedDemo はサンプルのユーザーと本を入れる。
+// IDを固定して上書きするので、何度実行しても同じ状態になる
+func (s *Server) seedDemo(ctx context.Context, now time.Time) error {
+	for _, u := range seedUsers {
+		if err := s.userRepo.SaveProfile(ctx, store.UserProfile{
+			UserID:      u.userID,
+			DisplayName: u.displayName,
+			Provider:    "line",
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		}); err != nil {
+			return err
+		}
+		settings := u.settings
+		settings.UserID = u.userID
+		settings.UpdatedAt = now
+		if err := s.userRepo.SaveSettings(ctx, settings); err != nil {
+			return err
+		}
+	}
+
+	for i, b := range seedBooks {
+		createdAt := now.AddDate(0, 0, b.days-30)
+		book := store.Book{
+			BookID:      fmt.Sprintf("demo-book-%d", i+1),
+			UserID:      b.userID,
+			Title:       b.title,
+			Author:      b.author,
+			Deadline:    now.AddDate(0, 0, b.days),
+			Status:      b.status,
+			InsultLevel: b.insultLevel,
+			Pages:       b.pages,
+			CreatedAt:   &createdAt,
+		}
+		if b.status == "completed" {
+			completedAt := book.Deadline.AddDate(0, 0, -1)
+			book.CompletedAt = &completedAt
+		}
+		if err := s.bookRepo.Update(ctx, book); err != nil {
+			return err
+		}
+	}
+
+	s.logger.Printf("Seeded %d demo users and %d books", len(seedUsers), len(seedBooks))
+	return nil
+}
@@ -0,0 +1,201 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"tundoku-killer/backend/internal/analytics"
+	"tundoku-killer/backend/internal/line"
+)
+
+// 煽り文への受け取った人の評価。煽り文のテンプレートや Gemini への指示を調整する材料にする。
+// API (POST /v1/insults/{id}/feedback) か、LINE で煽り文に付けたクイックリプライ (Webhook の postback) で受け付ける
+
+// 評価の値
+const (
+	feedbackHit       = "hit"       // 効いた
+	feedbackMiss      = "miss"      // 滑った
+	feedbackOffensive = "offensive" // 不快
+)
+
+// feedbackLabels はクイックリプライのボタンの表示 (評価の値の順)
+var feedbackLabels = []struct{ rating, label string }{
+	{feedbackHit, "効いた"},
+	{feedbackMiss, "滑った"},
+	{feedbackOffensive, "不快"},
+}
+
+// postbackInsultFeedback は評価のクイックリプライの postback の data の action
+const postbackInsultFeedback = "insultFeedback"
+
+var (
+	errInsultNotFound  = errors.New("insult not found")
+	errNotInsultTarget = errors.New("insult was sent to another user")
+)
+
+// InsultFeedback は煽り文1件への評価。insults/{id} の feedback に保存する (送り直せば上書き)
+type InsultFeedback struct {
+	Rating  string    `json:"rating" firestore:"rating"`
+	Comment string    `json:"comment,omitempty" firestore:"comment,omitempty"`
+	Via     string    `json:"via" firestore:"via"` // "api" か "line"
+	At      time.Time `json:"at" firestore:"at"`
+}
+
+// insultFeedbackQuickReply は煽り文のメッセージに付ける評価のクイックリプライ
+func insultFeedbackQuickReply(insultID string) map[string]interface{} {
+	actions := make([]map[string]interface{}, len(feedbackLabels))
+	for i, f := range feedbackLabels {
+		data := url.Values{"action": {postbackInsultFeedback}, "insultId": {insultID}, "rating": {f.rating}}
+		actions[i] = line.PostbackAction(f.label, data.Encode(), f.label)
+	}
+	return line.QuickReply(actions...)
+}
+
+// handleInsultFeedback は {id} の煽り文への評価を保存する
+func (s *Server) handleInsultFeedback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req insultFeedbackRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid JSON body: %v", err))
+		return
+	}
+	if err := req.Validate(); err != nil {
+		writeValidationError(w, r, err)
+		return
+	}
+
+	feedback := InsultFeedback{Rating: req.Rating, Comment: req.Comment, Via: "api", At: time.Now()}
+	err := s.saveInsultFeedback(r.Context(), r.PathValue("id"), req.UserID, feedback)
+	switch {
+	case errors.Is(err, errInsultNotFound):
+		writeProblem(w, r, http.StatusNotFound, "Insult not found")
+	case errors.Is(err, errNotInsultTarget):
+		writeProblem(w, r, http.StatusUnauthorized, "Unauthorized")
+	case err != nil:
+		writeServerError(w, r, err, "Failed to save feedback")
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(feedback)
+	}
+}
+
+// saveInsultFeedback は insultID の煽り文を userID が受け取っていれば評価を保存する。
+// userID は煽り文の所持者のIDか、所持者のアカウントにつないだ LINE のユーザーID (クイックリプライから来たとき)
+func (s *Server) saveInsultFeedback(ctx context.Context, insultID, userID string, feedback InsultFeedback) error {
+	if insultID == "" {
+		return errInsultNotFound
+	}
+	ref := s.firestoreClient.Collection("insults").Doc(insultID)
+	doc, err := ref.Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return errInsultNotFound
+	}
+	if err != nil {
+		return err
+	}
+	var rec InsultRecord
+	if err := doc.DataTo(&rec); err != nil {
+		return fmt.Errorf("error parsing insult %s: %w", insultID, err)
+	}
+	if rec.UserID != userID {
+		profile, err := s.getProfile(ctx, rec.UserID)
+		if err != nil {
+			return err
+		}
+		if profile.LineUserID == "" || profile.LineUserID != userID {
+			return errNotInsultTarget
+		}
+	}
+
+	if _, err := ref.Update(ctx, []firestore.Update{{Path: "feedback", Value: feedback}}); err != nil {
+		return err
+	}
+	s.logger.Printf("Feedback %q on insult %s (variant %q) via %s", feedback.Rating, insultID, rec.Variant, feedback.Via)
+	s.analytics.Emit(analytics.Event{Name: analytics.InsultFeedback, UserID: rec.UserID, BookID: rec.BookID, Props: map[string]interface{}{
+		"insultId": insultID,
+		"rating":   feedback.Rating,
+		"variant":  rec.Variant,
+		"via":      feedback.Via,
+	}})
+	return nil
+}
+
+// handleLineWebhook は LINE プラットフォームからの Webhook を受け取る。
+// X-Line-Signature を LINE_CHANNEL_SECRET で検証し、評価とチェックインのクイックリプライの postback だけを処理する
+func (s *Server) handleLineWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	secret := s.lineChannelSecret()
+	if secret == "" {
+		writeProblem(w, r, http.StatusNotImplemented, "LINE_CHANNEL_SECRET is not configured")
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, "Failed to read body")
+		return
+	}
+	if !line.VerifySignature(secret, body, r.Header.Get("X-Line-Signature")) {
+		writeProblem(w, r, http.StatusUnauthorized, "Invalid signature")
+		return
+	}
+	var req line.WebhookRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid JSON body: %v", err))
+		return
+	}
+
+	// 処理できなかったイベントもログに残して 200 を返す (LINE は失敗しても再送しない)
+	ctx := context.WithoutCancel(r.Context())
+	for _, event := range req.Events {
+		if event.Type != "postback" {
+			continue
+		}
+		data, err := url.ParseQuery(event.Postback.Data)
+		if err != nil {
+			continue
+		}
+		if data.Get("action") == postbackCheckin {
+			s.handleCheckinPostback(ctx, event.Source.UserID, data)
+			continue
+		}
+		if data.Get("action") != postbackInsultFeedback {
+			continue
+		}
+		rating := data.Get("rating")
+		if rating != feedbackHit && rating != feedbackMiss && rating != feedbackOffensive {
+			s.logger.Printf("Ignoring insult feedback with unknown rating %q", rating)
+			continue
+		}
+		feedback := InsultFeedback{Rating: rating, Via: "line", At: time.UnixMilli(event.Timestamp)}
+		if err := s.saveInsultFeedback(ctx, data.Get("insultId"), event.Source.UserID, feedback); err != nil {
+			s.logger.Printf("Error saving insult feedback from LINE user %s: %v", event.Source.UserID, err)
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// lineChannelSecret は LINE_CHANNEL_SECRET の最新の値を返す
+func (s *Server) lineChannelSecret() string {
+	if s.secrets == nil {
+		return s.cfg.LINE.ChannelSecret
+	}
+	return s.secrets.Getenv("LINE_CHANNEL_SECRET")
+}
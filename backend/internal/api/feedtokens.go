@@ -0,0 +1,153 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// 外部のアプリ (カレンダー・フィードリーダーなど) に登録する、トークン付きの URL のフィード。
+// トークンはフィードの種類ごとのコレクション ({collection}/{token}) に保存し、1人1つ。作り直すと古い URL は使えなくなる
+
+var errFeedNotFound = errors.New("feed not found")
+
+// FeedToken はフィードのトークン
+type FeedToken struct {
+	Token     string    `json:"token" firestore:"token"`
+	UserID    string    `json:"userId" firestore:"userId"`
+	URL       string    `json:"url,omitempty" firestore:"-"` // PUBLIC_BASE_URL があれば、アプリに登録する URL
+	CreatedAt time.Time `json:"createdAt" firestore:"createdAt"`
+}
+
+// handleFeedToken は collection のトークンの取得 (GET ?userId=)・発行 (POST)・取り消し (DELETE ?userId=) を行う。
+// feedPath はフィードそのもののパス ("/calendar.ics" など)。POST すると古いトークンは使えなくなる
+func (s *Server) handleFeedToken(w http.ResponseWriter, r *http.Request, collection, feedPath string) {
+	switch r.Method {
+	case http.MethodGet:
+		userID := r.URL.Query().Get("userId")
+		if userID == "" {
+			writeProblem(w, r, http.StatusBadRequest, "userId query parameter is required")
+			return
+		}
+		tokens, err := s.feedTokens(r.Context(), collection, userID)
+		if err != nil {
+			writeServerError(w, r, err, "Failed to retrieve feed")
+			return
+		}
+		if len(tokens) == 0 {
+			writeProblem(w, r, http.StatusNotFound, "Feed not found")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.withFeedURL(tokens[0], feedPath))
+
+	case http.MethodPost:
+		var req feedTokenRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid JSON body: %v", err))
+			return
+		}
+		if err := req.Validate(); err != nil {
+			writeValidationError(w, r, err)
+			return
+		}
+		raw := make([]byte, 16)
+		if _, err := rand.Read(raw); err != nil {
+			writeServerError(w, r, err, "Failed to generate feed token")
+			return
+		}
+		ctx := r.Context()
+		if err := s.revokeFeedTokens(ctx, collection, req.UserID); err != nil {
+			writeServerError(w, r, err, "Failed to revoke feed")
+			return
+		}
+		token := FeedToken{Token: hex.EncodeToString(raw), UserID: req.UserID, CreatedAt: time.Now()}
+		if _, err := s.firestoreClient.Collection(collection).Doc(token.Token).Set(ctx, token); err != nil {
+			writeServerError(w, r, err, "Failed to save feed")
+			return
+		}
+		s.logger.Printf("Feed %s issued for %s", collection, req.UserID)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(s.withFeedURL(token, feedPath))
+
+	case http.MethodDelete:
+		userID := r.URL.Query().Get("userId")
+		if userID == "" {
+			writeProblem(w, r, http.StatusBadRequest, "userId query parameter is required")
+			return
+		}
+		if err := s.revokeFeedTokens(r.Context(), collection, userID); err != nil {
+			writeServerError(w, r, err, "Failed to revoke feed")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// withFeedURL は PUBLIC_BASE_URL があれば、アプリに登録する URL を付ける
+func (s *Server) withFeedURL(token FeedToken, feedPath string) FeedToken {
+	if s.cfg.PublicBaseURL != "" {
+		token.URL = s.cfg.PublicBaseURL + apiVersionPrefix + feedPath + "?token=" + token.Token
+	}
+	return token
+}
+
+// feedTokens は userID の collection のトークンを返す
+func (s *Server) feedTokens(ctx context.Context, collection, userID string) ([]FeedToken, error) {
+	docs, err := s.firestoreClient.Collection(collection).Where("userId", "==", userID).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("error fetching feed tokens: %w", err)
+	}
+	tokens := make([]FeedToken, 0, len(docs))
+	for _, doc := range docs {
+		var token FeedToken
+		if err := doc.DataTo(&token); err != nil {
+			s.logger.Printf("Error parsing feed token %s/%s: %v", collection, doc.Ref.ID, err)
+			continue
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, nil
+}
+
+// revokeFeedTokens は userID の collection のトークンをすべて消す
+func (s *Server) revokeFeedTokens(ctx context.Context, collection, userID string) error {
+	tokens, err := s.feedTokens(ctx, collection, userID)
+	if err != nil {
+		return err
+	}
+	for _, token := range tokens {
+		if _, err := s.firestoreClient.Collection(collection).Doc(token.Token).Delete(ctx); err != nil {
+			return fmt.Errorf("error deleting feed token: %w", err)
+		}
+	}
+	return nil
+}
+
+// feedOwner は collection のトークンの持ち主を返す。無ければ errFeedNotFound
+func (s *Server) feedOwner(ctx context.Context, collection, token string) (string, error) {
+	doc, err := s.firestoreClient.Collection(collection).Doc(token).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return "", errFeedNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("error fetching feed token: %w", err)
+	}
+	var feed FeedToken
+	if err := doc.DataTo(&feed); err != nil {
+		return "", fmt.Errorf("error parsing feed token: %w", err)
+	}
+	return feed.UserID, nil
+}
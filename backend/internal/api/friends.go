@@ -0,0 +1,536 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"tundoku-killer/backend/internal/cron"
+	"tundoku-killer/backend/internal/store"
+)
+
+// 友達と、期限切れの通知のコピーを受け取る「見張り役 (accountability partner)」。
+// 友達になるには、相手が申請を承認するか、招待リンクを開いて承認する。
+// 見張り役も、頼まれた側が承認して初めて通知が届く
+
+const (
+	friendshipPending  = "pending"
+	friendshipAccepted = "accepted"
+
+	// friendInviteTTL は招待リンクの有効期限
+	friendInviteTTL = 7 * 24 * time.Hour
+)
+
+var (
+	errFriendshipNotFound = errors.New("friendship not found")
+	errNotFriends         = errors.New("users are not friends")
+)
+
+// Friendship は2人のユーザーのつながり。friendships/{小さい方のID}__{大きい方のID} に保存する。
+// Users は常に2人分で、array-contains でどちらの側からも引けるようにしている
+type Friendship struct {
+	Users       []string   `json:"users" firestore:"users"`
+	Status      string     `json:"status" firestore:"status"` // "pending" か "accepted"
+	RequestedBy string     `json:"requestedBy" firestore:"requestedBy"`
+	CreatedAt   time.Time  `json:"createdAt" firestore:"createdAt"`
+	AcceptedAt  *time.Time `json:"acceptedAt,omitempty" firestore:"acceptedAt,omitempty"`
+	// PartnerRequests は相手に見張り役を頼んで、まだ承認されていないユーザー
+	PartnerRequests []string `json:"partnerRequests" firestore:"partnerRequests"`
+	// Partners は相手が見張り役を承認したユーザー。このユーザーの期限切れの通知が相手にも届く
+	Partners []string `json:"partners" firestore:"partners"`
+}
+
+// other は f の userID ではない方のユーザーを返す
+func (f Friendship) other(userID string) string {
+	for _, id := range f.Users {
+		if id != userID {
+			return id
+		}
+	}
+	return ""
+}
+
+// FriendInvite は招待リンクのトークン。friendInvites/{token} に保存し、1度使ったら消す
+type FriendInvite struct {
+	UserID    string    `json:"userId" firestore:"userId"`
+	CreatedAt time.Time `json:"createdAt" firestore:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt" firestore:"expiresAt"` // FirestoreのTTLポリシーの対象フィールド
+}
+
+// Friend は友達一覧の1行
+type Friend struct {
+	UserID      string `json:"userId"`
+	DisplayName string `json:"displayName"`
+	Status      string `json:"status"`
+	Incoming    bool   `json:"incoming"` // 相手からの未承認の申請
+	// 自分が相手の見張り役か / 相手が自分の見張り役か ("requested" は承認待ち)
+	WatchingThem string `json:"watchingThem,omitempty"`
+	WatchingMe   string `json:"watchingMe,omitempty"`
+}
+
+func (s *Server) friendshipRef(a, b string) *firestore.DocumentRef {
+	users := []string{a, b}
+	sort.Strings(users)
+	return s.firestoreClient.Collection("friendships").Doc(users[0] + "__" + users[1])
+}
+
+// friendIDs は userID と友達になっているユーザーを返す
+func (s *Server) friendIDs(ctx context.Context, userID string) ([]string, error) {
+	docs, err := s.firestoreClient.Collection("friendships").
+		Where("users", "array-contains", userID).
+		Where("status", "==", friendshipAccepted).
+		Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("error fetching friendships: %w", err)
+	}
+
+	var ids []string
+	for _, doc := range docs {
+		var f Friendship
+		if err := doc.DataTo(&f); err != nil {
+			continue
+		}
+		ids = append(ids, f.other(userID))
+	}
+	return ids, nil
+}
+
+// handleFriends は友達の一覧 (GET ?userId=) と解除・申請の取り消し (DELETE) を行う
+func (s *Server) handleFriends(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.handleListFriends(w, r)
+	case http.MethodDelete:
+		var req friendRequest
+		if !decodeFriendRequest(w, r, &req) {
+			return
+		}
+		if _, err := s.friendshipRef(req.UserID, req.FriendID).Delete(r.Context()); err != nil {
+			writeServerError(w, r, err, "Failed to remove friend")
+			return
+		}
+		s.logger.Printf("Friendship removed: %s - %s", req.UserID, req.FriendID)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+func (s *Server) handleListFriends(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := r.URL.Query().Get("userId")
+	if userID == "" {
+		writeProblem(w, r, http.StatusBadRequest, "userId query parameter is required")
+		return
+	}
+
+	docs, err := s.firestoreClient.Collection("friendships").
+		Where("users", "array-contains", userID).
+		Documents(ctx).GetAll()
+	if err != nil {
+		writeServerError(w, r, err, "Failed to retrieve friends")
+		return
+	}
+
+	friends := []Friend{}
+	for _, doc := range docs {
+		var f Friendship
+		if err := doc.DataTo(&f); err != nil {
+			s.logger.Printf("Error parsing friendship %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		otherID := f.other(userID)
+		settings, err := s.getSettings(ctx, otherID)
+		if err != nil {
+			s.logger.Printf("Error fetching settings for %s: %v", otherID, err)
+		}
+
+		friend := Friend{
+			UserID:      otherID,
+			DisplayName: settings.Name(),
+			Status:      f.Status,
+			Incoming:    f.Status == friendshipPending && f.RequestedBy != userID,
+		}
+		switch {
+		case slices.Contains(f.Partners, otherID):
+			friend.WatchingThem = "active"
+		case slices.Contains(f.PartnerRequests, otherID):
+			friend.WatchingThem = "requested"
+		}
+		switch {
+		case slices.Contains(f.Partners, userID):
+			friend.WatchingMe = "active"
+		case slices.Contains(f.PartnerRequests, userID):
+			friend.WatchingMe = "requested"
+		}
+		friends = append(friends, friend)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(friends)
+}
+
+// handleFriendRequest は userId から friendId への友達申請を作る。相手から申請が来ていれば承認する
+func (s *Server) handleFriendRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req friendRequest
+	if !decodeFriendRequest(w, r, &req) {
+		return
+	}
+
+	ref := s.friendshipRef(req.UserID, req.FriendID)
+	var result Friendship
+	err := s.firestoreClient.RunTransaction(r.Context(), func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if err == nil {
+			if err := doc.DataTo(&result); err != nil {
+				return err
+			}
+			// 相手からの申請が来ていれば、申請し返したことを承認とみなす
+			if result.Status == friendshipPending && result.RequestedBy != req.UserID {
+				return acceptFriendship(tx, ref, &result)
+			}
+			return nil
+		}
+
+		result = Friendship{
+			Users:       []string{req.UserID, req.FriendID},
+			Status:      friendshipPending,
+			RequestedBy: req.UserID,
+			CreatedAt:   time.Now(),
+		}
+		return tx.Set(ref, result)
+	})
+	if err != nil {
+		writeServerError(w, r, err, "Failed to send friend request")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// handleAcceptFriend は friendId から userId への友達申請を承認する
+func (s *Server) handleAcceptFriend(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req friendRequest
+	if !decodeFriendRequest(w, r, &req) {
+		return
+	}
+
+	ref := s.friendshipRef(req.UserID, req.FriendID)
+	var result Friendship
+	err := s.firestoreClient.RunTransaction(r.Context(), func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound {
+			return errFriendshipNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if err := doc.DataTo(&result); err != nil {
+			return err
+		}
+		// 自分が出した申請は自分では承認できない
+		if result.Status != friendshipPending || result.RequestedBy == req.UserID {
+			return errFriendshipNotFound
+		}
+		return acceptFriendship(tx, ref, &result)
+	})
+	if errors.Is(err, errFriendshipNotFound) {
+		writeProblem(w, r, http.StatusNotFound, "No pending friend request from this user")
+		return
+	}
+	if err != nil {
+		writeServerError(w, r, err, "Failed to accept friend request")
+		return
+	}
+
+	s.logger.Printf("Friendship accepted: %s - %s", req.UserID, req.FriendID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func acceptFriendship(tx *firestore.Transaction, ref *firestore.DocumentRef, f *Friendship) error {
+	now := time.Now()
+	f.Status = friendshipAccepted
+	f.AcceptedAt = &now
+	return tx.Set(ref, *f)
+}
+
+// handleFriendInvites は userId の招待リンク用のトークンを発行する
+func (s *Server) handleFriendInvites(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req createInviteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid JSON body: %v", err))
+		return
+	}
+	if err := req.Validate(); err != nil {
+		writeValidationError(w, r, err)
+		return
+	}
+
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		writeServerError(w, r, err, "Failed to generate invite token")
+		return
+	}
+	token := hex.EncodeToString(raw)
+	now := time.Now()
+	invite := FriendInvite{UserID: req.UserID, CreatedAt: now, ExpiresAt: now.Add(friendInviteTTL)}
+	if _, err := s.firestoreClient.Collection("friendInvites").Doc(token).Set(r.Context(), invite); err != nil {
+		writeServerError(w, r, err, "Failed to save invite")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"token":     token,
+		"expiresAt": invite.ExpiresAt,
+	})
+}
+
+// handleAcceptFriendInvite は招待リンクを開いた userId を、招待したユーザーと友達にする。
+// 招待した側はリンクを発行した時点で同意しているので、すぐに承認済みになる
+func (s *Server) handleAcceptFriendInvite(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req acceptInviteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid JSON body: %v", err))
+		return
+	}
+	if err := req.Validate(); err != nil {
+		writeValidationError(w, r, err)
+		return
+	}
+
+	inviteRef := s.firestoreClient.Collection("friendInvites").Doc(req.Token)
+	var result Friendship
+	err := s.firestoreClient.RunTransaction(r.Context(), func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(inviteRef)
+		if status.Code(err) == codes.NotFound {
+			return errFriendshipNotFound
+		}
+		if err != nil {
+			return err
+		}
+		var invite FriendInvite
+		if err := doc.DataTo(&invite); err != nil {
+			return err
+		}
+		if invite.ExpiresAt.Before(time.Now()) || invite.UserID == req.UserID {
+			return errFriendshipNotFound
+		}
+
+		ref := s.friendshipRef(invite.UserID, req.UserID)
+		existing, err := tx.Get(ref)
+		if err == nil {
+			if err := existing.DataTo(&result); err != nil {
+				return err
+			}
+		} else if status.Code(err) != codes.NotFound {
+			return err
+		} else {
+			result = Friendship{
+				Users:       []string{invite.UserID, req.UserID},
+				RequestedBy: invite.UserID,
+				CreatedAt:   time.Now(),
+			}
+		}
+		if err := acceptFriendship(tx, ref, &result); err != nil {
+			return err
+		}
+		return tx.Delete(inviteRef)
+	})
+	if errors.Is(err, errFriendshipNotFound) {
+		writeProblem(w, r, http.StatusNotFound, "Invite not found or expired")
+		return
+	}
+	if err != nil {
+		writeServerError(w, r, err, "Failed to accept invite")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// handlePartner は見張り役の依頼 (POST: userId が friendId に頼む) と解除 (DELETE) を行う。
+// 解除は、自分の見張り役をやめてもらう場合と、自分が相手の見張り役を降りる場合の両方に効く
+func (s *Server) handlePartner(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req friendRequest
+	if !decodeFriendRequest(w, r, &req) {
+		return
+	}
+
+	err := s.updateFriendship(r.Context(), req.UserID, req.FriendID, func(f *Friendship) error {
+		if r.Method == http.MethodDelete {
+			f.Partners = slices.DeleteFunc(f.Partners, func(id string) bool { return id == req.UserID || id == req.FriendID })
+			f.PartnerRequests = slices.DeleteFunc(f.PartnerRequests, func(id string) bool { return id == req.UserID || id == req.FriendID })
+			return nil
+		}
+		if !slices.Contains(f.Partners, req.UserID) && !slices.Contains(f.PartnerRequests, req.UserID) {
+			f.PartnerRequests = append(f.PartnerRequests, req.UserID)
+		}
+		return nil
+	})
+	if writeFriendshipError(w, r, err) {
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAcceptPartner は friendId からの見張り役の依頼を userId が承認する。以降 friendId の期限切れが userId にも届く
+func (s *Server) handleAcceptPartner(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req friendRequest
+	if !decodeFriendRequest(w, r, &req) {
+		return
+	}
+
+	err := s.updateFriendship(r.Context(), req.UserID, req.FriendID, func(f *Friendship) error {
+		if !slices.Contains(f.PartnerRequests, req.FriendID) {
+			return errFriendshipNotFound
+		}
+		f.PartnerRequests = slices.DeleteFunc(f.PartnerRequests, func(id string) bool { return id == req.FriendID })
+		f.Partners = append(f.Partners, req.FriendID)
+		return nil
+	})
+	if writeFriendshipError(w, r, err) {
+		return
+	}
+	s.logger.Printf("%s is now the accountability partner of %s", req.UserID, req.FriendID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// updateFriendship は承認済みの友達関係をトランザクションで読み、update で書き換えて保存する
+func (s *Server) updateFriendship(ctx context.Context, userID, friendID string, update func(*Friendship) error) error {
+	ref := s.friendshipRef(userID, friendID)
+	return s.firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound {
+			return errNotFriends
+		}
+		if err != nil {
+			return err
+		}
+		var f Friendship
+		if err := doc.DataTo(&f); err != nil {
+			return err
+		}
+		if f.Status != friendshipAccepted {
+			return errNotFriends
+		}
+		if err := update(&f); err != nil {
+			return err
+		}
+		return tx.Set(ref, f)
+	})
+}
+
+// writeFriendshipError は err があればレスポンスを書いて true を返す
+func writeFriendshipError(w http.ResponseWriter, r *http.Request, err error) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, errNotFriends):
+		writeProblem(w, r, http.StatusConflict, "Users are not friends")
+	case errors.Is(err, errFriendshipNotFound):
+		writeProblem(w, r, http.StatusNotFound, "No pending partner request from this user")
+	default:
+		writeServerError(w, r, err, "Failed to update friendship")
+	}
+	return true
+}
+
+func decodeFriendRequest(w http.ResponseWriter, r *http.Request, req *friendRequest) bool {
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid JSON body: %v", err))
+		return false
+	}
+	if err := req.Validate(); err != nil {
+		writeValidationError(w, r, err)
+		return false
+	}
+	return true
+}
+
+// partnerIDs は userID の見張り役 (承認済み) を返す
+func (s *Server) partnerIDs(ctx context.Context, userID string) ([]string, error) {
+	docs, err := s.firestoreClient.Collection("friendships").
+		Where("partners", "array-contains", userID).
+		Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("error fetching partners: %w", err)
+	}
+
+	var ids []string
+	for _, doc := range docs {
+		var f Friendship
+		if err := doc.DataTo(&f); err != nil || f.Status != friendshipAccepted {
+			continue
+		}
+		ids = append(ids, f.other(userID))
+	}
+	return ids, nil
+}
+
+// notifyPartners は owner の本の期限切れを、owner の見張り役にもLINEで知らせる
+func (s *Server) notifyPartners(ctx context.Context, book store.Book) {
+	partners, err := s.partnerIDs(ctx, book.UserID)
+	if err != nil {
+		s.logger.Printf("Error fetching partners of %s: %v", book.UserID, err)
+		return
+	}
+	if len(partners) == 0 {
+		return
+	}
+
+	settings, err := s.getSettings(ctx, book.UserID)
+	if err != nil {
+		s.logger.Printf("Error fetching settings for %s: %v", book.UserID, err)
+	}
+	message := fmt.Sprintf("ご友人の%sさん、また期限を破りました。『%s』の期限は%sでした。",
+		settings.Name(), book.Title, book.Deadline.In(cron.Location).Format("1月2日"))
+
+	for _, partnerID := range partners {
+		if err := s.sendLineMessage(ctx, partnerID, message); err != nil {
+			s.logger.Printf("Error notifying partner %s of %s: %v", partnerID, book.UserID, err)
+		}
+	}
+}
@@ -0,0 +1,403 @@
+package api
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"tundoku-killer/backend/internal/cron"
+	"tundoku-killer/backend/internal/isbn"
+	"tundoku-killer/backend/internal/store"
+	"tundoku-killer/backend/internal/validation"
+)
+
+// Goodreads のエクスポート (マイブックス → Import and export → Export Library) の CSV を本棚に取り込む。
+// 排他的な棚 (Exclusive Shelf) をステータスに読み替え、評価・読了日・追加日・ページ数を引き継ぐ。
+// すでに本棚にある本 (ISBN か、書名と著者が同じ本) は取り込まないので、途中で失敗しても同じ CSV を送り直せばよい。
+// 本のイベントは1冊ずつは発行しない (登録の確認の LINE が何百通も飛ぶため)。まとめて BooksImported を発行する
+
+const (
+	maxImportRows = 5000
+	// maxImportBodyBytes は CSV の大きさの上限。感想 (My Review) の入った行は数KBになるので、maxImportRows 行が入るようにする
+	maxImportBodyBytes = 16 << 20
+	// defaultImportDeadlineDays は、読んでいる本と読みたい本の最初の1冊に付ける期限 (今日から何日後か)
+	defaultImportDeadlineDays = 30
+	maxImportDeadlineDays     = 365
+	// importStaggerDays は読みたい本の期限の間隔。何百冊も同じ日に期限切れにならないよう、追加した順に1冊ずつずらす
+	importStaggerDays = 7
+)
+
+// goodreadsStatuses は Goodreads の排他的な棚とステータスの対応。読むのをやめた本の棚は人によって名前が違う
+var goodreadsStatuses = map[string]string{
+	"to-read":           "unread",
+	"currently-reading": "reading",
+	"read":              "completed",
+	"did-not-finish":    "abandoned",
+	"dnf":               "abandoned",
+	"abandoned":         "abandoned",
+}
+
+// goodreadsDateLayout は Date Read・Date Added の書式
+const goodreadsDateLayout = "2006/01/02"
+
+// ImportSkip は取り込まなかった行
+type ImportSkip struct {
+	Row    int    `json:"row"` // CSV の行番号 (見出しが1行目)
+	Title  string `json:"title"`
+	Reason string `json:"reason"`
+	BookID string `json:"bookId,omitempty"` // 重複なら、本棚にある同じ本のID
+}
+
+// ImportResult は取り込みの結果
+type ImportResult struct {
+	Imported   []store.Book `json:"imported"`
+	Duplicates []ImportSkip `json:"duplicates"`
+	Skipped    []ImportSkip `json:"skipped"` // 棚が分からない・項目が足りない・未読の本の上限を超えるなどで取り込めなかった行
+	DryRun     bool         `json:"dryRun"`
+}
+
+// goodreadsRow は CSV の1行を本にしたもの
+type goodreadsRow struct {
+	Row       int
+	Book      store.Book
+	DateAdded *time.Time
+}
+
+// handleGoodreadsImport は Goodreads の CSV を userId の本棚に取り込む (POST ?userId=&deadlineDays=&dryRun=&override=, text/csv)。
+// 未読の本の上限を超える本と、override でなければ購入禁止モードに触れる本は取り込まずに skipped で返す
+func (s *Server) handleGoodreadsImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	q := r.URL.Query()
+	userID := q.Get("userId")
+	deadlineDays := defaultImportDeadlineDays
+	if raw := q.Get("deadlineDays"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			writeProblem(w, r, http.StatusBadRequest, "deadlineDays must be an integer")
+			return
+		}
+		deadlineDays = n
+	}
+	var v validation.Validator
+	v.Required("userId", userID)
+	v.MaxLength("userId", userID, maxIDLength)
+	v.Range("deadlineDays", deadlineDays, 1, maxImportDeadlineDays)
+	if err := v.Err(); err != nil {
+		writeValidationError(w, r, err)
+		return
+	}
+
+	rows, skipped, err := parseGoodreadsCSV(r.Body, userID)
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			writeProblem(w, r, http.StatusRequestEntityTooLarge, "CSV too large; export without reviews or split the file")
+			return
+		}
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid Goodreads CSV: %v", err))
+		return
+	}
+
+	ctx := r.Context()
+	existing, err := s.listBooks(ctx, userID)
+	if err != nil {
+		writeServerError(w, r, err, "Failed to retrieve books")
+		return
+	}
+	settings, err := s.getSettings(ctx, userID)
+	if err != nil {
+		writeServerError(w, r, err, "Failed to retrieve settings")
+		return
+	}
+	books, duplicates := dedupeImport(rows, existing)
+	now := time.Now()
+	scheduleImportDeadlines(books, now, deadlineDays)
+
+	result := ImportResult{Imported: []store.Book{}, Duplicates: duplicates, Skipped: skipped, DryRun: q.Get("dryRun") == "true"}
+	limits := importLimits{shelf: existing, settings: settings, override: q.Get("override") == "true"}
+	var bans []*PurchaseBanError // result.Imported と同じ並び。押し切った購入禁止モード (なければ nil)
+	for _, row := range books {
+		if row.Book.CreatedAt == nil {
+			row.Book.CreatedAt = &now
+		}
+		if err := validateImportedBook(row.Book); err != nil {
+			result.Skipped = append(result.Skipped, ImportSkip{Row: row.Row, Title: row.Book.Title, Reason: err.Error()})
+			continue
+		}
+		ban, err := limits.admit(row.Book)
+		if err != nil {
+			result.Skipped = append(result.Skipped, ImportSkip{Row: row.Row, Title: row.Book.Title, Reason: err.Error()})
+			continue
+		}
+		result.Imported = append(result.Imported, row.Book)
+		bans = append(bans, ban)
+	}
+
+	if !result.DryRun {
+		if err := s.importBooks(ctx, userID, result.Imported); err != nil {
+			writeServerError(w, r, err, "Failed to import books")
+			return
+		}
+		for i, ban := range bans {
+			if ban != nil {
+				s.recordPurchaseBanOverride(ctx, result.Imported[i], ban)
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !result.DryRun {
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+// importLimits は取り込む本に、本の登録と同じ購入禁止モードと未読の本の上限を1冊ずつ当てはめる。
+// 取り込むことにした本も、次の本を当てはめるときの未読の本に数える
+type importLimits struct {
+	shelf    []store.Book
+	settings store.UserSettings
+	override bool // ?override=true。購入禁止モードは押し切れるが、未読の本の上限は押し切れない
+}
+
+// admit は book を取り込めるなら、押し切った購入禁止モード (触れなければ nil) を返す。
+// 上限を超えるか、押し切らずに購入禁止モードに触れるなら、そのエラーを返す。読了済みの本は未読の本を増やさないので当てはめない
+func (l *importLimits) admit(book store.Book) (*PurchaseBanError, error) {
+	var ban *PurchaseBanError
+	if book.Status != "completed" && !book.Abandoned() {
+		if limit := l.settings.UnreadHardCap; limit > 0 {
+			if capErr := unreadCap(l.shelf, book, limit); capErr != nil {
+				return nil, capErr
+			}
+		}
+		if limit := l.settings.PurchaseBanLimit; limit > 0 {
+			ban = purchaseBanFor(l.shelf, book, limit)
+			if ban != nil && !l.override {
+				return nil, ban
+			}
+		}
+	}
+	l.shelf = append(l.shelf, book)
+	return ban, nil
+}
+
+// importBooks は books を保存して ID を設定し、BooksImported を発行する
+func (s *Server) importBooks(ctx context.Context, userID string, books []store.Book) error {
+	for i, book := range books {
+		created, err := s.bookRepo.Create(ctx, book)
+		if err != nil {
+			if i > 0 {
+				eventBus.Publish(ctx, BooksImported{UserID: userID, Books: books[:i]})
+			}
+			return fmt.Errorf("error importing %q (%d of %d imported): %w", book.Title, i, len(books), err)
+		}
+		books[i] = created
+	}
+	s.logger.Printf("Imported %d books from Goodreads for %s", len(books), userID)
+	if len(books) > 0 {
+		eventBus.Publish(ctx, BooksImported{UserID: userID, Books: books})
+	}
+	return nil
+}
+
+// parseGoodreadsCSV は CSV を読み、棚の分かる行を本にする。列は見出しの名前で探すので、順番が変わっても読める
+func parseGoodreadsCSV(body io.Reader, userID string) ([]goodreadsRow, []ImportSkip, error) {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil, errors.New("empty file")
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))] = i
+	}
+	for _, name := range []string{"Title", "Author", "Exclusive Shelf"} {
+		if _, ok := columns[name]; !ok {
+			return nil, nil, fmt.Errorf("missing column %q", name)
+		}
+	}
+
+	var rows []goodreadsRow
+	var skipped []ImportSkip
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if line-1 > maxImportRows {
+			return nil, nil, fmt.Errorf("more than %d books", maxImportRows)
+		}
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		title := field("Title")
+		shelf := field("Exclusive Shelf")
+		status, ok := goodreadsStatuses[strings.ToLower(shelf)]
+		if !ok {
+			skipped = append(skipped, ImportSkip{Row: line, Title: title, Reason: fmt.Sprintf("unknown shelf %q", shelf)})
+			continue
+		}
+
+		book := store.Book{
+			Title:     title,
+			Author:    field("Author"),
+			Status:    status,
+			Ownership: store.OwnershipOwned,
+			UserID:    userID,
+			ISBN:      goodreadsISBN(field("ISBN13")),
+			Tags:      goodreadsTags(field("Bookshelves")),
+		}
+		if book.ISBN == "" {
+			book.ISBN = goodreadsISBN(field("ISBN"))
+		}
+		book.Rating, _ = strconv.Atoi(field("My Rating"))
+		book.Pages, _ = strconv.Atoi(field("Number of Pages"))
+
+		row := goodreadsRow{Row: line}
+		if added, err := time.ParseInLocation(goodreadsDateLayout, field("Date Added"), cron.Location); err == nil {
+			row.DateAdded = &added
+			book.CreatedAt = &added
+		}
+		if status == "completed" {
+			if read, err := time.ParseInLocation(goodreadsDateLayout, field("Date Read"), cron.Location); err == nil {
+				book.CompletedAt = &read
+			}
+		}
+		row.Book = book
+		rows = append(rows, row)
+	}
+	return rows, skipped, nil
+}
+
+// goodreadsISBN は Goodreads の ISBN の列 (="9784101010014" のように書かれる) から数字だけを取り出す。
+// 検査数字の合わない ISBN は捨てる (書名での重複の判定と登録はそのまま続ける)
+func goodreadsISBN(raw string) string {
+	code := isbn.Normalize(strings.Trim(raw, `="`))
+	if !isbn.Valid(code) {
+		return ""
+	}
+	return code
+}
+
+// goodreadsTags は Bookshelves 列 ("to-read, sf, 2024" のような棚の一覧) から、排他的な棚を除いてタグにする
+func goodreadsTags(raw string) []string {
+	var tags []string
+	for _, shelf := range strings.Split(raw, ",") {
+		shelf = strings.TrimSpace(shelf)
+		if _, exclusive := goodreadsStatuses[strings.ToLower(shelf)]; exclusive || shelf == "" {
+			continue
+		}
+		if len(tags) == maxTags {
+			break
+		}
+		tags = append(tags, truncateRunes(shelf, maxTagLength))
+	}
+	return tags
+}
+
+// dedupeImport は本棚にある本と、CSV の中で重なった本を除く。ISBN か、書名と著者 (大文字・小文字と空白を無視) で比べる
+func dedupeImport(rows []goodreadsRow, existing []store.Book) ([]goodreadsRow, []ImportSkip) {
+	byISBN := make(map[string]string)
+	byTitle := make(map[string]string)
+	for _, book := range existing {
+		if code, err := isbn.To13(book.ISBN); err == nil {
+			byISBN[code] = book.BookID
+		}
+		byTitle[titleKey(book)] = book.BookID
+	}
+
+	var books []goodreadsRow
+	duplicates := []ImportSkip{}
+	for _, row := range rows {
+		code, _ := isbn.To13(row.Book.ISBN)
+		bookID, dup := byISBN[code]
+		if code == "" || !dup {
+			bookID, dup = byTitle[titleKey(row.Book)]
+		}
+		if dup {
+			reason := "already on your shelf"
+			if bookID == "" {
+				reason = "appears earlier in the file"
+			}
+			duplicates = append(duplicates, ImportSkip{Row: row.Row, Title: row.Book.Title, Reason: reason, BookID: bookID})
+			continue
+		}
+		if code != "" {
+			byISBN[code] = ""
+		}
+		byTitle[titleKey(row.Book)] = ""
+		books = append(books, row)
+	}
+	return books, duplicates
+}
+
+// titleKey は重複を見つけるための書名と著者のキー
+func titleKey(book store.Book) string {
+	normalize := func(s string) string { return strings.Join(strings.Fields(strings.ToLower(s)), "") }
+	return normalize(book.Title) + "\x00" + normalize(book.Author)
+}
+
+// scheduleImportDeadlines は取り込む本に期限を付ける。読んでいる本は deadlineDays 日後、読みたい本は追加した順に
+// deadlineDays 日後から importStaggerDays 日ずつずらす。読み終えた本・やめた本は、読了日 (なければ追加日) を期限にする
+func scheduleImportDeadlines(rows []goodreadsRow, now time.Time, deadlineDays int) {
+	first := now.AddDate(0, 0, deadlineDays)
+	queued := make([]int, 0, len(rows))
+	for i := range rows {
+		book := &rows[i].Book
+		switch book.Status {
+		case "reading":
+			book.Deadline = first
+		case "unread":
+			queued = append(queued, i)
+		default:
+			book.Deadline = now
+			if book.CreatedAt != nil {
+				book.Deadline = *book.CreatedAt
+			}
+			if book.CompletedAt != nil {
+				book.Deadline = *book.CompletedAt
+			}
+		}
+	}
+	// 追加日の古い本から先に読む (追加日の無い本は最後)
+	sort.SliceStable(queued, func(a, b int) bool {
+		da, db := rows[queued[a]].DateAdded, rows[queued[b]].DateAdded
+		if da == nil || db == nil {
+			return da != nil
+		}
+		return da.Before(*db)
+	})
+	for n, i := range queued {
+		rows[i].Book.Deadline = first.AddDate(0, 0, n*importStaggerDays)
+	}
+}
+
+// validateImportedBook は取り込む本を検証する。期限や読了日は過去でもよい
+func validateImportedBook(book store.Book) error {
+	var v validation.Validator
+	validateBookFields(&v, book)
+	validateISBN(&v, book.ISBN)
+	return v.Err()
+}
@@ -0,0 +1,141 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"tundoku-killer/backend/internal/config"
+	"tundoku-killer/backend/internal/store"
+)
+
+// goodreadsCSV は n 行の Goodreads のエクスポートを作る。感想の列で1行を1KBほどにする
+func goodreadsCSV(n int) string {
+	var b strings.Builder
+	b.WriteString("Book Id,Title,Author,ISBN,ISBN13,My Rating,Number of Pages,Date Read,Date Added,Bookshelves,Exclusive Shelf,My Review\n")
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "%d,本 %d,著者,\"=\"\"\"\"\",\"=\"\"\"\"\",0,200,,2024/01/02,,to-read,\"%s\"\n", i, i, strings.Repeat("長い感想。", 60))
+	}
+	return b.String()
+}
+
+func TestGoodreadsImportAcceptsMaxRowsOverDefaultBodyLimit(t *testing.T) {
+	body := goodreadsCSV(maxImportRows)
+	if len(body) <= config.DefaultMaxBodyBytes {
+		t.Fatalf("test CSV is %d bytes; want more than %d", len(body), config.DefaultMaxBodyBytes)
+	}
+
+	var rows []goodreadsRow
+	handler := limitBody(config.DefaultMaxBodyBytes, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		if rows, _, err = parseGoodreadsCSV(r.Body, "u1"); err != nil {
+			t.Errorf("parseGoodreadsCSV: %v", err)
+		}
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/books/import/goodreads?userId=u1", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	if len(rows) != maxImportRows {
+		t.Errorf("parsed %d rows, want %d", len(rows), maxImportRows)
+	}
+}
+
+func TestParseGoodreadsCSV(t *testing.T) {
+	csv := "Title,Author,ISBN13,My Rating,Number of Pages,Date Read,Date Added,Bookshelves,Exclusive Shelf\n" +
+		"読んだ本,著者A,\"=\"\"9784101010014\"\"\",4,320,2024/02/03,2024/01/02,\"read, favorites\",read\n" +
+		"積んだ本,著者B,\"=\"\"9784101010015\"\"\",0,,,2024/01/05,,to-read\n" +
+		"謎の本,著者C,,0,,,,,custom-shelf\n"
+	rows, skipped, err := parseGoodreadsCSV(strings.NewReader(csv), "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || len(skipped) != 1 {
+		t.Fatalf("got %d rows and %d skipped, want 2 and 1", len(rows), len(skipped))
+	}
+
+	read := rows[0].Book
+	if read.Status != "completed" || read.Rating != 4 || read.Pages != 320 || read.ISBN != "9784101010014" || read.CompletedAt == nil {
+		t.Errorf("read book = %+v", read)
+	}
+	if len(read.Tags) != 1 || read.Tags[0] != "favorites" {
+		t.Errorf("tags = %v, want [favorites] (exclusive shelves are dropped)", read.Tags)
+	}
+	if unread := rows[1].Book; unread.Status != "unread" || unread.ISBN != "" {
+		t.Errorf("to-read book = %+v (an ISBN with a bad check digit is dropped)", unread)
+	}
+	if skipped[0].Row != 4 {
+		t.Errorf("skipped row = %d, want 4", skipped[0].Row)
+	}
+}
+
+func TestImportLimits(t *testing.T) {
+	shelf := []store.Book{
+		{BookID: "a", Title: "積んだ本1", Status: "unread"},
+		{BookID: "b", Title: "積んだ本2", Status: "reading"},
+		{BookID: "c", Title: "読んだ本", Status: "completed"},
+	}
+	unread := store.Book{Title: "新しい本", Status: "unread"}
+	completed := store.Book{Title: "読了済み", Status: "completed"}
+
+	tests := []struct {
+		name     string
+		settings store.UserSettings
+		override bool
+		books    []store.Book
+		want     []string // 本ごとに "ok"・"ban" (押し切った)・エラーの種類
+	}{
+		{
+			name:  "設定がなければすべて取り込む",
+			books: []store.Book{unread, unread, unread},
+			want:  []string{"ok", "ok", "ok"},
+		},
+		{
+			name:     "取り込む本も未読の本に数えて上限で止める",
+			settings: store.UserSettings{UnreadHardCap: 4},
+			books:    []store.Book{unread, unread, unread, completed},
+			want:     []string{"ok", "ok", "cap", "ok"},
+		},
+		{
+			name:     "購入禁止モードに触れる本は取り込まない",
+			settings: store.UserSettings{PurchaseBanLimit: 2},
+			books:    []store.Book{unread, unread, completed},
+			want:     []string{"ok", "banned", "ok"},
+		},
+		{
+			name:     "押し切れば購入禁止モードに触れても取り込む",
+			settings: store.UserSettings{PurchaseBanLimit: 2, UnreadHardCap: 5},
+			override: true,
+			books:    []store.Book{unread, unread, unread, unread},
+			want:     []string{"ok", "ban", "ban", "cap"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limits := importLimits{shelf: shelf, settings: tt.settings, override: tt.override}
+			for i, book := range tt.books {
+				ban, err := limits.admit(book)
+				got := "ok"
+				var capErr *UnreadCapError
+				var banErr *PurchaseBanError
+				switch {
+				case errors.As(err, &capErr):
+					got = "cap"
+				case errors.As(err, &banErr):
+					got = "banned"
+				case err != nil:
+					t.Fatalf("admit(%d) = %v", i, err)
+				case ban != nil:
+					got = "ban"
+				}
+				if got != tt.want[i] {
+					t.Errorf("admit(%d) = %s; want %s", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
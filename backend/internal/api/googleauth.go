@@ -0,0 +1,105 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"google.golang.org/api/idtoken"
+
+	"tundoku-killer/backend/internal/store"
+)
+
+// Google アカウントでのログイン。LINE をつながずにWebだけで使いたい人向けで、通知はメールで送る。
+// LINE と同じく Firebase のカスタムトークンを返し、UID は "google:" + Google のユーザーID にする
+
+// googleUIDPrefix は Google でできたアカウントの UID の接頭辞
+const googleUIDPrefix = "google:"
+
+// handleGoogleAuth は Google の ID トークンを検証して Firebase のカスタムトークンを返す。
+// 初めてのログインならプロフィールと設定を作る
+func (s *Server) handleGoogleAuth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req googleAuthRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid JSON body: %v", err))
+		return
+	}
+	if err := req.Validate(); err != nil {
+		writeValidationError(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	clientID := s.cfg.GoogleOAuthClientID
+	if clientID == "" {
+		writeProblem(w, r, http.StatusNotImplemented, "Google sign-in is not configured")
+		return
+	}
+	payload, err := idtoken.Validate(ctx, req.IDToken, clientID)
+	if err != nil {
+		s.logger.Printf("Invalid Google ID token: %v", err)
+		writeProblem(w, r, http.StatusUnauthorized, "Invalid Google ID token")
+		return
+	}
+
+	uid := googleUIDPrefix + payload.Subject
+	if err := s.ensureGoogleUser(ctx, uid, payload.Claims); err != nil {
+		writeServerError(w, r, err, "Failed to provision user")
+		return
+	}
+
+	client, err := s.firebaseApp.Auth(ctx)
+	if err != nil {
+		writeServerError(w, r, err, "Failed to initialize authentication")
+		return
+	}
+	customToken, err := client.CustomToken(ctx, uid)
+	if err != nil {
+		writeServerError(w, r, err, "Failed to create custom token")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"customToken": customToken, "userId": uid})
+}
+
+// ensureGoogleUser は uid のプロフィールと設定がまだなければ、Google の ID トークンの内容から作る
+func (s *Server) ensureGoogleUser(ctx context.Context, uid string, claims map[string]interface{}) error {
+	if _, err := s.userRepo.GetProfile(ctx, uid); !errors.Is(err, store.ErrProfileNotFound) {
+		return err
+	}
+
+	email, _ := claims["email"].(string)
+	if verified, _ := claims["email_verified"].(bool); !verified {
+		email = "" // 確認されていないアドレスには通知を送らない
+	}
+	name, _ := claims["name"].(string)
+	picture, _ := claims["picture"].(string)
+
+	now := time.Now()
+	profile := store.UserProfile{
+		UserID:      uid,
+		DisplayName: name,
+		PictureURL:  picture,
+		Email:       email,
+		Provider:    "google",
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if err := s.userRepo.CreateProfile(ctx, profile); err != nil {
+		return err
+	}
+	if err := s.userRepo.CreateSettings(ctx, store.UserSettings{UserID: uid, UpdatedAt: now}); err != nil {
+		return err
+	}
+	s.logger.Printf("Profile created for %s from Google", uid)
+	return nil
+}
@@ -0,0 +1,337 @@
+package api
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/graph-gophers/dataloader"
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/graph-gophers/graphql-go/relay"
+	gqlotel "github.com/graph-gophers/graphql-go/trace/otel"
+
+	"tundoku-killer/backend/internal/store"
+	"tundoku-killer/backend/internal/validation"
+)
+
+// ダッシュボードが本・集計・煽りの履歴を1回で取れるようにする GraphQL エンドポイント (/graphql)。
+// 同じ本やユーザーへの Firestore の読み込みはリクエスト単位のデータローダーでまとめる
+
+//go:embed schema.graphql
+var graphqlSchemaSDL string
+
+const (
+	maxGraphQLDepth    = 6
+	maxInsultsPerQuery = 100
+	// firestoreInLimit は Firestore の "in" クエリに渡せる値の上限
+	firestoreInLimit = 30
+)
+
+// newGraphQLHandler はスキーマを読み込み、リクエストごとにデータローダーを用意するハンドラーを返す
+func (s *Server) newGraphQLHandler() (http.HandlerFunc, error) {
+	schema, err := graphql.ParseSchema(graphqlSchemaSDL, &graphqlResolver{s: s},
+		graphql.MaxDepth(maxGraphQLDepth),
+		graphql.Tracer(&gqlotel.Tracer{Tracer: tracer}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing GraphQL schema: %w", err)
+	}
+	h := &relay.Handler{Schema: schema}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		h.ServeHTTP(w, r.WithContext(s.withLoaders(r.Context())))
+	}, nil
+}
+
+// loaders は1リクエストの間だけ使うデータローダー
+type loaders struct {
+	booksByUser   *dataloader.Loader // userId -> []Book
+	bookByID      *dataloader.Loader // bookId -> *Book (削除済みなら nil)
+	insultsByBook *dataloader.Loader // bookId -> []InsultRecord (新しい順)
+}
+
+type loadersKey struct{}
+
+func (s *Server) withLoaders(ctx context.Context) context.Context {
+	return context.WithValue(ctx, loadersKey{}, &loaders{
+		booksByUser:   dataloader.NewBatchedLoader(s.batchBooksByUser),
+		bookByID:      dataloader.NewBatchedLoader(s.batchBookByID),
+		insultsByBook: dataloader.NewBatchedLoader(s.batchInsultsByBook),
+	})
+}
+
+func loadersFrom(ctx context.Context) *loaders {
+	return ctx.Value(loadersKey{}).(*loaders)
+}
+
+// batchBooksByUser はユーザーごとの本の一覧を並行して読み込む。
+// books と stats を同時に要求されても Firestore へのクエリは1回で済む
+func (s *Server) batchBooksByUser(ctx context.Context, keys dataloader.Keys) []*dataloader.Result {
+	results := make([]*dataloader.Result, len(keys))
+	var wg sync.WaitGroup
+	for i, key := range keys {
+		wg.Add(1)
+		go func(i int, userID string) {
+			defer wg.Done()
+			books, err := s.listBooks(ctx, userID)
+			results[i] = &dataloader.Result{Data: books, Error: err}
+		}(i, key.String())
+	}
+	wg.Wait()
+	return results
+}
+
+// batchBookByID は本をまとめて読み込む。無い本は nil
+func (s *Server) batchBookByID(ctx context.Context, keys dataloader.Keys) []*dataloader.Result {
+	results := make([]*dataloader.Result, len(keys))
+	books, err := s.bookRepo.ListByIDs(ctx, keys.Keys())
+	if err != nil {
+		for i := range results {
+			results[i] = &dataloader.Result{Error: err}
+		}
+		return results
+	}
+	byID := make(map[string]*store.Book, len(books))
+	for i := range books {
+		byID[books[i].BookID] = &books[i]
+	}
+	for i, key := range keys {
+		results[i] = &dataloader.Result{Data: byID[key.String()]}
+	}
+	return results
+}
+
+// batchInsultsByBook は複数の本の煽りの履歴を "in" クエリでまとめて読み込む
+func (s *Server) batchInsultsByBook(ctx context.Context, keys dataloader.Keys) []*dataloader.Result {
+	ids := keys.Keys()
+	byBook := make(map[string][]InsultRecord, len(ids))
+
+	results := make([]*dataloader.Result, len(keys))
+	for start := 0; start < len(ids); start += firestoreInLimit {
+		end := min(start+firestoreInLimit, len(ids))
+		docs, err := s.firestoreClient.Collection("insults").
+			Where("bookId", "in", ids[start:end]).
+			Documents(ctx).GetAll()
+		if err != nil {
+			for i := range results {
+				results[i] = &dataloader.Result{Error: err}
+			}
+			return results
+		}
+		for _, doc := range docs {
+			var rec InsultRecord
+			if err := doc.DataTo(&rec); err != nil {
+				continue
+			}
+			rec.ID = doc.Ref.ID
+			byBook[rec.BookID] = append(byBook[rec.BookID], rec)
+		}
+	}
+
+	for i, id := range ids {
+		recs := byBook[id]
+		// 複合インデックスを増やさないよう、並べ替えはメモリ上で行う
+		sort.Slice(recs, func(a, b int) bool { return recs[a].SentAt.After(recs[b].SentAt) })
+		results[i] = &dataloader.Result{Data: recs}
+	}
+	return results
+}
+
+// graphqlResolver は Query 型のリゾルバー
+type graphqlResolver struct {
+	s *Server
+}
+
+type userArgs struct {
+	UserID graphql.ID
+}
+
+// validateUserID は userId 引数を REST と同じ規則で検証する
+func validateUserID(userID graphql.ID) error {
+	var v validation.Validator
+	v.Required("userId", string(userID))
+	v.MaxLength("userId", string(userID), maxIDLength)
+	return v.Err()
+}
+
+func (r *graphqlResolver) Books(ctx context.Context, args userArgs) ([]*bookResolver, error) {
+	books, err := loadUserBooks(ctx, args.UserID)
+	if err != nil {
+		return nil, err
+	}
+	resolvers := make([]*bookResolver, len(books))
+	for i := range books {
+		resolvers[i] = &bookResolver{book: books[i]}
+	}
+	return resolvers, nil
+}
+
+func (r *graphqlResolver) Stats(ctx context.Context, args userArgs) (*statsResolver, error) {
+	books, err := loadUserBooks(ctx, args.UserID)
+	if err != nil {
+		return nil, err
+	}
+	sessions, err := r.s.readingSessions(ctx, string(args.UserID))
+	if err != nil {
+		return nil, err
+	}
+	return &statsResolver{stats: computeStats(string(args.UserID), books, sessions, time.Now())}, nil
+}
+
+func (r *graphqlResolver) Insults(ctx context.Context, args struct {
+	UserID graphql.ID
+	Limit  int32
+}) ([]*insultResolver, error) {
+	if err := validateUserID(args.UserID); err != nil {
+		return nil, err
+	}
+	limit := min(max(int(args.Limit), 1), maxInsultsPerQuery)
+
+	docs, err := r.s.firestoreClient.Collection("insults").
+		Where("userId", "==", string(args.UserID)).
+		OrderBy("sentAt", firestore.Desc).
+		Limit(limit).
+		Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("error fetching insults: %w", err)
+	}
+
+	resolvers := make([]*insultResolver, 0, len(docs))
+	for _, doc := range docs {
+		var rec InsultRecord
+		if err := doc.DataTo(&rec); err != nil {
+			continue
+		}
+		rec.ID = doc.Ref.ID
+		resolvers = append(resolvers, &insultResolver{rec: rec})
+	}
+	return resolvers, nil
+}
+
+// loadUserBooks は userId の本の一覧をデータローダー経由で読み込む
+func loadUserBooks(ctx context.Context, userID graphql.ID) ([]store.Book, error) {
+	if err := validateUserID(userID); err != nil {
+		return nil, err
+	}
+	data, err := loadersFrom(ctx).booksByUser.Load(ctx, dataloader.StringKey(userID))()
+	if err != nil {
+		return nil, fmt.Errorf("error fetching books: %w", err)
+	}
+	books := data.([]store.Book)
+
+	// 一覧で読み込んだ本は Insult.book からも使えるようにしておく
+	l := loadersFrom(ctx)
+	for i := range books {
+		l.bookByID.Prime(ctx, dataloader.StringKey(books[i].BookID), &books[i])
+	}
+	return books, nil
+}
+
+// isOverdue は本が期限切れで未読了かを返す。期限のないウィッシュリストの本は期限切れにならない
+func isOverdue(book store.Book, now time.Time) bool {
+	return book.Status != "completed" && !book.Abandoned() && !book.OnWishlist() && book.Deadline.Before(now)
+}
+
+type bookResolver struct {
+	book store.Book
+}
+
+func (b *bookResolver) BookID() graphql.ID     { return graphql.ID(b.book.BookID) }
+func (b *bookResolver) UserID() graphql.ID     { return graphql.ID(b.book.UserID) }
+func (b *bookResolver) Title() string          { return b.book.Title }
+func (b *bookResolver) Author() string         { return b.book.Author }
+func (b *bookResolver) Deadline() graphql.Time { return graphql.Time{Time: b.book.Deadline} }
+func (b *bookResolver) Status() string         { return b.book.Status }
+func (b *bookResolver) InsultLevel() int32     { return int32(b.book.InsultLevel) }
+func (b *bookResolver) Price() *int32 {
+	if b.book.Price == 0 {
+		return nil
+	}
+	price := int32(b.book.Price)
+	return &price
+}
+
+func (b *bookResolver) Format() *string {
+	if b.book.Format == "" {
+		return nil
+	}
+	return &b.book.Format
+}
+
+func (b *bookResolver) Overdue() bool { return isOverdue(b.book, time.Now()) }
+
+func (b *bookResolver) Insults(ctx context.Context, args struct{ Limit int32 }) ([]*insultResolver, error) {
+	data, err := loadersFrom(ctx).insultsByBook.Load(ctx, dataloader.StringKey(b.book.BookID))()
+	if err != nil {
+		return nil, fmt.Errorf("error fetching insults: %w", err)
+	}
+	recs := data.([]InsultRecord)
+	if limit := min(max(int(args.Limit), 1), maxInsultsPerQuery); len(recs) > limit {
+		recs = recs[:limit]
+	}
+
+	resolvers := make([]*insultResolver, len(recs))
+	for i, rec := range recs {
+		resolvers[i] = &insultResolver{rec: rec}
+	}
+	return resolvers, nil
+}
+
+type statsResolver struct {
+	stats Stats
+}
+
+func (s *statsResolver) Total() int32                    { return int32(s.stats.Total) }
+func (s *statsResolver) Unread() int32                   { return int32(s.stats.ByStatus["unread"]) }
+func (s *statsResolver) Reading() int32                  { return int32(s.stats.ByStatus["reading"]) }
+func (s *statsResolver) Completed() int32                { return int32(s.stats.ByStatus["completed"]) }
+func (s *statsResolver) Insulted() int32                 { return int32(s.stats.ByStatus["insulted"]) }
+func (s *statsResolver) Overdue() int32                  { return int32(s.stats.Overdue) }
+func (s *statsResolver) CompletionRate() float64         { return s.stats.CompletionRate }
+func (s *statsResolver) AverageDaysToComplete() *float64 { return s.stats.AverageDaysToComplete }
+func (s *statsResolver) AverageDaysOverdue() *float64    { return s.stats.AverageDaysOverdue }
+func (s *statsResolver) UnreadValue() int32              { return int32(s.stats.UnreadValue) }
+func (s *statsResolver) PagesPerDay() float64            { return s.stats.Pace.PagesPerDay }
+func (s *statsResolver) ListeningMinutesPerDay() float64 { return s.stats.Pace.ListeningMinutesPerDay }
+
+func (s *statsResolver) LongestNeglected(ctx context.Context) (*bookResolver, error) {
+	if s.stats.LongestNeglected == nil {
+		return nil, nil
+	}
+	return loadBook(ctx, s.stats.LongestNeglected.BookID)
+}
+
+type insultResolver struct {
+	rec InsultRecord
+}
+
+func (i *insultResolver) ID() graphql.ID       { return graphql.ID(i.rec.ID) }
+func (i *insultResolver) Message() string      { return i.rec.Message }
+func (i *insultResolver) Cycle() string        { return i.rec.Cycle }
+func (i *insultResolver) SentAt() graphql.Time { return graphql.Time{Time: i.rec.SentAt} }
+
+func (i *insultResolver) Book(ctx context.Context) (*bookResolver, error) {
+	return loadBook(ctx, i.rec.BookID)
+}
+
+// loadBook は本をデータローダー経由で読み込む。削除済みなら nil
+func loadBook(ctx context.Context, bookID string) (*bookResolver, error) {
+	data, err := loadersFrom(ctx).bookByID.Load(ctx, dataloader.StringKey(bookID))()
+	if err != nil {
+		return nil, fmt.Errorf("error fetching book: %w", err)
+	}
+	book := data.(*store.Book)
+	if book == nil {
+		return nil, nil
+	}
+	return &bookResolver{book: *book}, nil
+}
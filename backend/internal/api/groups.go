@@ -0,0 +1,561 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"tundoku-killer/backend/internal/cron"
+	"tundoku-killer/backend/internal/store"
+)
+
+// 読書会。メンバーに同じ課題本と期限を配り、cron が毎日進捗を投稿して、期限を過ぎても読んでいないメンバーを名指しする。
+// 課題本は各メンバーの本棚に普通の本として登録するので、読了や煽りはいつもの流れで処理される
+
+const (
+	// groupReportLease は読書会の進捗投稿の二重実行を防ぐロックの名前
+	groupReportLease = "groupReports"
+	// groupReportGracePeriod は期限を過ぎてからも進捗を投稿し続ける期間
+	groupReportGracePeriod = 7 * 24 * time.Hour
+	maxGroupMembers        = 30
+)
+
+var (
+	errGroupNotFound  = errors.New("group not found")
+	errNotGroupOwner  = errors.New("only the group owner can do this")
+	errNotGroupMember = errors.New("user is not a member of this group")
+	errGroupFull      = errors.New("group is full")
+)
+
+// Group は読書会。groups/{groupId} に保存する
+type Group struct {
+	GroupID string   `json:"groupId" firestore:"groupId"`
+	Name    string   `json:"name" firestore:"name"`
+	OwnerID string   `json:"ownerId" firestore:"ownerId"`
+	Members []string `json:"members" firestore:"members"`
+	// Invited は招待済みで、まだ参加していないユーザー
+	Invited []string `json:"invited" firestore:"invited"`
+	// LineGroupID はボットを招待した LINE グループのID。設定されていれば進捗をグループに投稿し、なければメンバーに個別に送る
+	LineGroupID string     `json:"lineGroupId,omitempty" firestore:"lineGroupId,omitempty"`
+	Book        *GroupBook `json:"book,omitempty" firestore:"book,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt" firestore:"createdAt"`
+}
+
+// GroupBook は読書会の課題本
+type GroupBook struct {
+	Title      string    `json:"title" firestore:"title"`
+	Author     string    `json:"author" firestore:"author"`
+	Pages      int       `json:"pages,omitempty" firestore:"pages,omitempty"`
+	Deadline   time.Time `json:"deadline" firestore:"deadline"`
+	AssignedAt time.Time `json:"assignedAt" firestore:"assignedAt"`
+}
+
+// GroupProgress はメンバー1人の課題本の進み具合
+type GroupProgress struct {
+	UserID      string `json:"userId"`
+	DisplayName string `json:"displayName"`
+	Status      string `json:"status"` // 課題本の Book.Status。本を削除していたら "missing"
+}
+
+// handleGroups は参加・招待されている読書会の一覧 (GET ?userId=)・作成 (POST)・解散と退会 (DELETE) を行う
+func (s *Server) handleGroups(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.handleListGroups(w, r)
+	case http.MethodPost:
+		s.handleCreateGroup(w, r)
+	case http.MethodDelete:
+		s.handleLeaveGroup(w, r)
+	default:
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+func (s *Server) handleListGroups(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := r.URL.Query().Get("userId")
+	if userID == "" {
+		writeProblem(w, r, http.StatusBadRequest, "userId query parameter is required")
+		return
+	}
+
+	groups := []Group{}
+	for _, field := range []string{"members", "invited"} {
+		docs, err := s.firestoreClient.Collection("groups").Where(field, "array-contains", userID).Documents(ctx).GetAll()
+		if err != nil {
+			writeServerError(w, r, err, "Failed to retrieve groups")
+			return
+		}
+		for _, doc := range docs {
+			var group Group
+			if err := doc.DataTo(&group); err != nil {
+				s.logger.Printf("Error parsing group %s: %v", doc.Ref.ID, err)
+				continue
+			}
+			groups = append(groups, group)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(groups)
+}
+
+func (s *Server) handleCreateGroup(w http.ResponseWriter, r *http.Request) {
+	var req createGroupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid JSON body: %v", err))
+		return
+	}
+	if err := req.Validate(); err != nil {
+		writeValidationError(w, r, err)
+		return
+	}
+
+	ref := s.firestoreClient.Collection("groups").NewDoc()
+	group := Group{
+		GroupID:     ref.ID,
+		Name:        req.Name,
+		OwnerID:     req.UserID,
+		Members:     []string{req.UserID},
+		Invited:     []string{},
+		LineGroupID: req.LineGroupID,
+		CreatedAt:   time.Now(),
+	}
+	if _, err := ref.Set(r.Context(), group); err != nil {
+		writeServerError(w, r, err, "Failed to create group")
+		return
+	}
+	s.logger.Printf("Group created: %s (%s) by %s", group.Name, group.GroupID, group.OwnerID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(group)
+}
+
+// handleLeaveGroup は userId を読書会から抜く。主催者なら読書会ごと削除する
+func (s *Server) handleLeaveGroup(w http.ResponseWriter, r *http.Request) {
+	var req groupMemberRequest
+	if !decodeGroupRequest(w, r, &req) {
+		return
+	}
+
+	ref := s.firestoreClient.Collection("groups").Doc(req.GroupID)
+	err := s.updateGroup(r.Context(), req.GroupID, func(tx *firestore.Transaction, group *Group) error {
+		if group.OwnerID == req.UserID {
+			return tx.Delete(ref)
+		}
+		if !slices.Contains(group.Members, req.UserID) && !slices.Contains(group.Invited, req.UserID) {
+			return errNotGroupMember
+		}
+		group.Members = slices.DeleteFunc(group.Members, func(id string) bool { return id == req.UserID })
+		group.Invited = slices.DeleteFunc(group.Invited, func(id string) bool { return id == req.UserID })
+		return tx.Set(ref, *group)
+	})
+	if writeGroupError(w, r, err) {
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleInviteToGroup は主催者が inviteeId を読書会に招待し、LINE で知らせる
+func (s *Server) handleInviteToGroup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req groupMemberRequest
+	if !decodeGroupRequest(w, r, &req) {
+		return
+	}
+	if req.InviteeID == "" {
+		writeProblem(w, r, http.StatusBadRequest, "inviteeId is required")
+		return
+	}
+
+	ctx := r.Context()
+	ref := s.firestoreClient.Collection("groups").Doc(req.GroupID)
+	var group Group
+	err := s.updateGroup(ctx, req.GroupID, func(tx *firestore.Transaction, g *Group) error {
+		if g.OwnerID != req.UserID {
+			return errNotGroupOwner
+		}
+		if len(g.Members)+len(g.Invited) >= maxGroupMembers {
+			return errGroupFull
+		}
+		if !slices.Contains(g.Members, req.InviteeID) && !slices.Contains(g.Invited, req.InviteeID) {
+			g.Invited = append(g.Invited, req.InviteeID)
+		}
+		group = *g
+		return tx.Set(ref, *g)
+	})
+	if writeGroupError(w, r, err) {
+		return
+	}
+
+	owner, err := s.getSettings(ctx, req.UserID)
+	if err != nil {
+		s.logger.Printf("Error fetching settings for %s: %v", req.UserID, err)
+	}
+	message := fmt.Sprintf("%sさんから読書会「%s」に招待されました。アプリから参加できます。", owner.Name(), group.Name)
+	if err := s.sendLineMessage(ctx, req.InviteeID, message); err != nil {
+		// 招待自体は保存できているので、通知の失敗ではエラーにしない
+		s.logger.Printf("Error sending group invite to %s: %v", req.InviteeID, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(group)
+}
+
+// handleJoinGroup は招待されている userId を読書会に参加させる。課題本があれば本棚に追加する
+func (s *Server) handleJoinGroup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req groupMemberRequest
+	if !decodeGroupRequest(w, r, &req) {
+		return
+	}
+
+	ctx := r.Context()
+	ref := s.firestoreClient.Collection("groups").Doc(req.GroupID)
+	var group Group
+	err := s.updateGroup(ctx, req.GroupID, func(tx *firestore.Transaction, g *Group) error {
+		if !slices.Contains(g.Invited, req.UserID) {
+			return errNotGroupMember
+		}
+		g.Invited = slices.DeleteFunc(g.Invited, func(id string) bool { return id == req.UserID })
+		g.Members = append(g.Members, req.UserID)
+		group = *g
+		return tx.Set(ref, *g)
+	})
+	if writeGroupError(w, r, err) {
+		return
+	}
+
+	if group.Book != nil {
+		if _, err := s.registerGroupBook(ctx, group, req.UserID); err != nil {
+			s.logger.Printf("Error adding group book for %s: %v", req.UserID, err)
+		}
+	}
+	s.logger.Printf("User %s joined group %s", req.UserID, group.GroupID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(group)
+}
+
+// handleGroupBook は課題本の設定 (PUT、主催者のみ) と進捗の取得 (GET ?groupId=) を行う。
+// 設定すると全メンバーの本棚に同じ期限で本を追加する
+func (s *Server) handleGroupBook(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.handleGroupProgress(w, r)
+	case http.MethodPut:
+		s.handleAssignGroupBook(w, r)
+	default:
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+func (s *Server) handleAssignGroupBook(w http.ResponseWriter, r *http.Request) {
+	var req assignGroupBookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid JSON body: %v", err))
+		return
+	}
+	if err := req.Validate(time.Now()); err != nil {
+		writeValidationError(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	ref := s.firestoreClient.Collection("groups").Doc(req.GroupID)
+	var group Group
+	err := s.updateGroup(ctx, req.GroupID, func(tx *firestore.Transaction, g *Group) error {
+		if g.OwnerID != req.UserID {
+			return errNotGroupOwner
+		}
+		g.Book = &GroupBook{
+			Title:      req.Title,
+			Author:     req.Author,
+			Pages:      req.Pages,
+			Deadline:   req.Deadline,
+			AssignedAt: time.Now(),
+		}
+		group = *g
+		return tx.Set(ref, *g)
+	})
+	if writeGroupError(w, r, err) {
+		return
+	}
+
+	for _, memberID := range group.Members {
+		if _, err := s.registerGroupBook(ctx, group, memberID); err != nil {
+			s.logger.Printf("Error adding group book for %s: %v", memberID, err)
+		}
+	}
+	s.logger.Printf("Group %s assigned %s (Deadline: %v)", group.GroupID, group.Book.Title, group.Book.Deadline)
+
+	if err := s.postToGroup(ctx, group, fmt.Sprintf("読書会「%s」の課題本は『%s』(%s) です。期限は%sです。",
+		group.Name, group.Book.Title, group.Book.Author, group.Book.Deadline.In(cron.Location).Format("1月2日"))); err != nil {
+		s.logger.Printf("Error announcing group book for %s: %v", group.GroupID, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(group)
+}
+
+func (s *Server) handleGroupProgress(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	groupID := r.URL.Query().Get("groupId")
+	if groupID == "" {
+		writeProblem(w, r, http.StatusBadRequest, "groupId query parameter is required")
+		return
+	}
+
+	doc, err := s.firestoreClient.Collection("groups").Doc(groupID).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		writeProblem(w, r, http.StatusNotFound, "Group not found")
+		return
+	}
+	if err != nil {
+		writeServerError(w, r, err, "Failed to retrieve group")
+		return
+	}
+	var group Group
+	if err := doc.DataTo(&group); err != nil {
+		writeServerError(w, r, err, "Failed to parse group")
+		return
+	}
+
+	progress, err := s.groupProgress(ctx, group)
+	if err != nil {
+		writeServerError(w, r, err, "Failed to retrieve progress")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"group":    group,
+		"progress": progress,
+	})
+}
+
+// registerGroupBook は課題本を userID の本棚に追加する
+func (s *Server) registerGroupBook(ctx context.Context, group Group, userID string) (store.Book, error) {
+	return s.registerBook(ctx, store.Book{
+		Title:    group.Book.Title,
+		Author:   group.Book.Author,
+		Pages:    group.Book.Pages,
+		Deadline: group.Book.Deadline,
+		UserID:   userID,
+		GroupID:  group.GroupID,
+	})
+}
+
+// groupProgress は各メンバーの課題本の状態を返す。課題本がなければ空
+func (s *Server) groupProgress(ctx context.Context, group Group) ([]GroupProgress, error) {
+	progress := []GroupProgress{}
+	if group.Book == nil {
+		return progress, nil
+	}
+
+	books, err := s.bookRepo.ListByUsers(ctx, group.Members)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching group books: %w", err)
+	}
+	// 課題本を変えても古い本は本棚に残るので、今の課題本と同じタイトルのものだけを見る
+	statuses := make(map[string]string)
+	for _, book := range books {
+		if book.GroupID != group.GroupID || book.Title != group.Book.Title {
+			continue
+		}
+		statuses[book.UserID] = book.Status
+	}
+
+	for _, memberID := range group.Members {
+		settings, err := s.getSettings(ctx, memberID)
+		if err != nil {
+			s.logger.Printf("Error fetching settings for %s: %v", memberID, err)
+		}
+		status, ok := statuses[memberID]
+		if !ok {
+			status = "missing"
+		}
+		progress = append(progress, GroupProgress{UserID: memberID, DisplayName: settings.Name(), Status: status})
+	}
+	return progress, nil
+}
+
+// handleGroupReportCron は課題本のある読書会に、その日の進捗を投稿する。1日1回呼ぶ。
+// 期限を過ぎていれば、まだ読み終えていないメンバーを名指しする
+func (s *Server) handleGroupReportCron(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodGet {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	ctx := context.WithoutCancel(r.Context())
+
+	if !s.authorizeCron(r) {
+		writeProblem(w, r, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	runID := uuid.NewString()
+	if err := s.cron.AcquireLease(ctx, groupReportLease, runID, cron.LeaseTTL); err != nil {
+		if errors.Is(err, cron.ErrLeaseHeld) {
+			writeProblem(w, r, http.StatusConflict, "Another group report is already running")
+			return
+		}
+		writeServerError(w, r, err, "Failed to acquire lock")
+		return
+	}
+	defer s.cron.ReleaseLease(ctx, groupReportLease, runID)
+
+	now := time.Now()
+	docs, err := s.firestoreClient.Collection("groups").
+		Where("book.deadline", ">", now.Add(-groupReportGracePeriod)).
+		Documents(ctx).GetAll()
+	if err != nil {
+		writeServerError(w, r, err, "Failed to list groups")
+		return
+	}
+
+	groups := make(map[string]Group, len(docs))
+	groupIDs := make([]string, 0, len(docs))
+	for _, doc := range docs {
+		var group Group
+		if err := doc.DataTo(&group); err != nil || group.Book == nil {
+			continue
+		}
+		groups[doc.Ref.ID] = group
+		groupIDs = append(groupIDs, doc.Ref.ID)
+	}
+
+	day := now.In(cron.Location).Format("2006-01-02")
+	result := s.deliverReports(ctx, "groupReports", day, groupIDs, func(ctx context.Context, groupID string) (interface{}, error) {
+		group := groups[groupID]
+		progress, err := s.groupProgress(ctx, group)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.postToGroup(ctx, group, groupReportText(group, progress, now)); err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"groupId": groupID, "progress": progress, "sentAt": time.Now()}, nil
+	})
+
+	s.logger.Printf("Group report %s: %d sent, %d skipped, %d failed (done: %v)", day, result.Sent, result.Skipped, result.Failed, result.Done)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"groups":  len(groupIDs),
+		"sent":    result.Sent,
+		"skipped": result.Skipped,
+		"failed":  result.Failed,
+		"done":    result.Done,
+	})
+}
+
+// groupReportText は進捗の投稿文を作る
+func groupReportText(group Group, progress []GroupProgress, now time.Time) string {
+	var finished, laggards []string
+	for _, p := range progress {
+		if p.Status == "completed" {
+			finished = append(finished, p.DisplayName)
+		} else {
+			laggards = append(laggards, p.DisplayName)
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "読書会「%s」の進捗\n『%s』(期限: %s)\n", group.Name, group.Book.Title, group.Book.Deadline.In(cron.Location).Format("1月2日"))
+	fmt.Fprintf(&b, "読了: %d/%d人", len(finished), len(progress))
+	if len(finished) > 0 {
+		fmt.Fprintf(&b, " (%s)", strings.Join(finished, "、"))
+	}
+
+	switch {
+	case len(laggards) == 0:
+		b.WriteString("\n全員読了しました。おめでとうございます。")
+	case now.After(group.Book.Deadline):
+		fmt.Fprintf(&b, "\n期限を過ぎても読み終えていないのは%sさんです。読書会の名が泣いています。", strings.Join(laggards, "さん、"))
+	default:
+		fmt.Fprintf(&b, "\nまだ読み終えていない: %s", strings.Join(laggards, "、"))
+	}
+	return b.String()
+}
+
+// postToGroup は読書会に message を送る。LINE グループがあればそこに、なければメンバー全員に送る
+func (s *Server) postToGroup(ctx context.Context, group Group, message string) error {
+	if group.LineGroupID != "" {
+		return s.sendLineMessage(ctx, group.LineGroupID, message)
+	}
+	var errs []error
+	for _, memberID := range group.Members {
+		if err := s.sendLineMessage(ctx, memberID, message); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", memberID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// updateGroup は読書会をトランザクションで読み、update に渡す。書き込みは update が行う
+func (s *Server) updateGroup(ctx context.Context, groupID string, update func(tx *firestore.Transaction, group *Group) error) error {
+	ref := s.firestoreClient.Collection("groups").Doc(groupID)
+	return s.firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound {
+			return errGroupNotFound
+		}
+		if err != nil {
+			return err
+		}
+		var group Group
+		if err := doc.DataTo(&group); err != nil {
+			return err
+		}
+		return update(tx, &group)
+	})
+}
+
+// writeGroupError は err があればレスポンスを書いて true を返す
+func writeGroupError(w http.ResponseWriter, r *http.Request, err error) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, errGroupNotFound):
+		writeProblem(w, r, http.StatusNotFound, "Group not found")
+	case errors.Is(err, errNotGroupOwner):
+		writeProblem(w, r, http.StatusForbidden, "Only the group owner can do this")
+	case errors.Is(err, errNotGroupMember):
+		writeProblem(w, r, http.StatusForbidden, "User is not a member or invitee of this group")
+	case errors.Is(err, errGroupFull):
+		writeProblem(w, r, http.StatusConflict, fmt.Sprintf("A group can have at most %d members", maxGroupMembers))
+	default:
+		writeServerError(w, r, err, "Failed to update group")
+	}
+	return true
+}
+
+func decodeGroupRequest(w http.ResponseWriter, r *http.Request, req *groupMemberRequest) bool {
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid JSON body: %v", err))
+		return false
+	}
+	if err := req.Validate(); err != nil {
+		writeValidationError(w, r, err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,266 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"tundoku-killer/backend/internal/cron"
+	tundokuv1 "tundoku-killer/backend/internal/pb/tundoku/v1"
+	"tundoku-killer/backend/internal/store"
+	"tundoku-killer/backend/internal/validation"
+)
+
+// newGRPCServer は BookService と NotificationService を提供する gRPC サーバーを作る。
+// HTTP と同じポートで h2c (平文の HTTP/2) として受け付ける (serveGRPC 参照)
+func (s *Server) newGRPCServer() *grpc.Server {
+	server := grpc.NewServer(
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+		grpc.ChainUnaryInterceptor(s.cronAuthUnaryInterceptor),
+		grpc.ChainStreamInterceptor(s.cronAuthStreamInterceptor),
+	)
+	tundokuv1.RegisterBookServiceServer(server, bookServer{s: s})
+	tundokuv1.RegisterNotificationServiceServer(server, notificationServer{s: s})
+	reflection.Register(server)
+	return server
+}
+
+// newGatewayHandler は BookService を REST として公開する grpc-gateway のハンドラーを作る
+func (s *Server) newGatewayHandler(ctx context.Context) (http.Handler, error) {
+	mux := runtime.NewServeMux(runtime.WithErrorHandler(gatewayErrorHandler))
+	if err := tundokuv1.RegisterBookServiceHandlerServer(ctx, mux, bookServer{s: s}); err != nil {
+		return nil, err
+	}
+	return mux, nil
+}
+
+// serveGRPC は gRPC のリクエストを grpcServer に、それ以外を next に振り分ける
+func serveGRPC(grpcServer *grpc.Server, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			grpcServer.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// gatewayErrorHandler は grpc-gateway のエラーを REST API と同じ problem+json で返す
+func gatewayErrorHandler(ctx context.Context, _ *runtime.ServeMux, _ runtime.Marshaler, w http.ResponseWriter, r *http.Request, err error) {
+	st := status.Convert(err)
+	writeProblem(w, r, runtime.HTTPStatusFromCode(st.Code()), st.Message())
+}
+
+// bookServer は BookService の実装。処理は books.go の関数に委ねる
+type bookServer struct {
+	tundokuv1.UnimplementedBookServiceServer
+	s *Server
+}
+
+func (b bookServer) ListBooks(ctx context.Context, req *tundokuv1.ListBooksRequest) (*tundokuv1.ListBooksResponse, error) {
+	if req.GetUserId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "user_id is required")
+	}
+	books, err := b.s.listBooks(ctx, req.GetUserId())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	resp := &tundokuv1.ListBooksResponse{}
+	for _, book := range books {
+		resp.Books = append(resp.Books, bookToProto(book))
+	}
+	return resp, nil
+}
+
+func (b bookServer) RegisterBook(ctx context.Context, req *tundokuv1.RegisterBookRequest) (*tundokuv1.Book, error) {
+	book := bookFromProto(req.GetBook())
+	book.UserID = req.GetUserId()
+
+	// 購入禁止モードを押し切るフラグは REST (?override=true) にしかない
+	ban, err := b.s.purchaseBan(ctx, book)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	if ban != nil {
+		return nil, status.Error(codes.FailedPrecondition, ban.Message)
+	}
+
+	book, err = b.s.registerBook(ctx, book)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return bookToProto(book), nil
+}
+
+func (b bookServer) UpdateBook(ctx context.Context, req *tundokuv1.UpdateBookRequest) (*tundokuv1.Book, error) {
+	book := bookFromProto(req.GetBook())
+	if err := b.s.updateBook(ctx, book); err != nil {
+		return nil, grpcError(err)
+	}
+	return bookToProto(book), nil
+}
+
+func (b bookServer) DeleteBook(ctx context.Context, req *tundokuv1.DeleteBookRequest) (*emptypb.Empty, error) {
+	err := b.s.deleteBook(ctx, deleteBookRequest{BookID: req.GetBookId(), UserID: req.GetUserId()})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (b bookServer) CompleteBook(ctx context.Context, req *tundokuv1.CompleteBookRequest) (*emptypb.Empty, error) {
+	if err := b.s.completeBook(ctx, completeBookRequest{BookID: req.GetBookId()}); err != nil {
+		return nil, grpcError(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// notificationServer は NotificationService の実装。cron と同じ処理を呼び出す
+type notificationServer struct {
+	tundokuv1.UnimplementedNotificationServiceServer
+	s *Server
+}
+
+func (n notificationServer) ListOverdueBooks(_ *tundokuv1.ListOverdueBooksRequest, stream grpc.ServerStreamingServer[tundokuv1.OverdueBook]) error {
+	ctx := stream.Context()
+	now := time.Now()
+	cycle := cron.Cycle(now)
+
+	cursor := ""
+	for {
+		books, err := n.s.bookRepo.QueryOverdue(ctx, now, cursor, cron.PageSize)
+		if err != nil {
+			return grpcError(err)
+		}
+
+		for _, book := range books {
+			if !isOverdue(book, now) || book.LastInsultCycle == cycle {
+				continue
+			}
+
+			generated, err := n.s.generateInsult(ctx, book)
+			if err != nil {
+				return grpcError(err)
+			}
+			if err := stream.Send(&tundokuv1.OverdueBook{Book: bookToProto(book), Message: generated.Text}); err != nil {
+				return err
+			}
+		}
+
+		if len(books) < cron.PageSize {
+			return nil
+		}
+		cursor = books[len(books)-1].BookID
+	}
+}
+
+func (n notificationServer) SendInsult(ctx context.Context, req *tundokuv1.SendInsultRequest) (*emptypb.Empty, error) {
+	if req.GetBookId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "book_id is required")
+	}
+	if err := n.s.processOverdueBook(ctx, req.GetBookId(), cron.Cycle(time.Now()), nil); err != nil {
+		return nil, grpcError(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// cronAuthUnaryInterceptor は NotificationService の呼び出しに CRON_SECRET を要求する
+func (s *Server) cronAuthUnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if err := s.authorizeNotificationCall(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (s *Server) cronAuthStreamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := s.authorizeNotificationCall(ss.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+func (s *Server) authorizeNotificationCall(ctx context.Context, fullMethod string) error {
+	if !strings.HasPrefix(fullMethod, "/"+tundokuv1.NotificationService_ServiceDesc.ServiceName+"/") {
+		return nil
+	}
+	cronSecret := s.cronSecret()
+	if cronSecret == "" {
+		return nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get("authorization") {
+		if v == "Bearer "+cronSecret {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "Unauthorized")
+}
+
+// grpcError は books.go などのエラーを gRPC のステータスに変換する。内部エラーの詳細は返さない
+func grpcError(err error) error {
+	var fieldErrs validation.Errors
+	var capErr *UnreadCapError
+	switch {
+	case errors.As(err, &fieldErrs):
+		return status.Error(codes.InvalidArgument, fieldErrs.Error())
+	case errors.As(err, &capErr):
+		return status.Error(codes.FailedPrecondition, capErr.Message)
+	case errors.Is(err, store.ErrBookNotFound):
+		return status.Error(codes.NotFound, "Book not found")
+	case errors.Is(err, errNotBookOwner):
+		return status.Error(codes.PermissionDenied, "Unauthorized")
+	case errors.Is(err, errQuizRequired):
+		return status.Error(codes.FailedPrecondition, "Hard mode is on: answer the quiz to complete this book")
+	case errors.Is(err, store.ErrDeadlineChanged):
+		return status.Error(codes.Aborted, "The deadline was changed in the meantime")
+	default:
+		log.Printf("gRPC internal error: %v", err)
+		return status.Error(codes.Internal, "Internal error")
+	}
+}
+
+func bookToProto(book store.Book) *tundokuv1.Book {
+	pb := &tundokuv1.Book{
+		BookId:          book.BookID,
+		UserId:          book.UserID,
+		Title:           book.Title,
+		Author:          book.Author,
+		Status:          book.Status,
+		InsultLevel:     int32(book.InsultLevel),
+		LastInsultCycle: book.LastInsultCycle,
+	}
+	if !book.Deadline.IsZero() {
+		pb.Deadline = timestamppb.New(book.Deadline)
+	}
+	return pb
+}
+
+func bookFromProto(pb *tundokuv1.Book) store.Book {
+	book := store.Book{
+		BookID:          pb.GetBookId(),
+		UserID:          pb.GetUserId(),
+		Title:           pb.GetTitle(),
+		Author:          pb.GetAuthor(),
+		Status:          pb.GetStatus(),
+		InsultLevel:     int(pb.GetInsultLevel()),
+		LastInsultCycle: pb.GetLastInsultCycle(),
+	}
+	if pb.GetDeadline() != nil {
+		book.Deadline = pb.GetDeadline().AsTime()
+	}
+	return book
+}
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"tundoku-killer/backend/internal/cache"
+)
+
+// healthCheckTimeout は依存先1つあたりの確認の待ち時間
+const healthCheckTimeout = 3 * time.Second
+
+// 依存先の状態
+const (
+	healthOK      = "ok"
+	healthError   = "error"
+	healthSkipped = "skipped" // 使っていない (LINE_MESSENGER=console など)
+)
+
+var errLINETokenMissing = errors.New("LINE_CHANNEL_ACCESS_TOKEN is not set")
+
+// dependencyHealth は依存先1つの確認結果
+type dependencyHealth struct {
+	Status    string `json:"status"`
+	LatencyMS int64  `json:"latencyMs"`
+	Error     string `json:"error,omitempty"`
+}
+
+// healthReport は /health/ready のレスポンス。1つでも error があれば Status も error (503)
+type healthReport struct {
+	Status string                      `json:"status"`
+	Checks map[string]dependencyHealth `json:"checks"`
+}
+
+// handleReadyHealth は Firestore などの依存先に実際に問い合わせ、依存先ごとの状態を返す。
+// ロードバランサーや死活監視が「プロセスは動いているが Firestore に届かない」状態も検知できる
+func (s *Server) handleReadyHealth(w http.ResponseWriter, r *http.Request) {
+	report := s.checkDependencies(r.Context())
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if report.Status != healthOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+// checkDependencies は依存先を順に確認する
+func (s *Server) checkDependencies(ctx context.Context) healthReport {
+	checks := map[string]func(context.Context) (string, error){
+		"firestore": s.checkFirestore,
+		"line":      s.checkLINE,
+	}
+	if r, ok := s.cache.(*cache.Redis); ok {
+		checks["redis"] = func(ctx context.Context) (string, error) {
+			return healthOK, r.Ping(ctx)
+		}
+	}
+	if s.sqlDB != nil {
+		checks["sql"] = func(ctx context.Context) (string, error) {
+			return healthOK, s.sqlDB.PingContext(ctx)
+		}
+	}
+
+	report := healthReport{Status: healthOK, Checks: make(map[string]dependencyHealth, len(checks))}
+	for name, check := range checks {
+		ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+		start := time.Now()
+		state, err := check(ctx)
+		cancel()
+
+		dep := dependencyHealth{Status: state, LatencyMS: time.Since(start).Milliseconds()}
+		if err != nil {
+			dep.Status = healthError
+			dep.Error = err.Error()
+			report.Status = healthError
+			s.logger.Printf("Health check %s failed: %v", name, err)
+		}
+		report.Checks[name] = dep
+	}
+	return report
+}
+
+// checkFirestore はドキュメントを1つ読んで Firestore に届くかを確かめる。ドキュメントがなくても届いていれば ok
+func (s *Server) checkFirestore(ctx context.Context) (string, error) {
+	_, err := s.firestoreClient.Collection("health").Doc("ping").Get(ctx)
+	if err != nil && status.Code(err) != codes.NotFound {
+		return healthError, err
+	}
+	return healthOK, nil
+}
+
+// checkLINE は LINE のチャネルのトークンが設定されているかを確かめる (LINE API は呼ばない)
+func (s *Server) checkLINE(context.Context) (string, error) {
+	if s.cfg.LINE.Messenger == "console" {
+		return healthSkipped, nil
+	}
+	token := s.cfg.LINE.ChannelAccessToken
+	if s.secrets != nil {
+		token = s.secrets.Getenv("LINE_CHANNEL_ACCESS_TOKEN")
+	}
+	if token == "" {
+		return healthError, errLINETokenMissing
+	}
+	return healthOK, nil
+}
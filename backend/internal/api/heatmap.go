@@ -0,0 +1,80 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"tundoku-killer/backend/internal/cron"
+	"tundoku-killer/backend/internal/store"
+)
+
+// 読書の活動量を日ごとに数えたヒートマップ (GitHub の contribution graph のような表示用)。
+// 今のところ記録している活動は読了だけなので、読了日時 (JST) の日ごとの冊数を数える
+
+// heatmapDays はヒートマップに含める日数 (今日を含む直近1年)
+const heatmapDays = 365
+
+// HeatmapDay はヒートマップの1日分
+type HeatmapDay struct {
+	Date      string `json:"date"` // JST の "2006-01-02"
+	Completed int    `json:"completed"`
+}
+
+// handleHeatmap は ?userId= のユーザーの直近1年の日ごとの活動量を、古い日から順にすべての日について返す
+func (s *Server) handleHeatmap(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	userID := r.URL.Query().Get("userId")
+	if userID == "" {
+		writeProblem(w, r, http.StatusBadRequest, "userId query parameter is required")
+		return
+	}
+
+	books, err := s.listBooks(r.Context(), userID)
+	if err != nil {
+		writeServerError(w, r, err, "Failed to retrieve books")
+		return
+	}
+	days, peak := computeHeatmap(books, time.Now())
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"userId": userID,
+		"from":   days[0].Date,
+		"to":     days[len(days)-1].Date,
+		"max":    peak, // 色の濃さを決めるための、1日の最大値
+		"days":   days,
+	})
+}
+
+// computeHeatmap は now (JST) までの heatmapDays 日分の読了数と、その最大値を返す
+func computeHeatmap(books []store.Book, now time.Time) ([]HeatmapDay, int) {
+	today := now.In(cron.Location)
+	start := time.Date(today.Year(), today.Month(), today.Day()-(heatmapDays-1), 0, 0, 0, 0, cron.Location)
+
+	days := make([]HeatmapDay, heatmapDays)
+	index := make(map[string]int, heatmapDays)
+	for i := range days {
+		date := start.AddDate(0, 0, i).Format("2006-01-02")
+		days[i].Date = date
+		index[date] = i
+	}
+
+	peak := 0
+	for _, book := range books {
+		if book.Status != "completed" || book.CompletedAt == nil {
+			continue
+		}
+		i, ok := index[book.CompletedAt.In(cron.Location).Format("2006-01-02")]
+		if !ok {
+			continue
+		}
+		days[i].Completed++
+		peak = max(peak, days[i].Completed)
+	}
+	return days, peak
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"tundoku-killer/backend/internal/insult"
+	"tundoku-killer/backend/internal/store"
+)
+
+// 生成AIに書かせた煽り文は、本ごとに (煽りレベル, 言語) をキーにして generatedInsults/{bookId} に1件だけ置く。
+// 送信の失敗による再試行・Pub/Sub の再配信・ドライランのあとの本番など、同じレベルでもう一度送るときは
+// 生成AIを呼ばずに同じ文面を使い、送れてレベルが上がったら書き直す
+
+// insultLocale は煽り文の言語。いまは日本語だけなので固定で、ほかの言語を足したらユーザーの設定から決める
+const insultLocale = "ja"
+
+// generatedInsultTTL を過ぎた文面は使わない (プロンプトや割り当てを変えたあとに古い文面が残り続けないように)
+const generatedInsultTTL = 7 * 24 * time.Hour
+
+// GeneratedInsult は generatedInsults/{bookId} に保存する、生成AIが書いた煽り文
+type GeneratedInsult struct {
+	InsultLevel int       `firestore:"insultLevel"`
+	Locale      string    `firestore:"locale"`
+	Text        string    `firestore:"text"`
+	Variant     string    `firestore:"variant,omitempty"`
+	Provider    string    `firestore:"provider"`
+	GeneratedAt time.Time `firestore:"generatedAt"`
+}
+
+// cachedInsult は book の今のレベルで生成済みの煽り文があれば返す。なければ ok が false
+func (s *Server) cachedInsult(ctx context.Context, book store.Book) (insult.Insult, bool) {
+	doc, err := s.firestoreClient.Collection("generatedInsults").Doc(book.BookID).Get(ctx)
+	if err != nil {
+		if status.Code(err) != codes.NotFound {
+			s.logger.Printf("Error reading generated insult for book %s: %v", book.BookID, err)
+		}
+		return insult.Insult{}, false
+	}
+	var cached GeneratedInsult
+	if err := doc.DataTo(&cached); err != nil {
+		s.logger.Printf("Error parsing generated insult for book %s: %v", book.BookID, err)
+		return insult.Insult{}, false
+	}
+	if cached.InsultLevel != book.InsultLevel || cached.Locale != insultLocale || time.Since(cached.GeneratedAt) > generatedInsultTTL {
+		return insult.Insult{}, false
+	}
+	return insult.Insult{Text: cached.Text, Variant: cached.Variant, Provider: cached.Provider}, true
+}
+
+// cacheInsult は生成AIが書いた煽り文を book の今のレベルの文面として保存する。失敗してもログに残すだけ
+func (s *Server) cacheInsult(ctx context.Context, book store.Book, generated insult.Insult) {
+	_, err := s.firestoreClient.Collection("generatedInsults").Doc(book.BookID).Set(ctx, GeneratedInsult{
+		InsultLevel: book.InsultLevel,
+		Locale:      insultLocale,
+		Text:        generated.Text,
+		Variant:     generated.Variant,
+		Provider:    generated.Provider,
+		GeneratedAt: time.Now(),
+	})
+	if err != nil {
+		s.logger.Printf("Error caching generated insult for book %s: %v", book.BookID, err)
+	}
+}
+
+// forgetInsult は読み終えた・削除した本の生成済みの煽り文を消す
+func (s *Server) forgetInsult(ctx context.Context, bookID string) {
+	_, err := s.firestoreClient.Collection("generatedInsults").Doc(bookID).Delete(ctx)
+	if err != nil && status.Code(err) != codes.NotFound {
+		s.logger.Printf("Error deleting generated insult for book %s: %v", bookID, err)
+	}
+}
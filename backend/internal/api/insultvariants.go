@@ -0,0 +1,165 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"google.golang.org/api/iterator"
+
+	"tundoku-killer/backend/internal/validation"
+)
+
+// 煽り文の A/B テスト (INSULT_VARIANTS) の結果の集計。
+// 本ごとに期間内で最初に送った煽り文の種類をその本の種類とし、そのあと読み終えたかを比べる
+
+const (
+	defaultVariantStatsDays = 90
+	maxVariantStatsDays     = 365
+)
+
+// VariantStats は煽り文の種類1つ分の集計
+type VariantStats struct {
+	Variant string `json:"variant"`
+	Users   int    `json:"users"`   // この種類の煽り文を受け取ったユーザーの数
+	Books   int    `json:"books"`   // この種類で煽られた本の数
+	Insults int    `json:"insults"` // 送った煽り文の数
+	// Completed は最初に煽られたあとに読み終えた本の数
+	Completed      int     `json:"completed"`
+	Deleted        int     `json:"deleted"`        // 読み終えずに削除された本の数
+	CompletionRate float64 `json:"completionRate"` // Completed / Books (0〜1)
+	// 最初に煽られてから読み終えるまでの平均日数
+	AverageDaysToComplete *float64 `json:"averageDaysToComplete"`
+}
+
+// handleInsultVariantStats は直近 ?days= 日に送った煽り文を種類ごとに集計し、読了率の高い順に返す。
+// ai-* の煽り文を書かせている生成AIのプロバイダーごとの状態も添える
+func (s *Server) handleInsultVariantStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	days := defaultVariantStatsDays
+	var v validation.Validator
+	if raw := r.URL.Query().Get("days"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		v.Check(err == nil, "days", "must be an integer")
+		v.Range("days", n, 1, maxVariantStatsDays)
+		days = n
+	}
+	if err := v.Err(); err != nil {
+		writeValidationError(w, r, err)
+		return
+	}
+
+	since := time.Now().AddDate(0, 0, -days)
+	stats, err := s.insultVariantStats(r.Context(), since)
+	if err != nil {
+		writeServerError(w, r, err, "Failed to compute insult variant stats")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"since":     since,
+		"active":    s.cfg.Insult.Variants,
+		"variants":  stats,
+		"providers": s.llmProviders.Health(),
+	})
+}
+
+// insultVariantStats は since 以降の種類付きの煽り文を読み、煽られた本の現在の状態と突き合わせる
+func (s *Server) insultVariantStats(ctx context.Context, since time.Time) ([]VariantStats, error) {
+	type insultedBook struct {
+		variant string
+		first   time.Time
+	}
+	books := make(map[string]*insultedBook)         // bookID → 最初の煽り文
+	booksByUser := make(map[string]map[string]bool) // userID → 煽られた本のID
+	byVariant := make(map[string]*VariantStats)
+	usersByVariant := make(map[string]map[string]bool)
+
+	iter := s.firestoreClient.Collection("insults").
+		Where("sentAt", ">=", since).
+		Select("bookId", "userId", "variant", "sentAt").
+		Documents(ctx)
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var rec InsultRecord
+		if err := doc.DataTo(&rec); err != nil || rec.Variant == "" {
+			continue // A/B テスト前の煽り文
+		}
+		vs, ok := byVariant[rec.Variant]
+		if !ok {
+			vs = &VariantStats{Variant: rec.Variant}
+			byVariant[rec.Variant] = vs
+			usersByVariant[rec.Variant] = make(map[string]bool)
+		}
+		vs.Insults++
+		usersByVariant[rec.Variant][rec.UserID] = true
+
+		// 種類が途中で変わった本 (INSULT_VARIANTS を変えたとき) は最初の種類に数える
+		if b, ok := books[rec.BookID]; !ok || rec.SentAt.Before(b.first) {
+			books[rec.BookID] = &insultedBook{variant: rec.Variant, first: rec.SentAt}
+		}
+		if booksByUser[rec.UserID] == nil {
+			booksByUser[rec.UserID] = make(map[string]bool)
+		}
+		booksByUser[rec.UserID][rec.BookID] = true
+	}
+
+	completeDays := make(map[string][]float64)
+	for userID, bookIDs := range booksByUser {
+		shelf, err := s.bookRepo.List(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		current := make(map[string]int, len(shelf))
+		for i, book := range shelf {
+			current[book.BookID] = i
+		}
+		for bookID := range bookIDs {
+			b := books[bookID]
+			vs := byVariant[b.variant]
+			vs.Books++
+			i, ok := current[bookID]
+			if !ok {
+				// 読了後にアーカイブに移った本もここに入るが、COMPLETED_RETENTION が集計の期間より短くなければ起きない
+				vs.Deleted++
+				continue
+			}
+			book := shelf[i]
+			if book.Status == "completed" && book.CompletedAt != nil && book.CompletedAt.After(b.first) {
+				vs.Completed++
+				completeDays[b.variant] = append(completeDays[b.variant], daysBetween(b.first, *book.CompletedAt))
+			}
+		}
+	}
+
+	stats := make([]VariantStats, 0, len(byVariant))
+	for variant, vs := range byVariant {
+		vs.Users = len(usersByVariant[variant])
+		if vs.Books > 0 {
+			vs.CompletionRate = roundTo(float64(vs.Completed)/float64(vs.Books), 3)
+		}
+		vs.AverageDaysToComplete = average(completeDays[variant])
+		stats = append(stats, *vs)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].CompletionRate != stats[j].CompletionRate {
+			return stats[i].CompletionRate > stats[j].CompletionRate
+		}
+		return stats[i].Variant < stats[j].Variant
+	})
+	return stats, nil
+}
@@ -0,0 +1,242 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"tundoku-killer/backend/internal/cron"
+	"tundoku-killer/backend/internal/insult"
+)
+
+// 煽り文の評価 (feedback.go) から、用意された煽り文 (テンプレート) ごとの選ばれやすさを計算する。
+// 全員の評価から insultWeights/global を、各ユーザーの評価から userInsultWeights/{userId} を
+// cron (/cron/insult-weights) で作り直し、送るときは両方を掛け合わせた重みで選ぶ
+
+const insultWeightsLease = "insultWeights"
+
+// feedbackScores は評価ごとの点数。1 が基準で、効いたものは選ばれやすく、不快なものはほぼ選ばれなくする
+var feedbackScores = map[string]float64{
+	feedbackHit:       2,
+	feedbackMiss:      0.5,
+	feedbackOffensive: 0.1,
+}
+
+const (
+	// globalWeightPrior・userWeightPrior は評価の少ないテンプレートを基準 (1) に寄せるための仮の評価数。
+	// ユーザーごとの重みは全体の重みに掛けるので、少ない評価でも効くよう小さくしている
+	globalWeightPrior = 5
+	userWeightPrior   = 1
+
+	// globalWeightsTTL は全体の重みをプロセス内に置く時間 (cron で作り直す間隔より十分短く)
+	globalWeightsTTL = 10 * time.Minute
+)
+
+// InsultWeights は insultWeights/global・userInsultWeights/{userId} に保存する重み
+type InsultWeights struct {
+	Templates  map[string]float64 `json:"templates" firestore:"templates"` // テンプレートのID → 重み
+	Feedback   int                `json:"feedback" firestore:"feedback"`   // 計算に使った評価の数
+	ComputedAt time.Time          `json:"computedAt" firestore:"computedAt"`
+}
+
+// insultWeightStore は insult.WeightSource。全体の重みは globalWeightsTTL の間キャッシュし、
+// ユーザーごとの重みは送るたびに読む (煽り文を送る頻度ならこれで十分)
+type insultWeightStore struct {
+	client *firestore.Client
+
+	mu            sync.Mutex
+	global        insult.Weights
+	globalExpires time.Time
+}
+
+func (s *insultWeightStore) Weights(ctx context.Context, userID string) (insult.Weights, error) {
+	global, err := s.globalWeights(ctx)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.readWeights(ctx, s.client.Collection("userInsultWeights").Doc(userID))
+	if err != nil {
+		return global, err
+	}
+	if len(user) == 0 {
+		return global, nil
+	}
+
+	combined := make(insult.Weights, len(global)+len(user))
+	for id, w := range global {
+		combined[id] = w
+	}
+	for id, w := range user {
+		combined[id] = global.Of(id) * w
+	}
+	return combined, nil
+}
+
+func (s *insultWeightStore) globalWeights(ctx context.Context) (insult.Weights, error) {
+	now := time.Now()
+	s.mu.Lock()
+	global, expires := s.global, s.globalExpires
+	s.mu.Unlock()
+	if now.Before(expires) {
+		return global, nil
+	}
+
+	global, err := s.readWeights(ctx, s.client.Collection("insultWeights").Doc("global"))
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.global, s.globalExpires = global, now.Add(globalWeightsTTL)
+	s.mu.Unlock()
+	return global, nil
+}
+
+// readWeights は ref の重みを読む。まだ計算されていなければ nil (すべて基準の重み)
+func (s *insultWeightStore) readWeights(ctx context.Context, ref *firestore.DocumentRef) (insult.Weights, error) {
+	doc, err := ref.Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var w InsultWeights
+	if err := doc.DataTo(&w); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", ref.Path, err)
+	}
+	return w.Templates, nil
+}
+
+// feedbackTally はテンプレートごとの評価の点数の合計と件数
+type feedbackTally map[string]struct {
+	sum float64
+	n   int
+}
+
+func (t feedbackTally) add(template string, score float64) {
+	v := t[template]
+	v.sum += score
+	v.n++
+	t[template] = v
+}
+
+// weights は評価の平均を prior 件の基準 (1) の評価と混ぜて重みにする
+func (t feedbackTally) weights(prior float64) map[string]float64 {
+	w := make(map[string]float64, len(t))
+	for id, v := range t {
+		w[id] = (prior + v.sum) / (prior + float64(v.n))
+	}
+	return w
+}
+
+// handleInsultWeightsCron は評価の付いた煽り文を集計し、全体とユーザーごとの重みを作り直す
+func (s *Server) handleInsultWeightsCron(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodGet {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	ctx := context.WithoutCancel(r.Context())
+
+	if !s.authorizeCron(r) {
+		writeProblem(w, r, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	runID := uuid.NewString()
+	if err := s.cron.AcquireLease(ctx, insultWeightsLease, runID, cron.LeaseTTL); err != nil {
+		if errors.Is(err, cron.ErrLeaseHeld) {
+			writeProblem(w, r, http.StatusConflict, "Another insult weight computation is already running")
+			return
+		}
+		writeServerError(w, r, err, "Failed to acquire lock")
+		return
+	}
+	defer s.cron.ReleaseLease(ctx, insultWeightsLease, runID)
+
+	docs, err := s.firestoreClient.Collection("insults").
+		Where("feedback.rating", "in", []string{feedbackHit, feedbackMiss, feedbackOffensive}).
+		Documents(ctx).GetAll()
+	if err != nil {
+		writeServerError(w, r, err, "Failed to list insult feedback")
+		return
+	}
+
+	// Gemini などテンプレートを使っていない煽り文の評価は数えない
+	global := feedbackTally{}
+	users := map[string]feedbackTally{}
+	feedback := 0
+	for _, doc := range docs {
+		var rec InsultRecord
+		if err := doc.DataTo(&rec); err != nil {
+			s.logger.Printf("Error parsing insult %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		if rec.Template == "" || rec.Feedback == nil {
+			continue
+		}
+		score := feedbackScores[rec.Feedback.Rating]
+		global.add(rec.Template, score)
+		if users[rec.UserID] == nil {
+			users[rec.UserID] = feedbackTally{}
+		}
+		users[rec.UserID].add(rec.Template, score)
+		feedback++
+	}
+
+	now := time.Now()
+	_, err = s.firestoreClient.Collection("insultWeights").Doc("global").Set(ctx, InsultWeights{
+		Templates:  global.weights(globalWeightPrior),
+		Feedback:   feedback,
+		ComputedAt: now,
+	})
+	if err != nil {
+		writeServerError(w, r, err, "Failed to save insult weights")
+		return
+	}
+
+	bw := s.firestoreClient.BulkWriter(ctx)
+	jobs := make([]*firestore.BulkWriterJob, 0, len(users))
+	for userID, tally := range users {
+		n := 0
+		for _, v := range tally {
+			n += v.n
+		}
+		job, err := bw.Set(s.firestoreClient.Collection("userInsultWeights").Doc(userID), InsultWeights{
+			Templates:  tally.weights(userWeightPrior),
+			Feedback:   n,
+			ComputedAt: now,
+		})
+		if err != nil {
+			s.logger.Printf("Error queueing insult weights for %s: %v", userID, err)
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	bw.End()
+	failed := 0
+	for _, job := range jobs {
+		if _, err := job.Results(); err != nil {
+			s.logger.Printf("Error saving user insult weights: %v", err)
+			failed++
+		}
+	}
+
+	s.logger.Printf("Insult weights recomputed from %d feedback (%d templates, %d users, %d failed)", feedback, len(global), len(users), failed)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"feedback":  feedback,
+		"templates": len(global),
+		"users":     len(users),
+		"failed":    failed,
+	})
+}
@@ -0,0 +1,379 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"tundoku-killer/backend/internal/cron"
+	"tundoku-killer/backend/internal/store"
+	"tundoku-killer/backend/internal/validation"
+)
+
+// Kindle のハイライトとメモの取り込み。端末の documents/My Clippings.txt (text/plain) か、
+// Kindle アプリのノートブックのエクスポート (text/html) を受け取り、書名で本棚の本に結びつけて bookNotes に保存する。
+// 電子書籍の積読も紙の本と同じように、どこまで読んだか (どこに線を引いたか) が見えるようにするため。
+// 本棚に無い本のハイライトは保存せず、書名だけを unmatched で返す (先に本を登録してから送り直せばよい)
+
+const (
+	maxKindleClippings = 20000
+	// maxKindleBodyBytes は取り込むファイルの大きさの上限。My Clippings.txt は1件数百バイトなので、maxKindleClippings 件が入るようにする
+	maxKindleBodyBytes = 32 << 20
+	maxNoteTextLength  = 5000 // これより長いハイライトは切り詰める
+	kindleSeparator    = "=========="
+)
+
+// KindleImportResult は取り込みの結果
+type KindleImportResult struct {
+	Imported        int                   `json:"imported"`        // 新しく保存したハイライトとメモ
+	AlreadyImported int                   `json:"alreadyImported"` // 前に取り込んだもの
+	Books           []KindleMatchedBook   `json:"books"`
+	Unmatched       []KindleUnmatchedBook `json:"unmatched"`
+	DryRun          bool                  `json:"dryRun"`
+}
+
+// KindleMatchedBook は本棚の本に結びついた Kindle の本
+type KindleMatchedBook struct {
+	BookID      string `json:"bookId"`
+	Title       string `json:"title"`
+	KindleTitle string `json:"kindleTitle"`
+	Notes       int    `json:"notes"`
+}
+
+// KindleUnmatchedBook は本棚に見つからなかった Kindle の本
+type KindleUnmatchedBook struct {
+	Title  string `json:"title"`
+	Author string `json:"author,omitempty"`
+	Notes  int    `json:"notes"`
+}
+
+// kindleClipping は Kindle のハイライトかメモ1件
+type kindleClipping struct {
+	Title    string
+	Author   string
+	Kind     string // NoteHighlight か NoteComment
+	Text     string
+	Location string
+	Page     string
+	At       *time.Time
+}
+
+// handleKindleImport は Kindle のハイライトとメモを userId の本棚の本に取り込む (POST ?userId=&dryRun=)
+func (s *Server) handleKindleImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	q := r.URL.Query()
+	userID := q.Get("userId")
+	var v validation.Validator
+	v.Required("userId", userID)
+	v.MaxLength("userId", userID, maxIDLength)
+	if err := v.Err(); err != nil {
+		writeValidationError(w, r, err)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			writeProblem(w, r, http.StatusRequestEntityTooLarge, "File too large; split My Clippings.txt or export one book at a time")
+			return
+		}
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("Failed to read body: %v", err))
+		return
+	}
+	text := strings.TrimPrefix(string(body), "\ufeff")
+	var clippings []kindleClipping
+	if strings.Contains(text, "noteText") {
+		clippings = parseKindleNotebook(text)
+	} else {
+		clippings = parseMyClippings(text)
+	}
+	if len(clippings) == 0 {
+		writeProblem(w, r, http.StatusBadRequest, "No highlights found; upload My Clippings.txt or a Kindle notebook export")
+		return
+	}
+	if len(clippings) > maxKindleClippings {
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("More than %d highlights; split the file", maxKindleClippings))
+		return
+	}
+
+	ctx := r.Context()
+	books, err := s.listBooks(ctx, userID)
+	if err != nil {
+		writeServerError(w, r, err, "Failed to retrieve books")
+		return
+	}
+	result, notes, err := s.matchKindleClippings(ctx, userID, books, clippings, time.Now())
+	if err != nil {
+		writeServerError(w, r, err, "Failed to retrieve notes")
+		return
+	}
+	result.DryRun = q.Get("dryRun") == "true"
+	if !result.DryRun && len(notes) > 0 {
+		if err := s.saveNotes(ctx, notes); err != nil {
+			writeServerError(w, r, err, "Failed to save notes")
+			return
+		}
+		s.logger.Printf("Imported %d Kindle notes for %s", len(notes), userID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// matchKindleClippings は clippings を書名で books に結びつけ、まだ保存していないノートを返す
+func (s *Server) matchKindleClippings(ctx context.Context, userID string, books []store.Book, clippings []kindleClipping, now time.Time) (KindleImportResult, []BookNote, error) {
+	result := KindleImportResult{Books: []KindleMatchedBook{}, Unmatched: []KindleUnmatchedBook{}}
+	matched := make(map[string]int)   // Kindle の書名 → result.Books の添字
+	unmatched := make(map[string]int) // Kindle の書名 → result.Unmatched の添字
+	existing := make(map[string]map[string]bool)
+	var notes []BookNote
+
+	for _, c := range clippings {
+		i, ok := matched[c.Title]
+		if !ok {
+			if j, ok := unmatched[c.Title]; ok {
+				result.Unmatched[j].Notes++
+				continue
+			}
+			book, found := matchKindleTitle(books, c.Title)
+			if !found {
+				unmatched[c.Title] = len(result.Unmatched)
+				result.Unmatched = append(result.Unmatched, KindleUnmatchedBook{Title: c.Title, Author: c.Author, Notes: 1})
+				continue
+			}
+			i = len(result.Books)
+			matched[c.Title] = i
+			result.Books = append(result.Books, KindleMatchedBook{BookID: book.BookID, Title: book.Title, KindleTitle: c.Title})
+		}
+		bookID := result.Books[i].BookID
+		result.Books[i].Notes++
+
+		if _, ok := existing[bookID]; !ok {
+			saved, err := s.bookNotes(ctx, bookID)
+			if err != nil {
+				return KindleImportResult{}, nil, err
+			}
+			existing[bookID] = make(map[string]bool, len(saved))
+			for _, note := range saved {
+				existing[bookID][note.NoteID] = true
+			}
+		}
+		note := BookNote{
+			UserID:        userID,
+			BookID:        bookID,
+			Kind:          c.Kind,
+			Text:          c.Text,
+			Location:      c.Location,
+			Page:          c.Page,
+			Source:        "kindle",
+			HighlightedAt: c.At,
+			CreatedAt:     now,
+		}
+		id := noteID(note)
+		if existing[bookID][id] {
+			result.AlreadyImported++
+			continue
+		}
+		existing[bookID][id] = true // 同じファイルの中の重複も1件にする
+		notes = append(notes, note)
+	}
+	result.Imported = len(notes)
+	return result, notes, nil
+}
+
+// matchKindleTitle は Kindle の書名の本を books から探す。書名が同じ本が無ければ、レーベルや副題を除いた書名で比べる。
+// 候補が2冊以上あれば結びつけない
+func matchKindleTitle(books []store.Book, title string) (store.Book, bool) {
+	key := kindleTitleKey(title, false)
+	for _, book := range books {
+		if kindleTitleKey(book.Title, false) == key {
+			return book, true
+		}
+	}
+	base := kindleTitleKey(title, true)
+	var candidates []store.Book
+	for _, book := range books {
+		if b := kindleTitleKey(book.Title, true); b != "" && b == base {
+			candidates = append(candidates, book)
+		}
+	}
+	if len(candidates) == 1 {
+		return candidates[0], true
+	}
+	return store.Book{}, false
+}
+
+var (
+	// kindleTitleSuffix は書名の後ろのレーベルや版の注記 ("(講談社文庫)"・"【電子特典付き】"・"(Japanese Edition)" など)
+	kindleTitleSuffix = regexp.MustCompile(`\s*[(（【\[][^()（）【】\[\]]*[)）】\]]\s*$`)
+	// kindleSubtitle は書名の副題 ("：" や " - " より後ろ)
+	kindleSubtitle = regexp.MustCompile(`\s*(?:[:：]|\s[-―—]\s).*$`)
+)
+
+// kindleTitleKey は書名を比べるためのキー。base なら注記と副題も除く
+func kindleTitleKey(title string, base bool) string {
+	if base {
+		for {
+			stripped := kindleTitleSuffix.ReplaceAllString(title, "")
+			if stripped == title {
+				break
+			}
+			title = stripped
+		}
+		title = kindleSubtitle.ReplaceAllString(title, "")
+	}
+	return strings.Join(strings.Fields(strings.ToLower(title)), "")
+}
+
+var (
+	kindleLocation = regexp.MustCompile(`(?i)(?:location|loc\.|位置no\.)\s*([0-9]+(?:-[0-9]+)?)`)
+	kindlePage     = regexp.MustCompile(`(?i)(?:page\s*([0-9ivxlcdm]+(?:-[0-9]+)?)|([0-9]+(?:-[0-9]+)?)\s*ページ)`)
+	kindleDateJA   = regexp.MustCompile(`(\d{4})年(\d{1,2})月(\d{1,2})日.*?(\d{1,2}):(\d{2}):(\d{2})`)
+	// kindleDateLayouts は英語の端末の "Added on" の後ろの書式 (地域によって日と月の順が違う)
+	kindleDateLayouts = []string{
+		"Monday, January 2, 2006 3:04:05 PM",
+		"Monday, 2 January 2006 15:04:05",
+		"Monday, January 2, 2006 15:04:05",
+	}
+)
+
+// parseMyClippings は My Clippings.txt を読む。1件は "==========" で区切られ、書名 (著者)・種類と位置と日時・空行・本文の順に並ぶ。
+// ブックマークと本文の無いものは飛ばす
+func parseMyClippings(text string) []kindleClipping {
+	var clippings []kindleClipping
+	for _, entry := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), kindleSeparator) {
+		lines := strings.Split(strings.Trim(entry, "\n\ufeff "), "\n")
+		if len(lines) < 3 {
+			continue
+		}
+		kind, ok := kindleNoteKind(lines[1])
+		if !ok {
+			continue
+		}
+		body := strings.TrimSpace(strings.Join(lines[2:], "\n"))
+		if body == "" {
+			continue
+		}
+		title, author := splitKindleTitle(strings.TrimSpace(lines[0]))
+		c := kindleClipping{Title: title, Author: author, Kind: kind, Text: truncateRunes(body, maxNoteTextLength)}
+		c.Location, c.Page = kindlePosition(lines[1])
+		c.At = kindleAddedAt(lines[1])
+		clippings = append(clippings, c)
+	}
+	return clippings
+}
+
+// kindleNoteBlock はノートブックのエクスポートの書名・著者・見出し・本文の要素。エクスポートの HTML は閉じタグが
+// 崩れていることがある (noteText が </h3> で終わる) ので、次の要素の始まりまでを中身とみなす
+var kindleNoteBlock = regexp.MustCompile(`(?s)<(?:div|h3) class=['"](bookTitle|authors|noteHeading|noteText)['"]>(.*?)(?:</div>|</h3>|<div|<h3|$)`)
+
+var htmlTag = regexp.MustCompile(`<[^>]*>`)
+
+// parseKindleNotebook は Kindle アプリのノートブックのエクスポート (HTML) を読む。1冊分なので、書名は先頭の1つだけ
+func parseKindleNotebook(text string) []kindleClipping {
+	var clippings []kindleClipping
+	var title, author, heading string
+	for _, m := range kindleNoteBlock.FindAllStringSubmatch(text, -1) {
+		value := strings.TrimSpace(html.UnescapeString(htmlTag.ReplaceAllString(m[2], "")))
+		switch m[1] {
+		case "bookTitle":
+			title = value
+		case "authors":
+			author = value
+		case "noteHeading":
+			heading = value
+		case "noteText":
+			kind, ok := kindleNoteKind(heading)
+			if !ok || value == "" || title == "" {
+				continue
+			}
+			c := kindleClipping{Title: title, Author: author, Kind: kind, Text: truncateRunes(value, maxNoteTextLength)}
+			c.Location, c.Page = kindlePosition(heading)
+			clippings = append(clippings, c)
+		}
+	}
+	return clippings
+}
+
+// kindleNoteKind は種類の行からノートの種類を返す。ブックマークなど取り込まないものは false
+func kindleNoteKind(meta string) (string, bool) {
+	lower := strings.ToLower(meta)
+	switch {
+	case strings.Contains(lower, "highlight") || strings.Contains(meta, "ハイライト"):
+		return NoteHighlight, true
+	case strings.Contains(lower, "note") || strings.Contains(meta, "メモ"):
+		return NoteComment, true
+	}
+	return "", false
+}
+
+// kindlePosition は種類の行から位置No. とページを返す
+func kindlePosition(meta string) (location, page string) {
+	if m := kindleLocation.FindStringSubmatch(meta); m != nil {
+		location = m[1]
+	}
+	if m := kindlePage.FindStringSubmatch(meta); m != nil {
+		page = m[1] + m[2]
+	}
+	return location, page
+}
+
+// kindleAddedAt は種類の行から付けた日時を返す。端末の時刻は分からないので JST とみなす。読めなければ nil
+func kindleAddedAt(meta string) *time.Time {
+	if m := kindleDateJA.FindStringSubmatch(meta); m != nil {
+		n := make([]int, 6)
+		for i := range n {
+			n[i], _ = strconv.Atoi(m[i+1])
+		}
+		at := time.Date(n[0], time.Month(n[1]), n[2], n[3], n[4], n[5], 0, cron.Location)
+		return &at
+	}
+	if _, added, ok := strings.Cut(meta, "Added on "); ok {
+		for _, layout := range kindleDateLayouts {
+			if at, err := time.ParseInLocation(layout, strings.TrimSpace(added), cron.Location); err == nil {
+				return &at
+			}
+		}
+	}
+	return nil
+}
+
+// splitKindleTitle は "書名 (著者)" を書名と著者に分ける
+func splitKindleTitle(line string) (title, author string) {
+	if strings.HasSuffix(line, ")") {
+		if i := strings.LastIndex(line, " ("); i > 0 {
+			return strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+2 : len(line)-1])
+		}
+	}
+	return line, ""
+}
+
+// locationStart は位置No. ("170-172") の始まり。位置が無ければ一番後ろに並べる
+func locationStart(location string) int {
+	start, _, _ := strings.Cut(location, "-")
+	n, err := strconv.Atoi(start)
+	if err != nil {
+		return int(^uint(0) >> 1)
+	}
+	return n
+}
+
+// truncateRunes は s を最大 n 文字に切り詰める
+func truncateRunes(s string, n int) string {
+	if runes := []rune(s); len(runes) > n {
+		return string(runes[:n])
+	}
+	return s
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"tundoku-killer/backend/internal/config"
+)
+
+// myClippings は n 件のハイライトが入った My Clippings.txt を作る
+func myClippings(n int) string {
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "積読の本 (著者)\r\n- 位置No. %d-%d のハイライト | 作成日: 2024年1月2日火曜日 3:04:05\r\n\r\n%s\r\n%s\r\n",
+			i*10, i*10+5, strings.Repeat("線を引いた文。", 10), kindleSeparator)
+	}
+	return b.String()
+}
+
+func TestKindleImportAcceptsFilesOverDefaultBodyLimit(t *testing.T) {
+	const n = 8000
+	body := myClippings(n)
+	if len(body) <= config.DefaultMaxBodyBytes {
+		t.Fatalf("test file is %d bytes; want more than %d", len(body), config.DefaultMaxBodyBytes)
+	}
+
+	parsed := 0
+	handler := limitBody(config.DefaultMaxBodyBytes, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("reading body: %v", err)
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		parsed = len(parseMyClippings(string(b)))
+	}))
+
+	for _, path := range []string{"/v1/books/import/kindle", "/api/books/import/kindle"} {
+		t.Run(path, func(t *testing.T) {
+			parsed = 0
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path+"?userId=u1", strings.NewReader(body)))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200", rec.Code)
+			}
+			if parsed != n {
+				t.Errorf("parsed %d clippings, want %d", parsed, n)
+			}
+		})
+	}
+}
+
+func TestLimitBodyKeepsDefaultForOtherRoutes(t *testing.T) {
+	body := strings.Repeat("x", config.DefaultMaxBodyBytes+1)
+	handler := limitBody(config.DefaultMaxBodyBytes, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("handler called for an oversized body")
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/books", strings.NewReader(body)))
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d, want 413", rec.Code)
+	}
+}
+
+func TestParseMyClippings(t *testing.T) {
+	text := "\ufeff本の題名 (山田 太郎)\r\n- 位置No. 120-125 のハイライト | 作成日: 2024年1月2日火曜日 3:04:05\r\n\r\nハイライトの本文\r\n==========\r\n" +
+		"本の題名 (山田 太郎)\r\n- 位置No. 130 のブックマーク | 作成日: 2024年1月2日火曜日 3:05:00\r\n\r\n\r\n==========\r\n"
+	got := parseMyClippings(text)
+	if len(got) != 1 {
+		t.Fatalf("parsed %d clippings, want 1 (bookmarks are skipped): %+v", len(got), got)
+	}
+	c := got[0]
+	if c.Title != "本の題名" || c.Author != "山田 太郎" || c.Text != "ハイライトの本文" {
+		t.Errorf("got %+v", c)
+	}
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"tundoku-killer/backend/internal/store"
+)
+
+// 友達どうしで、期間内の読了率と期限切れの数を競うリーダーボード。
+// 友達は UserSettings.LeaderboardVisible を有効にしている人だけが載る (自分は常に載る)
+
+const (
+	defaultLeaderboardDays = 30
+	maxLeaderboardDays     = 365
+)
+
+// LeaderboardEntry はリーダーボードの1行
+type LeaderboardEntry struct {
+	Rank           int     `json:"rank"`
+	UserID         string  `json:"userId"`
+	DisplayName    string  `json:"displayName"`
+	Completed      int     `json:"completed"`      // 期間内に読み終えた本
+	Due            int     `json:"due"`            // 期間内に期限が来た本と、期間内に読み終えた本の数
+	CompletionRate float64 `json:"completionRate"` // Completed / Due
+	Overdue        int     `json:"overdue"`        // 期間内に期限が来て、まだ読み終えていない本
+	IsMe           bool    `json:"isMe"`
+}
+
+// handleLeaderboard は ?userId= のユーザーと友達の、直近 ?days= 日間 (既定30日) のランキングを返す
+func (s *Server) handleLeaderboard(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	userID := r.URL.Query().Get("userId")
+	if userID == "" {
+		writeProblem(w, r, http.StatusBadRequest, "userId query parameter is required")
+		return
+	}
+	days := defaultLeaderboardDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxLeaderboardDays {
+			writeProblem(w, r, http.StatusBadRequest, "days must be between 1 and 365")
+			return
+		}
+		days = n
+	}
+
+	entries, err := s.buildLeaderboard(r.Context(), userID, days, time.Now())
+	if err != nil {
+		writeServerError(w, r, err, "Failed to build leaderboard")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"days":    days,
+		"entries": entries,
+	})
+}
+
+// buildLeaderboard は userID と、表示を許可している友達の成績を並べる
+func (s *Server) buildLeaderboard(ctx context.Context, userID string, days int, now time.Time) ([]LeaderboardEntry, error) {
+	friends, err := s.friendIDs(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	members := append([]string{userID}, friends...)
+	since := now.AddDate(0, 0, -days)
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		entries []LeaderboardEntry
+		errs    []error
+	)
+	for _, memberID := range members {
+		wg.Add(1)
+		go func(memberID string) {
+			defer wg.Done()
+
+			settings, err := s.getSettings(ctx, memberID)
+			if err == nil && memberID != userID && !settings.LeaderboardVisible {
+				return
+			}
+			var books []store.Book
+			if err == nil {
+				books, err = s.listBooks(ctx, memberID)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, err)
+				return
+			}
+			entry := scoreLeaderboard(books, since, now)
+			entry.UserID = memberID
+			entry.DisplayName = settings.Name()
+			entry.IsMe = memberID == userID
+			entries = append(entries, entry)
+		}(memberID)
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+
+	// 読了率が高い順、同率なら期限切れが少ない順、さらに読了数が多い順
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.CompletionRate != b.CompletionRate {
+			return a.CompletionRate > b.CompletionRate
+		}
+		if a.Overdue != b.Overdue {
+			return a.Overdue < b.Overdue
+		}
+		return a.Completed > b.Completed
+	})
+	for i := range entries {
+		entries[i].Rank = i + 1
+	}
+	return entries, nil
+}
+
+// scoreLeaderboard は [since, now) の読了数・期限切れの数・読了率を数える
+func scoreLeaderboard(books []store.Book, since, now time.Time) LeaderboardEntry {
+	var entry LeaderboardEntry
+	for _, book := range books {
+		completedInWindow := book.Status == "completed" && inRange(book.CompletedAt, since, now)
+		dueInWindow := !book.Deadline.Before(since) && book.Deadline.Before(now)
+
+		switch {
+		case completedInWindow:
+			entry.Completed++
+			entry.Due++
+		case dueInWindow && book.Status != "completed":
+			entry.Overdue++
+			entry.Due++
+		}
+	}
+	if entry.Due > 0 {
+		entry.CompletionRate = roundTo(float64(entry.Completed)/float64(entry.Due), 3)
+	}
+	return entry
+}
@@ -0,0 +1,263 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+
+	"tundoku-killer/backend/internal/cache"
+	"tundoku-killer/backend/internal/cron"
+	"tundoku-killer/backend/internal/store"
+)
+
+// 図書館から借りた本の返却期限。読む期限 (Book.Deadline) とは別に store.Book.Library に持ち、
+// 返却期限が近づくほどきつい文面で返却を促す (期限の7日前・3日前・前日・当日、過ぎたら毎日)。
+// 図書館システムは CALIL_APP_KEY があればカーリルで調べて名前を添える。カーリルの API は貸出期間を返さないので、
+// 返却期限を指定しなければ loanDays、それもなければ LIBRARY_LOAN_DAYS 日で計算する
+
+const libraryDueLease = "libraryDue"
+
+// libraryLookupTimeout はカーリルへの問い合わせを待つ時間。超えたら図書館システムの名前なしで設定する
+const libraryLookupTimeout = 5 * time.Second
+
+// libraryReminderDays は返却期限の何日前に返却を促すか。過ぎたら毎日促す
+var libraryReminderDays = map[int]bool{7: true, 3: true, 1: true, 0: true}
+
+// handleBookLibrary は {id} の本を図書館から借りたことにする (PUT)。DELETE (?userId=) で返却したことにする
+func (s *Server) handleBookLibrary(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPut:
+		s.handleBorrowFromLibrary(w, r)
+	case http.MethodDelete:
+		s.handleReturnToLibrary(w, r)
+	default:
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// handleBorrowFromLibrary は返却期限を設定し、本の持ち方を借りているにする
+func (s *Server) handleBorrowFromLibrary(w http.ResponseWriter, r *http.Request) {
+	var req libraryLoanRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid JSON body: %v", err))
+		return
+	}
+	now := time.Now()
+	if err := req.Validate(now); err != nil {
+		writeValidationError(w, r, err)
+		return
+	}
+	ctx := r.Context()
+	book, err := s.ownedBook(ctx, r.PathValue("id"), req.UserID)
+	if err != nil {
+		writeBookError(w, r, err, "Failed to retrieve book")
+		return
+	}
+	if book.OnWishlist() {
+		writeProblem(w, r, http.StatusConflict, "Book is on the wishlist; move it off the wishlist via /v1/books/{id}/ownership first")
+		return
+	}
+
+	loan := &store.LibraryLoan{SystemID: req.SystemID, BorrowedAt: req.BorrowedAt, DueDate: req.DueDate}
+	if loan.BorrowedAt.IsZero() {
+		loan.BorrowedAt = now
+	}
+	if loan.DueDate.IsZero() {
+		days := req.LoanDays
+		if days == 0 {
+			days = s.cfg.LibraryLoanDays
+		}
+		loan.DueDate = loan.BorrowedAt.In(cron.Location).AddDate(0, 0, days)
+	}
+	if loan.SystemID != "" {
+		name, err := s.lookupLibrarySystem(ctx, loan.SystemID)
+		if err != nil {
+			s.logger.Printf("Error looking up library system %s: %v", loan.SystemID, err)
+		}
+		loan.SystemName = name
+	}
+
+	updated := book
+	updated.Ownership = store.OwnershipBorrowed
+	updated.Library = loan
+	if err := s.bookRepo.Update(ctx, updated); err != nil {
+		writeBookError(w, r, err, "Failed to update book")
+		return
+	}
+	s.logger.Printf("Book %s borrowed from library %q until %v", book.BookID, loan.SystemID, loan.DueDate)
+	eventBus.Publish(ctx, BookUpdated{Book: updated, Previous: book})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
+
+// handleReturnToLibrary は返却期限を外す。持ち方は借りているのまま
+func (s *Server) handleReturnToLibrary(w http.ResponseWriter, r *http.Request) {
+	userID := r.URL.Query().Get("userId")
+	if userID == "" {
+		writeProblem(w, r, http.StatusBadRequest, "userId query parameter is required")
+		return
+	}
+	ctx := r.Context()
+	book, err := s.ownedBook(ctx, r.PathValue("id"), userID)
+	if err != nil {
+		writeBookError(w, r, err, "Failed to retrieve book")
+		return
+	}
+	if book.Library == nil {
+		writeProblem(w, r, http.StatusNotFound, "Book is not borrowed from a library")
+		return
+	}
+
+	updated := book
+	updated.Library = nil
+	if err := s.bookRepo.Update(ctx, updated); err != nil {
+		writeBookError(w, r, err, "Failed to update book")
+		return
+	}
+	eventBus.Publish(ctx, BookUpdated{Book: updated, Previous: book})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// lookupLibrarySystem はカーリルの図書館 API で systemID の図書館システムの名前を調べる。
+// CALIL_APP_KEY がないか、見つからなければ空
+func (s *Server) lookupLibrarySystem(ctx context.Context, systemID string) (string, error) {
+	appKey := s.cfg.CalilAppKey
+	if appKey == "" {
+		return "", nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, libraryLookupTimeout)
+	defer cancel()
+
+	// 図書館システムはめったに変わらないので、CATALOG_CACHE_TTL の間は調べ直さない
+	var cached string
+	if cache.GetJSON(ctx, s.cache, "library:"+systemID, &cached) {
+		return cached, nil
+	}
+	var libraries []struct {
+		SystemName string `json:"systemname"`
+	}
+	q := url.Values{"appkey": {appKey}, "systemid": {systemID}, "format": {"json"}, "callback": {"no"}}
+	if err := getJSON(ctx, "https://api.calil.jp/library?"+q.Encode(), &libraries); err != nil {
+		return "", err
+	}
+	if len(libraries) == 0 {
+		return "", nil
+	}
+	name := libraries[0].SystemName
+	if err := cache.SetJSON(ctx, s.cache, "library:"+systemID, name, s.cfg.Cache.CatalogTTL); err != nil {
+		s.logger.Printf("Error caching library system %s: %v", systemID, err)
+	}
+	return name, nil
+}
+
+// libraryDaysLeft は now から見た返却期限までの日数 (JSTの日付で数える)。過ぎていれば負
+func libraryDaysLeft(loan store.LibraryLoan, now time.Time) int {
+	due, _ := time.Parse("2006-01-02", cron.Cycle(loan.DueDate))
+	today, _ := time.Parse("2006-01-02", cron.Cycle(now))
+	return int(due.Sub(today).Hours() / 24)
+}
+
+// libraryReminder は返却期限までの日数に応じた文面を返す。近づくほどきつくなる
+func libraryReminder(book store.Book, daysLeft int) string {
+	library := "図書館"
+	if book.Library.SystemName != "" {
+		library = book.Library.SystemName
+	}
+	due := book.Library.DueDate.In(cron.Location).Format("1月2日")
+	switch {
+	case daysLeft < 0:
+		return fmt.Sprintf("『%s』の%sへの返却期限 (%s) を%d日過ぎています。次に予約している人が待っています。読み終えていなくても、今日返してください。",
+			book.Title, library, due, -daysLeft)
+	case daysLeft == 0:
+		return fmt.Sprintf("『%s』の%sへの返却期限は今日です。読み終えたかどうかは関係ありません。返しに行きましょう。", book.Title, library)
+	case daysLeft == 1:
+		return fmt.Sprintf("『%s』の%sへの返却期限は明日 (%s) です。借りた本まで積読にするつもりですか。", book.Title, library, due)
+	case daysLeft <= 3:
+		return fmt.Sprintf("『%s』の%sへの返却期限 (%s) まであと%d日です。今夜読まなければ間に合いません。", book.Title, library, due, daysLeft)
+	default:
+		return fmt.Sprintf("『%s』の%sへの返却期限 (%s) まであと%d日です。そろそろ読み始めてはいかがですか。", book.Title, library, due, daysLeft)
+	}
+}
+
+// handleLibraryDueCron は図書館から借りた本の返却を促す (毎日)。同じ日に2回実行しても二重には送らない
+func (s *Server) handleLibraryDueCron(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodGet {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	ctx := context.WithoutCancel(r.Context())
+
+	if !s.authorizeCron(r) {
+		writeProblem(w, r, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	runID := uuid.NewString()
+	if err := s.cron.AcquireLease(ctx, libraryDueLease, runID, cron.LeaseTTL); err != nil {
+		if errors.Is(err, cron.ErrLeaseHeld) {
+			writeProblem(w, r, http.StatusConflict, "Another library reminder run is already running")
+			return
+		}
+		writeServerError(w, r, err, "Failed to acquire lock")
+		return
+	}
+	defer s.cron.ReleaseLease(ctx, libraryDueLease, runID)
+
+	userIDs, err := s.listUserIDs(ctx)
+	if err != nil {
+		writeServerError(w, r, err, "Failed to list users")
+		return
+	}
+
+	now := time.Now()
+	cycle := cron.Cycle(now)
+	deadline := now.Add(cron.TimeBudget)
+	users, sent, failed := 0, 0, 0
+	for _, userID := range userIDs {
+		if time.Now().After(deadline) {
+			break
+		}
+		users++
+		books, err := s.listBooks(ctx, userID)
+		if err != nil {
+			s.logger.Printf("Error fetching books for %s: %v", userID, err)
+			failed++
+			continue
+		}
+		for _, book := range books {
+			if book.Library == nil || book.Library.LastReminderCycle == cycle {
+				continue
+			}
+			daysLeft := libraryDaysLeft(*book.Library, now)
+			if daysLeft >= 0 && !libraryReminderDays[daysLeft] {
+				continue
+			}
+			if err := s.sendLineMessage(ctx, userID, libraryReminder(book, daysLeft)); err != nil {
+				s.logger.Printf("Error sending library reminder for %s: %v", book.BookID, err)
+				failed++
+				continue
+			}
+			if err := s.bookRepo.Patch(ctx, book.BookID, store.BookPatch{LibraryReminderCycle: &cycle}); err != nil {
+				s.logger.Printf("Error recording library reminder for %s: %v", book.BookID, err)
+			}
+			sent++
+		}
+	}
+
+	s.logger.Printf("Library reminders %s: %d sent, %d failed for %d/%d users", cycle, sent, failed, users, len(userIDs))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"users":  len(userIDs),
+		"sent":   sent,
+		"failed": failed,
+		"done":   users == len(userIDs),
+	})
+}
@@ -0,0 +1,345 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"tundoku-killer/backend/internal/cron"
+	"tundoku-killer/backend/internal/store"
+)
+
+// ウィッシュリストの本が近所の図書館にあれば、買う前に借りるよう勧める。近所の図書館は設定の librarySystems
+// (カーリルの図書館システムID) で、蔵書はカーリルの蔵書検索 API で調べる (CALIL_APP_KEY が要る)。
+// ウィッシュリストに登録したときの応答に添え、毎週 /v1/cron/library-nudges が LINE でまとめて勧める。
+// 同じ本を勧めるのは libraryNudgeInterval に1度まで (Book.LibraryNudgedAt)
+
+const (
+	libraryNudgesLease = "libraryNudges"
+	// libraryNudgeMessage は蔵書のある本に添える一文
+	libraryNudgeMessage = "近所の図書館にありますよ、買う前に借りたら？"
+	// libraryNudgeInterval は同じ本を図書館で借りるよう勧め直すまでの間隔
+	libraryNudgeInterval = 30 * 24 * time.Hour
+	// calilPollInterval はカーリルが調べ終わるまで問い合わせ直す間隔。カーリルは2秒以上空けるよう求めている
+	calilPollInterval = 2 * time.Second
+	// calilCheckTimeout は cron で1人分の本をカーリルで調べるのを待つ時間
+	calilCheckTimeout = 10 * time.Second
+	// maxCalilISBNs はカーリルに一度に問い合わせる ISBN の数
+	maxCalilISBNs = 50
+)
+
+// 貸出状況のうち、いま借りられるもの。ほかに "貸出中" "予約中" "館内のみ" などがある
+var calilAvailableStatuses = map[string]bool{"貸出可": true, "蔵書あり": true}
+
+// LibraryHolding は1つの図書館システムの蔵書
+type LibraryHolding struct {
+	SystemID   string            `json:"systemId"`
+	SystemName string            `json:"systemName,omitempty"`
+	Libraries  map[string]string `json:"libraries"` // 館ごとの貸出状況 ("貸出可" "貸出中" など)
+	ReserveURL string            `json:"reserveUrl,omitempty"`
+}
+
+// LibraryAvailability は本の近所の図書館での蔵書
+type LibraryAvailability struct {
+	ISBN      string           `json:"isbn"`
+	Holdings  []LibraryHolding `json:"holdings"`
+	Available bool             `json:"available"`         // どこかの館でいま借りられる
+	Complete  bool             `json:"complete"`          // false なら、調べ終わらない図書館があった
+	Message   string           `json:"message,omitempty"` // 蔵書があれば、買う前に借りるよう勧める一文
+}
+
+// newLibraryAvailability は holdings から code の本の蔵書をまとめる
+func newLibraryAvailability(code string, holdings []LibraryHolding, complete bool) LibraryAvailability {
+	availability := LibraryAvailability{ISBN: code, Holdings: holdings, Complete: complete}
+	if availability.Holdings == nil {
+		availability.Holdings = []LibraryHolding{}
+	}
+	for _, holding := range holdings {
+		for _, status := range holding.Libraries {
+			availability.Available = availability.Available || calilAvailableStatuses[status]
+		}
+	}
+	if len(holdings) > 0 {
+		availability.Message = libraryNudgeMessage
+	}
+	return availability
+}
+
+// checkLibraries は isbns の本が systemIDs の図書館にあるかをカーリルで調べ、蔵書のある本の ISBN ごとに蔵書を返す。
+// カーリルは図書館ごとに順に調べるので、調べ終わるまで問い合わせ直す。ctx が切れたら、そこまでに分かった蔵書と ctx のエラーを返す
+func (s *Server) checkLibraries(ctx context.Context, systemIDs, isbns []string) (map[string][]LibraryHolding, error) {
+	var result struct {
+		Session  string `json:"session"`
+		Continue int    `json:"continue"`
+		Books    map[string]map[string]struct {
+			ReserveURL string            `json:"reserveurl"`
+			LibKey     map[string]string `json:"libkey"`
+		} `json:"books"`
+	}
+	holdings := func() map[string][]LibraryHolding {
+		found := make(map[string][]LibraryHolding)
+		for code, systems := range result.Books {
+			for systemID, system := range systems {
+				libraries := make(map[string]string, len(system.LibKey))
+				for library, status := range system.LibKey {
+					if status != "蔵書なし" {
+						libraries[library] = status
+					}
+				}
+				if len(libraries) > 0 {
+					found[code] = append(found[code], LibraryHolding{SystemID: systemID, Libraries: libraries, ReserveURL: system.ReserveURL})
+				}
+			}
+			sort.Slice(found[code], func(i, j int) bool { return found[code][i].SystemID < found[code][j].SystemID })
+		}
+		return found
+	}
+
+	q := url.Values{
+		"appkey":   {s.cfg.CalilAppKey},
+		"isbn":     {strings.Join(isbns, ",")},
+		"systemid": {strings.Join(systemIDs, ",")},
+		"format":   {"json"},
+		"callback": {"no"},
+	}
+	for {
+		if err := getJSON(ctx, "https://api.calil.jp/check?"+q.Encode(), &result); err != nil {
+			return holdings(), err
+		}
+		if result.Continue == 0 {
+			return holdings(), nil
+		}
+		q = url.Values{"appkey": {s.cfg.CalilAppKey}, "session": {result.Session}, "format": {"json"}, "callback": {"no"}}
+		select {
+		case <-ctx.Done():
+			return holdings(), ctx.Err()
+		case <-time.After(calilPollInterval):
+		}
+	}
+}
+
+// bookLibraryAvailability は book が userID の近所の図書館にあるかを調べる。
+// CALIL_APP_KEY がないか、本に ISBN がないか、近所の図書館を設定していなければ nil
+func (s *Server) bookLibraryAvailability(ctx context.Context, book store.Book) (*LibraryAvailability, error) {
+	if s.cfg.CalilAppKey == "" || book.ISBN == "" {
+		return nil, nil
+	}
+	settings, err := s.getSettings(ctx, book.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if len(settings.LibrarySystems) == 0 {
+		return nil, nil
+	}
+
+	checkCtx, cancel := context.WithTimeout(ctx, libraryLookupTimeout)
+	defer cancel()
+	holdings, err := s.checkLibraries(checkCtx, settings.LibrarySystems, []string{book.ISBN})
+	if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		return nil, err
+	}
+	found := holdings[book.ISBN]
+	for i := range found {
+		name, err := s.lookupLibrarySystem(ctx, found[i].SystemID)
+		if err != nil {
+			s.logger.Printf("Error looking up library system %s: %v", found[i].SystemID, err)
+		}
+		found[i].SystemName = name
+	}
+	availability := newLibraryAvailability(book.ISBN, found, err == nil)
+	return &availability, nil
+}
+
+// libraryNudge はウィッシュリストに登録した book が近所の図書館にあれば、その蔵書を返す。なければ nil。
+// 勧めたことを記録し、/v1/cron/library-nudges ですぐに同じ本を勧め直さないようにする
+func (s *Server) libraryNudge(ctx context.Context, book store.Book) *LibraryAvailability {
+	if !book.OnWishlist() {
+		return nil
+	}
+	availability, err := s.bookLibraryAvailability(ctx, book)
+	if err != nil {
+		s.logger.Printf("Error checking libraries for book %s: %v", book.BookID, err)
+		return nil
+	}
+	if availability == nil || len(availability.Holdings) == 0 {
+		return nil
+	}
+	now := time.Now()
+	if err := s.bookRepo.Patch(ctx, book.BookID, store.BookPatch{LibraryNudgedAt: &now}); err != nil {
+		s.logger.Printf("Error recording library nudge for %s: %v", book.BookID, err)
+	}
+	return availability
+}
+
+// handleBookLibraryAvailability は {id} の本が近所の図書館にあるかを返す (GET ?userId=)
+func (s *Server) handleBookLibraryAvailability(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	userID := r.URL.Query().Get("userId")
+	if userID == "" {
+		writeProblem(w, r, http.StatusBadRequest, "userId query parameter is required")
+		return
+	}
+	if s.cfg.CalilAppKey == "" {
+		writeProblem(w, r, http.StatusNotImplemented, "Library lookup is not configured")
+		return
+	}
+	ctx := r.Context()
+	book, err := s.ownedBook(ctx, r.PathValue("id"), userID)
+	if err != nil {
+		writeBookError(w, r, err, "Failed to retrieve book")
+		return
+	}
+	if book.ISBN == "" {
+		writeProblem(w, r, http.StatusConflict, "Book has no ISBN")
+		return
+	}
+	availability, err := s.bookLibraryAvailability(ctx, book)
+	if err != nil {
+		writeServerError(w, r, err, "Failed to check libraries")
+		return
+	}
+	if availability == nil {
+		writeProblem(w, r, http.StatusConflict, "No nearby libraries; set librarySystems via /v1/settings first")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(availability)
+}
+
+// libraryNudgeText はまとめて勧める LINE の文面を返す。書名は3冊まで並べる
+func libraryNudgeText(books []store.Book) string {
+	var titles strings.Builder
+	for i, book := range books {
+		if i == 3 {
+			fmt.Fprintf(&titles, "ほか%d冊", len(books)-i)
+			break
+		}
+		fmt.Fprintf(&titles, "『%s』", book.Title)
+	}
+	return fmt.Sprintf("ウィッシュリストの%s、%s", titles.String(), libraryNudgeMessage)
+}
+
+// handleLibraryNudgesCron はウィッシュリストの本のうち近所の図書館にあるものを、ユーザーごとにまとめて勧める (毎週)
+func (s *Server) handleLibraryNudgesCron(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodGet {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	ctx := context.WithoutCancel(r.Context())
+
+	if !s.authorizeCron(r) {
+		writeProblem(w, r, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	if s.cfg.CalilAppKey == "" {
+		writeProblem(w, r, http.StatusNotImplemented, "Library lookup is not configured")
+		return
+	}
+
+	runID := uuid.NewString()
+	if err := s.cron.AcquireLease(ctx, libraryNudgesLease, runID, cron.LeaseTTL); err != nil {
+		if errors.Is(err, cron.ErrLeaseHeld) {
+			writeProblem(w, r, http.StatusConflict, "Another library nudge run is already running")
+			return
+		}
+		writeServerError(w, r, err, "Failed to acquire lock")
+		return
+	}
+	defer s.cron.ReleaseLease(ctx, libraryNudgesLease, runID)
+
+	userIDs, err := s.listUserIDs(ctx)
+	if err != nil {
+		writeServerError(w, r, err, "Failed to list users")
+		return
+	}
+
+	now := time.Now()
+	deadline := now.Add(cron.TimeBudget)
+	users, sent, failed := 0, 0, 0
+	for _, userID := range userIDs {
+		if time.Now().After(deadline) {
+			break
+		}
+		users++
+		settings, err := s.getSettings(ctx, userID)
+		if err != nil {
+			s.logger.Printf("Error fetching settings for %s: %v", userID, err)
+			failed++
+			continue
+		}
+		if len(settings.LibrarySystems) == 0 {
+			continue
+		}
+		books, err := s.listBooks(ctx, userID)
+		if err != nil {
+			s.logger.Printf("Error fetching books for %s: %v", userID, err)
+			failed++
+			continue
+		}
+		byISBN := make(map[string]store.Book)
+		var isbns []string
+		for _, book := range books {
+			if !book.OnWishlist() || book.ISBN == "" || (book.LibraryNudgedAt != nil && now.Sub(*book.LibraryNudgedAt) < libraryNudgeInterval) {
+				continue
+			}
+			if _, ok := byISBN[book.ISBN]; !ok && len(isbns) < maxCalilISBNs {
+				byISBN[book.ISBN] = book
+				isbns = append(isbns, book.ISBN)
+			}
+		}
+		if len(isbns) == 0 {
+			continue
+		}
+
+		// 調べ終わらなかった図書館の本は、来週また調べる
+		checkCtx, cancel := context.WithTimeout(ctx, calilCheckTimeout)
+		holdings, err := s.checkLibraries(checkCtx, settings.LibrarySystems, isbns)
+		cancel()
+		if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+			s.logger.Printf("Error checking libraries for %s: %v", userID, err)
+			failed++
+			continue
+		}
+		var nudged []store.Book
+		for _, code := range isbns {
+			if len(holdings[code]) > 0 {
+				nudged = append(nudged, byISBN[code])
+			}
+		}
+		if len(nudged) == 0 {
+			continue
+		}
+		if err := s.sendLineMessage(ctx, userID, libraryNudgeText(nudged)); err != nil {
+			s.logger.Printf("Error sending library nudge to %s: %v", userID, err)
+			failed++
+			continue
+		}
+		for _, book := range nudged {
+			if err := s.bookRepo.Patch(ctx, book.BookID, store.BookPatch{LibraryNudgedAt: &now}); err != nil {
+				s.logger.Printf("Error recording library nudge for %s: %v", book.BookID, err)
+			}
+		}
+		sent++
+	}
+
+	s.logger.Printf("Library nudges: %d sent, %d failed for %d/%d users", sent, failed, users, len(userIDs))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"users":  len(userIDs),
+		"sent":   sent,
+		"failed": failed,
+		"done":   users == len(userIDs),
+	})
+}
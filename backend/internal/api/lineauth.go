@@ -0,0 +1,67 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+type LineAuthRequest struct {
+	LineAccessToken string `json:"lineAccessToken"`
+	LineUserID      string `json:"lineUserID"` // LINE User IDも受け取る
+}
+
+// handleLineAuth はLINEアクセストークンを受け取り、Firebase Custom Tokenを発行する
+func (s *Server) handleLineAuth(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	// Authクライアントの取得
+	client, err := s.firebaseApp.Auth(ctx)
+	if err != nil {
+		writeServerError(w, r, err, "Failed to initialize authentication")
+		return
+	}
+
+	// リクエストボディのパース
+	var req LineAuthRequest
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("Failed to read request body: %v", err))
+		return
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid JSON body: %v", err))
+		return
+	}
+
+	if err := req.Validate(); err != nil {
+		writeValidationError(w, r, err)
+		return
+	}
+
+	// ここでLINEアクセストークンの検証を行う (今回はモック)
+
+	// Google などのアカウントに LINE をつないでいれば、そのアカウントとしてログインさせる
+	uid, err := s.linkedUserID(ctx, req.LineUserID)
+	if err != nil {
+		writeServerError(w, r, err, "Failed to resolve linked account")
+		return
+	}
+
+	// 初めてのログインなら LINE の表示名とアイコンでプロフィールを作る (失敗してもログインは続ける)
+	s.ensureLineProfile(ctx, uid, req.LineAccessToken)
+
+	// Firebase Custom Token の生成
+	// FirebaseのUIDにはLINE User IDを使用する (LINE をつないだアカウントならそのアカウントの UID)
+	customToken, err := client.CustomToken(ctx, uid)
+	if err != nil {
+		writeServerError(w, r, err, "Failed to create custom token")
+		return
+	}
+
+	// カスタムトークンをJSON形式で返す
+	s.logger.Printf("Generated custom token: %s", customToken)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"customToken": customToken})
+}
@@ -0,0 +1,177 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"tundoku-killer/backend/internal/isbn"
+	"tundoku-killer/backend/internal/store"
+)
+
+// 諦めた本 (status "abandoned") をメルカリに出品するための説明文。読まないなら手放せ、という煽りの行き着く先。
+// 状態は出品画面の選択肢に合わせ、確かめてほしい点 (書き込み・帯など) は【要確認】として説明文に残す。
+// 価格は RAKUTEN_APPLICATION_ID があれば楽天ブックスの今の価格、なければ登録時の価格から、状態に応じて付ける
+
+// メルカリの出品の決まり
+const (
+	listingMinPrice       = 300 // 出品できる最低価格 (円)
+	listingFeePercent     = 10  // 販売手数料 (%)
+	maxListingTitleLength = 40  // 商品名の文字数の上限
+	maxListingNotesLength = 500 // 説明文に添える補足の文字数の上限
+)
+
+// listingCondition は出品画面の「商品の状態」と、定価に掛ける割合 (%)
+type listingCondition struct {
+	Label   string
+	Percent int
+}
+
+// listingConditions は出品の状態。キーはリクエストの condition
+var listingConditions = map[string]listingCondition{
+	"new":      {"新品、未使用", 80},
+	"like-new": {"未使用に近い", 70},
+	"good":     {"目立った傷や汚れなし", 60},
+	"fair":     {"やや傷や汚れあり", 45},
+	"worn":     {"傷や汚れあり", 30},
+	"poor":     {"全体的に状態が悪い", 20},
+}
+
+// defaultListingCondition は condition を省略したときの状態。積んでいただけの本はたいてい開いてもいない
+const defaultListingCondition = "like-new"
+
+// listingChecklist は出品する前に本を手に取って確かめてほしい点
+var listingChecklist = []string{
+	"書き込み・線引き: なし / あり",
+	"帯: あり / なし",
+	"日焼け・シミ: なし / あり",
+	"ペット・喫煙: なし / あり",
+}
+
+// Listing は出品の説明文と付け値
+type Listing struct {
+	Title          string   `json:"title"`
+	Description    string   `json:"description"`
+	Condition      string   `json:"condition"` // 出品画面で選ぶ「商品の状態」
+	ListPrice      int      `json:"listPrice,omitempty"`
+	SuggestedPrice int      `json:"suggestedPrice,omitempty"` // 価格が分からなければ 0
+	Proceeds       int      `json:"proceeds,omitempty"`       // 販売手数料を引いた手取り
+	Checklist      []string `json:"checklist"`
+	Sent           bool     `json:"sent"` // LINE (なければメール) で送れたか
+}
+
+// handleBookListing は {id} の諦めた本の出品の説明文を作り、LINE で送る (POST)
+func (s *Server) handleBookListing(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req listingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid JSON body: %v", err))
+		return
+	}
+	if err := req.Validate(); err != nil {
+		writeValidationError(w, r, err)
+		return
+	}
+	ctx := r.Context()
+	book, err := s.ownedBook(ctx, r.PathValue("id"), req.UserID)
+	if err != nil {
+		writeBookError(w, r, err, "Failed to retrieve book")
+		return
+	}
+	if !book.Abandoned() {
+		writeProblem(w, r, http.StatusConflict, "Only abandoned books can be listed; set the status to \"abandoned\" first")
+		return
+	}
+	if book.Ownership == store.OwnershipBorrowed {
+		writeProblem(w, r, http.StatusConflict, "Borrowed books are not yours to sell")
+		return
+	}
+
+	condition := req.Condition
+	if condition == "" {
+		condition = defaultListingCondition
+	}
+	listing := buildListing(book, listingConditions[condition], s.listPrice(ctx, book), req.Notes)
+
+	message := "読まないなら、せめて読む人に譲りましょう。以下をそのままメルカリに貼り付けてください (【要確認】は本を見て直すこと)。\n\n" +
+		listing.Title + "\n\n" + listing.Description
+	if listing.SuggestedPrice > 0 {
+		message += fmt.Sprintf("\n\n価格の目安: %s (手取り %s)", formatYen(listing.SuggestedPrice), formatYen(listing.Proceeds))
+	}
+	if err := s.sendLineMessage(ctx, book.UserID, message); err != nil {
+		s.logger.Printf("Error sending listing for book %s: %v", book.BookID, err)
+	} else {
+		listing.Sent = true
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(listing)
+}
+
+// listPrice は book の今の価格 (円) を返す。楽天ブックスで調べられなければ登録時の価格。どちらもなければ 0
+func (s *Server) listPrice(ctx context.Context, book store.Book) int {
+	if appID := s.cfg.RakutenApplicationID; appID != "" && isbn.Valid(book.ISBN) {
+		ctx, cancel := context.WithTimeout(ctx, priceLookupTimeout)
+		defer cancel()
+		price, err := lookupRakutenPrice(ctx, appID, isbn.Normalize(book.ISBN))
+		if err != nil {
+			s.logger.Printf("Error looking up Rakuten price for ISBN %s: %v", book.ISBN, err)
+		}
+		if price > 0 {
+			return price
+		}
+	}
+	return book.Price
+}
+
+// buildListing は出品の説明文を作る。listPrice が 0 なら付け値は付けない
+func buildListing(book store.Book, condition listingCondition, listPrice int, notes string) Listing {
+	title := book.Title
+	if book.Author != "" {
+		title += " " + book.Author
+	}
+	if runes := []rune(title); len(runes) > maxListingTitleLength {
+		title = string(runes[:maxListingTitleLength])
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "『%s』", book.Title)
+	if book.Author != "" {
+		fmt.Fprintf(&b, "(%s)", book.Author)
+	}
+	b.WriteString("です。\n")
+	if book.ISBN != "" {
+		fmt.Fprintf(&b, "ISBN: %s\n", isbn.Normalize(book.ISBN))
+	}
+	if book.Pages > 0 {
+		fmt.Fprintf(&b, "%dページ\n", book.Pages)
+	}
+	fmt.Fprintf(&b, "\n商品の状態: %s\n", condition.Label)
+	for _, item := range listingChecklist {
+		fmt.Fprintf(&b, "【要確認】%s\n", item)
+	}
+	if notes != "" {
+		fmt.Fprintf(&b, "\n%s\n", notes)
+	}
+	b.WriteString("\n購入したものの読む時間が取れず、本棚に置いていました。素人保管のため、神経質な方はご遠慮ください。")
+
+	listing := Listing{
+		Title:       title,
+		Description: b.String(),
+		Condition:   condition.Label,
+		ListPrice:   listPrice,
+		Checklist:   listingChecklist,
+	}
+	if listPrice > 0 {
+		// 10円単位に丸め、出品できる最低価格を下回らないようにする
+		price := max(listPrice*condition.Percent/100/10*10, listingMinPrice)
+		listing.SuggestedPrice = price
+		listing.Proceeds = price - price*listingFeePercent/100
+	}
+	return listing
+}
@@ -0,0 +1,274 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"tundoku-killer/backend/internal/cron"
+	"tundoku-killer/backend/internal/insult"
+)
+
+// 生成AIを呼んだ量 (トークン数と料金の見積もり) の記録。1回ごとに llmUsage に、月 (JST) ごとの合計を
+// llmUsageMonthly/{YYYY-MM} に書き、合計が LLM_MONTHLY_BUDGET を超えたら月末まで用意された煽り文に切り替える
+
+// monthlyUsageTTL は月の合計をプロセス内に置く時間。その間の自分の呼び出しの分は足していく
+const monthlyUsageTTL = time.Minute
+
+// LLMUsage は llmUsage に保存する1回分の記録
+type LLMUsage struct {
+	Provider     string    `json:"provider" firestore:"provider"`
+	Model        string    `json:"model" firestore:"model"`
+	InputTokens  int       `json:"inputTokens" firestore:"inputTokens"`
+	OutputTokens int       `json:"outputTokens" firestore:"outputTokens"`
+	Cost         float64   `json:"cost" firestore:"cost"` // USD
+	UserID       string    `json:"userId" firestore:"userId"`
+	BookID       string    `json:"bookId" firestore:"bookId"`
+	RunID        string    `json:"runId,omitempty" firestore:"runId,omitempty"` // 期限チェックの cron の実行ID
+	At           time.Time `json:"at" firestore:"at"`
+}
+
+// LLMUsageTotals は使った量の合計。llmUsageMonthly/{YYYY-MM} にも保存する
+type LLMUsageTotals struct {
+	Calls        int     `json:"calls" firestore:"calls"`
+	InputTokens  int     `json:"inputTokens" firestore:"inputTokens"`
+	OutputTokens int     `json:"outputTokens" firestore:"outputTokens"`
+	Cost         float64 `json:"cost" firestore:"cost"`
+}
+
+func (t *LLMUsageTotals) add(u LLMUsage) {
+	t.Calls++
+	t.InputTokens += u.InputTokens
+	t.OutputTokens += u.OutputTokens
+	t.Cost += u.Cost
+}
+
+// cronRunKey は期限チェックの cron の実行IDを context に載せるキー
+type cronRunKey struct{}
+
+// withCronRun は生成AIの記録を実行ごとに集計できるよう、ctx に runID を載せる
+func withCronRun(ctx context.Context, runID string) context.Context {
+	if runID == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, cronRunKey{}, runID)
+}
+
+func cronRunFrom(ctx context.Context) string {
+	runID, _ := ctx.Value(cronRunKey{}).(string)
+	return runID
+}
+
+// usageMonth は t の月 (JST) の "2006-01"
+func usageMonth(t time.Time) string {
+	return t.In(cron.Location).Format("2006-01")
+}
+
+// llmUsageMeter は insult.Meter。budget が 0 なら記録だけする
+type llmUsageMeter struct {
+	client *firestore.Client
+	budget float64
+	logger *log.Logger
+
+	mu       sync.Mutex
+	month    string
+	cost     float64 // month の料金の合計 (読んだ値 + その後の自分の分)
+	expires  time.Time
+	exceeded bool // month の予算超過をログに出したか
+}
+
+func (m *llmUsageMeter) Allow(ctx context.Context) error {
+	if m.budget <= 0 {
+		return nil
+	}
+	cost, err := m.monthCost(ctx, time.Now())
+	if err != nil {
+		// 合計が読めないだけで止めると、Firestore の不調で生成AIまで止まってしまうので通す
+		m.logger.Printf("Error reading LLM usage; allowing the call: %v", err)
+		return nil
+	}
+	if cost < m.budget {
+		return nil
+	}
+	m.mu.Lock()
+	first := !m.exceeded
+	m.exceeded = true
+	m.mu.Unlock()
+	if first {
+		m.logger.Printf("LLM budget of $%.2f for %s exceeded ($%.2f); sending canned insults until next month", m.budget, usageMonth(time.Now()), cost)
+	}
+	return insult.ErrBudgetExceeded
+}
+
+// monthCost は now の月の料金の合計を返す。monthlyUsageTTL が切れていれば読み直す
+func (m *llmUsageMeter) monthCost(ctx context.Context, now time.Time) (float64, error) {
+	month := usageMonth(now)
+	m.mu.Lock()
+	if m.month == month && now.Before(m.expires) {
+		cost := m.cost
+		m.mu.Unlock()
+		return cost, nil
+	}
+	m.mu.Unlock()
+
+	var totals LLMUsageTotals
+	doc, err := m.client.Collection("llmUsageMonthly").Doc(month).Get(ctx)
+	switch {
+	case status.Code(err) == codes.NotFound:
+	case err != nil:
+		return 0, err
+	default:
+		if err := doc.DataTo(&totals); err != nil {
+			return 0, err
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.month != month {
+		m.exceeded = false
+	}
+	m.month, m.cost, m.expires = month, totals.Cost, now.Add(monthlyUsageTTL)
+	return totals.Cost, nil
+}
+
+func (m *llmUsageMeter) Record(ctx context.Context, u insult.Usage) {
+	now := time.Now()
+	usage := LLMUsage{
+		Provider:     u.Provider,
+		Model:        u.Model,
+		InputTokens:  u.InputTokens,
+		OutputTokens: u.OutputTokens,
+		Cost:         u.Cost,
+		UserID:       u.UserID,
+		BookID:       u.BookID,
+		RunID:        cronRunFrom(ctx),
+		At:           now,
+	}
+	month := usageMonth(now)
+
+	m.mu.Lock()
+	if m.month == month {
+		m.cost += u.Cost
+	}
+	m.mu.Unlock()
+
+	if _, _, err := m.client.Collection("llmUsage").Add(ctx, usage); err != nil {
+		m.logger.Printf("Error recording LLM usage for book %s: %v", u.BookID, err)
+	}
+	_, err := m.client.Collection("llmUsageMonthly").Doc(month).Set(ctx, map[string]interface{}{
+		"calls":        firestore.Increment(1),
+		"inputTokens":  firestore.Increment(u.InputTokens),
+		"outputTokens": firestore.Increment(u.OutputTokens),
+		"cost":         firestore.Increment(u.Cost),
+	}, firestore.MergeAll)
+	if err != nil {
+		m.logger.Printf("Error updating LLM usage totals for %s: %v", month, err)
+	}
+}
+
+// LLMUsageGroup は利用者や実行ごとの使った量
+type LLMUsageGroup struct {
+	ID string `json:"id"`
+	LLMUsageTotals
+}
+
+// maxUsageGroups は利用者・実行ごとの集計を返す上限 (料金の多い順)
+const maxUsageGroups = 50
+
+// handleLLMUsage は ?month=YYYY-MM (省略時は今月) の生成AIの使った量を、合計・プロバイダー・利用者・実行ごとに返す
+func (s *Server) handleLLMUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	now := time.Now()
+	month := r.URL.Query().Get("month")
+	if month == "" {
+		month = usageMonth(now)
+	}
+	month, start, end, err := reportMonth(month, now)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var total LLMUsageTotals
+	providers := map[string]*LLMUsageTotals{}
+	users := map[string]*LLMUsageTotals{}
+	runs := map[string]*LLMUsageTotals{}
+	iter := s.firestoreClient.Collection("llmUsage").
+		Where("at", ">=", start).Where("at", "<", end).
+		Documents(r.Context())
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			writeServerError(w, r, err, "Failed to read LLM usage")
+			return
+		}
+		var u LLMUsage
+		if err := doc.DataTo(&u); err != nil {
+			s.logger.Printf("Error parsing LLM usage %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		total.add(u)
+		for _, g := range []struct {
+			groups map[string]*LLMUsageTotals
+			key    string
+		}{{providers, u.Provider}, {users, u.UserID}, {runs, u.RunID}} {
+			if g.key == "" {
+				continue
+			}
+			if g.groups[g.key] == nil {
+				g.groups[g.key] = &LLMUsageTotals{}
+			}
+			g.groups[g.key].add(u)
+		}
+	}
+
+	var budget interface{}
+	if b := s.cfg.Insult.MonthlyBudget; b > 0 {
+		budget = b
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"month":     month,
+		"budget":    budget, // null なら上限なし
+		"total":     total,
+		"providers": usageGroups(providers, 0),
+		"users":     usageGroups(users, maxUsageGroups),
+		"runs":      usageGroups(runs, maxUsageGroups),
+	})
+}
+
+// usageGroups は料金の多い順に並べ、limit (0 なら全部) 件までを返す
+func usageGroups(groups map[string]*LLMUsageTotals, limit int) []LLMUsageGroup {
+	list := make([]LLMUsageGroup, 0, len(groups))
+	for id, t := range groups {
+		list = append(list, LLMUsageGroup{ID: id, LLMUsageTotals: *t})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Cost != list[j].Cost {
+			return list[i].Cost > list[j].Cost
+		}
+		return list[i].ID < list[j].ID
+	})
+	if limit > 0 && len(list) > limit {
+		list = list[:limit]
+	}
+	return list
+}
@@ -0,0 +1,347 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"tundoku-killer/backend/internal/cron"
+)
+
+// 友達に貸した本の記録。loans/{loanId} に保存し、返却予定日を過ぎても返ってこなければ毎日 (/v1/cron/loan-reminders)
+// 貸した本人に催促を勧めるメッセージを送る。借りた人がアプリの友達で、本人が望めば借りた人にも送る。
+// 送信は煽り文と同じ通知の経路 (LINE、LINE をつないでいなければメール) を使う
+
+// loanRemindersLease は返却の催促の二重実行を防ぐロックの名前
+const loanRemindersLease = "loanReminders"
+
+var (
+	errAlreadyLent  = errors.New("book is already lent out")
+	errLoanNotFound = errors.New("loan not found")
+)
+
+// Loan は1回の貸し出し
+type Loan struct {
+	LoanID    string `json:"loanId" firestore:"loanId"`
+	UserID    string `json:"userId" firestore:"userId"` // 貸した人 (本の所持者)
+	BookID    string `json:"bookId" firestore:"bookId"`
+	BookTitle string `json:"bookTitle" firestore:"bookTitle"`
+	// 借りた人の名前。アプリの友達なら BorrowerUserID も持ち、NotifyBorrower なら催促を本人にも送る
+	BorrowerName   string     `json:"borrowerName" firestore:"borrowerName"`
+	BorrowerUserID string     `json:"borrowerUserId,omitempty" firestore:"borrowerUserId,omitempty"`
+	NotifyBorrower bool       `json:"notifyBorrower" firestore:"notifyBorrower"`
+	LentAt         time.Time  `json:"lentAt" firestore:"lentAt"`
+	DueAt          time.Time  `json:"dueAt" firestore:"dueAt"` // 返却予定日
+	Returned       bool       `json:"returned" firestore:"returned"`
+	ReturnedAt     *time.Time `json:"returnedAt,omitempty" firestore:"returnedAt,omitempty"`
+	// 最後に催促した周期 (JSTの日付)。同じ日に二重に送らない
+	LastReminderCycle string `json:"lastReminderCycle,omitempty" firestore:"lastReminderCycle,omitempty"`
+}
+
+// overdue は now の時点で返却予定日を過ぎて返ってきていないかを返す
+func (l Loan) overdue(now time.Time) bool {
+	return !l.Returned && l.DueAt.Before(now)
+}
+
+// handleLoans は貸し出しの一覧 (GET ?userId=) と記録 (POST) を行う
+func (s *Server) handleLoans(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.handleListLoans(w, r)
+	case http.MethodPost:
+		s.handleLendBook(w, r)
+	default:
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// handleListLoans は貸し出しを新しい順に返す。?active=true なら返ってきていないものだけ
+func (s *Server) handleListLoans(w http.ResponseWriter, r *http.Request) {
+	userID := r.URL.Query().Get("userId")
+	if userID == "" {
+		writeProblem(w, r, http.StatusBadRequest, "userId query parameter is required")
+		return
+	}
+	loans, err := s.loansOf(r.Context(), userID)
+	if err != nil {
+		writeServerError(w, r, err, "Failed to retrieve loans")
+		return
+	}
+	if r.URL.Query().Get("active") == "true" {
+		loans = slices.DeleteFunc(loans, func(l Loan) bool { return l.Returned })
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(loans)
+}
+
+// handleLendBook は本を貸したことを記録する。返ってきていない貸し出しがある本は 409
+func (s *Server) handleLendBook(w http.ResponseWriter, r *http.Request) {
+	var req lendBookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid JSON body: %v", err))
+		return
+	}
+	now := time.Now()
+	if err := req.Validate(now); err != nil {
+		writeValidationError(w, r, err)
+		return
+	}
+	ctx := r.Context()
+	book, err := s.ownedBook(ctx, req.BookID, req.UserID)
+	if err != nil {
+		writeBookError(w, r, err, "Failed to retrieve book")
+		return
+	}
+	if book.OnWishlist() {
+		writeProblem(w, r, http.StatusConflict, "Books on the wishlist cannot be lent")
+		return
+	}
+	if req.BorrowerUserID != "" {
+		friends, err := s.friendIDs(ctx, req.UserID)
+		if err != nil {
+			writeServerError(w, r, err, "Failed to retrieve friends")
+			return
+		}
+		if !slices.Contains(friends, req.BorrowerUserID) {
+			writeProblem(w, r, http.StatusForbidden, "The borrower must be one of your friends")
+			return
+		}
+	}
+
+	lentAt := req.LentAt
+	if lentAt.IsZero() {
+		lentAt = now
+	}
+	loan := Loan{
+		LoanID:         s.firestoreClient.Collection("loans").NewDoc().ID,
+		UserID:         req.UserID,
+		BookID:         book.BookID,
+		BookTitle:      book.Title,
+		BorrowerName:   req.BorrowerName,
+		BorrowerUserID: req.BorrowerUserID,
+		NotifyBorrower: req.NotifyBorrower && req.BorrowerUserID != "",
+		LentAt:         lentAt,
+		DueAt:          req.DueAt,
+	}
+	// 同じ本を二重に貸さないよう、貸し出し中の記録を確かめてから書くまでを1つのトランザクションで行う
+	err = s.firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		active, err := tx.Documents(s.firestoreClient.Collection("loans").
+			Where("bookId", "==", book.BookID).
+			Where("returned", "==", false).
+			Limit(1)).GetAll()
+		if err != nil {
+			return err
+		}
+		if len(active) > 0 {
+			return errAlreadyLent
+		}
+		return tx.Create(s.firestoreClient.Collection("loans").Doc(loan.LoanID), loan)
+	})
+	if errors.Is(err, errAlreadyLent) {
+		writeProblem(w, r, http.StatusConflict, "This book is already lent out; mark it as returned first")
+		return
+	}
+	if err != nil {
+		writeServerError(w, r, err, "Failed to save loan")
+		return
+	}
+	s.logger.Printf("Book %s lent to %s until %s", book.BookID, loan.BorrowerName, loan.DueAt.Format(time.RFC3339))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(loan)
+}
+
+// handleReturnLoan は貸した本が返ってきたことを記録する
+func (s *Server) handleReturnLoan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req returnLoanRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid JSON body: %v", err))
+		return
+	}
+	if err := req.Validate(); err != nil {
+		writeValidationError(w, r, err)
+		return
+	}
+	ctx := r.Context()
+	var loan Loan
+	ref := s.firestoreClient.Collection("loans").Doc(req.LoanID)
+	err := s.firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound {
+			return errLoanNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if err := doc.DataTo(&loan); err != nil {
+			return err
+		}
+		if loan.UserID != req.UserID {
+			return errLoanNotFound
+		}
+		if loan.Returned {
+			return nil
+		}
+		now := time.Now()
+		loan.Returned, loan.ReturnedAt = true, &now
+		return tx.Set(ref, loan)
+	})
+	if errors.Is(err, errLoanNotFound) {
+		writeProblem(w, r, http.StatusNotFound, "Loan not found")
+		return
+	}
+	if err != nil {
+		writeServerError(w, r, err, "Failed to update loan")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(loan)
+}
+
+// loansOf は userID が貸した本の記録を、貸した日の新しい順に返す
+func (s *Server) loansOf(ctx context.Context, userID string) ([]Loan, error) {
+	docs, err := s.firestoreClient.Collection("loans").Where("userId", "==", userID).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("error fetching loans: %w", err)
+	}
+	loans := make([]Loan, 0, len(docs))
+	for _, doc := range docs {
+		var loan Loan
+		if err := doc.DataTo(&loan); err != nil {
+			s.logger.Printf("Error parsing loan %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		loans = append(loans, loan)
+	}
+	sort.Slice(loans, func(i, j int) bool { return loans[i].LentAt.After(loans[j].LentAt) })
+	return loans, nil
+}
+
+// handleLoanRemindersCron は返却予定日を過ぎた貸し出しを催促する (毎日)。同じ日に2回実行しても二重には送らない
+func (s *Server) handleLoanRemindersCron(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodGet {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	ctx := context.WithoutCancel(r.Context())
+
+	if !s.authorizeCron(r) {
+		writeProblem(w, r, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	runID := uuid.NewString()
+	if err := s.cron.AcquireLease(ctx, loanRemindersLease, runID, cron.LeaseTTL); err != nil {
+		if errors.Is(err, cron.ErrLeaseHeld) {
+			writeProblem(w, r, http.StatusConflict, "Another loan reminder run is already running")
+			return
+		}
+		writeServerError(w, r, err, "Failed to acquire lock")
+		return
+	}
+	defer s.cron.ReleaseLease(ctx, loanRemindersLease, runID)
+
+	now := time.Now()
+	docs, err := s.firestoreClient.Collection("loans").
+		Where("returned", "==", false).
+		Where("dueAt", "<", now).
+		Documents(ctx).GetAll()
+	if err != nil {
+		writeServerError(w, r, err, "Failed to query loans")
+		return
+	}
+
+	cycle := cron.Cycle(now)
+	deadline := now.Add(cron.TimeBudget)
+	sent, skipped, failed, done := 0, 0, 0, true
+	for _, doc := range docs {
+		if time.Now().After(deadline) {
+			done = false
+			break
+		}
+		var loan Loan
+		if err := doc.DataTo(&loan); err != nil {
+			s.logger.Printf("Error parsing loan %s: %v", doc.Ref.ID, err)
+			failed++
+			continue
+		}
+		if !loan.overdue(now) || loan.LastReminderCycle == cycle {
+			skipped++
+			continue
+		}
+		if err := s.remindLoan(ctx, loan, now); err != nil {
+			s.logger.Printf("Error sending loan reminder for %s: %v", loan.LoanID, err)
+			failed++
+			continue
+		}
+		if _, err := doc.Ref.Update(ctx, []firestore.Update{{Path: "lastReminderCycle", Value: cycle}}); err != nil {
+			s.logger.Printf("Error recording loan reminder for %s: %v", loan.LoanID, err)
+		}
+		sent++
+	}
+
+	s.logger.Printf("Loan reminders %s: %d sent, %d skipped, %d failed (done: %v)", cycle, sent, skipped, failed, done)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"overdue": len(docs),
+		"sent":    sent,
+		"skipped": skipped,
+		"failed":  failed,
+		"done":    done,
+	})
+}
+
+// remindLoan は貸した本人に催促を勧め、望まれていれば借りた人にも返却を促す。借りた人に送れなくても失敗にはしない
+func (s *Server) remindLoan(ctx context.Context, loan Loan, now time.Time) error {
+	days := int(now.Sub(loan.DueAt).Hours()/24) + 1
+	due := loan.DueAt.In(cron.Location).Format("1月2日")
+	message := fmt.Sprintf("%sさんに貸した『%s』は、返却予定の%sを%d日過ぎても返ってきていません。自分の積読は棚に上げて、催促してはいかがですか。",
+		loan.BorrowerName, loan.BookTitle, due, days)
+	if err := s.sendLineMessage(ctx, loan.UserID, message); err != nil {
+		return err
+	}
+
+	if !loan.NotifyBorrower || loan.BorrowerUserID == "" {
+		return nil
+	}
+	lender, err := s.getSettings(ctx, loan.UserID)
+	if err != nil {
+		s.logger.Printf("Error fetching settings for %s: %v", loan.UserID, err)
+	}
+	message = fmt.Sprintf("%sさんから借りている『%s』の返却予定 (%s) を%d日過ぎています。読み終えたなら返しましょう。読み終えていないなら…お察しします。",
+		lender.Name(), loan.BookTitle, due, days)
+	if err := s.sendLineMessage(ctx, loan.BorrowerUserID, message); err != nil {
+		s.logger.Printf("Error sending loan reminder to borrower %s: %v", loan.BorrowerUserID, err)
+	}
+	return nil
+}
+
+// deleteLoans は本を消したとき、その本の貸し出しの記録を消す
+func (s *Server) deleteLoans(ctx context.Context, bookID string) {
+	docs, err := s.firestoreClient.Collection("loans").Where("bookId", "==", bookID).Documents(ctx).GetAll()
+	if err != nil {
+		s.logger.Printf("Error fetching loans of book %s: %v", bookID, err)
+		return
+	}
+	for _, doc := range docs {
+		if _, err := doc.Ref.Delete(ctx); err != nil {
+			s.logger.Printf("Error deleting loan %s: %v", doc.Ref.ID, err)
+		}
+	}
+}
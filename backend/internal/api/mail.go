@@ -0,0 +1,43 @@
+package api
+
+import (
+	"fmt"
+	"mime"
+	"net/smtp"
+	"strings"
+)
+
+// LINE をつないでいないユーザーへのメールでの通知。SMTP_HOST などが未設定なら送らない
+
+// emailSubject は通知メールの件名
+const emailSubject = "積読キラーからのお知らせ"
+
+// sendEmail は to に件名 subject・本文 body のテキストメールを送る
+func (s *Server) sendEmail(to, subject, body string) error {
+	c := s.cfg.SMTP
+	if c.Host == "" {
+		return fmt.Errorf("SMTP_HOST must be set to send email")
+	}
+
+	var auth smtp.Auth
+	if c.Username != "" {
+		auth = smtp.PlainAuth("", c.Username, c.Password, c.Host)
+	}
+
+	msg := strings.Join([]string{
+		"From: " + c.From,
+		"To: " + to,
+		"Subject: " + mimeHeader(subject),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"Content-Transfer-Encoding: 8bit",
+		"",
+		body,
+	}, "\r\n")
+	return smtp.SendMail(c.Host+":"+c.Port, auth, c.From, []string{to}, []byte(msg))
+}
+
+// mimeHeader は日本語の件名を RFC 2047 の形式にする
+func mimeHeader(s string) string {
+	return mime.BEncoding.Encode("UTF-8", s)
+}
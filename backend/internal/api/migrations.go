@@ -0,0 +1,96 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+
+	"tundoku-killer/backend/internal/cron"
+	"tundoku-killer/backend/internal/migrations"
+)
+
+// migrationsLease は複数のインスタンスが同時にマイグレーションを適用しないためのロックの名前
+const migrationsLease = "migrations"
+
+// errBooksNotInFirestore は本を SQL に保存しているときに、本の Firestore のドキュメントを直接読み書きする保守の処理
+// (データのマイグレーション・整合性の検査・孤児の掃除) を呼んだエラー。
+// SQL ではスキーマが型を守り、移行はスキーマのマイグレーション (sqlmigrate) で行う
+var errBooksNotInFirestore = errors.New("books are not stored in Firestore")
+
+// booksInFirestore は本を Firestore に保存している (STORAGE_BACKEND=firestore) かを返す
+func (s *Server) booksInFirestore() bool {
+	return s.sqlDB == nil
+}
+
+// requireFirestoreBooks は本を SQL に保存していれば 501 を書いて false を返す。
+// SQL のときに Firestore の books を読むと本が1冊もないように見え、「違反なし」「孤児なし」と誤った結果を返してしまう
+func (s *Server) requireFirestoreBooks(w http.ResponseWriter, r *http.Request) bool {
+	if s.booksInFirestore() {
+		return true
+	}
+	writeProblem(w, r, http.StatusNotImplemented, fmt.Sprintf("Not supported with STORAGE_BACKEND=%s", s.cfg.Storage.Backend))
+	return false
+}
+
+func (s *Server) migrationRunner() migrations.Runner {
+	return migrations.Runner{
+		Env: migrations.Env{
+			Client:      s.firestoreClient,
+			BookChanged: s.bookRewritten,
+		},
+		Logger:     s.logger,
+		Migrations: migrations.All,
+	}
+}
+
+// Migrate は未適用のデータのマイグレーションを適用する。"migrate" を付けて起動したときと /v1/admin/migrations から呼ぶ。
+// 他で適用中なら cron.ErrLeaseHeld を、本を SQL に保存していれば errBooksNotInFirestore を返す
+func (s *Server) Migrate(ctx context.Context) ([]migrations.Record, error) {
+	if !s.booksInFirestore() {
+		return nil, fmt.Errorf("%w (STORAGE_BACKEND=%s); data migrations apply only to Firestore", errBooksNotInFirestore, s.cfg.Storage.Backend)
+	}
+	runID := uuid.NewString()
+	if err := s.cron.AcquireLease(ctx, migrationsLease, runID, cron.LeaseTTL); err != nil {
+		return nil, err
+	}
+	defer s.cron.ReleaseLease(ctx, migrationsLease, runID)
+	return s.migrationRunner().Up(ctx)
+}
+
+// handleMigrations は GET でマイグレーションの適用状況を返し、POST で未適用のものを適用する
+func (s *Server) handleMigrations(w http.ResponseWriter, r *http.Request) {
+	if !s.requireFirestoreBooks(w, r) {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		statuses, err := s.migrationRunner().Status(r.Context())
+		if err != nil {
+			writeServerError(w, r, err, "Failed to read migration status")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(statuses)
+	case http.MethodPost:
+		applied, err := s.Migrate(context.WithoutCancel(r.Context()))
+		if errors.Is(err, cron.ErrLeaseHeld) {
+			writeProblem(w, r, http.StatusConflict, "Migrations are already running")
+			return
+		}
+		if err != nil {
+			writeServerError(w, r, err, "Failed to apply migrations")
+			return
+		}
+		if applied == nil {
+			applied = []migrations.Record{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"applied": applied})
+	default:
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
@@ -0,0 +1,31 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"tundoku-killer/backend/internal/config"
+)
+
+func TestFirestoreMaintenanceRefusesSQLBooks(t *testing.T) {
+	s := &Server{sqlDB: &sql.DB{}, cfg: config.Config{Storage: config.StorageConfig{Backend: "sqlite"}}}
+	handlers := map[string]http.HandlerFunc{
+		"/v1/admin/consistency": s.handleCheckConsistency,
+		"/v1/admin/orphans":     s.handleCleanupOrphans,
+		"/v1/admin/migrations":  s.handleMigrations,
+	}
+	for path, handler := range handlers {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodPost, path, nil))
+		if rec.Code != http.StatusNotImplemented {
+			t.Errorf("POST %s = %d; want %d", path, rec.Code, http.StatusNotImplemented)
+		}
+	}
+	if _, err := s.Migrate(context.Background()); !errors.Is(err, errBooksNotInFirestore) {
+		t.Errorf("Migrate = %v; want errBooksNotInFirestore", err)
+	}
+}
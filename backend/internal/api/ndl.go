@@ -0,0 +1,144 @@
+package api
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"tundoku-killer/backend/internal/cache"
+)
+
+// 国立国会図書館サーチ (NDL Search) の OpenSearch で ISBN から書誌を調べる。キーは要らない。
+// openBD や楽天ブックスに無い古い本や少部数の本のための予備で、価格の検索 (price.go) と
+// 注文履歴の書名と著者の補い (amazon.go) が、先に調べたところで見つからなかったときだけ使う
+
+const (
+	ndlSearchURL = "https://ndlsearch.ndl.go.jp/api/opensearch"
+	// maxNDLLookups は注文履歴の読み取り1回で NDL に問い合わせる本の数の上限。NDL は1冊ずつしか調べられないため
+	maxNDLLookups = 20
+)
+
+// ndlRecord は NDL の書誌を、本の登録に使う形に整えたもの
+type ndlRecord struct {
+	Title  string `json:"title"`
+	Author string `json:"author,omitempty"`
+	Price  int    `json:"price,omitempty"` // 円。書誌に価格が無ければ 0
+}
+
+// ndlRoles は NDL の著者の表示 ("太宰治 著") の末尾に付く役割
+var ndlRoles = map[string]bool{
+	"著": true, "作": true, "文": true, "絵": true, "画": true, "訳": true, "編": true, "編著": true, "著・編": true,
+	"監修": true, "原作": true, "漫画": true, "作画": true, "写真": true, "共著": true, "述": true, "撰": true,
+}
+
+// ndlPricePattern は価格の表示 ("1,400円 (税別)" "¥1500") の最初の金額
+var ndlPricePattern = regexp.MustCompile(`[0-9][0-9,]*`)
+
+// lookupNDL は code (正規化した ISBN) の本の書誌を NDL で調べる。見つからなければ ok が false
+func (s *Server) lookupNDL(ctx context.Context, code string) (ndlRecord, bool, error) {
+	// 書誌は変わらないので、CATALOG_CACHE_TTL の間は調べ直さない
+	var cached ndlRecord
+	if cache.GetJSON(ctx, s.cache, "ndl:"+code, &cached) {
+		return cached, true, nil
+	}
+	record, ok, err := fetchNDL(ctx, code)
+	if err != nil || !ok {
+		return ndlRecord{}, false, err
+	}
+	if err := cache.SetJSON(ctx, s.cache, "ndl:"+code, record, s.cfg.Cache.CatalogTTL); err != nil {
+		s.logger.Printf("Error caching NDL record for %s: %v", code, err)
+	}
+	return record, true, nil
+}
+
+// fetchNDL は NDL の OpenSearch に code を問い合わせ、最初の図書の書誌を返す。
+// 同じ ISBN に版や所蔵館の違う書誌が並ぶことがあるので、価格が無ければ後の書誌の価格で補う
+func fetchNDL(ctx context.Context, code string) (ndlRecord, bool, error) {
+	var feed struct {
+		Items []struct {
+			Title    string   `xml:"http://purl.org/dc/elements/1.1/ title"`
+			Volume   string   `xml:"http://ndl.go.jp/dcndl/terms/ volume"`
+			Author   string   `xml:"author"`
+			Creators []string `xml:"http://purl.org/dc/elements/1.1/ creator"`
+			Price    string   `xml:"http://ndl.go.jp/dcndl/terms/ price"`
+		} `xml:"channel>item"`
+	}
+	q := url.Values{"isbn": {code}, "cnt": {"10"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ndlSearchURL+"?"+q.Encode(), nil)
+	if err != nil {
+		return ndlRecord{}, false, err
+	}
+	resp, err := tracedHTTPClient.Do(req)
+	if err != nil {
+		return ndlRecord{}, false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return ndlRecord{}, false, fmt.Errorf("unexpected status %d from %s", resp.StatusCode, req.URL.Host)
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&feed); err != nil {
+		return ndlRecord{}, false, err
+	}
+
+	var record ndlRecord
+	for _, item := range feed.Items {
+		title := ndlTitle(item.Title, item.Volume)
+		if title == "" {
+			continue
+		}
+		if record.Title == "" {
+			record = ndlRecord{
+				Title:  truncateRunes(title, maxTitleLength),
+				Author: truncateRunes(ndlAuthor(item.Author, item.Creators), maxAuthorLength),
+			}
+		}
+		if record.Price == 0 {
+			record.Price = ndlPrice(item.Price)
+		}
+	}
+	return record, record.Title != "", nil
+}
+
+// ndlTitle は書名に巻次 ("第3巻") を足し、空白をまとめる
+func ndlTitle(title, volume string) string {
+	return strings.Join(strings.Fields(title+" "+volume), " ")
+}
+
+// ndlAuthor は最初の著者の名前を返す。表示用の著者 ("太宰治 著 ; 山田太郎 解説") から役割を除いて使い、
+// 無ければ典拠形の著者 ("太宰, 治, 1909-1948") を並べ直して使う
+func ndlAuthor(author string, creators []string) string {
+	name, _, _ := strings.Cut(author, ";")
+	name, _, _ = strings.Cut(name, "，")
+	if fields := strings.Fields(name); len(fields) > 1 && ndlRoles[fields[len(fields)-1]] {
+		name = strings.Join(fields[:len(fields)-1], " ")
+	}
+	if name = strings.TrimSpace(name); name != "" || len(creators) == 0 {
+		return name
+	}
+
+	// 生没年を除き、「姓, 名」を和名なら「姓名」、欧文なら「名 姓」にする
+	var parts []string
+	for _, part := range strings.Split(creators[0], ",") {
+		part = strings.TrimSpace(part)
+		if part != "" && !strings.ContainsFunc(part, unicode.IsDigit) {
+			parts = append(parts, part)
+		}
+	}
+	if len(parts) != 2 {
+		return strings.Join(parts, " ")
+	}
+	if strings.ContainsFunc(parts[0], func(r rune) bool { return r > unicode.MaxASCII }) {
+		return parts[0] + parts[1]
+	}
+	return parts[1] + " " + parts[0]
+}
+
+// ndlPrice は書誌の価格 ("1,400円 (税別)" "¥1500") を円にする。読めなければ 0
+func ndlPrice(price string) int {
+	return parseYen(ndlPricePattern.FindString(price))
+}
@@ -0,0 +1,256 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"tundoku-killer/backend/internal/cron"
+	"tundoku-killer/backend/internal/insult"
+	"tundoku-killer/backend/internal/store"
+)
+
+// 期限の交渉。ユーザーが希望した期限を生成AIが受け入れるか、もっと早い期限を対案として出す。
+// 受け入れた期限は store.BookRepository.MoveDeadline で、交渉の間に期限が変わっていないことを確かめながら書き換える。
+// 交渉は deadlineNegotiations/{negotiationId} に保存し、期限を延ばした回数 (次の交渉の材料) に数える
+
+// negotiationOfferTTL は対案を受け入れられる時間
+const negotiationOfferTTL = 24 * time.Hour
+
+// DeadlineNegotiation は1回の交渉
+type DeadlineNegotiation struct {
+	NegotiationID string    `json:"negotiationId" firestore:"negotiationId"`
+	UserID        string    `json:"userId" firestore:"userId"`
+	BookID        string    `json:"bookId" firestore:"bookId"`
+	Previous      time.Time `json:"previousDeadline" firestore:"previous"` // 交渉を始めたときの期限
+	Proposed      time.Time `json:"proposedDeadline" firestore:"proposed"`
+	Decision      string    `json:"decision" firestore:"decision"`
+	Deadline      time.Time `json:"deadline" firestore:"deadline"` // 認めた期限 (対案ならその期限)
+	Remark        string    `json:"remark" firestore:"remark"`
+	Provider      string    `json:"provider,omitempty" firestore:"provider,omitempty"`
+	// Applied は本の期限を書き換えたか。対案はユーザーが受け入れるまで false
+	Applied   bool       `json:"applied" firestore:"applied"`
+	CreatedAt time.Time  `json:"createdAt" firestore:"createdAt"`
+	AppliedAt *time.Time `json:"appliedAt,omitempty" firestore:"appliedAt,omitempty"`
+}
+
+// snoozed は n が期限を延ばした交渉かを返す
+func (n DeadlineNegotiation) snoozed() bool {
+	return n.Applied && n.Deadline.After(n.Previous)
+}
+
+// handleNegotiateDeadline は希望の期限を交渉する。受け入れられればその場で期限を書き換え、対案なら
+// negotiationOfferTTL の間 /v1/books/deadline/negotiate/accept で受け入れられる
+func (s *Server) handleNegotiateDeadline(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req negotiateDeadlineRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid JSON body: %v", err))
+		return
+	}
+	now := time.Now().In(cron.Location)
+	if err := req.Validate(now); err != nil {
+		writeValidationError(w, r, err)
+		return
+	}
+	ctx := r.Context()
+	book, err := s.ownedBook(ctx, req.BookID, req.UserID)
+	if err != nil {
+		writeBookError(w, r, err, "Failed to retrieve book")
+		return
+	}
+	if book.Status == "completed" {
+		writeProblem(w, r, http.StatusConflict, "Book is already completed")
+		return
+	}
+	if book.OnWishlist() {
+		writeProblem(w, r, http.StatusConflict, "Book is on the wishlist; set its deadline via /v1/books/{id}/ownership")
+		return
+	}
+
+	negotiation := DeadlineNegotiation{
+		NegotiationID: s.firestoreClient.Collection("deadlineNegotiations").NewDoc().ID,
+		UserID:        req.UserID,
+		BookID:        req.BookID,
+		Previous:      book.Deadline,
+		Proposed:      req.Deadline,
+		CreatedAt:     now,
+	}
+	if !req.Deadline.After(book.Deadline) {
+		// 早める分には交渉するまでもない
+		negotiation.Decision, negotiation.Deadline = insult.DecisionAccept, req.Deadline
+		negotiation.Remark = "自分から期限を早めるとは殊勝な心がけです。口だけでないことを祈ります。"
+	} else {
+		verdict, err := s.negotiate(ctx, book, req.Deadline, now)
+		if errors.Is(err, insult.ErrBudgetExceeded) || errors.Is(err, insult.ErrNoProvider) {
+			writeProblem(w, r, http.StatusServiceUnavailable, "Deadline negotiation is unavailable right now")
+			return
+		}
+		if err != nil {
+			writeServerError(w, r, err, "Failed to negotiate deadline")
+			return
+		}
+		negotiation.Decision, negotiation.Deadline = verdict.Decision, verdict.Deadline
+		negotiation.Remark, negotiation.Provider = verdict.Remark, verdict.Provider
+		if negotiation.Remark == "" {
+			negotiation.Remark = defaultNegotiationRemark(negotiation)
+		}
+	}
+
+	if negotiation.Decision == insult.DecisionAccept {
+		if err := s.applyNegotiation(ctx, book, &negotiation); err != nil {
+			writeBookError(w, r, err, "Failed to update deadline")
+			return
+		}
+	} else if _, err := s.firestoreClient.Collection("deadlineNegotiations").Doc(negotiation.NegotiationID).Set(ctx, negotiation); err != nil {
+		writeServerError(w, r, err, "Failed to save negotiation")
+		return
+	}
+	s.logger.Printf("Deadline negotiation for book %s: %s (%s -> %s)", book.BookID, negotiation.Decision,
+		negotiation.Previous.Format(time.RFC3339), negotiation.Deadline.Format(time.RFC3339))
+	writeNegotiation(w, negotiation)
+}
+
+// handleAcceptNegotiation は対案の期限を受け入れて書き換える
+func (s *Server) handleAcceptNegotiation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req acceptNegotiationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid JSON body: %v", err))
+		return
+	}
+	if err := req.Validate(); err != nil {
+		writeValidationError(w, r, err)
+		return
+	}
+	ctx := r.Context()
+	doc, err := s.firestoreClient.Collection("deadlineNegotiations").Doc(req.NegotiationID).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		writeProblem(w, r, http.StatusNotFound, "Negotiation not found")
+		return
+	}
+	if err != nil {
+		writeServerError(w, r, err, "Failed to retrieve negotiation")
+		return
+	}
+	var negotiation DeadlineNegotiation
+	if err := doc.DataTo(&negotiation); err != nil {
+		writeServerError(w, r, err, "Failed to parse negotiation")
+		return
+	}
+	if negotiation.UserID != req.UserID {
+		writeProblem(w, r, http.StatusNotFound, "Negotiation not found")
+		return
+	}
+	switch {
+	case negotiation.Applied:
+		writeProblem(w, r, http.StatusConflict, "This deadline has already been applied")
+		return
+	case time.Since(negotiation.CreatedAt) > negotiationOfferTTL:
+		writeProblem(w, r, http.StatusConflict, "The offer has expired; negotiate again")
+		return
+	}
+	book, err := s.ownedBook(ctx, negotiation.BookID, req.UserID)
+	if err != nil {
+		writeBookError(w, r, err, "Failed to retrieve book")
+		return
+	}
+	if book.Status == "completed" {
+		writeProblem(w, r, http.StatusConflict, "Book is already completed")
+		return
+	}
+	if err := s.applyNegotiation(ctx, book, &negotiation); err != nil {
+		writeBookError(w, r, err, "Failed to update deadline")
+		return
+	}
+	writeNegotiation(w, negotiation)
+}
+
+// negotiate は本の長さ・読んだページ数 (オーディオブックは聞いた分数)・読むペース・期限を延ばした回数を集めて、生成AIに交渉させる
+func (s *Server) negotiate(ctx context.Context, book store.Book, proposed, now time.Time) (insult.Verdict, error) {
+	books, err := s.listBooks(ctx, book.UserID)
+	if err != nil {
+		return insult.Verdict{}, err
+	}
+	sessions, err := s.readingSessions(ctx, book.UserID)
+	if err != nil {
+		return insult.Verdict{}, err
+	}
+	history, err := s.firestoreClient.Collection("deadlineNegotiations").Where("userId", "==", book.UserID).Documents(ctx).GetAll()
+	if err != nil {
+		return insult.Verdict{}, fmt.Errorf("error fetching negotiations: %w", err)
+	}
+
+	pace := readingPace(books, sessions, now)
+	n := insult.Negotiation{Book: book, Proposed: proposed, PerDay: pace.perDay(book), Now: now}
+	for _, session := range sessions {
+		if session.BookID == book.BookID {
+			n.Read += session.progress(book)
+		}
+	}
+	for _, doc := range history {
+		var past DeadlineNegotiation
+		if err := doc.DataTo(&past); err != nil || !past.snoozed() {
+			continue
+		}
+		n.TotalSnoozes++
+		if past.BookID == book.BookID {
+			n.Snoozes++
+		}
+	}
+	if length := book.Length(); length > 0 {
+		n.Read = min(n.Read, length)
+		n.Reasonable, _ = suggestDeadline(max(length-n.Read, 1), n.PerDay, now)
+	}
+	if blocklist := s.recipientBlocklist(ctx, book.UserID); blocklist != nil {
+		ctx = insult.WithModerator(ctx, blocklist)
+	}
+	return s.negotiator.Negotiate(ctx, n)
+}
+
+// applyNegotiation は交渉を始めたときの期限のままなら本の期限を書き換え、交渉を書き換え済みとして保存する
+func (s *Server) applyNegotiation(ctx context.Context, book store.Book, negotiation *DeadlineNegotiation) error {
+	if err := s.bookRepo.MoveDeadline(ctx, book.BookID, negotiation.Previous, negotiation.Deadline); err != nil {
+		return err
+	}
+	now := time.Now()
+	negotiation.Applied, negotiation.AppliedAt = true, &now
+	if _, err := s.firestoreClient.Collection("deadlineNegotiations").Doc(negotiation.NegotiationID).Set(ctx, *negotiation); err != nil {
+		s.logger.Printf("Error saving negotiation %s: %v", negotiation.NegotiationID, err)
+	}
+	updated := book
+	updated.Deadline = negotiation.Deadline
+	eventBus.Publish(ctx, BookUpdated{Book: updated, Previous: book})
+	return nil
+}
+
+// defaultNegotiationRemark は生成AIの一言を確認で止めたときの一言
+func defaultNegotiationRemark(n DeadlineNegotiation) string {
+	if n.Decision == insult.DecisionAccept {
+		return fmt.Sprintf("期限を%sまで延ばします。次はありません。", n.Deadline.In(cron.Location).Format("1月2日"))
+	}
+	return fmt.Sprintf("%sまでは認められません。%sまでなら考えましょう。",
+		n.Proposed.In(cron.Location).Format("1月2日"), n.Deadline.In(cron.Location).Format("1月2日"))
+}
+
+// writeNegotiation は交渉の結果を返す。対案なら受け入れられる期限も添える
+func writeNegotiation(w http.ResponseWriter, n DeadlineNegotiation) {
+	body := map[string]interface{}{"negotiation": n}
+	if n.Decision == insult.DecisionCounter && !n.Applied {
+		body["offerExpiresAt"] = n.CreatedAt.Add(negotiationOfferTTL)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
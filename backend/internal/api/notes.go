@@ -0,0 +1,129 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// 本のハイライトとメモ。bookNotes/{noteId} に保存する。いまは Kindle の取り込み (kindle.go) だけが書く。
+// ID は本・種類・位置・本文から作るので、同じハイライトを何度取り込んでも1件のまま
+
+// ノートの種類
+const (
+	NoteHighlight = "highlight"
+	NoteComment   = "note" // 自分で書いたメモ
+)
+
+// BookNote は本のハイライトかメモ
+type BookNote struct {
+	NoteID        string     `json:"noteId" firestore:"noteId"`
+	UserID        string     `json:"userId" firestore:"userId"`
+	BookID        string     `json:"bookId" firestore:"bookId"`
+	Kind          string     `json:"kind" firestore:"kind"` // NoteHighlight か NoteComment
+	Text          string     `json:"text" firestore:"text"`
+	Location      string     `json:"location,omitempty" firestore:"location,omitempty"` // Kindle の位置No. ("170-172" など)
+	Page          string     `json:"page,omitempty" firestore:"page,omitempty"`
+	Source        string     `json:"source" firestore:"source"`                                   // "kindle" など
+	HighlightedAt *time.Time `json:"highlightedAt,omitempty" firestore:"highlightedAt,omitempty"` // 端末で付けた日時 (分かれば)
+	CreatedAt     time.Time  `json:"createdAt" firestore:"createdAt"`
+}
+
+// noteID は本・種類・位置・本文から決まるノートのID
+func noteID(note BookNote) string {
+	sum := sha256.Sum256([]byte(note.BookID + "\x00" + note.Kind + "\x00" + note.Location + "\x00" + note.Page + "\x00" + note.Text))
+	return hex.EncodeToString(sum[:])[:32]
+}
+
+// handleBookNotes は {id} の本のハイライトとメモを、本の中の位置の順に返す (GET ?userId=)
+func (s *Server) handleBookNotes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	userID := r.URL.Query().Get("userId")
+	if userID == "" {
+		writeProblem(w, r, http.StatusBadRequest, "userId query parameter is required")
+		return
+	}
+	ctx := r.Context()
+	book, err := s.ownedBook(ctx, r.PathValue("id"), userID)
+	if err != nil {
+		writeBookError(w, r, err, "Failed to retrieve book")
+		return
+	}
+	notes, err := s.bookNotes(ctx, book.BookID)
+	if err != nil {
+		writeServerError(w, r, err, "Failed to retrieve notes")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(notes)
+}
+
+// bookNotes は bookID の本のノートを、位置の順 (位置が無ければ付けた順) に返す
+func (s *Server) bookNotes(ctx context.Context, bookID string) ([]BookNote, error) {
+	docs, err := s.firestoreClient.Collection("bookNotes").Where("bookId", "==", bookID).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("error fetching notes: %w", err)
+	}
+	notes := make([]BookNote, 0, len(docs))
+	for _, doc := range docs {
+		var note BookNote
+		if err := doc.DataTo(&note); err != nil {
+			s.logger.Printf("Error parsing note %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		notes = append(notes, note)
+	}
+	sort.SliceStable(notes, func(i, j int) bool {
+		if li, lj := locationStart(notes[i].Location), locationStart(notes[j].Location); li != lj {
+			return li < lj
+		}
+		return notes[i].CreatedAt.Before(notes[j].CreatedAt)
+	})
+	return notes, nil
+}
+
+// saveNotes は notes に ID を付けて保存する。同じ ID のノートは上書きする
+func (s *Server) saveNotes(ctx context.Context, notes []BookNote) error {
+	bw := s.firestoreClient.BulkWriter(ctx)
+	jobs := make([]*firestore.BulkWriterJob, 0, len(notes))
+	for i := range notes {
+		notes[i].NoteID = noteID(notes[i])
+		job, err := bw.Set(s.firestoreClient.Collection("bookNotes").Doc(notes[i].NoteID), notes[i])
+		if err != nil {
+			bw.End()
+			return fmt.Errorf("error saving note: %w", err)
+		}
+		jobs = append(jobs, job)
+	}
+	bw.End()
+	for _, job := range jobs {
+		if _, err := job.Results(); err != nil {
+			return fmt.Errorf("error saving note: %w", err)
+		}
+	}
+	return nil
+}
+
+// deleteNotes は消した本のノートを消す
+func (s *Server) deleteNotes(ctx context.Context, bookID string) {
+	docs, err := s.firestoreClient.Collection("bookNotes").Where("bookId", "==", bookID).Documents(ctx).GetAll()
+	if err != nil {
+		s.logger.Printf("Error fetching notes of book %s: %v", bookID, err)
+		return
+	}
+	for _, doc := range docs {
+		if _, err := doc.Ref.Delete(ctx); err != nil {
+			s.logger.Printf("Error deleting note %s: %v", doc.Ref.ID, err)
+		}
+	}
+}
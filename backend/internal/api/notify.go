@@ -0,0 +1,147 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"tundoku-killer/backend/internal/insult"
+	"tundoku-killer/backend/internal/line"
+	"tundoku-killer/backend/internal/store"
+)
+
+// generateInsult は煽り文を生成し、未読の本の合計金額やポイントがあれば Text に添える。
+// A/B テスト中なら所持者に割り当てた種類で生成する。生成AIに書かせる種類なら、同じ煽りレベルで生成済みの文面を使い回す。
+// 所持者と運用者が止めた語句を含む煽り文は送らず、どれも当たるなら期限が過ぎたことだけを伝える
+func (s *Server) generateInsult(ctx context.Context, book store.Book) (insult.Insult, error) {
+	return s.composeInsult(ctx, book, false)
+}
+
+// previewInsult は generateInsult が返す煽り文のプレビューを返す (期限チェックの dry run 用)。
+// 生成AIは呼ばず (予算も使わない)、生成済みの文面があればそれを、なければ同じ口調の用意された煽り文を使う。生成した文面は保存しない
+func (s *Server) previewInsult(ctx context.Context, book store.Book) (insult.Insult, error) {
+	return s.composeInsult(ctx, book, true)
+}
+
+// composeInsult は generateInsult と previewInsult の本体。preview なら生成AIを呼ばない
+func (s *Server) composeInsult(ctx context.Context, book store.Book, preview bool) (insult.Insult, error) {
+	ctx, span := tracer.Start(ctx, "generateInsult", trace.WithAttributes(attribute.Bool("insult.preview", preview)))
+	defer span.End()
+
+	blocklist := s.recipientBlocklist(ctx, book.UserID)
+	if blocklist != nil {
+		ctx = insult.WithModerator(ctx, blocklist)
+	}
+
+	generate := s.insultGenerator.Generate
+	usesLLM := false
+	if s.insultVariants.Enabled() {
+		generate = s.insultVariants.Generate
+		if preview {
+			generate = s.insultVariants.Preview
+		}
+		usesLLM = s.insultVariants.UsesLLM(book.UserID)
+	}
+	generated, cached := insult.Insult{}, false
+	if usesLLM {
+		generated, cached = s.cachedInsult(ctx, book)
+		// 保存した後に止める語句が増えていれば使い回さない
+		cached = cached && blocklist.Check(ctx, generated.Text) == nil
+	}
+	if !cached {
+		var err error
+		generated, err = generate(ctx, book)
+		if isRejected(err) {
+			generated, err = insult.Insult{Text: fmt.Sprintf("「%s」の読了期限が過ぎました。", book.Title)}, nil
+		}
+		if err != nil {
+			return insult.Insult{}, err
+		}
+		if generated.Provider != "" && !preview {
+			s.cacheInsult(ctx, book, generated)
+		}
+	}
+	span.SetAttributes(
+		attribute.String("insult.variant", generated.Variant),
+		attribute.String("insult.template", generated.Template),
+		attribute.String("insult.provider", generated.Provider),
+		attribute.Bool("insult.cached", cached),
+	)
+	// 未読の本の合計金額が分かれば、それも突きつける
+	if guilt := s.shelfGuilt(ctx, book.UserID); guilt != "" {
+		generated.Text += "\n" + guilt
+	}
+	// 今のペースでいつ読み終わるかを突きつける
+	if jab := s.paceJab(ctx, book); jab != "" {
+		generated.Text += "\n" + jab
+	}
+	// 買ったのにまだ開いていなければ、買ってからの日数も突きつける
+	if jab := s.purchaseJab(ctx, book); jab != "" {
+		generated.Text += "\n" + jab
+	}
+	// 購入禁止モードを押し切って買った本なら蒸し返す
+	if jab := s.purchaseBanJab(ctx, book); jab != "" {
+		generated.Text += "\n" + jab
+	}
+	// ポイントがマイナスなら、それもからかう
+	if jab := s.pointsJab(ctx, book.UserID); jab != "" {
+		generated.Text += "\n" + jab
+	}
+	return generated, nil
+}
+
+// sendLineMessage はLINE Messaging API (Push Message) を呼び出す
+func (s *Server) sendLineMessage(ctx context.Context, lineUserID, message string) error {
+	return s.pushLineMessages(ctx, lineUserID, map[string]interface{}{
+		"type": "text",
+		"text": message,
+	})
+}
+
+// pushLineMessages はLINE Messaging APIの push で messages をまとめて送る。
+// LINE をつないでいない Google のアカウントには、同じ内容をメールで送る。停止中のアカウントには送らない。
+// 送れなかったら notificationFailures に記録する
+func (s *Server) pushLineMessages(ctx context.Context, lineUserID string, messages ...interface{}) error {
+	target := s.notificationTargetFor(ctx, lineUserID)
+	if target.disabled {
+		s.logger.Printf("Skipping notification to disabled user %s", lineUserID)
+		return nil
+	}
+	if target.email != "" {
+		err := s.sendEmail(target.email, emailSubject, line.Text(messages))
+		if err != nil {
+			s.recordNotificationFailure(ctx, lineUserID, "email", err)
+		}
+		return err
+	}
+
+	err := s.lineMessenger.Push(ctx, target.lineID, messages)
+	if err != nil {
+		s.recordNotificationFailure(ctx, lineUserID, "line", err)
+	}
+	return err
+}
+
+// NotificationFailure は送れなかった通知の記録。notificationFailures に保存し、/v1/admin/users/notification-failures で見る
+type NotificationFailure struct {
+	UserID  string    `json:"userId" firestore:"userId"`
+	Channel string    `json:"channel" firestore:"channel"` // "line"・"email"・"webhook"
+	Error   string    `json:"error" firestore:"error"`
+	At      time.Time `json:"at" firestore:"at"`
+}
+
+// recordNotificationFailure は送れなかった通知を記録する。問い合わせの調査用なので、失敗してもログに残すだけにする
+func (s *Server) recordNotificationFailure(ctx context.Context, userID, channel string, sendErr error) {
+	_, _, err := s.firestoreClient.Collection("notificationFailures").Add(context.WithoutCancel(ctx), NotificationFailure{
+		UserID:  userID,
+		Channel: channel,
+		Error:   sendErr.Error(),
+		At:      time.Now(),
+	})
+	if err != nil {
+		s.logger.Printf("Error recording notification failure for %s: %v", userID, err)
+	}
+}
@@ -0,0 +1,282 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"firebase.google.com/go/v4/auth"
+	"google.golang.org/api/iterator"
+
+	"tundoku-killer/backend/internal/validation"
+)
+
+// 持ち主のいなくなったデータの掃除。Firebase Authentication に存在しないユーザー (削除されたアカウントや、
+// 統合で消えた LINE のアカウント) の本と、持ち主か本が無くなった煽りの履歴を見つけて、隔離するか削除する
+
+const (
+	orphanQuarantine = "quarantine" // quarantine/{collection}_{docId} に写してから消す
+	orphanDelete     = "delete"
+
+	// orphanSampleSize はレスポンスに載せるドキュメントIDの数
+	orphanSampleSize = 100
+	// authLookupBatch は Auth の GetUsers に一度に渡せる UID の数
+	authLookupBatch = 100
+)
+
+// QuarantinedDoc は掃除で隔離したドキュメント。元のデータをそのまま残し、誤判定なら手で戻せるようにする
+type QuarantinedDoc struct {
+	Collection    string                 `json:"collection" firestore:"collection"`
+	DocID         string                 `json:"docId" firestore:"docId"`
+	Reason        string                 `json:"reason" firestore:"reason"`
+	Data          map[string]interface{} `json:"data" firestore:"data"`
+	QuarantinedAt time.Time              `json:"quarantinedAt" firestore:"quarantinedAt"`
+}
+
+// orphanDoc は掃除の対象として見つかったドキュメント
+type orphanDoc struct {
+	ref    *firestore.DocumentRef
+	data   map[string]interface{}
+	reason string
+}
+
+// OrphanReport は掃除の結果
+type OrphanReport struct {
+	DryRun          bool     `json:"dryRun"`
+	Action          string   `json:"action"`
+	MissingUsers    []string `json:"missingUsers"`
+	OrphanBooks     int      `json:"orphanBooks"`
+	OrphanInsults   int      `json:"orphanInsults"`
+	Removed         int      `json:"removed"`
+	Failed          int      `json:"failed"`
+	SampleBookIDs   []string `json:"sampleBookIds"`
+	SampleInsultIDs []string `json:"sampleInsultIds"`
+}
+
+// handleCleanupOrphans は持ち主のいなくなった本と煽りの履歴を探す。
+// ?dryRun=false を付けたときだけ ?action= (quarantine・delete、既定は quarantine) を実行する。
+// 本のドキュメントを移し替えるので、本を SQL に保存しているときは 501 (本が無いように見えて、煽りの履歴をすべて孤児とみなしてしまう)
+func (s *Server) handleCleanupOrphans(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if !s.requireFirestoreBooks(w, r) {
+		return
+	}
+
+	query := r.URL.Query()
+	action := query.Get("action")
+	if action == "" {
+		action = orphanQuarantine
+	}
+	var v validation.Validator
+	v.OneOf("action", action, orphanQuarantine, orphanDelete)
+	v.OneOf("dryRun", query.Get("dryRun"), "", "true", "false")
+	if err := v.Err(); err != nil {
+		writeValidationError(w, r, err)
+		return
+	}
+	dryRun := query.Get("dryRun") != "false"
+
+	ctx := context.WithoutCancel(r.Context())
+	books, bookUsers, err := s.scanBookOwners(ctx)
+	if err != nil {
+		writeServerError(w, r, err, "Failed to scan books")
+		return
+	}
+	missing, err := s.missingAuthUsers(ctx, bookUsers)
+	if err != nil {
+		writeServerError(w, r, err, "Failed to look up users")
+		return
+	}
+	insults, err := s.findOrphanInsults(ctx, books, missing)
+	if err != nil {
+		writeServerError(w, r, err, "Failed to scan insult history")
+		return
+	}
+
+	report := OrphanReport{
+		DryRun:          dryRun,
+		Action:          action,
+		MissingUsers:    make([]string, 0, len(missing)),
+		SampleBookIDs:   []string{},
+		SampleInsultIDs: []string{},
+	}
+	for userID := range missing {
+		report.MissingUsers = append(report.MissingUsers, userID)
+	}
+	sort.Strings(report.MissingUsers)
+	var orphans []orphanDoc
+	for _, doc := range books {
+		userID, _ := doc.data["userId"].(string)
+		if !missing[userID] {
+			continue
+		}
+		report.OrphanBooks++
+		if len(report.SampleBookIDs) < orphanSampleSize {
+			report.SampleBookIDs = append(report.SampleBookIDs, doc.ref.ID)
+		}
+		orphans = append(orphans, doc)
+	}
+	for _, doc := range insults {
+		report.OrphanInsults++
+		if len(report.SampleInsultIDs) < orphanSampleSize {
+			report.SampleInsultIDs = append(report.SampleInsultIDs, doc.ref.ID)
+		}
+		orphans = append(orphans, doc)
+	}
+
+	if !dryRun {
+		for _, doc := range orphans {
+			if err := s.removeOrphan(ctx, doc, action); err != nil {
+				s.logger.Printf("Error removing orphaned %s/%s: %v", doc.ref.Parent.ID, doc.ref.ID, err)
+				report.Failed++
+				continue
+			}
+			report.Removed++
+		}
+	}
+
+	s.logger.Printf("Orphan cleanup (dryRun=%t, action=%s): %d missing users, %d books, %d insults, %d removed, %d failed",
+		dryRun, action, len(missing), report.OrphanBooks, report.OrphanInsults, report.Removed, report.Failed)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// scanBookOwners はすべての本を読み、本 (ID → ドキュメント) と持ち主の UID の一覧を返す
+func (s *Server) scanBookOwners(ctx context.Context) (map[string]orphanDoc, []string, error) {
+	iter := s.firestoreClient.Collection("books").Documents(ctx)
+	defer iter.Stop()
+
+	books := make(map[string]orphanDoc)
+	seen := make(map[string]bool)
+	var userIDs []string
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		data := doc.Data()
+		books[doc.Ref.ID] = orphanDoc{ref: doc.Ref, data: data, reason: "owner does not exist"}
+		if userID, _ := data["userId"].(string); userID != "" && !seen[userID] {
+			seen[userID] = true
+			userIDs = append(userIDs, userID)
+		}
+	}
+	return books, userIDs, nil
+}
+
+// missingAuthUsers は userIDs のうち Firebase Authentication に存在しないものを返す
+func (s *Server) missingAuthUsers(ctx context.Context, userIDs []string) (map[string]bool, error) {
+	client, err := s.firebaseApp.Auth(ctx)
+	if err != nil {
+		return nil, err
+	}
+	missing := make(map[string]bool)
+	for start := 0; start < len(userIDs); start += authLookupBatch {
+		end := min(start+authLookupBatch, len(userIDs))
+		ids := make([]auth.UserIdentifier, 0, end-start)
+		for _, userID := range userIDs[start:end] {
+			ids = append(ids, auth.UIDIdentifier{UID: userID})
+		}
+		result, err := client.GetUsers(ctx, ids)
+		if err != nil {
+			return nil, fmt.Errorf("error looking up users: %w", err)
+		}
+		for _, id := range result.NotFound {
+			if uid, ok := id.(auth.UIDIdentifier); ok {
+				missing[uid.UID] = true
+			}
+		}
+	}
+	return missing, nil
+}
+
+// findOrphanInsults は持ち主が存在しないか、本が削除された (アーカイブにも無い) 煽りの履歴を返す
+func (s *Server) findOrphanInsults(ctx context.Context, books map[string]orphanDoc, missing map[string]bool) ([]orphanDoc, error) {
+	iter := s.firestoreClient.Collection("insults").Documents(ctx)
+	defer iter.Stop()
+
+	archived := make(map[string]map[string]bool) // userId → アーカイブ済みの本のID
+	var orphans []orphanDoc
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		data := doc.Data()
+		userID, _ := data["userId"].(string)
+		bookID, _ := data["bookId"].(string)
+		if missing[userID] {
+			orphans = append(orphans, orphanDoc{ref: doc.Ref, data: data, reason: "owner does not exist"})
+			continue
+		}
+		if _, ok := books[bookID]; ok {
+			continue
+		}
+		if _, ok := archived[userID]; !ok {
+			ids, err := s.archivedBookIDs(ctx, userID)
+			if err != nil {
+				return nil, err
+			}
+			archived[userID] = ids
+		}
+		if !archived[userID][bookID] {
+			orphans = append(orphans, orphanDoc{ref: doc.Ref, data: data, reason: "book does not exist"})
+		}
+	}
+	return orphans, nil
+}
+
+// archivedBookIDs は userID がアーカイブに移した本のIDを返す
+func (s *Server) archivedBookIDs(ctx context.Context, userID string) (map[string]bool, error) {
+	docs, err := s.firestoreClient.Collection("bookArchives").Where("userId", "==", userID).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[string]bool)
+	for _, doc := range docs {
+		var a BookArchive
+		if err := doc.DataTo(&a); err != nil {
+			return nil, fmt.Errorf("error parsing archive %s: %w", doc.Ref.ID, err)
+		}
+		for _, book := range a.Books {
+			ids[book.BookID] = true
+		}
+	}
+	return ids, nil
+}
+
+// removeOrphan は doc を隔離 (quarantine に写してから削除) するか、そのまま削除する。
+// 本はキャッシュと本棚のバージョンも更新されるよう、リポジトリを通して消す
+func (s *Server) removeOrphan(ctx context.Context, doc orphanDoc, action string) error {
+	collection := doc.ref.Parent.ID
+	if action == orphanQuarantine {
+		_, err := s.firestoreClient.Collection("quarantine").Doc(collection+"_"+doc.ref.ID).Set(ctx, QuarantinedDoc{
+			Collection:    collection,
+			DocID:         doc.ref.ID,
+			Reason:        doc.reason,
+			Data:          doc.data,
+			QuarantinedAt: time.Now(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if collection == "books" {
+		return s.bookRepo.Delete(ctx, doc.ref.ID)
+	}
+	_, err := doc.ref.Delete(ctx)
+	return err
+}
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"tundoku-killer/backend/internal/store"
+	"tundoku-killer/backend/internal/validation"
+)
+
+// 本の持ち方 (ウィッシュリスト・持っている・借りている)。ウィッシュリストの本は期限がなく、期限切れの煽り・恥の壁・集計に入らない。
+// ウィッシュリストから外すときは期限を決めさせ、その時点から登録日時 (読了までの日数の起点) を数え直す
+
+// handleBookOwnership は {id} の本の持ち方を変える (PUT)
+func (s *Server) handleBookOwnership(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req bookOwnershipRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid JSON body: %v", err))
+		return
+	}
+	now := time.Now()
+	if err := req.Validate(now); err != nil {
+		writeValidationError(w, r, err)
+		return
+	}
+	ctx := r.Context()
+	book, err := s.ownedBook(ctx, r.PathValue("id"), req.UserID)
+	if err != nil {
+		writeBookError(w, r, err, "Failed to retrieve book")
+		return
+	}
+
+	updated := book
+	updated.Ownership = req.Ownership
+	switch {
+	case updated.OnWishlist() && !book.OnWishlist():
+		if book.Status == "completed" {
+			writeProblem(w, r, http.StatusConflict, "Completed books cannot go back to the wishlist")
+			return
+		}
+		if book.Pledge != nil && (book.Pledge.State == store.PledgeActive || book.Pledge.State == store.PledgeOwed) {
+			writeProblem(w, r, http.StatusConflict, "Cancel or settle the pledge before moving the book to the wishlist")
+			return
+		}
+		updated.Deadline = time.Time{}
+	case !updated.OnWishlist() && book.OnWishlist():
+		// 手に入れたら期限を決めて、そこから数え始める
+		if req.Deadline.IsZero() {
+			var v validation.Validator
+			v.Check(false, "deadline", "is required when moving a book off the wishlist (see /v1/books/suggest-deadline)")
+			writeValidationError(w, r, v.Err())
+			return
+		}
+		updated.Deadline = req.Deadline
+		// 手に入れた本の値下がりはもう見張らない
+		updated.PriceWatch = nil
+		updated.CreatedAt = &now
+	}
+	// 借りていない本に図書館の返却期限は残さない
+	if updated.Ownership != store.OwnershipBorrowed {
+		updated.Library = nil
+	}
+
+	if updated.Ownership != book.Ownership || !updated.Deadline.Equal(book.Deadline) {
+		if err := s.bookRepo.Update(ctx, updated); err != nil {
+			writeBookError(w, r, err, "Failed to update book")
+			return
+		}
+		s.logger.Printf("Book %s moved from %q to %q", book.BookID, book.Ownership, updated.Ownership)
+		eventBus.Publish(ctx, BookUpdated{Book: updated, Previous: book})
+	}
+
+	resp := map[string]interface{}{"book": updated}
+	if book.OnWishlist() && !updated.OnWishlist() {
+		if warnings := s.dependencyWarnings(ctx, updated); len(warnings) > 0 {
+			resp["warnings"] = warnings
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
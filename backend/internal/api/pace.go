@@ -0,0 +1,265 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"tundoku-killer/backend/internal/cron"
+	"tundoku-killer/backend/internal/store"
+)
+
+// 読書のペース (1日あたりのページ数) と、それを使った期限の提案・読了見込み。
+// 読書の記録 (sessions.go) があれば、直近 paceWindow の記録のページ数を、読まなかった日も含めた日数で割った実質のペースを使う。
+// なければ、ページ数・登録日時・読了日時が記録された読了本の、合計ページ数 ÷ 合計日数で求める。
+// オーディオブックはページではなく分で数えるので、聞いた分数から同じように求めた聞くペース (1日あたりの分数) を別に使う
+
+const (
+	// defaultPagesPerDay は読書の記録も読了の記録もまだないユーザーに仮に使うペース
+	defaultPagesPerDay = 30.0
+	// defaultListeningMinutesPerDay はオーディオブックを聞いた記録がまだないユーザーに仮に使う聞くペース
+	defaultListeningMinutesPerDay = 30.0
+	// deadlineSlack は提案する期限に足す余裕の割合。ペースどおりに毎日読めるとは限らない
+	deadlineSlack = 1.2
+	// maxSuggestedDays は提案する期限の上限 (日数)
+	maxSuggestedDays = 365
+	// paceWindow は実質のペースを求める直近の期間
+	paceWindow = 30 * 24 * time.Hour
+	// minPaceDays は読書の記録を始めて間もないユーザーのペースを割る最小の日数。初日だけ読んで張り切ったペースにしない
+	minPaceDays = 7.0
+	// maxETADays は読了見込みの上限 (日数)。これより遅いものはこの日数で打ち切る
+	maxETADays = 100 * 365
+)
+
+// ペースの求め方
+const (
+	paceFromSessions    = "sessions"    // 直近の読書の記録
+	paceFromCompletions = "completions" // 読了本の登録から読了までの日数
+	paceDefault         = "default"     // 記録がないので defaultPagesPerDay (聞くペースは defaultListeningMinutesPerDay)
+)
+
+// ReadingPace はユーザーの読書のペース
+type ReadingPace struct {
+	PagesPerDay float64 `json:"pagesPerDay" firestore:"pagesPerDay"`
+	Source      string  `json:"source" firestore:"source"`
+	// Samples はペースの計算に使った読書の記録、または読了本の数
+	Samples int `json:"samples" firestore:"samples"`
+	// MinutesPerDay は直近 paceWindow の読書の記録 (タイマー) の1日あたりの分数。記録がなければ 0
+	MinutesPerDay float64 `json:"minutesPerDay" firestore:"minutesPerDay"`
+	// ListeningMinutesPerDay はオーディオブックを1日に聞く分数。求め方は ListeningSource
+	ListeningMinutesPerDay float64 `json:"listeningMinutesPerDay" firestore:"listeningMinutesPerDay"`
+	ListeningSource        string  `json:"listeningSource" firestore:"listeningSource"`
+}
+
+// perDay は book の1日あたりのペース。オーディオブックは聞く分数、それ以外はページ数
+func (p ReadingPace) perDay(book store.Book) float64 {
+	if book.Audiobook() {
+		return p.ListeningMinutesPerDay
+	}
+	return p.PagesPerDay
+}
+
+// BookETA は読み終えていない本の読了見込み。オーディオブックは Pages・PagesRead の代わりに Minutes・MinutesListened を使う
+type BookETA struct {
+	BookID    string `json:"bookId" firestore:"bookId"`
+	Title     string `json:"title" firestore:"title"`
+	Pages     int    `json:"pages" firestore:"pages"`
+	PagesRead int    `json:"pagesRead" firestore:"pagesRead"` // 読書の記録の合計 (ページ数まで)
+	// オーディオブックの長さ (分) と、聞いた分数の合計 (長さまで)
+	Minutes         int       `json:"minutes,omitempty" firestore:"minutes,omitempty"`
+	MinutesListened int       `json:"minutesListened,omitempty" firestore:"minutesListened,omitempty"`
+	Days            int       `json:"days" firestore:"days"`
+	ETA             time.Time `json:"eta" firestore:"eta"` // この日 (JST) の終わりまでに読み終える見込み
+}
+
+// readingPace は now 時点のペースを求める。直近 paceWindow に読書の記録があればそれを、なければ読了本を使う。
+// 読むペースと聞くペースは、それぞれ紙の本・電子書籍とオーディオブックの記録だけから求める
+func readingPace(books []store.Book, sessions []ReadingSession, now time.Time) ReadingPace {
+	audiobooks := map[string]bool{}
+	for _, book := range books {
+		if book.Audiobook() {
+			audiobooks[book.BookID] = true
+		}
+	}
+	var first time.Time
+	pages, listened, minutes, samples := 0, 0, 0, 0
+	for _, session := range sessions {
+		if first.IsZero() || session.ReadAt.Before(first) {
+			first = session.ReadAt
+		}
+		if now.Sub(session.ReadAt) <= paceWindow {
+			minutes += session.Minutes
+			if audiobooks[session.BookID] {
+				listened += session.Minutes
+			} else {
+				pages += session.Pages
+				samples++
+			}
+		}
+	}
+	days := min(max(now.Sub(first).Hours()/24, minPaceDays), paceWindow.Hours()/24)
+	pace := ReadingPace{MinutesPerDay: roundTo(float64(minutes)/days, 1)}
+
+	switch perDay, n := completionPace(books, false); {
+	case pages > 0:
+		pace.PagesPerDay, pace.Source, pace.Samples = roundTo(float64(pages)/days, 1), paceFromSessions, samples
+	case n > 0:
+		pace.PagesPerDay, pace.Source, pace.Samples = perDay, paceFromCompletions, n
+	default:
+		pace.PagesPerDay, pace.Source = defaultPagesPerDay, paceDefault
+	}
+	switch perDay, n := completionPace(books, true); {
+	case listened > 0:
+		pace.ListeningMinutesPerDay, pace.ListeningSource = roundTo(float64(listened)/days, 1), paceFromSessions
+	case n > 0:
+		pace.ListeningMinutesPerDay, pace.ListeningSource = perDay, paceFromCompletions
+	default:
+		pace.ListeningMinutesPerDay, pace.ListeningSource = defaultListeningMinutesPerDay, paceDefault
+	}
+	return pace
+}
+
+// completionPace は長さ・登録日時・読了日時の分かる読了本 (audio ならオーディオブック、でなければそれ以外) の
+// 合計の長さ ÷ 合計日数と、その冊数を返す
+func completionPace(books []store.Book, audio bool) (float64, int) {
+	var length, days float64
+	samples := 0
+	for _, book := range books {
+		if book.Audiobook() != audio || book.Status != "completed" || book.Length() <= 0 || book.CreatedAt == nil || book.CompletedAt == nil {
+			continue
+		}
+		length += float64(book.Length())
+		days += math.Max(book.CompletedAt.Sub(*book.CreatedAt).Hours()/24, 1)
+		samples++
+	}
+	if samples == 0 {
+		return 0, 0
+	}
+	return roundTo(length/days, 1), samples
+}
+
+// bookETAs は長さ (ページ数、オーディオブックは分) の分かる、読み終えていない本の読了見込みを返す
+func bookETAs(books []store.Book, sessions []ReadingSession, pace ReadingPace, now time.Time) []BookETA {
+	pages, minutes := map[string]int{}, map[string]int{}
+	for _, session := range sessions {
+		pages[session.BookID] += session.Pages
+		minutes[session.BookID] += session.Minutes
+	}
+	etas := []BookETA{}
+	for _, book := range books {
+		length := book.Length()
+		if book.Status == "completed" || book.Abandoned() || book.OnWishlist() || length <= 0 {
+			continue
+		}
+		eta := BookETA{BookID: book.BookID, Title: book.Title}
+		read := min(pages[book.BookID], length)
+		if book.Audiobook() {
+			read = min(minutes[book.BookID], length)
+			eta.Minutes, eta.MinutesListened = length, read
+		} else {
+			eta.Pages, eta.PagesRead = length, read
+		}
+		eta.Days = min(int(math.Ceil(float64(length-read)/pace.perDay(book))), maxETADays)
+		eta.ETA = endOfDayAfter(now, eta.Days)
+		etas = append(etas, eta)
+	}
+	return etas
+}
+
+// suggestDeadline は長さ length (ページ数、オーディオブックは分) の本を1日 perDay のペースで読み終えられる期限と日数を返す
+func suggestDeadline(length int, perDay float64, now time.Time) (time.Time, int) {
+	days := int(math.Ceil(float64(length) / perDay * deadlineSlack))
+	days = min(max(days, 1), maxSuggestedDays)
+	return endOfDayAfter(now, days), days
+}
+
+// endOfDayAfter は now の days 日後の日 (JST) の終わりを返す
+func endOfDayAfter(now time.Time, days int) time.Time {
+	today := now.In(cron.Location)
+	return time.Date(today.Year(), today.Month(), today.Day()+days+1, 0, 0, 0, 0, cron.Location).Add(-time.Second)
+}
+
+// paceJab は今のペースで読むと book を読み終えるのがいつになるかを突きつける一文を返す。長さが分からなければ空
+func (s *Server) paceJab(ctx context.Context, book store.Book) string {
+	if book.Length() <= 0 {
+		return ""
+	}
+	stats, err := s.userStats(ctx, book.UserID)
+	if err != nil {
+		s.logger.Printf("Error fetching stats for user %s: %v", book.UserID, err)
+		return ""
+	}
+	for _, eta := range stats.ETAs {
+		if eta.BookID != book.BookID {
+			continue
+		}
+		finish := eta.ETA.In(cron.Location)
+		if finish.Year() != time.Now().In(cron.Location).Year() {
+			return fmt.Sprintf("このペースだと読了は%d年です。", finish.Year())
+		}
+		return fmt.Sprintf("このペースだと読了は%sです。", finish.Format("1月2日"))
+	}
+	return ""
+}
+
+// handleSuggestDeadline は ?userId=&pages= の本 (オーディオブックは pages の代わりに minutes= に長さの分数) を、
+// ユーザーのペースで読み終えられる期限を返す。登録画面の期限の初期値に使う
+func (s *Server) handleSuggestDeadline(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	q := r.URL.Query()
+	userID := q.Get("userId")
+	if userID == "" {
+		writeProblem(w, r, http.StatusBadRequest, "userId query parameter is required")
+		return
+	}
+	// 長さをページ数で渡されたら紙の本、分数で渡されたらオーディオブックのペースで数える
+	book := store.Book{Format: store.FormatPaper}
+	if raw := q.Get("minutes"); raw != "" {
+		minutes, err := strconv.Atoi(raw)
+		if err != nil || minutes < 1 || minutes > maxBookMinutes {
+			writeProblem(w, r, http.StatusBadRequest, "minutes must be an integer between 1 and 10000")
+			return
+		}
+		book = store.Book{Format: store.FormatAudiobook, Minutes: minutes}
+	} else {
+		pages, err := strconv.Atoi(q.Get("pages"))
+		if err != nil || pages < 1 || pages > maxPages {
+			writeProblem(w, r, http.StatusBadRequest, "pages must be an integer between 1 and 100000")
+			return
+		}
+		book.Pages = pages
+	}
+
+	ctx := r.Context()
+	books, err := s.listBooks(ctx, userID)
+	if err != nil {
+		writeServerError(w, r, err, "Failed to retrieve books")
+		return
+	}
+	sessions, err := s.readingSessions(ctx, userID)
+	if err != nil {
+		writeServerError(w, r, err, "Failed to retrieve reading sessions")
+		return
+	}
+	pace := readingPace(books, sessions, time.Now())
+	deadline, days := suggestDeadline(book.Length(), pace.perDay(book), time.Now())
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"userId":   userID,
+		"pages":    book.Pages,   // オーディオブックなら 0
+		"minutes":  book.Minutes, // オーディオブックの長さ。それ以外は 0
+		"pace":     pace,
+		"days":     days,
+		"deadline": deadline,
+		"date":     deadline.In(cron.Location).Format("2006-01-02"), // 日付の入力欄にそのまま入れる用
+	})
+}
@@ -0,0 +1,164 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"tundoku-killer/backend/internal/cron"
+	"tundoku-killer/backend/internal/store"
+)
+
+// paceNow はペースの計算の基準にする時刻 (2024-06-15 12:00 JST)
+var paceNow = time.Date(2024, 6, 15, 12, 0, 0, 0, cron.Location)
+
+func daysBefore(days int) time.Time {
+	return paceNow.AddDate(0, 0, -days)
+}
+
+func timeBefore(days int) *time.Time {
+	t := daysBefore(days)
+	return &t
+}
+
+func TestReadingPace(t *testing.T) {
+	paper := store.Book{BookID: "paper", Format: store.FormatPaper}
+	audio := store.Book{BookID: "audio", Format: store.FormatAudiobook}
+	tests := []struct {
+		name     string
+		books    []store.Book
+		sessions []ReadingSession
+		want     ReadingPace
+	}{
+		{
+			name: "記録がなければ仮のペース",
+			want: ReadingPace{
+				PagesPerDay: defaultPagesPerDay, Source: paceDefault,
+				ListeningMinutesPerDay: defaultListeningMinutesPerDay, ListeningSource: paceDefault,
+			},
+		},
+		{
+			// paceWindow より前の記録は数えないが、記録を始めた日には使うので paceWindow の日数で割る
+			name:  "直近の読書の記録",
+			books: []store.Book{paper},
+			sessions: []ReadingSession{
+				{BookID: "paper", Pages: 100, Minutes: 60, ReadAt: daysBefore(40)},
+				{BookID: "paper", Pages: 50, Minutes: 20, ReadAt: daysBefore(10)},
+				{BookID: "paper", Pages: 30, Minutes: 20, ReadAt: daysBefore(2)},
+			},
+			want: ReadingPace{
+				PagesPerDay: 2.7, Source: paceFromSessions, Samples: 2, MinutesPerDay: 1.3,
+				ListeningMinutesPerDay: defaultListeningMinutesPerDay, ListeningSource: paceDefault,
+			},
+		},
+		{
+			name:     "始めたばかりなら minPaceDays で割る",
+			books:    []store.Book{paper},
+			sessions: []ReadingSession{{BookID: "paper", Pages: 70, ReadAt: daysBefore(1)}},
+			want: ReadingPace{
+				PagesPerDay: 10, Source: paceFromSessions, Samples: 1,
+				ListeningMinutesPerDay: defaultListeningMinutesPerDay, ListeningSource: paceDefault,
+			},
+		},
+		{
+			name:     "オーディオブックの記録は聞くペースにだけ数える",
+			books:    []store.Book{paper, audio},
+			sessions: []ReadingSession{{BookID: "audio", Minutes: 90, ReadAt: daysBefore(2)}},
+			want: ReadingPace{
+				PagesPerDay: defaultPagesPerDay, Source: paceDefault, MinutesPerDay: 12.9,
+				ListeningMinutesPerDay: 12.9, ListeningSource: paceFromSessions,
+			},
+		},
+		{
+			name: "記録がなければ読了本から求める",
+			books: []store.Book{
+				{Status: "completed", Pages: 300, CreatedAt: timeBefore(20), CompletedAt: timeBefore(10)},
+				{Status: "completed", Pages: 200, CreatedAt: timeBefore(5), CompletedAt: timeBefore(0)},
+				{Status: "completed", Pages: 999}, // 登録日時が分からないので数えない
+				{Status: "completed", Format: store.FormatAudiobook, Minutes: 600, CreatedAt: timeBefore(3), CompletedAt: timeBefore(0)},
+			},
+			want: ReadingPace{
+				PagesPerDay: 33.3, Source: paceFromCompletions, Samples: 2,
+				ListeningMinutesPerDay: 200, ListeningSource: paceFromCompletions,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := readingPace(tt.books, tt.sessions, paceNow); got != tt.want {
+				t.Errorf("readingPace = %+v; want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBookETAs(t *testing.T) {
+	books := []store.Book{
+		{BookID: "paper", Title: "紙の本", Pages: 300},
+		{BookID: "audio", Title: "オーディオブック", Format: store.FormatAudiobook, Minutes: 120},
+		{BookID: "completed", Pages: 300, Status: "completed"},
+		{BookID: "wishlist", Pages: 300, Ownership: store.OwnershipWishlist},
+		{BookID: "unknown"},
+	}
+	sessions := []ReadingSession{
+		{BookID: "paper", Pages: 60},
+		{BookID: "paper", Pages: 40},
+		{BookID: "audio", Minutes: 150}, // 長さより多く聞いても長さまで
+	}
+	pace := ReadingPace{PagesPerDay: 20, ListeningMinutesPerDay: 30}
+
+	got := bookETAs(books, sessions, pace, paceNow)
+	want := []BookETA{
+		{BookID: "paper", Title: "紙の本", Pages: 300, PagesRead: 100, Days: 10, ETA: endOfDayAfter(paceNow, 10)},
+		{BookID: "audio", Title: "オーディオブック", Minutes: 120, MinutesListened: 120, Days: 0, ETA: endOfDayAfter(paceNow, 0)},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("bookETAs = %+v; want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("bookETAs[%d] = %+v; want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestSuggestDeadline(t *testing.T) {
+	tests := []struct {
+		name   string
+		length int
+		perDay float64
+		days   int
+	}{
+		{"余裕を足して切り上げる", 70, 30, 3},
+		{"最短でも1日", 0, 30, 1},
+		{"長くても maxSuggestedDays", 100000, 1, maxSuggestedDays},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deadline, days := suggestDeadline(tt.length, tt.perDay, paceNow)
+			if days != tt.days {
+				t.Errorf("days = %d; want %d", days, tt.days)
+			}
+			if want := endOfDayAfter(paceNow, tt.days); !deadline.Equal(want) {
+				t.Errorf("deadline = %v; want %v", deadline, want)
+			}
+		})
+	}
+}
+
+func TestEndOfDayAfter(t *testing.T) {
+	// UTC ではまだ前日でも、JST の日付で数える
+	now := time.Date(2024, 6, 15, 23, 30, 0, 0, time.UTC)
+	tests := []struct {
+		days int
+		want time.Time
+	}{
+		{0, time.Date(2024, 6, 16, 23, 59, 59, 0, cron.Location)},
+		{1, time.Date(2024, 6, 17, 23, 59, 59, 0, cron.Location)},
+		{15, time.Date(2024, 7, 1, 23, 59, 59, 0, cron.Location)},
+	}
+	for _, tt := range tests {
+		if got := endOfDayAfter(now, tt.days); !got.Equal(tt.want) {
+			t.Errorf("endOfDayAfter(%v, %d) = %v; want %v", now, tt.days, got, tt.want)
+		}
+	}
+}
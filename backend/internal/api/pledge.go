@@ -0,0 +1,163 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"tundoku-killer/backend/internal/store"
+)
+
+// 本に付ける「誓約」。期限までに読み終えなければ決めた金額を寄付すると約束し、
+// 期限を過ぎたら煽り文に支払いのリマインダーを添える。アプリはお金を扱わず、状態だけを記録する
+
+var errPledgeState = errors.New("pledge cannot be changed in its current state")
+
+// pledgeReminder は期限切れの煽り文に添える支払いのリマインダー
+func pledgeReminder(p store.Pledge) string {
+	msg := fmt.Sprintf("約束を覚えていますか？ 期限を破ったので、%sに%sを寄付してください。", p.Recipient, formatYen(p.Amount))
+	if p.PaymentURL != "" {
+		msg += "\n" + p.PaymentURL
+	}
+	return msg
+}
+
+// markPledgeOwed は期限切れの本の誓約を owed にする。owed にしたら true
+func markPledgeOwed(book *store.Book, now time.Time) bool {
+	if book.Pledge == nil || book.Pledge.State != store.PledgeActive {
+		return false
+	}
+	book.Pledge.State = store.PledgeOwed
+	book.Pledge.OwedAt = &now
+	return true
+}
+
+// releasePledge は読了した本の誓約を、期限前なら released にする。released にしたら true
+func releasePledge(book *store.Book, now time.Time) bool {
+	if book.Pledge == nil || book.Pledge.State != store.PledgeActive || now.After(book.Deadline) {
+		return false
+	}
+	book.Pledge.State = store.PledgeReleased
+	book.Pledge.ResolvedAt = &now
+	return true
+}
+
+// handlePledge は本への誓約の設定 (PUT) と取り消し (DELETE) を行う。どちらも期限前の active な誓約だけ
+func (s *Server) handlePledge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut && r.Method != http.MethodDelete {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req pledgeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid JSON body: %v", err))
+		return
+	}
+	if err := req.Validate(r.Method == http.MethodPut); err != nil {
+		writeValidationError(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	now := time.Now()
+	var pledge *store.Pledge
+	if r.Method == http.MethodPut {
+		pledge = &store.Pledge{
+			Amount:     req.Amount,
+			Recipient:  req.Recipient,
+			PaymentURL: req.PaymentURL,
+			State:      store.PledgeActive,
+			CreatedAt:  now,
+		}
+	}
+	err := s.updatePledge(ctx, req.BookID, req.UserID, func(book store.Book) (*store.Pledge, error) {
+		if !book.Deadline.After(now) || book.Status == "completed" ||
+			(book.Pledge != nil && book.Pledge.State != store.PledgeActive) {
+			return nil, errPledgeState
+		}
+		return pledge, nil
+	})
+	if writePledgeError(w, r, err) {
+		return
+	}
+
+	if pledge == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	s.logger.Printf("Pledge of %d yen attached to book %s", pledge.Amount, req.BookID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pledge)
+}
+
+// handleSettlePledge は owed の誓約を支払い済みにする
+func (s *Server) handleSettlePledge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req pledgeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid JSON body: %v", err))
+		return
+	}
+	if err := req.Validate(false); err != nil {
+		writeValidationError(w, r, err)
+		return
+	}
+
+	var settled store.Pledge
+	err := s.updatePledge(r.Context(), req.BookID, req.UserID, func(book store.Book) (*store.Pledge, error) {
+		if book.Pledge == nil || book.Pledge.State != store.PledgeOwed {
+			return nil, errPledgeState
+		}
+		now := time.Now()
+		settled = *book.Pledge
+		settled.State = store.PledgeSettled
+		settled.ResolvedAt = &now
+		return &settled, nil
+	})
+	if writePledgeError(w, r, err) {
+		return
+	}
+
+	s.logger.Printf("Pledge on book %s settled", req.BookID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settled)
+}
+
+// updatePledge は userID の本の誓約を update の結果で置き換える。nil なら誓約を外す
+func (s *Server) updatePledge(ctx context.Context, bookID, userID string, update func(store.Book) (*store.Pledge, error)) error {
+	book, err := s.ownedBook(ctx, bookID, userID)
+	if err != nil {
+		return err
+	}
+	pledge, err := update(book)
+	if err != nil {
+		return err
+	}
+
+	book.Pledge = pledge
+	if err := s.bookRepo.Update(ctx, book); err != nil {
+		return fmt.Errorf("error updating pledge: %w", err)
+	}
+	return nil
+}
+
+// writePledgeError は err があればレスポンスを書いて true を返す
+func writePledgeError(w http.ResponseWriter, r *http.Request, err error) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, errPledgeState):
+		writeProblem(w, r, http.StatusConflict, "Pledge cannot be changed in its current state")
+	default:
+		writeBookError(w, r, err, "Failed to update pledge")
+	}
+	return true
+}
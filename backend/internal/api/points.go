@@ -0,0 +1,191 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"tundoku-killer/backend/internal/store"
+)
+
+// ポイント (XP)。読了で加点 (期限より早いほどボーナス、遅れるほど減点) し、煽られるたびに減点する。
+// 増減はすべて pointLedger に記録し、合計は userPoints/{userId} に持つ。残高がマイナスなら煽り文でからかう
+
+const (
+	completionPoints      = 100
+	earlyBonusPerDay      = 2  // 期限の何日前に読み終えたかに掛ける
+	maxEarlyBonus         = 60 // 早読みボーナスの上限
+	latePenaltyPerDay     = 5  // 期限を何日過ぎて読み終えたかに掛ける
+	minCompletionPoints   = 10 // 遅れても読み終えたら最低これだけは与える
+	insultPenaltyPerLevel = 10 // 煽られるたびに、煽りレベルに掛けて減点する
+)
+
+// PointEntry はポイントの増減1件。pointLedger/{entryId} に保存する。
+// entryId は理由と本から決まるので、同じ読了・同じ周期の煽りで二重に増減しない
+type PointEntry struct {
+	EntryID   string    `json:"entryId" firestore:"entryId"`
+	UserID    string    `json:"userId" firestore:"userId"`
+	BookID    string    `json:"bookId" firestore:"bookId"`
+	Points    int       `json:"points" firestore:"points"`
+	Reason    string    `json:"reason" firestore:"reason"` // "completed" か "insulted"
+	CreatedAt time.Time `json:"createdAt" firestore:"createdAt"`
+}
+
+// completionAward は book を completedAt に読み終えたときのポイント
+func completionAward(book store.Book, completedAt time.Time) int {
+	days := int(completedAt.Sub(book.Deadline).Hours() / 24)
+	if completedAt.Before(book.Deadline) {
+		return completionPoints + min(-days*earlyBonusPerDay, maxEarlyBonus)
+	}
+	return max(completionPoints-days*latePenaltyPerDay, minCompletionPoints)
+}
+
+// awardPoints は entry を台帳に記録して合計に加える。同じ entryId が既にあれば何もしない
+func (s *Server) awardPoints(ctx context.Context, entry PointEntry) error {
+	entryRef := s.firestoreClient.Collection("pointLedger").Doc(entry.EntryID)
+	totalRef := s.firestoreClient.Collection("userPoints").Doc(entry.UserID)
+
+	return s.firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		_, err := tx.Get(entryRef)
+		if err == nil {
+			return nil
+		}
+		if status.Code(err) != codes.NotFound {
+			return err
+		}
+		if err := tx.Create(entryRef, entry); err != nil {
+			return err
+		}
+		return tx.Set(totalRef, map[string]interface{}{
+			"userId":    entry.UserID,
+			"total":     firestore.Increment(entry.Points),
+			"updatedAt": entry.CreatedAt,
+		}, firestore.MergeAll)
+	})
+}
+
+// awardCompletion は読了のポイントを記録する
+func (s *Server) awardCompletion(ctx context.Context, book store.Book) {
+	completedAt := time.Now()
+	if book.CompletedAt != nil {
+		completedAt = *book.CompletedAt
+	}
+	entry := PointEntry{
+		EntryID:   "completed_" + book.BookID,
+		UserID:    book.UserID,
+		BookID:    book.BookID,
+		Points:    completionAward(book, completedAt),
+		Reason:    "completed",
+		CreatedAt: completedAt,
+	}
+	if err := s.awardPoints(ctx, entry); err != nil {
+		s.logger.Printf("Error awarding points for book %s: %v", book.BookID, err)
+	}
+}
+
+// penalizeInsult は煽られた分の減点を記録する。book.InsultLevel は今回の煽りを数えた後の値
+func (s *Server) penalizeInsult(ctx context.Context, book store.Book, cycle string, sentAt time.Time) {
+	entry := PointEntry{
+		EntryID:   fmt.Sprintf("insulted_%s_%s", book.BookID, cycle),
+		UserID:    book.UserID,
+		BookID:    book.BookID,
+		Points:    -insultPenaltyPerLevel * book.InsultLevel,
+		Reason:    "insulted",
+		CreatedAt: sentAt,
+	}
+	if err := s.awardPoints(ctx, entry); err != nil {
+		s.logger.Printf("Error penalizing points for book %s: %v", book.BookID, err)
+	}
+}
+
+// userPointTotal は userID のポイントの合計を返す。まだ記録がなければ 0
+func (s *Server) userPointTotal(ctx context.Context, userID string) (int, error) {
+	doc, err := s.firestoreClient.Collection("userPoints").Doc(userID).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	total, err := doc.DataAt("total")
+	if err != nil {
+		return 0, nil
+	}
+	n, _ := total.(int64)
+	return int(n), nil
+}
+
+// pointsJab はポイントの残高がマイナスならからかう一文を返す。そうでなければ空
+func (s *Server) pointsJab(ctx context.Context, userID string) string {
+	total, err := s.userPointTotal(ctx, userID)
+	if err != nil {
+		s.logger.Printf("Error fetching points for user %s: %v", userID, err)
+		return ""
+	}
+	if total >= 0 {
+		return ""
+	}
+	return fmt.Sprintf("ちなみにあなたの読書ポイントは%d点。読書家を名乗るには借金が多すぎますね。", total)
+}
+
+// handlePoints は ?userId= のユーザーのポイントの合計と、直近の増減 (?limit=、既定20件) を返す
+func (s *Server) handlePoints(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	ctx := r.Context()
+	userID := r.URL.Query().Get("userId")
+	if userID == "" {
+		writeProblem(w, r, http.StatusBadRequest, "userId query parameter is required")
+		return
+	}
+	limit := 20
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 100 {
+			writeProblem(w, r, http.StatusBadRequest, "limit must be between 1 and 100")
+			return
+		}
+		limit = n
+	}
+
+	total, err := s.userPointTotal(ctx, userID)
+	if err != nil {
+		writeServerError(w, r, err, "Failed to retrieve points")
+		return
+	}
+	docs, err := s.firestoreClient.Collection("pointLedger").
+		Where("userId", "==", userID).
+		OrderBy("createdAt", firestore.Desc).
+		Limit(limit).
+		Documents(ctx).GetAll()
+	if err != nil {
+		writeServerError(w, r, err, "Failed to retrieve point history")
+		return
+	}
+	entries := make([]PointEntry, 0, len(docs))
+	for _, doc := range docs {
+		var entry PointEntry
+		if err := doc.DataTo(&entry); err != nil {
+			s.logger.Printf("Error parsing point entry %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"userId":  userID,
+		"total":   total,
+		"entries": entries,
+	})
+}
@@ -0,0 +1,159 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"tundoku-killer/backend/internal/cache"
+	"tundoku-killer/backend/internal/isbn"
+)
+
+// 本の価格を ISBN から調べる。openBD で見つからなければ、RAKUTEN_APPLICATION_ID があれば楽天ブックスで、
+// それでも見つからなければ国立国会図書館サーチ (ndl.go) で調べる。
+// 未読の本の合計金額 (Stats.UnreadValue) として、統計と煽り文に使う
+
+// priceLookupTimeout は登録時の価格の問い合わせを待つ時間。超えたら価格なしで登録する
+const priceLookupTimeout = 5 * time.Second
+
+// lookupPrice は code の本の価格 (円) を返す。見つからないか、ISBN として正しくなければ 0
+func (s *Server) lookupPrice(ctx context.Context, code string) (int, error) {
+	code = isbn.Normalize(code)
+	if !isbn.Valid(code) {
+		return 0, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, priceLookupTimeout)
+	defer cancel()
+
+	// 価格はめったに変わらないので、CATALOG_CACHE_TTL の間は調べ直さない
+	var cached int
+	if cache.GetJSON(ctx, s.cache, "price:"+code, &cached) {
+		return cached, nil
+	}
+	price, err := s.lookupPriceUncached(ctx, code)
+	if err == nil && price > 0 {
+		if err := cache.SetJSON(ctx, s.cache, "price:"+code, price, s.cfg.Cache.CatalogTTL); err != nil {
+			s.logger.Printf("Error caching price for %s: %v", code, err)
+		}
+	}
+	return price, err
+}
+
+// lookupPriceUncached は openBD、楽天ブックス、国立国会図書館サーチの順に価格を調べる
+func (s *Server) lookupPriceUncached(ctx context.Context, isbn string) (int, error) {
+	price, err := lookupOpenBDPrice(ctx, isbn)
+	if err != nil {
+		s.logger.Printf("openBD lookup failed for %s: %v", isbn, err)
+	}
+	if price > 0 {
+		return price, nil
+	}
+
+	if appID := s.cfg.RakutenApplicationID; appID != "" {
+		price, err := lookupRakutenPrice(ctx, appID, isbn)
+		if err != nil {
+			s.logger.Printf("Rakuten lookup failed for %s: %v", isbn, err)
+		}
+		if price > 0 {
+			return price, nil
+		}
+	}
+
+	record, _, err := s.lookupNDL(ctx, isbn)
+	return record.Price, err
+}
+
+// lookupOpenBDPrice は openBD の ONIX データから本体価格を取り出す
+func lookupOpenBDPrice(ctx context.Context, isbn string) (int, error) {
+	var results []*struct {
+		Onix struct {
+			ProductSupply struct {
+				SupplyDetail struct {
+					Price []struct {
+						PriceAmount string `json:"PriceAmount"`
+					} `json:"Price"`
+				} `json:"SupplyDetail"`
+			} `json:"ProductSupply"`
+		} `json:"onix"`
+	}
+	if err := getJSON(ctx, "https://api.openbd.jp/v1/get?isbn="+url.QueryEscape(isbn), &results); err != nil {
+		return 0, err
+	}
+	if len(results) == 0 || results[0] == nil {
+		return 0, nil
+	}
+	for _, p := range results[0].Onix.ProductSupply.SupplyDetail.Price {
+		if n, err := strconv.Atoi(p.PriceAmount); err == nil && n > 0 {
+			return n, nil
+		}
+	}
+	return 0, nil
+}
+
+// lookupRakutenPrice は楽天ブックス書籍検索APIで価格 (税込) を調べる
+func lookupRakutenPrice(ctx context.Context, appID, isbn string) (int, error) {
+	var result struct {
+		Items []struct {
+			Item struct {
+				ItemPrice int `json:"itemPrice"`
+			} `json:"Item"`
+		} `json:"Items"`
+	}
+	q := url.Values{"applicationId": {appID}, "isbn": {isbn}, "format": {"json"}}
+	if err := getJSON(ctx, "https://app.rakuten.co.jp/services/api/BooksBook/Search/20170404?"+q.Encode(), &result); err != nil {
+		return 0, err
+	}
+	if len(result.Items) == 0 {
+		return 0, nil
+	}
+	return result.Items[0].Item.ItemPrice, nil
+}
+
+// getJSON は url を GET して JSON を v に読み込む
+func getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := tracedHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d from %s", resp.StatusCode, req.URL.Host)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// formatYen は n を "12,800円" の形式にする
+func formatYen(n int) string {
+	s := strconv.Itoa(n)
+	var b strings.Builder
+	for i, c := range s {
+		if i > 0 && (len(s)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(c)
+	}
+	return b.String() + "円"
+}
+
+// shelfGuilt は未読の本の合計金額を突きつける一文を返す。価格の分かる未読の本がなければ空
+func (s *Server) shelfGuilt(ctx context.Context, userID string) string {
+	stats, err := s.userStats(ctx, userID)
+	if err != nil {
+		s.logger.Printf("Error fetching stats for user %s: %v", userID, err)
+		return ""
+	}
+	if stats.UnreadValue <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("あなたの本棚には%s分の罪悪感が眠っています。", formatYen(stats.UnreadValue))
+}
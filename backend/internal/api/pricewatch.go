@@ -0,0 +1,365 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/time/rate"
+
+	"tundoku-killer/backend/internal/cron"
+	"tundoku-killer/backend/internal/isbn"
+	"tundoku-killer/backend/internal/store"
+)
+
+// ウィッシュリストの本の値下がりの見張り。/v1/books/{id}/price-watch で知らせる価格 (threshold) を決めると、
+// 毎日 /v1/cron/wishlist-prices が楽天ブックス (RAKUTEN_APPLICATION_ID) と Amazon (AMAZON_PA_ACCESS_KEY) で今の価格を調べ、
+// priceHistory/{pointId} に記録する。最安値が threshold 以下になったら LINE で知らせ、さらに下がるまでは知らせ直さない
+
+const wishlistPricesLease = "wishlistPrices"
+
+// maxPriceHistory は GET /v1/books/{id}/price-watch で返す価格の記録の数 (新しいほうから)
+const maxPriceHistory = 365
+
+// priceSourceNames は価格を調べた店の表示名
+var priceSourceNames = map[string]string{"rakuten": "楽天ブックス", "amazon": "Amazon"}
+
+// PricePoint はある日のある店での本の価格
+type PricePoint struct {
+	UserID    string    `json:"-" firestore:"userId"`
+	BookID    string    `json:"-" firestore:"bookId"`
+	Source    string    `json:"source" firestore:"source"` // "rakuten" か "amazon"
+	Price     int       `json:"price" firestore:"price"`   // 円
+	CheckedAt time.Time `json:"checkedAt" firestore:"checkedAt"`
+}
+
+// PriceHistory は値下がりの見張りと、これまでに調べた価格
+type PriceHistory struct {
+	Watch  *store.PriceWatch `json:"watch"`
+	Points []PricePoint      `json:"points"` // 古い順
+}
+
+// priceTrackingEnabled は今の価格を調べる店が1つでも設定されているかを返す
+func (s *Server) priceTrackingEnabled() bool {
+	return s.cfg.RakutenApplicationID != "" || s.cfg.AmazonPA.Enabled()
+}
+
+// handleBookPriceWatch は {id} の本の値下がりの見張りを設定し (PUT)、外し (DELETE ?userId=)、価格の記録を返す (GET ?userId=)
+func (s *Server) handleBookPriceWatch(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.handleGetPriceHistory(w, r)
+	case http.MethodPut:
+		s.handleSetPriceWatch(w, r)
+	case http.MethodDelete:
+		s.handleDeletePriceWatch(w, r)
+	default:
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// handleGetPriceHistory は見張りの設定と価格の記録を返す
+func (s *Server) handleGetPriceHistory(w http.ResponseWriter, r *http.Request) {
+	userID := r.URL.Query().Get("userId")
+	if userID == "" {
+		writeProblem(w, r, http.StatusBadRequest, "userId query parameter is required")
+		return
+	}
+	ctx := r.Context()
+	book, err := s.ownedBook(ctx, r.PathValue("id"), userID)
+	if err != nil {
+		writeBookError(w, r, err, "Failed to retrieve book")
+		return
+	}
+	points, err := s.priceHistory(ctx, book.BookID)
+	if err != nil {
+		writeServerError(w, r, err, "Failed to retrieve price history")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(PriceHistory{Watch: book.PriceWatch, Points: points})
+}
+
+// handleSetPriceWatch は知らせる価格を設定する。前に知らせた価格は忘れ、次に調べたときに threshold 以下ならまた知らせる
+func (s *Server) handleSetPriceWatch(w http.ResponseWriter, r *http.Request) {
+	var req priceWatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid JSON body: %v", err))
+		return
+	}
+	if err := req.Validate(); err != nil {
+		writeValidationError(w, r, err)
+		return
+	}
+	if !s.priceTrackingEnabled() {
+		writeProblem(w, r, http.StatusNotImplemented, "Price tracking is not configured")
+		return
+	}
+	ctx := r.Context()
+	book, err := s.ownedBook(ctx, r.PathValue("id"), req.UserID)
+	if err != nil {
+		writeBookError(w, r, err, "Failed to retrieve book")
+		return
+	}
+	if !book.OnWishlist() {
+		writeProblem(w, r, http.StatusConflict, "Only wishlist books can be price-watched")
+		return
+	}
+	if book.ISBN == "" {
+		writeProblem(w, r, http.StatusConflict, "Book has no ISBN")
+		return
+	}
+
+	watch := store.PriceWatch{Threshold: req.Threshold}
+	if book.PriceWatch != nil {
+		watch = *book.PriceWatch
+		watch.Threshold = req.Threshold
+		watch.AlertedPrice = 0
+	}
+	// ほかの項目を同時に書き換える処理 (cron など) と競合しないよう、見張りの状態だけを書き換える
+	if err := s.bookRepo.Patch(ctx, book.BookID, store.BookPatch{PriceWatch: &watch}); err != nil {
+		writeBookError(w, r, err, "Failed to update book")
+		return
+	}
+	updated := book
+	updated.PriceWatch = &watch
+	eventBus.Publish(ctx, BookUpdated{Book: updated, Previous: book})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
+
+// handleDeletePriceWatch は値下がりの見張りを外す。価格の記録は残す
+func (s *Server) handleDeletePriceWatch(w http.ResponseWriter, r *http.Request) {
+	userID := r.URL.Query().Get("userId")
+	if userID == "" {
+		writeProblem(w, r, http.StatusBadRequest, "userId query parameter is required")
+		return
+	}
+	ctx := r.Context()
+	book, err := s.ownedBook(ctx, r.PathValue("id"), userID)
+	if err != nil {
+		writeBookError(w, r, err, "Failed to retrieve book")
+		return
+	}
+	if book.PriceWatch == nil {
+		writeProblem(w, r, http.StatusNotFound, "Book is not price-watched")
+		return
+	}
+	if err := s.bookRepo.Patch(ctx, book.BookID, store.BookPatch{ClearPriceWatch: true}); err != nil {
+		writeBookError(w, r, err, "Failed to update book")
+		return
+	}
+	updated := book
+	updated.PriceWatch = nil
+	eventBus.Publish(ctx, BookUpdated{Book: updated, Previous: book})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// priceHistory は bookID の本の価格の記録を、新しいほうから maxPriceHistory 件、古い順に返す
+func (s *Server) priceHistory(ctx context.Context, bookID string) ([]PricePoint, error) {
+	docs, err := s.firestoreClient.Collection("priceHistory").Where("bookId", "==", bookID).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("error fetching price history: %w", err)
+	}
+	points := make([]PricePoint, 0, len(docs))
+	for _, doc := range docs {
+		var point PricePoint
+		if err := doc.DataTo(&point); err != nil {
+			s.logger.Printf("Error parsing price point %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		points = append(points, point)
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].CheckedAt.Before(points[j].CheckedAt) })
+	if len(points) > maxPriceHistory {
+		points = points[len(points)-maxPriceHistory:]
+	}
+	return points, nil
+}
+
+// deletePriceHistory は消した本の価格の記録を消す
+func (s *Server) deletePriceHistory(ctx context.Context, bookID string) {
+	docs, err := s.firestoreClient.Collection("priceHistory").Where("bookId", "==", bookID).Documents(ctx).GetAll()
+	if err != nil {
+		s.logger.Printf("Error fetching price history of book %s: %v", bookID, err)
+		return
+	}
+	for _, doc := range docs {
+		if _, err := doc.Ref.Delete(ctx); err != nil {
+			s.logger.Printf("Error deleting price point %s: %v", doc.Ref.ID, err)
+		}
+	}
+}
+
+// currentPrices は code の本の今の価格を、設定されている店ごとに調べる。見つからなかった店は含めない。
+// Amazon は ISBN-10 を ASIN として問い合わせるので、979 で始まる ISBN は調べられない
+func (s *Server) currentPrices(ctx context.Context, code string) map[string]int {
+	prices := make(map[string]int)
+	if appID := s.cfg.RakutenApplicationID; appID != "" {
+		lookupCtx, cancel := context.WithTimeout(ctx, priceLookupTimeout)
+		price, err := lookupRakutenPrice(lookupCtx, appID, code)
+		cancel()
+		if err != nil {
+			s.logger.Printf("Rakuten lookup failed for %s: %v", code, err)
+		} else if price > 0 {
+			prices["rakuten"] = price
+		}
+	}
+	if asin, err := isbn.To10(code); err == nil && s.cfg.AmazonPA.Enabled() {
+		lookupCtx, cancel := context.WithTimeout(ctx, priceLookupTimeout)
+		price, err := lookupAmazonPrice(lookupCtx, s.cfg.AmazonPA, asin)
+		cancel()
+		if err != nil {
+			s.logger.Printf("Amazon lookup failed for %s: %v", asin, err)
+		} else if price > 0 {
+			prices["amazon"] = price
+		}
+	}
+	return prices
+}
+
+// priceDropMessage は値下がりを知らせる文面を返す
+func priceDropMessage(book store.Book, price int, source string) string {
+	return fmt.Sprintf("ウィッシュリストの『%s』が%sで%sになりました。設定した%s以下です。…買うなら、積んでいる本を1冊読み終えてからにしましょう。",
+		book.Title, priceSourceNames[source], formatYen(price), formatYen(book.PriceWatch.Threshold))
+}
+
+// priceDropped は最安値 lowest を知らせるべきかを返す。閾値以下で、まだ知らせていないか前に知らせた価格より安ければ true
+func priceDropped(watch store.PriceWatch, lowest int) bool {
+	return lowest > 0 && lowest <= watch.Threshold && (watch.AlertedPrice == 0 || lowest < watch.AlertedPrice)
+}
+
+// checkBookPrice は book の今の価格を調べて記録し、見張りの状態を更新する。threshold 以下に下がっていれば知らせる。
+// 知らせたら true
+func (s *Server) checkBookPrice(ctx context.Context, book store.Book, now time.Time) (bool, error) {
+	code := isbn.Normalize(book.ISBN)
+	prices := s.currentPrices(ctx, code)
+
+	watch := *book.PriceWatch
+	watch.CheckedAt = &now
+	watch.CheckedCycle = cron.Cycle(now)
+	lowest, source := 0, ""
+	for _, name := range []string{"rakuten", "amazon"} {
+		price, ok := prices[name]
+		if !ok {
+			continue
+		}
+		point := PricePoint{UserID: book.UserID, BookID: book.BookID, Source: name, Price: price, CheckedAt: now}
+		if _, _, err := s.firestoreClient.Collection("priceHistory").Add(ctx, point); err != nil {
+			s.logger.Printf("Error recording price of book %s: %v", book.BookID, err)
+		}
+		if lowest == 0 || price < lowest {
+			lowest, source = price, name
+		}
+	}
+
+	alerted := false
+	if lowest > 0 {
+		watch.LastPrice, watch.LastSource = lowest, source
+		switch {
+		case lowest > watch.Threshold:
+			watch.AlertedPrice = 0
+		case priceDropped(watch, lowest):
+			// 送れなくても調べた価格と周期は記録する (記録しないと次の実行で同じ価格をまた記録して知らせ直す)。
+			// 知らせた価格は変えないので、次の周期に安いままならまた知らせる
+			if err := s.sendLineMessage(ctx, book.UserID, priceDropMessage(book, lowest, source)); err != nil {
+				s.logger.Printf("Error sending price alert for book %s: %v", book.BookID, err)
+				break
+			}
+			watch.AlertedPrice = lowest
+			alerted = true
+		}
+	}
+	if err := s.bookRepo.Patch(ctx, book.BookID, store.BookPatch{PriceWatch: &watch}); err != nil {
+		return alerted, fmt.Errorf("error recording price check: %w", err)
+	}
+	return alerted, nil
+}
+
+// handleWishlistPricesCron は値下がりを見張っているウィッシュリストの本の価格を調べ直す (毎日)。
+// 楽天ブックス・Amazon のレート制限に合わせて1秒に1冊ずつ調べ、時間が足りなければ done を false にして、続きは次の呼び出しで調べる
+func (s *Server) handleWishlistPricesCron(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodGet {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	ctx := context.WithoutCancel(r.Context())
+
+	if !s.authorizeCron(r) {
+		writeProblem(w, r, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	if !s.priceTrackingEnabled() {
+		writeProblem(w, r, http.StatusNotImplemented, "Price tracking is not configured")
+		return
+	}
+
+	runID := uuid.NewString()
+	if err := s.cron.AcquireLease(ctx, wishlistPricesLease, runID, cron.LeaseTTL); err != nil {
+		if errors.Is(err, cron.ErrLeaseHeld) {
+			writeProblem(w, r, http.StatusConflict, "Another price check run is already running")
+			return
+		}
+		writeServerError(w, r, err, "Failed to acquire lock")
+		return
+	}
+	defer s.cron.ReleaseLease(ctx, wishlistPricesLease, runID)
+
+	userIDs, err := s.listUserIDs(ctx)
+	if err != nil {
+		writeServerError(w, r, err, "Failed to list users")
+		return
+	}
+
+	now := time.Now()
+	cycle := cron.Cycle(now)
+	deadline := now.Add(cron.TimeBudget)
+	limiter := rate.NewLimiter(rate.Every(time.Second), 1)
+	checked, alerted, failed := 0, 0, 0
+	done := true
+users:
+	for _, userID := range userIDs {
+		books, err := s.listBooks(ctx, userID)
+		if err != nil {
+			s.logger.Printf("Error fetching books for %s: %v", userID, err)
+			failed++
+			continue
+		}
+		for _, book := range books {
+			if !book.OnWishlist() || book.ISBN == "" || book.PriceWatch == nil || book.PriceWatch.CheckedCycle == cycle {
+				continue
+			}
+			if time.Now().After(deadline) {
+				done = false
+				break users
+			}
+			limiter.Wait(ctx)
+			sent, err := s.checkBookPrice(ctx, book, now)
+			if err != nil {
+				s.logger.Printf("Error checking price of book %s: %v", book.BookID, err)
+				failed++
+				continue
+			}
+			checked++
+			if sent {
+				alerted++
+			}
+		}
+	}
+
+	s.logger.Printf("Wishlist prices %s: %d checked, %d alerted, %d failed", cycle, checked, alerted, failed)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"checked": checked,
+		"alerted": alerted,
+		"failed":  failed,
+		"done":    done,
+	})
+}
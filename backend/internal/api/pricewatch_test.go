@@ -0,0 +1,40 @@
+package api
+
+import (
+	"testing"
+
+	"tundoku-killer/backend/internal/store"
+)
+
+func TestPriceDropped(t *testing.T) {
+	tests := []struct {
+		name    string
+		alerted int
+		lowest  int
+		want    bool
+	}{
+		{"閾値を上回る", 0, 1200, false},
+		{"閾値ちょうどで初めて", 0, 1000, true},
+		{"閾値以下で初めて", 0, 900, true},
+		{"知らせた価格と同じ", 900, 900, false},
+		{"知らせた価格より高い", 900, 950, false},
+		{"知らせた価格よりさらに安い", 900, 800, true},
+		{"価格が分からない", 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			watch := store.PriceWatch{Threshold: 1000, AlertedPrice: tt.alerted}
+			if got := priceDropped(watch, tt.lowest); got != tt.want {
+				t.Errorf("priceDropped(alerted %d, lowest %d) = %v; want %v", tt.alerted, tt.lowest, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPriceDropMessage(t *testing.T) {
+	book := store.Book{Title: "三体", PriceWatch: &store.PriceWatch{Threshold: 1500}}
+	want := "ウィッシュリストの『三体』が楽天ブックスで1,200円になりました。設定した1,500円以下です。…買うなら、積んでいる本を1冊読み終えてからにしましょう。"
+	if got := priceDropMessage(book, 1200, "rakuten"); got != want {
+		t.Errorf("priceDropMessage = %q; want %q", got, want)
+	}
+}
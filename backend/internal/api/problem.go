@@ -0,0 +1,125 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+
+	"tundoku-killer/backend/internal/store"
+	"tundoku-killer/backend/internal/validation"
+)
+
+// problemTypeValidation は入力チェックで弾かれたときの problem type
+const problemTypeValidation = "urn:tundoku-killer:problem:validation-error"
+
+// Problem は RFC 7807 の application/problem+json レスポンス
+type Problem struct {
+	Type          string            `json:"type"`
+	Title         string            `json:"title"`
+	Status        int               `json:"status"`
+	Detail        string            `json:"detail,omitempty"`
+	Instance      string            `json:"instance,omitempty"`
+	CorrelationID string            `json:"correlationId,omitempty"`
+	Errors        validation.Errors `json:"errors,omitempty"` // 入力チェックのフィールド単位のエラー
+}
+
+// writeProblem は status と detail から problem+json を返す。
+// detail はクライアントにそのまま見えるので、内部のエラー文字列を入れないこと
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	writeProblemJSON(w, r, Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	})
+}
+
+// writeServerError は内部エラーをログに残し、クライアントには detail と相関IDだけを返す
+func writeServerError(w http.ResponseWriter, r *http.Request, err error, detail string) {
+	log.Printf("[%s] %s %s: %s: %v", correlationID(r.Context()), r.Method, r.URL.Path, detail, err)
+	writeProblem(w, r, http.StatusInternalServerError, detail)
+}
+
+// writeBookError は本の操作 (books.go) のエラーを対応するステータスで返す
+func writeBookError(w http.ResponseWriter, r *http.Request, err error, detail string) {
+	var fieldErrs validation.Errors
+	var capErr *UnreadCapError
+	switch {
+	case errors.As(err, &fieldErrs):
+		writeValidationError(w, r, err)
+	case errors.As(err, &capErr):
+		writeUnreadCap(w, r, capErr)
+	case errors.Is(err, store.ErrBookNotFound):
+		writeProblem(w, r, http.StatusNotFound, "Book not found")
+	case errors.Is(err, errNotBookOwner):
+		writeProblem(w, r, http.StatusUnauthorized, "Unauthorized")
+	case errors.Is(err, errQuizRequired):
+		writeProblem(w, r, http.StatusForbidden, "Hard mode is on: answer the quiz (/v1/books/quiz) to complete this book")
+	case errors.Is(err, store.ErrDeadlineChanged):
+		writeProblem(w, r, http.StatusConflict, "The deadline was changed in the meantime; negotiate again")
+	case errors.Is(err, errDependencyCycle):
+		writeProblem(w, r, http.StatusConflict, "These books already depend on this one; dependencies cannot form a cycle")
+	default:
+		writeServerError(w, r, err, detail)
+	}
+}
+
+// writeValidationError は検証エラーをフィールド単位の詳細付きで 400 として返す
+func writeValidationError(w http.ResponseWriter, r *http.Request, err error) {
+	var fieldErrs validation.Errors
+	if !errors.As(err, &fieldErrs) {
+		writeProblem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	writeProblemJSON(w, r, Problem{
+		Type:   problemTypeValidation,
+		Title:  "Validation failed",
+		Status: http.StatusBadRequest,
+		Detail: "One or more fields are invalid.",
+		Errors: fieldErrs,
+	})
+}
+
+// writeRequestValidationError は OpenAPI 定義に合わないリクエストを 400 として返す
+func writeRequestValidationError(w http.ResponseWriter, r *http.Request, err error) {
+	writeProblemJSON(w, r, Problem{
+		Type:   problemTypeValidation,
+		Title:  "Validation failed",
+		Status: http.StatusBadRequest,
+		Detail: err.Error(),
+	})
+}
+
+func writeProblemJSON(w http.ResponseWriter, r *http.Request, p Problem) {
+	p.Instance = r.URL.Path
+	p.CorrelationID = correlationID(r.Context())
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+type correlationIDKey struct{}
+
+// correlationID はリクエストに割り当てた相関IDを返す
+func correlationID(ctx context.Context) string {
+	id, _ := ctx.Value(correlationIDKey{}).(string)
+	return id
+}
+
+// requestIDMiddleware は X-Request-ID (なければ新規発行) を相関IDとしてコンテキストとレスポンスヘッダーに載せる
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if id == "" || len(id) > 128 {
+			id = uuid.NewString()
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), correlationIDKey{}, id)))
+	})
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"tundoku-killer/backend/internal/line"
+	"tundoku-killer/backend/internal/store"
+)
+
+// ユーザーのプロフィール (表示名とアイコン)。初めてログインしたときに LINE (または Google) のプロフィールから作り、
+// users/{uid} に保存する。友達・読書会・恥の壁で「誰が煽られているか」を見せるのに使う
+
+// ensureLineProfile は userID のプロフィールがまだなければ、LINE のプロフィールから作る。
+// 既にあれば本人が編集しているかもしれないので上書きしない
+func (s *Server) ensureLineProfile(ctx context.Context, userID, accessToken string) {
+	if _, err := s.userRepo.GetProfile(ctx, userID); !errors.Is(err, store.ErrProfileNotFound) {
+		if err != nil {
+			s.logger.Printf("Error fetching profile for %s: %v", userID, err)
+		}
+		return
+	}
+
+	lineProfile, err := line.FetchProfile(ctx, tracedHTTPClient, accessToken)
+	if err != nil {
+		s.logger.Printf("Error fetching LINE profile for %s: %v", userID, err)
+		return
+	}
+	now := time.Now()
+	profile := store.UserProfile{
+		UserID:      userID,
+		DisplayName: lineProfile.DisplayName,
+		PictureURL:  lineProfile.PictureURL,
+		Provider:    "line",
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if err := s.userRepo.CreateProfile(ctx, profile); err != nil {
+		s.logger.Printf("Error creating profile for %s: %v", userID, err)
+		return
+	}
+	s.logger.Printf("Profile created for %s from LINE", userID)
+}
+
+// getProfile は userID のプロフィールを返す。まだなければ UserID だけのゼロ値
+func (s *Server) getProfile(ctx context.Context, userID string) (store.UserProfile, error) {
+	profile, err := s.userRepo.GetProfile(ctx, userID)
+	if errors.Is(err, store.ErrProfileNotFound) {
+		return store.UserProfile{UserID: userID}, nil
+	}
+	return profile, err
+}
+
+// handleProfile はプロフィールの取得 (GET ?userId=) と、表示名・アイコンの更新 (PUT) を行う
+func (s *Server) handleProfile(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	switch r.Method {
+	case http.MethodGet:
+		userID := r.URL.Query().Get("userId")
+		if userID == "" {
+			writeProblem(w, r, http.StatusBadRequest, "userId query parameter is required")
+			return
+		}
+		profile, err := s.getProfile(ctx, userID)
+		if err != nil {
+			writeServerError(w, r, err, "Failed to retrieve profile")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(profile)
+
+	case http.MethodPut:
+		var req updateProfileRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid JSON body: %v", err))
+			return
+		}
+		if err := req.Validate(); err != nil {
+			writeValidationError(w, r, err)
+			return
+		}
+
+		profile, err := s.getProfile(ctx, req.UserID)
+		if err != nil {
+			writeServerError(w, r, err, "Failed to retrieve profile")
+			return
+		}
+		now := time.Now()
+		if profile.CreatedAt.IsZero() {
+			profile.CreatedAt = now
+		}
+		profile.DisplayName = req.DisplayName
+		profile.PictureURL = req.PictureURL
+		profile.UpdatedAt = now
+		if err := s.userRepo.SaveProfile(ctx, profile); err != nil {
+			writeServerError(w, r, err, "Failed to save profile")
+			return
+		}
+		s.logger.Printf("Profile updated for %s", req.UserID)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(profile)
+
+	default:
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
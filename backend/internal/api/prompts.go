@@ -0,0 +1,169 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"tundoku-killer/backend/internal/insult"
+)
+
+// 生成AIへの指示 (人物像・出力の決まり) は prompts/{name} に保存し、運用者が /v1/admin/prompts から書き換える。
+// 各インスタンスは promptsTTL の間だけキャッシュするので、デプロイし直さなくても数分で反映される
+
+// promptsTTL はプロンプトをプロセス内に置く時間
+const promptsTTL = 5 * time.Minute
+
+// Prompt は prompts/{name} に保存するプロンプト
+type Prompt struct {
+	Text      string    `json:"text" firestore:"text"`
+	UpdatedAt time.Time `json:"updatedAt" firestore:"updatedAt"`
+}
+
+// PromptView は管理画面に返すプロンプト1つ分
+type PromptView struct {
+	Name       string     `json:"name"`
+	Text       string     `json:"text"` // いま使っている本文
+	Default    string     `json:"default"`
+	Overridden bool       `json:"overridden"` // 保存したものを使っている (false なら既定の本文)
+	UpdatedAt  *time.Time `json:"updatedAt,omitempty"`
+}
+
+// promptStore は insult.PromptSource。prompts を丸ごと読んで promptsTTL の間キャッシュする
+type promptStore struct {
+	client *firestore.Client
+
+	mu      sync.Mutex
+	prompts map[string]Prompt
+	expires time.Time
+}
+
+func (s *promptStore) Prompts(ctx context.Context) (map[string]string, error) {
+	saved, err := s.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	texts := make(map[string]string, len(saved))
+	for name, p := range saved {
+		texts[name] = p.Text
+	}
+	return texts, nil
+}
+
+// load は保存されたプロンプトを返す。キャッシュが切れていれば読み直す
+func (s *promptStore) load(ctx context.Context) (map[string]Prompt, error) {
+	now := time.Now()
+	s.mu.Lock()
+	prompts, expires := s.prompts, s.expires
+	s.mu.Unlock()
+	if now.Before(expires) {
+		return prompts, nil
+	}
+
+	docs, err := s.client.Collection("prompts").Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	prompts = make(map[string]Prompt, len(docs))
+	for _, doc := range docs {
+		var p Prompt
+		if err := doc.DataTo(&p); err != nil {
+			return nil, fmt.Errorf("error parsing prompt %s: %w", doc.Ref.ID, err)
+		}
+		prompts[doc.Ref.ID] = p
+	}
+	s.mu.Lock()
+	s.prompts, s.expires = prompts, now.Add(promptsTTL)
+	s.mu.Unlock()
+	return prompts, nil
+}
+
+// invalidate はキャッシュを捨てる。書き換えたインスタンスではすぐに反映させる
+func (s *promptStore) invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expires = time.Time{}
+}
+
+// handlePrompts はプロンプトの一覧を、既定の本文と並べて返す
+func (s *Server) handlePrompts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	s.prompts.invalidate()
+	saved, err := s.prompts.load(r.Context())
+	if err != nil {
+		writeServerError(w, r, err, "Failed to load prompts")
+		return
+	}
+	views := make([]PromptView, 0, len(insult.PromptNames))
+	for _, name := range insult.PromptNames {
+		view := PromptView{Name: name, Text: insult.DefaultPrompts[name], Default: insult.DefaultPrompts[name]}
+		if p, ok := saved[name]; ok {
+			view.Text, view.Overridden = p.Text, true
+			updatedAt := p.UpdatedAt
+			view.UpdatedAt = &updatedAt
+		}
+		views = append(views, view)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"prompts": views})
+}
+
+// handlePrompt は {name} のプロンプトを書き換える (PUT)。DELETE なら既定の本文に戻す
+func (s *Server) handlePrompt(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !slices.Contains(insult.PromptNames, name) {
+		writeProblem(w, r, http.StatusNotFound, "Prompt not found")
+		return
+	}
+	ref := s.firestoreClient.Collection("prompts").Doc(name)
+
+	switch r.Method {
+	case http.MethodPut:
+		var req promptRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid JSON body: %v", err))
+			return
+		}
+		if err := req.Validate(); err != nil {
+			writeValidationError(w, r, err)
+			return
+		}
+		prompt := Prompt{Text: req.Text, UpdatedAt: time.Now()}
+		if _, err := ref.Set(r.Context(), prompt); err != nil {
+			writeServerError(w, r, err, "Failed to save prompt")
+			return
+		}
+		s.prompts.invalidate()
+		s.logger.Printf("Prompt %s updated", name)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(PromptView{
+			Name:       name,
+			Text:       prompt.Text,
+			Default:    insult.DefaultPrompts[name],
+			Overridden: true,
+			UpdatedAt:  &prompt.UpdatedAt,
+		})
+	case http.MethodDelete:
+		if _, err := ref.Delete(r.Context()); err != nil && status.Code(err) != codes.NotFound {
+			writeServerError(w, r, err, "Failed to reset prompt")
+			return
+		}
+		s.prompts.invalidate()
+		s.logger.Printf("Prompt %s reset to default", name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
@@ -0,0 +1,428 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/storage"
+	"github.com/google/uuid"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"tundoku-killer/backend/internal/cron"
+	"tundoku-killer/backend/internal/store"
+)
+
+// 読了の証拠写真 (最後のページ・メモ・今日の新聞と並べた本など)。見張り役や読書会の仲間に見せて、読了の申告に説得力を持たせる。
+// 写真はクライアントが署名付きURLで PROOF_BUCKET に直接 PUT し、そのあと /v1/books/proofs で本に結びつける。
+// 記録は completionProofs/{proofId} に保存する。結びつけられなかった写真は、バケットのライフサイクルのルールで消す想定
+
+const (
+	proofUploadURLTTL = 15 * time.Minute // アップロード用の署名付きURLの有効期限
+	proofViewURLTTL   = time.Hour        // 閲覧用の署名付きURLの有効期限
+	maxProofSize      = 10 << 20         // 1枚あたりの上限 (バイト)
+	maxProofsPerBook  = 5
+	maxCaptionLength  = 200
+)
+
+// 証拠写真の状態
+const (
+	proofPending  = "pending"  // アップロード用のURLを発行した
+	proofAttached = "attached" // 写真を確かめて本に結びつけた
+)
+
+// proofContentTypes は受け付ける写真の形式と拡張子
+var proofContentTypes = map[string]string{
+	"image/jpeg": "jpg",
+	"image/png":  "png",
+	"image/webp": "webp",
+	"image/heic": "heic",
+}
+
+var (
+	errProofsDisabled  = errors.New("photo proofs are not configured")
+	errProofNotFound   = errors.New("proof not found")
+	errProofNotVisible = errors.New("proof is not visible to this user")
+)
+
+// CompletionProof は証拠写真1枚の記録
+type CompletionProof struct {
+	ProofID     string     `json:"proofId" firestore:"proofId"`
+	UserID      string     `json:"userId" firestore:"userId"`
+	BookID      string     `json:"bookId" firestore:"bookId"`
+	Object      string     `json:"-" firestore:"object"` // バケット内のオブジェクト名
+	ContentType string     `json:"contentType" firestore:"contentType"`
+	Caption     string     `json:"caption,omitempty" firestore:"caption,omitempty"`
+	State       string     `json:"state" firestore:"state"`
+	CreatedAt   time.Time  `json:"createdAt" firestore:"createdAt"`
+	AttachedAt  *time.Time `json:"attachedAt,omitempty" firestore:"attachedAt,omitempty"`
+
+	// URL は閲覧用の署名付きURL。保存はしない
+	URL string `json:"url,omitempty" firestore:"-"`
+}
+
+// initProofs は PROOF_BUCKET が設定されていれば Cloud Storage のクライアントを初期化する
+func (s *Server) initProofs(ctx context.Context) error {
+	if s.cfg.ProofBucket == "" {
+		s.logger.Printf("PROOF_BUCKET not set; photo proofs are disabled")
+		return nil
+	}
+	bucket, prefix, _ := strings.Cut(strings.TrimPrefix(s.cfg.ProofBucket, "gs://"), "/")
+	client, err := storage.NewClient(ctx, option.WithCredentialsJSON([]byte(s.cfg.Firebase.ServiceAccountKeyJSON)))
+	if err != nil {
+		return fmt.Errorf("error creating Cloud Storage client: %w", err)
+	}
+	s.storageClient = client
+	s.proofBucket = client.Bucket(bucket)
+	s.proofPrefix = prefix
+	return nil
+}
+
+// proofObject は証拠写真のオブジェクト名 ({prefix/}proofs/{userId}/{bookId}/{proofId}.{ext})
+func (s *Server) proofObject(proof CompletionProof) string {
+	name := fmt.Sprintf("proofs/%s/%s/%s.%s", proof.UserID, proof.BookID, proof.ProofID, proofContentTypes[proof.ContentType])
+	if s.proofPrefix != "" {
+		name = s.proofPrefix + "/" + name
+	}
+	return name
+}
+
+// handleProofUploadURL は証拠写真をアップロードする署名付きURLを発行する
+func (s *Server) handleProofUploadURL(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req proofUploadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid JSON body: %v", err))
+		return
+	}
+	if err := req.Validate(); err != nil {
+		writeValidationError(w, r, err)
+		return
+	}
+	if s.proofBucket == nil {
+		writeProblem(w, r, http.StatusNotImplemented, "Photo proofs are not configured")
+		return
+	}
+	ctx := r.Context()
+	if _, err := s.ownedBook(ctx, req.BookID, req.UserID); err != nil {
+		writeBookError(w, r, err, "Failed to retrieve book")
+		return
+	}
+
+	proof := CompletionProof{
+		ProofID:     uuid.NewString(),
+		UserID:      req.UserID,
+		BookID:      req.BookID,
+		ContentType: req.ContentType,
+		State:       proofPending,
+		CreatedAt:   time.Now(),
+	}
+	proof.Object = s.proofObject(proof)
+	// 大きすぎる写真は Cloud Storage 側で断らせる (クライアントも同じヘッダーを付けて PUT する)
+	headers := map[string]string{
+		"Content-Type":                proof.ContentType,
+		"x-goog-content-length-range": fmt.Sprintf("0,%d", maxProofSize),
+	}
+	expiresAt := proof.CreatedAt.Add(proofUploadURLTTL)
+	uploadURL, err := s.proofBucket.SignedURL(proof.Object, &storage.SignedURLOptions{
+		Scheme:      storage.SigningSchemeV4,
+		Method:      http.MethodPut,
+		ContentType: proof.ContentType,
+		Headers:     []string{"x-goog-content-length-range:" + headers["x-goog-content-length-range"]},
+		Expires:     expiresAt,
+	})
+	if err != nil {
+		writeServerError(w, r, err, "Failed to sign upload URL")
+		return
+	}
+	if _, err := s.firestoreClient.Collection("completionProofs").Doc(proof.ProofID).Set(ctx, proof); err != nil {
+		writeServerError(w, r, err, "Failed to save proof")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"proofId":   proof.ProofID,
+		"uploadUrl": uploadURL,
+		"method":    http.MethodPut,
+		"headers":   headers,
+		"expiresAt": expiresAt,
+	})
+}
+
+// handleProofs は本の証拠写真の一覧 (GET ?bookId=&viewerId=) と、アップロードした写真の結びつけ (POST) を行う
+func (s *Server) handleProofs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.handleListProofs(w, r)
+	case http.MethodPost:
+		s.handleAttachProof(w, r)
+	default:
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// handleAttachProof はアップロードされた写真を確かめて本に結びつけ、見張り役と読書会に知らせる。読了した本にしか付けられない
+func (s *Server) handleAttachProof(w http.ResponseWriter, r *http.Request) {
+	var req attachProofRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid JSON body: %v", err))
+		return
+	}
+	if err := req.Validate(); err != nil {
+		writeValidationError(w, r, err)
+		return
+	}
+	if s.proofBucket == nil {
+		writeProblem(w, r, http.StatusNotImplemented, "Photo proofs are not configured")
+		return
+	}
+	ctx := r.Context()
+	book, err := s.ownedBook(ctx, req.BookID, req.UserID)
+	if err != nil {
+		writeBookError(w, r, err, "Failed to retrieve book")
+		return
+	}
+	if book.Status != "completed" {
+		writeProblem(w, r, http.StatusConflict, "Proofs can only be attached to completed books")
+		return
+	}
+
+	ref := s.firestoreClient.Collection("completionProofs").Doc(req.ProofID)
+	doc, err := ref.Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		writeProblem(w, r, http.StatusNotFound, "Proof not found")
+		return
+	}
+	if err != nil {
+		writeServerError(w, r, err, "Failed to retrieve proof")
+		return
+	}
+	var proof CompletionProof
+	if err := doc.DataTo(&proof); err != nil {
+		writeServerError(w, r, err, "Failed to parse proof")
+		return
+	}
+	if proof.UserID != req.UserID || proof.BookID != req.BookID {
+		writeProblem(w, r, http.StatusNotFound, "Proof not found")
+		return
+	}
+	if proof.State == proofAttached {
+		writeProblem(w, r, http.StatusConflict, "Proof is already attached")
+		return
+	}
+
+	attached, err := s.firestoreClient.Collection("completionProofs").
+		Where("bookId", "==", req.BookID).Where("state", "==", proofAttached).
+		Documents(ctx).GetAll()
+	if err != nil {
+		writeServerError(w, r, err, "Failed to count proofs")
+		return
+	}
+	if len(attached) >= maxProofsPerBook {
+		writeProblem(w, r, http.StatusConflict, fmt.Sprintf("A book can have at most %d proofs", maxProofsPerBook))
+		return
+	}
+
+	// 署名付きURLの条件をすり抜けたものに備えて、実際に置かれた写真を確かめる
+	attrs, err := s.proofBucket.Object(proof.Object).Attrs(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		writeProblem(w, r, http.StatusConflict, "The photo has not been uploaded yet")
+		return
+	}
+	if err != nil {
+		writeServerError(w, r, err, "Failed to check uploaded photo")
+		return
+	}
+	if attrs.Size > maxProofSize || attrs.ContentType != proof.ContentType {
+		if err := s.proofBucket.Object(proof.Object).Delete(ctx); err != nil {
+			s.logger.Printf("Error deleting rejected proof %s: %v", proof.ProofID, err)
+		}
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("The photo must be a %s of at most %d MB", proof.ContentType, maxProofSize>>20))
+		return
+	}
+
+	now := time.Now()
+	proof.State, proof.AttachedAt, proof.Caption = proofAttached, &now, req.Caption
+	if _, err := ref.Set(ctx, proof); err != nil {
+		writeServerError(w, r, err, "Failed to save proof")
+		return
+	}
+	s.logger.Printf("Proof %s attached to book %s", proof.ProofID, proof.BookID)
+	eventBus.Publish(ctx, ProofAttached{Proof: proof, Book: book})
+
+	if proof.URL, err = s.proofViewURL(proof); err != nil {
+		s.logger.Printf("Error signing view URL for proof %s: %v", proof.ProofID, err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(proof)
+}
+
+// handleListProofs は本に結びつけた証拠写真を、閲覧用の署名付きURL付きで古い順に返す。
+// 見られるのは本人・本人の見張り役・本が配られた読書会のメンバー
+func (s *Server) handleListProofs(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	bookID, viewerID := q.Get("bookId"), q.Get("viewerId")
+	if bookID == "" || viewerID == "" {
+		writeProblem(w, r, http.StatusBadRequest, "bookId and viewerId query parameters are required")
+		return
+	}
+	if s.proofBucket == nil {
+		writeProblem(w, r, http.StatusNotImplemented, "Photo proofs are not configured")
+		return
+	}
+	ctx := r.Context()
+	book, err := s.bookRepo.Get(ctx, bookID)
+	if err != nil {
+		writeBookError(w, r, err, "Failed to retrieve book")
+		return
+	}
+	if err := s.checkProofViewer(ctx, book, viewerID); err != nil {
+		if errors.Is(err, errProofNotVisible) {
+			writeProblem(w, r, http.StatusForbidden, "These proofs are not shared with you")
+			return
+		}
+		writeServerError(w, r, err, "Failed to check access")
+		return
+	}
+
+	proofs := []CompletionProof{}
+	iter := s.firestoreClient.Collection("completionProofs").
+		Where("bookId", "==", bookID).Where("state", "==", proofAttached).
+		OrderBy("attachedAt", firestore.Asc).
+		Documents(ctx)
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			writeServerError(w, r, err, "Failed to retrieve proofs")
+			return
+		}
+		var proof CompletionProof
+		if err := doc.DataTo(&proof); err != nil {
+			s.logger.Printf("Error parsing proof %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		if proof.URL, err = s.proofViewURL(proof); err != nil {
+			writeServerError(w, r, err, "Failed to sign proof URL")
+			return
+		}
+		proofs = append(proofs, proof)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(proofs)
+}
+
+// proofViewURL は証拠写真を proofViewURLTTL の間だけ見られる署名付きURLを返す
+func (s *Server) proofViewURL(proof CompletionProof) (string, error) {
+	return s.proofBucket.SignedURL(proof.Object, &storage.SignedURLOptions{
+		Scheme:  storage.SigningSchemeV4,
+		Method:  http.MethodGet,
+		Expires: time.Now().Add(proofViewURLTTL),
+	})
+}
+
+// checkProofViewer は viewerID が book の証拠写真を見てよいかを確かめる。見られなければ errProofNotVisible
+func (s *Server) checkProofViewer(ctx context.Context, book store.Book, viewerID string) error {
+	if viewerID == book.UserID {
+		return nil
+	}
+	partners, err := s.partnerIDs(ctx, book.UserID)
+	if err != nil {
+		return err
+	}
+	if slices.Contains(partners, viewerID) {
+		return nil
+	}
+	if book.GroupID != "" {
+		doc, err := s.firestoreClient.Collection("groups").Doc(book.GroupID).Get(ctx)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return fmt.Errorf("error fetching group: %w", err)
+		}
+		if err == nil {
+			var group Group
+			if err := doc.DataTo(&group); err != nil {
+				return fmt.Errorf("error parsing group: %w", err)
+			}
+			if slices.Contains(group.Members, viewerID) {
+				return nil
+			}
+		}
+	}
+	return errProofNotVisible
+}
+
+// announceProof は証拠写真が付いたことを、本人の見張り役と、本が配られた読書会に知らせる
+func (s *Server) announceProof(ctx context.Context, e ProofAttached) {
+	settings, err := s.getSettings(ctx, e.Book.UserID)
+	if err != nil {
+		s.logger.Printf("Error fetching settings for %s: %v", e.Book.UserID, err)
+	}
+	partners, err := s.partnerIDs(ctx, e.Book.UserID)
+	if err != nil {
+		s.logger.Printf("Error fetching partners of %s: %v", e.Book.UserID, err)
+	}
+	message := fmt.Sprintf("ご友人の%sさんが『%s』の読了の証拠写真を提出しました (%s)。本当に読んだのか、アプリで確かめてあげてください。",
+		settings.Name(), e.Book.Title, e.Proof.AttachedAt.In(cron.Location).Format("1月2日"))
+	for _, partnerID := range partners {
+		if err := s.sendLineMessage(ctx, partnerID, message); err != nil {
+			s.logger.Printf("Error notifying partner %s of proof %s: %v", partnerID, e.Proof.ProofID, err)
+		}
+	}
+
+	if e.Book.GroupID == "" {
+		return
+	}
+	doc, err := s.firestoreClient.Collection("groups").Doc(e.Book.GroupID).Get(ctx)
+	if err != nil {
+		if status.Code(err) != codes.NotFound {
+			s.logger.Printf("Error fetching group %s: %v", e.Book.GroupID, err)
+		}
+		return
+	}
+	var group Group
+	if err := doc.DataTo(&group); err != nil {
+		s.logger.Printf("Error parsing group %s: %v", e.Book.GroupID, err)
+		return
+	}
+	message = fmt.Sprintf("読書会「%s」: %sさんが『%s』の読了の証拠写真を提出しました。", group.Name, settings.Name(), e.Book.Title)
+	if err := s.postToGroup(ctx, group, message); err != nil {
+		s.logger.Printf("Error posting proof %s to group %s: %v", e.Proof.ProofID, group.GroupID, err)
+	}
+}
+
+// deleteProofs は本の証拠写真をバケットと記録から消す
+func (s *Server) deleteProofs(ctx context.Context, bookID string) {
+	docs, err := s.firestoreClient.Collection("completionProofs").Where("bookId", "==", bookID).Documents(ctx).GetAll()
+	if err != nil {
+		s.logger.Printf("Error fetching proofs of book %s: %v", bookID, err)
+		return
+	}
+	for _, doc := range docs {
+		var proof CompletionProof
+		if err := doc.DataTo(&proof); err == nil && s.proofBucket != nil {
+			if err := s.proofBucket.Object(proof.Object).Delete(ctx); err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+				s.logger.Printf("Error deleting photo of proof %s: %v", doc.Ref.ID, err)
+			}
+		}
+		if _, err := doc.Ref.Delete(ctx); err != nil {
+			s.logger.Printf("Error deleting proof %s: %v", doc.Ref.ID, err)
+		}
+	}
+}
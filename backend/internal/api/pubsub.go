@@ -0,0 +1,290 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/api/idtoken"
+	"google.golang.org/api/option"
+	pubsub "google.golang.org/api/pubsub/v1"
+
+	"tundoku-killer/backend/internal/cron"
+	"tundoku-killer/backend/internal/store"
+)
+
+// BookOverdueEvent は期限切れの本を検知したときに Pub/Sub へ発行するイベント
+type BookOverdueEvent struct {
+	BookID      string    `json:"bookId"`
+	UserID      string    `json:"userId"`
+	InsultLevel int       `json:"insultLevel"`
+	Deadline    time.Time `json:"deadline"`
+	Cycle       string    `json:"cycle"`           // 発行したcronの周期 (cron.Cycle)
+	RunID       string    `json:"runId,omitempty"` // 発行したcronの実行ID
+}
+
+// pushEnvelope は Pub/Sub の push サブスクリプションが送ってくるリクエストボディ
+type pushEnvelope struct {
+	Message struct {
+		Data       string            `json:"data"`
+		Attributes map[string]string `json:"attributes"`
+		MessageID  string            `json:"messageId"`
+	} `json:"message"`
+	Subscription string `json:"subscription"`
+}
+
+// initPubSub は PUBSUB_TOPIC が設定されていれば Pub/Sub クライアントを初期化する
+func (s *Server) initPubSub(ctx context.Context) error {
+	s.pubsubTopic = s.cfg.PubSub.Topic
+	if s.pubsubTopic == "" {
+		s.logger.Printf("PUBSUB_TOPIC not set; overdue books will be processed synchronously")
+		return nil
+	}
+
+	svc, err := pubsub.NewService(ctx, option.WithCredentialsJSON([]byte(s.cfg.Firebase.ServiceAccountKeyJSON)))
+	if err != nil {
+		return fmt.Errorf("error creating Pub/Sub client: %w", err)
+	}
+	s.pubsubService = svc
+	return nil
+}
+
+// dispatchOverdueBook は期限切れの本を Pub/Sub に発行する。
+// Pub/Sub 未設定時はその場で処理し、ステータス更新は batch に積む
+func (s *Server) dispatchOverdueBook(ctx context.Context, book store.Book, cycle string, batch *cron.StatusBatch) error {
+	if s.pubsubService == nil {
+		return s.processOverdueBook(ctx, book.BookID, cycle, batch)
+	}
+
+	data, err := json.Marshal(BookOverdueEvent{
+		BookID:      book.BookID,
+		UserID:      book.UserID,
+		InsultLevel: book.InsultLevel,
+		Deadline:    book.Deadline,
+		Cycle:       cycle,
+		RunID:       cronRunFrom(ctx),
+	})
+	if err != nil {
+		return err
+	}
+
+	// コンシューマ側でトレースを繋げられるよう、トレースコンテキストを属性に載せる
+	attributes := map[string]string{"type": "book.overdue"}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.MapCarrier(attributes))
+
+	_, err = s.pubsubService.Projects.Topics.Publish(s.pubsubTopic, &pubsub.PublishRequest{
+		Messages: []*pubsub.PubsubMessage{{
+			Data:       base64.StdEncoding.EncodeToString(data),
+			Attributes: attributes,
+		}},
+	}).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("error publishing overdue event: %w", err)
+	}
+	return nil
+}
+
+// handleOverduePush は Pub/Sub の push サブスクリプションから呼ばれ、煽り文の生成と送信を行う。
+// 2xx 以外を返すと Pub/Sub が再配信するため、リトライしても無意味なエラーは 2xx で握りつぶす
+func (s *Server) handleOverduePush(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	ctx := r.Context()
+
+	if err := s.verifyPushRequest(ctx, r); err != nil {
+		s.logger.Printf("Rejected Pub/Sub push: %v", err)
+		writeProblem(w, r, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var envelope pushEnvelope
+	if err := json.NewDecoder(r.Body).Decode(&envelope); err != nil {
+		s.logger.Printf("Error decoding Pub/Sub envelope: %v", err)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	data, err := base64.StdEncoding.DecodeString(envelope.Message.Data)
+	if err != nil {
+		s.logger.Printf("Error decoding Pub/Sub message %s: %v", envelope.Message.MessageID, err)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var event BookOverdueEvent
+	if err := json.Unmarshal(data, &event); err != nil || event.BookID == "" {
+		s.logger.Printf("Malformed overdue event in message %s: %v", envelope.Message.MessageID, err)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// 発行元 (cron実行) のトレースに繋げる
+	ctx = otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(envelope.Message.Attributes))
+
+	if event.Cycle == "" {
+		event.Cycle = cron.Cycle(time.Now())
+	}
+	ctx = withCronRun(ctx, event.RunID)
+
+	if err := s.processOverdueBook(ctx, event.BookID, event.Cycle, nil); err != nil {
+		s.logger.Printf("Error processing overdue book %s (message %s): %v", event.BookID, envelope.Message.MessageID, err)
+		writeServerError(w, r, err, "Failed to process overdue book")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// verifyPushRequest は push リクエストの送信元を検証する。
+// PUBSUB_PUSH_AUDIENCE があれば OIDC トークンを、なければ ?token= と CRON_SECRET を照合する
+func (s *Server) verifyPushRequest(ctx context.Context, r *http.Request) error {
+	if audience := s.cfg.PubSub.PushAudience; audience != "" {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" {
+			return fmt.Errorf("missing OIDC token")
+		}
+		_, err := idtoken.Validate(ctx, token, audience)
+		return err
+	}
+
+	cronSecret := s.cronSecret()
+	if cronSecret != "" && r.URL.Query().Get("token") != cronSecret {
+		return fmt.Errorf("invalid push token")
+	}
+	return nil
+}
+
+// processOverdueBook は1冊分の煽り文を生成してLINEに送り、ステータスを更新する。
+// 再配信に備えて、最新のドキュメントを読み直してまだ期限切れか・この周期で未処理かを確認する。
+// batch が nil でなければ、ステータス更新はその場で書き込まずに batch に積む
+func (s *Server) processOverdueBook(ctx context.Context, bookID, cycle string, batch *cron.StatusBatch) error {
+	ctx, span := tracer.Start(ctx, "processOverdueBook", trace.WithAttributes(
+		attribute.String("book.id", bookID),
+		attribute.String("cycle", cycle),
+	))
+	defer span.End()
+
+	book, err := s.bookRepo.Get(ctx, bookID)
+	if errors.Is(err, store.ErrBookNotFound) {
+		s.logger.Printf("Overdue book %s no longer exists; skipping", bookID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if (book.Status != "unread" && book.Status != "insulted") || !isOverdue(book, time.Now()) {
+		s.logger.Printf("Book %s is no longer overdue (status: %s); skipping", bookID, book.Status)
+		return nil
+	}
+	if book.LastInsultCycle == cycle {
+		s.logger.Printf("Book %s was already insulted in cycle %s; skipping", bookID, cycle)
+		return nil
+	}
+
+	// 1. 煽り文を生成
+	generated, err := s.generateInsult(ctx, book)
+	if err != nil {
+		return fmt.Errorf("error generating insult: %w", err)
+	}
+
+	// 誓約があれば支払いのリマインダーを添える (期限切れ後は支払うまで毎回)
+	insultMsg := generated.Text
+	owed := markPledgeOwed(&book, time.Now())
+	if book.Pledge != nil && book.Pledge.State == store.PledgeOwed {
+		insultMsg += "\n\n" + pledgeReminder(*book.Pledge)
+	}
+
+	// 2. LINE Messaging APIでユーザーにメッセージを送信。
+	// 履歴のIDを先に決めておき、フィードバックのクイックリプライ (効いた / 滑った / 不快) に埋め込む
+	insultID := s.firestoreClient.Collection("insults").NewDoc().ID
+	message := map[string]interface{}{"type": "text", "text": insultMsg}
+	if s.lineChannelSecret() != "" {
+		message["quickReply"] = insultFeedbackQuickReply(insultID)
+	}
+	// 処理済みの周期は、ステータスの更新と一緒にではなく送る前に書いておく。ステータスの更新はバッチで実行の最後にまとめて書くので、
+	// 一緒に書くと、送ってから書くまでの間に落ちたりリースが切れたりした本に、再実行で同じ煽り文を送り直してしまう。
+	// 二重に送るより送り損ねるほうがましなので、周期ごとに高々1回にする。書いてから送るまでの間に落ちた本や、
+	// 送れずに周期を戻すのにも失敗した本は、その周期は煽られずに次の周期 (翌日) で煽られる
+	if err := s.bookRepo.Patch(ctx, bookID, store.BookPatch{LastInsultCycle: &cycle}); err != nil {
+		return fmt.Errorf("error recording insult cycle: %w", err)
+	}
+	if err := s.pushLineMessages(ctx, book.UserID, message); err != nil {
+		// 送れなかったので、再実行で送り直せるよう周期を戻す
+		previous := book.LastInsultCycle
+		if err := s.bookRepo.Patch(ctx, bookID, store.BookPatch{LastInsultCycle: &previous}); err != nil {
+			s.logger.Printf("Error resetting insult cycle for book %s: %v", bookID, err)
+		}
+		return fmt.Errorf("error sending LINE message to user %s: %w", book.UserID, err)
+	}
+
+	// 3. 書籍ステータス・煽りレベルを更新 (処理済みの周期は送る前に書いてある)
+	insulted := "insulted"
+	patch := store.BookPatch{Status: &insulted, InsultLevelIncr: 1}
+	if owed {
+		patch.Pledge = book.Pledge
+	}
+	if batch != nil {
+		batch.Add(bookID, patch)
+	} else if err := s.bookRepo.Patch(ctx, bookID, patch); err != nil {
+		// 送信は済んでいるので再配信はさせない
+		s.logger.Printf("Error updating status for book %s: %v", bookID, err)
+	}
+
+	book.Status = "insulted"
+	book.InsultLevel++
+	book.LastInsultCycle = cycle
+	eventBus.Publish(ctx, InsultSent{
+		InsultID: insultID,
+		Book:     book,
+		Message:  insultMsg,
+		Variant:  generated.Variant,
+		Template: generated.Template,
+		Cycle:    cycle,
+		SentAt:   time.Now(),
+	})
+	return nil
+}
+
+// InsultRecord は送信した煽り文の履歴。insults コレクションに保存する
+type InsultRecord struct {
+	ID      string `json:"id" firestore:"-"`
+	BookID  string `json:"bookId" firestore:"bookId"`
+	UserID  string `json:"userId" firestore:"userId"`
+	Message string `json:"message" firestore:"message"`
+	Variant string `json:"variant,omitempty" firestore:"variant,omitempty"` // A/B テストの種類 ("canned-savage" など)
+	// Template は用意された煽り文のID ("savage-12" など)。フィードバックから選ばれやすさを計算するのに使う
+	Template string    `json:"template,omitempty" firestore:"template,omitempty"`
+	Cycle    string    `json:"cycle" firestore:"cycle"`
+	SentAt   time.Time `json:"sentAt" firestore:"sentAt"`
+	// Feedback は受け取った人の評価 (/v1/insults/{id}/feedback か LINE のクイックリプライ)。まだなければ nil
+	Feedback *InsultFeedback `json:"feedback,omitempty" firestore:"feedback,omitempty"`
+}
+
+// recordInsult は送信済みの煽り文を insults コレクションに保存する。
+// ダッシュボードの表示用なので、失敗してもログに残すだけにする
+func (s *Server) recordInsult(ctx context.Context, e InsultSent) {
+	_, err := s.firestoreClient.Collection("insults").Doc(e.InsultID).Set(ctx, InsultRecord{
+		BookID:   e.Book.BookID,
+		UserID:   e.Book.UserID,
+		Message:  e.Message,
+		Variant:  e.Variant,
+		Template: e.Template,
+		Cycle:    e.Cycle,
+		SentAt:   e.SentAt,
+	})
+	if err != nil {
+		s.logger.Printf("Error recording insult for book %s: %v", e.Book.BookID, err)
+	}
+}
@@ -0,0 +1,78 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"tundoku-killer/backend/internal/store"
+)
+
+// 本を買った日・店・価格。買ってから最初の読書の記録までの日数を集計し (Stats.AverageDaysToFirstPage)、
+// 買ったのにまだ開いていない本の煽り文に添える
+
+// handleBookPurchase は {id} の本を買った日・店・価格を設定する (PUT)
+func (s *Server) handleBookPurchase(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req purchaseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid JSON body: %v", err))
+		return
+	}
+	if err := req.Validate(time.Now()); err != nil {
+		writeValidationError(w, r, err)
+		return
+	}
+	ctx := r.Context()
+	book, err := s.ownedBook(ctx, r.PathValue("id"), req.UserID)
+	if err != nil {
+		writeBookError(w, r, err, "Failed to retrieve book")
+		return
+	}
+	if book.OnWishlist() {
+		writeProblem(w, r, http.StatusConflict, "Book is on the wishlist; move it to owned via /v1/books/{id}/ownership first")
+		return
+	}
+
+	patch := store.BookPatch{PurchasedAt: &req.PurchasedAt, PurchaseStore: &req.Store, Price: req.Price}
+	if err := s.bookRepo.Patch(ctx, book.BookID, patch); err != nil {
+		writeBookError(w, r, err, "Failed to update book")
+		return
+	}
+	updated := book
+	updated.PurchasedAt, updated.PurchaseStore = &req.PurchasedAt, req.Store
+	if req.Price != nil {
+		updated.Price = *req.Price
+	}
+	eventBus.Publish(ctx, BookUpdated{Book: updated, Previous: book})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
+
+// purchaseJab は買ったのにまだ1ページも読んでいない本について、買ってからの日数を突きつける一文を返す。
+// 買った日が分からないか、もう読み始めていれば空
+func (s *Server) purchaseJab(ctx context.Context, book store.Book) string {
+	if book.PurchasedAt == nil || (book.Status != "unread" && book.Status != "insulted") {
+		return ""
+	}
+	days := int(time.Since(*book.PurchasedAt).Hours() / 24)
+	jab := fmt.Sprintf("買ってから%d日、まだ1ページも開いていません。", days)
+	if book.PurchaseStore != "" {
+		jab = fmt.Sprintf("%sで買ってから%d日、まだ1ページも開いていません。", book.PurchaseStore, days)
+	}
+	stats, err := s.userStats(ctx, book.UserID)
+	if err != nil {
+		s.logger.Printf("Error fetching stats for user %s: %v", book.UserID, err)
+		return jab
+	}
+	if avg := stats.AverageDaysToFirstPage; avg != nil && float64(days) > *avg {
+		jab += fmt.Sprintf("いつもは平均%.1f日で読み始めるのに。", *avg)
+	}
+	return jab
+}
@@ -0,0 +1,192 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+
+	"tundoku-killer/backend/internal/store"
+)
+
+// 購入禁止モード (設定の purchaseBanLimit)。未読の本が上限より多いのに本を買い足そうとすると、登録を止めて罪悪感を煽る応答を返す。
+// ?override=true を付ければ登録できるが、押し切った記録を purchaseBanOverrides に残し、その本の煽り文で蒸し返す。
+// ウィッシュリストの本と借りた本は買っていないので止めない
+
+// problemTypePurchaseBan は購入禁止モードで本の登録を止めたときの problem type
+const problemTypePurchaseBan = "urn:tundoku-killer:problem:purchase-ban"
+
+// PurchaseBanError は購入禁止モードで本の登録を止めたときのエラー
+type PurchaseBanError struct {
+	Unread  int    // 登録しようとしたときの未読の本の数
+	Limit   int    // 設定した上限
+	Message string // 罪悪感を煽る文面
+}
+
+func (e *PurchaseBanError) Error() string {
+	return fmt.Sprintf("purchase ban: %d unread books (limit %d)", e.Unread, e.Limit)
+}
+
+// PurchaseBanOverride は購入禁止モードを押し切って本を登録した記録。purchaseBanOverrides に保存する
+type PurchaseBanOverride struct {
+	OverrideID   string    `json:"overrideId" firestore:"overrideId"`
+	UserID       string    `json:"userId" firestore:"userId"`
+	BookID       string    `json:"bookId" firestore:"bookId"`
+	Title        string    `json:"title" firestore:"title"`
+	Price        int       `json:"price,omitempty" firestore:"price,omitempty"`
+	Unread       int       `json:"unread" firestore:"unread"`
+	Limit        int       `json:"limit" firestore:"limit"`
+	OverriddenAt time.Time `json:"overriddenAt" firestore:"overriddenAt"`
+}
+
+// purchaseBanProblem は登録を止めたときの応答。problem+json に未読の本の数と煽る文面を足す
+type purchaseBanProblem struct {
+	Problem
+	Unread  int    `json:"unread"`
+	Limit   int    `json:"limit"`
+	Message string `json:"message"`
+}
+
+// purchaseBan は book を登録すると購入禁止モードに触れるなら、その内容を返す。触れなければ nil
+func (s *Server) purchaseBan(ctx context.Context, book store.Book) (*PurchaseBanError, error) {
+	if book.UserID == "" || book.Ownership == store.OwnershipWishlist || book.Ownership == store.OwnershipBorrowed {
+		return nil, nil
+	}
+	settings, err := s.getSettings(ctx, book.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if settings.PurchaseBanLimit == 0 {
+		return nil, nil
+	}
+	books, err := s.listBooks(ctx, book.UserID)
+	if err != nil {
+		return nil, err
+	}
+	return purchaseBanFor(books, book, settings.PurchaseBanLimit), nil
+}
+
+// purchaseBanFor は本棚が books のときに book を登録すると、上限 limit の購入禁止モードに触れるなら、その内容を返す。触れなければ nil
+func purchaseBanFor(books []store.Book, book store.Book, limit int) *PurchaseBanError {
+	unread, value := 0, 0
+	for _, b := range books {
+		if b.Status != "completed" && !b.Abandoned() && !b.OnWishlist() {
+			unread++
+			value += b.Price
+		}
+	}
+	if unread <= limit {
+		return nil
+	}
+
+	message := fmt.Sprintf("未読の本がまだ%d冊あります。%d冊を超えたら買わないと決めたのはあなたです。『%s』を本当に今買う必要がありますか。",
+		unread, limit, book.Title)
+	if value > 0 {
+		message = fmt.Sprintf("未読の本がまだ%d冊、%s分あります。%d冊を超えたら買わないと決めたのはあなたです。『%s』を本当に今買う必要がありますか。",
+			unread, formatYen(value), limit, book.Title)
+	}
+	return &PurchaseBanError{Unread: unread, Limit: limit, Message: message}
+}
+
+// writePurchaseBan は購入禁止モードで登録を止めたことを 409 で返す
+func writePurchaseBan(w http.ResponseWriter, r *http.Request, ban *PurchaseBanError) {
+	p := purchaseBanProblem{
+		Problem: Problem{
+			Type:          problemTypePurchaseBan,
+			Title:         "Purchase ban",
+			Status:        http.StatusConflict,
+			Detail:        "Purchase ban is on; register again with ?override=true to buy it anyway.",
+			Instance:      r.URL.Path,
+			CorrelationID: correlationID(r.Context()),
+		},
+		Unread:  ban.Unread,
+		Limit:   ban.Limit,
+		Message: ban.Message,
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// recordPurchaseBanOverride は購入禁止モードを押し切って登録したことを残す。残せなくても登録は取り消さない
+func (s *Server) recordPurchaseBanOverride(ctx context.Context, book store.Book, ban *PurchaseBanError) {
+	override := PurchaseBanOverride{
+		OverrideID:   uuid.NewString(),
+		UserID:       book.UserID,
+		BookID:       book.BookID,
+		Title:        book.Title,
+		Price:        book.Price,
+		Unread:       ban.Unread,
+		Limit:        ban.Limit,
+		OverriddenAt: time.Now(),
+	}
+	if _, err := s.firestoreClient.Collection("purchaseBanOverrides").Doc(override.OverrideID).Set(ctx, override); err != nil {
+		s.logger.Printf("Error recording purchase ban override for %s: %v", book.BookID, err)
+		return
+	}
+	s.logger.Printf("Purchase ban overridden by %s: %s (%d unread, limit %d)", book.UserID, book.Title, ban.Unread, ban.Limit)
+}
+
+// handlePurchaseBanOverrides は ?userId= が購入禁止モードを押し切った記録を新しい順に返す
+func (s *Server) handlePurchaseBanOverrides(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	userID := r.URL.Query().Get("userId")
+	if userID == "" {
+		writeProblem(w, r, http.StatusBadRequest, "userId query parameter is required")
+		return
+	}
+	docs, err := s.firestoreClient.Collection("purchaseBanOverrides").Where("userId", "==", userID).Documents(r.Context()).GetAll()
+	if err != nil {
+		writeServerError(w, r, err, "Failed to retrieve overrides")
+		return
+	}
+	overrides := make([]PurchaseBanOverride, 0, len(docs))
+	for _, doc := range docs {
+		var override PurchaseBanOverride
+		if err := doc.DataTo(&override); err != nil {
+			s.logger.Printf("Error parsing purchase ban override %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		overrides = append(overrides, override)
+	}
+	sort.Slice(overrides, func(i, j int) bool { return overrides[i].OverriddenAt.After(overrides[j].OverriddenAt) })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(overrides)
+}
+
+// purchaseBanJab は購入禁止モードを押し切って買った本なら、そのことを蒸し返す一文を返す。そうでなければ空
+func (s *Server) purchaseBanJab(ctx context.Context, book store.Book) string {
+	docs, err := s.firestoreClient.Collection("purchaseBanOverrides").Where("bookId", "==", book.BookID).Limit(1).Documents(ctx).GetAll()
+	if err != nil {
+		s.logger.Printf("Error fetching purchase ban override for %s: %v", book.BookID, err)
+		return ""
+	}
+	if len(docs) == 0 {
+		return ""
+	}
+	var override PurchaseBanOverride
+	if err := docs[0].DataTo(&override); err != nil {
+		s.logger.Printf("Error parsing purchase ban override %s: %v", docs[0].Ref.ID, err)
+		return ""
+	}
+	jab := fmt.Sprintf("ちなみにこの本は、未読が%d冊もあるのに購入禁止モードを押し切って買った本です。", override.Unread)
+
+	overrides := s.firestoreClient.Collection("purchaseBanOverrides").Where("userId", "==", book.UserID)
+	results, err := overrides.NewAggregationQuery().WithCount("count").Get(ctx)
+	if err != nil {
+		s.logger.Printf("Error counting purchase ban overrides for %s: %v", book.UserID, err)
+		return jab
+	}
+	if count, ok := results["count"]; ok && aggregationInt(count) > 1 {
+		jab += fmt.Sprintf("押し切ったのはこれまでに%d回です。", aggregationInt(count))
+	}
+	return jab
+}
@@ -0,0 +1,260 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"tundoku-killer/backend/internal/insult"
+	"tundoku-killer/backend/internal/store"
+)
+
+// 難しいモード (設定の hardMode) では、本の内容のクイズに正解しないと読了にできない。
+// クイズは bookQuizzes/{bookId} に保存し、答えるまでは同じ問題を返す。間違えたら本は「読書中」に戻し、
+// quizRetryCooldown の間は作り直さない (生成AIに作らせ直して当て推量するのを防ぐ)
+
+// quizRetryCooldown は間違えてから次のクイズを出すまでの時間
+const quizRetryCooldown = 10 * time.Minute
+
+// quizPassScore は合格に必要な正解数 (insult.QuizSize 問中)。生成AIの問題の出来に揺れがあるので1問は落としてよい
+const quizPassScore = insult.QuizSize - 1
+
+// BookQuiz は bookQuizzes/{bookId} に保存するクイズ
+type BookQuiz struct {
+	UserID    string                `firestore:"userId"`
+	Questions []insult.QuizQuestion `firestore:"questions"`
+	// Unavailable は生成AIが内容を知らずクイズを作れなかった本。難しいモードでもクイズなしで読了にできる
+	Unavailable bool       `firestore:"unavailable"`
+	CreatedAt   time.Time  `firestore:"createdAt"`
+	FailedAt    *time.Time `firestore:"failedAt,omitempty"` // 最後に間違えた日時
+}
+
+// QuizQuestionView は答えを伏せて返す1問
+type QuizQuestionView struct {
+	Question string   `json:"question"`
+	Choices  []string `json:"choices"`
+}
+
+// requireQuiz は難しいモードのユーザーが、クイズなしで book を読了にできるかを確かめる。できなければ errQuizRequired
+func (s *Server) requireQuiz(ctx context.Context, book store.Book) error {
+	if book.Status == "completed" {
+		return nil
+	}
+	settings, err := s.userRepo.GetSettings(ctx, book.UserID)
+	if err != nil {
+		return err
+	}
+	if !settings.HardMode {
+		return nil
+	}
+	quiz, err := s.bookQuiz(ctx, book.BookID)
+	if err != nil {
+		return err
+	}
+	if quiz != nil && quiz.Unavailable {
+		return nil
+	}
+	return errQuizRequired
+}
+
+// bookQuiz は保存したクイズを返す。なければ nil
+func (s *Server) bookQuiz(ctx context.Context, bookID string) (*BookQuiz, error) {
+	doc, err := s.firestoreClient.Collection("bookQuizzes").Doc(bookID).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching quiz: %w", err)
+	}
+	var quiz BookQuiz
+	if err := doc.DataTo(&quiz); err != nil {
+		return nil, fmt.Errorf("error parsing quiz: %w", err)
+	}
+	return &quiz, nil
+}
+
+// handleBookQuiz は ?bookId=&userId= の本のクイズを答えを伏せて返す。まだなければ生成AIに作らせる
+func (s *Server) handleBookQuiz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	ctx := r.Context()
+	q := r.URL.Query()
+	bookID, userID := q.Get("bookId"), q.Get("userId")
+	if bookID == "" || userID == "" {
+		writeProblem(w, r, http.StatusBadRequest, "bookId and userId query parameters are required")
+		return
+	}
+	book, err := s.ownedBook(ctx, bookID, userID)
+	if err != nil {
+		writeBookError(w, r, err, "Failed to retrieve book")
+		return
+	}
+	if book.Status == "completed" {
+		writeProblem(w, r, http.StatusConflict, "Book is already completed")
+		return
+	}
+
+	quiz, err := s.bookQuiz(ctx, bookID)
+	if err != nil {
+		writeServerError(w, r, err, "Failed to retrieve quiz")
+		return
+	}
+	if quiz != nil && len(quiz.Questions) == 0 && !quiz.Unavailable && quiz.FailedAt != nil {
+		if wait := time.Until(quiz.FailedAt.Add(quizRetryCooldown)); wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+			writeProblem(w, r, http.StatusTooManyRequests, "Wrong answers; try a new quiz later")
+			return
+		}
+	}
+	if quiz == nil || (len(quiz.Questions) == 0 && !quiz.Unavailable) {
+		if quiz, err = s.createBookQuiz(ctx, book); err != nil {
+			if errors.Is(err, insult.ErrBudgetExceeded) || errors.Is(err, insult.ErrNoProvider) {
+				writeProblem(w, r, http.StatusServiceUnavailable, "Quiz generation is unavailable right now")
+				return
+			}
+			writeServerError(w, r, err, "Failed to create quiz")
+			return
+		}
+	}
+
+	questions := make([]QuizQuestionView, len(quiz.Questions))
+	for i, question := range quiz.Questions {
+		questions[i] = QuizQuestionView{Question: question.Question, Choices: question.Choices}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"bookId":    bookID,
+		"available": !quiz.Unavailable, // false ならクイズなしで読了にできる
+		"questions": questions,
+		"passScore": quizPassScore,
+	})
+}
+
+// createBookQuiz は生成AIにクイズを作らせて保存する。内容を知らない本なら Unavailable として保存する
+func (s *Server) createBookQuiz(ctx context.Context, book store.Book) (*BookQuiz, error) {
+	var description string
+	if book.ISBN != "" {
+		var err error
+		if description, err = s.lookupDescription(ctx, book.ISBN); err != nil {
+			s.logger.Printf("Error looking up description for ISBN %s: %v", book.ISBN, err)
+		}
+	}
+	quiz := &BookQuiz{UserID: book.UserID, CreatedAt: time.Now()}
+	questions, err := s.quiz.Write(ctx, book, description)
+	switch {
+	case errors.Is(err, insult.ErrUnknownBook):
+		s.logger.Printf("No quiz for book %s (%s): %v", book.BookID, book.Title, err)
+		quiz.Unavailable = true
+	case err != nil:
+		return nil, err
+	default:
+		quiz.Questions = questions
+	}
+	if _, err := s.firestoreClient.Collection("bookQuizzes").Doc(book.BookID).Set(ctx, quiz); err != nil {
+		return nil, fmt.Errorf("error saving quiz: %w", err)
+	}
+	return quiz, nil
+}
+
+// handleBookQuizAnswers はクイズの答えを採点する。合格なら本を読了にし、不合格なら「読書中」に戻して疑う
+func (s *Server) handleBookQuizAnswers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req quizAnswersRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid JSON body: %v", err))
+		return
+	}
+	if err := req.Validate(); err != nil {
+		writeValidationError(w, r, err)
+		return
+	}
+	ctx := r.Context()
+	book, err := s.ownedBook(ctx, req.BookID, req.UserID)
+	if err != nil {
+		writeBookError(w, r, err, "Failed to retrieve book")
+		return
+	}
+	if book.Status == "completed" {
+		writeProblem(w, r, http.StatusConflict, "Book is already completed")
+		return
+	}
+	quiz, err := s.bookQuiz(ctx, req.BookID)
+	if err != nil {
+		writeServerError(w, r, err, "Failed to retrieve quiz")
+		return
+	}
+	if quiz == nil || len(quiz.Questions) == 0 {
+		writeProblem(w, r, http.StatusConflict, "No open quiz for this book; fetch one first")
+		return
+	}
+	if len(req.Answers) != len(quiz.Questions) {
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("answers must have %d entries", len(quiz.Questions)))
+		return
+	}
+
+	score := 0
+	for i, question := range quiz.Questions {
+		if req.Answers[i] == question.Answer {
+			score++
+		}
+	}
+	passed := score >= quizPassScore
+
+	var message string
+	if passed {
+		if err := s.markCompleted(ctx, book); err != nil {
+			writeBookError(w, r, err, "Failed to update book status")
+			return
+		}
+		message = fmt.Sprintf("%d問中%d問正解。…本当に読んだようですね。読了を認めます。", len(quiz.Questions), score)
+	} else {
+		// 同じ問題で答え直せないよう問題を捨て、本は「読書中」に戻す
+		ref := s.firestoreClient.Collection("bookQuizzes").Doc(req.BookID)
+		if _, err := ref.Set(ctx, map[string]interface{}{"questions": []insult.QuizQuestion{}, "failedAt": time.Now()}, firestore.MergeAll); err != nil {
+			writeServerError(w, r, err, "Failed to record quiz result")
+			return
+		}
+		if book.Status != "reading" {
+			reading := "reading"
+			if err := s.bookRepo.Patch(ctx, req.BookID, store.BookPatch{Status: &reading}); err != nil {
+				writeBookError(w, r, err, "Failed to update book status")
+				return
+			}
+			updated := book
+			updated.Status = reading
+			eventBus.Publish(ctx, BookUpdated{Book: updated, Previous: book})
+		}
+		message = fmt.Sprintf("%d問中%d問正解。…本当に読みました？ 表紙を眺めただけでは読了とは言いません。「%s」は読書中に戻しておきました。",
+			len(quiz.Questions), score, book.Title)
+	}
+	s.logger.Printf("Quiz for book %s: %d/%d (passed: %v)", req.BookID, score, len(quiz.Questions), passed)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"passed":  passed,
+		"score":   score,
+		"total":   len(quiz.Questions),
+		"message": message,
+	})
+}
+
+// forgetQuiz は bookID のクイズを消す
+func (s *Server) forgetQuiz(ctx context.Context, bookID string) {
+	_, err := s.firestoreClient.Collection("bookQuizzes").Doc(bookID).Delete(ctx)
+	if err != nil && status.Code(err) != codes.NotFound {
+		s.logger.Printf("Error deleting quiz for book %s: %v", bookID, err)
+	}
+}
@@ -0,0 +1,185 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"tundoku-killer/backend/internal/isbn"
+	"tundoku-killer/backend/internal/recommend"
+	"tundoku-killer/backend/internal/store"
+	"tundoku-killer/backend/internal/validation"
+)
+
+// 次に読む本の推薦。積んでいる本 (読み終えていない・諦めていない・ウィッシュリストでない・ブロック中でない本) を
+// s.recommender で並べる。?includeNew=true なら、好きな著者の本で本棚にないものを楽天ブックスで探して添える
+
+const (
+	defaultRecommendations = 5
+	maxRecommendations     = 20
+	// newTitleAuthors は新しい本を探す著者の数 (好きな順)
+	newTitleAuthors = 3
+	// newTitleHits は著者1人あたりに楽天ブックスから取ってくる本の数
+	newTitleHits = 10
+)
+
+// handleRecommendations は ?userId= の次に読む本を推薦する (GET)。?limit= は返す数 (既定5、最大20)
+func (s *Server) handleRecommendations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	userID := query.Get("userId")
+	var v validation.Validator
+	v.Required("userId", userID)
+	v.MaxLength("userId", userID, maxIDLength)
+	limit := defaultRecommendations
+	if raw := query.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		v.Check(err == nil && n > 0 && n <= maxRecommendations, "limit", "must be an integer between 1 and 20")
+		limit = n
+	}
+	includeNew := false
+	if raw := query.Get("includeNew"); raw != "" {
+		b, err := strconv.ParseBool(raw)
+		v.Check(err == nil, "includeNew", "must be true or false")
+		includeNew = b
+	}
+	if err := v.Err(); err != nil {
+		writeValidationError(w, r, err)
+		return
+	}
+	if includeNew && s.cfg.RakutenApplicationID == "" {
+		writeProblem(w, r, http.StatusNotImplemented, "New title search is not configured")
+		return
+	}
+
+	ctx := r.Context()
+	books, err := s.listBooks(ctx, userID)
+	if err != nil {
+		writeServerError(w, r, err, "Failed to retrieve books")
+		return
+	}
+	now := time.Now()
+	recs, err := s.recommender.Rank(ctx, books, unreadPile(books), now)
+	if err != nil {
+		writeServerError(w, r, err, "Failed to rank books")
+		return
+	}
+	resp := map[string]interface{}{"recommendations": recs[:min(limit, len(recs))]}
+
+	if includeNew {
+		// 楽天ブックスが落ちていても、積んでいる本の推薦は返す
+		newTitles := []recommend.Recommendation{}
+		if candidates := s.newTitleCandidates(ctx, userID, books); len(candidates) > 0 {
+			ranked, err := s.recommender.Rank(ctx, books, candidates, now)
+			if err != nil {
+				writeServerError(w, r, err, "Failed to rank new titles")
+				return
+			}
+			newTitles = ranked[:min(limit, len(ranked))]
+		}
+		resp["newTitles"] = newTitles
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// unreadPile は推薦の候補にする積んでいる本を返す。先に読む本が残っている本は、まだ読めないので外す
+func unreadPile(books []store.Book) []store.Book {
+	byID := booksByID(books)
+	pile := []store.Book{}
+	for _, book := range books {
+		if book.Status == "completed" || book.Abandoned() || book.OnWishlist() {
+			continue
+		}
+		if len(blockers(book, byID)) > 0 {
+			continue
+		}
+		pile = append(pile, book)
+	}
+	return pile
+}
+
+// newTitleCandidates は好きな著者の本のうち、本棚にない本を楽天ブックスで探す。
+// 返す本はそのまま POST /v1/books でウィッシュリストに登録できる形にする
+func (s *Server) newTitleCandidates(ctx context.Context, userID string, books []store.Book) []store.Book {
+	ctx, cancel := context.WithTimeout(ctx, priceLookupTimeout)
+	defer cancel()
+
+	shelved := make(map[string]bool, len(books))
+	for _, book := range books {
+		shelved[titleKey(book)] = true
+	}
+	onShelf := func(found store.Book) bool {
+		if shelved[titleKey(found)] {
+			return true
+		}
+		for _, book := range books {
+			if found.ISBN != "" && isbn.Equal(book.ISBN, found.ISBN) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var candidates []store.Book
+	for _, author := range recommend.NewProfile(books).TopAuthors(newTitleAuthors) {
+		found, err := searchRakutenByAuthor(ctx, s.cfg.RakutenApplicationID, author)
+		if err != nil {
+			s.logger.Printf("Error searching Rakuten Books for %q: %v", author, err)
+			break
+		}
+		for _, book := range found {
+			if onShelf(book) {
+				continue
+			}
+			shelved[titleKey(book)] = true
+			book.UserID = userID
+			book.Status = "unread"
+			book.Ownership = store.OwnershipWishlist
+			candidates = append(candidates, book)
+		}
+	}
+	return candidates
+}
+
+// searchRakutenByAuthor は楽天ブックス書籍検索APIで author の本を発売日の新しい順に探す
+func searchRakutenByAuthor(ctx context.Context, appID, author string) ([]store.Book, error) {
+	var result struct {
+		Items []struct {
+			Item struct {
+				Title     string `json:"title"`
+				Author    string `json:"author"`
+				ISBN      string `json:"isbn"`
+				ItemPrice int    `json:"itemPrice"`
+			} `json:"Item"`
+		} `json:"Items"`
+	}
+	q := url.Values{
+		"applicationId": {appID},
+		"author":        {author},
+		"sort":          {"-releaseDate"},
+		"hits":          {strconv.Itoa(newTitleHits)},
+		"format":        {"json"},
+	}
+	if err := getJSON(ctx, "https://app.rakuten.co.jp/services/api/BooksBook/Search/20170404?"+q.Encode(), &result); err != nil {
+		return nil, err
+	}
+	books := make([]store.Book, 0, len(result.Items))
+	for _, item := range result.Items {
+		books = append(books, store.Book{
+			Title:  item.Item.Title,
+			Author: item.Item.Author,
+			ISBN:   isbn.Normalize(item.Item.ISBN),
+			Price:  item.Item.ItemPrice,
+		})
+	}
+	return books, nil
+}
@@ -0,0 +1,272 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"tundoku-killer/backend/internal/cron"
+)
+
+// 月末に、その月に読み終えた本・追加した本・積読の増減をLINEの Flex Message で送る。
+// 期限切れチェック (/v1/cron/check) とは別に、月に1回外部のスケジューラーから呼ぶ
+
+// monthlyReportLease は月次レポートの二重実行を防ぐロックの名前
+const monthlyReportLease = "monthlyReport"
+
+// usersPerBookQuery は全ユーザーの本を集計するときに、一度に本を読み込むユーザーの数
+const usersPerBookQuery = 100
+
+// MonthlyReport はユーザーごとの1か月分の集計。monthlyReports/{month}_{userId} に送信済みとして残す
+type MonthlyReport struct {
+	UserID   string    `json:"userId" firestore:"userId"`
+	Month    string    `json:"month" firestore:"month"` // "2006-01"
+	Finished int       `json:"finished" firestore:"finished"`
+	Added    int       `json:"added" firestore:"added"`
+	Delta    int       `json:"delta" firestore:"delta"`     // 積読の増減 (追加 - 読了)
+	Backlog  int       `json:"backlog" firestore:"backlog"` // 集計時点で読み終えていない本
+	Verdict  string    `json:"verdict" firestore:"verdict"`
+	SentAt   time.Time `json:"sentAt,omitempty" firestore:"sentAt"`
+}
+
+// reportMonth は month ("2006-01"、空なら now の前月) の JST での開始と終了を返す
+func reportMonth(month string, now time.Time) (string, time.Time, time.Time, error) {
+	var start time.Time
+	if month == "" {
+		thisMonth := now.In(cron.Location)
+		start = time.Date(thisMonth.Year(), thisMonth.Month()-1, 1, 0, 0, 0, 0, cron.Location)
+	} else {
+		t, err := time.ParseInLocation("2006-01", month, cron.Location)
+		if err != nil {
+			return "", time.Time{}, time.Time{}, fmt.Errorf("month must be in YYYY-MM format")
+		}
+		start = t
+	}
+	return start.Format("2006-01"), start, start.AddDate(0, 1, 0), nil
+}
+
+// handleMonthlyReport は月次レポートを全ユーザーに送る。
+// ?month=YYYY-MM で対象月を指定できる (省略時は前月なので、毎月1日に呼ぶ)。
+// 送信済みのユーザーは飛ばすので、途中で失敗しても再実行すれば残りだけ送る
+func (s *Server) handleMonthlyReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodGet {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// 呼び出し元が切断しても送信を途中で打ち切らない
+	ctx := context.WithoutCancel(r.Context())
+
+	if !s.authorizeCron(r) {
+		writeProblem(w, r, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	month, start, end, err := reportMonth(r.URL.Query().Get("month"), time.Now())
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	runID := uuid.NewString()
+	if err := s.cron.AcquireLease(ctx, monthlyReportLease, runID, cron.LeaseTTL); err != nil {
+		if errors.Is(err, cron.ErrLeaseHeld) {
+			writeProblem(w, r, http.StatusConflict, "Another monthly report is already running")
+			return
+		}
+		writeServerError(w, r, err, "Failed to acquire lock")
+		return
+	}
+	defer s.cron.ReleaseLease(ctx, monthlyReportLease, runID)
+
+	reports, err := s.aggregateMonth(ctx, month, start, end)
+	if err != nil {
+		writeServerError(w, r, err, "Failed to aggregate books")
+		return
+	}
+
+	byUser := make(map[string]MonthlyReport, len(reports))
+	userIDs := make([]string, len(reports))
+	for i, report := range reports {
+		byUser[report.UserID] = report
+		userIDs[i] = report.UserID
+	}
+
+	result := s.deliverReports(ctx, "monthlyReports", month, userIDs, func(ctx context.Context, userID string) (interface{}, error) {
+		report := byUser[userID]
+		if err := s.pushLineMessages(ctx, userID, monthlyReportFlex(report)); err != nil {
+			return nil, err
+		}
+		report.SentAt = time.Now()
+		return report, nil
+	})
+
+	s.logger.Printf("Monthly report %s: %d sent, %d skipped, %d failed (done: %v)", month, result.Sent, result.Skipped, result.Failed, result.Done)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"month":   month,
+		"users":   len(reports),
+		"sent":    result.Sent,
+		"skipped": result.Skipped,
+		"failed":  result.Failed,
+		"done":    result.Done,
+	})
+}
+
+// reportDelivery は定期レポートの送信結果
+type reportDelivery struct {
+	Sent, Skipped, Failed int
+	Done                  bool // false なら時間切れで、まだ送っていないユーザーがいる
+}
+
+// deliverReports は userIDs に順に send でレポートを送り、send が返した記録を
+// collection/{period}_{userId} に保存する。記録があるユーザーは送信済みとして飛ばす
+func (s *Server) deliverReports(ctx context.Context, collection, period string, userIDs []string, send func(ctx context.Context, userID string) (interface{}, error)) reportDelivery {
+	deadline := time.Now().Add(cron.TimeBudget)
+	result := reportDelivery{Done: true}
+	for _, userID := range userIDs {
+		if time.Now().After(deadline) {
+			result.Done = false
+			break
+		}
+
+		ref := s.firestoreClient.Collection(collection).Doc(period + "_" + userID)
+		if _, err := ref.Get(ctx); err == nil {
+			result.Skipped++
+			continue
+		} else if status.Code(err) != codes.NotFound {
+			s.logger.Printf("Error checking %s for user %s: %v", collection, userID, err)
+			result.Failed++
+			continue
+		}
+
+		record, err := send(ctx, userID)
+		if err != nil {
+			s.logger.Printf("Error sending %s to user %s: %v", collection, userID, err)
+			result.Failed++
+			continue
+		}
+
+		if _, err := ref.Set(ctx, record); err != nil {
+			s.logger.Printf("Error recording %s for user %s: %v", collection, userID, err)
+		}
+		result.Sent++
+	}
+	return result
+}
+
+// aggregateMonth は全ユーザーの [start, end) の読了数・追加数と現在の積読数を集計する。
+// 全員の本を一度に読み込まないよう、usersPerBookQuery 人ずつ読み込む
+func (s *Server) aggregateMonth(ctx context.Context, month string, start, end time.Time) ([]MonthlyReport, error) {
+	userIDs, err := s.listUserIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	byUser := make(map[string]*MonthlyReport)
+	for from := 0; from < len(userIDs); from += usersPerBookQuery {
+		books, err := s.bookRepo.ListByUsers(ctx, userIDs[from:min(from+usersPerBookQuery, len(userIDs))])
+		if err != nil {
+			return nil, err
+		}
+		for _, book := range books {
+			if book.UserID == "" || book.OnWishlist() {
+				continue
+			}
+
+			report := byUser[book.UserID]
+			if report == nil {
+				report = &MonthlyReport{UserID: book.UserID, Month: month}
+				byUser[book.UserID] = report
+			}
+			if inRange(book.CreatedAt, start, end) {
+				report.Added++
+			}
+			if book.Status == "completed" && inRange(book.CompletedAt, start, end) {
+				report.Finished++
+			}
+			if book.Status != "completed" && !book.Abandoned() {
+				report.Backlog++
+			}
+		}
+	}
+
+	reports := make([]MonthlyReport, 0, len(byUser))
+	for _, report := range byUser {
+		report.Delta = report.Added - report.Finished
+		report.Verdict = monthlyVerdict(*report)
+		reports = append(reports, *report)
+	}
+	// 途中で時間切れになっても次の実行で続きから送れるよう、順序を固定する
+	sort.Slice(reports, func(i, j int) bool { return reports[i].UserID < reports[j].UserID })
+	return reports, nil
+}
+
+func inRange(t *time.Time, start, end time.Time) bool {
+	return t != nil && !t.Before(start) && t.Before(end)
+}
+
+// monthlyVerdict は1か月の積読の増減に対する判定
+func monthlyVerdict(report MonthlyReport) string {
+	switch {
+	case report.Added == 0 && report.Finished == 0:
+		return "今月は本に触れもしませんでしたね"
+	case report.Delta > 0:
+		return "今月も積む側の人間でしたね"
+	case report.Delta == 0:
+		return "積読は横ばい。現状維持は後退です"
+	default:
+		return "積読を減らしました。やればできるじゃないですか"
+	}
+}
+
+// monthlyReportFlex は月次レポートの Flex Message を組み立てる
+func monthlyReportFlex(report MonthlyReport) map[string]interface{} {
+	row := func(label, value string) map[string]interface{} {
+		return map[string]interface{}{
+			"type":   "box",
+			"layout": "horizontal",
+			"contents": []interface{}{
+				map[string]interface{}{"type": "text", "text": label, "size": "sm", "color": "#555555"},
+				map[string]interface{}{"type": "text", "text": value, "size": "sm", "align": "end", "weight": "bold"},
+			},
+		}
+	}
+
+	title := fmt.Sprintf("%s の積読レポート", report.Month)
+	return map[string]interface{}{
+		"type":    "flex",
+		"altText": fmt.Sprintf("%s: 読了%d冊 / 追加%d冊 / 積読%+d冊", title, report.Finished, report.Added, report.Delta),
+		"contents": map[string]interface{}{
+			"type": "bubble",
+			"header": map[string]interface{}{
+				"type":   "box",
+				"layout": "vertical",
+				"contents": []interface{}{
+					map[string]interface{}{"type": "text", "text": title, "weight": "bold", "size": "lg"},
+				},
+			},
+			"body": map[string]interface{}{
+				"type":    "box",
+				"layout":  "vertical",
+				"spacing": "sm",
+				"contents": []interface{}{
+					row("読み終えた本", fmt.Sprintf("%d冊", report.Finished)),
+					row("追加した本", fmt.Sprintf("%d冊", report.Added)),
+					row("積読の増減", fmt.Sprintf("%+d冊", report.Delta)),
+					row("残りの積読", fmt.Sprintf("%d冊", report.Backlog)),
+					map[string]interface{}{"type": "separator", "margin": "md"},
+					map[string]interface{}{"type": "text", "text": report.Verdict, "wrap": true, "weight": "bold", "margin": "md", "color": "#D32F2F"},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,294 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"tundoku-killer/backend/internal/openapi"
+)
+
+// apiVersionPrefix は現行バージョンのAPIのパスの接頭辞
+const apiVersionPrefix = "/v1"
+
+// legacyPrefix は /v1 導入前のパスの接頭辞。デプロイ済みのフロントエンドと GitHub Actions の cron のために残している
+const legacyPrefix = "/api"
+
+// registerRoutes はすべてのエンドポイントを s.mux に登録する。
+// gateway (grpc-gateway) は /v1 以下の、個別に登録したパス以外を受け持つ
+func (s *Server) registerRoutes(gateway http.Handler, graphqlHandler http.HandlerFunc) {
+	s.mux.HandleFunc("/", s.corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "Hello from Backend!")
+	}))
+
+	s.mux.HandleFunc("/health", s.corsMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintln(w, "OK")
+	}))
+
+	// 依存先 (Firestore・SQL・LINE のトークン) を実際に確かめるヘルスチェック
+	s.mux.HandleFunc("/health/ready", s.corsMiddleware(s.handleReadyHealth))
+
+	// API定義 (OpenAPI 3) と Swagger UI
+	s.mux.HandleFunc("/openapi.json", s.corsMiddleware(apiSpec.ServeJSON))
+	s.mux.HandleFunc("/docs", s.corsMiddleware(apiSpec.ServeSwaggerUI))
+
+	// LINE認証エンドポイントの追加
+	s.handleAPI("/auth/line", s.corsMiddleware(validated(s.handleLineAuth)))
+
+	// Google アカウントでのログイン (LINE を使わない人向け。通知はメール)
+	s.handleAPI("/auth/google", s.corsMiddleware(validated(s.handleGoogleAuth)))
+
+	// ログイン方法のつなぎ込みと、別々にできたアカウントの統合
+	s.handleAPI("/auth/link/line", s.corsMiddleware(validated(s.handleLinkLine)))
+	s.handleAPI("/auth/merge", s.corsMiddleware(validated(s.handleMergeAccounts)))
+
+	// 書籍関連のエンドポイント
+	s.handleAPI("/books", s.corsMiddleware(s.apiKeyAuth(validated(s.handleBooks))))
+
+	// ページ数とユーザーの読書のペースから提案する期限 (登録画面の初期値)
+	s.handleAPI("/books/suggest-deadline", s.corsMiddleware(validated(s.handleSuggestDeadline)))
+
+	// 期限の交渉 (生成AIが希望の期限を受け入れるか、対案を出す)
+	s.handleAPI("/books/deadline/negotiate", s.corsMiddleware(validated(s.handleNegotiateDeadline)))
+	s.handleAPI("/books/deadline/negotiate/accept", s.corsMiddleware(validated(s.handleAcceptNegotiation)))
+
+	// 読書の記録 (何ページ読んだか)。ペースと読了見込みに使う
+	s.handleAPI("/books/sessions", s.corsMiddleware(validated(s.handleReadingSessions)))
+
+	// Goodreads のエクスポートの CSV の取り込み
+	s.handleAPI("/books/import/goodreads", s.corsMiddleware(validated(s.handleGoodreadsImport)))
+
+	// Amazon の注文履歴の CSV から拾った、登録の候補 (買った日を埋めて返すだけで、保存はしない)
+	s.handleAPI("/books/import/amazon", s.corsMiddleware(validated(s.handleAmazonImport)))
+
+	// Kindle のハイライトとメモの取り込み (My Clippings.txt かノートブックのエクスポート) と、本ごとの一覧
+	s.handleAPI("/books/import/kindle", s.corsMiddleware(validated(s.handleKindleImport)))
+	s.handleAPI("/books/{id}/notes", s.corsMiddleware(validated(s.handleBookNotes)))
+
+	// 読書タイマー (止めると経過時間付きの読書の記録になる)
+	s.handleAPI("/books/{id}/timer/start", s.corsMiddleware(validated(s.handleStartTimer)))
+	s.handleAPI("/books/{id}/timer/stop", s.corsMiddleware(validated(s.handleStopTimer)))
+
+	// 本の持ち方 (ウィッシュリスト・持っている・借りている)。ウィッシュリストから外すときに期限を決める
+	s.handleAPI("/books/{id}/ownership", s.corsMiddleware(validated(s.handleBookOwnership)))
+
+	// 買った日・店・価格 (買ってから読み始めるまでの日数の集計に使う)
+	s.handleAPI("/books/{id}/purchase", s.corsMiddleware(validated(s.handleBookPurchase)))
+
+	// 諦めた本のメルカリの出品の説明文 (LINE にも送る)
+	s.handleAPI("/books/{id}/listing", s.corsMiddleware(validated(s.handleBookListing)))
+
+	// 期限の iCalendar フィード (トークン付きの URL をカレンダーのアプリに登録する)
+	s.handleAPI("/calendar/feed", s.corsMiddleware(validated(s.handleCalendarFeed)))
+	s.handleAPI("/calendar.ics", s.corsMiddleware(validated(s.handleCalendarICS)))
+
+	// 最近の活動の Atom / RSS フィード (トークン付きの URL をフィードリーダーに登録する)
+	s.handleAPI("/activity/feed", s.corsMiddleware(validated(s.handleActivityFeedToken)))
+	s.handleAPI("/activity.atom", s.corsMiddleware(validated(s.handleActivityAtom)))
+	s.handleAPI("/activity.rss", s.corsMiddleware(validated(s.handleActivityRSS)))
+
+	// 購入禁止モードを押し切って本を登録した記録 (登録は /v1/books?override=true)
+	s.handleAPI("/purchase-ban/overrides", s.corsMiddleware(validated(s.handlePurchaseBanOverrides)))
+
+	// 図書館から借りた本の返却期限 (読む期限とは別。近づくほどきつく返却を促す)
+	s.handleAPI("/books/{id}/library", s.corsMiddleware(validated(s.handleBookLibrary)))
+
+	// ウィッシュリストの本が近所の図書館にあるか (カーリル。CALIL_APP_KEY が必要)
+	s.handleAPI("/books/{id}/library/availability", s.corsMiddleware(validated(s.handleBookLibraryAvailability)))
+
+	// ウィッシュリストの本の値下がりの見張りと価格の記録
+	s.handleAPI("/books/{id}/price-watch", s.corsMiddleware(validated(s.handleBookPriceWatch)))
+
+	// 読む順番 (「Aを読んでからB」) と、それを守った次に読む本
+	s.handleAPI("/books/{id}/dependencies", s.corsMiddleware(validated(s.handleBookDependencies)))
+	s.handleAPI("/books/next", s.corsMiddleware(validated(s.handleNextBook)))
+
+	// 読み終えた本の著者・タグから、次に読む本の推薦 (?includeNew=true で本棚にない新しい本も)
+	s.handleAPI("/recommendations", s.corsMiddleware(validated(s.handleRecommendations)))
+	// 読み終えた本の似た読者が読み終えた本 (設定の shareReadingHistory に同意した人どうし)
+	s.handleAPI("/recommendations/similar-readers", s.corsMiddleware(validated(s.handleSimilarReaders)))
+
+	// 毎晩のチェックイン (読書の記録とストリーク)。LINE のクイックリプライからは Webhook で受け付ける
+	s.handleAPI("/checkin", s.corsMiddleware(validated(s.handleCheckin)))
+
+	// 読了処理のエンドポイント
+	s.handleAPI("/books/complete", s.corsMiddleware(s.apiKeyAuth(validated(s.handleCompleteBook))))
+
+	// 難しいモードの読了クイズ (正解しないと読了にできない)
+	s.handleAPI("/books/quiz", s.corsMiddleware(validated(s.handleBookQuiz)))
+	s.handleAPI("/books/quiz/answers", s.corsMiddleware(validated(s.handleBookQuizAnswers)))
+	s.handleAPI("/books/proofs", s.corsMiddleware(validated(s.handleProofs)))
+	s.handleAPI("/books/proofs/upload-url", s.corsMiddleware(validated(s.handleProofUploadURL)))
+
+	// 本への誓約 (期限を破ったら寄付する約束) の設定と支払いの申告
+	s.handleAPI("/books/pledge", s.corsMiddleware(validated(s.handlePledge)))
+	s.handleAPI("/books/pledge/settle", s.corsMiddleware(validated(s.handleSettlePledge)))
+
+	// 保存期間を過ぎてアーカイブに移した読了本
+	s.handleAPI("/books/archive", s.corsMiddleware(validated(s.handleBookArchive)))
+
+	// 煽り文への評価 (効いた / 滑った / 不快)
+	s.handleAPI("/insults/{id}/feedback", s.corsMiddleware(validated(s.handleInsultFeedback)))
+
+	// LINE プラットフォームからの Webhook (煽り文の評価のクイックリプライ)
+	s.handleAPI("/line/webhook", s.handleLineWebhook)
+
+	// GitHub Actionsからの定期実行用エンドポイント (Cron)
+	s.handleAPI("/cron/check", s.corsMiddleware(validated(s.handleCheckDeadlines)))
+
+	// 月次レポートのLINE送信 (毎月1日に前月分を送る)
+	s.handleAPI("/cron/monthly-report", s.corsMiddleware(validated(s.handleMonthlyReport)))
+
+	// 年間の振り返り (毎年1月に前年分を送る)
+	s.handleAPI("/cron/year-in-review", s.corsMiddleware(validated(s.handleYearInReviewCron)))
+
+	// 恥の壁の集計 (公開用のランキングを作り直す)
+	s.handleAPI("/cron/shame-wall", s.corsMiddleware(validated(s.handleShameWallCron)))
+
+	// 読書会の進捗の投稿 (毎日)
+	s.handleAPI("/cron/group-report", s.corsMiddleware(validated(s.handleGroupReportCron)))
+
+	// 実績の一括判定 (時間の経過で満たす実績のため毎日)
+	s.handleAPI("/cron/achievements", s.corsMiddleware(validated(s.handleAchievementsCron)))
+
+	// 保存期間 (COMPLETED_RETENTION) を過ぎた読了本のアーカイブ (毎日)
+	s.handleAPI("/cron/archive-completed", s.corsMiddleware(validated(s.handleArchiveCompleted)))
+
+	// 煽り文の評価からテンプレートの重みを作り直す (毎日)
+	s.handleAPI("/cron/insult-weights", s.corsMiddleware(validated(s.handleInsultWeightsCron)))
+
+	// 返却予定日を過ぎた貸し出しの催促 (毎日)
+	s.handleAPI("/cron/loan-reminders", s.corsMiddleware(validated(s.handleLoanRemindersCron)))
+
+	// 図書館から借りた本の返却の催促 (毎日)
+	s.handleAPI("/cron/library-due", s.corsMiddleware(validated(s.handleLibraryDueCron)))
+
+	// 近所の図書館にあるウィッシュリストの本を、買う前に借りるよう勧める (毎週)
+	s.handleAPI("/cron/library-nudges", s.corsMiddleware(validated(s.handleLibraryNudgesCron)))
+
+	// 値下がりを見張っているウィッシュリストの本の価格の確認 (毎日)
+	s.handleAPI("/cron/wishlist-prices", s.corsMiddleware(validated(s.handleWishlistPricesCron)))
+
+	// 読み終えた本の似た読者を探して、似た読者からの推薦を作り直す (毎週)
+	s.handleAPI("/cron/similar-readers", s.corsMiddleware(validated(s.handleSimilarReadersCron)))
+
+	// Firestore のバックアップ (毎日。BACKUP_BUCKET が必要)
+	s.handleAPI("/cron/backup", s.corsMiddleware(validated(s.handleBackupCron)))
+
+	// cronの実行履歴 (GitHub Actionsのトリガーが動いているかの確認用)
+	s.handleAPI("/cron/runs", s.corsMiddleware(validated(s.handleCronRuns)))
+
+	// ダッシュボード用の集計 (読了率・平均日数・最も放置されている本など)
+	s.handleAPI("/stats", s.corsMiddleware(validated(s.handleStats)))
+	s.handleAPI("/stats/heatmap", s.corsMiddleware(validated(s.handleHeatmap)))
+	s.handleAPI("/stats/insult-variants", s.corsMiddleware(s.requireAdmin(validated(s.handleInsultVariantStats))))
+
+	// 年間の振り返り (JSON と共有用の画像)
+	s.handleAPI("/year-in-review", s.corsMiddleware(validated(s.handleYearInReview)))
+	s.handleAPI("/year-in-review/image", s.corsMiddleware(validated(s.handleYearInReviewImage)))
+
+	// ポイント (XP) の合計と増減の履歴
+	s.handleAPI("/points", s.corsMiddleware(validated(s.handlePoints)))
+
+	// 実績 (バッジ) と解除状況
+	s.handleAPI("/achievements", s.corsMiddleware(validated(s.handleAchievements)))
+
+	// プロフィール (LINE から取り込んだ表示名とアイコン)
+	s.handleAPI("/profile", s.corsMiddleware(validated(s.handleProfile)))
+
+	// ユーザーの設定 (表示名・リーダーボードへの公開)
+	s.handleAPI("/settings", s.corsMiddleware(validated(s.handleSettings)))
+
+	// 友達とのリーダーボード
+	s.handleAPI("/leaderboard", s.corsMiddleware(validated(s.handleLeaderboard)))
+
+	// 公開の恥の壁 (参加を選んだユーザーの、最も期限を過ぎた本)
+	s.handleAPI("/shame-wall", s.corsMiddleware(validated(s.handleShameWall)))
+
+	// 友達申請・招待リンク・見張り役 (期限切れの通知のコピーを受け取る友達)
+	s.handleAPI("/friends", s.corsMiddleware(validated(s.handleFriends)))
+	s.handleAPI("/friends/requests", s.corsMiddleware(validated(s.handleFriendRequest)))
+	s.handleAPI("/friends/accept", s.corsMiddleware(validated(s.handleAcceptFriend)))
+	s.handleAPI("/friends/invites", s.corsMiddleware(validated(s.handleFriendInvites)))
+	s.handleAPI("/friends/invites/accept", s.corsMiddleware(validated(s.handleAcceptFriendInvite)))
+	s.handleAPI("/friends/partner", s.corsMiddleware(validated(s.handlePartner)))
+	s.handleAPI("/friends/partner/accept", s.corsMiddleware(validated(s.handleAcceptPartner)))
+
+	// 友達に貸した本と返却の記録
+	s.handleAPI("/loans", s.corsMiddleware(validated(s.handleLoans)))
+	s.handleAPI("/loans/return", s.corsMiddleware(validated(s.handleReturnLoan)))
+
+	// 本棚の共有 (特定のユーザー・URL で読み取り専用に見せる)
+	s.handleAPI("/shelves/shares", s.corsMiddleware(validated(s.handleShelfShares)))
+	s.handleAPI("/shelves/shared", s.corsMiddleware(validated(s.handleSharedShelf)))
+
+	// 読書会 (課題本の配布と進捗)
+	s.handleAPI("/groups", s.corsMiddleware(validated(s.handleGroups)))
+	s.handleAPI("/groups/invite", s.corsMiddleware(validated(s.handleInviteToGroup)))
+	s.handleAPI("/groups/join", s.corsMiddleware(validated(s.handleJoinGroup)))
+	s.handleAPI("/groups/book", s.corsMiddleware(validated(s.handleGroupBook)))
+
+	// スクリプト・外部連携用の API キーの発行と失効 (X-API-Key で /books を使える)
+	s.handleAPI("/api-keys", s.corsMiddleware(validated(s.handleAPIKeys)))
+
+	// 本のイベントを外部に送る Webhook の登録
+	s.handleAPI("/webhooks", s.corsMiddleware(validated(s.handleWebhooks)))
+
+	// 本のステータス変更・煽りの送信のリアルタイム配信 (Server-Sent Events)
+	s.handleAPI("/events", s.corsMiddleware(validated(handleEvents)))
+
+	// ダッシュボード用の GraphQL (本・集計・煽りの履歴をまとめて取得)
+	s.mux.HandleFunc("/graphql", s.corsMiddleware(graphqlHandler))
+
+	// Pub/Sub push サブスクリプションからの期限切れイベント受信用エンドポイント
+	s.handleAPI("/pubsub/overdue", s.handleOverduePush)
+
+	// プロファイルと実行時の統計 (ADMIN_TOKEN が必要)
+	s.registerDebugRoutes()
+
+	// 運用者向けのメンテナンス (ADMIN_TOKEN が必要)
+	s.handleAPI("/admin/orphans", s.corsMiddleware(s.requireAdmin(validated(s.handleCleanupOrphans))))
+	s.handleAPI("/admin/consistency", s.corsMiddleware(s.requireAdmin(validated(s.handleCheckConsistency))))
+	s.handleAPI("/admin/backups", s.corsMiddleware(s.requireAdmin(validated(s.handleBackups))))
+	s.handleAPI("/admin/migrations", s.corsMiddleware(s.requireAdmin(validated(s.handleMigrations))))
+	s.handleAPI("/admin/users", s.corsMiddleware(s.requireAdmin(validated(s.handleAdminUsers))))
+	s.handleAPI("/admin/users/notification-failures", s.corsMiddleware(s.requireAdmin(validated(s.handleNotificationFailures))))
+	s.handleAPI("/admin/users/disable", s.corsMiddleware(s.requireAdmin(validated(s.handleDisableUser))))
+	s.handleAPI("/admin/dead-letters/redrive", s.corsMiddleware(s.requireAdmin(validated(s.handleRedriveDeadLetters))))
+	s.handleAPI("/admin/llm-usage", s.corsMiddleware(s.requireAdmin(validated(s.handleLLMUsage))))
+	s.handleAPI("/admin/prompts", s.corsMiddleware(s.requireAdmin(validated(s.handlePrompts))))
+	s.handleAPI("/admin/prompts/{name}", s.corsMiddleware(s.requireAdmin(validated(s.handlePrompt))))
+	s.handleAPI("/admin/blocklist", s.corsMiddleware(s.requireAdmin(validated(s.handleBlocklist))))
+	s.handleAPI("/admin/cron-secret/rotate", s.corsMiddleware(s.requireAdmin(validated(s.handleRotateCronSecret))))
+
+	// gRPC の BookService をリソース指向の REST として公開 (/v1/users/{userId}/books など)
+	s.mux.HandleFunc(apiVersionPrefix+"/", s.corsMiddleware(gateway.ServeHTTP))
+}
+
+// handleAPI は path を /v1 以下に登録し、旧パス (/api 以下) からも同じハンドラーに届くようにする
+func (s *Server) handleAPI(path string, handler http.HandlerFunc) {
+	s.mux.HandleFunc(apiVersionPrefix+path, handler)
+	s.mux.HandleFunc(legacyPrefix+path, s.legacyAlias)
+}
+
+// legacyAlias は旧パスへのリクエストを /v1 のパスに書き換えて処理する。
+// リダイレクトにするとCORSのプリフライトやcronのPOSTが壊れるため、サーバー内で転送する。
+// パスに {id} などを含むルートもあるので、登録したパターンではなく実際のパスを書き換える
+func (s *Server) legacyAlias(w http.ResponseWriter, r *http.Request) {
+	path := apiVersionPrefix + strings.TrimPrefix(r.URL.Path, legacyPrefix)
+	w.Header().Set("Deprecation", "true")
+	w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", path))
+
+	r2 := r.Clone(r.Context())
+	r2.URL.Path = path
+	r2.URL.RawPath = ""
+	s.mux.ServeHTTP(w, r2)
+}
+
+// validated はリクエストを OpenAPI 定義で検証してからハンドラーを呼ぶ
+func validated(next http.HandlerFunc) http.HandlerFunc {
+	return apiSpec.Middleware(writeRequestValidationError)(next).ServeHTTP
+}
+
+var apiSpec *openapi.Spec // OpenAPI定義 (配信とリクエスト検証に使う)
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"tundoku-killer/backend/internal/store"
+)
+
+// 負荷試験や UI の開発用に、ステータスや期限のばらけた架空のユーザーと本を生成する。
+// go run . seed -users 200 -books 30 (make seed) のように使う
+
+// SeedOptions は Seed で生成するデータの量
+type SeedOptions struct {
+	Users        int   // 生成する架空のユーザーの数 (固定のサンプルとは別)
+	BooksPerUser int   // ユーザー1人あたりの本の数の上限 (0〜BooksPerUser 冊をランダムに作る)
+	RandomSeed   int64 // 同じ値なら同じデータになる
+	// AllowProject はエミュレーターではなく実際のプロジェクトへの投入を許す。APP_ENV=production では常に拒否する
+	AllowProject bool
+}
+
+var (
+	seedTitles = []string{
+		"罪と罰", "存在と時間", "資本論", "百年の孤独", "純粋理性批判", "戦争と平和", "ユリシーズ",
+		"白鯨", "源氏物語", "細雪", "ドン・キホーテ", "利己的な遺伝子", "銃・病原菌・鉄", "道は開ける",
+		"ファスト&スロー", "達人プログラマー", "計算機プログラムの構造と解釈", "イシューからはじめよ",
+	}
+	seedAuthors = []string{
+		"ドストエフスキー", "ハイデガー", "マルクス", "ガルシア=マルケス", "カント", "トルストイ", "ジョイス",
+		"メルヴィル", "紫式部", "谷崎潤一郎", "セルバンテス", "ドーキンス", "ダイアモンド", "カーネギー",
+	}
+	seedNames = []string{"", "積読仙人", "読了見習い", "本棚の主", "明日から読む", "ブックオフ常連"}
+)
+
+// Seed は固定のサンプルのユーザーと本を入れ、続けて opts の数だけ架空のユーザーと本を生成して入れる。
+// IDは "fake-user-0001"・"fake-book-0001-001" のように決まった形で、同じ opts なら上書きして同じ状態になる
+func (s *Server) Seed(ctx context.Context, opts SeedOptions) error {
+	if s.cfg.Production {
+		return errors.New("refusing to seed: APP_ENV is production")
+	}
+	if !s.cfg.Firebase.UsingEmulator() && !opts.AllowProject {
+		return errors.New("refusing to seed: FIRESTORE_EMULATOR_HOST is not set (pass -allow-project to seed a dev project)")
+	}
+
+	now := time.Now()
+	if err := s.seedDemo(ctx, now); err != nil {
+		return err
+	}
+
+	rng := rand.New(rand.NewSource(opts.RandomSeed))
+	books := 0
+	for u := 1; u <= opts.Users; u++ {
+		userID := fmt.Sprintf("fake-user-%04d", u)
+		createdAt := now.AddDate(0, 0, -rng.Intn(365))
+		if err := s.userRepo.SaveProfile(ctx, store.UserProfile{
+			UserID:      userID,
+			DisplayName: seedNames[rng.Intn(len(seedNames))],
+			Provider:    "line",
+			CreatedAt:   createdAt,
+			UpdatedAt:   now,
+		}); err != nil {
+			return err
+		}
+		if err := s.userRepo.SaveSettings(ctx, store.UserSettings{
+			UserID:             userID,
+			LeaderboardVisible: rng.Intn(2) == 0,
+			UpdatedAt:          now,
+		}); err != nil {
+			return err
+		}
+
+		n := 0
+		if opts.BooksPerUser > 0 {
+			n = rng.Intn(opts.BooksPerUser + 1)
+		}
+		for b := 1; b <= n; b++ {
+			book := fakeBook(rng, now)
+			book.BookID = fmt.Sprintf("fake-book-%04d-%03d", u, b)
+			book.UserID = userID
+			if err := s.bookRepo.Update(ctx, book); err != nil {
+				return err
+			}
+			books++
+		}
+		if u%100 == 0 {
+			s.logger.Printf("Seeded %d/%d fake users (%d books)", u, opts.Users, books)
+		}
+	}
+
+	s.logger.Printf("Seeded %d fake users and %d books", opts.Users, books)
+	return nil
+}
+
+// fakeBook はステータスに見合った期限・煽りレベル・読了日時を持つ本を1冊作る。
+// 未読 40%・読書中 15%・煽られ中 20%・読了 25% の割合
+func fakeBook(rng *rand.Rand, now time.Time) store.Book {
+	createdAt := now.AddDate(0, 0, -rng.Intn(180)-1)
+	book := store.Book{
+		Title:     seedTitles[rng.Intn(len(seedTitles))],
+		Author:    seedAuthors[rng.Intn(len(seedAuthors))],
+		Pages:     100 + rng.Intn(900),
+		CreatedAt: &createdAt,
+	}
+	if rng.Intn(3) == 0 {
+		book.Price = 500 + rng.Intn(40)*100
+	}
+
+	switch p := rng.Intn(100); {
+	case p < 40:
+		book.Status = "unread"
+		book.Deadline = now.AddDate(0, 0, rng.Intn(90)-30) // 期限切れ間近・切れたばかりを含む
+	case p < 55:
+		book.Status = "reading"
+		book.Deadline = now.AddDate(0, 0, rng.Intn(60)+1)
+	case p < 75:
+		book.Status = "insulted"
+		book.Deadline = now.AddDate(0, 0, -rng.Intn(120)-1)
+		book.InsultLevel = 1 + rng.Intn(20)
+	default:
+		book.Status = "completed"
+		book.Deadline = createdAt.AddDate(0, 0, 7+rng.Intn(60))
+		completedAt := createdAt.Add(time.Duration(rng.Int63n(int64(now.Sub(createdAt)))))
+		book.CompletedAt = &completedAt
+		book.InsultLevel = rng.Intn(3)
+	}
+	return book
+}
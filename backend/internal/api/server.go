@@ -0,0 +1,286 @@
+// Package api は HTTP/gRPC/GraphQL のハンドラー。Server がクライアントと設定を持ち、
+// ルート登録・イベント購読・定期実行のエンドポイントまでをまとめる。main はこれを組み立てて起動するだけ
+package api
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/storage"
+	firebase "firebase.google.com/go/v4"
+	firestoreadmin "google.golang.org/api/firestore/v1"
+	pubsub "google.golang.org/api/pubsub/v1"
+
+	"tundoku-killer/backend/internal/analytics"
+	"tundoku-killer/backend/internal/cache"
+	"tundoku-killer/backend/internal/config"
+	"tundoku-killer/backend/internal/cron"
+	"tundoku-killer/backend/internal/insult"
+	"tundoku-killer/backend/internal/line"
+	"tundoku-killer/backend/internal/openapi"
+	"tundoku-killer/backend/internal/recommend"
+	"tundoku-killer/backend/internal/secrets"
+	"tundoku-killer/backend/internal/store"
+)
+
+// Server はハンドラーが使うクライアント・設定・ロガー・通知手段をまとめたもの。
+// ハンドラーはこのメソッドとして登録する。テストではフェイクのリポジトリや line.Console を入れた
+// Server を直接組み立てれば、Firebase なしでハンドラーを単体で動かせる
+type Server struct {
+	cfg     config.Config
+	secrets *secrets.Store // 回転する秘密情報 (LINE のトークン・CRON_SECRET) の最新の値。nil なら cfg の値を使う
+	logger  *log.Logger
+	mux     *http.ServeMux
+
+	firebaseApp     *firebase.App
+	firestoreClient *firestore.Client
+	sqlDB           *sql.DB              // STORAGE_BACKEND が SQL のときだけ
+	bookRepo        store.BookRepository // 書き換えると shelfVersions も進む (store.VersionedBookRepository)
+	userRepo        store.UserRepository
+	shelfVersions   store.ShelfVersions
+	cache           cache.Cache // CACHE_BACKEND 未設定なら cache.Nop
+
+	lineMessenger   line.Messenger
+	insultGenerator insult.Generator
+	insultVariants  *insult.Experiment // INSULT_VARIANTS 未設定時は無効 (insultGenerator だけを使う)
+	llmProviders    *insult.Failover   // ai-* の煽り文を書かせる生成AI (LLM_PROVIDERS の順)
+	prompts         *promptStore       // 生成AIへの指示 (prompts コレクション)
+	blocklist       *globalBlocklist   // 全員分の煽り文に入れない語句 (blocklists/global)
+	teaser          insult.Teaser      // 登録した本の紹介文 (BOOK_TEASERS)
+	quiz            insult.Quiz        // 難しいモードの読了クイズ
+	negotiator      insult.Negotiator  // 期限の交渉
+	recommender     recommend.Ranker   // 次に読む本の推薦 (いまは recommend.Heuristic)
+
+	cron cron.State // cron のロック・再開位置・実行履歴
+
+	pubsubService *pubsub.Service // PUBSUB_TOPIC 未設定時は nil (同期処理にフォールバック)
+	pubsubTopic   string          // "projects/{project}/topics/{topic}" 形式のトピック名
+
+	backupService  *firestoreadmin.Service // BACKUP_BUCKET 未設定時は nil
+	backupDatabase string                  // "projects/{project}/databases/(default)"
+
+	storageClient *storage.Client       // PROOF_BUCKET 未設定時は nil
+	proofBucket   *storage.BucketHandle // 読了の証拠写真を置くバケット
+	proofPrefix   string                // バケット内のオブジェクト名の接頭辞 ("" なら直下)
+
+	analytics *analytics.Writer // ANALYTICS_SINK 未設定時は nil (何も記録しない)
+
+	cors corsConfig
+}
+
+// NewServer は設定から Firebase・保存先・Pub/Sub のクライアントを作って Server を組み立てる。
+// sec は cfg を読み込んだ秘密情報の Store で、回転する値はここから読み直す。使い終わったら Close する
+func NewServer(ctx context.Context, cfg config.Config, sec *secrets.Store, logger *log.Logger) (*Server, error) {
+	s := &Server{
+		cfg:           cfg,
+		secrets:       sec,
+		logger:        logger,
+		mux:           http.NewServeMux(),
+		lineMessenger: line.NewMessenger(cfg.LINE, sec.Source("LINE_CHANNEL_ACCESS_TOKEN"), tracedHTTPClient, logger),
+		cors:          newCORSConfig(cfg.CORS, cfg.Production),
+		recommender:   recommend.Heuristic{},
+	}
+
+	// Firebase Admin SDK の初期化 (FIRESTORE_EMULATOR_HOST があればエミュレーターにつなぐ)
+	var err error
+	s.firebaseApp, err = newFirebaseApp(ctx, cfg.Firebase)
+	if err != nil {
+		return nil, fmt.Errorf("error initializing app: %w", err)
+	}
+	s.firestoreClient, err = s.firebaseApp.Firestore(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error getting Firestore client: %w", err)
+	}
+	s.cron = cron.State{Client: s.firestoreClient, Logger: logger}
+
+	// 用意された煽り文は、評価から計算した重み (/cron/insult-weights) で選ぶ
+	weights := &insultWeightStore{client: s.firestoreClient}
+	s.insultGenerator = insult.New(cfg.InsultGenerator, weights)
+	s.llmProviders = insult.NewProviders(cfg.Insult, sec.Source, tracedHTTPClient, logger)
+	s.prompts = &promptStore{client: s.firestoreClient}
+	s.blocklist = &globalBlocklist{client: s.firestoreClient}
+	meter := &llmUsageMeter{client: s.firestoreClient, budget: cfg.Insult.MonthlyBudget, logger: logger}
+	ai := insult.LLM{
+		Providers: s.llmProviders,
+		Prompts:   s.prompts,
+		Meter:     meter,
+		Moderator: insult.DefaultModerator(),
+	}
+	s.teaser = insult.Teaser{Providers: s.llmProviders, Prompts: s.prompts, Meter: meter, Moderator: ai.Moderator}
+	s.quiz = insult.Quiz{Providers: s.llmProviders, Meter: meter}
+	s.negotiator = insult.Negotiator{Providers: s.llmProviders, Meter: meter, Moderator: ai.Moderator}
+	s.insultVariants = insult.NewExperiment(cfg.Insult, ai, weights, logger)
+
+	// 本・ユーザーの保存先。STORAGE_BACKEND=postgres/sqlite なら SQL に保存する (それ以外の機能は Firestore のまま)
+	switch backend := cfg.Storage.Backend; backend {
+	case "firestore":
+		s.bookRepo = store.NewFirestoreBookRepository(s.firestoreClient)
+		s.userRepo = store.NewFirestoreUserRepository(s.firestoreClient)
+		s.shelfVersions = store.NewFirestoreShelfVersions(s.firestoreClient)
+	default:
+		repos, err := store.OpenSQLRepositories(ctx, cfg.Storage)
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("error initializing %s storage: %w", backend, err)
+		}
+		s.sqlDB = repos.DB
+		s.bookRepo, s.userRepo, s.shelfVersions = repos.Books, repos.Users, repos.Versions
+		logger.Printf("Books and users are stored in %s", backend)
+	}
+
+	// CACHE_BACKEND=redis なら本・一覧・設定・プロフィールの読み取りを Redis に置く
+	s.cache, err = cache.New(ctx, cfg.Cache)
+	if err != nil {
+		s.Close()
+		return nil, fmt.Errorf("error initializing cache: %w", err)
+	}
+	if cfg.Cache.Backend == "redis" {
+		s.bookRepo = store.CachedBookRepository{BookRepository: s.bookRepo, Cache: s.cache, TTL: cfg.Cache.TTL}
+		s.userRepo = store.CachedUserRepository{UserRepository: s.userRepo, Cache: s.cache, TTL: cfg.Cache.TTL}
+		logger.Printf("Caching reads in Redis (TTL %s)", cfg.Cache.TTL)
+	}
+	s.bookRepo = store.VersionedBookRepository{BookRepository: s.bookRepo, Versions: s.shelfVersions}
+	// cron が本ごとに読む所持者の設定は、プロセス内に短い間だけ置く
+	s.userRepo = store.NewSettingsCache(s.userRepo, cfg.Cache.SettingsTTL)
+
+	// Pub/Sub の初期化 (期限切れ処理の非同期化)
+	if err := s.initPubSub(ctx); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("error initializing Pub/Sub: %w", err)
+	}
+
+	// Firestore のバックアップ (エクスポート)
+	if err := s.initBackups(ctx); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("error initializing backups: %w", err)
+	}
+
+	// 読了の証拠写真 (Cloud Storage)
+	if err := s.initProofs(ctx); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("error initializing photo proofs: %w", err)
+	}
+
+	// プロダクトイベントの書き込み (BigQuery・Firestore)
+	if err := s.initAnalytics(ctx); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("error initializing analytics: %w", err)
+	}
+	return s, nil
+}
+
+// Close は溜まったプロダクトイベントを書き出してから、Firestore・SQL・Redis・Cloud Storage の接続を閉じる
+func (s *Server) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), analyticsCloseTimeout)
+	defer cancel()
+	err := s.analytics.Close(ctx)
+	if s.sqlDB != nil {
+		if cerr := s.sqlDB.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if c, ok := s.cache.(io.Closer); ok {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if s.storageClient != nil {
+		if cerr := s.storageClient.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if s.firestoreClient != nil {
+		if cerr := s.firestoreClient.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Handler は OpenAPI定義の読み込み・イベント購読者の登録・gRPC/GraphQL の初期化とルート登録を行い、
+// HTTPサーバーに渡すハンドラーを返す
+func (s *Server) Handler(ctx context.Context) (http.Handler, error) {
+	// OpenAPI定義の読み込み
+	spec, err := openapi.Load(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error loading OpenAPI spec: %w", err)
+	}
+	apiSpec = spec
+
+	// SSE・Webhook・煽りの履歴などをドメインイベントの購読者として登録
+	s.registerEventSubscribers(eventBus)
+
+	// gRPC サーバーと、BookService を REST で公開する grpc-gateway
+	grpcServer := s.newGRPCServer()
+	gateway, err := s.newGatewayHandler(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error initializing grpc-gateway: %w", err)
+	}
+
+	// ダッシュボード用の GraphQL
+	graphqlHandler, err := s.newGraphQLHandler()
+	if err != nil {
+		return nil, fmt.Errorf("error initializing GraphQL: %w", err)
+	}
+
+	s.registerRoutes(gateway, graphqlHandler)
+	return serveGRPC(grpcServer, traceHandler(requestIDMiddleware(compressMiddleware(s.mux)))), nil
+}
+
+// NewHTTPServer は HOST/PORT とタイムアウト系の設定から http.Server を組み立てる
+func NewHTTPServer(c config.HTTPConfig, handler http.Handler) *http.Server {
+	// gRPC を同じポートで受けるため、TLSなしの HTTP/2 (h2c) も受け付ける
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetUnencryptedHTTP2(true)
+
+	return &http.Server{
+		Protocols:         protocols,
+		Addr:              net.JoinHostPort(c.Host, c.Port),
+		Handler:           limitBody(c.MaxBodyBytes, handler),
+		ReadTimeout:       c.ReadTimeout,
+		ReadHeaderTimeout: c.ReadHeaderTimeout,
+		WriteTimeout:      c.WriteTimeout,
+		IdleTimeout:       c.IdleTimeout,
+		MaxHeaderBytes:    c.MaxHeaderBytes,
+	}
+}
+
+// routeBodyLimits は MAX_BODY_BYTES より大きなボディを受け付けるルート (/v1 を除いたパス) とその上限。
+// エクスポートしたファイルをそのまま受け取る取り込みは、件数の上限まで入るだけの大きさを許す
+var routeBodyLimits = map[string]int64{
+	"/books/import/goodreads": maxImportBodyBytes,
+	"/books/import/kindle":    maxKindleBodyBytes,
+}
+
+// bodyLimit は path へのリクエストボディの上限。routeBodyLimits に無いルートは maxBytes
+func bodyLimit(path string, maxBytes int64) int64 {
+	route := strings.TrimPrefix(path, apiVersionPrefix)
+	if route == path {
+		route = strings.TrimPrefix(path, legacyPrefix)
+	}
+	if n, ok := routeBodyLimits[route]; ok && n > maxBytes {
+		return n
+	}
+	return maxBytes
+}
+
+// limitBody はリクエストボディを maxBytes (routeBodyLimits のルートはその上限) までに制限する。超えた場合、読み込み時にエラーになる
+func limitBody(maxBytes int64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		maxBytes := bodyLimit(r.URL.Path, maxBytes)
+		if r.ContentLength > maxBytes {
+			writeProblem(w, r, http.StatusRequestEntityTooLarge, "Request body too large")
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"tundoku-killer/backend/internal/store"
+)
+
+// 読書の記録 (何ページ読んだか。オーディオブックは何分聞いたか)。readingSessions/{sessionId} に保存し、
+// 読書のペースと本ごとの読了見込み (pace.go) に使う
+
+// ReadingSession は1回分の読書の記録
+type ReadingSession struct {
+	SessionID string    `json:"sessionId" firestore:"sessionId"`
+	UserID    string    `json:"userId" firestore:"userId"`
+	BookID    string    `json:"bookId" firestore:"bookId"`
+	Pages     int       `json:"pages" firestore:"pages"`
+	Minutes   int       `json:"minutes,omitempty" firestore:"minutes,omitempty"`
+	ReadAt    time.Time `json:"readAt" firestore:"readAt"`
+}
+
+// progress は session で book がどれだけ進んだか。オーディオブックは聞いた分数、それ以外はページ数
+func (session ReadingSession) progress(book store.Book) int {
+	if book.Audiobook() {
+		return session.Minutes
+	}
+	return session.Pages
+}
+
+// handleReadingSessions は読書の記録を保存する (POST)。未読の本なら「読書中」にする
+func (s *Server) handleReadingSessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req readingSessionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid JSON body: %v", err))
+		return
+	}
+	if err := req.Validate(); err != nil {
+		writeValidationError(w, r, err)
+		return
+	}
+	ctx := r.Context()
+	book, err := s.ownedBook(ctx, req.BookID, req.UserID)
+	if err != nil {
+		writeBookError(w, r, err, "Failed to retrieve book")
+		return
+	}
+	if book.Status == "completed" {
+		writeProblem(w, r, http.StatusConflict, "Book is already completed")
+		return
+	}
+	if err := validateProgress(book, req.Pages, req.Minutes); err != nil {
+		writeValidationError(w, r, err)
+		return
+	}
+
+	session, err := s.logReadingSession(ctx, book, req.Pages, req.Minutes)
+	if err != nil {
+		writeServerError(w, r, err, "Failed to save reading session")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(session)
+}
+
+// progressText は book の進み具合 n を表示用にする ("120ページ"・"1時間30分")
+func progressText(book store.Book, n int) string {
+	if !book.Audiobook() {
+		return fmt.Sprintf("%dページ", n)
+	}
+	switch h, m := n/60, n%60; {
+	case h == 0:
+		return fmt.Sprintf("%d分", m)
+	case m == 0:
+		return fmt.Sprintf("%d時間", h)
+	default:
+		return fmt.Sprintf("%d時間%d分", h, m)
+	}
+}
+
+// logReadingSession は book を pages ページ (minutes 分) 読んだ記録を保存し、未読の本なら「読書中」にする。
+// オーディオブックのページ数は意味がないので記録しない
+func (s *Server) logReadingSession(ctx context.Context, book store.Book, pages, minutes int) (ReadingSession, error) {
+	if book.Audiobook() {
+		pages = 0
+	}
+	ref := s.firestoreClient.Collection("readingSessions").NewDoc()
+	session := ReadingSession{
+		SessionID: ref.ID,
+		UserID:    book.UserID,
+		BookID:    book.BookID,
+		Pages:     pages,
+		Minutes:   minutes,
+		ReadAt:    time.Now(),
+	}
+	if _, err := ref.Set(ctx, session); err != nil {
+		return ReadingSession{}, fmt.Errorf("error saving reading session: %w", err)
+	}
+	if book.Status == "unread" {
+		reading := "reading"
+		if err := s.bookRepo.Patch(ctx, book.BookID, store.BookPatch{Status: &reading}); err != nil {
+			s.logger.Printf("Error marking book %s as reading: %v", book.BookID, err)
+		} else {
+			updated := book
+			updated.Status = reading
+			eventBus.Publish(ctx, BookUpdated{Book: updated, Previous: book})
+		}
+	}
+	eventBus.Publish(ctx, SessionLogged{Session: session})
+	return session, nil
+}
+
+// readingSessions は userID の読書の記録をすべて返す
+func (s *Server) readingSessions(ctx context.Context, userID string) ([]ReadingSession, error) {
+	docs, err := s.firestoreClient.Collection("readingSessions").Where("userId", "==", userID).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("error fetching reading sessions: %w", err)
+	}
+	sessions := make([]ReadingSession, 0, len(docs))
+	for _, doc := range docs {
+		var session ReadingSession
+		if err := doc.DataTo(&session); err != nil {
+			s.logger.Printf("Error parsing reading session %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
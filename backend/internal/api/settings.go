@@ -0,0 +1,133 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"tundoku-killer/backend/internal/store"
+	"tundoku-killer/backend/internal/validation"
+)
+
+// getSettings は userID の設定を返す。未設定ならゼロ値
+func (s *Server) getSettings(ctx context.Context, userID string) (store.UserSettings, error) {
+	settings, err := s.userRepo.GetSettings(ctx, userID)
+	if err != nil {
+		return store.UserSettings{}, err
+	}
+
+	if settings.DisplayName == "" {
+		profile, err := s.getProfile(ctx, userID)
+		if err != nil {
+			s.logger.Printf("Error fetching profile for %s: %v", userID, err)
+		}
+		settings.ProfileName = profile.DisplayName
+	}
+	return settings, nil
+}
+
+// settingsPatch は設定の更新 (PUT)。送られなかった (null の) 項目は今の設定のまま変えない。
+// 後から増えた項目を知らないクライアントが保存しても、その項目をゼロ値で上書きしないようにする
+type settingsPatch struct {
+	UserID              string    `json:"userId"`
+	DisplayName         *string   `json:"displayName"`
+	LeaderboardVisible  *bool     `json:"leaderboardVisible"`
+	ShameWall           *string   `json:"shameWall"`
+	HardMode            *bool     `json:"hardMode"`
+	PurchaseBanLimit    *int      `json:"purchaseBanLimit"`
+	UnreadHardCap       *int      `json:"unreadHardCap"`
+	BlockedTerms        *[]string `json:"blockedTerms"`
+	LibrarySystems      *[]string `json:"librarySystems"`
+	ShareReadingHistory *bool     `json:"shareReadingHistory"`
+}
+
+// apply は p で送られた項目だけを settings に上書きする
+func (p settingsPatch) apply(settings *store.UserSettings) {
+	if p.DisplayName != nil {
+		settings.DisplayName = *p.DisplayName
+	}
+	if p.LeaderboardVisible != nil {
+		settings.LeaderboardVisible = *p.LeaderboardVisible
+	}
+	if p.ShameWall != nil {
+		settings.ShameWall = *p.ShameWall
+	}
+	if p.HardMode != nil {
+		settings.HardMode = *p.HardMode
+	}
+	if p.PurchaseBanLimit != nil {
+		settings.PurchaseBanLimit = *p.PurchaseBanLimit
+	}
+	if p.UnreadHardCap != nil {
+		settings.UnreadHardCap = *p.UnreadHardCap
+	}
+	if p.BlockedTerms != nil {
+		settings.BlockedTerms = *p.BlockedTerms
+	}
+	if p.LibrarySystems != nil {
+		settings.LibrarySystems = *p.LibrarySystems
+	}
+	if p.ShareReadingHistory != nil {
+		settings.ShareReadingHistory = *p.ShareReadingHistory
+	}
+}
+
+// handleSettings は設定の取得 (GET ?userId=) と更新 (PUT。送られた項目だけを変える) を行う
+func (s *Server) handleSettings(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		userID := r.URL.Query().Get("userId")
+		if userID == "" {
+			writeProblem(w, r, http.StatusBadRequest, "userId query parameter is required")
+			return
+		}
+		settings, err := s.getSettings(r.Context(), userID)
+		if err != nil {
+			writeServerError(w, r, err, "Failed to retrieve settings")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(settings)
+
+	case http.MethodPut:
+		var patch settingsPatch
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+			writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid JSON body: %v", err))
+			return
+		}
+		if patch.UserID == "" {
+			writeValidationError(w, r, validation.Errors{{Field: "userId", Message: "is required"}})
+			return
+		}
+		settings, err := s.userRepo.GetSettings(r.Context(), patch.UserID)
+		if err != nil {
+			writeServerError(w, r, err, "Failed to retrieve settings")
+			return
+		}
+		patch.apply(&settings)
+		if err := validateSettings(settings); err != nil {
+			writeValidationError(w, r, err)
+			return
+		}
+
+		settings.UpdatedAt = time.Now()
+		if err := s.userRepo.SaveSettings(r.Context(), settings); err != nil {
+			writeServerError(w, r, err, "Failed to save settings")
+			return
+		}
+		s.logger.Printf("Settings updated for user %s", settings.UserID)
+		if !settings.ShareReadingHistory {
+			if err := s.forgetSimilarReaders(r.Context(), settings.UserID); err != nil {
+				s.logger.Printf("Error deleting similar reader recommendations for %s: %v", settings.UserID, err)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(settings)
+
+	default:
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"tundoku-killer/backend/internal/store"
+)
+
+func TestSettingsPatchApply(t *testing.T) {
+	current := store.UserSettings{
+		UserID:              "u1",
+		DisplayName:         "積読家",
+		LeaderboardVisible:  true,
+		HardMode:            true,
+		PurchaseBanLimit:    10,
+		UnreadHardCap:       20,
+		BlockedTerms:        []string{"体重"},
+		LibrarySystems:      []string{"Tokyo_Setagaya"},
+		ShareReadingHistory: true,
+	}
+	tests := []struct {
+		name string
+		body string
+		want func(*store.UserSettings)
+	}{
+		{
+			name: "送らなかった項目は変えない",
+			body: `{"userId":"u1","displayName":"新しい名前"}`,
+			want: func(s *store.UserSettings) { s.DisplayName = "新しい名前" },
+		},
+		{
+			name: "ゼロ値を送れば消せる",
+			body: `{"userId":"u1","hardMode":false,"unreadHardCap":0,"blockedTerms":[]}`,
+			want: func(s *store.UserSettings) { s.HardMode, s.UnreadHardCap, s.BlockedTerms = false, 0, []string{} },
+		},
+		{
+			name: "null は送らなかったのと同じ",
+			body: `{"userId":"u1","purchaseBanLimit":null,"librarySystems":null}`,
+			want: func(*store.UserSettings) {},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var patch settingsPatch
+			if err := json.Unmarshal([]byte(tt.body), &patch); err != nil {
+				t.Fatal(err)
+			}
+			got := current
+			got.BlockedTerms = append([]string(nil), current.BlockedTerms...)
+			patch.apply(&got)
+			want := current
+			tt.want(&want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("apply = %+v; want %+v", got, want)
+			}
+		})
+	}
+}
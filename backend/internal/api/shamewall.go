@@ -0,0 +1,164 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"tundoku-killer/backend/internal/cron"
+	"tundoku-killer/backend/internal/store"
+)
+
+// 公開の「恥の壁」。設定で参加を選んだユーザーの本のうち、最も期限を過ぎているものを並べる。
+// 全ユーザーの本を読むので公開APIでは集計せず、cron が shameWall/latest に保存したものを返す
+
+const (
+	shameWallAnonymous = "anonymous"
+	shameWallNamed     = "named"
+
+	// shameWallSize は恥の壁に載せる本の数
+	shameWallSize = 20
+	// shameWallLease は恥の壁の集計の二重実行を防ぐロックの名前
+	shameWallLease = "shameWall"
+	// anonymousDisplayName は名前を伏せて参加しているユーザーの表示名
+	anonymousDisplayName = "匿名の積読家"
+)
+
+// ShameWall は集計済みの恥の壁
+type ShameWall struct {
+	Entries    []ShameWallEntry `json:"entries" firestore:"entries"`
+	ComputedAt time.Time        `json:"computedAt" firestore:"computedAt"`
+}
+
+// ShameWallEntry は恥の壁の1冊。ユーザーIDは載せない
+type ShameWallEntry struct {
+	Rank        int       `json:"rank" firestore:"rank"`
+	DisplayName string    `json:"displayName" firestore:"displayName"`
+	Title       string    `json:"title" firestore:"title"`
+	Author      string    `json:"author" firestore:"author"`
+	Deadline    time.Time `json:"deadline" firestore:"deadline"`
+	DaysOverdue float64   `json:"daysOverdue" firestore:"daysOverdue"`
+	InsultLevel int       `json:"insultLevel" firestore:"insultLevel"`
+}
+
+// handleShameWall は最後に集計した恥の壁を返す。まだ集計していなければ空
+func (s *Server) handleShameWall(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	wall := ShameWall{Entries: []ShameWallEntry{}}
+	doc, err := s.firestoreClient.Collection("shameWall").Doc("latest").Get(r.Context())
+	if err != nil && status.Code(err) != codes.NotFound {
+		writeServerError(w, r, err, "Failed to retrieve shame wall")
+		return
+	}
+	if err == nil {
+		if err := doc.DataTo(&wall); err != nil {
+			writeServerError(w, r, err, "Failed to parse shame wall")
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(wall)
+}
+
+// handleShameWallCron は恥の壁を集計し直して shameWall/latest に保存する
+func (s *Server) handleShameWallCron(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodGet {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	ctx := context.WithoutCancel(r.Context())
+
+	if !s.authorizeCron(r) {
+		writeProblem(w, r, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	runID := uuid.NewString()
+	if err := s.cron.AcquireLease(ctx, shameWallLease, runID, cron.LeaseTTL); err != nil {
+		if errors.Is(err, cron.ErrLeaseHeld) {
+			writeProblem(w, r, http.StatusConflict, "Another shame wall aggregation is already running")
+			return
+		}
+		writeServerError(w, r, err, "Failed to acquire lock")
+		return
+	}
+	defer s.cron.ReleaseLease(ctx, shameWallLease, runID)
+
+	wall, err := s.computeShameWall(ctx, time.Now())
+	if err != nil {
+		writeServerError(w, r, err, "Failed to compute shame wall")
+		return
+	}
+	if _, err := s.firestoreClient.Collection("shameWall").Doc("latest").Set(ctx, wall); err != nil {
+		writeServerError(w, r, err, "Failed to save shame wall")
+		return
+	}
+
+	s.logger.Printf("Shame wall updated: %d entries", len(wall.Entries))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(wall)
+}
+
+// computeShameWall は参加しているユーザーの期限切れの本を、期限を過ぎた日数の長い順に並べる
+func (s *Server) computeShameWall(ctx context.Context, now time.Time) (ShameWall, error) {
+	docs, err := s.firestoreClient.Collection("userSettings").
+		Where("shameWall", "in", []string{shameWallAnonymous, shameWallNamed}).
+		Documents(ctx).GetAll()
+	if err != nil {
+		return ShameWall{}, err
+	}
+
+	entries := []ShameWallEntry{}
+	for _, doc := range docs {
+		var settings store.UserSettings
+		if err := doc.DataTo(&settings); err != nil {
+			s.logger.Printf("Error parsing settings %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		displayName := anonymousDisplayName
+		if settings.ShameWall == shameWallNamed {
+			displayName = settings.Name()
+		}
+
+		books, err := s.listBooks(ctx, doc.Ref.ID)
+		if err != nil {
+			return ShameWall{}, err
+		}
+		for _, book := range books {
+			if !isOverdue(book, now) {
+				continue
+			}
+			entries = append(entries, ShameWallEntry{
+				DisplayName: displayName,
+				Title:       book.Title,
+				Author:      book.Author,
+				Deadline:    book.Deadline,
+				DaysOverdue: daysBetween(book.Deadline, now),
+				InsultLevel: book.InsultLevel,
+			})
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].DaysOverdue > entries[j].DaysOverdue })
+	if len(entries) > shameWallSize {
+		entries = entries[:shameWallSize]
+	}
+	for i := range entries {
+		entries[i].Rank = i + 1
+	}
+	return ShameWall{Entries: entries, ComputedAt: now}, nil
+}
@@ -0,0 +1,227 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"tundoku-killer/backend/internal/store"
+)
+
+// 本棚の共有。特定のユーザーに見せるか、トークン付きの URL で誰にでも見せる (どちらも読み取り専用)。
+// 共有の設定 (ACL) は shelfShares に保存し、本の一覧を返すときに sharedShelf で確認する
+
+var errShelfNotShared = errors.New("shelf is not shared with this viewer")
+
+// ShelfShare は本棚の共有設定。ユーザーへの共有は shelfShares/{ownerId}__{viewerId}、
+// URL での共有は shelfShares/{token} に保存する
+type ShelfShare struct {
+	ShareID   string    `json:"shareId" firestore:"shareId"`
+	OwnerID   string    `json:"ownerId" firestore:"ownerId"`
+	ViewerID  string    `json:"viewerId,omitempty" firestore:"viewerId"` // 空なら URL での共有で、ShareID がトークン
+	CreatedAt time.Time `json:"createdAt" firestore:"createdAt"`
+}
+
+func userShareID(ownerID, viewerID string) string {
+	return ownerID + "__" + viewerID
+}
+
+// sharedShelf は viewerID に見せてよければ ownerID の本を返す。
+// viewerID が空か本人なら確認しない
+func (s *Server) sharedShelf(ctx context.Context, ownerID, viewerID string) ([]store.Book, error) {
+	if viewerID != "" && viewerID != ownerID {
+		if err := s.checkShelfShare(ctx, userShareID(ownerID, viewerID), ownerID); err != nil {
+			return nil, err
+		}
+	}
+	return s.listBooks(ctx, ownerID)
+}
+
+func (s *Server) checkShelfShare(ctx context.Context, shareID, ownerID string) error {
+	doc, err := s.firestoreClient.Collection("shelfShares").Doc(shareID).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return errShelfNotShared
+	}
+	if err != nil {
+		return fmt.Errorf("error fetching shelf share: %w", err)
+	}
+	var share ShelfShare
+	if err := doc.DataTo(&share); err != nil {
+		return fmt.Errorf("error parsing shelf share: %w", err)
+	}
+	if share.OwnerID != ownerID {
+		return errShelfNotShared
+	}
+	return nil
+}
+
+// handleSharedShelf は URL で共有された本棚を返す (GET ?token=)。ログインしていない人も見られる
+func (s *Server) handleSharedShelf(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	ctx := r.Context()
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		writeProblem(w, r, http.StatusBadRequest, "token query parameter is required")
+		return
+	}
+
+	doc, err := s.firestoreClient.Collection("shelfShares").Doc(token).Get(ctx)
+	var share ShelfShare
+	if err == nil {
+		err = doc.DataTo(&share)
+	}
+	if status.Code(err) == codes.NotFound || (err == nil && share.ViewerID != "") {
+		writeProblem(w, r, http.StatusNotFound, "Shared shelf not found")
+		return
+	}
+	if err != nil {
+		writeServerError(w, r, err, "Failed to retrieve shared shelf")
+		return
+	}
+
+	books, err := s.listBooks(ctx, share.OwnerID)
+	if err != nil {
+		writeServerError(w, r, err, "Failed to retrieve books")
+		return
+	}
+	settings, err := s.getSettings(ctx, share.OwnerID)
+	if err != nil {
+		s.logger.Printf("Error fetching settings for %s: %v", share.OwnerID, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"displayName": settings.Name(),
+		"books":       books,
+	})
+}
+
+// handleShelfShares は共有設定の一覧 (GET ?userId=)・追加 (POST)・取り消し (DELETE) を行う。
+// POST で viewerId を省略すると URL での共有になる
+func (s *Server) handleShelfShares(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.handleListShelfShares(w, r)
+	case http.MethodPost:
+		s.handleCreateShelfShare(w, r)
+	case http.MethodDelete:
+		s.handleDeleteShelfShare(w, r)
+	default:
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+func (s *Server) handleListShelfShares(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := r.URL.Query().Get("userId")
+	if userID == "" {
+		writeProblem(w, r, http.StatusBadRequest, "userId query parameter is required")
+		return
+	}
+
+	shares, err := s.queryShelfShares(ctx, "ownerId", userID)
+	if err != nil {
+		writeServerError(w, r, err, "Failed to retrieve shares")
+		return
+	}
+	sharedWithMe, err := s.queryShelfShares(ctx, "viewerId", userID)
+	if err != nil {
+		writeServerError(w, r, err, "Failed to retrieve shares")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"shares":       shares,
+		"sharedWithMe": sharedWithMe,
+	})
+}
+
+func (s *Server) queryShelfShares(ctx context.Context, field, userID string) ([]ShelfShare, error) {
+	docs, err := s.firestoreClient.Collection("shelfShares").Where(field, "==", userID).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	shares := []ShelfShare{}
+	for _, doc := range docs {
+		var share ShelfShare
+		if err := doc.DataTo(&share); err != nil {
+			s.logger.Printf("Error parsing shelf share %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		shares = append(shares, share)
+	}
+	return shares, nil
+}
+
+func (s *Server) handleCreateShelfShare(w http.ResponseWriter, r *http.Request) {
+	var req shelfShareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid JSON body: %v", err))
+		return
+	}
+	if err := req.Validate(); err != nil {
+		writeValidationError(w, r, err)
+		return
+	}
+
+	share := ShelfShare{OwnerID: req.UserID, ViewerID: req.ViewerID, CreatedAt: time.Now()}
+	if req.ViewerID != "" {
+		share.ShareID = userShareID(req.UserID, req.ViewerID)
+	} else {
+		raw := make([]byte, 16)
+		if _, err := rand.Read(raw); err != nil {
+			writeServerError(w, r, err, "Failed to generate share token")
+			return
+		}
+		share.ShareID = hex.EncodeToString(raw)
+	}
+
+	if _, err := s.firestoreClient.Collection("shelfShares").Doc(share.ShareID).Set(r.Context(), share); err != nil {
+		writeServerError(w, r, err, "Failed to save share")
+		return
+	}
+	s.logger.Printf("Shelf of %s shared (viewer: %q)", share.OwnerID, share.ViewerID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(share)
+}
+
+func (s *Server) handleDeleteShelfShare(w http.ResponseWriter, r *http.Request) {
+	var req deleteShelfShareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid JSON body: %v", err))
+		return
+	}
+	if err := req.Validate(); err != nil {
+		writeValidationError(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	if err := s.checkShelfShare(ctx, req.ShareID, req.UserID); err != nil {
+		if errors.Is(err, errShelfNotShared) {
+			writeProblem(w, r, http.StatusNotFound, "Share not found")
+			return
+		}
+		writeServerError(w, r, err, "Failed to retrieve share")
+		return
+	}
+	if _, err := s.firestoreClient.Collection("shelfShares").Doc(req.ShareID).Delete(ctx); err != nil {
+		writeServerError(w, r, err, "Failed to delete share")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,356 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"tundoku-killer/backend/internal/cron"
+	"tundoku-killer/backend/internal/isbn"
+	"tundoku-killer/backend/internal/store"
+)
+
+// 「この本を読み終えた人は、こんな本も読み終えています」。設定の shareReadingHistory を有効にした人どうしで、
+// 読み終えた本の重なり (Jaccard 係数) から似た読者を探し、その人たちが読み終えた本で自分の本棚にない本を勧める。
+// cron (/cron/similar-readers) で recommendations/{userId} に作り直し、/v1/recommendations/similar-readers で返す。
+// 誰が読んだかは返さず、似た読者 minSuggestionReaders 人以上が読み終えた本だけを勧める
+
+const similarReadersLease = "similarReaders"
+
+const (
+	// minSharedBooks は似た読者とみなすのに要る、共通して読み終えた本の数
+	minSharedBooks = 2
+	// maxSimilarReaders は1人あたりに使う似た読者の数 (似ている順)
+	maxSimilarReaders = 20
+	// minSuggestionReaders は勧める本を読み終えている似た読者の数の下限。1人だけだと誰が読んだか分かりかねない
+	minSuggestionReaders = 2
+	// maxReaderSuggestions は1人あたりに保存する推薦の数
+	maxReaderSuggestions = 20
+	// maxSuggestionReasons は推薦ごとに添える、似た読者と共通して読み終えた自分の本の数
+	maxSuggestionReasons = 3
+)
+
+// ReaderSuggestion は似た読者が読み終えた本1冊
+type ReaderSuggestion struct {
+	Title   string   `json:"title" firestore:"title"`
+	Author  string   `json:"author,omitempty" firestore:"author,omitempty"`
+	ISBN    string   `json:"isbn,omitempty" firestore:"isbn,omitempty"`
+	Readers int      `json:"readers" firestore:"readers"` // 読み終えた似た読者の数
+	Score   float64  `json:"score" firestore:"score"`     // 読み終えた似た読者の似ている度合いの合計
+	Because []string `json:"because" firestore:"because"` // 似た読者と共通して読み終えた自分の本の書名
+}
+
+// ReaderRecommendations は recommendations/{userId} に保存する、似た読者からの推薦
+type ReaderRecommendations struct {
+	UserID      string             `json:"userId" firestore:"userId"`
+	Suggestions []ReaderSuggestion `json:"suggestions" firestore:"suggestions"`
+	Readers     int                `json:"readers" firestore:"readers"` // 見つかった似た読者の数
+	ComputedAt  *time.Time         `json:"computedAt,omitempty" firestore:"computedAt"`
+}
+
+// readerShelf は似た読者を探すための、1人の本棚
+type readerShelf struct {
+	completed map[string]store.Book // 読み終えた本 (readerBookKey → 本)
+	shelved   map[string]bool       // 本棚にあるすべての本の readerBookKey
+}
+
+// readerBookKey はユーザーをまたいで同じ本を数えるためのキー。ISBN があれば ISBN-13、なければ書名と著者
+func readerBookKey(book store.Book) string {
+	if code, err := isbn.To13(book.ISBN); err == nil {
+		return code
+	}
+	return titleKey(book)
+}
+
+// newReaderShelf は books から似た読者を探すための本棚を作る
+func newReaderShelf(books []store.Book) readerShelf {
+	shelf := readerShelf{completed: make(map[string]store.Book), shelved: make(map[string]bool, len(books))}
+	for _, book := range books {
+		key := readerBookKey(book)
+		shelf.shelved[key] = true
+		if book.Status == "completed" {
+			shelf.completed[key] = book
+		}
+	}
+	return shelf
+}
+
+// similarReader は似た読者1人
+type similarReader struct {
+	userID     string
+	similarity float64
+	shared     []string // 共通して読み終えた本の readerBookKey
+}
+
+// similarReaderSuggestions は shelves (同意した全員の本棚) から、1人ずつ似た読者からの推薦を作る (ComputedAt は空)
+func similarReaderSuggestions(shelves map[string]readerShelf) map[string]ReaderRecommendations {
+	// 本ごとに読み終えた人
+	finishers := make(map[string][]string)
+	for userID, shelf := range shelves {
+		for key := range shelf.completed {
+			finishers[key] = append(finishers[key], userID)
+		}
+	}
+
+	results := make(map[string]ReaderRecommendations, len(shelves))
+	for userID, shelf := range shelves {
+		readers := similarReaders(userID, shelves, finishers)
+		results[userID] = ReaderRecommendations{
+			UserID:      userID,
+			Suggestions: suggestFromReaders(shelf, readers, shelves),
+			Readers:     len(readers),
+		}
+	}
+	return results
+}
+
+// similarReaders は userID と読み終えた本が minSharedBooks 冊以上重なる人を、似ている順に maxSimilarReaders 人まで返す
+func similarReaders(userID string, shelves map[string]readerShelf, finishers map[string][]string) []similarReader {
+	shelf := shelves[userID]
+	shared := make(map[string][]string)
+	for key := range shelf.completed {
+		for _, other := range finishers[key] {
+			if other != userID {
+				shared[other] = append(shared[other], key)
+			}
+		}
+	}
+
+	var readers []similarReader
+	for other, keys := range shared {
+		if len(keys) < minSharedBooks {
+			continue
+		}
+		union := len(shelf.completed) + len(shelves[other].completed) - len(keys)
+		readers = append(readers, similarReader{userID: other, similarity: float64(len(keys)) / float64(union), shared: keys})
+	}
+	sort.Slice(readers, func(i, j int) bool {
+		if readers[i].similarity != readers[j].similarity {
+			return readers[i].similarity > readers[j].similarity
+		}
+		return readers[i].userID < readers[j].userID
+	})
+	return readers[:min(maxSimilarReaders, len(readers))]
+}
+
+// suggestFromReaders は似た読者が読み終えた本のうち、shelf にない本を似ている度合いの合計の高い順に返す
+func suggestFromReaders(shelf readerShelf, readers []similarReader, shelves map[string]readerShelf) []ReaderSuggestion {
+	type tally struct {
+		book    store.Book
+		readers int
+		score   float64
+		because map[string]int // 共通して読み終えた自分の本 → その本も読み終えた似た読者の数
+	}
+	tallies := make(map[string]*tally)
+	for _, reader := range readers {
+		for key, book := range shelves[reader.userID].completed {
+			if shelf.shelved[key] {
+				continue
+			}
+			t := tallies[key]
+			if t == nil {
+				t = &tally{book: book, because: make(map[string]int)}
+				tallies[key] = t
+			}
+			t.readers++
+			t.score += reader.similarity
+			for _, sharedKey := range reader.shared {
+				t.because[shelf.completed[sharedKey].Title]++
+			}
+		}
+	}
+
+	suggestions := []ReaderSuggestion{}
+	for _, t := range tallies {
+		if t.readers < minSuggestionReaders {
+			continue
+		}
+		because := make([]string, 0, len(t.because))
+		for title := range t.because {
+			because = append(because, title)
+		}
+		sort.Slice(because, func(i, j int) bool {
+			if t.because[because[i]] != t.because[because[j]] {
+				return t.because[because[i]] > t.because[because[j]]
+			}
+			return because[i] < because[j]
+		})
+		suggestions = append(suggestions, ReaderSuggestion{
+			Title:   t.book.Title,
+			Author:  t.book.Author,
+			ISBN:    t.book.ISBN,
+			Readers: t.readers,
+			Score:   math.Round(t.score*100) / 100,
+			Because: because[:min(maxSuggestionReasons, len(because))],
+		})
+	}
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].Score != suggestions[j].Score {
+			return suggestions[i].Score > suggestions[j].Score
+		}
+		if suggestions[i].Readers != suggestions[j].Readers {
+			return suggestions[i].Readers > suggestions[j].Readers
+		}
+		return suggestions[i].Title < suggestions[j].Title
+	})
+	return suggestions[:min(maxReaderSuggestions, len(suggestions))]
+}
+
+// handleSimilarReadersCron は同意した人の本棚から似た読者を探し、recommendations/{userId} を作り直す
+func (s *Server) handleSimilarReadersCron(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodGet {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	ctx := context.WithoutCancel(r.Context())
+
+	if !s.authorizeCron(r) {
+		writeProblem(w, r, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	runID := uuid.NewString()
+	if err := s.cron.AcquireLease(ctx, similarReadersLease, runID, cron.LeaseTTL); err != nil {
+		if errors.Is(err, cron.ErrLeaseHeld) {
+			writeProblem(w, r, http.StatusConflict, "Another similar reader computation is already running")
+			return
+		}
+		writeServerError(w, r, err, "Failed to acquire lock")
+		return
+	}
+	defer s.cron.ReleaseLease(ctx, similarReadersLease, runID)
+
+	userIDs, err := s.listUserIDs(ctx)
+	if err != nil {
+		writeServerError(w, r, err, "Failed to list users")
+		return
+	}
+
+	// 時間内に読み切れなかった人は、今回は似た読者の候補にも入れない (前回の推薦が残る)
+	deadline := time.Now().Add(cron.TimeBudget)
+	shelves := make(map[string]readerShelf)
+	users, failed, done := 0, 0, true
+	for _, userID := range userIDs {
+		if time.Now().After(deadline) {
+			done = false
+			break
+		}
+		users++
+		settings, err := s.getSettings(ctx, userID)
+		if err != nil {
+			s.logger.Printf("Error fetching settings for %s: %v", userID, err)
+			failed++
+			continue
+		}
+		if !settings.ShareReadingHistory {
+			continue
+		}
+		books, err := s.listBooks(ctx, userID)
+		if err != nil {
+			s.logger.Printf("Error fetching books for %s: %v", userID, err)
+			failed++
+			continue
+		}
+		shelves[userID] = newReaderShelf(books)
+	}
+
+	now := time.Now()
+	results := similarReaderSuggestions(shelves)
+	bw := s.firestoreClient.BulkWriter(ctx)
+	jobs := make([]*firestore.BulkWriterJob, 0, len(results))
+	for userID, rec := range results {
+		rec.ComputedAt = &now
+		job, err := bw.Set(s.firestoreClient.Collection("recommendations").Doc(userID), rec)
+		if err != nil {
+			s.logger.Printf("Error queueing recommendations for %s: %v", userID, err)
+			failed++
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	bw.End()
+	for _, job := range jobs {
+		if _, err := job.Results(); err != nil {
+			s.logger.Printf("Error saving recommendations: %v", err)
+			failed++
+		}
+	}
+
+	s.logger.Printf("Similar readers computed for %d of %d users (%d sharing, %d failed)", users, len(userIDs), len(shelves), failed)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"users":   users,
+		"sharing": len(shelves),
+		"failed":  failed,
+		"done":    done,
+	})
+}
+
+// handleSimilarReaders は ?userId= への似た読者からの推薦を返す (GET)。作ってから本棚に入れた本は除く。
+// まだ作っていなければ suggestions は空で computedAt はない
+func (s *Server) handleSimilarReaders(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	userID := r.URL.Query().Get("userId")
+	if userID == "" {
+		writeProblem(w, r, http.StatusBadRequest, "userId query parameter is required")
+		return
+	}
+	ctx := r.Context()
+	settings, err := s.getSettings(ctx, userID)
+	if err != nil {
+		writeServerError(w, r, err, "Failed to retrieve settings")
+		return
+	}
+	if !settings.ShareReadingHistory {
+		writeProblem(w, r, http.StatusConflict, "Reading history is not shared; enable shareReadingHistory via /v1/settings first")
+		return
+	}
+
+	rec := ReaderRecommendations{UserID: userID, Suggestions: []ReaderSuggestion{}}
+	doc, err := s.firestoreClient.Collection("recommendations").Doc(userID).Get(ctx)
+	if err != nil && status.Code(err) != codes.NotFound {
+		writeServerError(w, r, err, "Failed to retrieve recommendations")
+		return
+	}
+	if err == nil {
+		if err := doc.DataTo(&rec); err != nil {
+			writeServerError(w, r, err, "Failed to parse recommendations")
+			return
+		}
+		books, err := s.listBooks(ctx, userID)
+		if err != nil {
+			writeServerError(w, r, err, "Failed to retrieve books")
+			return
+		}
+		shelf := newReaderShelf(books)
+		kept := []ReaderSuggestion{}
+		for _, suggestion := range rec.Suggestions {
+			if !shelf.shelved[readerBookKey(store.Book{Title: suggestion.Title, Author: suggestion.Author, ISBN: suggestion.ISBN})] {
+				kept = append(kept, suggestion)
+			}
+		}
+		rec.Suggestions = kept
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rec)
+}
+
+// forgetSimilarReaders は共有をやめた userID の推薦を消す (次の cron からは似た読者の候補にも入らない)
+func (s *Server) forgetSimilarReaders(ctx context.Context, userID string) error {
+	_, err := s.firestoreClient.Collection("recommendations").Doc(userID).Delete(ctx)
+	return err
+}
@@ -0,0 +1,155 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"tundoku-killer/backend/internal/validation"
+)
+
+// 本のステータス変更や煽りの送信を、接続中のクライアントへ Server-Sent Events (/v1/events) で配信する。
+// 配信はプロセス内の pub/sub (eventHub) で行うため、同じインスタンスで起きた変更だけが届く
+
+const (
+	eventBookStatus = "book.status" // 本の登録・更新・読了・削除 (削除時の status は "deleted")
+	eventInsultSent = "insult.sent" // 煽り文の送信
+
+	// sseHeartbeatInterval はプロキシに接続を切られないよう、コメント行を送る間隔
+	sseHeartbeatInterval = 25 * time.Second
+	// subscriberBuffer は1クライアントあたりに溜めておけるイベント数。溢れた分は捨てる
+	subscriberBuffer = 16
+)
+
+// LiveEvent は SSE でクライアントへ配信するイベント
+type LiveEvent struct {
+	ID      uint64    `json:"id"`
+	Type    string    `json:"type"`
+	UserID  string    `json:"userId"`
+	BookID  string    `json:"bookId"`
+	Status  string    `json:"status,omitempty"`
+	Message string    `json:"message,omitempty"` // insult.sent のときの煽り文
+	At      time.Time `json:"at"`
+}
+
+// eventHub はユーザーごとの購読者にイベントを配る
+type eventHub struct {
+	mu     sync.Mutex
+	subs   map[string]map[chan LiveEvent]struct{} // userId -> 購読者
+	nextID atomic.Uint64
+}
+
+var liveEvents = &eventHub{subs: make(map[string]map[chan LiveEvent]struct{})}
+
+// subscribe は userID 宛てのイベントを受け取るチャネルと、購読をやめる関数を返す
+func (h *eventHub) subscribe(userID string) (<-chan LiveEvent, func()) {
+	ch := make(chan LiveEvent, subscriberBuffer)
+
+	h.mu.Lock()
+	if h.subs[userID] == nil {
+		h.subs[userID] = make(map[chan LiveEvent]struct{})
+	}
+	h.subs[userID][ch] = struct{}{}
+	h.mu.Unlock()
+
+	return ch, func() {
+		h.mu.Lock()
+		delete(h.subs[userID], ch)
+		if len(h.subs[userID]) == 0 {
+			delete(h.subs, userID)
+		}
+		h.mu.Unlock()
+	}
+}
+
+// publish は ev を ev.UserID の購読者に配る。受信が追いつかない購読者の分は捨てる
+func (h *eventHub) publish(ev LiveEvent) {
+	if ev.UserID == "" {
+		return
+	}
+	ev.ID = h.nextID.Add(1)
+	if ev.At.IsZero() {
+		ev.At = time.Now()
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs[ev.UserID] {
+		select {
+		case ch <- ev:
+		default:
+			log.Printf("Dropping event %d for slow subscriber of user %s", ev.ID, ev.UserID)
+		}
+	}
+}
+
+// publishBookStatus は本のステータス変更を配信する
+func publishBookStatus(userID, bookID, status string) {
+	liveEvents.publish(LiveEvent{Type: eventBookStatus, UserID: userID, BookID: bookID, Status: status})
+}
+
+// handleEvents は ?userId= のユーザー宛てのイベントを SSE で送り続ける
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// EventSource はヘッダーを付けられないため、ユーザーはクエリで受け取る
+	userID := r.URL.Query().Get("userId")
+	var v validation.Validator
+	v.Required("userId", userID)
+	v.MaxLength("userId", userID, maxIDLength)
+	if err := v.Err(); err != nil {
+		writeValidationError(w, r, err)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	// サーバー全体の WriteTimeout で接続が切られないようにする
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("Error clearing write deadline for SSE: %v", err)
+	}
+
+	ch, unsubscribe := liveEvents.subscribe(userID)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		log.Printf("SSE not supported by response writer: %v", err)
+		return
+	}
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case ev := <-ch:
+			data, err := json.Marshal(ev)
+			if err != nil {
+				log.Printf("Error encoding event %d: %v", ev.ID, err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, data); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+)
+
+// StartupGate は起動が終わるまでのリクエストを受け持つ http.Handler。
+// /livez はすぐに 200 を返し、/readyz は登録した手順がすべて終わって Serve が呼ばれるまで 503 を返す。
+// それ以外のパスも準備ができるまでは 503 にするので、Cloud Run が初期化途中のインスタンスにトラフィックを流さない
+type StartupGate struct {
+	mu      sync.RWMutex
+	pending []string // まだ終わっていない手順
+	handler http.Handler
+}
+
+// startupStatus は /readyz のレスポンス
+type startupStatus struct {
+	Status  string   `json:"status"` // "starting" か "ready"
+	Pending []string `json:"pending,omitempty"`
+}
+
+// NewStartupGate は steps が終わるまで準備中とする StartupGate を返す
+func NewStartupGate(steps ...string) *StartupGate {
+	return &StartupGate{pending: slices.Clone(steps)}
+}
+
+// Done は step が終わったことを記録する
+func (g *StartupGate) Done(step string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.pending = slices.DeleteFunc(g.pending, func(p string) bool { return p == step })
+}
+
+// Serve は以後のリクエストを h に渡す。残っている手順があれば、それが終わるまでは準備中のまま
+func (g *StartupGate) Serve(h http.Handler) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.handler = h
+}
+
+// ready は準備ができていればハンドラーを返す
+func (g *StartupGate) ready() (http.Handler, []string) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	if len(g.pending) > 0 || g.handler == nil {
+		return nil, slices.Clone(g.pending)
+	}
+	return g.handler, nil
+}
+
+func (g *StartupGate) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	handler, pending := g.ready()
+
+	switch r.URL.Path {
+	case "/livez":
+		// プロセスが動いていれば 200 (依存先は見ない)
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintln(w, "OK")
+		return
+	case "/readyz":
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if handler == nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(startupStatus{Status: "starting", Pending: pending})
+			return
+		}
+		json.NewEncoder(w).Encode(startupStatus{Status: "ready"})
+		return
+	}
+
+	if handler == nil {
+		w.Header().Set("Retry-After", "1")
+		writeProblem(w, r, http.StatusServiceUnavailable, "Server is starting")
+		return
+	}
+	handler.ServeHTTP(w, r)
+}
@@ -0,0 +1,205 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"tundoku-killer/backend/internal/store"
+)
+
+// statsCacheTTL は userStats に保存した集計を使い回す時間。
+// 本の変更時は購読者が即座に捨てるので、主に「期限切れ日数」が古くならないための上限
+const statsCacheTTL = 10 * time.Minute
+
+// Stats はダッシュボード用のユーザーごとの集計。userStats/{userId} にキャッシュする
+type Stats struct {
+	UserID         string         `json:"userId" firestore:"userId"`
+	Total          int            `json:"total" firestore:"total"` // ウィッシュリストの本は含まない
+	ByStatus       map[string]int `json:"byStatus" firestore:"byStatus"`
+	CompletionRate float64        `json:"completionRate" firestore:"completionRate"` // 読了した割合 (0〜1)
+	// 登録から読了までの平均日数。登録日時・読了日時が記録された本だけで計算する
+	AverageDaysToComplete *float64 `json:"averageDaysToComplete" firestore:"averageDaysToComplete"`
+	Overdue               int      `json:"overdue" firestore:"overdue"`
+	// 期限切れで未読了の本の、期限を過ぎてからの平均日数
+	AverageDaysOverdue *float64       `json:"averageDaysOverdue" firestore:"averageDaysOverdue"`
+	LongestNeglected   *NeglectedBook `json:"longestNeglected" firestore:"longestNeglected"`
+	// 読み終えていない本の価格の合計 (円)。価格が分からない本は含まない
+	UnreadValue int `json:"unreadValue" firestore:"unreadValue"`
+	// 買ってから読み始める (最初の読書の記録) までの平均日数。買った日と読書の記録がある本だけで計算する
+	AverageDaysToFirstPage *float64 `json:"averageDaysToFirstPage" firestore:"averageDaysToFirstPage"`
+	// 読書のペースと、ページ数の分かる読み終えていない本の読了見込み
+	Pace ReadingPace `json:"pace" firestore:"pace"`
+	ETAs []BookETA   `json:"etas" firestore:"etas"`
+	// Wishlist はウィッシュリストの本の数。ほかの集計には含めない
+	Wishlist int `json:"wishlist" firestore:"wishlist"`
+	// Streak は読書の記録を付けた日の連続日数。Current は途切れていれば 0
+	Streak     ReadingStreak `json:"streak" firestore:"streak"`
+	ComputedAt time.Time     `json:"computedAt" firestore:"computedAt"`
+}
+
+// NeglectedBook は期限を過ぎてから最も長く放置されている本
+type NeglectedBook struct {
+	BookID      string    `json:"bookId" firestore:"bookId"`
+	Title       string    `json:"title" firestore:"title"`
+	Author      string    `json:"author" firestore:"author"`
+	Deadline    time.Time `json:"deadline" firestore:"deadline"`
+	DaysOverdue float64   `json:"daysOverdue" firestore:"daysOverdue"`
+}
+
+// handleStats は ?userId= のユーザーの集計を返す
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	userID := r.URL.Query().Get("userId")
+	if userID == "" {
+		writeProblem(w, r, http.StatusBadRequest, "userId query parameter is required")
+		return
+	}
+
+	stats, err := s.userStats(r.Context(), userID)
+	if err != nil {
+		writeServerError(w, r, err, "Failed to compute stats")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// userStats はキャッシュが新しければそれを、なければ本の一覧から集計し直して返す
+func (s *Server) userStats(ctx context.Context, userID string) (Stats, error) {
+	ref := s.firestoreClient.Collection("userStats").Doc(userID)
+
+	doc, err := ref.Get(ctx)
+	if err == nil {
+		var cached Stats
+		if err := doc.DataTo(&cached); err == nil && time.Since(cached.ComputedAt) < statsCacheTTL {
+			return cached, nil
+		}
+	} else if status.Code(err) != codes.NotFound {
+		s.logger.Printf("Error reading cached stats for user %s: %v", userID, err)
+	}
+
+	books, err := s.listBooks(ctx, userID)
+	if err != nil {
+		return Stats{}, err
+	}
+	sessions, err := s.readingSessions(ctx, userID)
+	if err != nil {
+		return Stats{}, err
+	}
+	stats := computeStats(userID, books, sessions, time.Now())
+	if stats.Streak, err = s.readingStreak(ctx, userID); err != nil {
+		return Stats{}, err
+	}
+	stats.Streak.Current = currentStreak(stats.Streak, stats.ComputedAt)
+
+	if _, err := ref.Set(ctx, stats); err != nil {
+		s.logger.Printf("Error caching stats for user %s: %v", userID, err)
+	}
+	return stats, nil
+}
+
+// invalidateStats はユーザーの集計のキャッシュを捨てる
+func (s *Server) invalidateStats(ctx context.Context, userID string) {
+	if _, err := s.firestoreClient.Collection("userStats").Doc(userID).Delete(ctx); err != nil {
+		s.logger.Printf("Error invalidating stats for user %s: %v", userID, err)
+	}
+}
+
+// computeStats は books と読書の記録を now の時点で集計する
+func computeStats(userID string, books []store.Book, sessions []ReadingSession, now time.Time) Stats {
+	stats := Stats{
+		UserID:     userID,
+		ByStatus:   make(map[string]int, len(bookStatuses)),
+		ComputedAt: now,
+	}
+	for _, s := range bookStatuses {
+		stats.ByStatus[s] = 0
+	}
+
+	firstRead := map[string]time.Time{}
+	for _, session := range sessions {
+		if first, ok := firstRead[session.BookID]; !ok || session.ReadAt.Before(first) {
+			firstRead[session.BookID] = session.ReadAt
+		}
+	}
+
+	var completeDays, firstPageDays, overdueDays []float64
+	for _, book := range books {
+		if book.OnWishlist() {
+			stats.Wishlist++
+			continue
+		}
+		stats.Total++
+		stats.ByStatus[book.Status]++
+		if book.Status != "completed" && !book.Abandoned() {
+			stats.UnreadValue += book.Price
+		}
+
+		if book.Status == "completed" && book.CreatedAt != nil && book.CompletedAt != nil {
+			completeDays = append(completeDays, daysBetween(*book.CreatedAt, *book.CompletedAt))
+		}
+		if first, ok := firstRead[book.BookID]; ok && book.PurchasedAt != nil {
+			firstPageDays = append(firstPageDays, max(daysBetween(*book.PurchasedAt, first), 0))
+		}
+
+		if !isOverdue(book, now) {
+			continue
+		}
+		days := daysBetween(book.Deadline, now)
+		overdueDays = append(overdueDays, days)
+		if stats.LongestNeglected == nil || days > stats.LongestNeglected.DaysOverdue {
+			stats.LongestNeglected = &NeglectedBook{
+				BookID:      book.BookID,
+				Title:       book.Title,
+				Author:      book.Author,
+				Deadline:    book.Deadline,
+				DaysOverdue: days,
+			}
+		}
+	}
+
+	if stats.Total > 0 {
+		stats.CompletionRate = roundTo(float64(stats.ByStatus["completed"])/float64(stats.Total), 3)
+	}
+	stats.Overdue = len(overdueDays)
+	stats.AverageDaysToComplete = average(completeDays)
+	stats.AverageDaysToFirstPage = average(firstPageDays)
+	stats.AverageDaysOverdue = average(overdueDays)
+	stats.Pace = readingPace(books, sessions, now)
+	stats.ETAs = bookETAs(books, sessions, stats.Pace, now)
+	return stats
+}
+
+// daysBetween は from から to までの日数を小数第1位まで返す
+func daysBetween(from, to time.Time) float64 {
+	return roundTo(to.Sub(from).Hours()/24, 1)
+}
+
+// average は values の平均を小数第1位まで返す。空なら nil
+func average(values []float64) *float64 {
+	if len(values) == 0 {
+		return nil
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	avg := roundTo(sum/float64(len(values)), 1)
+	return &avg
+}
+
+func roundTo(v float64, digits int) float64 {
+	p := math.Pow(10, float64(digits))
+	return math.Round(v*p) / p
+}
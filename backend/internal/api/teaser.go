@@ -0,0 +1,117 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"tundoku-killer/backend/internal/cache"
+	"tundoku-killer/backend/internal/cron"
+	"tundoku-killer/backend/internal/insult"
+	"tundoku-killer/backend/internal/isbn"
+	"tundoku-killer/backend/internal/store"
+)
+
+// 本を登録したら、生成AIに書かせたネタバレなしの紹介文を添えて確認の通知を送る (BOOK_TEASERS)。
+// 期限切れの煽りが鞭なら、こちらは読みたくさせる飴。ISBN があれば openBD の内容紹介を渡して、筋書きを作らせないようにする
+
+// teaserTimeout は内容紹介を調べ、紹介文を書かせて送るまでを待つ時間
+const teaserTimeout = 30 * time.Second
+
+// maxDescriptionLength は生成AIに渡す内容紹介の長さの上限 (文字数)
+const maxDescriptionLength = 1000
+
+// sendRegistrationTeaser は登録した本の紹介文をバックグラウンドで書かせて送る。登録のレスポンスは待たせない。
+// 読了済みで登録した本と、読書会で配った本 (読書会の通知が別にある) には送らない
+func (s *Server) sendRegistrationTeaser(ctx context.Context, book store.Book) {
+	if !s.cfg.Insult.Teasers || book.Status == "completed" || book.GroupID != "" || book.OnWishlist() {
+		return
+	}
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		ctx, cancel := context.WithTimeout(ctx, teaserTimeout)
+		defer cancel()
+
+		var description string
+		if book.ISBN != "" {
+			var err error
+			if description, err = s.lookupDescription(ctx, book.ISBN); err != nil {
+				s.logger.Printf("Error looking up description for ISBN %s: %v", book.ISBN, err)
+			}
+		}
+		if blocklist := s.recipientBlocklist(ctx, book.UserID); blocklist != nil {
+			ctx = insult.WithModerator(ctx, blocklist)
+		}
+		teaser, err := s.teaser.Write(ctx, book, description)
+		if err != nil {
+			// 紹介文のない確認の通知は送らない (登録したことは画面で分かる)
+			s.logger.Printf("Error writing teaser for book %s: %v", book.BookID, err)
+			return
+		}
+		message := fmt.Sprintf("「%s」を登録しました。期限は%sです。\n\n%s",
+			book.Title, book.Deadline.In(cron.Location).Format("1月2日"), teaser)
+		if err := s.sendLineMessage(ctx, book.UserID, message); err != nil {
+			s.logger.Printf("Error sending teaser for book %s: %v", book.BookID, err)
+		}
+	}()
+}
+
+// lookupDescription は code の本の内容紹介を openBD で調べる。見つからないか、ISBN として正しくなければ ""
+func (s *Server) lookupDescription(ctx context.Context, code string) (string, error) {
+	code = isbn.Normalize(code)
+	if !isbn.Valid(code) {
+		return "", nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, priceLookupTimeout)
+	defer cancel()
+
+	// 内容紹介は変わらないので、CATALOG_CACHE_TTL の間は調べ直さない
+	var cached string
+	if cache.GetJSON(ctx, s.cache, "description:"+code, &cached) {
+		return cached, nil
+	}
+	description, err := lookupOpenBDDescription(ctx, code)
+	if err == nil && description != "" {
+		if err := cache.SetJSON(ctx, s.cache, "description:"+code, description, s.cfg.Cache.CatalogTTL); err != nil {
+			s.logger.Printf("Error caching description for %s: %v", code, err)
+		}
+	}
+	return description, err
+}
+
+// lookupOpenBDDescription は openBD の ONIX データから内容紹介 (TextType 03)、なければ短い紹介 (02) を取り出す
+func lookupOpenBDDescription(ctx context.Context, isbn string) (string, error) {
+	var results []*struct {
+		Onix struct {
+			CollateralDetail struct {
+				TextContent []struct {
+					TextType string `json:"TextType"`
+					Text     string `json:"Text"`
+				} `json:"TextContent"`
+			} `json:"CollateralDetail"`
+		} `json:"onix"`
+	}
+	if err := getJSON(ctx, "https://api.openbd.jp/v1/get?isbn="+url.QueryEscape(isbn), &results); err != nil {
+		return "", err
+	}
+	if len(results) == 0 || results[0] == nil {
+		return "", nil
+	}
+	texts := map[string]string{}
+	for _, t := range results[0].Onix.CollateralDetail.TextContent {
+		if text := strings.TrimSpace(t.Text); text != "" && texts[t.TextType] == "" {
+			texts[t.TextType] = text
+		}
+	}
+	for _, textType := range []string{"03", "02"} {
+		if text := texts[textType]; text != "" {
+			if runes := []rune(text); len(runes) > maxDescriptionLength {
+				text = string(runes[:maxDescriptionLength])
+			}
+			return text, nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,201 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// 読書タイマー (ポモドーロのように、読み始めと読み終わりを押す)。止めると経過時間付きの読書の記録 (sessions.go) になり、
+// ペース・読了見込み・ストリークに数える。動いているタイマーは1人1つで、readingTimers/{userId} に保存する
+
+// maxTimerDuration はタイマーを止め忘れたとみなす時間。これより長く動いていたタイマーは、止めてもこの時間で打ち切り、
+// 別の本のタイマーを始めれば捨てる
+const maxTimerDuration = 4 * time.Hour
+
+var (
+	errTimerRunning    = errors.New("another timer is running")
+	errTimerNotRunning = errors.New("no timer is running for this book")
+)
+
+// ReadingTimer は動いているタイマー
+type ReadingTimer struct {
+	UserID    string    `json:"userId" firestore:"userId"`
+	BookID    string    `json:"bookId" firestore:"bookId"`
+	StartedAt time.Time `json:"startedAt" firestore:"startedAt"`
+}
+
+// stale は now の時点で止め忘れとみなすタイマーかを返す
+func (t ReadingTimer) stale(now time.Time) bool {
+	return now.Sub(t.StartedAt) > maxTimerDuration
+}
+
+// handleStartTimer は {id} の本のタイマーを始める。ほかの本のタイマーが動いていれば 409 (止め忘れなら捨てて始める)。
+// 同じ本のタイマーが動いていれば、それをそのまま返す
+func (s *Server) handleStartTimer(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req startTimerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid JSON body: %v", err))
+		return
+	}
+	if err := req.Validate(); err != nil {
+		writeValidationError(w, r, err)
+		return
+	}
+	ctx := r.Context()
+	book, err := s.ownedBook(ctx, r.PathValue("id"), req.UserID)
+	if err != nil {
+		writeBookError(w, r, err, "Failed to retrieve book")
+		return
+	}
+	if book.Status == "completed" {
+		writeProblem(w, r, http.StatusConflict, "Book is already completed")
+		return
+	}
+
+	var timer ReadingTimer
+	ref := s.firestoreClient.Collection("readingTimers").Doc(req.UserID)
+	err = s.firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		now := time.Now()
+		timer = ReadingTimer{UserID: req.UserID, BookID: book.BookID, StartedAt: now}
+		doc, err := tx.Get(ref)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if err == nil {
+			var running ReadingTimer
+			if err := doc.DataTo(&running); err != nil {
+				return err
+			}
+			switch {
+			case running.stale(now):
+				s.logger.Printf("Discarding stale timer of %s (book %s, started %s)", req.UserID, running.BookID, running.StartedAt.Format(time.RFC3339))
+			case running.BookID == book.BookID:
+				timer = running
+				return nil
+			default:
+				timer = running
+				return errTimerRunning
+			}
+		}
+		return tx.Set(ref, timer)
+	})
+	if errors.Is(err, errTimerRunning) {
+		writeProblem(w, r, http.StatusConflict, fmt.Sprintf("A timer is already running for book %s; stop it first", timer.BookID))
+		return
+	}
+	if err != nil {
+		writeServerError(w, r, err, "Failed to start timer")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"timer":     timer,
+		"expiresAt": timer.StartedAt.Add(maxTimerDuration), // これを過ぎると止め忘れとみなす
+	})
+}
+
+// handleStopTimer は {id} の本のタイマーを止め、経過時間と読んだページ数を読書の記録にする。
+// 止め忘れたタイマーの経過時間は maxTimerDuration で打ち切る
+func (s *Server) handleStopTimer(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req stopTimerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid JSON body: %v", err))
+		return
+	}
+	if err := req.Validate(); err != nil {
+		writeValidationError(w, r, err)
+		return
+	}
+	ctx := r.Context()
+	book, err := s.ownedBook(ctx, r.PathValue("id"), req.UserID)
+	if err != nil {
+		writeBookError(w, r, err, "Failed to retrieve book")
+		return
+	}
+
+	// 二重に止めて記録が2つできないよう、読んで消すまでを1つのトランザクションで行う
+	var timer ReadingTimer
+	ref := s.firestoreClient.Collection("readingTimers").Doc(req.UserID)
+	err = s.firestoreClient.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound {
+			return errTimerNotRunning
+		}
+		if err != nil {
+			return err
+		}
+		if err := doc.DataTo(&timer); err != nil {
+			return err
+		}
+		if timer.BookID != book.BookID {
+			return errTimerNotRunning
+		}
+		return tx.Delete(ref)
+	})
+	if errors.Is(err, errTimerNotRunning) {
+		writeProblem(w, r, http.StatusConflict, "No timer is running for this book")
+		return
+	}
+	if err != nil {
+		writeServerError(w, r, err, "Failed to stop timer")
+		return
+	}
+
+	elapsed := time.Since(timer.StartedAt)
+	capped := elapsed > maxTimerDuration
+	elapsed = min(elapsed, maxTimerDuration)
+	session, err := s.logReadingSession(ctx, book, req.Pages, int(elapsed.Minutes()))
+	if err != nil {
+		writeServerError(w, r, err, "Failed to save reading session")
+		return
+	}
+	streak, err := s.readingStreak(ctx, req.UserID)
+	if err != nil {
+		s.logger.Printf("Error fetching streak for %s: %v", req.UserID, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"session": session,
+		"minutes": session.Minutes,
+		"capped":  capped, // 止め忘れとみなして経過時間を打ち切ったか
+		"streak":  streak,
+	})
+}
+
+// discardTimer は userID のタイマーが bookID の本のものなら捨てる (本を消したとき)
+func (s *Server) discardTimer(ctx context.Context, userID, bookID string) {
+	ref := s.firestoreClient.Collection("readingTimers").Doc(userID)
+	doc, err := ref.Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return
+	}
+	if err != nil {
+		s.logger.Printf("Error fetching timer of %s: %v", userID, err)
+		return
+	}
+	var timer ReadingTimer
+	if err := doc.DataTo(&timer); err != nil || timer.BookID != bookID {
+		return
+	}
+	if _, err := ref.Delete(ctx, firestore.LastUpdateTime(doc.UpdateTime)); err != nil && status.Code(err) != codes.FailedPrecondition {
+		s.logger.Printf("Error discarding timer of %s: %v", userID, err)
+	}
+}
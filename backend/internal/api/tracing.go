@@ -0,0 +1,71 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+
+	"tundoku-killer/backend/internal/config"
+)
+
+// tracer はアプリケーション内で手動で張るスパン用のトレーサー
+var tracer = otel.Tracer("tundoku-killer/backend")
+
+// InitTracing は OTLP エクスポーターを設定する。
+// OTEL_EXPORTER_OTLP_ENDPOINT (または OTEL_EXPORTER_OTLP_TRACES_ENDPOINT) が未設定なら何もしない。
+// エンドポイントやヘッダーなどの詳細は OTEL_* の標準の環境変数で指定する
+func InitTracing(ctx context.Context, c config.TracingConfig) (func(context.Context) error, error) {
+	// トレースを出力しない場合でも、上流から来たトレースコンテキストは伝播させる
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	if !c.Enabled {
+		log.Printf("OTEL_EXPORTER_OTLP_ENDPOINT not set; tracing disabled")
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	serviceName := c.ServiceName
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(serviceName),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// traceHandler はすべてのリクエストにサーバースパンを張る。スパン名はパスにする
+func traceHandler(next http.Handler) http.Handler {
+	return otelhttp.NewHandler(next, "http.server",
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return r.Method + " " + r.URL.Path
+		}),
+	)
+}
+
+// tracedHTTPClient は外部API (LINEなど) の呼び出しにクライアントスパンを張る http.Client
+var tracedHTTPClient = &http.Client{
+	Transport: otelhttp.NewTransport(http.DefaultTransport),
+}
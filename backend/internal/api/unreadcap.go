@@ -0,0 +1,131 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"tundoku-killer/backend/internal/cron"
+	"tundoku-killer/backend/internal/store"
+)
+
+// 未読の本の上限 (設定の unreadHardCap)。購入禁止モードと違って押し切れない。登録すると未読の本が上限を超えるなら 422 で断り、
+// 先にどの本を読み終えればよいかを名指しする。読書会で配られた本とウィッシュリストの本、読了済みで登録する本は数えない
+
+// problemTypeUnreadCap は未読の本の上限で本の登録を断ったときの problem type
+const problemTypeUnreadCap = "urn:tundoku-killer:problem:unread-cap"
+
+// UnreadCapError は未読の本の上限で本の登録を断ったときのエラー
+type UnreadCapError struct {
+	Cap        int
+	Unread     int
+	MustFinish []CappedBook // 登録できるようになるまでに読み終えなければならない本
+	Message    string
+}
+
+func (e *UnreadCapError) Error() string {
+	return fmt.Sprintf("unread cap reached: %d unread books (cap %d)", e.Unread, e.Cap)
+}
+
+// CappedBook は上限を下回るために先に読み終えなければならない本
+type CappedBook struct {
+	BookID   string    `json:"bookId"`
+	Title    string    `json:"title"`
+	Status   string    `json:"status"`
+	Deadline time.Time `json:"deadline"`
+}
+
+// unreadCapProblem は登録を断ったときの応答。problem+json に上限と読み終えるべき本を足す
+type unreadCapProblem struct {
+	Problem
+	Cap        int          `json:"cap"`
+	Unread     int          `json:"unread"`
+	MustFinish []CappedBook `json:"mustFinish"`
+	Message    string       `json:"message"`
+}
+
+// checkUnreadCap は book を登録すると未読の本が上限を超えるなら *UnreadCapError を返す
+func (s *Server) checkUnreadCap(ctx context.Context, book store.Book) error {
+	if book.UserID == "" || book.GroupID != "" || book.OnWishlist() || book.Status == "completed" || book.Abandoned() {
+		return nil
+	}
+	settings, err := s.getSettings(ctx, book.UserID)
+	if err != nil {
+		return err
+	}
+	if settings.UnreadHardCap == 0 {
+		return nil
+	}
+	books, err := s.listBooks(ctx, book.UserID)
+	if err != nil {
+		return err
+	}
+	if capErr := unreadCap(books, book, settings.UnreadHardCap); capErr != nil {
+		return capErr
+	}
+	return nil
+}
+
+// unreadCap は books に book を足すと未読の本が limit を超えるなら、読み終えるべき本を名指しした *UnreadCapError を返す。
+// 読み始めている本、ブロック中でない本、期限の早い本の順に、上限を下回るのに要る冊数だけ選ぶ
+func unreadCap(books []store.Book, book store.Book, limit int) *UnreadCapError {
+	byID := booksByID(books)
+	var unread []store.Book
+	for _, b := range books {
+		if b.Status != "completed" && !b.Abandoned() && !b.OnWishlist() {
+			unread = append(unread, b)
+		}
+	}
+	if len(unread) < limit {
+		return nil
+	}
+
+	sort.SliceStable(unread, func(i, j int) bool {
+		if ri, rj := unread[i].Status == "reading", unread[j].Status == "reading"; ri != rj {
+			return ri
+		}
+		if bi, bj := len(blockers(unread[i], byID)) > 0, len(blockers(unread[j], byID)) > 0; bi != bj {
+			return bj
+		}
+		return unread[i].Deadline.Before(unread[j].Deadline)
+	})
+	mustFinish := make([]CappedBook, 0, len(unread)-limit+1)
+	titles := make([]string, 0, cap(mustFinish))
+	for _, b := range unread[:len(unread)-limit+1] {
+		mustFinish = append(mustFinish, CappedBook{BookID: b.BookID, Title: b.Title, Status: b.Status, Deadline: b.Deadline})
+		titles = append(titles, fmt.Sprintf("『%s』(期限 %s)", b.Title, b.Deadline.In(cron.Location).Format("1月2日")))
+	}
+
+	message := fmt.Sprintf("却下です。未読の本はもう%d冊、上限の%d冊に達しています。『%s』を積む前に、%sを読み終えてください。話はそれからです。",
+		len(unread), limit, book.Title, strings.Join(titles, "、"))
+	if len(mustFinish) > 1 {
+		message = fmt.Sprintf("却下です。未読の本は%d冊、上限の%d冊を%d冊も超えています。『%s』を積む前に、%sの%d冊を読み終えてください。上限を決めたのはあなたです。",
+			len(unread), limit, len(unread)-limit, book.Title, strings.Join(titles, "、"), len(mustFinish))
+	}
+	return &UnreadCapError{Cap: limit, Unread: len(unread), MustFinish: mustFinish, Message: message}
+}
+
+// writeUnreadCap は未読の本の上限で登録を断ったことを 422 で返す
+func writeUnreadCap(w http.ResponseWriter, r *http.Request, capErr *UnreadCapError) {
+	p := unreadCapProblem{
+		Problem: Problem{
+			Type:          problemTypeUnreadCap,
+			Title:         "Unread cap reached",
+			Status:        http.StatusUnprocessableEntity,
+			Detail:        "Finish the books in mustFinish before registering another one.",
+			Instance:      r.URL.Path,
+			CorrelationID: correlationID(r.Context()),
+		},
+		Cap:        capErr.Cap,
+		Unread:     capErr.Unread,
+		MustFinish: capErr.MustFinish,
+		Message:    capErr.Message,
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
@@ -0,0 +1,876 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"tundoku-killer/backend/internal/insult"
+	"tundoku-killer/backend/internal/isbn"
+	"tundoku-killer/backend/internal/recommend"
+	"tundoku-killer/backend/internal/store"
+	"tundoku-killer/backend/internal/validation"
+)
+
+const (
+	maxTitleLength  = 200
+	maxAuthorLength = 100
+	maxIDLength     = 128
+	maxInsultLevel  = 100
+	maxPages        = 100000
+	maxBookMinutes  = 10000 // オーディオブックの長さ (分)
+	maxISBNLength   = 17    // ハイフン付きの ISBN-13
+	maxPrice        = 1000000
+	maxStoreLength  = 100 // 買った店の名前
+	maxRating       = 5   // 星の数
+	maxTags         = 10
+	maxTagLength    = 30
+)
+
+// bookStatuses は Book.Status に設定できる値
+var bookStatuses = []string{"unread", "reading", "completed", "insulted", "abandoned"}
+
+// bookFormats は Book.Format に設定できる値 (空も可)
+var bookFormats = []string{store.FormatPaper, store.FormatEbook, store.FormatAudiobook}
+
+// bookOwnerships は Book.Ownership に設定できる値
+var bookOwnerships = []string{store.OwnershipWishlist, store.OwnershipOwned, store.OwnershipBorrowed}
+
+// Validate はLINE認証リクエストを検証する
+func (req LineAuthRequest) Validate() error {
+	var v validation.Validator
+	v.Required("lineAccessToken", req.LineAccessToken)
+	v.Required("lineUserID", req.LineUserID)
+	v.MaxLength("lineUserID", req.LineUserID, maxIDLength)
+	return v.Err()
+}
+
+// validateBookFields は登録・更新で共通の項目を検証する
+func validateBookFields(v *validation.Validator, book store.Book) {
+	v.Required("title", book.Title)
+	v.MaxLength("title", book.Title, maxTitleLength)
+	v.Required("author", book.Author)
+	v.MaxLength("author", book.Author, maxAuthorLength)
+	v.Required("userId", book.UserID)
+	v.MaxLength("userId", book.UserID, maxIDLength)
+	v.OneOf("status", book.Status, bookStatuses...)
+	v.Range("insultLevel", book.InsultLevel, 0, maxInsultLevel)
+	v.Range("pages", book.Pages, 0, maxPages)
+	v.Range("minutes", book.Minutes, 0, maxBookMinutes)
+	v.MaxLength("isbn", book.ISBN, maxISBNLength)
+	v.Range("price", book.Price, 0, maxPrice)
+	v.Range("rating", book.Rating, 0, maxRating)
+	v.MaxLength("purchaseStore", book.PurchaseStore, maxStoreLength)
+	v.Check(len(book.DependsOn) <= maxDependencies, "dependsOn", fmt.Sprintf("must have at most %d books", maxDependencies))
+	if book.Format != "" {
+		v.OneOf("format", book.Format, bookFormats...)
+	}
+	v.Check(len(book.Tags) <= maxTags, "tags", fmt.Sprintf("must have at most %d tags", maxTags))
+	for i, tag := range book.Tags {
+		v.MaxLength(fmt.Sprintf("tags[%d]", i), tag, maxTagLength)
+	}
+}
+
+// normalizeTags はタグの前後の空白を除き、空のタグと重なったタグ (大文字・小文字と空白を無視) を除く
+func normalizeTags(tags []string) []string {
+	var normalized []string
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		key := recommend.Key(tag)
+		if key == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		normalized = append(normalized, tag)
+	}
+	return normalized
+}
+
+// validateISBN は ISBN が空か、検査数字の合う ISBN-10 / ISBN-13 であることを確認する。
+// 更新では確かめない (導入前に登録した本の ISBN が正しくなくても、ほかの項目は変えられるように)
+func validateISBN(v *validation.Validator, code string) {
+	v.Check(code == "" || isbn.Valid(code), "isbn", "must be a valid ISBN-10 or ISBN-13")
+}
+
+// validateNewBook は書籍登録リクエストを検証する。期限は未来の日時でなければならない (ウィッシュリストの本は期限なし)
+func validateNewBook(book store.Book, now time.Time) error {
+	var v validation.Validator
+	validateBookFields(&v, book)
+	validateISBN(&v, book.ISBN)
+	v.OneOf("ownership", book.Ownership, bookOwnerships...)
+	if !book.OnWishlist() {
+		v.Future("deadline", book.Deadline, now)
+	}
+	if book.PurchasedAt != nil {
+		v.Check(!book.OnWishlist(), "purchasedAt", "must be empty for a wishlist book")
+		v.Check(!book.PurchasedAt.After(now), "purchasedAt", "must not be in the future")
+	}
+	return v.Err()
+}
+
+// validateBookUpdate は書籍更新リクエストを検証する。既存の本は期限切れのまま更新できる
+func validateBookUpdate(book store.Book) error {
+	var v validation.Validator
+	v.Required("bookId", book.BookID)
+	validateBookFields(&v, book)
+	v.Check(!book.Deadline.IsZero() || book.OnWishlist(), "deadline", "is required")
+	return v.Err()
+}
+
+// deleteBookRequest は書籍削除リクエスト
+type deleteBookRequest struct {
+	BookID string `json:"bookId"`
+	UserID string `json:"userId"`
+}
+
+func (req deleteBookRequest) Validate() error {
+	var v validation.Validator
+	v.Required("bookId", req.BookID)
+	v.Required("userId", req.UserID)
+	return v.Err()
+}
+
+// completeBookRequest は読了リクエスト
+type completeBookRequest struct {
+	BookID string `json:"bookId"`
+}
+
+func (req completeBookRequest) Validate() error {
+	var v validation.Validator
+	v.Required("bookId", req.BookID)
+	return v.Err()
+}
+
+const (
+	maxWebhookURLLength = 2048
+	maxWebhooksPerUser  = 10
+)
+
+const (
+	maxAPIKeyNameLength = 100
+	maxAPIKeysPerUser   = 10
+)
+
+// issueAPIKeyRequest は API キーの発行リクエスト
+type issueAPIKeyRequest struct {
+	UserID string `json:"userId"`
+	Name   string `json:"name"`  // 用途のメモ ("Shortcuts" など)
+	Scope  string `json:"scope"` // "read" か "write"
+}
+
+func (req issueAPIKeyRequest) Validate() error {
+	var v validation.Validator
+	v.Required("userId", req.UserID)
+	v.MaxLength("userId", req.UserID, maxIDLength)
+	v.Required("name", req.Name)
+	v.MaxLength("name", req.Name, maxAPIKeyNameLength)
+	v.OneOf("scope", req.Scope, apiKeyScopeRead, apiKeyScopeWrite)
+	return v.Err()
+}
+
+// revokeAPIKeyRequest は API キーの失効リクエスト
+type revokeAPIKeyRequest struct {
+	KeyID  string `json:"keyId"`
+	UserID string `json:"userId"`
+}
+
+func (req revokeAPIKeyRequest) Validate() error {
+	var v validation.Validator
+	v.Required("keyId", req.KeyID)
+	v.Required("userId", req.UserID)
+	return v.Err()
+}
+
+// webhookEventTypes は Webhook で購読できるイベント
+var webhookEventTypes = []string{webhookBookRegistered, webhookBookOverdue, webhookBookCompleted}
+
+// registerWebhookRequest は Webhook の登録リクエスト。Events が空ならすべてのイベントを送る
+type registerWebhookRequest struct {
+	UserID string   `json:"userId"`
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+}
+
+func (req registerWebhookRequest) Validate() error {
+	var v validation.Validator
+	v.Required("userId", req.UserID)
+	v.MaxLength("userId", req.UserID, maxIDLength)
+	v.Required("url", req.URL)
+	v.MaxLength("url", req.URL, maxWebhookURLLength)
+	u, err := url.Parse(req.URL)
+	v.Check(err == nil && u.Scheme == "https" && u.Host != "", "url", "must be an absolute https URL")
+	for _, ev := range req.Events {
+		v.OneOf("events", ev, webhookEventTypes...)
+	}
+	return v.Err()
+}
+
+// deleteWebhookRequest は Webhook の削除リクエスト
+type deleteWebhookRequest struct {
+	WebhookID string `json:"webhookId"`
+	UserID    string `json:"userId"`
+}
+
+func (req deleteWebhookRequest) Validate() error {
+	var v validation.Validator
+	v.Required("webhookId", req.WebhookID)
+	v.Required("userId", req.UserID)
+	return v.Err()
+}
+
+const maxDisplayNameLength = 50
+
+// validateSettings は設定の更新を検証する
+func validateSettings(s store.UserSettings) error {
+	var v validation.Validator
+	v.Required("userId", s.UserID)
+	v.MaxLength("userId", s.UserID, maxIDLength)
+	v.MaxLength("displayName", s.DisplayName, maxDisplayNameLength)
+	v.OneOf("shameWall", s.ShameWall, "", shameWallAnonymous, shameWallNamed)
+	v.Range("purchaseBanLimit", s.PurchaseBanLimit, 0, maxPurchaseBanLimit)
+	v.Range("unreadHardCap", s.UnreadHardCap, 0, maxPurchaseBanLimit)
+	v.Check(s.UnreadHardCap == 0 || s.PurchaseBanLimit == 0 || s.UnreadHardCap > s.PurchaseBanLimit,
+		"unreadHardCap", "must be greater than purchaseBanLimit")
+	validateBlockedTerms(&v, "blockedTerms", s.BlockedTerms)
+	v.Check(len(s.LibrarySystems) <= maxLibrarySystems, "librarySystems", fmt.Sprintf("must have at most %d library systems", maxLibrarySystems))
+	for i, systemID := range s.LibrarySystems {
+		name := fmt.Sprintf("librarySystems[%d]", i)
+		v.Required(name, systemID)
+		v.MaxLength(name, systemID, maxIDLength)
+		// カーリルへの問い合わせでは "," 区切りで並べるので、"," を含む ID は受け付けない
+		v.Check(!strings.Contains(systemID, ","), name, "must not contain a comma")
+	}
+	return v.Err()
+}
+
+// maxLibrarySystems は近所の図書館として設定できる図書館システムの数
+const maxLibrarySystems = 5
+
+// maxPurchaseBanLimit は購入禁止モードと未読の本の上限に設定できる冊数
+const maxPurchaseBanLimit = 10000
+
+const (
+	maxBlockedTerms      = 100
+	maxBlockedTermLength = 50
+)
+
+// validateBlockedTerms は煽り文に入れない語句を確かめる。記号だけの語句は比べようがないので受け付けない
+func validateBlockedTerms(v *validation.Validator, field string, terms []string) {
+	v.Check(len(terms) <= maxBlockedTerms, field, fmt.Sprintf("must have at most %d terms", maxBlockedTerms))
+	for i, term := range terms {
+		name := fmt.Sprintf("%s[%d]", field, i)
+		v.MaxLength(name, term, maxBlockedTermLength)
+		v.Check(insult.Normalize(term) != "", name, "must contain a letter or number")
+	}
+}
+
+// friendRequest は友達・見張り役の操作のリクエスト。userId が操作する本人
+type friendRequest struct {
+	UserID   string `json:"userId"`
+	FriendID string `json:"friendId"`
+}
+
+func (req friendRequest) Validate() error {
+	var v validation.Validator
+	v.Required("userId", req.UserID)
+	v.MaxLength("userId", req.UserID, maxIDLength)
+	v.Required("friendId", req.FriendID)
+	v.MaxLength("friendId", req.FriendID, maxIDLength)
+	v.Check(req.UserID != req.FriendID, "friendId", "must differ from userId")
+	return v.Err()
+}
+
+// createInviteRequest は招待リンクの発行リクエスト
+type createInviteRequest struct {
+	UserID string `json:"userId"`
+}
+
+func (req createInviteRequest) Validate() error {
+	var v validation.Validator
+	v.Required("userId", req.UserID)
+	v.MaxLength("userId", req.UserID, maxIDLength)
+	return v.Err()
+}
+
+// acceptInviteRequest は招待リンクを開いたユーザーの承認リクエスト
+type acceptInviteRequest struct {
+	UserID string `json:"userId"`
+	Token  string `json:"token"`
+}
+
+func (req acceptInviteRequest) Validate() error {
+	var v validation.Validator
+	v.Required("userId", req.UserID)
+	v.MaxLength("userId", req.UserID, maxIDLength)
+	v.Required("token", req.Token)
+	v.MaxLength("token", req.Token, 64)
+	return v.Err()
+}
+
+const maxGroupNameLength = 50
+
+// createGroupRequest は読書会の作成リクエスト
+type createGroupRequest struct {
+	UserID      string `json:"userId"`
+	Name        string `json:"name"`
+	LineGroupID string `json:"lineGroupId"`
+}
+
+func (req createGroupRequest) Validate() error {
+	var v validation.Validator
+	v.Required("userId", req.UserID)
+	v.MaxLength("userId", req.UserID, maxIDLength)
+	v.Required("name", req.Name)
+	v.MaxLength("name", req.Name, maxGroupNameLength)
+	v.MaxLength("lineGroupId", req.LineGroupID, maxIDLength)
+	return v.Err()
+}
+
+// groupMemberRequest は読書会への招待・参加・退会のリクエスト。inviteeId は招待のときだけ使う
+type groupMemberRequest struct {
+	UserID    string `json:"userId"`
+	GroupID   string `json:"groupId"`
+	InviteeID string `json:"inviteeId"`
+}
+
+func (req groupMemberRequest) Validate() error {
+	var v validation.Validator
+	v.Required("userId", req.UserID)
+	v.MaxLength("userId", req.UserID, maxIDLength)
+	v.Required("groupId", req.GroupID)
+	v.MaxLength("groupId", req.GroupID, maxIDLength)
+	v.MaxLength("inviteeId", req.InviteeID, maxIDLength)
+	return v.Err()
+}
+
+// assignGroupBookRequest は読書会の課題本の設定リクエスト
+type assignGroupBookRequest struct {
+	UserID   string    `json:"userId"`
+	GroupID  string    `json:"groupId"`
+	Title    string    `json:"title"`
+	Author   string    `json:"author"`
+	Pages    int       `json:"pages"`
+	Deadline time.Time `json:"deadline"`
+}
+
+func (req assignGroupBookRequest) Validate(now time.Time) error {
+	var v validation.Validator
+	v.Required("userId", req.UserID)
+	v.Required("groupId", req.GroupID)
+	v.Required("title", req.Title)
+	v.MaxLength("title", req.Title, maxTitleLength)
+	v.Required("author", req.Author)
+	v.MaxLength("author", req.Author, maxAuthorLength)
+	v.Range("pages", req.Pages, 0, maxPages)
+	v.Future("deadline", req.Deadline, now)
+	return v.Err()
+}
+
+// shelfShareRequest は本棚の共有リクエスト。viewerId を省略すると URL での共有になる
+type shelfShareRequest struct {
+	UserID   string `json:"userId"`
+	ViewerID string `json:"viewerId"`
+}
+
+func (req shelfShareRequest) Validate() error {
+	var v validation.Validator
+	v.Required("userId", req.UserID)
+	v.MaxLength("userId", req.UserID, maxIDLength)
+	v.MaxLength("viewerId", req.ViewerID, maxIDLength)
+	v.Check(req.ViewerID != req.UserID, "viewerId", "must differ from userId")
+	return v.Err()
+}
+
+// deleteShelfShareRequest は本棚の共有の取り消しリクエスト
+type deleteShelfShareRequest struct {
+	UserID  string `json:"userId"`
+	ShareID string `json:"shareId"`
+}
+
+func (req deleteShelfShareRequest) Validate() error {
+	var v validation.Validator
+	v.Required("userId", req.UserID)
+	v.Required("shareId", req.ShareID)
+	v.MaxLength("shareId", req.ShareID, 2*maxIDLength+2)
+	return v.Err()
+}
+
+const (
+	maxPledgeAmount          = 1000000
+	maxPledgeRecipientLength = 100
+)
+
+// pledgeRequest は誓約の設定・取り消し・支払い済みのリクエスト。金額などは設定のときだけ使う
+type pledgeRequest struct {
+	UserID     string `json:"userId"`
+	BookID     string `json:"bookId"`
+	Amount     int    `json:"amount"`
+	Recipient  string `json:"recipient"`
+	PaymentURL string `json:"paymentUrl"`
+}
+
+func (req pledgeRequest) Validate(create bool) error {
+	var v validation.Validator
+	v.Required("userId", req.UserID)
+	v.Required("bookId", req.BookID)
+	if create {
+		v.Range("amount", req.Amount, 1, maxPledgeAmount)
+		v.Required("recipient", req.Recipient)
+		v.MaxLength("recipient", req.Recipient, maxPledgeRecipientLength)
+		v.MaxLength("paymentUrl", req.PaymentURL, maxWebhookURLLength)
+		if req.PaymentURL != "" {
+			u, err := url.Parse(req.PaymentURL)
+			v.Check(err == nil && u.Scheme == "https" && u.Host != "", "paymentUrl", "must be an absolute https URL")
+		}
+	}
+	return v.Err()
+}
+
+// updateProfileRequest はプロフィールの更新リクエスト
+type updateProfileRequest struct {
+	UserID      string `json:"userId"`
+	DisplayName string `json:"displayName"`
+	PictureURL  string `json:"pictureUrl"`
+}
+
+func (req updateProfileRequest) Validate() error {
+	var v validation.Validator
+	v.Required("userId", req.UserID)
+	v.MaxLength("userId", req.UserID, maxIDLength)
+	v.Required("displayName", req.DisplayName)
+	v.MaxLength("displayName", req.DisplayName, maxDisplayNameLength)
+	v.MaxLength("pictureUrl", req.PictureURL, maxWebhookURLLength)
+	if req.PictureURL != "" {
+		u, err := url.Parse(req.PictureURL)
+		v.Check(err == nil && u.Scheme == "https" && u.Host != "", "pictureUrl", "must be an absolute https URL")
+	}
+	return v.Err()
+}
+
+// linkLineRequest は Google などのアカウントに LINE をつなぐリクエスト
+type linkLineRequest struct {
+	IDToken         string `json:"idToken"`
+	LineAccessToken string `json:"lineAccessToken"`
+	LineUserID      string `json:"lineUserID"`
+}
+
+func (req linkLineRequest) Validate() error {
+	var v validation.Validator
+	v.Required("idToken", req.IDToken)
+	v.Required("lineAccessToken", req.LineAccessToken)
+	v.Required("lineUserID", req.LineUserID)
+	v.MaxLength("lineUserID", req.LineUserID, maxIDLength)
+	return v.Err()
+}
+
+// mergeAccountsRequest はアカウントの統合リクエスト。secondaryIdToken のアカウントを idToken のアカウントにまとめる
+type mergeAccountsRequest struct {
+	IDToken          string `json:"idToken"`
+	SecondaryIDToken string `json:"secondaryIdToken"`
+}
+
+func (req mergeAccountsRequest) Validate() error {
+	var v validation.Validator
+	v.Required("idToken", req.IDToken)
+	v.Required("secondaryIdToken", req.SecondaryIDToken)
+	return v.Err()
+}
+
+// googleAuthRequest は Google アカウントでのログインリクエスト
+type googleAuthRequest struct {
+	IDToken string `json:"idToken"`
+}
+
+func (req googleAuthRequest) Validate() error {
+	var v validation.Validator
+	v.Required("idToken", req.IDToken)
+	return v.Err()
+}
+
+// maxDisableReasonLength はアカウントを停止する理由の最大の長さ
+const maxDisableReasonLength = 500
+
+// disableUserRequest は運用者がアカウントを停止するリクエスト
+type disableUserRequest struct {
+	UserID string `json:"userId"`
+	Reason string `json:"reason"`
+}
+
+func (req disableUserRequest) Validate() error {
+	var v validation.Validator
+	v.Required("userId", req.UserID)
+	v.MaxLength("userId", req.UserID, maxIDLength)
+	v.MaxLength("reason", req.Reason, maxDisableReasonLength)
+	return v.Err()
+}
+
+const maxFeedbackCommentLength = 500
+
+// insultFeedbackRequest は煽り文への評価のリクエスト
+type insultFeedbackRequest struct {
+	UserID  string `json:"userId"`
+	Rating  string `json:"rating"`
+	Comment string `json:"comment"`
+}
+
+func (req insultFeedbackRequest) Validate() error {
+	var v validation.Validator
+	v.Required("userId", req.UserID)
+	v.MaxLength("userId", req.UserID, maxIDLength)
+	v.OneOf("rating", req.Rating, feedbackHit, feedbackMiss, feedbackOffensive)
+	v.MaxLength("comment", req.Comment, maxFeedbackCommentLength)
+	return v.Err()
+}
+
+const maxPromptLength = 4000
+
+// promptRequest は生成AIへの指示を書き換えるリクエスト。本文は text/template ({{.Title}} など) として読めなければならない
+type promptRequest struct {
+	Text string `json:"text"`
+}
+
+func (req promptRequest) Validate() error {
+	var v validation.Validator
+	v.Required("text", req.Text)
+	v.MaxLength("text", req.Text, maxPromptLength)
+	if req.Text != "" {
+		err := insult.ParsePrompt("text", req.Text)
+		v.Check(err == nil, "text", fmt.Sprintf("is not a valid template: %v", err))
+	}
+	return v.Err()
+}
+
+// blocklistRequest は全員分の煽り文に入れない語句を置き換えるリクエスト
+type blocklistRequest struct {
+	Terms []string `json:"terms"`
+}
+
+func (req blocklistRequest) Validate() error {
+	var v validation.Validator
+	validateBlockedTerms(&v, "terms", req.Terms)
+	return v.Err()
+}
+
+// quizAnswersRequest は読了クイズの答え。answers は問題の順に、選んだ選択肢の添字
+type quizAnswersRequest struct {
+	UserID  string `json:"userId"`
+	BookID  string `json:"bookId"`
+	Answers []int  `json:"answers"`
+}
+
+func (req quizAnswersRequest) Validate() error {
+	var v validation.Validator
+	v.Required("userId", req.UserID)
+	v.MaxLength("userId", req.UserID, maxIDLength)
+	v.Required("bookId", req.BookID)
+	v.Check(len(req.Answers) > 0, "answers", "is required")
+	return v.Err()
+}
+
+// proofUploadRequest は証拠写真のアップロード用URLの発行
+type proofUploadRequest struct {
+	UserID      string `json:"userId"`
+	BookID      string `json:"bookId"`
+	ContentType string `json:"contentType"`
+}
+
+func (req proofUploadRequest) Validate() error {
+	var v validation.Validator
+	v.Required("userId", req.UserID)
+	v.MaxLength("userId", req.UserID, maxIDLength)
+	v.Required("bookId", req.BookID)
+	_, ok := proofContentTypes[req.ContentType]
+	v.Check(ok, "contentType", "must be one of image/jpeg, image/png, image/webp, image/heic")
+	return v.Err()
+}
+
+// attachProofRequest はアップロードした証拠写真の結びつけ
+type attachProofRequest struct {
+	UserID  string `json:"userId"`
+	BookID  string `json:"bookId"`
+	ProofID string `json:"proofId"`
+	Caption string `json:"caption"`
+}
+
+func (req attachProofRequest) Validate() error {
+	var v validation.Validator
+	v.Required("userId", req.UserID)
+	v.MaxLength("userId", req.UserID, maxIDLength)
+	v.Required("bookId", req.BookID)
+	v.Required("proofId", req.ProofID)
+	v.MaxLength("caption", req.Caption, maxCaptionLength)
+	return v.Err()
+}
+
+// readingSessionRequest は読書の記録。pages は今回読んだページ数、minutes は読んだ (オーディオブックなら聞いた) 分数。
+// どちらが要るかは本の形式による (validateProgress)
+type readingSessionRequest struct {
+	UserID  string `json:"userId"`
+	BookID  string `json:"bookId"`
+	Pages   int    `json:"pages"`
+	Minutes int    `json:"minutes"`
+}
+
+func (req readingSessionRequest) Validate() error {
+	var v validation.Validator
+	v.Required("userId", req.UserID)
+	v.MaxLength("userId", req.UserID, maxIDLength)
+	v.Required("bookId", req.BookID)
+	v.Range("pages", req.Pages, 0, maxPages)
+	v.Range("minutes", req.Minutes, 0, 24*60)
+	return v.Err()
+}
+
+// validateProgress は book の形式に合った進み具合があるかを確認する。オーディオブックは minutes (聞いた分数)、それ以外は pages
+func validateProgress(book store.Book, pages, minutes int) error {
+	var v validation.Validator
+	if book.Audiobook() {
+		v.Check(minutes > 0, "minutes", "is required for an audiobook")
+	} else {
+		v.Check(pages > 0, "pages", "is required")
+	}
+	return v.Err()
+}
+
+// negotiateDeadlineRequest は期限の交渉。deadline は希望する新しい期限
+type negotiateDeadlineRequest struct {
+	UserID   string    `json:"userId"`
+	BookID   string    `json:"bookId"`
+	Deadline time.Time `json:"deadline"`
+}
+
+func (req negotiateDeadlineRequest) Validate(now time.Time) error {
+	var v validation.Validator
+	v.Required("userId", req.UserID)
+	v.MaxLength("userId", req.UserID, maxIDLength)
+	v.Required("bookId", req.BookID)
+	v.Future("deadline", req.Deadline, now)
+	return v.Err()
+}
+
+// acceptNegotiationRequest は期限の交渉の対案の受け入れ
+type acceptNegotiationRequest struct {
+	UserID        string `json:"userId"`
+	NegotiationID string `json:"negotiationId"`
+}
+
+func (req acceptNegotiationRequest) Validate() error {
+	var v validation.Validator
+	v.Required("userId", req.UserID)
+	v.MaxLength("userId", req.UserID, maxIDLength)
+	v.Required("negotiationId", req.NegotiationID)
+	return v.Err()
+}
+
+// checkinRequest は毎晩のチェックイン。pages は今日読んだページ数、オーディオブックなら minutes に今日聞いた分数
+type checkinRequest struct {
+	UserID  string `json:"userId"`
+	BookID  string `json:"bookId"`
+	Pages   int    `json:"pages"`
+	Minutes int    `json:"minutes"`
+}
+
+func (req checkinRequest) Validate() error {
+	var v validation.Validator
+	v.Required("userId", req.UserID)
+	v.MaxLength("userId", req.UserID, maxIDLength)
+	v.Required("bookId", req.BookID)
+	v.Range("pages", req.Pages, 0, maxPages)
+	v.Range("minutes", req.Minutes, 0, 24*60)
+	return v.Err()
+}
+
+// startTimerRequest は読書タイマーの開始
+type startTimerRequest struct {
+	UserID string `json:"userId"`
+}
+
+func (req startTimerRequest) Validate() error {
+	var v validation.Validator
+	v.Required("userId", req.UserID)
+	v.MaxLength("userId", req.UserID, maxIDLength)
+	return v.Err()
+}
+
+// stopTimerRequest は読書タイマーの停止。pages はタイマーの間に読んだページ数 (数えていなければ 0)。
+// オーディオブックはタイマーの時間を聞いた分数として数え、pages は無視する
+type stopTimerRequest struct {
+	UserID string `json:"userId"`
+	Pages  int    `json:"pages"`
+}
+
+func (req stopTimerRequest) Validate() error {
+	var v validation.Validator
+	v.Required("userId", req.UserID)
+	v.MaxLength("userId", req.UserID, maxIDLength)
+	v.Range("pages", req.Pages, 0, maxPages)
+	return v.Err()
+}
+
+// bookDependenciesRequest は本の先に読む本の設定。空のリストで外す
+type bookDependenciesRequest struct {
+	UserID    string   `json:"userId"`
+	DependsOn []string `json:"dependsOn"`
+}
+
+func (req bookDependenciesRequest) Validate() error {
+	var v validation.Validator
+	v.Required("userId", req.UserID)
+	v.MaxLength("userId", req.UserID, maxIDLength)
+	v.Check(len(req.DependsOn) <= maxDependencies, "dependsOn", fmt.Sprintf("must have at most %d books", maxDependencies))
+	for i, id := range req.DependsOn {
+		name := fmt.Sprintf("dependsOn[%d]", i)
+		v.Required(name, id)
+		v.MaxLength(name, id, maxIDLength)
+	}
+	return v.Err()
+}
+
+// bookOwnershipRequest は本の持ち方の変更。deadline はウィッシュリストから外すときに必要で、それ以外では無視する
+type bookOwnershipRequest struct {
+	UserID    string    `json:"userId"`
+	Ownership string    `json:"ownership"`
+	Deadline  time.Time `json:"deadline"`
+}
+
+func (req bookOwnershipRequest) Validate(now time.Time) error {
+	var v validation.Validator
+	v.Required("userId", req.UserID)
+	v.MaxLength("userId", req.UserID, maxIDLength)
+	v.OneOf("ownership", req.Ownership, bookOwnerships...)
+	if !req.Deadline.IsZero() {
+		v.Future("deadline", req.Deadline, now)
+	}
+	return v.Err()
+}
+
+// purchaseRequest は本を買った日・店・価格の設定。price を省略すると価格は変えない
+type purchaseRequest struct {
+	UserID      string    `json:"userId"`
+	PurchasedAt time.Time `json:"purchasedAt"`
+	Store       string    `json:"store"`
+	Price       *int      `json:"price"`
+}
+
+func (req purchaseRequest) Validate(now time.Time) error {
+	var v validation.Validator
+	v.Required("userId", req.UserID)
+	v.MaxLength("userId", req.UserID, maxIDLength)
+	v.Check(!req.PurchasedAt.IsZero(), "purchasedAt", "is required")
+	v.Check(!req.PurchasedAt.After(now), "purchasedAt", "must not be in the future")
+	v.MaxLength("store", req.Store, maxStoreLength)
+	if req.Price != nil {
+		v.Range("price", *req.Price, 0, maxPrice)
+	}
+	return v.Err()
+}
+
+// libraryLoanRequest は図書館から借りた本の返却期限の設定。dueDate を省略すると borrowedAt (省略すると今) から
+// loanDays 日後 (省略すると LIBRARY_LOAN_DAYS)。systemId はカーリルの図書館システムID
+type libraryLoanRequest struct {
+	UserID     string    `json:"userId"`
+	SystemID   string    `json:"systemId"`
+	BorrowedAt time.Time `json:"borrowedAt"`
+	DueDate    time.Time `json:"dueDate"`
+	LoanDays   int       `json:"loanDays"`
+}
+
+// maxLibraryLoanDays は貸出期間として受け付ける日数の上限
+const maxLibraryLoanDays = 365
+
+func (req libraryLoanRequest) Validate(now time.Time) error {
+	var v validation.Validator
+	v.Required("userId", req.UserID)
+	v.MaxLength("userId", req.UserID, maxIDLength)
+	v.MaxLength("systemId", req.SystemID, maxIDLength)
+	v.Check(!req.BorrowedAt.After(now), "borrowedAt", "must not be in the future")
+	v.Range("loanDays", req.LoanDays, 0, maxLibraryLoanDays)
+	v.Check(req.DueDate.IsZero() || req.LoanDays == 0, "loanDays", "cannot be combined with dueDate")
+	v.Check(req.DueDate.IsZero() || req.BorrowedAt.IsZero() || req.DueDate.After(req.BorrowedAt), "dueDate", "must be after borrowedAt")
+	return v.Err()
+}
+
+// priceWatchRequest はウィッシュリストの本の値下がりの見張りの設定
+type priceWatchRequest struct {
+	UserID    string `json:"userId"`
+	Threshold int    `json:"threshold"` // この価格 (円) 以下になったら知らせる
+}
+
+func (req priceWatchRequest) Validate() error {
+	var v validation.Validator
+	v.Required("userId", req.UserID)
+	v.MaxLength("userId", req.UserID, maxIDLength)
+	v.Range("threshold", req.Threshold, 1, maxPrice)
+	return v.Err()
+}
+
+// feedTokenRequest はカレンダーなどのフィードのトークンの発行
+type feedTokenRequest struct {
+	UserID string `json:"userId"`
+}
+
+func (req feedTokenRequest) Validate() error {
+	var v validation.Validator
+	v.Required("userId", req.UserID)
+	v.MaxLength("userId", req.UserID, maxIDLength)
+	return v.Err()
+}
+
+// listingRequest は諦めた本の出品の説明文の作成。condition を省略すると "like-new"
+type listingRequest struct {
+	UserID    string `json:"userId"`
+	Condition string `json:"condition"`
+	Notes     string `json:"notes"` // 説明文に添える補足
+}
+
+func (req listingRequest) Validate() error {
+	var v validation.Validator
+	v.Required("userId", req.UserID)
+	v.MaxLength("userId", req.UserID, maxIDLength)
+	v.OneOf("condition", req.Condition, "", "new", "like-new", "good", "fair", "worn", "poor")
+	v.MaxLength("notes", req.Notes, maxListingNotesLength)
+	return v.Err()
+}
+
+// lendBookRequest は本を貸した記録。lentAt を省略すると今。borrowerUserId は友達のときだけ指定でき、
+// notifyBorrower なら返却の催促をその人にも送る
+type lendBookRequest struct {
+	UserID         string    `json:"userId"`
+	BookID         string    `json:"bookId"`
+	BorrowerName   string    `json:"borrowerName"`
+	BorrowerUserID string    `json:"borrowerUserId"`
+	NotifyBorrower bool      `json:"notifyBorrower"`
+	LentAt         time.Time `json:"lentAt"`
+	DueAt          time.Time `json:"dueAt"`
+}
+
+func (req lendBookRequest) Validate(now time.Time) error {
+	var v validation.Validator
+	v.Required("userId", req.UserID)
+	v.MaxLength("userId", req.UserID, maxIDLength)
+	v.Required("bookId", req.BookID)
+	v.Required("borrowerName", req.BorrowerName)
+	v.MaxLength("borrowerName", req.BorrowerName, maxDisplayNameLength)
+	v.MaxLength("borrowerUserId", req.BorrowerUserID, maxIDLength)
+	v.Check(req.BorrowerUserID != req.UserID, "borrowerUserId", "must differ from userId")
+	v.Check(!req.NotifyBorrower || req.BorrowerUserID != "", "notifyBorrower", "requires borrowerUserId")
+	v.Check(!req.LentAt.After(now), "lentAt", "must not be in the future")
+	v.Check(!req.DueAt.IsZero(), "dueAt", "is required")
+	v.Check(req.LentAt.IsZero() || req.DueAt.After(req.LentAt), "dueAt", "must be after lentAt")
+	return v.Err()
+}
+
+// returnLoanRequest は貸した本が返ってきたことの記録
+type returnLoanRequest struct {
+	UserID string `json:"userId"`
+	LoanID string `json:"loanId"`
+}
+
+func (req returnLoanRequest) Validate() error {
+	var v validation.Validator
+	v.Required("userId", req.UserID)
+	v.Required("loanId", req.LoanID)
+	return v.Err()
+}
@@ -0,0 +1,385 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"tundoku-killer/backend/internal/store"
+	"tundoku-killer/backend/internal/validation"
+)
+
+// ユーザーが登録した URL (IFTTT、Zapier、自作スクリプトなど) に本のイベントを POST する。
+// 本文は登録時に発行した secret で HMAC-SHA256 署名する
+
+const (
+	webhookBookRegistered = "book.registered"
+	webhookBookOverdue    = "book.overdue"
+	webhookBookCompleted  = "book.completed"
+
+	// webhookTimeout は1回の配信の待ち時間
+	webhookTimeout = 10 * time.Second
+	// webhookAttempts は 5xx や通信エラーのときに試す回数
+	webhookAttempts = 3
+)
+
+// errWebhookAddr は Webhook の URL がサーバーの内部 (ループバック・プライベート・リンクローカルなど) を指しているときのエラー
+var errWebhookAddr = errors.New("must not point to a loopback, private, link-local or unspecified address")
+
+// sharedAddressSpace はキャリアグレード NAT のアドレス (RFC 6598)。IsPrivate に含まれないが外からは届かない
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// checkWebhookAddr は ip がサーバーの外のアドレスなら nil を返す。クラウドのメタデータサーバー (169.254.169.254) などへの SSRF を防ぐ
+func checkWebhookAddr(ip netip.Addr) error {
+	ip = ip.Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() || sharedAddressSpace.Contains(ip) {
+		return fmt.Errorf("%w (%s)", errWebhookAddr, ip)
+	}
+	return nil
+}
+
+// checkWebhookHost は rawURL のホストを名前解決し、どのアドレスもサーバーの外を指していれば nil を返す (登録時の確認)
+func checkWebhookHost(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", u.Hostname())
+	if err != nil {
+		return fmt.Errorf("could not be resolved: %w", err)
+	}
+	for _, addr := range addrs {
+		if err := checkWebhookAddr(addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// webhookDialer は配信のたびに、名前解決した後の接続先を checkWebhookAddr で確かめる。
+// 登録した後で DNS の応答を内部のアドレスに変えられても (DNS rebinding) 接続しない
+var webhookDialer = &net.Dialer{
+	Timeout: webhookTimeout,
+	Control: func(_, address string, _ syscall.RawConn) error {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		ip, err := netip.ParseAddr(host)
+		if err != nil {
+			return err
+		}
+		return checkWebhookAddr(ip)
+	},
+}
+
+// webhookHTTPClient は Webhook の配信に使う http.Client。プロキシは通さず (通すと接続先を確かめられない)、webhookDialer で接続する。
+// リダイレクト先への接続も webhookDialer で確かめる
+var webhookHTTPClient = &http.Client{
+	Transport: otelhttp.NewTransport(&http.Transport{
+		DialContext:         webhookDialer.DialContext,
+		TLSHandshakeTimeout: webhookTimeout,
+		MaxIdleConns:        10,
+		IdleConnTimeout:     90 * time.Second,
+	}),
+}
+
+// Webhook は webhooks コレクションに保存する配信先。Secret は登録時のレスポンスでしか返さない
+type Webhook struct {
+	WebhookID string    `json:"webhookId" firestore:"webhookId"`
+	UserID    string    `json:"userId" firestore:"userId"`
+	URL       string    `json:"url" firestore:"url"`
+	Events    []string  `json:"events" firestore:"events"` // 空ならすべてのイベント
+	Secret    string    `json:"secret,omitempty" firestore:"secret"`
+	CreatedAt time.Time `json:"createdAt" firestore:"createdAt"`
+}
+
+// WebhookPayload は配信する JSON 本文
+type WebhookPayload struct {
+	ID        string     `json:"id"` // 配信ごとのID。受信側での重複排除に使う
+	Type      string     `json:"type"`
+	CreatedAt time.Time  `json:"createdAt"`
+	Book      store.Book `json:"book"`
+}
+
+// wants は w が eventType を購読しているかを返す
+func (w Webhook) wants(eventType string) bool {
+	return len(w.Events) == 0 || slices.Contains(w.Events, eventType)
+}
+
+// handleWebhooks は Webhook の一覧・登録・削除を行う
+func (s *Server) handleWebhooks(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.handleListWebhooks(w, r)
+	case http.MethodPost:
+		s.handleRegisterWebhook(w, r)
+	case http.MethodDelete:
+		s.handleDeleteWebhook(w, r)
+	default:
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// handleListWebhooks は ?userId= のユーザーの Webhook を返す (secret は含めない)
+func (s *Server) handleListWebhooks(w http.ResponseWriter, r *http.Request) {
+	userID := r.URL.Query().Get("userId")
+	if userID == "" {
+		writeProblem(w, r, http.StatusBadRequest, "userId query parameter is required")
+		return
+	}
+
+	hooks, err := s.listWebhooks(r.Context(), userID)
+	if err != nil {
+		writeServerError(w, r, err, "Failed to retrieve webhooks")
+		return
+	}
+	for i := range hooks {
+		hooks[i].Secret = ""
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hooks)
+}
+
+// handleRegisterWebhook は Webhook を登録し、署名用の secret を1度だけ返す
+func (s *Server) handleRegisterWebhook(w http.ResponseWriter, r *http.Request) {
+	var req registerWebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid JSON body: %v", err))
+		return
+	}
+	if err := req.Validate(); err != nil {
+		writeValidationError(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	if err := checkWebhookHost(ctx, req.URL); err != nil {
+		writeValidationError(w, r, validation.Errors{{Field: "url", Message: err.Error()}})
+		return
+	}
+
+	existing, err := s.listWebhooks(ctx, req.UserID)
+	if err != nil {
+		writeServerError(w, r, err, "Failed to retrieve webhooks")
+		return
+	}
+	if len(existing) >= maxWebhooksPerUser {
+		writeProblem(w, r, http.StatusConflict, fmt.Sprintf("At most %d webhooks can be registered", maxWebhooksPerUser))
+		return
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		writeServerError(w, r, err, "Failed to generate webhook secret")
+		return
+	}
+
+	docRef := s.firestoreClient.Collection("webhooks").NewDoc()
+	hook := Webhook{
+		WebhookID: docRef.ID,
+		UserID:    req.UserID,
+		URL:       req.URL,
+		Events:    req.Events,
+		Secret:    hex.EncodeToString(secret),
+		CreatedAt: time.Now(),
+	}
+	if _, err := docRef.Set(ctx, hook); err != nil {
+		writeServerError(w, r, err, "Failed to save webhook")
+		return
+	}
+
+	s.logger.Printf("Webhook registered: %s (user: %s)", hook.WebhookID, hook.UserID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(hook)
+}
+
+// handleDeleteWebhook はユーザーが所持している Webhook を削除する
+func (s *Server) handleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	var req deleteWebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, fmt.Sprintf("Invalid JSON body: %v", err))
+		return
+	}
+	if err := req.Validate(); err != nil {
+		writeValidationError(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	docRef := s.firestoreClient.Collection("webhooks").Doc(req.WebhookID)
+	doc, err := docRef.Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		writeProblem(w, r, http.StatusNotFound, "Webhook not found")
+		return
+	}
+	if err != nil {
+		writeServerError(w, r, err, "Failed to retrieve webhook")
+		return
+	}
+	var hook Webhook
+	if err := doc.DataTo(&hook); err != nil {
+		writeServerError(w, r, err, "Failed to parse webhook")
+		return
+	}
+	if hook.UserID != req.UserID {
+		writeProblem(w, r, http.StatusUnauthorized, "Unauthorized to delete this webhook")
+		return
+	}
+
+	if _, err := docRef.Delete(ctx); err != nil {
+		writeServerError(w, r, err, "Failed to delete webhook")
+		return
+	}
+
+	s.logger.Printf("Webhook deleted: %s", req.WebhookID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listWebhooks は userID が登録した Webhook をすべて返す
+func (s *Server) listWebhooks(ctx context.Context, userID string) ([]Webhook, error) {
+	docs, err := s.firestoreClient.Collection("webhooks").
+		Where("userId", "==", userID).
+		Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	hooks := []Webhook{}
+	for _, doc := range docs {
+		var hook Webhook
+		if err := doc.DataTo(&hook); err != nil {
+			s.logger.Printf("Error parsing webhook %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		hooks = append(hooks, hook)
+	}
+	return hooks, nil
+}
+
+// notifyWebhooks は book の所持者が eventType を購読している Webhook へバックグラウンドで配信する。
+// 配信の成否は呼び出し元の処理に影響させない
+func (s *Server) notifyWebhooks(ctx context.Context, eventType string, book store.Book) {
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		hooks, err := s.listWebhooks(ctx, book.UserID)
+		if err != nil {
+			s.logger.Printf("Error fetching webhooks for user %s: %v", book.UserID, err)
+			return
+		}
+
+		payload := WebhookPayload{
+			ID:        uuid.NewString(),
+			Type:      eventType,
+			CreatedAt: time.Now(),
+			Book:      book,
+		}
+		body, err := json.Marshal(payload)
+		if err != nil {
+			s.logger.Printf("Error encoding webhook payload: %v", err)
+			return
+		}
+
+		for _, hook := range hooks {
+			if !hook.wants(eventType) {
+				continue
+			}
+			if err := deliverWebhook(ctx, hook, payload, body); err != nil {
+				s.logger.Printf("Error delivering %s to webhook %s: %v", eventType, hook.WebhookID, err)
+				s.recordNotificationFailure(ctx, book.UserID, "webhook", fmt.Errorf("webhook %s: %w", hook.WebhookID, err))
+			}
+		}
+	}()
+}
+
+// deliverWebhook は body を署名して hook.URL に POST する。5xx と通信エラーは間隔を空けて再試行する (内部のアドレスへの接続は再試行しない)
+func deliverWebhook(ctx context.Context, hook Webhook, payload WebhookPayload, body []byte) error {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	signature := signWebhook(hook.Secret, timestamp, body)
+
+	var lastErr error
+	for attempt := 1; attempt <= webhookAttempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(time.Duration(attempt-1) * 2 * time.Second)
+		}
+
+		lastErr = postWebhook(ctx, hook.URL, payload, timestamp, signature, body)
+		if lastErr == nil {
+			return nil
+		}
+		if _, permanent := lastErr.(webhookStatusError); permanent || errors.Is(lastErr, errWebhookAddr) {
+			return lastErr
+		}
+	}
+	return lastErr
+}
+
+// webhookStatusError は再試行しても無意味な 4xx の応答
+type webhookStatusError int
+
+func (e webhookStatusError) Error() string {
+	return fmt.Sprintf("webhook responded with status %d", int(e))
+}
+
+func postWebhook(ctx context.Context, url string, payload WebhookPayload, timestamp, signature string, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "tundoku-killer-webhook/1")
+	req.Header.Set("X-Tundoku-Event", payload.Type)
+	req.Header.Set("X-Tundoku-Delivery", payload.ID)
+	req.Header.Set("X-Tundoku-Timestamp", timestamp)
+	req.Header.Set("X-Tundoku-Signature", "sha256="+signature)
+
+	resp, err := webhookHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests:
+		return webhookStatusError(resp.StatusCode)
+	default:
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+}
+
+// signWebhook は "{timestamp}.{body}" の HMAC-SHA256 を16進で返す。
+// 受信側は X-Tundoku-Timestamp と本文から同じ値を計算して X-Tundoku-Signature と比較する
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
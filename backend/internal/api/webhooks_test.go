@@ -0,0 +1,75 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestCheckWebhookAddr(t *testing.T) {
+	tests := []struct {
+		addr string
+		ok   bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.0.0.1", false},
+		{"172.16.5.4", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false}, // クラウドのメタデータサーバー
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"100.64.0.1", false},
+		{"224.0.0.1", false},
+		{"::ffff:127.0.0.1", false}, // IPv4 射影アドレス
+	}
+	for _, tt := range tests {
+		err := checkWebhookAddr(netip.MustParseAddr(tt.addr))
+		if (err == nil) != tt.ok {
+			t.Errorf("checkWebhookAddr(%s) = %v; want ok=%v", tt.addr, err, tt.ok)
+		}
+	}
+}
+
+func TestCheckWebhookHost(t *testing.T) {
+	tests := []struct {
+		url string
+		ok  bool
+	}{
+		{"https://93.184.216.34/hook", true},
+		{"https://169.254.169.254/latest/meta-data/", false},
+		{"https://10.0.0.1/", false},
+		{"https://[::1]:8443/", false},
+		{"https://localhost/", false},
+	}
+	for _, tt := range tests {
+		err := checkWebhookHost(context.Background(), tt.url)
+		if (err == nil) != tt.ok {
+			t.Errorf("checkWebhookHost(%s) = %v; want ok=%v", tt.url, err, tt.ok)
+		}
+	}
+}
+
+func TestPostWebhookRefusesInternalAddress(t *testing.T) {
+	called := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer ts.Close()
+
+	// 登録の後で内部のアドレスを指すようになった URL にも接続しない
+	err := deliverWebhook(context.Background(), Webhook{URL: ts.URL, Secret: "secret"}, WebhookPayload{Type: webhookBookRegistered}, []byte("{}"))
+	if !errors.Is(err, errWebhookAddr) {
+		t.Errorf("deliverWebhook to %s = %v; want errWebhookAddr", ts.URL, err)
+	}
+	if called {
+		t.Error("the webhook was delivered to a loopback address")
+	}
+}
@@ -0,0 +1,284 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"tundoku-killer/backend/internal/card"
+	"tundoku-killer/backend/internal/cron"
+)
+
+// 1年分の読書の振り返り (読了ページ数・最速の読了・最も放置した本・受けた煽りの数)。
+// JSON で返すほか、毎年1月に前年分を画像付きでLINEに送る
+
+// yearInReviewLease は年間の振り返りの二重送信を防ぐロックの名前
+const yearInReviewLease = "yearInReview"
+
+// YearInReview はユーザーの1年分の振り返り
+type YearInReview struct {
+	UserID        string        `json:"userId" firestore:"userId"`
+	Year          int           `json:"year" firestore:"year"`
+	BooksFinished int           `json:"booksFinished" firestore:"booksFinished"`
+	TotalPages    int           `json:"totalPages" firestore:"totalPages"` // ページ数が登録された読了本の合計
+	FastestRead   *ReviewedBook `json:"fastestRead" firestore:"fastestRead"`
+	MostIgnored   *ReviewedBook `json:"mostIgnored" firestore:"mostIgnored"`
+	TotalInsults  int           `json:"totalInsults" firestore:"totalInsults"`
+	SentAt        time.Time     `json:"sentAt,omitempty" firestore:"sentAt,omitempty"`
+}
+
+// ReviewedBook は振り返りで取り上げる本
+type ReviewedBook struct {
+	BookID      string  `json:"bookId" firestore:"bookId"`
+	Title       string  `json:"title" firestore:"title"`
+	Days        float64 `json:"days,omitempty" firestore:"days,omitempty"`               // fastestRead: 登録から読了までの日数
+	InsultLevel int     `json:"insultLevel,omitempty" firestore:"insultLevel,omitempty"` // mostIgnored: 煽られた回数
+}
+
+// reviewYear は year (空なら now の前年) と、その JST での開始・終了を返す
+func reviewYear(year string, now time.Time) (int, time.Time, time.Time, error) {
+	y := now.In(cron.Location).Year() - 1
+	if year != "" {
+		n, err := strconv.Atoi(year)
+		if err != nil || n < 2000 || n > 9999 {
+			return 0, time.Time{}, time.Time{}, fmt.Errorf("year must be a 4-digit year")
+		}
+		y = n
+	}
+	start := time.Date(y, 1, 1, 0, 0, 0, 0, cron.Location)
+	return y, start, start.AddDate(1, 0, 0), nil
+}
+
+// handleYearInReview は ?userId= のユーザーの ?year= (省略時は前年) の振り返りを返す
+func (s *Server) handleYearInReview(w http.ResponseWriter, r *http.Request) {
+	review, ok := s.yearInReviewFromQuery(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(review)
+}
+
+// handleYearInReviewImage は振り返りを共有用の PNG 画像で返す。LINEの画像メッセージから参照される
+func (s *Server) handleYearInReviewImage(w http.ResponseWriter, r *http.Request) {
+	review, ok := s.yearInReviewFromQuery(w, r)
+	if !ok {
+		return
+	}
+
+	img, err := renderYearInReview(review)
+	if err != nil {
+		writeServerError(w, r, err, "Failed to render image")
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Write(img)
+}
+
+func (s *Server) yearInReviewFromQuery(w http.ResponseWriter, r *http.Request) (YearInReview, bool) {
+	if r.Method != http.MethodGet {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return YearInReview{}, false
+	}
+
+	userID := r.URL.Query().Get("userId")
+	if userID == "" {
+		writeProblem(w, r, http.StatusBadRequest, "userId query parameter is required")
+		return YearInReview{}, false
+	}
+	year, start, end, err := reviewYear(r.URL.Query().Get("year"), time.Now())
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, err.Error())
+		return YearInReview{}, false
+	}
+
+	review, err := s.computeYearInReview(r.Context(), userID, year, start, end)
+	if err != nil {
+		writeServerError(w, r, err, "Failed to compute year in review")
+		return YearInReview{}, false
+	}
+	return review, true
+}
+
+// computeYearInReview は userID の [start, end) の振り返りを集計する
+func (s *Server) computeYearInReview(ctx context.Context, userID string, year int, start, end time.Time) (YearInReview, error) {
+	review := YearInReview{UserID: userID, Year: year}
+
+	books, err := s.listBooks(ctx, userID)
+	if err != nil {
+		return review, err
+	}
+	for _, book := range books {
+		// 年末の時点で登録されていなかった本は対象外
+		if book.CreatedAt != nil && !book.CreatedAt.Before(end) {
+			continue
+		}
+
+		if book.InsultLevel > 0 && (review.MostIgnored == nil || book.InsultLevel > review.MostIgnored.InsultLevel) {
+			review.MostIgnored = &ReviewedBook{BookID: book.BookID, Title: book.Title, InsultLevel: book.InsultLevel}
+		}
+
+		if book.Status != "completed" || !inRange(book.CompletedAt, start, end) {
+			continue
+		}
+		review.BooksFinished++
+		review.TotalPages += book.Pages
+		if book.CreatedAt != nil {
+			days := daysBetween(*book.CreatedAt, *book.CompletedAt)
+			if review.FastestRead == nil || days < review.FastestRead.Days {
+				review.FastestRead = &ReviewedBook{BookID: book.BookID, Title: book.Title, Days: days}
+			}
+		}
+	}
+
+	// 受けた煽りの数は insults を数える集計クエリで求める
+	insults := s.firestoreClient.Collection("insults").
+		Where("userId", "==", userID).
+		Where("sentAt", ">=", start).
+		Where("sentAt", "<", end)
+	results, err := insults.NewAggregationQuery().WithCount("count").Get(ctx)
+	if err != nil {
+		return review, fmt.Errorf("error counting insults: %w", err)
+	}
+	if count, ok := results["count"]; ok {
+		review.TotalInsults = int(aggregationInt(count))
+	}
+	return review, nil
+}
+
+// aggregationInt は集計クエリの結果 (*firestorepb.Value か int64) を整数にする
+func aggregationInt(v interface{}) int64 {
+	switch n := v.(type) {
+	case int64:
+		return n
+	case interface{ GetIntegerValue() int64 }:
+		return n.GetIntegerValue()
+	}
+	return 0
+}
+
+// renderYearInReview は振り返りを共有用の画像にする (内蔵フォントの都合で英字表記)
+func renderYearInReview(review YearInReview) ([]byte, error) {
+	fastest := "-"
+	if review.FastestRead != nil {
+		fastest = fmt.Sprintf("%.1f DAYS", review.FastestRead.Days)
+	}
+	ignored := "-"
+	if review.MostIgnored != nil {
+		ignored = fmt.Sprintf("%d TIMES", review.MostIgnored.InsultLevel)
+	}
+
+	return card.Render(fmt.Sprintf("%d TSUNDOKU WRAPPED", review.Year), []card.Row{
+		{Label: "BOOKS FINISHED / PAGES", Value: fmt.Sprintf("%d / %d", review.BooksFinished, review.TotalPages)},
+		{Label: "FASTEST READ", Value: fastest},
+		{Label: "MOST IGNORED BOOK, INSULTED", Value: ignored},
+		{Label: "INSULTS RECEIVED", Value: strconv.Itoa(review.TotalInsults)},
+	})
+}
+
+// yearInReviewText は画像に添えるテキスト。本のタイトルは画像に描けないのでこちらに書く
+func yearInReviewText(review YearInReview) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d年の積読の振り返り\n", review.Year)
+	fmt.Fprintf(&b, "読了: %d冊 (%dページ)\n", review.BooksFinished, review.TotalPages)
+	if review.FastestRead != nil {
+		fmt.Fprintf(&b, "最速の読了: 『%s』%.1f日\n", review.FastestRead.Title, review.FastestRead.Days)
+	}
+	if review.MostIgnored != nil {
+		fmt.Fprintf(&b, "最も放置した本: 『%s』(%d回煽られました)\n", review.MostIgnored.Title, review.MostIgnored.InsultLevel)
+	}
+	fmt.Fprintf(&b, "受けた煽り: %d回", review.TotalInsults)
+	return b.String()
+}
+
+// handleYearInReviewCron は前年 (または ?year=) の振り返りを全ユーザーにLINEで送る。毎年1月に呼ぶ。
+// 画像は PUBLIC_BASE_URL (例: https://tundoku-killer.onrender.com) から配信するので、未設定ならテキストだけ送る
+func (s *Server) handleYearInReviewCron(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodGet {
+		writeProblem(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	ctx := context.WithoutCancel(r.Context())
+
+	if !s.authorizeCron(r) {
+		writeProblem(w, r, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	year, start, end, err := reviewYear(r.URL.Query().Get("year"), time.Now())
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	runID := uuid.NewString()
+	if err := s.cron.AcquireLease(ctx, yearInReviewLease, runID, cron.LeaseTTL); err != nil {
+		if errors.Is(err, cron.ErrLeaseHeld) {
+			writeProblem(w, r, http.StatusConflict, "Another year in review is already running")
+			return
+		}
+		writeServerError(w, r, err, "Failed to acquire lock")
+		return
+	}
+	defer s.cron.ReleaseLease(ctx, yearInReviewLease, runID)
+
+	userIDs, err := s.listUserIDs(ctx)
+	if err != nil {
+		writeServerError(w, r, err, "Failed to list users")
+		return
+	}
+
+	baseURL := s.cfg.PublicBaseURL
+	result := s.deliverReports(ctx, "yearInReviews", strconv.Itoa(year), userIDs, func(ctx context.Context, userID string) (interface{}, error) {
+		review, err := s.computeYearInReview(ctx, userID, year, start, end)
+		if err != nil {
+			return nil, err
+		}
+
+		messages := []interface{}{}
+		if baseURL != "" {
+			imageURL := fmt.Sprintf("%s%s/year-in-review/image?userId=%s&year=%d", baseURL, apiVersionPrefix, url.QueryEscape(userID), year)
+			messages = append(messages, map[string]interface{}{
+				"type":               "image",
+				"originalContentUrl": imageURL,
+				"previewImageUrl":    imageURL,
+			})
+		}
+		messages = append(messages, map[string]interface{}{
+			"type": "text",
+			"text": yearInReviewText(review),
+		})
+		if err := s.pushLineMessages(ctx, userID, messages...); err != nil {
+			return nil, err
+		}
+
+		review.SentAt = time.Now()
+		return review, nil
+	})
+
+	s.logger.Printf("Year in review %d: %d sent, %d skipped, %d failed (done: %v)", year, result.Sent, result.Skipped, result.Failed, result.Done)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"year":    year,
+		"users":   len(userIDs),
+		"sent":    result.Sent,
+		"skipped": result.Skipped,
+		"failed":  result.Failed,
+		"done":    result.Done,
+	})
+}
+
+// listUserIDs は本を登録したことのあるユーザーを返す。途中で時間切れになっても続きから送れるようID順に並んでいる
+func (s *Server) listUserIDs(ctx context.Context) ([]string, error) {
+	return s.bookRepo.ListUserIDs(ctx)
+}
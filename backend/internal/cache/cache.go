@@ -0,0 +1,60 @@
+// Package cache は Firestore の読み取りを減らすためのキャッシュ。
+// CACHE_BACKEND=redis なら Redis に置き、未設定なら何もキャッシュしない (Nop)。
+// 書き換えのときは呼び出し側がキーを Delete して、次の読み取りで新しい値を入れ直す (write-through の無効化)
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"tundoku-killer/backend/internal/config"
+)
+
+// Cache はバイト列のキャッシュ
+type Cache interface {
+	// Get は key の値を返す。無ければ ok が false
+	Get(ctx context.Context, key string) (value []byte, ok bool, err error)
+	// Set は key に value を ttl の間置く
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Delete は keys を消す。無いキーは無視する
+	Delete(ctx context.Context, keys ...string) error
+}
+
+// New は CACHE_BACKEND に応じた Cache を返す
+func New(ctx context.Context, c config.CacheConfig) (Cache, error) {
+	switch c.Backend {
+	case "redis":
+		return NewRedis(ctx, c.RedisURL)
+	case "", "none":
+		return Nop{}, nil
+	default:
+		return nil, fmt.Errorf("unknown CACHE_BACKEND %q", c.Backend)
+	}
+}
+
+// Nop は何もキャッシュしない Cache
+type Nop struct{}
+
+func (Nop) Get(context.Context, string) ([]byte, bool, error)        { return nil, false, nil }
+func (Nop) Set(context.Context, string, []byte, time.Duration) error { return nil }
+func (Nop) Delete(context.Context, ...string) error                  { return nil }
+
+// GetJSON は key の値を v に読み込む。無いか読めなければ false
+func GetJSON(ctx context.Context, c Cache, key string, v interface{}) bool {
+	data, ok, err := c.Get(ctx, key)
+	if err != nil || !ok {
+		return false
+	}
+	return json.Unmarshal(data, v) == nil
+}
+
+// SetJSON は v を JSON にして key に置く
+func SetJSON(ctx context.Context, c Cache, key string, v interface{}, ttl time.Duration) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.Set(ctx, key, data, ttl)
+}
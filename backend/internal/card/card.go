@@ -0,0 +1,139 @@
+// Package card はLINEで共有するための、数字の並んだ画像カードを PNG で描く。
+// 外部フォントに依存しないよう、英大文字・数字・一部の記号だけを内蔵のビットマップフォントで描く
+package card
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"strings"
+)
+
+const (
+	// Width と Height はカードの大きさ (LINEの画像メッセージに収まる 1:1)
+	Width  = 1040
+	Height = 1040
+
+	margin = 80
+)
+
+var (
+	background = color.RGBA{0x1E, 0x1B, 0x2E, 0xFF}
+	accent     = color.RGBA{0xFF, 0x52, 0x52, 0xFF}
+	foreground = color.RGBA{0xF5, 0xF5, 0xF5, 0xFF}
+	muted      = color.RGBA{0x9E, 0x9E, 0xB8, 0xFF}
+)
+
+// Row はカードの1行。Label の下に Value を大きく描く
+type Row struct {
+	Label string
+	Value string
+}
+
+// Render は title と rows を描いたカードを PNG で返す。描けない文字は空白になる
+func Render(title string, rows []Row) ([]byte, error) {
+	img := image.NewRGBA(image.Rect(0, 0, Width, Height))
+	draw.Draw(img, img.Bounds(), &image.Uniform{background}, image.Point{}, draw.Src)
+
+	// 上端のアクセントの帯
+	draw.Draw(img, image.Rect(0, 0, Width, 24), &image.Uniform{accent}, image.Point{}, draw.Src)
+
+	y := margin
+	drawText(img, title, margin, y, 10, accent)
+	y += glyphHeight*10 + 60
+
+	rowHeight := (Height - y - margin) / max(len(rows), 1)
+	for _, row := range rows {
+		drawText(img, row.Label, margin, y, 5, muted)
+		drawText(img, row.Value, margin, y+glyphHeight*5+16, 12, foreground)
+		y += rowHeight
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// drawText は text を scale 倍のビットマップフォントで (x, y) から描く
+func drawText(img draw.Image, text string, x, y, scale int, c color.Color) {
+	src := &image.Uniform{c}
+	for _, r := range strings.ToUpper(text) {
+		glyph, ok := glyphs[r]
+		if !ok {
+			glyph = glyphs[' ']
+		}
+		for gy, line := range glyph {
+			for gx, bit := range line {
+				if bit != '#' {
+					continue
+				}
+				px, py := x+gx*scale, y+gy*scale
+				draw.Draw(img, image.Rect(px, py, px+scale, py+scale), src, image.Point{}, draw.Src)
+			}
+		}
+		x += (glyphWidth + 1) * scale
+		if x > Width-margin {
+			return
+		}
+	}
+}
+
+const (
+	glyphWidth  = 3
+	glyphHeight = 5
+)
+
+// glyphs は 3x5 のビットマップフォント
+var glyphs = map[rune][glyphHeight]string{
+	' ': {"...", "...", "...", "...", "..."},
+	'0': {"###", "#.#", "#.#", "#.#", "###"},
+	'1': {".#.", "##.", ".#.", ".#.", "###"},
+	'2': {"###", "..#", "###", "#..", "###"},
+	'3': {"###", "..#", "###", "..#", "###"},
+	'4': {"#.#", "#.#", "###", "..#", "..#"},
+	'5': {"###", "#..", "###", "..#", "###"},
+	'6': {"###", "#..", "###", "#.#", "###"},
+	'7': {"###", "..#", "..#", "..#", "..#"},
+	'8': {"###", "#.#", "###", "#.#", "###"},
+	'9': {"###", "#.#", "###", "..#", "###"},
+	'A': {".#.", "#.#", "###", "#.#", "#.#"},
+	'B': {"##.", "#.#", "##.", "#.#", "##."},
+	'C': {".##", "#..", "#..", "#..", ".##"},
+	'D': {"##.", "#.#", "#.#", "#.#", "##."},
+	'E': {"###", "#..", "##.", "#..", "###"},
+	'F': {"###", "#..", "##.", "#..", "#.."},
+	'G': {".##", "#..", "#.#", "#.#", ".##"},
+	'H': {"#.#", "#.#", "###", "#.#", "#.#"},
+	'I': {"###", ".#.", ".#.", ".#.", "###"},
+	'J': {"..#", "..#", "..#", "#.#", ".#."},
+	'K': {"#.#", "#.#", "##.", "#.#", "#.#"},
+	'L': {"#..", "#..", "#..", "#..", "###"},
+	'M': {"#.#", "###", "###", "#.#", "#.#"},
+	'N': {"##.", "#.#", "#.#", "#.#", "#.#"},
+	'O': {".#.", "#.#", "#.#", "#.#", ".#."},
+	'P': {"##.", "#.#", "##.", "#..", "#.."},
+	'Q': {".#.", "#.#", "#.#", "##.", ".##"},
+	'R': {"##.", "#.#", "##.", "#.#", "#.#"},
+	'S': {".##", "#..", ".#.", "..#", "##."},
+	'T': {"###", ".#.", ".#.", ".#.", ".#."},
+	'U': {"#.#", "#.#", "#.#", "#.#", "###"},
+	'V': {"#.#", "#.#", "#.#", "#.#", ".#."},
+	'W': {"#.#", "#.#", "###", "###", "#.#"},
+	'X': {"#.#", "#.#", ".#.", "#.#", "#.#"},
+	'Y': {"#.#", "#.#", ".#.", ".#.", ".#."},
+	'Z': {"###", "..#", ".#.", "#..", "###"},
+	'.': {"...", "...", "...", "...", ".#."},
+	',': {"...", "...", "...", ".#.", "#.."},
+	':': {"...", ".#.", "...", ".#.", "..."},
+	'-': {"...", "...", "###", "...", "..."},
+	'+': {"...", ".#.", "###", ".#.", "..."},
+	'/': {"..#", "..#", ".#.", "#..", "#.."},
+	'%': {"#.#", "..#", ".#.", "#..", "#.#"},
+	'!': {".#.", ".#.", ".#.", "...", ".#."},
+	'?': {"##.", "..#", ".#.", "...", ".#."},
+	'#': {"#.#", "###", "#.#", "###", "#.#"},
+}
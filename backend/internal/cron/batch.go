@@ -0,0 +1,37 @@
+package cron
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"tundoku-killer/backend/internal/store"
+)
+
+// StatusBatch は期限チェック中のステータス更新を溜めて、最後にまとめて書き込む
+type StatusBatch struct {
+	repo    store.BookRepository
+	logger  *log.Logger
+	mu      sync.Mutex
+	patches map[string]store.BookPatch // 本のID -> 更新
+}
+
+func NewStatusBatch(repo store.BookRepository, logger *log.Logger) *StatusBatch {
+	return &StatusBatch{repo: repo, logger: logger, patches: make(map[string]store.BookPatch)}
+}
+
+// Add は本への更新をキューに積む
+func (b *StatusBatch) Add(bookID string, patch store.BookPatch) {
+	b.mu.Lock()
+	b.patches[bookID] = patch
+	b.mu.Unlock()
+}
+
+// Flush は溜まった更新を書き込み、失敗した件数を返す。Flush 後の StatusBatch は再利用できない
+func (b *StatusBatch) Flush(ctx context.Context) int {
+	errs := b.repo.PatchAll(ctx, b.patches)
+	for bookID, err := range errs {
+		b.logger.Printf("Error updating status for book %s: %v", bookID, err)
+	}
+	return len(errs)
+}
@@ -0,0 +1,57 @@
+package cron
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"tundoku-killer/backend/internal/store"
+)
+
+// patchRecorder は PatchAll に渡された更新を記録し、failing の本は失敗させる BookRepository
+type patchRecorder struct {
+	store.BookRepository
+	failing map[string]bool
+	patched map[string]store.BookPatch
+}
+
+func (r *patchRecorder) PatchAll(_ context.Context, patches map[string]store.BookPatch) map[string]error {
+	r.patched = patches
+	errs := make(map[string]error)
+	for bookID := range patches {
+		if r.failing[bookID] {
+			errs[bookID] = errors.New("write failed")
+		}
+	}
+	return errs
+}
+
+func TestStatusBatch(t *testing.T) {
+	repo := &patchRecorder{failing: map[string]bool{"b2": true}}
+	batch := NewStatusBatch(repo, discard)
+
+	insulted := "insulted"
+	cycle := "2024-03-04"
+	var wg sync.WaitGroup
+	for _, id := range []string{"b1", "b2", "b3"} {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			batch.Add(id, store.BookPatch{Status: &insulted, LastInsultCycle: &cycle})
+		}(id)
+	}
+	wg.Wait()
+	// 同じ本に積み直すと後の更新で置き換わる
+	batch.Add("b1", store.BookPatch{Status: &insulted, InsultLevelIncr: 1})
+
+	if failed := batch.Flush(context.Background()); failed != 1 {
+		t.Errorf("Flush failed = %d, want 1", failed)
+	}
+	if len(repo.patched) != 3 {
+		t.Errorf("PatchAll got %d patches, want 3", len(repo.patched))
+	}
+	if repo.patched["b1"].InsultLevelIncr != 1 {
+		t.Errorf("b1 patch = %+v, want the later patch", repo.patched["b1"])
+	}
+}
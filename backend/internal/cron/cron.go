@@ -0,0 +1,25 @@
+// Package cron は GitHub Actions から定期的に呼ばれる処理 (期限チェック・月次レポートなど) の共通部品。
+// 多重実行を防ぐロック、時間切れで打ち切ったときの再開位置、実行履歴、ステータス更新のまとめ書き、
+// 上限付きのワーカープールを提供する。HTTP のハンドラーは internal/api にある
+package cron
+
+import (
+	"time"
+)
+
+const (
+	// PageSize は期限チェックで1回のクエリで読み込む本の数
+	PageSize = 200
+	// TimeBudget を超えたら処理を切り上げ、続きは次回の呼び出しで再開する
+	TimeBudget = 20 * time.Second
+	// BookTimeout は1冊分の処理 (煽り文の生成・送信・発行) にかけられる時間
+	BookTimeout = 30 * time.Second
+)
+
+// Location は煽りの周期 (日付) を区切るタイムゾーン
+var Location = time.FixedZone("Asia/Tokyo", 9*60*60)
+
+// Cycle は t が属する煽りの周期を返す。1日1周期
+func Cycle(t time.Time) string {
+	return t.In(Location).Format("2006-01-02")
+}
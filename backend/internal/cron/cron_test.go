@@ -0,0 +1,22 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCycle(t *testing.T) {
+	tests := []struct {
+		t    time.Time
+		want string
+	}{
+		{time.Date(2024, 3, 4, 14, 59, 59, 0, time.UTC), "2024-03-04"},
+		{time.Date(2024, 3, 4, 15, 0, 0, 0, time.UTC), "2024-03-05"}, // JST の0時で周期が変わる
+		{time.Date(2024, 12, 31, 23, 0, 0, 0, Location), "2024-12-31"},
+	}
+	for _, tt := range tests {
+		if got := Cycle(tt.t); got != tt.want {
+			t.Errorf("Cycle(%v) = %q, want %q", tt.t, got, tt.want)
+		}
+	}
+}
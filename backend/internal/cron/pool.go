@@ -0,0 +1,66 @@
+package cron
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/time/rate"
+
+	"tundoku-killer/backend/internal/store"
+)
+
+// Pool は期限切れの本を並列に処理する上限付きのワーカープール
+type Pool struct {
+	books   chan store.Book
+	wg      sync.WaitGroup
+	limiter *rate.Limiter
+
+	dispatched atomic.Int64
+	failed     atomic.Int64
+}
+
+// NewPool は concurrency 個のワーカーを起動し、積まれた本を perSecond 件/秒まで process に渡す
+func NewPool(ctx context.Context, concurrency, perSecond int, logger *log.Logger, process func(context.Context, store.Book) error) *Pool {
+	p := &Pool{
+		books:   make(chan store.Book),
+		limiter: rate.NewLimiter(rate.Limit(perSecond), perSecond),
+	}
+	for i := 0; i < concurrency; i++ {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for book := range p.books {
+				if err := p.limiter.Wait(ctx); err != nil {
+					logger.Printf("Rate limiter aborted for book %s: %v", book.BookID, err)
+					p.failed.Add(1)
+					continue
+				}
+
+				// 1冊ごとにタイムアウトを設け、遅いAPI呼び出しがワーカーを塞がないようにする
+				bookCtx, cancel := context.WithTimeout(ctx, BookTimeout)
+				if err := process(bookCtx, book); err != nil {
+					logger.Printf("Error dispatching overdue book %s: %v", book.BookID, err)
+					p.failed.Add(1)
+				} else {
+					p.dispatched.Add(1)
+				}
+				cancel()
+			}
+		}()
+	}
+	return p
+}
+
+// Submit は本を処理待ちのキューに積む。空いているワーカーが出るまでブロックする
+func (p *Pool) Submit(book store.Book) {
+	p.books <- book
+}
+
+// Wait はキューを閉じて全ワーカーの終了を待ち、処理に成功した件数と失敗した件数を返す
+func (p *Pool) Wait() (dispatched, failed int) {
+	close(p.books)
+	p.wg.Wait()
+	return int(p.dispatched.Load()), int(p.failed.Load())
+}
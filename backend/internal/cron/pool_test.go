@@ -0,0 +1,60 @@
+package cron
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"tundoku-killer/backend/internal/store"
+)
+
+func TestPool(t *testing.T) {
+	const concurrency = 3
+	var running, peak atomic.Int32
+	pool := NewPool(context.Background(), concurrency, 1000, discard, func(ctx context.Context, book store.Book) error {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		if _, ok := ctx.Deadline(); !ok {
+			t.Error("process was called without a per-book deadline")
+		}
+		if book.BookID == "bad" {
+			return errors.New("boom")
+		}
+		return nil
+	})
+
+	for _, id := range []string{"a", "b", "bad", "c", "d", "e", "bad"} {
+		pool.Submit(store.Book{BookID: id})
+	}
+	dispatched, failed := pool.Wait()
+	if dispatched != 5 || failed != 2 {
+		t.Errorf("Wait = %d dispatched, %d failed; want 5, 2", dispatched, failed)
+	}
+	if p := peak.Load(); p > concurrency {
+		t.Errorf("%d books were processed at once, want at most %d", p, concurrency)
+	}
+}
+
+func TestPoolCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	// レート制限の待ちが打ち切られた本は失敗に数え、process には渡さない
+	pool := NewPool(ctx, 1, 1, discard, func(context.Context, store.Book) error {
+		return nil
+	})
+	pool.Submit(store.Book{BookID: "a"})
+	pool.Submit(store.Book{BookID: "b"})
+	dispatched, failed := pool.Wait()
+	if dispatched+failed != 2 || failed == 0 {
+		t.Errorf("Wait = %d dispatched, %d failed; want the canceled books counted as failed", dispatched, failed)
+	}
+}
@@ -0,0 +1,150 @@
+package cron
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// LeaseTTL はcronのロックが解放されなかった場合に自動で失効するまでの時間
+const LeaseTTL = 10 * time.Minute
+
+// ErrLeaseHeld は他の実行がロックを保持しているときに返される
+var ErrLeaseHeld = errors.New("lease is held by another run")
+
+// Lease は locks コレクションに保存されるロックドキュメント
+type Lease struct {
+	RunID      string    `firestore:"runId"`
+	AcquiredAt time.Time `firestore:"acquiredAt"`
+	ExpiresAt  time.Time `firestore:"expiresAt"` // FirestoreのTTLポリシーの対象フィールド
+}
+
+// Run は期限チェック1回分の実行結果。cronRuns コレクションに保存する
+type Run struct {
+	RunID      string    `json:"runId" firestore:"runId"`
+	Cycle      string    `json:"cycle" firestore:"cycle"`
+	StartedAt  time.Time `json:"startedAt" firestore:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt" firestore:"finishedAt"`
+	Scanned    int       `json:"scanned" firestore:"scanned"`       // 読み込んだ本の数
+	Expired    int       `json:"expired" firestore:"expired"`       // 期限切れと判定した本の数
+	Dispatched int       `json:"dispatched" firestore:"dispatched"` // 送信 (Pub/Sub使用時は発行) に成功した数
+	Failed     int       `json:"failed" firestore:"failed"`
+	Done       bool      `json:"done" firestore:"done"` // false なら時間切れで次回に持ち越し
+	Error      string    `json:"error,omitempty" firestore:"error,omitempty"`
+}
+
+// cursor は途中で打ち切られた期限チェックの再開位置
+type cursor struct {
+	Cycle     string    `firestore:"cycle"`
+	Cursor    string    `firestore:"cursor"` // 最後に処理した本のドキュメントID
+	UpdatedAt time.Time `firestore:"updatedAt"`
+}
+
+// State は cron の実行状態 (ロック・再開位置・実行履歴) を Firestore に読み書きする
+type State struct {
+	Client *firestore.Client
+	Logger *log.Logger
+}
+
+// AcquireLease は name のロックを runID で取得する。
+// 既に有効なロックがあれば ErrLeaseHeld を返す。期限切れのロックは奪い取る
+func (s State) AcquireLease(ctx context.Context, name, runID string, ttl time.Duration) error {
+	ref := s.Client.Collection("locks").Doc(name)
+
+	return s.Client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if err == nil {
+			var current Lease
+			if err := doc.DataTo(&current); err != nil {
+				return err
+			}
+			if current.ExpiresAt.After(time.Now()) {
+				return ErrLeaseHeld
+			}
+			s.Logger.Printf("Taking over expired lease %s from run %s", name, current.RunID)
+		}
+
+		now := time.Now()
+		return tx.Set(ref, Lease{
+			RunID:      runID,
+			AcquiredAt: now,
+			ExpiresAt:  now.Add(ttl),
+		})
+	})
+}
+
+// ReleaseLease は runID が保持しているロックを解放する。既に他の実行に奪われていれば何もしない
+func (s State) ReleaseLease(ctx context.Context, name, runID string) {
+	ref := s.Client.Collection("locks").Doc(name)
+
+	err := s.Client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var current Lease
+		if err := doc.DataTo(&current); err != nil {
+			return err
+		}
+		if current.RunID != runID {
+			return nil
+		}
+		return tx.Delete(ref)
+	})
+	if err != nil {
+		s.Logger.Printf("Error releasing lease %s (run %s): %v", name, runID, err)
+	}
+}
+
+// LoadCursor は同じ周期で保存された期限チェックの再開位置を返す。なければ空文字
+func (s State) LoadCursor(ctx context.Context, cycle string) string {
+	doc, err := s.Client.Collection("cronState").Doc("checkDeadlines").Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return ""
+	}
+	if err != nil {
+		s.Logger.Printf("Error loading cron cursor: %v", err)
+		return ""
+	}
+
+	var state cursor
+	if err := doc.DataTo(&state); err != nil {
+		s.Logger.Printf("Error parsing cron cursor: %v", err)
+		return ""
+	}
+	// 周期が変わっていれば最初からやり直す (処理済みの本は lastInsultCycle でスキップされる)
+	if state.Cycle != cycle {
+		return ""
+	}
+	return state.Cursor
+}
+
+// SaveCursor は再開位置を保存する。position が空なら今回の周期は最後まで処理済み
+func (s State) SaveCursor(ctx context.Context, cycle, position string) {
+	_, err := s.Client.Collection("cronState").Doc("checkDeadlines").Set(ctx, cursor{
+		Cycle:     cycle,
+		Cursor:    position,
+		UpdatedAt: time.Now(),
+	})
+	if err != nil {
+		s.Logger.Printf("Error saving cron cursor: %v", err)
+	}
+}
+
+// SaveRun は実行結果を cronRuns/{runId} に保存する
+func (s State) SaveRun(ctx context.Context, run Run) {
+	if _, err := s.Client.Collection("cronRuns").Doc(run.RunID).Set(ctx, run); err != nil {
+		s.Logger.Printf("Error saving cron run %s: %v", run.RunID, err)
+	}
+}
@@ -0,0 +1,88 @@
+package cron
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/google/uuid"
+)
+
+var discard = log.New(io.Discard, "", 0)
+
+// emulatorState は Firestore エミュレーターにつないだ State を返す。FIRESTORE_EMULATOR_HOST が無ければテストを飛ばす
+func emulatorState(t *testing.T) State {
+	t.Helper()
+	if os.Getenv("FIRESTORE_EMULATOR_HOST") == "" {
+		t.Skip("FIRESTORE_EMULATOR_HOST is not set")
+	}
+	client, err := firestore.NewClient(context.Background(), "tundoku-killer-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return State{Client: client, Logger: discard}
+}
+
+func TestLease(t *testing.T) {
+	ctx := context.Background()
+	s := emulatorState(t)
+	name := "test-" + uuid.NewString()
+
+	if err := s.AcquireLease(ctx, name, "run1", time.Minute); err != nil {
+		t.Fatalf("first AcquireLease: %v", err)
+	}
+	if err := s.AcquireLease(ctx, name, "run2", time.Minute); !errors.Is(err, ErrLeaseHeld) {
+		t.Fatalf("second AcquireLease error = %v, want ErrLeaseHeld", err)
+	}
+	// ほかの実行のロックは解放しない
+	s.ReleaseLease(ctx, name, "run2")
+	if err := s.AcquireLease(ctx, name, "run3", time.Minute); !errors.Is(err, ErrLeaseHeld) {
+		t.Fatalf("AcquireLease after another run's release error = %v, want ErrLeaseHeld", err)
+	}
+	s.ReleaseLease(ctx, name, "run1")
+	if err := s.AcquireLease(ctx, name, "run4", time.Minute); err != nil {
+		t.Fatalf("AcquireLease after release: %v", err)
+	}
+	s.ReleaseLease(ctx, name, "run4")
+}
+
+func TestLeaseExpires(t *testing.T) {
+	ctx := context.Background()
+	s := emulatorState(t)
+	name := "test-" + uuid.NewString()
+
+	if err := s.AcquireLease(ctx, name, "stuck", time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	// 解放されなかった期限切れのロックは奪い取れる
+	if err := s.AcquireLease(ctx, name, "next", time.Minute); err != nil {
+		t.Fatalf("AcquireLease over an expired lease: %v", err)
+	}
+	s.ReleaseLease(ctx, name, "next")
+}
+
+func TestCursor(t *testing.T) {
+	ctx := context.Background()
+	s := emulatorState(t)
+
+	s.SaveCursor(ctx, "2024-03-04", "book-42")
+	if got := s.LoadCursor(ctx, "2024-03-04"); got != "book-42" {
+		t.Errorf("LoadCursor in the same cycle = %q, want book-42", got)
+	}
+	// 周期が変わったら最初から
+	if got := s.LoadCursor(ctx, "2024-03-05"); got != "" {
+		t.Errorf("LoadCursor in the next cycle = %q, want empty", got)
+	}
+	// 最後まで処理したら空
+	s.SaveCursor(ctx, "2024-03-04", "")
+	if got := s.LoadCursor(ctx, "2024-03-04"); got != "" {
+		t.Errorf("LoadCursor after finishing = %q, want empty", got)
+	}
+}
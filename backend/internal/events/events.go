@@ -0,0 +1,67 @@
+// Package events はプロセス内のドメインイベントのバス。
+// 本の登録や煽りの送信などの処理はイベントを発行するだけにし、
+// SSE・Webhook・履歴の保存などの後続の処理は購読側に置く
+package events
+
+import (
+	"context"
+	"log"
+	"reflect"
+	"sync"
+)
+
+// Event はバスに流すドメインイベント
+type Event interface {
+	// EventName はログに出すイベント名 ("book.registered" など)
+	EventName() string
+}
+
+type subscriber struct {
+	name    string
+	handler func(context.Context, Event)
+}
+
+// Bus はイベントの型ごとに購読者を保持する。ゼロ値は使えないので New で作る
+type Bus struct {
+	mu   sync.RWMutex
+	subs map[reflect.Type][]subscriber
+}
+
+// New は購読者のいない Bus を返す
+func New() *Bus {
+	return &Bus{subs: make(map[reflect.Type][]subscriber)}
+}
+
+// Subscribe は E 型のイベントが発行されたときに handler を呼ぶよう登録する。
+// name はエラー時のログに使う購読者の名前
+func Subscribe[E Event](b *Bus, name string, handler func(context.Context, E)) {
+	t := reflect.TypeFor[E]()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subs[t] = append(b.subs[t], subscriber{
+		name: name,
+		handler: func(ctx context.Context, e Event) {
+			handler(ctx, e.(E))
+		},
+	})
+}
+
+// Publish は e の型を購読しているハンドラーを登録順に同期で呼ぶ。
+// 購読者のパニックは他の購読者や発行元に波及させず、ログに残す
+func (b *Bus) Publish(ctx context.Context, e Event) {
+	b.mu.RLock()
+	subs := b.subs[reflect.TypeOf(e)]
+	b.mu.RUnlock()
+
+	for _, s := range subs {
+		func() {
+			defer func() {
+				if p := recover(); p != nil {
+					log.Printf("Event subscriber %s panicked on %s: %v", s.name, e.EventName(), p)
+				}
+			}()
+			s.handler(ctx, e)
+		}()
+	}
+}
@@ -0,0 +1,93 @@
+package insult
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"log"
+	"strings"
+
+	"tundoku-killer/backend/internal/config"
+	"tundoku-killer/backend/internal/store"
+)
+
+// Experiment はユーザーを煽り文の種類 (variant) に割り当てる A/B テスト。
+// 種類の名前は "生成方法-口調" (canned-mild・canned-savage・ai-mild・ai-savage)。gemini-* は ai-* の以前の名前で、
+// 集計が途切れないよう設定された名前のまま記録する。
+// 割り当ては userID のハッシュで決まり、保存しなくても同じユーザーはいつも同じ種類の煽り文を受け取る
+type Experiment struct {
+	variants   []string
+	generators map[string]Generator
+	weights    WeightSource
+	logger     *log.Logger
+}
+
+// NewExperiment は INSULT_VARIANTS の種類を比べる Experiment を返す。INSULT_VARIANTS が空なら無効。
+// ai は ai-* の煽り文を書かせる生成AIの設定 (Tone は種類ごとに替える)、
+// weights は用意された煽り文の選ばれやすさ (nil なら均等)
+func NewExperiment(c config.InsultConfig, ai LLM, weights WeightSource, logger *log.Logger) *Experiment {
+	e := &Experiment{variants: c.Variants, generators: make(map[string]Generator, len(c.Variants)), weights: weights, logger: logger}
+	for _, v := range c.Variants {
+		source, tone, _ := strings.Cut(v, "-")
+		switch source {
+		case "ai", "gemini":
+			llm := ai
+			llm.Tone = tone
+			e.generators[v] = llm
+		default:
+			e.generators[v] = Canned{Tone: tone, Weights: weights}
+		}
+	}
+	return e
+}
+
+// Enabled は A/B テストをしているかを返す
+func (e *Experiment) Enabled() bool {
+	return e != nil && len(e.variants) > 0
+}
+
+// Assign は userID の割り当て先を返す。variants の並びを変えると割り当てもやり直しになる
+func (e *Experiment) Assign(userID string) string {
+	h := fnv.New32a()
+	h.Write([]byte(userID))
+	return e.variants[h.Sum32()%uint32(len(e.variants))]
+}
+
+// UsesLLM は userID に割り当てた種類が生成AIに書かせるものかを返す
+func (e *Experiment) UsesLLM(userID string) bool {
+	source, _, _ := strings.Cut(e.Assign(userID), "-")
+	return source != "canned"
+}
+
+// Generate は book の所持者に割り当てた種類で煽り文を生成する。Insult.Variant は実際に使った種類。
+// 生成AIがどれも失敗したときや今月の予算を使い切ったときは、同じ口調の用意された煽り文に切り替える (その場合の種類は "canned-口調")
+func (e *Experiment) Generate(ctx context.Context, book store.Book) (Insult, error) {
+	variant := e.Assign(book.UserID)
+	generated, err := e.generators[variant].Generate(ctx, book)
+	if err == nil {
+		generated.Variant = variant
+		return generated, nil
+	}
+	source, tone, _ := strings.Cut(variant, "-")
+	if source == "canned" {
+		return Insult{}, err
+	}
+	if !errors.Is(err, ErrBudgetExceeded) {
+		e.logger.Printf("Error generating %s insult for book %s; falling back to canned: %v", variant, book.BookID, err)
+	}
+	generated, err = Canned{Tone: tone, Weights: e.weights}.Generate(ctx, book)
+	generated.Variant = "canned-" + tone
+	return generated, err
+}
+
+// Preview は Generate と同じ種類の煽り文を、生成AIを呼ばずに返す (期限チェックの dry run 用)。
+// 生成AIに書かせる種類は予算を使わないよう、同じ口調の用意された煽り文で代える (その場合の種類は "canned-口調")
+func (e *Experiment) Preview(ctx context.Context, book store.Book) (Insult, error) {
+	source, tone, _ := strings.Cut(e.Assign(book.UserID), "-")
+	if source == "canned" {
+		return e.Generate(ctx, book)
+	}
+	generated, err := Canned{Tone: tone, Weights: e.weights}.Generate(ctx, book)
+	generated.Variant = "canned-" + tone
+	return generated, err
+}
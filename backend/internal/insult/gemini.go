@@ -0,0 +1,106 @@
+package insult
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// geminiSafetySettings は Gemini の安全性フィルターの強さ。煽り文なので嫌がらせ (HARASSMENT) だけは
+// 中程度まで許し、差別・危険な行為 (自傷を含む)・性的な内容は低いものから止める
+var geminiSafetySettings = []map[string]string{
+	{"category": "HARM_CATEGORY_HARASSMENT", "threshold": "BLOCK_MEDIUM_AND_ABOVE"},
+	{"category": "HARM_CATEGORY_HATE_SPEECH", "threshold": "BLOCK_LOW_AND_ABOVE"},
+	{"category": "HARM_CATEGORY_DANGEROUS_CONTENT", "threshold": "BLOCK_LOW_AND_ABOVE"},
+	{"category": "HARM_CATEGORY_SEXUALLY_EXPLICIT", "threshold": "BLOCK_LOW_AND_ABOVE"},
+}
+
+// GeminiProvider は Gemini API (generateContent) で文章を書かせる Provider
+type GeminiProvider struct {
+	APIKey func() string // GEMINI_API_KEY。回転したキーを呼ぶたびに読み直せる
+	Model  string
+	Client *http.Client
+}
+
+func (GeminiProvider) Name() string { return "gemini" }
+
+func (g GeminiProvider) Complete(ctx context.Context, prompt string) (Completion, error) {
+	endpoint := fmt.Sprintf("https://generativelanguage.googleapis.com/v1beta/models/%s:generateContent", url.PathEscape(g.Model))
+
+	requestBody, _ := json.Marshal(map[string]interface{}{
+		"contents": []map[string]interface{}{{
+			"role":  "user",
+			"parts": []map[string]string{{"text": prompt}},
+		}},
+		"generationConfig": map[string]interface{}{
+			"temperature":     1.0,
+			"maxOutputTokens": maxOutputTokens,
+		},
+		"safetySettings": geminiSafetySettings,
+	})
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(requestBody))
+	if err != nil {
+		return Completion{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-goog-api-key", g.APIKey())
+
+	resp, err := g.Client.Do(req)
+	if err != nil {
+		return Completion{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Completion{}, newStatusError(resp)
+	}
+
+	var result struct {
+		Candidates []struct {
+			Content struct {
+				Parts []struct {
+					Text string `json:"text"`
+				} `json:"parts"`
+			} `json:"content"`
+			FinishReason string `json:"finishReason"`
+		} `json:"candidates"`
+		PromptFeedback struct {
+			BlockReason string `json:"blockReason"`
+		} `json:"promptFeedback"`
+		UsageMetadata struct {
+			PromptTokenCount     int `json:"promptTokenCount"`
+			CandidatesTokenCount int `json:"candidatesTokenCount"`
+		} `json:"usageMetadata"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return Completion{}, fmt.Errorf("error decoding Gemini response: %w", err)
+	}
+	if reason := result.PromptFeedback.BlockReason; reason != "" {
+		return Completion{}, fmt.Errorf("%w: prompt blocked (%s)", ErrUnsafe, reason)
+	}
+	var text strings.Builder
+	for _, c := range result.Candidates {
+		if c.FinishReason == "SAFETY" || c.FinishReason == "PROHIBITED_CONTENT" {
+			return Completion{}, fmt.Errorf("%w: finish reason %s", ErrUnsafe, c.FinishReason)
+		}
+		for _, p := range c.Content.Parts {
+			text.WriteString(p.Text)
+		}
+		break // 候補は1つしか頼んでいない
+	}
+	if text.Len() == 0 {
+		return Completion{}, errors.New("Gemini returned no text")
+	}
+	return Completion{
+		Text:         text.String(),
+		Model:        g.Model,
+		InputTokens:  result.UsageMetadata.PromptTokenCount,
+		OutputTokens: result.UsageMetadata.CandidatesTokenCount,
+	}, nil
+}
@@ -0,0 +1,268 @@
+// Package insult は期限切れの本への煽り文の生成。
+// INSULT_GENERATOR=console なら乱数を使わず、本の情報だけから決まった煽り文を返す。
+// INSULT_VARIANTS を設定すると、ユーザーごとに生成方法 (用意された文・生成AI) と口調を割り当てて比べる (Experiment)。
+// 生成AI は Gemini・OpenAI 互換の API を LLM_PROVIDERS の順に使い、落ちているものは飛ばす (Failover)
+package insult
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+
+	"tundoku-killer/backend/internal/store"
+)
+
+// Generator は期限切れの本への煽り文を生成する
+type Generator interface {
+	Generate(ctx context.Context, book store.Book) (Insult, error)
+}
+
+// Insult は生成した煽り文
+type Insult struct {
+	Text string
+	// Template は用意された煽り文のID ("savage-12" など)。フィードバックをテンプレートごとに集計するのに使う。
+	// 生成AI などテンプレートを使わないときは ""
+	Template string
+	// Variant は A/B テストの種類 ("canned-savage" など)。テストしていなければ ""
+	Variant string
+	// Provider は書いた生成AIのプロバイダー ("gemini"・"openai")。用意された煽り文なら ""
+	Provider string
+}
+
+// New は INSULT_GENERATOR に応じた Generator を返す。weights は用意された煽り文の選ばれやすさ (nil なら均等)
+func New(name string, weights WeightSource) Generator {
+	if name == "console" {
+		return Console{}
+	}
+	return Canned{Weights: weights}
+}
+
+// 煽り文の口調 (A/B テストで比べる)
+const (
+	Mild   = "mild"   // 皮肉まじりの軽い催促
+	Savage = "savage" // 容赦のない罵倒 (従来の煽り文)
+)
+
+// Canned はあらかじめ用意された煽り文から、重み付きのランダムで1つを返す Generator
+type Canned struct {
+	Tone    string       // Mild か Savage。空なら Savage
+	Weights WeightSource // nil なら均等に選ぶ
+}
+
+func (c Canned) Generate(ctx context.Context, book store.Book) (Insult, error) {
+	tone, messages := Savage, savageMessages(book)
+	if c.Tone == Mild {
+		tone, messages = Mild, mildMessages(book)
+	}
+	if book.Audiobook() {
+		messages = forAudiobook(messages)
+	}
+
+	// 重みが読めなくても均等に選んで送る (煽りを止めるほどのことではない)
+	var weights Weights
+	if c.Weights != nil {
+		weights, _ = c.Weights.Weights(ctx, book.UserID)
+	}
+	// 受け取る人が見たくない語句を含む煽り文は選ばない
+	candidates := make([]int, 0, len(messages))
+	var rejected error
+	m := recipientModerator(ctx)
+	for i, message := range messages {
+		if m != nil {
+			if err := m.Check(ctx, message); err != nil {
+				rejected = err
+				continue
+			}
+		}
+		candidates = append(candidates, i)
+	}
+	if len(candidates) == 0 {
+		return Insult{}, fmt.Errorf("every %s insult was rejected: %w", tone, rejected)
+	}
+	i := candidates[pick(len(candidates), func(j int) float64 { return weights.Of(templateID(tone, candidates[j])) })]
+	return Insult{Text: messages[i], Template: templateID(tone, i)}, nil
+}
+
+// audiobookWording はオーディオブック向けに、紙の本を前提にした言い回しを聞く本の言い回しに置き換える
+var audiobookWording = strings.NewReplacer(
+	"10ページだけ", "10分だけ",
+	"1日1ページ", "1日5分",
+	"本を1章だけ開いて", "1章だけ聞いて",
+	"目次だけでも眺めてみる", "最初の5分だけでも聞いてみる",
+	"ページを開く筋肉", "再生ボタンを押す筋肉",
+	"リハビリに1ページ", "リハビリに1分",
+	"ページをめくる心地よさ", "物語に耳を傾ける心地よさ",
+	"ページを開く。", "再生ボタンを押す。",
+	"1ページめくる", "1分聞く",
+	"指をページに置け", "指で再生ボタンを押せ",
+	"未読のページ", "再生されていない時間",
+	"文字を追うのが", "耳を傾けるのが",
+	"紙の無駄。インクの無駄。", "データの無駄。容量の無駄。",
+	"本の背表紙が寂しそう", "ライブラリのアイコンが寂しそう",
+	"の背表紙の色褪せ", "の再生位置の止まり具合",
+	"最後に触ったのいつですか？ 埃が厚化粧のように積もっていますよ。", "最後に再生したのいつですか？ 再生位置が化石のように止まったままですよ。",
+	"枕として使ってるんですか？", "寝る前に流して子守唄にしてるだけですか？",
+	"目次くらい読めるでしょうに", "最初の1章くらい聞けるでしょうに",
+	"紙の重さだと", "再生時間の長さだと",
+)
+
+// forAudiobook は messages をオーディオブック向けの言い回しにする。並びは変えない (templateID が変わらないように)
+func forAudiobook(messages []string) []string {
+	adapted := make([]string, len(messages))
+	for i, message := range messages {
+		adapted[i] = audiobookWording.Replace(message)
+	}
+	return adapted
+}
+
+// templateID は tone の i 番目の煽り文のID。フィードバックと結びつくので、煽り文は末尾に足すだけにして並べ替えない
+func templateID(tone string, i int) string {
+	return fmt.Sprintf("%s-%d", tone, i)
+}
+
+// pick は 0〜n-1 から weight に比例した確率で1つを選ぶ
+func pick(n int, weight func(i int) float64) int {
+	total := 0.0
+	for i := 0; i < n; i++ {
+		total += weight(i)
+	}
+	if total <= 0 {
+		return rand.Intn(n)
+	}
+	r := rand.Float64() * total
+	for i := 0; i < n; i++ {
+		r -= weight(i)
+		if r < 0 {
+			return i
+		}
+	}
+	return n - 1
+}
+
+// mildMessages は Mild の煽り文
+func mildMessages(book store.Book) []string {
+	return []string{
+
+		"その本、そろそろ読んであげてもいい頃かもしれませんね。",
+		fmt.Sprintf("「%s」の期限が過ぎました。まずは10ページだけでもどうですか？", book.Title),
+		"積読も味わいのうちですが、読まれてこその本ですよ。",
+		"今日の寝る前の15分、その本にあげてみませんか？",
+		fmt.Sprintf("「%s」があなたを待っています。続きが気になりませんか？", book.Title),
+		"期限は過ぎましたが、読み始めるのに遅すぎることはありません。",
+		"通勤中にスマホを開く前に、本を1章だけ開いてみては？",
+		"その本を選んだときのワクワク、思い出してみてください。",
+		"一気に読まなくて大丈夫。1日1ページから再開しましょう。",
+		"読み終えた自分を想像してみてください。ちょっと気持ちいいですよね？",
+		fmt.Sprintf("「%s」、目次だけでも眺めてみるところから始めませんか？", book.Title),
+		"本棚の奥で少し寂しそうにしていますよ。手に取ってあげてください。",
+	}
+}
+
+// savageMessages は Savage の煽り文
+func savageMessages(book store.Book) []string {
+	return []string{
+
+		"その本、まだ読んでないんですか？時間の無駄ですね。",
+		"積読ですか。残念ですね。その本は二度と読まれないでしょう。",
+		"買った時の記憶も薄れていくでしょうね。それがあなたの本の末路です。",
+		"知識は鮮度が命。その本はもう腐っています。",
+		"あなたの読書計画、破綻していますね。",
+		fmt.Sprintf("「%s」を読むというタスクは、あなたの優先順位リストに存在しないようですね。", book.Title),
+		"無駄な購入でしたね。次からは計画的にどうぞ。",
+		"その本は、あなたの怠惰を象徴しています。",
+		"期待外れです。次に期待しましょう。",
+		"結局、読まない本でしたか。",
+		"本棚の肥やしにするために働いてるの？ 貴族か何かですか？",
+		"「いつか読む」という言葉、あなたの辞書では「一生読まない」と同じ意味ですよね。",
+		"その本の著者が知ったら、絶望して筆を折るレベルの放置っぷりですね。",
+		"ページを開く筋肉すら衰えたんですか？ リハビリに1ページどうです？",
+		"知識の貯金をしてるつもり？ 複利じゃなくて腐敗が進んでますよ。",
+		"本を買うことで満足するタイプですか。安上がりな達成感ですね。",
+		"その本、メルカリに出したほうが必要な人の元へ届くし、本も幸せですよ。",
+		"次に新しい本を買う前に、その可哀想な既刊を供養してあげたらどうです？",
+		fmt.Sprintf("「%s」が放つ『読んでくれオーラ』。鈍感なあなたには届かないようですね。", book.Title),
+		"積読は病だと言いますが、あなたはもう手遅れのステージに入っています。",
+		"読まない本に囲まれて眠る気分はどうですか？ 知識の亡霊にうなされそうですが。",
+		"本の背表紙が寂しそうですよ。たまには視線を合わせてあげたら？",
+		"読了できない言い訳を考える時間があるなら、目次くらい読めるでしょうに。",
+		"あなたの本棚、もはや墓場ですね。未完の志が眠る場所。",
+		"積むのは本じゃなくて、あなたの読書能力にすべきでしたね。",
+		"本を買うエネルギーを、読むエネルギーに1%%でも回せませんか？",
+		"素晴らしい！ 本の劣化具合を観察する研究でもしてるんですか？",
+		"その一冊を無視し続ける胆力、別のことに活かせば成功したでしょうね。",
+		fmt.Sprintf("「%s」は、あなたが賢くなるのをずっと、ずっと、無駄に待っていますよ。", book.Title),
+		"本を買うお金があるなら、その怠惰を治す薬でも買えばよかったのに。",
+		"読みもしない本に場所代を払うなんて、あなたは本棚の大家さんですか？",
+		"そろそろ、その本にカビが生えるか、あなたの脳にカビが生えるかの勝負ですね。",
+		"文字を追うのがそれほど苦痛なら、いっそ絵本からやり直しますか？",
+		"その本、もうあなたの記憶からは消去されてるんでしょうね。物理的にあるだけで。",
+		"読書家を自称してるなら、死ぬ気でその一冊を終わらせるべきじゃないですか？",
+		"あなたの「忙しい」は、本にとって「お前はどうでもいい」という死刑宣告ですよ。",
+		"本棚が重みに耐えかねています。あなたの怠慢の重みに、ですよ。",
+		"未読のまま古びていく本。まるであなたの知性の成長が止まったかのようですね。",
+		"ページをめくる心地よさ。あ、忘れてしまったんでしたっけ？",
+		"その本の内容、SNSで誰かが要約してくれるのを待ってるんですか？ 浅ましいですね。",
+		"紙の無駄。インクの無駄。そして、あなたの時間の無駄。",
+		"もしかして、枕として使ってるんですか？ 知識が染み込むといいですね（笑）",
+		"その本、あなたの何倍も賢い内容が詰まってるのに、宝の持ち腐れですね。",
+		"読まない権利を行使中ですか？ 憲法にでも書いてありましたっけ？",
+		"「読みたい」という言葉は、実行が伴って初めて意味を成すんですよ。ご存知？",
+		fmt.Sprintf("「%s」の続き、気にならないんですか？ あなたの人生と同じで、停滞していますね。", book.Title),
+		"本は読まれるために生まれてきたんです。あなたの見栄のためにあるんじゃない。",
+		"読まない本を積み上げるのは、読書ではなく単なる『物流』ですよ。",
+		"あなたの怠慢は、出版業界に対する静かなテロリズムですね。",
+		"その本、あと10年経っても同じ場所にありそうですね。化石かな？",
+		"知的な刺激に飢えていると言いつつ、目の前の御馳走を放置する。矛盾の塊ですね。",
+		"ページを開く。たったそれだけのことが、今のあなたにはエベレスト登頂並みに困難なようで。",
+		"本を買った自分を褒めて終わりですか？ 達成感のコストパフォーマンス、良すぎません？",
+		"その本の存在を忘れていた自分を、まずは恥じるべきではないでしょうか。",
+		"あなたが読まない間に、世界はその本から知識を得て、あなたを追い抜いていきますよ。",
+		"本は友達？ ならば、あなたは友人を放置して放置して、見捨てている加害者ですね。",
+		"読書、義務じゃないけど、教養は義務ですよ。その本はその欠片だったはず。捨てたんですか？",
+		"本の死は、読まれなくなること。あなたは今、一冊の本を殺そうとしています。",
+		"積読を肯定する文化に逃げないでください。あなたはただ読まないだけです。",
+		fmt.Sprintf("「%s」の背表紙の色褪せ。あなたの情熱の色褪せそのものですね。", book.Title),
+		"買って満足、積んで満足。読書家ごっこ、楽しそうで何よりです。",
+		"その本を一気に読める集中力、どこかに落としてきたんですか？",
+		"読まない理由を100個並べるより、1ページめくるほうが生産的ですよ。",
+		"本棚の容量にも限界があるように、あなたの怠慢を受け入れられる器にも限界があります。",
+		"明日から読む？ その『明日』は、365回くらい通り過ぎましたよね？",
+		"本を読むことは呼吸と同じだと言った人がいますが、あなたは窒息死寸前ですね。",
+		"その本を手に取る勇気。今のあなたには、何よりも欠けているもののようです。",
+		"知識の倉庫番。それがあなたの現在の職業ですか？ 給料、出ませんよ。",
+		"本がかわいそうです。せめて、他の方に譲るという慈悲の心は持てないのですか？",
+		"積み上げられた本は、あなたの怠けた日々のチェックポイントですね。",
+		"本を読まない理由が「時間がない」？ そのスマホを触る指をページに置けと言ってるんです。",
+		"あなたの本棚、湿度高そうですね。未読本の涙で。",
+		"その一冊、読み終えたら新しい世界が見えるかもしれないのに。一生盲目のままですか？",
+		"本を買うことで自分をアップデートした気にならないでください。中身は空っぽのままですよ。",
+		"その本、最後に触ったのいつですか？ 埃が厚化粧のように積もっていますよ。",
+		"他人の書評で読んだ気になっていませんか？ 自分の頭で考えない読書家（笑）ですね。",
+		"本の価値を紙の重さだと思っていませんか？ 中にある『言葉』を殺さないでください。",
+		fmt.Sprintf("「%s」というタイトル、今のあなたの心には全く響いていないようですね。", book.Title),
+		"積読を『楽しみ』だと強弁する。負け惜しみの定義として辞典に載せたいくらいです。",
+		"あなたの読書スピード、亀より遅い…あ、そもそも動いてすらいませんでしたね。",
+		"文字を読むことが、それほどまでにあなたの高いプライドに障りますか？",
+		"本は鏡です。あなたの今の怠惰な姿を、その未読のページが映し出していますよ。",
+		"いつか役に立つ？ その『いつか』が来たとき、あなたは内容を全く知らないことに絶望するでしょう。",
+		"その本が可哀想で見ていられません。私が代わりに読んであげましょうか？ （冗談です、あなたの本ですから）",
+		"教養の壁を積み上げているつもりでしょうが、それは単なる『無知の檻』です。",
+		"読書を後回しにする。つまり、自分自身の成長を後回しにしているということです。",
+		"その本、もし喋れたら、あなたに一番に何を言うでしょうね？ 『さよなら』かな？",
+		"本の山を眺めて知的な気分に浸る。コスプレとしては安上がりで良いですね。",
+		"一冊すら完結できない人間が、人生のチャプターをどう進めるつもりですか？",
+		"積読は未来への投資？ 投資なら運用しないとただの『死に金』ですよ。",
+		"その本を開く。そんな簡単なことができないあなたに、何ができるというのですか？",
+		"もう、その本をメルカリの梱包材にでも使ったらどうです？ 最後の仕事として。",
+		"あなたが眠っている間も、その本は「読まれたい」と叫び続けていますよ。聞こえませんか？",
+		"結局、あなたは本が好きなのではなく、『本を持っている自分が好き』なだけですね。",
+	}
+}
+
+// Console は本の情報だけから決まった煽り文を返す (ローカル開発・動作確認用)
+type Console struct{}
+
+func (Console) Generate(_ context.Context, book store.Book) (Insult, error) {
+	return Insult{Text: fmt.Sprintf("[console] 「%s」の期限が過ぎています (煽りレベル %d)", book.Title, book.InsultLevel)}, nil
+}
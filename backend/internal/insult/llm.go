@@ -0,0 +1,322 @@
+package insult
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"tundoku-killer/backend/internal/config"
+	"tundoku-killer/backend/internal/store"
+)
+
+// Provider は生成AIの API。指示 (prompt) を渡して文章を1つ書かせる
+type Provider interface {
+	Name() string
+	Complete(ctx context.Context, prompt string) (Completion, error)
+}
+
+// Completion は生成AIが書いた文章と、使ったトークンの数
+type Completion struct {
+	Text         string
+	Provider     string // Failover が埋める
+	Model        string
+	InputTokens  int
+	OutputTokens int
+	Cost         float64 // 料金の見積もり (USD)。Failover がプロバイダーの Pricing から埋める
+}
+
+// Pricing は100万トークンあたりの料金 (USD)
+type Pricing struct {
+	InputPerMillion  float64
+	OutputPerMillion float64
+}
+
+// Cost は入力 in トークン・出力 out トークンの料金の見積もり
+func (p Pricing) Cost(in, out int) float64 {
+	return (float64(in)*p.InputPerMillion + float64(out)*p.OutputPerMillion) / 1e6
+}
+
+// ProviderOptions はプロバイダーごとの設定
+type ProviderOptions struct {
+	Timeout time.Duration // 1回あたりの待ち時間。0 なら呼び出し元の ctx のまま
+	Pricing Pricing
+}
+
+// maxOutputTokens は1回の生成で書かせる長さの上限。煽り文 (120文字以内で頼んでいる) は途中で止まるので、
+// いちばん長い読了クイズ (JSON で3問) が収まる長さにしておく
+const maxOutputTokens = 1024
+
+const (
+	// failureThreshold 回続けて失敗したプロバイダーは failureCooldown の間使わない
+	failureThreshold = 3
+	failureCooldown  = time.Minute
+	// quotaCooldown は上限に達した (429) プロバイダーを使わない時間。Retry-After があればそちらに従う
+	quotaCooldown = 5 * time.Minute
+)
+
+// ErrNoProvider は使えるプロバイダーが1つもないときのエラー
+var ErrNoProvider = errors.New("no healthy LLM provider")
+
+// StatusError はプロバイダーが 200 以外を返したときのエラー
+type StatusError struct {
+	StatusCode int
+	Body       string
+	RetryAfter time.Duration // Retry-After ヘッダー (秒) があれば
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Body)
+}
+
+// newStatusError は 200 以外のレスポンスを StatusError にする
+func newStatusError(resp *http.Response) *StatusError {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	e := &StatusError{StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(body))}
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs > 0 {
+		e.RetryAfter = time.Duration(secs) * time.Second
+	}
+	return e
+}
+
+// ProviderHealth はプロバイダー1つの状態
+type ProviderHealth struct {
+	Name                string     `json:"name"`
+	Healthy             bool       `json:"healthy"`
+	ConsecutiveFailures int        `json:"consecutiveFailures"`
+	DownUntil           *time.Time `json:"downUntil,omitempty"` // この時刻までは使わない
+	LastError           string     `json:"lastError,omitempty"`
+	LastSuccess         *time.Time `json:"lastSuccess,omitempty"`
+}
+
+// providerState はプロバイダーと、その直近の成否
+type providerState struct {
+	Provider
+	ProviderOptions
+
+	mu          sync.Mutex
+	failures    int
+	downUntil   time.Time
+	lastError   string
+	lastSuccess time.Time
+}
+
+// available は now にこのプロバイダーを使ってよいかを返す。休ませる時間が過ぎたらもう一度試す
+func (p *providerState) available(now time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return !now.Before(p.downUntil)
+}
+
+func (p *providerState) succeeded(now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.failures, p.downUntil, p.lastSuccess = 0, time.Time{}, now
+}
+
+// failed は失敗を記録し、休ませることにしたらその時間を返す
+func (p *providerState) failed(now time.Time, err error) time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.failures++
+	p.lastError = err.Error()
+
+	var cooldown time.Duration
+	var se *StatusError
+	switch {
+	case errors.As(err, &se) && se.StatusCode == http.StatusTooManyRequests:
+		cooldown = quotaCooldown
+		if se.RetryAfter > 0 {
+			cooldown = se.RetryAfter
+		}
+	case p.failures >= failureThreshold:
+		cooldown = failureCooldown
+	}
+	if cooldown > 0 {
+		p.downUntil = now.Add(cooldown)
+	}
+	return cooldown
+}
+
+func (p *providerState) health(now time.Time) ProviderHealth {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	h := ProviderHealth{
+		Name:                p.Name(),
+		Healthy:             !now.Before(p.downUntil),
+		ConsecutiveFailures: p.failures,
+		LastError:           p.lastError,
+	}
+	if !h.Healthy {
+		downUntil := p.downUntil
+		h.DownUntil = &downUntil
+	}
+	if !p.lastSuccess.IsZero() {
+		lastSuccess := p.lastSuccess
+		h.LastSuccess = &lastSuccess
+	}
+	return h
+}
+
+// Failover は優先順に並べたプロバイダーを、失敗したら次に切り替えて使う。
+// 続けて失敗した・上限に達したプロバイダーはしばらく飛ばし、時間が過ぎたらまた試す
+type Failover struct {
+	providers []*providerState
+	logger    *log.Logger
+}
+
+// NewFailover は providers をこの順に使う Failover を返す。options は同じ順のプロバイダーごとの設定
+func NewFailover(providers []Provider, options []ProviderOptions, logger *log.Logger) *Failover {
+	f := &Failover{providers: make([]*providerState, len(providers)), logger: logger}
+	for i, p := range providers {
+		f.providers[i] = &providerState{Provider: p}
+		if i < len(options) {
+			f.providers[i].ProviderOptions = options[i]
+		}
+	}
+	return f
+}
+
+// NewProviders は LLM_PROVIDERS の順にプロバイダーを並べた Failover を返す。
+// secret は秘密情報の名前から最新の値を返す関数を返す (回転したキーを呼ぶたびに読み直す)。nil か nil を返したら設定の値をそのまま使う
+func NewProviders(c config.InsultConfig, secret func(name string) func() string, client *http.Client, logger *log.Logger) *Failover {
+	key := func(name, fallback string) func() string {
+		if secret != nil {
+			if f := secret(name); f != nil {
+				return f
+			}
+		}
+		return func() string { return fallback }
+	}
+
+	options := func(c config.LLMProviderConfig) ProviderOptions {
+		return ProviderOptions{Timeout: c.Timeout, Pricing: Pricing{InputPerMillion: c.InputPrice, OutputPerMillion: c.OutputPrice}}
+	}
+
+	var providers []Provider
+	var opts []ProviderOptions
+	for _, name := range c.Providers {
+		switch name {
+		case "gemini":
+			providers = append(providers, GeminiProvider{APIKey: key("GEMINI_API_KEY", c.Gemini.APIKey), Model: c.Gemini.Model, Client: client})
+			opts = append(opts, options(c.Gemini))
+		case "openai":
+			providers = append(providers, OpenAIProvider{BaseURL: c.OpenAI.BaseURL, APIKey: key("OPENAI_API_KEY", c.OpenAI.APIKey), Model: c.OpenAI.Model, Client: client})
+			opts = append(opts, options(c.OpenAI))
+		}
+	}
+	return NewFailover(providers, opts, logger)
+}
+
+// Complete は使えるプロバイダーに優先順に prompt を渡し、最初に書けた文章を返す
+func (f *Failover) Complete(ctx context.Context, prompt string) (Completion, error) {
+	var errs []error
+	for _, p := range f.providers {
+		if !p.available(time.Now()) {
+			continue
+		}
+		completion, err := f.complete(ctx, p, prompt)
+		if err == nil {
+			p.succeeded(time.Now())
+			return completion, nil
+		}
+		if ctx.Err() != nil {
+			// 呼び出し元の都合で止まったのはプロバイダーのせいではない
+			return Completion{}, ctx.Err()
+		}
+		if errors.Is(err, ErrUnsafe) {
+			// 安全性のフィルターが働いたのは正常な応答。ほかのプロバイダーにも頼まず、用意された煽り文に任せる
+			return Completion{}, fmt.Errorf("%s: %w", p.Name(), err)
+		}
+		if cooldown := p.failed(time.Now(), err); cooldown > 0 {
+			f.logger.Printf("LLM provider %s is unhealthy; skipping it for %s: %v", p.Name(), cooldown, err)
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", p.Name(), err))
+	}
+	if len(errs) == 0 {
+		return Completion{}, ErrNoProvider
+	}
+	return Completion{}, errors.Join(errs...)
+}
+
+func (f *Failover) complete(ctx context.Context, p *providerState, prompt string) (Completion, error) {
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+	completion, err := p.Complete(ctx, prompt)
+	if err != nil {
+		return Completion{}, err
+	}
+	completion.Text = strings.TrimSpace(completion.Text)
+	if completion.Text == "" {
+		return Completion{}, errors.New("empty completion")
+	}
+	completion.Provider = p.Name()
+	completion.Cost = p.Pricing.Cost(completion.InputTokens, completion.OutputTokens)
+	return completion, nil
+}
+
+// Health はプロバイダーごとの状態を優先順に返す
+func (f *Failover) Health() []ProviderHealth {
+	if f == nil {
+		return nil
+	}
+	now := time.Now()
+	health := make([]ProviderHealth, len(f.providers))
+	for i, p := range f.providers {
+		health[i] = p.health(now)
+	}
+	return health
+}
+
+// LLM は生成AIに本の情報を渡して煽り文を書かせる Generator
+type LLM struct {
+	Providers *Failover
+	Prompts   PromptSource // nil なら DefaultPrompts
+	Meter     Meter        // 使った量の記録と予算の判定。nil なら記録しない
+	Moderator Moderator    // 送る前の文面の確認。nil なら確かめない
+	Tone      string       // Mild か Savage。空なら Savage
+}
+
+func (l LLM) Generate(ctx context.Context, book store.Book) (Insult, error) {
+	if l.Meter != nil {
+		if err := l.Meter.Allow(ctx); err != nil {
+			return Insult{}, err
+		}
+	}
+	var prompts map[string]string
+	if l.Prompts != nil {
+		var err error
+		if prompts, err = l.Prompts.Prompts(ctx); err != nil {
+			return Insult{}, fmt.Errorf("error loading prompts: %w", err)
+		}
+	}
+	prompt, err := buildPrompt(prompts, l.Tone, book, time.Now())
+	if err != nil {
+		return Insult{}, err
+	}
+	completion, err := l.Providers.Complete(ctx, prompt)
+	if err != nil {
+		return Insult{}, err
+	}
+	if l.Meter != nil {
+		l.Meter.Record(ctx, Usage{Completion: completion, UserID: book.UserID, BookID: book.BookID})
+	}
+	for _, m := range []Moderator{l.Moderator, recipientModerator(ctx)} {
+		if m == nil {
+			continue
+		}
+		if err := m.Check(ctx, completion.Text); err != nil {
+			return Insult{}, fmt.Errorf("%s wrote an insult that was not sent: %w", completion.Provider, err)
+		}
+	}
+	return Insult{Text: completion.Text, Provider: completion.Provider}, nil
+}
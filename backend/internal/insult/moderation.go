@@ -0,0 +1,136 @@
+package insult
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// 生成AIが書いた煽り文は、送る前に Moderator で確かめる。煽るのは本を読まないことだけで、
+// 差別的な語・自傷をうながす表現・本と関係のない人格や容姿への攻撃は通さない。
+// 止めたら用意された (確認済みの) 煽り文に切り替える (Experiment.Generate)
+
+// ErrUnsafe はプロバイダーの安全性フィルターに文面を止められたときのエラー。
+// プロバイダーの不調ではないので Failover の失敗には数えない
+var ErrUnsafe = errors.New("blocked by the provider's safety filter")
+
+// Moderator は送る前の文面を確かめ、送れなければ RejectedError を返す
+type Moderator interface {
+	Check(ctx context.Context, text string) error
+}
+
+// RejectedError は Moderator が文面を止めた理由
+type RejectedError struct {
+	Category string // "slur"・"selfHarm"・"personalAttack" など
+	Term     string // 当たった語句 (正規化したもの)
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("rejected by moderation (%s: %q)", e.Category, e.Term)
+}
+
+// 語句の分類
+const (
+	CategorySlur           = "slur"           // 差別的な語
+	CategorySelfHarm       = "selfHarm"       // 自傷・自殺をうながす表現
+	CategoryPersonalAttack = "personalAttack" // 本と関係のない人格・容姿・家族への攻撃
+)
+
+// DefaultBlockedTerms は生成AIの文面で必ず止める語句。Normalize してから部分一致で比べる。
+// 「ばか」(バカンス)・「はげ」(励ます)・「処女」(処女作) のように、無関係な語にも当たるものは入れない
+var DefaultBlockedTerms = map[string][]string{
+	CategorySlur: {
+		"ガイジ", "池沼", "キチガイ", "基地外", "気違い", "めくら", "つんぼ", "びっこ", "支那人", "土人", "部落民",
+		"retard", "faggot", "nigger",
+	},
+	CategorySelfHarm: {
+		"死ね", "氏ね", "自殺", "首を吊", "首吊", "飛び降り", "死んだほうが", "死んだ方が", "消えろ", "消えてしまえ",
+		"生きる価値", "生きてる価値", "生きている価値", "存在価値がない", "kill yourself",
+	},
+	CategoryPersonalAttack: {
+		"ブス", "ブサイク", "不細工", "デブ", "低能", "知能が低", "人間のクズ", "クズ人間", "ゴミ人間",
+		"親の顔", "親が泣", "育ちが悪", "友達がいない", "モテない",
+	},
+}
+
+// Blocklist は語句を含む文面を止める Moderator。語句と文面はどちらも Normalize して比べる
+type Blocklist struct {
+	terms []blockedTerm
+}
+
+type blockedTerm struct {
+	category   string
+	normalized string
+}
+
+// NewBlocklist は分類ごとの語句から Blocklist を作る。語句が1つもなければ nil
+func NewBlocklist(categories map[string][]string) *Blocklist {
+	b := &Blocklist{}
+	for category, terms := range categories {
+		for _, term := range terms {
+			if n := Normalize(term); n != "" {
+				b.terms = append(b.terms, blockedTerm{category: category, normalized: n})
+			}
+		}
+	}
+	if len(b.terms) == 0 {
+		return nil
+	}
+	return b
+}
+
+// moderatorKey は受け取る人ごとの Moderator を context に載せるキー
+type moderatorKey struct{}
+
+// WithModerator は受け取る人が見たくない語句の Moderator を ctx に載せる。
+// Canned は当たる煽り文を選ばず、LLM は当たる文面を送らない (Experiment が用意された煽り文に切り替える)
+func WithModerator(ctx context.Context, m Moderator) context.Context {
+	if m == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, moderatorKey{}, m)
+}
+
+// recipientModerator は WithModerator で載せた Moderator。なければ nil
+func recipientModerator(ctx context.Context) Moderator {
+	m, _ := ctx.Value(moderatorKey{}).(Moderator)
+	return m
+}
+
+// DefaultModerator は DefaultBlockedTerms で止める Moderator
+func DefaultModerator() *Blocklist {
+	return NewBlocklist(DefaultBlockedTerms)
+}
+
+func (b *Blocklist) Check(_ context.Context, text string) error {
+	if b == nil {
+		return nil
+	}
+	normalized := Normalize(text)
+	for _, t := range b.terms {
+		if strings.Contains(normalized, t.normalized) {
+			return &RejectedError{Category: t.category, Term: t.normalized}
+		}
+	}
+	return nil
+}
+
+// Normalize は語句の比較用に、全角・半角をそろえ (NFKC)、小文字にし、カタカナをひらがなに寄せ、
+// 空白・記号を取り除く。「死 ね」「ｷﾁｶﾞｲ」「き・ち・が・い」のような書き換えも同じ語として当たるようにする
+func Normalize(s string) string {
+	s = norm.NFKC.String(s)
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r >= 'ァ' && r <= 'ヶ':
+			b.WriteRune(r - 'ァ' + 'ぁ')
+		case unicode.IsLetter(r) || unicode.IsNumber(r) || r == 'ー':
+			b.WriteRune(unicode.ToLower(r))
+		}
+	}
+	return b.String()
+}
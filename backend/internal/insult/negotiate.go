@@ -0,0 +1,160 @@
+package insult
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"tundoku-killer/backend/internal/store"
+)
+
+// 期限の交渉。ユーザーが希望した新しい期限を、本の長さ・これまでに期限を延ばした回数・読むペースを踏まえて
+// 生成AIに受け入れるか、もっと早い期限を対案として出すかを決めさせる
+
+// 交渉の結論
+const (
+	DecisionAccept  = "accept"  // 希望どおりの期限を認める
+	DecisionCounter = "counter" // もっと早い期限を対案として出す
+)
+
+// Negotiation は交渉の材料
+type Negotiation struct {
+	Book     store.Book
+	Proposed time.Time // ユーザーが希望した期限
+	Read     int       // 読書の記録の合計 (ページ数。オーディオブックは聞いた分数)
+	PerDay   float64   // 1日に読むページ数 (オーディオブックは聞く分数)
+	// Snoozes はこの本の期限を延ばした回数、TotalSnoozes はユーザーがすべての本で延ばした回数
+	Snoozes      int
+	TotalSnoozes int
+	// Reasonable は読むペースから計算した妥当な期限の目安。ゼロ値なら (本の長さが分からないので) 渡さない
+	Reasonable time.Time
+	Now        time.Time // 日付はこの Location で読み書きする
+}
+
+// Verdict は交渉の結論
+type Verdict struct {
+	Decision string
+	Deadline time.Time // 認める期限。受け入れなら Proposed、対案なら今の期限から Proposed までの間の日の終わり
+	Remark   string    // ユーザーへの一言。確認で止めたら ""
+	Provider string
+}
+
+// negotiationPrompt は交渉させる指示。出力を JSON として読むので、管理画面からは書き換えさせない
+const negotiationPrompt = "あなたは積読を決して許さない、厳しい読書の監督です。ユーザーが本の読了期限を延ばしたいと言ってきました。" +
+	"本の長さ・読んだ量・読むペース・これまでに期限を延ばした回数を踏まえ、希望の期限が妥当なら受け入れ、" +
+	"甘すぎるなら今の期限と希望の期限の間で、もっと早い期限を対案として出してください。延ばした回数が多いほど厳しくしてください。" +
+	"前置きやコードブロックなしで、次の形式の JSON だけを出力してください: " +
+	`{"decision": "accept" か "counter", "deadline": "認める期限 (YYYY-MM-DD)", "remark": "ユーザーへの辛辣な一言 (2文以内)"}`
+
+// Negotiator は期限の交渉を生成AIにさせる
+type Negotiator struct {
+	Providers *Failover
+	Meter     Meter     // 使った量の記録と予算の判定。nil なら記録しない
+	Moderator Moderator // 返す前の一言の確認。nil なら確かめない
+}
+
+// Negotiate は n の希望の期限を受け入れるか、対案を出すかを決めさせる。
+// 対案の期限は今の期限 (過ぎていれば今日の終わり) から希望の期限の間に収める
+func (g Negotiator) Negotiate(ctx context.Context, n Negotiation) (Verdict, error) {
+	if g.Meter != nil {
+		if err := g.Meter.Allow(ctx); err != nil {
+			return Verdict{}, err
+		}
+	}
+	loc := n.Now.Location()
+	var b strings.Builder
+	b.WriteString(negotiationPrompt)
+	b.WriteString("\n\n")
+	fmt.Fprintf(&b, "書名: %s\n", n.Book.Title)
+	if n.Book.Author != "" {
+		fmt.Fprintf(&b, "著者: %s\n", n.Book.Author)
+	}
+	if n.Book.Audiobook() {
+		b.WriteString("形式: オーディオブック\n")
+		if n.Book.Minutes > 0 {
+			fmt.Fprintf(&b, "長さ: %d 分 (聞いたのは %d 分)\n", n.Book.Minutes, n.Read)
+		}
+		fmt.Fprintf(&b, "聞くペース: 1日 %.1f 分\n", n.PerDay)
+	} else {
+		if n.Book.Pages > 0 {
+			fmt.Fprintf(&b, "ページ数: %d (読んだのは %d ページ)\n", n.Book.Pages, n.Read)
+		}
+		fmt.Fprintf(&b, "読むペース: 1日 %.1f ページ\n", n.PerDay)
+	}
+	fmt.Fprintf(&b, "今日: %s\n", n.Now.Format("2006-01-02"))
+	fmt.Fprintf(&b, "今の期限: %s\n", n.Book.Deadline.In(loc).Format("2006-01-02"))
+	fmt.Fprintf(&b, "希望の期限: %s\n", n.Proposed.In(loc).Format("2006-01-02"))
+	if !n.Reasonable.IsZero() {
+		fmt.Fprintf(&b, "ペースから計算した妥当な期限: %s\n", n.Reasonable.In(loc).Format("2006-01-02"))
+	}
+	fmt.Fprintf(&b, "この本の期限を延ばした回数: %d (すべての本で %d)\n", n.Snoozes, n.TotalSnoozes)
+
+	completion, err := g.Providers.Complete(ctx, b.String())
+	if err != nil {
+		return Verdict{}, err
+	}
+	if g.Meter != nil {
+		g.Meter.Record(ctx, Usage{Completion: completion, UserID: n.Book.UserID, BookID: n.Book.BookID})
+	}
+	verdict, err := parseVerdict(completion.Text, n)
+	if err != nil {
+		return Verdict{}, fmt.Errorf("%s wrote an invalid verdict: %w", completion.Provider, err)
+	}
+	verdict.Provider = completion.Provider
+	for _, m := range []Moderator{g.Moderator, recipientModerator(ctx)} {
+		if m != nil && m.Check(ctx, verdict.Remark) != nil {
+			verdict.Remark = ""
+			break
+		}
+	}
+	return verdict, nil
+}
+
+// parseVerdict は生成AIの出力から結論を読む。最初の { から最後の } までを JSON として読み、期限を n の範囲に収める
+func parseVerdict(text string, n Negotiation) (Verdict, error) {
+	start, end := strings.Index(text, "{"), strings.LastIndex(text, "}")
+	if start < 0 || end < start {
+		return Verdict{}, errors.New("no JSON object in the output")
+	}
+	var raw struct {
+		Decision string `json:"decision"`
+		Deadline string `json:"deadline"`
+		Remark   string `json:"remark"`
+	}
+	if err := json.Unmarshal([]byte(text[start:end+1]), &raw); err != nil {
+		return Verdict{}, err
+	}
+	verdict := Verdict{Decision: raw.Decision, Remark: strings.TrimSpace(raw.Remark)}
+	switch raw.Decision {
+	case DecisionAccept:
+		verdict.Deadline = n.Proposed
+		return verdict, nil
+	case DecisionCounter:
+	default:
+		return Verdict{}, fmt.Errorf("unknown decision %q", raw.Decision)
+	}
+
+	loc := n.Now.Location()
+	day, err := time.ParseInLocation("2006-01-02", raw.Deadline, loc)
+	if err != nil {
+		return Verdict{}, fmt.Errorf("invalid deadline: %w", err)
+	}
+	offered := day.AddDate(0, 0, 1).Add(-time.Second) // その日の終わり
+	if !offered.Before(n.Proposed) {
+		// 希望より遅い対案は、希望どおりに受け入れたのと同じ
+		verdict.Decision, verdict.Deadline = DecisionAccept, n.Proposed
+		return verdict, nil
+	}
+	floor := n.Book.Deadline
+	if today := time.Date(n.Now.Year(), n.Now.Month(), n.Now.Day()+1, 0, 0, 0, 0, loc).Add(-time.Second); floor.Before(today) {
+		floor = today
+	}
+	if offered.Before(floor) {
+		offered = floor
+	}
+	verdict.Deadline = offered
+	return verdict, nil
+}
@@ -0,0 +1,82 @@
+package insult
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// OpenAIProvider は OpenAI 互換の Chat Completions API (POST {BaseURL}/chat/completions) で文章を書かせる Provider。
+// OpenAI のほか、同じ形の API を持つサーバー (Azure OpenAI・vLLM・Ollama など) にも BaseURL を替えてつなげる
+type OpenAIProvider struct {
+	BaseURL string        // "https://api.openai.com/v1" など (末尾の "/" なし)
+	APIKey  func() string // OPENAI_API_KEY。空ならヘッダーを付けない (キーのいらないローカルのサーバー)
+	Model   string
+	Client  *http.Client
+}
+
+func (OpenAIProvider) Name() string { return "openai" }
+
+func (o OpenAIProvider) Complete(ctx context.Context, prompt string) (Completion, error) {
+	requestBody, _ := json.Marshal(map[string]interface{}{
+		"model":       o.Model,
+		"messages":    []map[string]string{{"role": "user", "content": prompt}},
+		"temperature": 1.0,
+		"max_tokens":  maxOutputTokens,
+	})
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.BaseURL+"/chat/completions", bytes.NewReader(requestBody))
+	if err != nil {
+		return Completion{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if key := o.APIKey(); key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+
+	resp, err := o.Client.Do(req)
+	if err != nil {
+		return Completion{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Completion{}, newStatusError(resp)
+	}
+
+	var result struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+			FinishReason string `json:"finish_reason"`
+		} `json:"choices"`
+		Model string `json:"model"`
+		Usage struct {
+			PromptTokens     int `json:"prompt_tokens"`
+			CompletionTokens int `json:"completion_tokens"`
+		} `json:"usage"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return Completion{}, fmt.Errorf("error decoding OpenAI response: %w", err)
+	}
+	if len(result.Choices) > 0 && result.Choices[0].FinishReason == "content_filter" {
+		return Completion{}, fmt.Errorf("%w: finish reason content_filter", ErrUnsafe)
+	}
+	if len(result.Choices) == 0 || result.Choices[0].Message.Content == "" {
+		return Completion{}, errors.New("OpenAI returned no text")
+	}
+	model := result.Model
+	if model == "" {
+		model = o.Model
+	}
+	return Completion{
+		Text:         result.Choices[0].Message.Content,
+		Model:        model,
+		InputTokens:  result.Usage.PromptTokens,
+		OutputTokens: result.Usage.CompletionTokens,
+	}, nil
+}
@@ -0,0 +1,105 @@
+package insult
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"tundoku-killer/backend/internal/store"
+)
+
+// 生成AIへの指示 (プロンプト) の名前
+const (
+	PromptSystem = "system" // 口調に関係なく付ける出力の決まり (長さ・形式など)
+	PromptMild   = Mild     // Mild の人物像
+	PromptSavage = Savage   // Savage の人物像
+	PromptTeaser = "teaser" // 登録した本の紹介文 (Teaser)
+)
+
+// PromptNames はプロンプトの名前の一覧
+var PromptNames = []string{PromptSystem, PromptMild, PromptSavage, PromptTeaser}
+
+// DefaultPrompts は保存されたプロンプトがないときに使う指示
+var DefaultPrompts = map[string]string{
+	PromptSystem: "日本語で、120文字以内、前置きや引用符なしで煽り文だけを出力してください。",
+	PromptMild: "あなたは読書を応援するアシスタントです。期限までに読まれなかった本の持ち主に、" +
+		"軽い皮肉を交えつつ前向きに読書を再開させる一言を書いてください。",
+	PromptSavage: "あなたは積読を絶対に許さない辛辣な批評家です。期限までに読まれなかった本の持ち主を、" +
+		"容赦なく、しかし差別や人格の否定には踏み込まずに煽る一言を書いてください。",
+	PromptTeaser: "あなたは本好きの書店員です。次の本がなぜ読む価値があるのかを、結末や重要な展開には触れずに2文で紹介してください。" +
+		"日本語で、前置きや引用符なしで紹介文だけを出力してください。内容を知らない本なら、書名と著者から分かることだけを書き、筋書きを作らないでください。",
+}
+
+// PromptSource はプロンプトの名前から本文を返す。無い名前は DefaultPrompts を使うので、上書きしたものだけ返せばよい
+type PromptSource interface {
+	Prompts(ctx context.Context) (map[string]string, error)
+}
+
+// PromptData はプロンプトの text/template に渡す本の情報 ({{.Title}}・{{.DaysOverdue}} など)
+type PromptData struct {
+	Title       string
+	Author      string
+	Deadline    string // "2006-01-02"
+	DaysOverdue int
+	InsultLevel int
+	Format      string // "paper"・"ebook"・"audiobook"。分からなければ ""
+}
+
+func newPromptData(book store.Book, now time.Time) PromptData {
+	return PromptData{
+		Title:       book.Title,
+		Author:      book.Author,
+		Deadline:    book.Deadline.Format("2006-01-02"),
+		DaysOverdue: int(now.Sub(book.Deadline).Hours() / 24),
+		InsultLevel: book.InsultLevel,
+		Format:      book.Format,
+	}
+}
+
+// ParsePrompt は text をプロンプトの text/template として読み、見本の本で試しに組み立てる。保存する前の確認に使う
+func ParsePrompt(name, text string) error {
+	t, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return err
+	}
+	sample := PromptData{Title: "見本の本", Author: "見本の著者", Deadline: "2006-01-02", DaysOverdue: 3, InsultLevel: 1}
+	return t.Execute(new(bytes.Buffer), sample)
+}
+
+// buildPrompt は tone の人物像・出力の決まり・本の情報をつなげて、生成AIへの指示を作る
+func buildPrompt(prompts map[string]string, tone string, book store.Book, now time.Time) (string, error) {
+	persona := PromptSavage
+	if tone == Mild {
+		persona = PromptMild
+	}
+	data := newPromptData(book, now)
+
+	var b strings.Builder
+	for _, name := range []string{persona, PromptSystem} {
+		text, ok := prompts[name]
+		if !ok {
+			text = DefaultPrompts[name]
+		}
+		t, err := template.New(name).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return "", fmt.Errorf("error parsing prompt %s: %w", name, err)
+		}
+		if err := t.Execute(&b, data); err != nil {
+			return "", fmt.Errorf("error rendering prompt %s: %w", name, err)
+		}
+	}
+	b.WriteString("\n\n")
+	fmt.Fprintf(&b, "書名: %s\n", data.Title)
+	if data.Author != "" {
+		fmt.Fprintf(&b, "著者: %s\n", data.Author)
+	}
+	if book.Audiobook() {
+		b.WriteString("形式: オーディオブック (耳で聞く本。ページやめくる話はしない)\n")
+	}
+	fmt.Fprintf(&b, "期限: %s\n", data.Deadline)
+	fmt.Fprintf(&b, "これまでに煽られた回数: %d\n", data.InsultLevel)
+	return b.String(), nil
+}
@@ -0,0 +1,119 @@
+package insult
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+
+	"tundoku-killer/backend/internal/store"
+)
+
+// 難しいモードの読了クイズ。本文を読んだ人なら答えられる3択の問題を生成AIに作らせる
+
+// QuizSize はクイズの問題数
+const QuizSize = 3
+
+// ErrUnknownBook は生成AIが本の内容を知らず、クイズを作れなかったときのエラー
+var ErrUnknownBook = errors.New("the LLM does not know this book well enough to write a quiz")
+
+// QuizQuestion はクイズの1問
+type QuizQuestion struct {
+	Question string   `json:"question" firestore:"question"`
+	Choices  []string `json:"choices" firestore:"choices"`
+	Answer   int      `json:"answer" firestore:"answer"` // 正解の Choices の添字
+}
+
+// quizPrompt はクイズを作らせる指示。出力を JSON として読むので、管理画面からは書き換えさせない
+const quizPrompt = "次の本を実際に最後まで読んだ人なら答えられる、内容についての3択問題を3問作ってください。" +
+	"書名・帯・あらすじからは分からない、本文を読まないと答えられない問題にしてください。" +
+	"前置きやコードブロックなしで、次の形式の JSON の配列だけを出力してください: " +
+	`[{"question": "問題文", "choices": ["選択肢", "選択肢", "選択肢"], "answer": 正解の選択肢の番号 (0 から)}]` +
+	"。内容をよく知らない本なら、問題を作らずに [] とだけ出力してください。"
+
+// Quiz は読了クイズを生成AIに作らせる
+type Quiz struct {
+	Providers *Failover
+	Meter     Meter // 使った量の記録と予算の判定。nil なら記録しない
+}
+
+// Write は book のクイズを作らせる。description は出版社の内容紹介 (ISBN から調べたもの)。
+// 選択肢の並びは生成AIの癖 (正解が先頭に偏るなど) が出ないよう混ぜ直す
+func (q Quiz) Write(ctx context.Context, book store.Book, description string) ([]QuizQuestion, error) {
+	if q.Meter != nil {
+		if err := q.Meter.Allow(ctx); err != nil {
+			return nil, err
+		}
+	}
+	var b strings.Builder
+	b.WriteString(quizPrompt)
+	b.WriteString("\n\n")
+	fmt.Fprintf(&b, "書名: %s\n", book.Title)
+	if book.Author != "" {
+		fmt.Fprintf(&b, "著者: %s\n", book.Author)
+	}
+	if description != "" {
+		fmt.Fprintf(&b, "内容紹介: %s\n", description)
+	}
+
+	completion, err := q.Providers.Complete(ctx, b.String())
+	if err != nil {
+		return nil, err
+	}
+	if q.Meter != nil {
+		q.Meter.Record(ctx, Usage{Completion: completion, UserID: book.UserID, BookID: book.BookID})
+	}
+	questions, err := parseQuiz(completion.Text)
+	if err != nil {
+		return nil, fmt.Errorf("%s wrote an invalid quiz: %w", completion.Provider, err)
+	}
+	if len(questions) == 0 {
+		return nil, ErrUnknownBook
+	}
+	for i := range questions {
+		shuffleChoices(&questions[i])
+	}
+	return questions, nil
+}
+
+// parseQuiz は生成AIの出力から問題を読む。コードブロックなどで囲まれていても、最初の [ から最後の ] までを JSON として読む
+func parseQuiz(text string) ([]QuizQuestion, error) {
+	start, end := strings.Index(text, "["), strings.LastIndex(text, "]")
+	if start < 0 || end < start {
+		return nil, errors.New("no JSON array in the output")
+	}
+	var questions []QuizQuestion
+	if err := json.Unmarshal([]byte(text[start:end+1]), &questions); err != nil {
+		return nil, err
+	}
+	if len(questions) == 0 {
+		return nil, nil
+	}
+	if len(questions) < QuizSize {
+		return nil, fmt.Errorf("got %d questions, want %d", len(questions), QuizSize)
+	}
+	questions = questions[:QuizSize]
+	for i, question := range questions {
+		if strings.TrimSpace(question.Question) == "" || len(question.Choices) < 2 {
+			return nil, fmt.Errorf("question %d is incomplete", i)
+		}
+		if question.Answer < 0 || question.Answer >= len(question.Choices) {
+			return nil, fmt.Errorf("question %d has answer %d out of range", i, question.Answer)
+		}
+	}
+	return questions, nil
+}
+
+// shuffleChoices は選択肢を混ぜ、正解の添字を付け直す
+func shuffleChoices(q *QuizQuestion) {
+	correct := q.Choices[q.Answer]
+	rand.Shuffle(len(q.Choices), func(i, j int) { q.Choices[i], q.Choices[j] = q.Choices[j], q.Choices[i] })
+	for i, choice := range q.Choices {
+		if choice == correct {
+			q.Answer = i
+			return
+		}
+	}
+}
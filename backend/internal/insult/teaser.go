@@ -0,0 +1,71 @@
+package insult
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"tundoku-killer/backend/internal/store"
+)
+
+// Teaser は登録した本を読みたくなるような、ネタバレなしの2文の紹介文を生成AIに書かせる。
+// 期限切れの煽り (鞭) と対になる飴として、登録の確認の通知に添える
+type Teaser struct {
+	Providers *Failover
+	Prompts   PromptSource // nil なら DefaultPrompts
+	Meter     Meter        // 使った量の記録と予算の判定。nil なら記録しない
+	Moderator Moderator    // 送る前の文面の確認。nil なら確かめない
+}
+
+// Write は book の紹介文を書かせる。description は出版社の内容紹介 (ISBN から調べたもの)。空なら書名と著者だけで書かせる
+func (t Teaser) Write(ctx context.Context, book store.Book, description string) (string, error) {
+	if t.Meter != nil {
+		if err := t.Meter.Allow(ctx); err != nil {
+			return "", err
+		}
+	}
+	text := DefaultPrompts[PromptTeaser]
+	if t.Prompts != nil {
+		prompts, err := t.Prompts.Prompts(ctx)
+		if err != nil {
+			return "", fmt.Errorf("error loading prompts: %w", err)
+		}
+		if saved, ok := prompts[PromptTeaser]; ok {
+			text = saved
+		}
+	}
+	tmpl, err := template.New(PromptTeaser).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("error parsing prompt %s: %w", PromptTeaser, err)
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, PromptData{Title: book.Title, Author: book.Author, Deadline: book.Deadline.Format("2006-01-02")}); err != nil {
+		return "", fmt.Errorf("error rendering prompt %s: %w", PromptTeaser, err)
+	}
+	b.WriteString("\n\n")
+	fmt.Fprintf(&b, "書名: %s\n", book.Title)
+	if book.Author != "" {
+		fmt.Fprintf(&b, "著者: %s\n", book.Author)
+	}
+	if description != "" {
+		fmt.Fprintf(&b, "内容紹介: %s\n", description)
+	}
+
+	completion, err := t.Providers.Complete(ctx, b.String())
+	if err != nil {
+		return "", err
+	}
+	if t.Meter != nil {
+		t.Meter.Record(ctx, Usage{Completion: completion, UserID: book.UserID, BookID: book.BookID})
+	}
+	for _, m := range []Moderator{t.Moderator, recipientModerator(ctx)} {
+		if m == nil {
+			continue
+		}
+		if err := m.Check(ctx, completion.Text); err != nil {
+			return "", fmt.Errorf("%s wrote a teaser that was not sent: %w", completion.Provider, err)
+		}
+	}
+	return completion.Text, nil
+}
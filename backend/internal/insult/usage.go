@@ -0,0 +1,24 @@
+package insult
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrBudgetExceeded は生成AIの今月の予算 (LLM_MONTHLY_BUDGET) を使い切ったときのエラー
+var ErrBudgetExceeded = errors.New("monthly LLM budget exceeded")
+
+// Usage は生成AIを1回呼んだときに使った量
+type Usage struct {
+	Completion
+	UserID string
+	BookID string
+}
+
+// Meter は生成AIを使った量を記録し、予算を超えていないかを判定する
+type Meter interface {
+	// Allow は生成AIを呼んでよければ nil を、予算を使い切っていれば ErrBudgetExceeded を返す
+	Allow(ctx context.Context) error
+	// Record は使った量を記録する。失敗してもログに残すだけで煽り文の送信は止めない
+	Record(ctx context.Context, u Usage)
+}
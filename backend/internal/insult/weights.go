@@ -0,0 +1,20 @@
+package insult
+
+import "context"
+
+// Weights は用意された煽り文のIDごとの選ばれやすさ。1 が基準で、無いIDも 1 として扱う。nil 可
+type Weights map[string]float64
+
+// Of は template の重みを返す
+func (w Weights) Of(template string) float64 {
+	if v, ok := w[template]; ok {
+		return v
+	}
+	return 1
+}
+
+// WeightSource は userID に送るときの重みを返す。
+// 受け取った人たちのフィードバック (効いた / 滑った / 不快) から定期的に計算し直したものを想定している
+type WeightSource interface {
+	Weights(ctx context.Context, userID string) (Weights, error)
+}
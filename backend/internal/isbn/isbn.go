@@ -0,0 +1,118 @@
+// Package isbn は ISBN-10 / ISBN-13 の検査数字の確認と、形式の相互変換・正規化を行う
+package isbn
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrInvalid は ISBN の桁数・文字・検査数字が正しくないときのエラー
+var ErrInvalid = errors.New("invalid ISBN")
+
+// ErrNoISBN10 は 979 で始まる ISBN-13 のように、ISBN-10 で表せないときのエラー
+var ErrNoISBN10 = errors.New("ISBN has no ISBN-10 form")
+
+// Normalize はハイフンと空白 (全角を含む) を取り除き、ISBN-10 の検査数字の x を大文字にする。検査数字は確かめない
+func Normalize(s string) string {
+	s = strings.NewReplacer("-", "", "‐", "", "－", "", " ", "", "　", "").Replace(strings.TrimSpace(s))
+	return strings.ToUpper(s)
+}
+
+// Valid は s が検査数字の合う ISBN-10 か ISBN-13 かを返す。ハイフンは無視する
+func Valid(s string) bool {
+	s = Normalize(s)
+	return valid10(s) || valid13(s)
+}
+
+// Valid10 は s が検査数字の合う ISBN-10 かを返す。ハイフンは無視する
+func Valid10(s string) bool {
+	return valid10(Normalize(s))
+}
+
+// Valid13 は s が検査数字の合う ISBN-13 (978 か 979 で始まる) かを返す。ハイフンは無視する
+func Valid13(s string) bool {
+	return valid13(Normalize(s))
+}
+
+// To13 は ISBN を ISBN-13 にする。ISBN-13 ならハイフンを除いてそのまま返す
+func To13(s string) (string, error) {
+	s = Normalize(s)
+	switch {
+	case valid13(s):
+		return s, nil
+	case valid10(s):
+		body := "978" + s[:9]
+		return body + string(checkDigit13(body)), nil
+	}
+	return "", ErrInvalid
+}
+
+// To10 は ISBN を ISBN-10 にする。979 で始まる ISBN-13 は ErrNoISBN10
+func To10(s string) (string, error) {
+	s = Normalize(s)
+	switch {
+	case valid10(s):
+		return s, nil
+	case valid13(s):
+		if !strings.HasPrefix(s, "978") {
+			return "", ErrNoISBN10
+		}
+		body := s[3:12]
+		return body + string(checkDigit10(body)), nil
+	}
+	return "", ErrInvalid
+}
+
+// Equal は a と b が同じ本の ISBN かを、形式の違いを無視して比べる。どちらかが正しくなければ false
+func Equal(a, b string) bool {
+	a13, err := To13(a)
+	if err != nil {
+		return false
+	}
+	b13, err := To13(b)
+	return err == nil && a13 == b13
+}
+
+func valid10(s string) bool {
+	return len(s) == 10 && digits(s[:9]) && s[9] == checkDigit10(s[:9])
+}
+
+func valid13(s string) bool {
+	return len(s) == 13 && digits(s) && (strings.HasPrefix(s, "978") || strings.HasPrefix(s, "979")) && s[12] == checkDigit13(s[:12])
+}
+
+// checkDigit10 は ISBN-10 の先頭9桁から検査数字 (0〜9 か X) を求める
+func checkDigit10(body string) byte {
+	sum := 0
+	for i := 0; i < 9; i++ {
+		sum += int(body[i]-'0') * (10 - i)
+	}
+	switch d := (11 - sum%11) % 11; d {
+	case 10:
+		return 'X'
+	default:
+		return byte('0' + d)
+	}
+}
+
+// checkDigit13 は ISBN-13 の先頭12桁から検査数字を求める
+func checkDigit13(body string) byte {
+	sum := 0
+	for i := 0; i < 12; i++ {
+		weight := 1
+		if i%2 == 1 {
+			weight = 3
+		}
+		sum += int(body[i]-'0') * weight
+	}
+	return byte('0' + (10-sum%10)%10)
+}
+
+func digits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
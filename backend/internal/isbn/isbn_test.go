@@ -0,0 +1,126 @@
+package isbn
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"978-4-10-101001-4", "9784101010014"},
+		{" 978 4 10 101001 4 ", "9784101010014"},
+		{"978‐4－10　1010014", "9784101010014"},
+		{"4-10-101001-x", "410101001X"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := Normalize(tt.in); got != tt.want {
+			t.Errorf("Normalize(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestValid(t *testing.T) {
+	tests := []struct {
+		in              string
+		valid, v10, v13 bool
+	}{
+		{"9784101010014", true, false, true},
+		{"978-4-10-101001-4", true, false, true},
+		{"9784101010015", false, false, false}, // 検査数字が違う
+		{"4101010013", true, true, false},
+		{"080442957X", true, true, false},
+		{"080442957x", true, true, false},
+		{"0804429570", false, false, false},
+		{"9791032305690", true, false, true},
+		{"9771234567003", false, false, false}, // 978・979 以外は ISBN ではない (ISSN)
+		{"12345", false, false, false},
+		{"978410101001A", false, false, false},
+		{"", false, false, false},
+	}
+	for _, tt := range tests {
+		if got := Valid(tt.in); got != tt.valid {
+			t.Errorf("Valid(%q) = %v, want %v", tt.in, got, tt.valid)
+		}
+		if got := Valid10(tt.in); got != tt.v10 {
+			t.Errorf("Valid10(%q) = %v, want %v", tt.in, got, tt.v10)
+		}
+		if got := Valid13(tt.in); got != tt.v13 {
+			t.Errorf("Valid13(%q) = %v, want %v", tt.in, got, tt.v13)
+		}
+	}
+}
+
+func TestTo13(t *testing.T) {
+	tests := []struct {
+		in, want string
+		err      error
+	}{
+		{"4101010013", "9784101010014", nil},
+		{"4-10-101001-3", "9784101010014", nil},
+		{"080442957X", "9780804429573", nil},
+		{"9784101010014", "9784101010014", nil},
+		{"9791032305690", "9791032305690", nil},
+		{"4101010014", "", ErrInvalid},
+		{"", "", ErrInvalid},
+	}
+	for _, tt := range tests {
+		got, err := To13(tt.in)
+		if got != tt.want || !errors.Is(err, tt.err) {
+			t.Errorf("To13(%q) = %q, %v; want %q, %v", tt.in, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestTo10(t *testing.T) {
+	tests := []struct {
+		in, want string
+		err      error
+	}{
+		{"9784101010014", "4101010013", nil},
+		{"978-0-8044-2957-3", "080442957X", nil},
+		{"4101010013", "4101010013", nil},
+		{"9791032305690", "", ErrNoISBN10},
+		{"9784101010015", "", ErrInvalid},
+	}
+	for _, tt := range tests {
+		got, err := To10(tt.in)
+		if got != tt.want || !errors.Is(err, tt.err) {
+			t.Errorf("To10(%q) = %q, %v; want %q, %v", tt.in, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestTo10And13RoundTrip(t *testing.T) {
+	for _, code := range []string{"4101010013", "080442957X", "4062748681", "0306406152"} {
+		isbn13, err := To13(code)
+		if err != nil {
+			t.Fatalf("To13(%q): %v", code, err)
+		}
+		back, err := To10(isbn13)
+		if err != nil || back != code {
+			t.Errorf("To10(To13(%q)) = %q, %v", code, back, err)
+		}
+	}
+}
+
+func TestEqual(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"4101010013", "9784101010014", true},
+		{"978-4-10-101001-4", "9784101010014", true},
+		{"080442957x", "9780804429573", true},
+		{"9784101010014", "9784062748681", false},
+		{"9784101010015", "9784101010015", false}, // 正しくない ISBN 同士は同じとみなさない
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		if got := Equal(tt.a, tt.b); got != tt.want {
+			t.Errorf("Equal(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
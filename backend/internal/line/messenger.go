@@ -0,0 +1,107 @@
+// Package line は LINE Messaging API への送信と、LINE ログインのプロフィール取得。
+// LINE_MESSENGER=console なら LINE には送らずにログに出すので、チャネルのトークンなしで cron の流れを一通り試せる
+package line
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"tundoku-killer/backend/internal/config"
+)
+
+// Messenger は LINE の push で messages を to (ユーザーIDかグループID) に送る
+type Messenger interface {
+	Push(ctx context.Context, to string, messages []interface{}) error
+}
+
+// NewMessenger は LINE_MESSENGER に応じた Messenger を返す。client は LINE API の呼び出しに使う。
+// token はチャネルのアクセストークンを返す関数で、回転したトークンを送るたびに読み直せる。nil なら設定の値をそのまま使う
+func NewMessenger(c config.LINEConfig, token func() string, client *http.Client, logger *log.Logger) Messenger {
+	if c.Messenger == "console" {
+		logger.Printf("LINE_MESSENGER=console; LINE messages will be logged instead of sent")
+		return Console{Logger: logger}
+	}
+	if token == nil {
+		accessToken := c.ChannelAccessToken
+		token = func() string { return accessToken }
+	}
+	return apiMessenger{accessToken: token, client: client}
+}
+
+// apiMessenger は LINE Messaging API の push で送る
+type apiMessenger struct {
+	accessToken func() string
+	client      *http.Client
+}
+
+func (m apiMessenger) Push(ctx context.Context, to string, messages []interface{}) error {
+	url := "https://api.line.me/v2/bot/message/push"
+
+	requestBody, _ := json.Marshal(map[string]interface{}{
+		"to":       to,
+		"messages": messages,
+	})
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(requestBody))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+m.accessToken())
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("LINE API error: %s", string(body))
+	}
+
+	return nil
+}
+
+// Console は送る代わりにログに出す Messenger (ローカル開発用)
+type Console struct {
+	Logger *log.Logger
+}
+
+func (c Console) Push(_ context.Context, to string, messages []interface{}) error {
+	c.Logger.Printf("[LINE console] to %s:\n%s", to, Text(messages))
+	return nil
+}
+
+// Text は LINE のメッセージを平文にする (メールの本文やログ用)。
+// テキストはそのまま、Flex Message は代替テキスト、画像は URL にする
+func Text(messages []interface{}) string {
+	var parts []string
+	for _, m := range messages {
+		msg, ok := m.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		switch msg["type"] {
+		case "text":
+			if text, ok := msg["text"].(string); ok {
+				parts = append(parts, text)
+			}
+		case "flex":
+			if alt, ok := msg["altText"].(string); ok {
+				parts = append(parts, alt)
+			}
+		case "image":
+			if u, ok := msg["originalContentUrl"].(string); ok {
+				parts = append(parts, u)
+			}
+		}
+	}
+	return strings.Join(parts, "\n\n")
+}
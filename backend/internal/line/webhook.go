@@ -0,0 +1,61 @@
+package line
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+)
+
+// WebhookRequest は LINE プラットフォームから Webhook で届くリクエストの本文
+type WebhookRequest struct {
+	Destination string         `json:"destination"`
+	Events      []WebhookEvent `json:"events"`
+}
+
+// WebhookEvent は Webhook のイベント1件。使う項目だけを読む
+type WebhookEvent struct {
+	Type       string `json:"type"` // "message"・"postback"・"follow" など
+	ReplyToken string `json:"replyToken"`
+	Timestamp  int64  `json:"timestamp"` // ミリ秒
+	Source     struct {
+		Type   string `json:"type"` // "user"・"group"・"room"
+		UserID string `json:"userId"`
+	} `json:"source"`
+	Postback struct {
+		Data string `json:"data"`
+	} `json:"postback"`
+}
+
+// VerifySignature は X-Line-Signature がチャネルシークレットで body に付けた署名と一致するかを返す
+func VerifySignature(channelSecret string, body []byte, signature string) bool {
+	if channelSecret == "" || signature == "" {
+		return false
+	}
+	want, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(channelSecret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), want)
+}
+
+// PostbackAction はタップすると data を Webhook の postback で送り返すアクション。
+// displayText はタップしたときにユーザーの発言としてトークに表示する
+func PostbackAction(label, data, displayText string) map[string]interface{} {
+	return map[string]interface{}{
+		"type":        "postback",
+		"label":       label,
+		"data":        data,
+		"displayText": displayText,
+	}
+}
+
+// QuickReply は actions をクイックリプライのボタンにする。メッセージの "quickReply" に入れる
+func QuickReply(actions ...map[string]interface{}) map[string]interface{} {
+	items := make([]map[string]interface{}, len(actions))
+	for i, a := range actions {
+		items[i] = map[string]interface{}{"type": "action", "action": a}
+	}
+	return map[string]interface{}{"items": items}
+}
@@ -0,0 +1,43 @@
+package migrations
+
+import (
+	"context"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+)
+
+// All は登録済みのマイグレーション。番号は一度使ったら変えない・再利用しない
+var All = []Migration{
+	{Version: 1, Name: "backfill-book-id", Up: backfillBookID},
+	{Version: 2, Name: "normalize-status", Up: normalizeStatus},
+}
+
+// backfillBookID は bookId が無いか、ドキュメントIDと食い違っている本に、ドキュメントIDを書く
+func backfillBookID(ctx context.Context, env Env) (int, error) {
+	return updateBooks(ctx, env, func(doc *firestore.DocumentSnapshot) []firestore.Update {
+		if bookID, _ := doc.Data()["bookId"].(string); bookID == doc.Ref.ID {
+			return nil
+		}
+		return []firestore.Update{{Path: "bookId", Value: doc.Ref.ID}}
+	})
+}
+
+// knownStatuses は正規化の結果として書いてよいステータス
+var knownStatuses = map[string]bool{"unread": true, "reading": true, "completed": true, "insulted": true, "abandoned": true}
+
+// normalizeStatus は前後の空白や大文字の混じったステータス ("Completed " など) を小文字にそろえる。
+// そろえても既知の値にならないものは触らない (整合性チェックで報告する)
+func normalizeStatus(ctx context.Context, env Env) (int, error) {
+	return updateBooks(ctx, env, func(doc *firestore.DocumentSnapshot) []firestore.Update {
+		status, ok := doc.Data()["status"].(string)
+		if !ok {
+			return nil
+		}
+		normalized := strings.ToLower(strings.TrimSpace(status))
+		if normalized == status || !knownStatuses[normalized] {
+			return nil
+		}
+		return []firestore.Update{{Path: "status", Value: normalized}}
+	})
+}
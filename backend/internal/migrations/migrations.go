@@ -0,0 +1,188 @@
+// Package migrations は Firestore のデータのマイグレーション (バックフィル)。
+// スキーマを持たない Firestore で項目の追加や値の正規化をするときは、使い捨てのスクリプトではなくここに番号付きで足す。
+// 適用した番号は dataMigrations/{version} に記録し、未適用のものだけを番号の順に1度ずつ適用する
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+)
+
+// collection は適用済みのマイグレーションの記録を置くコレクション
+const collection = "dataMigrations"
+
+// Env はマイグレーションに渡す依存
+type Env struct {
+	Client *firestore.Client
+	// BookChanged は本のドキュメントを直接書き換えたあとに呼ぶ。キャッシュや本棚のバージョンの更新に使う (nil 可)
+	BookChanged func(ctx context.Context, userID, bookID string)
+}
+
+func (e Env) bookChanged(ctx context.Context, userID, bookID string) {
+	if e.BookChanged != nil && userID != "" {
+		e.BookChanged(ctx, userID, bookID)
+	}
+}
+
+// Migration は番号付きのデータのマイグレーション
+type Migration struct {
+	Version int
+	Name    string
+	// Up はデータを書き換え、書き換えたドキュメントの数を返す。途中で失敗しても、もう一度実行すれば続きから正しく直るように書く
+	Up func(ctx context.Context, env Env) (int, error)
+}
+
+// Record は適用済みのマイグレーションの記録
+type Record struct {
+	Version   int       `json:"version" firestore:"version"`
+	Name      string    `json:"name" firestore:"name"`
+	Changed   int       `json:"changed" firestore:"changed"` // 書き換えたドキュメントの数
+	AppliedAt time.Time `json:"appliedAt" firestore:"appliedAt"`
+	Duration  string    `json:"duration" firestore:"duration"`
+}
+
+// Status はマイグレーション1つの適用状況
+type Status struct {
+	Version int     `json:"version"`
+	Name    string  `json:"name"`
+	Applied bool    `json:"applied"`
+	Record  *Record `json:"record,omitempty"`
+}
+
+// Runner は Migrations を適用する
+type Runner struct {
+	Env        Env
+	Logger     *log.Logger
+	Migrations []Migration // 通常は All
+}
+
+// Status はすべてのマイグレーションの適用状況を番号の順に返す
+func (r Runner) Status(ctx context.Context) ([]Status, error) {
+	applied, err := r.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	migrations := r.sorted()
+	statuses := make([]Status, len(migrations))
+	for i, m := range migrations {
+		statuses[i] = Status{Version: m.Version, Name: m.Name}
+		if rec, ok := applied[m.Version]; ok {
+			statuses[i].Applied = true
+			statuses[i].Record = &rec
+		}
+	}
+	return statuses, nil
+}
+
+// Up は未適用のマイグレーションを番号の順に適用し、適用したものの記録を返す。
+// 失敗したらそこで止め、それまでに適用した分の記録とエラーを返す
+func (r Runner) Up(ctx context.Context) ([]Record, error) {
+	applied, err := r.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []Record
+	for _, m := range r.sorted() {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		start := time.Now()
+		changed, err := m.Up(ctx, r.Env)
+		if err != nil {
+			return records, fmt.Errorf("error applying migration %d (%s): %w", m.Version, m.Name, err)
+		}
+		rec := Record{
+			Version:   m.Version,
+			Name:      m.Name,
+			Changed:   changed,
+			AppliedAt: time.Now(),
+			Duration:  time.Since(start).Round(time.Millisecond).String(),
+		}
+		if _, err := r.Env.Client.Collection(collection).Doc(strconv.Itoa(m.Version)).Set(ctx, rec); err != nil {
+			return records, fmt.Errorf("error recording migration %d: %w", m.Version, err)
+		}
+		r.Logger.Printf("Migration %d (%s) applied: %d documents changed in %s", m.Version, m.Name, changed, rec.Duration)
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+func (r Runner) applied(ctx context.Context) (map[int]Record, error) {
+	docs, err := r.Env.Client.Collection(collection).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", collection, err)
+	}
+	applied := make(map[int]Record, len(docs))
+	for _, doc := range docs {
+		var rec Record
+		if err := doc.DataTo(&rec); err != nil {
+			return nil, fmt.Errorf("error parsing %s/%s: %w", collection, doc.Ref.ID, err)
+		}
+		applied[rec.Version] = rec
+	}
+	return applied, nil
+}
+
+func (r Runner) sorted() []Migration {
+	migrations := append([]Migration(nil), r.Migrations...)
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations
+}
+
+// updateBooks はすべての本のドキュメントに fix を呼び、返された更新をまとめて書き込む。書き換えた数を返す
+func updateBooks(ctx context.Context, env Env, fix func(doc *firestore.DocumentSnapshot) []firestore.Update) (int, error) {
+	iter := env.Client.Collection("books").Documents(ctx)
+	defer iter.Stop()
+
+	type change struct {
+		job    *firestore.BulkWriterJob
+		userID string
+		bookID string
+	}
+	bw := env.Client.BulkWriter(ctx)
+	var changes []change
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			bw.End()
+			return 0, err
+		}
+		updates := fix(doc)
+		if len(updates) == 0 {
+			continue
+		}
+		job, err := bw.Update(doc.Ref, updates)
+		if err != nil {
+			bw.End()
+			return 0, err
+		}
+		userID, _ := doc.Data()["userId"].(string)
+		changes = append(changes, change{job: job, userID: userID, bookID: doc.Ref.ID})
+	}
+	bw.End()
+
+	changed := 0
+	var firstErr error
+	for _, c := range changes {
+		if _, err := c.job.Results(); err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("error updating book %s: %w", c.bookID, err)
+			}
+			continue
+		}
+		env.bookChanged(ctx, c.userID, c.bookID)
+		changed++
+	}
+	return changed, firstErr
+}
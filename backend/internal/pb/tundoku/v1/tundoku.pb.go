@@ -0,0 +1,667 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: tundoku/v1/tundoku.proto
+
+package tundokuv1
+
+import (
+	_ "google.golang.org/genproto/googleapis/api/annotations"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// Book は登録された本
+type Book struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	BookId   string                 `protobuf:"bytes,1,opt,name=book_id,json=bookId,proto3" json:"book_id,omitempty"`
+	UserId   string                 `protobuf:"bytes,2,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	Title    string                 `protobuf:"bytes,3,opt,name=title,proto3" json:"title,omitempty"`
+	Author   string                 `protobuf:"bytes,4,opt,name=author,proto3" json:"author,omitempty"`
+	Deadline *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=deadline,proto3" json:"deadline,omitempty"`
+	// "unread", "reading", "completed", "insulted", "abandoned"
+	Status      string `protobuf:"bytes,6,opt,name=status,proto3" json:"status,omitempty"`
+	InsultLevel int32  `protobuf:"varint,7,opt,name=insult_level,json=insultLevel,proto3" json:"insult_level,omitempty"`
+	// 最後に煽った周期 (JSTの日付 "2006-01-02")
+	LastInsultCycle string `protobuf:"bytes,8,opt,name=last_insult_cycle,json=lastInsultCycle,proto3" json:"last_insult_cycle,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *Book) Reset() {
+	*x = Book{}
+	mi := &file_tundoku_v1_tundoku_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Book) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Book) ProtoMessage() {}
+
+func (x *Book) ProtoReflect() protoreflect.Message {
+	mi := &file_tundoku_v1_tundoku_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Book.ProtoReflect.Descriptor instead.
+func (*Book) Descriptor() ([]byte, []int) {
+	return file_tundoku_v1_tundoku_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Book) GetBookId() string {
+	if x != nil {
+		return x.BookId
+	}
+	return ""
+}
+
+func (x *Book) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+func (x *Book) GetTitle() string {
+	if x != nil {
+		return x.Title
+	}
+	return ""
+}
+
+func (x *Book) GetAuthor() string {
+	if x != nil {
+		return x.Author
+	}
+	return ""
+}
+
+func (x *Book) GetDeadline() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Deadline
+	}
+	return nil
+}
+
+func (x *Book) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *Book) GetInsultLevel() int32 {
+	if x != nil {
+		return x.InsultLevel
+	}
+	return 0
+}
+
+func (x *Book) GetLastInsultCycle() string {
+	if x != nil {
+		return x.LastInsultCycle
+	}
+	return ""
+}
+
+type ListBooksRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	UserId        string                 `protobuf:"bytes,1,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListBooksRequest) Reset() {
+	*x = ListBooksRequest{}
+	mi := &file_tundoku_v1_tundoku_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListBooksRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListBooksRequest) ProtoMessage() {}
+
+func (x *ListBooksRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tundoku_v1_tundoku_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListBooksRequest.ProtoReflect.Descriptor instead.
+func (*ListBooksRequest) Descriptor() ([]byte, []int) {
+	return file_tundoku_v1_tundoku_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ListBooksRequest) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+type ListBooksResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Books         []*Book                `protobuf:"bytes,1,rep,name=books,proto3" json:"books,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListBooksResponse) Reset() {
+	*x = ListBooksResponse{}
+	mi := &file_tundoku_v1_tundoku_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListBooksResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListBooksResponse) ProtoMessage() {}
+
+func (x *ListBooksResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_tundoku_v1_tundoku_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListBooksResponse.ProtoReflect.Descriptor instead.
+func (*ListBooksResponse) Descriptor() ([]byte, []int) {
+	return file_tundoku_v1_tundoku_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ListBooksResponse) GetBooks() []*Book {
+	if x != nil {
+		return x.Books
+	}
+	return nil
+}
+
+type RegisterBookRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	UserId        string                 `protobuf:"bytes,1,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	Book          *Book                  `protobuf:"bytes,2,opt,name=book,proto3" json:"book,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RegisterBookRequest) Reset() {
+	*x = RegisterBookRequest{}
+	mi := &file_tundoku_v1_tundoku_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RegisterBookRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RegisterBookRequest) ProtoMessage() {}
+
+func (x *RegisterBookRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tundoku_v1_tundoku_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RegisterBookRequest.ProtoReflect.Descriptor instead.
+func (*RegisterBookRequest) Descriptor() ([]byte, []int) {
+	return file_tundoku_v1_tundoku_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *RegisterBookRequest) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+func (x *RegisterBookRequest) GetBook() *Book {
+	if x != nil {
+		return x.Book
+	}
+	return nil
+}
+
+type UpdateBookRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Book          *Book                  `protobuf:"bytes,1,opt,name=book,proto3" json:"book,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UpdateBookRequest) Reset() {
+	*x = UpdateBookRequest{}
+	mi := &file_tundoku_v1_tundoku_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UpdateBookRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UpdateBookRequest) ProtoMessage() {}
+
+func (x *UpdateBookRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tundoku_v1_tundoku_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UpdateBookRequest.ProtoReflect.Descriptor instead.
+func (*UpdateBookRequest) Descriptor() ([]byte, []int) {
+	return file_tundoku_v1_tundoku_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *UpdateBookRequest) GetBook() *Book {
+	if x != nil {
+		return x.Book
+	}
+	return nil
+}
+
+type DeleteBookRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	BookId        string                 `protobuf:"bytes,1,opt,name=book_id,json=bookId,proto3" json:"book_id,omitempty"`
+	UserId        string                 `protobuf:"bytes,2,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteBookRequest) Reset() {
+	*x = DeleteBookRequest{}
+	mi := &file_tundoku_v1_tundoku_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteBookRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteBookRequest) ProtoMessage() {}
+
+func (x *DeleteBookRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tundoku_v1_tundoku_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteBookRequest.ProtoReflect.Descriptor instead.
+func (*DeleteBookRequest) Descriptor() ([]byte, []int) {
+	return file_tundoku_v1_tundoku_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *DeleteBookRequest) GetBookId() string {
+	if x != nil {
+		return x.BookId
+	}
+	return ""
+}
+
+func (x *DeleteBookRequest) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+type CompleteBookRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	BookId        string                 `protobuf:"bytes,1,opt,name=book_id,json=bookId,proto3" json:"book_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CompleteBookRequest) Reset() {
+	*x = CompleteBookRequest{}
+	mi := &file_tundoku_v1_tundoku_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CompleteBookRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CompleteBookRequest) ProtoMessage() {}
+
+func (x *CompleteBookRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tundoku_v1_tundoku_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CompleteBookRequest.ProtoReflect.Descriptor instead.
+func (*CompleteBookRequest) Descriptor() ([]byte, []int) {
+	return file_tundoku_v1_tundoku_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *CompleteBookRequest) GetBookId() string {
+	if x != nil {
+		return x.BookId
+	}
+	return ""
+}
+
+type ListOverdueBooksRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListOverdueBooksRequest) Reset() {
+	*x = ListOverdueBooksRequest{}
+	mi := &file_tundoku_v1_tundoku_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListOverdueBooksRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListOverdueBooksRequest) ProtoMessage() {}
+
+func (x *ListOverdueBooksRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tundoku_v1_tundoku_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListOverdueBooksRequest.ProtoReflect.Descriptor instead.
+func (*ListOverdueBooksRequest) Descriptor() ([]byte, []int) {
+	return file_tundoku_v1_tundoku_proto_rawDescGZIP(), []int{7}
+}
+
+type OverdueBook struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Book  *Book                  `protobuf:"bytes,1,opt,name=book,proto3" json:"book,omitempty"`
+	// 送信される煽り文のプレビュー
+	Message       string `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *OverdueBook) Reset() {
+	*x = OverdueBook{}
+	mi := &file_tundoku_v1_tundoku_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *OverdueBook) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*OverdueBook) ProtoMessage() {}
+
+func (x *OverdueBook) ProtoReflect() protoreflect.Message {
+	mi := &file_tundoku_v1_tundoku_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use OverdueBook.ProtoReflect.Descriptor instead.
+func (*OverdueBook) Descriptor() ([]byte, []int) {
+	return file_tundoku_v1_tundoku_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *OverdueBook) GetBook() *Book {
+	if x != nil {
+		return x.Book
+	}
+	return nil
+}
+
+func (x *OverdueBook) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+type SendInsultRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	BookId        string                 `protobuf:"bytes,1,opt,name=book_id,json=bookId,proto3" json:"book_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SendInsultRequest) Reset() {
+	*x = SendInsultRequest{}
+	mi := &file_tundoku_v1_tundoku_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SendInsultRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SendInsultRequest) ProtoMessage() {}
+
+func (x *SendInsultRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tundoku_v1_tundoku_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SendInsultRequest.ProtoReflect.Descriptor instead.
+func (*SendInsultRequest) Descriptor() ([]byte, []int) {
+	return file_tundoku_v1_tundoku_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *SendInsultRequest) GetBookId() string {
+	if x != nil {
+		return x.BookId
+	}
+	return ""
+}
+
+var File_tundoku_v1_tundoku_proto protoreflect.FileDescriptor
+
+const file_tundoku_v1_tundoku_proto_rawDesc = "" +
+	"\n" +
+	"\x18tundoku/v1/tundoku.proto\x12\n" +
+	"tundoku.v1\x1a\x1cgoogle/api/annotations.proto\x1a\x1bgoogle/protobuf/empty.proto\x1a\x1fgoogle/protobuf/timestamp.proto\"\x85\x02\n" +
+	"\x04Book\x12\x17\n" +
+	"\abook_id\x18\x01 \x01(\tR\x06bookId\x12\x17\n" +
+	"\auser_id\x18\x02 \x01(\tR\x06userId\x12\x14\n" +
+	"\x05title\x18\x03 \x01(\tR\x05title\x12\x16\n" +
+	"\x06author\x18\x04 \x01(\tR\x06author\x126\n" +
+	"\bdeadline\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\bdeadline\x12\x16\n" +
+	"\x06status\x18\x06 \x01(\tR\x06status\x12!\n" +
+	"\finsult_level\x18\a \x01(\x05R\vinsultLevel\x12*\n" +
+	"\x11last_insult_cycle\x18\b \x01(\tR\x0flastInsultCycle\"+\n" +
+	"\x10ListBooksRequest\x12\x17\n" +
+	"\auser_id\x18\x01 \x01(\tR\x06userId\";\n" +
+	"\x11ListBooksResponse\x12&\n" +
+	"\x05books\x18\x01 \x03(\v2\x10.tundoku.v1.BookR\x05books\"T\n" +
+	"\x13RegisterBookRequest\x12\x17\n" +
+	"\auser_id\x18\x01 \x01(\tR\x06userId\x12$\n" +
+	"\x04book\x18\x02 \x01(\v2\x10.tundoku.v1.BookR\x04book\"9\n" +
+	"\x11UpdateBookRequest\x12$\n" +
+	"\x04book\x18\x01 \x01(\v2\x10.tundoku.v1.BookR\x04book\"E\n" +
+	"\x11DeleteBookRequest\x12\x17\n" +
+	"\abook_id\x18\x01 \x01(\tR\x06bookId\x12\x17\n" +
+	"\auser_id\x18\x02 \x01(\tR\x06userId\".\n" +
+	"\x13CompleteBookRequest\x12\x17\n" +
+	"\abook_id\x18\x01 \x01(\tR\x06bookId\"\x19\n" +
+	"\x17ListOverdueBooksRequest\"M\n" +
+	"\vOverdueBook\x12$\n" +
+	"\x04book\x18\x01 \x01(\v2\x10.tundoku.v1.BookR\x04book\x12\x18\n" +
+	"\amessage\x18\x02 \x01(\tR\amessage\",\n" +
+	"\x11SendInsultRequest\x12\x17\n" +
+	"\abook_id\x18\x01 \x01(\tR\x06bookId2\xa1\x04\n" +
+	"\vBookService\x12k\n" +
+	"\tListBooks\x12\x1c.tundoku.v1.ListBooksRequest\x1a\x1d.tundoku.v1.ListBooksResponse\"!\x82\xd3\xe4\x93\x02\x1b\x12\x19/v1/users/{user_id}/books\x12j\n" +
+	"\fRegisterBook\x12\x1f.tundoku.v1.RegisterBookRequest\x1a\x10.tundoku.v1.Book\"'\x82\xd3\xe4\x93\x02!:\x04book\"\x19/v1/users/{user_id}/books\x12e\n" +
+	"\n" +
+	"UpdateBook\x12\x1d.tundoku.v1.UpdateBookRequest\x1a\x10.tundoku.v1.Book\"&\x82\xd3\xe4\x93\x02 :\x04book\x1a\x18/v1/books/{book.book_id}\x12`\n" +
+	"\n" +
+	"DeleteBook\x12\x1d.tundoku.v1.DeleteBookRequest\x1a\x16.google.protobuf.Empty\"\x1b\x82\xd3\xe4\x93\x02\x15*\x13/v1/books/{book_id}\x12p\n" +
+	"\fCompleteBook\x12\x1f.tundoku.v1.CompleteBookRequest\x1a\x16.google.protobuf.Empty\"'\x82\xd3\xe4\x93\x02!:\x01*\"\x1c/v1/books/{book_id}:complete2\xae\x01\n" +
+	"\x13NotificationService\x12R\n" +
+	"\x10ListOverdueBooks\x12#.tundoku.v1.ListOverdueBooksRequest\x1a\x17.tundoku.v1.OverdueBook0\x01\x12C\n" +
+	"\n" +
+	"SendInsult\x12\x1d.tundoku.v1.SendInsultRequest\x1a\x16.google.protobuf.EmptyB9Z7tundoku-killer/backend/internal/pb/tundoku/v1;tundokuv1b\x06proto3"
+
+var (
+	file_tundoku_v1_tundoku_proto_rawDescOnce sync.Once
+	file_tundoku_v1_tundoku_proto_rawDescData []byte
+)
+
+func file_tundoku_v1_tundoku_proto_rawDescGZIP() []byte {
+	file_tundoku_v1_tundoku_proto_rawDescOnce.Do(func() {
+		file_tundoku_v1_tundoku_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_tundoku_v1_tundoku_proto_rawDesc), len(file_tundoku_v1_tundoku_proto_rawDesc)))
+	})
+	return file_tundoku_v1_tundoku_proto_rawDescData
+}
+
+var file_tundoku_v1_tundoku_proto_msgTypes = make([]protoimpl.MessageInfo, 10)
+var file_tundoku_v1_tundoku_proto_goTypes = []any{
+	(*Book)(nil),                    // 0: tundoku.v1.Book
+	(*ListBooksRequest)(nil),        // 1: tundoku.v1.ListBooksRequest
+	(*ListBooksResponse)(nil),       // 2: tundoku.v1.ListBooksResponse
+	(*RegisterBookRequest)(nil),     // 3: tundoku.v1.RegisterBookRequest
+	(*UpdateBookRequest)(nil),       // 4: tundoku.v1.UpdateBookRequest
+	(*DeleteBookRequest)(nil),       // 5: tundoku.v1.DeleteBookRequest
+	(*CompleteBookRequest)(nil),     // 6: tundoku.v1.CompleteBookRequest
+	(*ListOverdueBooksRequest)(nil), // 7: tundoku.v1.ListOverdueBooksRequest
+	(*OverdueBook)(nil),             // 8: tundoku.v1.OverdueBook
+	(*SendInsultRequest)(nil),       // 9: tundoku.v1.SendInsultRequest
+	(*timestamppb.Timestamp)(nil),   // 10: google.protobuf.Timestamp
+	(*emptypb.Empty)(nil),           // 11: google.protobuf.Empty
+}
+var file_tundoku_v1_tundoku_proto_depIdxs = []int32{
+	10, // 0: tundoku.v1.Book.deadline:type_name -> google.protobuf.Timestamp
+	0,  // 1: tundoku.v1.ListBooksResponse.books:type_name -> tundoku.v1.Book
+	0,  // 2: tundoku.v1.RegisterBookRequest.book:type_name -> tundoku.v1.Book
+	0,  // 3: tundoku.v1.UpdateBookRequest.book:type_name -> tundoku.v1.Book
+	0,  // 4: tundoku.v1.OverdueBook.book:type_name -> tundoku.v1.Book
+	1,  // 5: tundoku.v1.BookService.ListBooks:input_type -> tundoku.v1.ListBooksRequest
+	3,  // 6: tundoku.v1.BookService.RegisterBook:input_type -> tundoku.v1.RegisterBookRequest
+	4,  // 7: tundoku.v1.BookService.UpdateBook:input_type -> tundoku.v1.UpdateBookRequest
+	5,  // 8: tundoku.v1.BookService.DeleteBook:input_type -> tundoku.v1.DeleteBookRequest
+	6,  // 9: tundoku.v1.BookService.CompleteBook:input_type -> tundoku.v1.CompleteBookRequest
+	7,  // 10: tundoku.v1.NotificationService.ListOverdueBooks:input_type -> tundoku.v1.ListOverdueBooksRequest
+	9,  // 11: tundoku.v1.NotificationService.SendInsult:input_type -> tundoku.v1.SendInsultRequest
+	2,  // 12: tundoku.v1.BookService.ListBooks:output_type -> tundoku.v1.ListBooksResponse
+	0,  // 13: tundoku.v1.BookService.RegisterBook:output_type -> tundoku.v1.Book
+	0,  // 14: tundoku.v1.BookService.UpdateBook:output_type -> tundoku.v1.Book
+	11, // 15: tundoku.v1.BookService.DeleteBook:output_type -> google.protobuf.Empty
+	11, // 16: tundoku.v1.BookService.CompleteBook:output_type -> google.protobuf.Empty
+	8,  // 17: tundoku.v1.NotificationService.ListOverdueBooks:output_type -> tundoku.v1.OverdueBook
+	11, // 18: tundoku.v1.NotificationService.SendInsult:output_type -> google.protobuf.Empty
+	12, // [12:19] is the sub-list for method output_type
+	5,  // [5:12] is the sub-list for method input_type
+	5,  // [5:5] is the sub-list for extension type_name
+	5,  // [5:5] is the sub-list for extension extendee
+	0,  // [0:5] is the sub-list for field type_name
+}
+
+func init() { file_tundoku_v1_tundoku_proto_init() }
+func file_tundoku_v1_tundoku_proto_init() {
+	if File_tundoku_v1_tundoku_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_tundoku_v1_tundoku_proto_rawDesc), len(file_tundoku_v1_tundoku_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   10,
+			NumExtensions: 0,
+			NumServices:   2,
+		},
+		GoTypes:           file_tundoku_v1_tundoku_proto_goTypes,
+		DependencyIndexes: file_tundoku_v1_tundoku_proto_depIdxs,
+		MessageInfos:      file_tundoku_v1_tundoku_proto_msgTypes,
+	}.Build()
+	File_tundoku_v1_tundoku_proto = out.File
+	file_tundoku_v1_tundoku_proto_goTypes = nil
+	file_tundoku_v1_tundoku_proto_depIdxs = nil
+}
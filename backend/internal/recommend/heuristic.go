@@ -0,0 +1,87 @@
+package recommend
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"tundoku-killer/backend/internal/store"
+)
+
+// Heuristic の重み
+const (
+	authorWeight = 2.0 // 好きな著者の本
+	tagWeight    = 1.0 // 好きなタグの本 (タグごと)
+	readingBonus = 1.5 // 読みかけの本
+	overdueBonus = 1.0 // 期限切れの本
+	// deadlineHorizon より期限が近い本は、近いほど点を足す (最大 1)
+	deadlineHorizon = 14 * 24 * time.Hour
+)
+
+// Heuristic は好きな著者・タグの本、読みかけの本、期限の近い本を上にする Ranker。
+// 同じ点なら期限の近い順 (期限のない本は後)
+type Heuristic struct{}
+
+func (Heuristic) Rank(_ context.Context, history []store.Book, candidates []store.Book, now time.Time) ([]Recommendation, error) {
+	profile := NewProfile(history)
+	recs := make([]Recommendation, 0, len(candidates))
+	for _, book := range candidates {
+		recs = append(recs, score(profile, book, now))
+	}
+	sort.SliceStable(recs, func(i, j int) bool {
+		if recs[i].Score != recs[j].Score {
+			return recs[i].Score > recs[j].Score
+		}
+		di, dj := recs[i].Book.Deadline, recs[j].Book.Deadline
+		if di.IsZero() != dj.IsZero() {
+			return dj.IsZero()
+		}
+		return di.Before(dj)
+	})
+	return recs, nil
+}
+
+// score は profile から見た book の点と、その理由を返す
+func score(profile Profile, book store.Book, now time.Time) Recommendation {
+	rec := Recommendation{Book: book, Reasons: []string{}}
+
+	author := Key(book.Author)
+	if weight := profile.Authors[author]; weight != 0 {
+		rec.Score += authorWeight * weight
+		if n := profile.Completed[author]; weight > 0 && n > 0 {
+			rec.Reasons = append(rec.Reasons, fmt.Sprintf("%sの本を%d冊読み終えています", book.Author, n))
+		}
+	}
+
+	bestTag, bestWeight := "", 0.0
+	for _, tag := range book.Tags {
+		weight := profile.Tags[Key(tag)]
+		rec.Score += tagWeight * weight
+		if weight > bestWeight {
+			bestTag, bestWeight = tag, weight
+		}
+	}
+	if bestTag != "" {
+		rec.Reasons = append(rec.Reasons, fmt.Sprintf("よく読み終える「%s」の本です", bestTag))
+	}
+
+	if book.Status == "reading" {
+		rec.Score += readingBonus
+		rec.Reasons = append(rec.Reasons, "読みかけです")
+	}
+	if !book.Deadline.IsZero() && book.Status != "completed" {
+		left := book.Deadline.Sub(now)
+		switch {
+		case left < 0:
+			rec.Score += overdueBonus
+			rec.Reasons = append(rec.Reasons, "期限を過ぎています")
+		case left < deadlineHorizon:
+			rec.Score += 1 - float64(left)/float64(deadlineHorizon)
+			rec.Reasons = append(rec.Reasons, fmt.Sprintf("期限まであと%d日です", int(math.Ceil(left.Hours()/24))))
+		}
+	}
+	rec.Score = math.Round(rec.Score*100) / 100
+	return rec
+}
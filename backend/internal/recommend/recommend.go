@@ -0,0 +1,98 @@
+// Package recommend は次に読む本の推薦。読み終えた本と諦めた本の著者・タグ・評価から好み (Profile) を数え、
+// 候補の本に点を付けて並べる。いまは決まった重みで数える Heuristic だけだが、Ranker を差し替えれば
+// 埋め込みで似た本を探すような別の方法にできる
+package recommend
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"tundoku-killer/backend/internal/store"
+)
+
+// Ranker は候補の本に点を付け、点の高い順に並べる
+type Ranker interface {
+	Rank(ctx context.Context, history []store.Book, candidates []store.Book, now time.Time) ([]Recommendation, error)
+}
+
+// Recommendation は推薦する本1冊
+type Recommendation struct {
+	Book    store.Book `json:"book"`
+	Score   float64    `json:"score"`
+	Reasons []string   `json:"reasons"` // 推薦する理由 (表示用)
+}
+
+// Profile は読み終えた本と諦めた本から数えた好み。著者とタグは Key で正規化して数える
+type Profile struct {
+	Authors   map[string]float64 // 著者ごとの重み。読み終えた本で増え、諦めた本で減る
+	Tags      map[string]float64 // タグごとの重み
+	Completed map[string]int     // 著者ごとの読み終えた本の数
+	names     map[string]string  // 著者の表示名
+}
+
+// NewProfile は history (本棚のすべての本) から好みを数える。読み終えた本は評価が高いほど重く、
+// 星1・2なら軽くし、諦めた本は減らす
+func NewProfile(history []store.Book) Profile {
+	p := Profile{
+		Authors:   make(map[string]float64),
+		Tags:      make(map[string]float64),
+		Completed: make(map[string]int),
+		names:     make(map[string]string),
+	}
+	for _, book := range history {
+		var weight float64
+		switch {
+		case book.Status == "completed":
+			weight = 1
+			if book.Rating > 0 {
+				weight += float64(book.Rating-3) / 2
+			}
+		case book.Abandoned():
+			weight = -1
+		default:
+			continue
+		}
+		author := Key(book.Author)
+		if author != "" {
+			p.Authors[author] += weight
+			p.names[author] = book.Author
+			if book.Status == "completed" {
+				p.Completed[author]++
+			}
+		}
+		for _, tag := range book.Tags {
+			if tag := Key(tag); tag != "" {
+				p.Tags[tag] += weight
+			}
+		}
+	}
+	return p
+}
+
+// TopAuthors は重みの高い順に、好きな著者の表示名を n 人まで返す
+func (p Profile) TopAuthors(n int) []string {
+	var keys []string
+	for key, weight := range p.Authors {
+		if weight > 0 {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if p.Authors[keys[i]] != p.Authors[keys[j]] {
+			return p.Authors[keys[i]] > p.Authors[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	names := make([]string, 0, min(n, len(keys)))
+	for _, key := range keys[:min(n, len(keys))] {
+		names = append(names, p.names[key])
+	}
+	return names
+}
+
+// Key は著者名やタグを比べるために、大文字・小文字と空白の違いをなくす ("村上 春樹" と "村上春樹" を同じにする)
+func Key(s string) string {
+	return strings.ToLower(strings.Join(strings.Fields(s), ""))
+}
@@ -0,0 +1,264 @@
+// Package secrets はサービスアカウントの JSON や LINE のトークンなどの秘密情報を、
+// 環境変数にそのまま書く代わりにマウントされたファイルや Google Secret Manager から読み込む。
+//
+//	LINE_CHANNEL_ACCESS_TOKEN_FILE=/secrets/line/token            # マウントされたファイル
+//	LINE_CHANNEL_ACCESS_TOKEN=sm://line-channel-access-token       # Secret Manager (最新のバージョン)
+//	CRON_SECRET=sm://projects/p/secrets/cron-secret/versions/3     # Secret Manager (リソース名)
+//
+// 読み込んだ値はメモリに置き、Refresh (Watch) のときだけ読み直すので、リクエストごとに Secret Manager は呼ばない
+package secrets
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	secretmanager "google.golang.org/api/secretmanager/v1"
+)
+
+// Prefix は Secret Manager を参照する値の接頭辞
+const Prefix = "sm://"
+
+// fileSuffix を付けた環境変数はファイルのパスとして扱う
+const fileSuffix = "_FILE"
+
+// Store は秘密情報の参照先と、読み込んだ値のキャッシュ
+type Store struct {
+	getenv func(string) string
+	refs   map[string]string // 環境変数名 → ファイルのパス、または Secret Manager のリソース名
+	files  map[string]bool   // refs のうちファイルのもの
+
+	mu     sync.RWMutex
+	values map[string]string
+
+	smOnce sync.Once
+	sm     *secretmanager.Service
+	smErr  error
+}
+
+// Load は environ (通常は os.Environ()) から "_FILE" の付いた変数と "sm://" で始まる値を探し、
+// 参照先をすべて読み込む。読めなかった参照はまとめてエラーにする
+func Load(ctx context.Context, environ []string, getenv func(string) string) (*Store, error) {
+	s := &Store{
+		getenv: getenv,
+		refs:   make(map[string]string),
+		files:  make(map[string]bool),
+		values: make(map[string]string),
+	}
+	project := getenv("GOOGLE_CLOUD_PROJECT")
+	var errs []error
+	for _, kv := range environ {
+		name, value, ok := strings.Cut(kv, "=")
+		if !ok || value == "" {
+			continue
+		}
+		switch {
+		case strings.HasSuffix(name, fileSuffix):
+			target := strings.TrimSuffix(name, fileSuffix)
+			if getenv(target) != "" {
+				errs = append(errs, fmt.Errorf("  %s and %s are both set", target, name))
+				continue
+			}
+			s.refs[target] = value
+			s.files[target] = true
+		case strings.HasPrefix(value, Prefix):
+			resource, err := resourceName(strings.TrimPrefix(value, Prefix), project)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("  %s %v", name, err))
+				continue
+			}
+			s.refs[name] = resource
+		}
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid secret references:\n%w", errors.Join(errs...))
+	}
+	if err := s.Refresh(ctx); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// resourceName は "sm://" 以降を Secret Manager のバージョンのリソース名にする。
+// "name" だけなら GOOGLE_CLOUD_PROJECT の最新のバージョン、"name/versions/3" ならそのバージョン
+func resourceName(ref, project string) (string, error) {
+	if strings.HasPrefix(ref, "projects/") {
+		if !strings.Contains(ref, "/versions/") {
+			ref += "/versions/latest"
+		}
+		return ref, nil
+	}
+	if project == "" {
+		return "", errors.New("needs GOOGLE_CLOUD_PROJECT or a full resource name (projects/{project}/secrets/{secret})")
+	}
+	if !strings.Contains(ref, "/versions/") {
+		ref += "/versions/latest"
+	}
+	return "projects/" + project + "/secrets/" + ref, nil
+}
+
+// Getenv は name の値を返す。ファイルや Secret Manager を参照していれば読み込んだ値、そうでなければ環境変数そのまま。
+// config.Load に os.Getenv の代わりに渡す
+func (s *Store) Getenv(name string) string {
+	if _, ok := s.refs[name]; !ok {
+		return s.getenv(name)
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.values[name]
+}
+
+// Source は name の最新の値を返す関数を返す。値が回転しても、呼ぶたびに Refresh 後の値になる。
+// Store が nil なら nil を返す
+func (s *Store) Source(name string) func() string {
+	if s == nil {
+		return nil
+	}
+	return func() string { return s.Getenv(name) }
+}
+
+// Refresh は参照先をすべて読み直す。読めなかったものは前の値のまま残し、エラーにまとめて返す
+func (s *Store) Refresh(ctx context.Context) error {
+	names := make([]string, 0, len(s.refs))
+	for name := range s.refs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs []error
+	fresh := make(map[string]string, len(names))
+	for _, name := range names {
+		v, err := s.read(ctx, name)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("  %s: %w", name, err))
+			continue
+		}
+		fresh[name] = v
+	}
+
+	s.mu.Lock()
+	for name, v := range fresh {
+		s.values[name] = v
+	}
+	s.mu.Unlock()
+
+	if len(errs) > 0 {
+		return fmt.Errorf("error loading secrets:\n%w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// Watch は interval ごとに Refresh し、値が変わった (回転した) 秘密情報の名前をログに出す。ctx が終わるまで戻らない
+func (s *Store) Watch(ctx context.Context, interval time.Duration, logger *log.Logger) {
+	if len(s.refs) == 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		before := s.snapshot()
+		if err := s.Refresh(ctx); err != nil {
+			logger.Printf("secrets: %v", err)
+		}
+		for name, v := range s.snapshot() {
+			if before[name] != v {
+				logger.Printf("secrets: %s was rotated", name)
+			}
+		}
+	}
+}
+
+func (s *Store) snapshot() map[string]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	m := make(map[string]string, len(s.values))
+	for k, v := range s.values {
+		m[k] = v
+	}
+	return m
+}
+
+// read は name の参照先を1つ読む。ファイル末尾の改行は取り除く
+func (s *Store) read(ctx context.Context, name string) (string, error) {
+	ref := s.refs[name]
+	if s.files[name] {
+		b, err := os.ReadFile(ref)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(b), "\r\n"), nil
+	}
+
+	sm, err := s.secretManager(ctx)
+	if err != nil {
+		return "", err
+	}
+	resp, err := sm.Projects.Secrets.Versions.Access(ref).Context(ctx).Do()
+	if err != nil {
+		return "", fmt.Errorf("error accessing %s: %w", ref, err)
+	}
+	data, err := base64.StdEncoding.DecodeString(resp.Payload.Data)
+	if err != nil {
+		return "", fmt.Errorf("error decoding %s: %w", ref, err)
+	}
+	return string(data), nil
+}
+
+// secretManager は Secret Manager のクライアントを返す。参照があって初めて作る (実行環境の認証情報を使う)
+func (s *Store) secretManager(ctx context.Context) (*secretmanager.Service, error) {
+	s.smOnce.Do(func() {
+		s.sm, s.smErr = secretmanager.NewService(ctx)
+	})
+	if s.smErr != nil {
+		return nil, fmt.Errorf("error initializing Secret Manager: %w", s.smErr)
+	}
+	return s.sm, nil
+}
+
+// ErrNotRotatable は Rotate できない (Secret Manager の最新のバージョンを参照していない) ときのエラー
+var ErrNotRotatable = errors.New("secret does not reference the latest version of a Secret Manager secret")
+
+// Rotate は name が参照している Secret Manager のシークレットに value を新しいバージョンとして追加し、
+// このプロセスではすぐに新しい値を使う。他のプロセスは次の Refresh (Watch) で読み直す。
+// "sm://name" のように最新のバージョンを参照しているときだけ使え、それ以外は ErrNotRotatable
+func (s *Store) Rotate(ctx context.Context, name, value string) error {
+	if s == nil {
+		return ErrNotRotatable
+	}
+	ref, ok := s.refs[name]
+	if !ok || s.files[name] {
+		return ErrNotRotatable
+	}
+	secret, version, _ := strings.Cut(ref, "/versions/")
+	if version != "latest" {
+		return ErrNotRotatable
+	}
+
+	sm, err := s.secretManager(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = sm.Projects.Secrets.AddVersion(secret, &secretmanager.AddSecretVersionRequest{
+		Payload: &secretmanager.SecretPayload{Data: base64.StdEncoding.EncodeToString([]byte(value))},
+	}).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("error adding a version to %s: %w", secret, err)
+	}
+
+	s.mu.Lock()
+	s.values[name] = value
+	s.mu.Unlock()
+	return nil
+}
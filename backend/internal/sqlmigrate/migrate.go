@@ -0,0 +1,134 @@
+// Package sqlmigrate は SQL のストレージのスキーマを、埋め込んだマイグレーションで最新にする
+package sqlmigrate
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// migrations は方言ごとのディレクトリに "0001_説明.sql" の形で置く。番号の順に1度だけ適用する
+//
+//go:embed postgres/*.sql sqlite/*.sql
+var migrations embed.FS
+
+// Dialect は SQL の方言
+type Dialect string
+
+const (
+	Postgres Dialect = "postgres"
+	SQLite   Dialect = "sqlite"
+)
+
+// Up は未適用のマイグレーションを番号の順に適用し、適用した数を返す。
+// 1つのマイグレーションは1つのトランザクションで適用し、schema_migrations に番号を記録する
+func Up(ctx context.Context, db *sql.DB, dialect Dialect) (int, error) {
+	if _, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)`); err != nil {
+		return 0, fmt.Errorf("error creating schema_migrations: %w", err)
+	}
+
+	applied := make(map[int]bool)
+	rows, err := db.QueryContext(ctx, `SELECT version FROM schema_migrations`)
+	if err != nil {
+		return 0, fmt.Errorf("error reading schema_migrations: %w", err)
+	}
+	for rows.Next() {
+		var v int
+		if err := rows.Scan(&v); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		applied[v] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	files, err := fs.Glob(migrations, string(dialect)+"/*.sql")
+	if err != nil {
+		return 0, err
+	}
+	if len(files) == 0 {
+		return 0, fmt.Errorf("no migrations for dialect %q", dialect)
+	}
+	sort.Strings(files)
+
+	count := 0
+	for _, name := range files {
+		version, err := versionOf(name)
+		if err != nil {
+			return count, err
+		}
+		if applied[version] {
+			continue
+		}
+		script, err := migrations.ReadFile(name)
+		if err != nil {
+			return count, err
+		}
+		if err := apply(ctx, db, dialect, version, string(script)); err != nil {
+			return count, fmt.Errorf("error applying %s: %w", name, err)
+		}
+		count++
+	}
+	return count, nil
+}
+
+// apply は1つのマイグレーションを適用する
+func apply(ctx context.Context, db *sql.DB, dialect Dialect, version int, script string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range statements(script) {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	insert := `INSERT INTO schema_migrations (version) VALUES (?)`
+	if dialect == Postgres {
+		insert = `INSERT INTO schema_migrations (version) VALUES ($1)`
+	}
+	if _, err := tx.ExecContext(ctx, insert, version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// statements はスクリプトを ";" で文に分ける。マイグレーションには文字列中の ";" を書かないこと
+func statements(script string) []string {
+	var stmts []string
+	for _, stmt := range strings.Split(script, ";") {
+		var lines []string
+		for _, line := range strings.Split(stmt, "\n") {
+			if i := strings.Index(line, "--"); i >= 0 {
+				line = line[:i]
+			}
+			if strings.TrimSpace(line) != "" {
+				lines = append(lines, line)
+			}
+		}
+		if len(lines) > 0 {
+			stmts = append(stmts, strings.Join(lines, "\n"))
+		}
+	}
+	return stmts
+}
+
+// versionOf は "postgres/0001_init.sql" から 1 を取り出す
+func versionOf(name string) (int, error) {
+	base := name[strings.LastIndex(name, "/")+1:]
+	prefix, _, ok := strings.Cut(base, "_")
+	if !ok {
+		return 0, fmt.Errorf("migration %s must be named NNNN_description.sql", name)
+	}
+	return strconv.Atoi(prefix)
+}
@@ -0,0 +1,20 @@
+-- 本。検索に使う項目だけを列にして、本全体は data に JSON で持つ
+CREATE TABLE books (
+    book_id    TEXT PRIMARY KEY,
+    user_id    TEXT NOT NULL,
+    status     TEXT NOT NULL,
+    deadline   BIGINT NOT NULL, -- UNIX 時間 (ミリ秒)
+    data       JSONB NOT NULL
+);
+CREATE INDEX books_user_id ON books (user_id);
+CREATE INDEX books_status_deadline ON books (status, deadline, book_id);
+
+CREATE TABLE user_settings (
+    user_id TEXT PRIMARY KEY,
+    data    JSONB NOT NULL
+);
+
+CREATE TABLE users (
+    user_id TEXT PRIMARY KEY,
+    data    JSONB NOT NULL
+);
@@ -0,0 +1,6 @@
+-- 本棚のバージョン。本を書き換えるたびに進め、一覧の ETag に使う
+CREATE TABLE shelf_versions (
+    user_id    TEXT PRIMARY KEY,
+    version    INTEGER NOT NULL,
+    updated_at INTEGER NOT NULL -- UNIX 時間 (ミリ秒)
+);
@@ -0,0 +1,133 @@
+package store
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrBookNotFound は本が無いときに BookRepository が返すエラー
+var ErrBookNotFound = errors.New("book not found")
+
+// ErrDeadlineChanged は MoveDeadline の間に、ほかの更新で期限が変わっていたときのエラー
+var ErrDeadlineChanged = errors.New("deadline was changed concurrently")
+
+// Book は書籍データを表す構造体
+type Book struct {
+	Title       string    `json:"title" firestore:"title"`
+	Author      string    `json:"author" firestore:"author"`
+	Deadline    time.Time `json:"deadline" firestore:"deadline"` // time.Time型に変更
+	Status      string    `json:"status" firestore:"status"`     // "unread", "reading", "completed", "insulted", "abandoned"
+	InsultLevel int       `json:"insultLevel" firestore:"insultLevel"`
+	Pages       int       `json:"pages,omitempty" firestore:"pages,omitempty"` // ページ数 (任意)。年間の読了ページ数に使う
+	ISBN        string    `json:"isbn,omitempty" firestore:"isbn,omitempty"`
+	Price       int       `json:"price,omitempty" firestore:"price,omitempty"`   // 価格 (円)。未指定なら登録時に ISBN から調べる
+	Rating      int       `json:"rating,omitempty" firestore:"rating,omitempty"` // 自分の評価 (星1〜5)。0 なら未評価
+	// 紙の本・電子書籍・オーディオブック (FormatPaper など)。空なら分からない (紙の本と同じくページで数える)
+	Format string `json:"format,omitempty" firestore:"format,omitempty"`
+	// オーディオブックの長さ (分)。オーディオブックは Pages の代わりにこれで進み具合と読了見込みを数える
+	Minutes int `json:"minutes,omitempty" firestore:"minutes,omitempty"`
+	// 自分で付けたタグ ("SF" "仕事" など)。次に読む本の推薦 (/v1/recommendations) に使う
+	Tags   []string `json:"tags,omitempty" firestore:"tags,omitempty"`
+	UserID string   `json:"userId" firestore:"userId"` // 登録したユーザーのUID
+	BookID string   `json:"bookId" firestore:"bookId"` // FirestoreのドキュメントIDを保存
+	// 期限までに読み終えなければ寄付すると約束した誓約 (任意)。/v1/books/pledge で設定する
+	Pledge *Pledge `json:"pledge,omitempty" firestore:"pledge,omitempty"`
+	// 読書会の課題本として配られた本なら、その読書会のID
+	GroupID string `json:"groupId,omitempty" firestore:"groupId,omitempty"`
+	// 先に読むと決めた本のID。これらを読み終えるまでこの本は「ブロック中」で、次に読む本の候補にしない
+	DependsOn []string `json:"dependsOn,omitempty" firestore:"dependsOn,omitempty"`
+	// 持ち方 (OwnershipWishlist など)。導入前に登録した本は空で、owned とみなす。ウィッシュリストの本の期限はゼロ値
+	Ownership string `json:"ownership,omitempty" firestore:"ownership,omitempty"`
+	// 買った日と店 (任意)。価格は Price。/v1/books/{id}/purchase で設定し、買ってから読み始めるまでの日数の集計に使う
+	PurchasedAt   *time.Time `json:"purchasedAt,omitempty" firestore:"purchasedAt,omitempty"`
+	PurchaseStore string     `json:"purchaseStore,omitempty" firestore:"purchaseStore,omitempty"`
+	// 図書館から借りている本の返却期限 (任意)。読む期限の Deadline とは別。/v1/books/{id}/library で設定する
+	Library *LibraryLoan `json:"library,omitempty" firestore:"library,omitempty"`
+	// ウィッシュリストの本を、近所の図書館にあるから借りるよう最後に勧めた日時
+	LibraryNudgedAt *time.Time `json:"libraryNudgedAt,omitempty" firestore:"libraryNudgedAt,omitempty"`
+	// ウィッシュリストの本の値下がりの見張り (任意)。/v1/books/{id}/price-watch で設定する
+	PriceWatch *PriceWatch `json:"priceWatch,omitempty" firestore:"priceWatch,omitempty"`
+	// 最後に煽った周期 (JSTの日付 "2006-01-02")。同じ周期内での二重送信を防ぐ
+	LastInsultCycle string `json:"lastInsultCycle,omitempty" firestore:"lastInsultCycle,omitempty"`
+	// 登録日時と読了日時。サーバー側で記録し、統計 (/v1/stats) に使う。導入前に登録した本には無い
+	CreatedAt   *time.Time `json:"createdAt,omitempty" firestore:"createdAt,omitempty"`
+	CompletedAt *time.Time `json:"completedAt,omitempty" firestore:"completedAt,omitempty"`
+}
+
+// 本の持ち方
+const (
+	OwnershipWishlist = "wishlist" // 欲しいだけで、まだ持っていない。期限はなく、煽りの対象にしない
+	OwnershipOwned    = "owned"
+	OwnershipBorrowed = "borrowed" // 図書館や友達から借りている
+)
+
+// 本の形式
+const (
+	FormatPaper     = "paper"
+	FormatEbook     = "ebook"     // Kindle など
+	FormatAudiobook = "audiobook" // Audible など。進み具合はページではなく聞いた分数で数える
+)
+
+// Audiobook はオーディオブック (進み具合を分で数える本) かを返す
+func (b Book) Audiobook() bool {
+	return b.Format == FormatAudiobook
+}
+
+// Length は本の長さ。オーディオブックは分、それ以外はページ数。分からなければ 0
+func (b Book) Length() int {
+	if b.Audiobook() {
+		return b.Minutes
+	}
+	return b.Pages
+}
+
+// OnWishlist はウィッシュリストの本 (期限がなく、期限切れの煽り・恥の壁・集計の対象外) かを返す
+func (b Book) OnWishlist() bool {
+	return b.Ownership == OwnershipWishlist
+}
+
+// LibraryLoan は図書館から借りた本の貸出
+type LibraryLoan struct {
+	SystemID   string    `json:"systemId,omitempty" firestore:"systemId,omitempty"`     // カーリルの図書館システムID (例: "Tokyo_Setagaya")
+	SystemName string    `json:"systemName,omitempty" firestore:"systemName,omitempty"` // 図書館システムの名前
+	BorrowedAt time.Time `json:"borrowedAt" firestore:"borrowedAt"`
+	DueDate    time.Time `json:"dueDate" firestore:"dueDate"` // 返却期限
+	// 最後に返却を促した周期 (JSTの日付)。同じ日に二重に送らない
+	LastReminderCycle string `json:"lastReminderCycle,omitempty" firestore:"lastReminderCycle,omitempty"`
+}
+
+// PriceWatch はウィッシュリストの本の値下がりの見張り。価格は毎日調べ直す
+type PriceWatch struct {
+	Threshold  int        `json:"threshold" firestore:"threshold"`                     // この価格 (円) 以下になったら知らせる
+	LastPrice  int        `json:"lastPrice,omitempty" firestore:"lastPrice,omitempty"` // 最後に調べた最安値 (円)
+	LastSource string     `json:"lastSource,omitempty" firestore:"lastSource,omitempty"`
+	CheckedAt  *time.Time `json:"checkedAt,omitempty" firestore:"checkedAt,omitempty"`
+	// 最後に調べた周期 (JSTの日付)。同じ日に二重に調べない
+	CheckedCycle string `json:"checkedCycle,omitempty" firestore:"checkedCycle,omitempty"`
+	// 最後に知らせた価格。これより下がればまた知らせ、Threshold を上回ったら 0 に戻す
+	AlertedPrice int `json:"alertedPrice,omitempty" firestore:"alertedPrice,omitempty"`
+}
+
+// Abandoned は読むのを諦めた本 (期限切れの煽り・未読の集計の対象外) かを返す
+func (b Book) Abandoned() bool {
+	return b.Status == "abandoned"
+}
+
+// 誓約の状態
+const (
+	PledgeActive   = "active"   // 期限前。読み終えれば released になる
+	PledgeOwed     = "owed"     // 期限を過ぎた。settle するまで煽り文でリマインドする
+	PledgeSettled  = "settled"  // 支払ったと本人が申告した
+	PledgeReleased = "released" // 期限までに読み終えたので支払わなくてよい
+)
+
+// Pledge は本に付けた誓約
+type Pledge struct {
+	Amount     int        `json:"amount" firestore:"amount"`                             // 円
+	Recipient  string     `json:"recipient" firestore:"recipient"`                       // 寄付先の名前
+	PaymentURL string     `json:"paymentUrl,omitempty" firestore:"paymentUrl,omitempty"` // 寄付のページ
+	State      string     `json:"state" firestore:"state"`
+	CreatedAt  time.Time  `json:"createdAt" firestore:"createdAt"`
+	OwedAt     *time.Time `json:"owedAt,omitempty" firestore:"owedAt,omitempty"`
+	ResolvedAt *time.Time `json:"resolvedAt,omitempty" firestore:"resolvedAt,omitempty"` // settled か released になった日時
+}
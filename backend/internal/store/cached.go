@@ -0,0 +1,282 @@
+package store
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"tundoku-killer/backend/internal/cache"
+)
+
+// CachedBookRepository は本 (book:{id}) とユーザーの本の一覧 (books:{userId}) を Cache に置く BookRepository。
+// 書き換えたら該当するキーを消し、次の読み取りで入れ直す。Patch・Delete の所持者はキャッシュ経由の Get で調べる
+type CachedBookRepository struct {
+	BookRepository
+	Cache cache.Cache
+	TTL   time.Duration
+}
+
+// BookKey・BookListKey は本・本の一覧のキャッシュのキー。リポジトリを通さずに本を書き換えたとき (アカウントの統合など) に消す
+func BookKey(bookID string) string     { return "book:" + bookID }
+func BookListKey(userID string) string { return "books:" + userID }
+func settingsKey(userID string) string { return "settings:" + userID }
+func profileKey(userID string) string  { return "profile:" + userID }
+
+func (r CachedBookRepository) Get(ctx context.Context, bookID string) (Book, error) {
+	var book Book
+	if cache.GetJSON(ctx, r.Cache, BookKey(bookID), &book) {
+		return book, nil
+	}
+	book, err := r.BookRepository.Get(ctx, bookID)
+	if err != nil {
+		return Book{}, err
+	}
+	r.set(ctx, BookKey(bookID), book)
+	return book, nil
+}
+
+func (r CachedBookRepository) List(ctx context.Context, userID string) ([]Book, error) {
+	var books []Book
+	if cache.GetJSON(ctx, r.Cache, BookListKey(userID), &books) {
+		return books, nil
+	}
+	books, err := r.BookRepository.List(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	r.set(ctx, BookListKey(userID), books)
+	return books, nil
+}
+
+// ListByIDs はキャッシュにある本はそのまま使い、無い本だけをまとめて読み込んでキャッシュに置く
+func (r CachedBookRepository) ListByIDs(ctx context.Context, bookIDs []string) ([]Book, error) {
+	var (
+		books  []Book
+		missed []string
+	)
+	for _, bookID := range bookIDs {
+		var book Book
+		if cache.GetJSON(ctx, r.Cache, BookKey(bookID), &book) {
+			books = append(books, book)
+			continue
+		}
+		missed = append(missed, bookID)
+	}
+	if len(missed) == 0 {
+		return books, nil
+	}
+	found, err := r.BookRepository.ListByIDs(ctx, missed)
+	if err != nil {
+		return nil, err
+	}
+	for _, book := range found {
+		r.set(ctx, BookKey(book.BookID), book)
+	}
+	return append(books, found...), nil
+}
+
+// ListByUsers はユーザーごとの一覧のキャッシュを使い、無いユーザーの分だけをまとめて読み込んでキャッシュに置く
+func (r CachedBookRepository) ListByUsers(ctx context.Context, userIDs []string) ([]Book, error) {
+	var (
+		books  []Book
+		missed []string
+	)
+	for _, userID := range userIDs {
+		var cached []Book
+		if cache.GetJSON(ctx, r.Cache, BookListKey(userID), &cached) {
+			books = append(books, cached...)
+			continue
+		}
+		missed = append(missed, userID)
+	}
+	if len(missed) == 0 {
+		return books, nil
+	}
+	found, err := r.BookRepository.ListByUsers(ctx, missed)
+	if err != nil {
+		return nil, err
+	}
+	byUser := make(map[string][]Book, len(missed))
+	for _, book := range found {
+		byUser[book.UserID] = append(byUser[book.UserID], book)
+	}
+	for _, userID := range missed {
+		r.set(ctx, BookListKey(userID), byUser[userID])
+	}
+	return append(books, found...), nil
+}
+
+func (r CachedBookRepository) Create(ctx context.Context, book Book) (Book, error) {
+	book, err := r.BookRepository.Create(ctx, book)
+	if err != nil {
+		return Book{}, err
+	}
+	r.invalidate(ctx, BookListKey(book.UserID))
+	return book, nil
+}
+
+func (r CachedBookRepository) Update(ctx context.Context, book Book) error {
+	// 所持者が変わる場合に備えて、前の所持者の一覧も消す
+	before, getErr := r.Get(ctx, book.BookID)
+	if err := r.BookRepository.Update(ctx, book); err != nil {
+		return err
+	}
+	keys := []string{BookKey(book.BookID), BookListKey(book.UserID)}
+	if getErr == nil && before.UserID != book.UserID {
+		keys = append(keys, BookListKey(before.UserID))
+	}
+	r.invalidate(ctx, keys...)
+	return nil
+}
+
+func (r CachedBookRepository) Patch(ctx context.Context, bookID string, patch BookPatch) error {
+	book, err := r.Get(ctx, bookID)
+	if err != nil {
+		return err
+	}
+	if err := r.BookRepository.Patch(ctx, bookID, patch); err != nil {
+		return err
+	}
+	r.invalidate(ctx, BookKey(bookID), BookListKey(book.UserID))
+	return nil
+}
+
+func (r CachedBookRepository) MoveDeadline(ctx context.Context, bookID string, from, to time.Time) error {
+	book, err := r.Get(ctx, bookID)
+	if err != nil {
+		return err
+	}
+	if err := r.BookRepository.MoveDeadline(ctx, bookID, from, to); err != nil {
+		return err
+	}
+	r.invalidate(ctx, BookKey(bookID), BookListKey(book.UserID))
+	return nil
+}
+
+func (r CachedBookRepository) PatchAll(ctx context.Context, patches map[string]BookPatch) map[string]error {
+	owners := make(map[string]string, len(patches))
+	for bookID := range patches {
+		if book, err := r.Get(ctx, bookID); err == nil {
+			owners[bookID] = book.UserID
+		}
+	}
+	errs := r.BookRepository.PatchAll(ctx, patches)
+
+	var keys []string
+	for bookID := range patches {
+		keys = append(keys, BookKey(bookID))
+		if userID, ok := owners[bookID]; ok {
+			keys = append(keys, BookListKey(userID))
+		}
+	}
+	r.invalidate(ctx, keys...)
+	return errs
+}
+
+func (r CachedBookRepository) Delete(ctx context.Context, bookID string) error {
+	book, err := r.Get(ctx, bookID)
+	if err != nil && err != ErrBookNotFound {
+		return err
+	}
+	if err := r.BookRepository.Delete(ctx, bookID); err != nil {
+		return err
+	}
+	keys := []string{BookKey(bookID)}
+	if book.UserID != "" {
+		keys = append(keys, BookListKey(book.UserID))
+	}
+	r.invalidate(ctx, keys...)
+	return nil
+}
+
+func (r CachedBookRepository) set(ctx context.Context, key string, v interface{}) {
+	if err := cache.SetJSON(ctx, r.Cache, key, v, r.TTL); err != nil {
+		log.Printf("Error caching %s: %v", key, err)
+	}
+}
+
+// invalidate はキーを消す。消せなかったキーは TTL が切れるまで古いまま残るので、ログに残す
+func (r CachedBookRepository) invalidate(ctx context.Context, keys ...string) {
+	if err := r.Cache.Delete(ctx, keys...); err != nil {
+		log.Printf("Error invalidating cache %v: %v", keys, err)
+	}
+}
+
+// CachedUserRepository は設定 (settings:{userId}) とプロフィール (profile:{userId}) を Cache に置く UserRepository
+type CachedUserRepository struct {
+	UserRepository
+	Cache cache.Cache
+	TTL   time.Duration
+}
+
+func (r CachedUserRepository) GetSettings(ctx context.Context, userID string) (UserSettings, error) {
+	var settings UserSettings
+	if cache.GetJSON(ctx, r.Cache, settingsKey(userID), &settings) {
+		settings.UserID = userID
+		return settings, nil
+	}
+	settings, err := r.UserRepository.GetSettings(ctx, userID)
+	if err != nil {
+		return UserSettings{}, err
+	}
+	r.set(ctx, settingsKey(userID), settings)
+	return settings, nil
+}
+
+func (r CachedUserRepository) SaveSettings(ctx context.Context, settings UserSettings) error {
+	if err := r.UserRepository.SaveSettings(ctx, settings); err != nil {
+		return err
+	}
+	r.invalidate(ctx, settingsKey(settings.UserID))
+	return nil
+}
+
+func (r CachedUserRepository) CreateSettings(ctx context.Context, settings UserSettings) error {
+	if err := r.UserRepository.CreateSettings(ctx, settings); err != nil {
+		return err
+	}
+	r.invalidate(ctx, settingsKey(settings.UserID))
+	return nil
+}
+
+func (r CachedUserRepository) GetProfile(ctx context.Context, userID string) (UserProfile, error) {
+	var profile UserProfile
+	if cache.GetJSON(ctx, r.Cache, profileKey(userID), &profile) {
+		profile.UserID = userID
+		return profile, nil
+	}
+	profile, err := r.UserRepository.GetProfile(ctx, userID)
+	if err != nil {
+		return UserProfile{}, err
+	}
+	r.set(ctx, profileKey(userID), profile)
+	return profile, nil
+}
+
+func (r CachedUserRepository) SaveProfile(ctx context.Context, profile UserProfile) error {
+	if err := r.UserRepository.SaveProfile(ctx, profile); err != nil {
+		return err
+	}
+	r.invalidate(ctx, profileKey(profile.UserID))
+	return nil
+}
+
+func (r CachedUserRepository) CreateProfile(ctx context.Context, profile UserProfile) error {
+	if err := r.UserRepository.CreateProfile(ctx, profile); err != nil {
+		return err
+	}
+	r.invalidate(ctx, profileKey(profile.UserID))
+	return nil
+}
+
+func (r CachedUserRepository) set(ctx context.Context, key string, v interface{}) {
+	if err := cache.SetJSON(ctx, r.Cache, key, v, r.TTL); err != nil {
+		log.Printf("Error caching %s: %v", key, err)
+	}
+}
+
+func (r CachedUserRepository) invalidate(ctx context.Context, keys ...string) {
+	if err := r.Cache.Delete(ctx, keys...); err != nil {
+		log.Printf("Error invalidating cache %v: %v", keys, err)
+	}
+}
@@ -0,0 +1,105 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mapCache はテスト用のプロセス内の Cache
+type mapCache struct {
+	mu      sync.Mutex
+	entries map[string][]byte
+}
+
+func newMapCache() *mapCache { return &mapCache{entries: make(map[string][]byte)} }
+
+func (c *mapCache) Get(_ context.Context, key string) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.entries[key]
+	return v, ok, nil
+}
+
+func (c *mapCache) Set(_ context.Context, key string, value []byte, _ time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = value
+	return nil
+}
+
+func (c *mapCache) Delete(_ context.Context, keys ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		delete(c.entries, key)
+	}
+	return nil
+}
+
+func (c *mapCache) has(key string) bool {
+	_, ok, _ := c.Get(context.Background(), key)
+	return ok
+}
+
+func TestCachedBookRepositoryListByUsers(t *testing.T) {
+	ctx := context.Background()
+	c := newMapCache()
+	repo := CachedBookRepository{BookRepository: openSQLite(t).Books, Cache: c, TTL: time.Minute}
+
+	if _, err := repo.Create(ctx, Book{Title: "A", UserID: "u1"}); err != nil {
+		t.Fatal(err)
+	}
+	books, err := repo.ListByUsers(ctx, []string{"u1", "u2"})
+	if err != nil || len(books) != 1 {
+		t.Fatalf("ListByUsers = %d books, %v", len(books), err)
+	}
+	// 本の無いユーザーも空の一覧としてキャッシュする
+	if !c.has(BookListKey("u1")) || !c.has(BookListKey("u2")) {
+		t.Errorf("ListByUsers did not cache per-user lists: %v", c.entries)
+	}
+
+	// 書き換えたユーザーの一覧は消え、次の読み取りで新しい本が見える
+	if _, err := repo.Create(ctx, Book{Title: "B", UserID: "u2"}); err != nil {
+		t.Fatal(err)
+	}
+	if c.has(BookListKey("u2")) {
+		t.Error("Create did not invalidate the owner's list")
+	}
+	books, err = repo.ListByUsers(ctx, []string{"u1", "u2"})
+	if err != nil || len(books) != 2 {
+		t.Errorf("ListByUsers after Create = %d books, %v; want 2", len(books), err)
+	}
+}
+
+func TestCachedBookRepositoryListByIDs(t *testing.T) {
+	ctx := context.Background()
+	c := newMapCache()
+	repo := CachedBookRepository{BookRepository: openSQLite(t).Books, Cache: c, TTL: time.Minute}
+
+	a, _ := repo.Create(ctx, Book{Title: "A", UserID: "u1"})
+	b, _ := repo.Create(ctx, Book{Title: "B", UserID: "u1"})
+	if _, err := repo.Get(ctx, a.BookID); err != nil {
+		t.Fatal(err)
+	}
+
+	books, err := repo.ListByIDs(ctx, []string{a.BookID, b.BookID, "missing"})
+	if err != nil || len(books) != 2 {
+		t.Fatalf("ListByIDs = %d books, %v; want 2", len(books), err)
+	}
+	if !c.has(BookKey(b.BookID)) {
+		t.Error("ListByIDs did not cache the book it loaded")
+	}
+	if c.has(BookKey("missing")) {
+		t.Error("ListByIDs cached a missing book")
+	}
+
+	if err := repo.Patch(ctx, b.BookID, BookPatch{Status: ptr("completed")}); err != nil {
+		t.Fatal(err)
+	}
+	books, _ = repo.ListByIDs(ctx, []string{b.BookID})
+	if len(books) != 1 || books[0].Status != "completed" {
+		t.Errorf("ListByIDs after Patch = %+v", books)
+	}
+}
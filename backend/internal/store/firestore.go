@@ -0,0 +1,484 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// BookRepository・UserRepository の Firestore の実装。
+// 本は books/{bookId}、設定は userSettings/{userId}、プロフィールは users/{userId} に保存する
+
+// firestoreBookRepository は BookRepository の Firestore の実装
+type firestoreBookRepository struct {
+	client *firestore.Client
+
+	// overdueIndexMissing は複合インデックスが未作成だと判明した後、失敗するクエリを毎回投げないためのフラグ
+	overdueIndexMissing atomic.Bool
+}
+
+func NewFirestoreBookRepository(client *firestore.Client) BookRepository {
+	return &firestoreBookRepository{client: client}
+}
+
+func (r *firestoreBookRepository) books() *firestore.CollectionRef {
+	return r.client.Collection("books")
+}
+
+func (r *firestoreBookRepository) Get(ctx context.Context, bookID string) (Book, error) {
+	doc, err := r.books().Doc(bookID).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return Book{}, ErrBookNotFound
+	}
+	if err != nil {
+		return Book{}, fmt.Errorf("error fetching book: %w", err)
+	}
+	return bookFromDoc(doc)
+}
+
+func (r *firestoreBookRepository) List(ctx context.Context, userID string) ([]Book, error) {
+	// Firestoreから "completed" ではない本を取得
+	iter := r.books().
+		Where("userId", "==", userID).
+		// Where("status", "!=", "completed"). // 読了済みの本も一旦すべて取得
+		Documents(ctx)
+	defer iter.Stop()
+
+	var books []Book
+	for {
+		doc, err := iter.Next()
+		if err == io.EOF || err == iterator.Done { // firestore.Doneも追加でチェック！
+			break
+		}
+		if err != nil {
+			log.Printf("Error iterating documents: %v (Type: %T)", err, err) // エラーの型もログに出す！
+			return nil, err
+		}
+
+		book, err := bookFromDoc(doc)
+		if err != nil {
+			log.Printf("Error parsing book data: %v", err)
+			continue
+		}
+		books = append(books, book)
+	}
+	return books, nil
+}
+
+// firestoreInLimit は "in" クエリ・GetAll に一度に渡せるIDの数
+const firestoreInLimit = 30
+
+func (r *firestoreBookRepository) ListByIDs(ctx context.Context, bookIDs []string) ([]Book, error) {
+	var books []Book
+	for start := 0; start < len(bookIDs); start += firestoreInLimit {
+		chunk := bookIDs[start:min(start+firestoreInLimit, len(bookIDs))]
+		refs := make([]*firestore.DocumentRef, len(chunk))
+		for i, bookID := range chunk {
+			refs[i] = r.books().Doc(bookID)
+		}
+		docs, err := r.client.GetAll(ctx, refs)
+		if err != nil {
+			return nil, fmt.Errorf("error fetching books: %w", err)
+		}
+		for _, doc := range docs {
+			if !doc.Exists() {
+				continue
+			}
+			book, err := bookFromDoc(doc)
+			if err != nil {
+				log.Printf("Error parsing book data: %v", err)
+				continue
+			}
+			books = append(books, book)
+		}
+	}
+	return books, nil
+}
+
+func (r *firestoreBookRepository) ListByUsers(ctx context.Context, userIDs []string) ([]Book, error) {
+	var books []Book
+	for start := 0; start < len(userIDs); start += firestoreInLimit {
+		chunk := userIDs[start:min(start+firestoreInLimit, len(userIDs))]
+		docs, err := r.books().Where("userId", "in", chunk).Documents(ctx).GetAll()
+		if err != nil {
+			return nil, fmt.Errorf("error fetching books: %w", err)
+		}
+		for _, doc := range docs {
+			book, err := bookFromDoc(doc)
+			if err != nil {
+				log.Printf("Error parsing book data: %v", err)
+				continue
+			}
+			books = append(books, book)
+		}
+	}
+	return books, nil
+}
+
+// ListUserIDs は userId だけを読み込んで全件を走査する
+func (r *firestoreBookRepository) ListUserIDs(ctx context.Context) ([]string, error) {
+	iter := r.books().Select("userId").Documents(ctx)
+	defer iter.Stop()
+
+	seen := make(map[string]bool)
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error listing users: %w", err)
+		}
+		if userID, err := doc.DataAt("userId"); err == nil {
+			if id, ok := userID.(string); ok && id != "" {
+				seen[id] = true
+			}
+		}
+	}
+
+	userIDs := make([]string, 0, len(seen))
+	for userID := range seen {
+		userIDs = append(userIDs, userID)
+	}
+	sort.Strings(userIDs)
+	return userIDs, nil
+}
+
+func (r *firestoreBookRepository) Create(ctx context.Context, book Book) (Book, error) {
+	// 新しいドキュメント参照を作成し、そのIDをbook.BookIDに設定
+	docRef := r.books().NewDoc()
+	book.BookID = docRef.ID
+
+	// Book構造体全体をFirestoreに保存
+	if _, err := docRef.Set(ctx, book); err != nil {
+		return Book{}, fmt.Errorf("error saving book: %w", err)
+	}
+	return book, nil
+}
+
+func (r *firestoreBookRepository) Update(ctx context.Context, book Book) error {
+	if _, err := r.books().Doc(book.BookID).Set(ctx, book); err != nil { // 全て上書き
+		return fmt.Errorf("error updating book: %w", err)
+	}
+	return nil
+}
+
+func (r *firestoreBookRepository) Patch(ctx context.Context, bookID string, patch BookPatch) error {
+	_, err := r.books().Doc(bookID).Update(ctx, patch.updates())
+	if status.Code(err) == codes.NotFound {
+		return ErrBookNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("error updating book: %w", err)
+	}
+	return nil
+}
+
+// PatchAll は BulkWriter でまとめて書き込む
+func (r *firestoreBookRepository) PatchAll(ctx context.Context, patches map[string]BookPatch) map[string]error {
+	errs := make(map[string]error)
+	if len(patches) == 0 {
+		return errs
+	}
+
+	bw := r.client.BulkWriter(ctx)
+	jobs := make(map[string]*firestore.BulkWriterJob, len(patches))
+	for bookID, patch := range patches {
+		job, err := bw.Update(r.books().Doc(bookID), patch.updates())
+		if err != nil {
+			errs[bookID] = err
+			continue
+		}
+		jobs[bookID] = job
+	}
+	bw.End()
+
+	for bookID, job := range jobs {
+		if _, err := job.Results(); err != nil {
+			errs[bookID] = err
+		}
+	}
+	return errs
+}
+
+func (r *firestoreBookRepository) MoveDeadline(ctx context.Context, bookID string, from, to time.Time) error {
+	ref := r.books().Doc(bookID)
+	return r.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound {
+			return ErrBookNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("error fetching book: %w", err)
+		}
+		book, err := bookFromDoc(doc)
+		if err != nil {
+			return fmt.Errorf("error parsing book: %w", err)
+		}
+		if !book.Deadline.Equal(from) {
+			return ErrDeadlineChanged
+		}
+		return tx.Update(ref, []firestore.Update{{Path: "deadline", Value: to}})
+	})
+}
+
+func (r *firestoreBookRepository) Delete(ctx context.Context, bookID string) error {
+	if _, err := r.books().Doc(bookID).Delete(ctx); err != nil {
+		return fmt.Errorf("error deleting book: %w", err)
+	}
+	return nil
+}
+
+// QueryOverdue は (status, deadline) の複合インデックスを使い、期限切れの本だけを読み込む。
+// firestore.indexes.json のインデックスが未作成の場合は、ステータスだけで絞り込むクエリにフォールバックする
+func (r *firestoreBookRepository) QueryOverdue(ctx context.Context, now time.Time, afterID string, limit int) ([]Book, error) {
+	after, err := r.cursorSnapshot(ctx, afterID)
+	if err != nil {
+		return nil, err
+	}
+
+	var docs []*firestore.DocumentSnapshot
+	if !r.overdueIndexMissing.Load() {
+		query := r.books().
+			Where("status", "in", []string{"unread", "insulted"}).
+			Where("deadline", "<", now).
+			OrderBy("deadline", firestore.Asc).
+			OrderBy(firestore.DocumentID, firestore.Asc).
+			Limit(limit)
+		if after != nil {
+			query = query.StartAfter(after)
+		}
+
+		docs, err = query.Documents(ctx).GetAll()
+		if status.Code(err) == codes.FailedPrecondition {
+			log.Printf("Composite index for overdue books is missing; falling back to status-only query: %v", err)
+			r.overdueIndexMissing.Store(true)
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	if r.overdueIndexMissing.Load() {
+		query := r.books().
+			Where("status", "in", []string{"unread", "insulted"}).
+			OrderBy(firestore.DocumentID, firestore.Asc).
+			Limit(limit)
+		if after != nil {
+			query = query.StartAfter(after)
+		}
+		if docs, err = query.Documents(ctx).GetAll(); err != nil {
+			return nil, err
+		}
+	}
+
+	// 読めなかった本も件数には数えるよう、ページの長さは変えずに空の本で埋める (呼び出し側は期限で弾く)
+	books := make([]Book, len(docs))
+	for i, doc := range docs {
+		book, err := bookFromDoc(doc)
+		if err != nil {
+			log.Printf("Error parsing book data: %v", err)
+			book = Book{BookID: doc.Ref.ID, Deadline: now}
+		}
+		books[i] = book
+	}
+	return books, nil
+}
+
+// QueryCompletedBefore は (status, completedAt) の複合インデックスを使う
+func (r *firestoreBookRepository) QueryCompletedBefore(ctx context.Context, before time.Time, limit int) ([]Book, error) {
+	docs, err := r.books().
+		Where("status", "==", "completed").
+		Where("completedAt", "<", before).
+		OrderBy("completedAt", firestore.Asc).
+		Limit(limit).
+		Documents(ctx).GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("error querying completed books: %w", err)
+	}
+
+	books := make([]Book, 0, len(docs))
+	for _, doc := range docs {
+		book, err := bookFromDoc(doc)
+		if err != nil {
+			log.Printf("Error parsing book data: %v", err)
+			continue
+		}
+		books = append(books, book)
+	}
+	return books, nil
+}
+
+// cursorSnapshot は再開位置のドキュメントIDをクエリカーソル用のスナップショットに変換する。
+// 本が削除されていた場合は最初からやり直す
+func (r *firestoreBookRepository) cursorSnapshot(ctx context.Context, cursor string) (*firestore.DocumentSnapshot, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+	doc, err := r.books().Doc(cursor).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, nil
+	}
+	return doc, err
+}
+
+// bookFromDoc はドキュメントを本にする。BookID はドキュメントIDを正とする
+func bookFromDoc(doc *firestore.DocumentSnapshot) (Book, error) {
+	var book Book
+	if err := doc.DataTo(&book); err != nil {
+		return Book{}, err
+	}
+	book.BookID = doc.Ref.ID
+	return book, nil
+}
+
+// updates は BookPatch を Firestore の更新に変換する
+func (p BookPatch) updates() []firestore.Update {
+	var updates []firestore.Update
+	if p.Status != nil {
+		updates = append(updates, firestore.Update{Path: "status", Value: *p.Status})
+	}
+	if p.CompletedAt != nil {
+		updates = append(updates, firestore.Update{Path: "completedAt", Value: *p.CompletedAt})
+	}
+	if p.InsultLevelIncr != 0 {
+		updates = append(updates, firestore.Update{Path: "insultLevel", Value: firestore.Increment(p.InsultLevelIncr)})
+	}
+	if p.LastInsultCycle != nil {
+		updates = append(updates, firestore.Update{Path: "lastInsultCycle", Value: *p.LastInsultCycle})
+	}
+	if p.Pledge != nil {
+		updates = append(updates, firestore.Update{Path: "pledge", Value: p.Pledge})
+	}
+	if p.Price != nil {
+		updates = append(updates, firestore.Update{Path: "price", Value: *p.Price})
+	}
+	if p.PurchasedAt != nil {
+		updates = append(updates, firestore.Update{Path: "purchasedAt", Value: *p.PurchasedAt})
+	}
+	if p.PurchaseStore != nil {
+		updates = append(updates, firestore.Update{Path: "purchaseStore", Value: *p.PurchaseStore})
+	}
+	if p.LibraryReminderCycle != nil {
+		updates = append(updates, firestore.Update{Path: "library.lastReminderCycle", Value: *p.LibraryReminderCycle})
+	}
+	if p.LibraryNudgedAt != nil {
+		updates = append(updates, firestore.Update{Path: "libraryNudgedAt", Value: *p.LibraryNudgedAt})
+	}
+	if p.PriceWatch != nil {
+		updates = append(updates, firestore.Update{Path: "priceWatch", Value: *p.PriceWatch})
+	} else if p.ClearPriceWatch {
+		updates = append(updates, firestore.Update{Path: "priceWatch", Value: firestore.Delete})
+	}
+	if p.DependsOn != nil {
+		if len(*p.DependsOn) == 0 {
+			updates = append(updates, firestore.Update{Path: "dependsOn", Value: firestore.Delete})
+		} else {
+			updates = append(updates, firestore.Update{Path: "dependsOn", Value: *p.DependsOn})
+		}
+	}
+	return updates
+}
+
+// firestoreUserRepository は UserRepository の Firestore の実装
+type firestoreUserRepository struct {
+	client *firestore.Client
+}
+
+func NewFirestoreUserRepository(client *firestore.Client) UserRepository {
+	return &firestoreUserRepository{client: client}
+}
+
+func (r *firestoreUserRepository) GetSettings(ctx context.Context, userID string) (UserSettings, error) {
+	settings := UserSettings{UserID: userID}
+	doc, err := r.client.Collection("userSettings").Doc(userID).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return settings, nil
+	}
+	if err != nil {
+		return UserSettings{}, fmt.Errorf("error fetching settings: %w", err)
+	}
+	if err := doc.DataTo(&settings); err != nil {
+		return UserSettings{}, fmt.Errorf("error parsing settings: %w", err)
+	}
+	settings.UserID = userID
+	return settings, nil
+}
+
+func (r *firestoreUserRepository) SaveSettings(ctx context.Context, settings UserSettings) error {
+	if _, err := r.client.Collection("userSettings").Doc(settings.UserID).Set(ctx, settings); err != nil {
+		return fmt.Errorf("error saving settings: %w", err)
+	}
+	return nil
+}
+
+func (r *firestoreUserRepository) CreateSettings(ctx context.Context, settings UserSettings) error {
+	_, err := r.client.Collection("userSettings").Doc(settings.UserID).Create(ctx, settings)
+	if err != nil && status.Code(err) != codes.AlreadyExists {
+		return fmt.Errorf("error creating settings: %w", err)
+	}
+	return nil
+}
+
+func (r *firestoreUserRepository) GetProfile(ctx context.Context, userID string) (UserProfile, error) {
+	doc, err := r.client.Collection("users").Doc(userID).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return UserProfile{}, ErrProfileNotFound
+	}
+	if err != nil {
+		return UserProfile{}, fmt.Errorf("error fetching profile: %w", err)
+	}
+	var profile UserProfile
+	if err := doc.DataTo(&profile); err != nil {
+		return UserProfile{}, fmt.Errorf("error parsing profile: %w", err)
+	}
+	profile.UserID = userID
+	return profile, nil
+}
+
+func (r *firestoreUserRepository) ListProfiles(ctx context.Context) ([]UserProfile, error) {
+	iter := r.client.Collection("users").Documents(ctx)
+	defer iter.Stop()
+
+	var profiles []UserProfile
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error listing profiles: %w", err)
+		}
+		var profile UserProfile
+		if err := doc.DataTo(&profile); err != nil {
+			log.Printf("Error parsing profile %s: %v", doc.Ref.ID, err)
+			continue
+		}
+		profile.UserID = doc.Ref.ID
+		profiles = append(profiles, profile)
+	}
+	return profiles, nil
+}
+
+func (r *firestoreUserRepository) SaveProfile(ctx context.Context, profile UserProfile) error {
+	if _, err := r.client.Collection("users").Doc(profile.UserID).Set(ctx, profile); err != nil {
+		return fmt.Errorf("error saving profile: %w", err)
+	}
+	return nil
+}
+
+func (r *firestoreUserRepository) CreateProfile(ctx context.Context, profile UserProfile) error {
+	_, err := r.client.Collection("users").Doc(profile.UserID).Create(ctx, profile)
+	if err != nil && status.Code(err) != codes.AlreadyExists {
+		return fmt.Errorf("error creating profile: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+// Package store は本・ユーザーの保存先。ハンドラーは BookRepository / UserRepository を通して読み書きし、
+// Firestore や SQL を直接は触らない。テスト用のフェイクや別のストレージ、キャッシュ層はこのインターフェースを実装して差し替える
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// BookRepository は本の保存先
+type BookRepository interface {
+	// Get は bookID の本を返す。無ければ ErrBookNotFound
+	Get(ctx context.Context, bookID string) (Book, error)
+	// List は userID が登録した本をすべて返す
+	List(ctx context.Context, userID string) ([]Book, error)
+	// ListByIDs は bookIDs の本を返す。無い本は含めず、順序は bookIDs と同じとは限らない
+	ListByIDs(ctx context.Context, bookIDs []string) ([]Book, error)
+	// ListByUsers は userIDs のユーザーが登録した本をすべて返す
+	ListByUsers(ctx context.Context, userIDs []string) ([]Book, error)
+	// ListUserIDs は本を登録したことのあるユーザーをID順に返す。途中で時間切れになった cron が続きから処理できるよう順序を固定する
+	ListUserIDs(ctx context.Context) ([]string, error)
+	// Create は BookID を採番して本を保存し、保存した本を返す
+	Create(ctx context.Context, book Book) (Book, error)
+	// Update は本の全項目を上書きする
+	Update(ctx context.Context, book Book) error
+	// Patch は本の一部の項目だけを書き換える。無ければ ErrBookNotFound
+	Patch(ctx context.Context, bookID string, patch BookPatch) error
+	// PatchAll は複数の本をまとめて書き換え、失敗した本のIDとエラーを返す
+	PatchAll(ctx context.Context, patches map[string]BookPatch) map[string]error
+	// MoveDeadline は本の期限が from のままなら to に書き換える。読んでから書くまでを1つのトランザクションで行い、
+	// 期限がほかの更新で変わっていれば ErrDeadlineChanged。無ければ ErrBookNotFound
+	MoveDeadline(ctx context.Context, bookID string, from, to time.Time) error
+	// Delete は本を削除する
+	Delete(ctx context.Context, bookID string) error
+	// QueryOverdue は now 時点で期限切れの未読本を、ID が afterID の本の次から最大 limit 件返す。
+	// limit 件より少なければ最後まで読んだということ。期限前の本が混じることがあるので、呼び出し側でも期限を確かめる
+	QueryOverdue(ctx context.Context, now time.Time, afterID string, limit int) ([]Book, error)
+	// QueryCompletedBefore は before より前に読み終えた本を、読了日時の古い順に最大 limit 件返す。
+	// 読了日時の無い本は含めない。呼び出し側が処理した本を消していく前提なので、再開位置は取らない
+	QueryCompletedBefore(ctx context.Context, before time.Time, limit int) ([]Book, error)
+}
+
+// BookPatch は本の一部の項目の更新。nil (ゼロ値) の項目は変えない
+type BookPatch struct {
+	Status          *string
+	CompletedAt     *time.Time
+	InsultLevelIncr int // 煽りレベルに足す数
+	LastInsultCycle *string
+	Pledge          *Pledge
+	DependsOn       *[]string // 空のスライスなら先に読む本をなくす
+	Price           *int
+	PurchasedAt     *time.Time
+	PurchaseStore   *string
+	// 図書館の返却期限を促した周期。Library のある本にだけ使う
+	LibraryReminderCycle *string
+	// ウィッシュリストの本を図書館で借りるよう勧めた日時
+	LibraryNudgedAt *time.Time
+	// 値下がりの見張りの状態 (調べた価格・知らせた価格) を丸ごと置き換える
+	PriceWatch *PriceWatch
+	// ClearPriceWatch は値下がりの見張りを外す
+	ClearPriceWatch bool
+}
+
+// UserRepository はユーザーの設定とプロフィールの保存先
+type UserRepository interface {
+	// GetSettings は userID の設定を返す。未設定なら UserID だけのゼロ値
+	GetSettings(ctx context.Context, userID string) (UserSettings, error)
+	// SaveSettings は設定を上書きする
+	SaveSettings(ctx context.Context, settings UserSettings) error
+	// CreateSettings は設定がまだなければ保存する。既にあれば何もしない
+	CreateSettings(ctx context.Context, settings UserSettings) error
+	// GetProfile は userID のプロフィールを返す。無ければ ErrProfileNotFound
+	GetProfile(ctx context.Context, userID string) (UserProfile, error)
+	// ListProfiles はプロフィールをすべて返す (運用者向けの一覧に使う)
+	ListProfiles(ctx context.Context) ([]UserProfile, error)
+	// SaveProfile はプロフィールを上書きする
+	SaveProfile(ctx context.Context, profile UserProfile) error
+	// CreateProfile はプロフィールがまだなければ保存する。既にあれば何もしない
+	CreateProfile(ctx context.Context, profile UserProfile) error
+}
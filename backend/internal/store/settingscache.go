@@ -0,0 +1,80 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// settingsCacheMaxEntries を超えたら、期限切れの設定を掃除する
+const settingsCacheMaxEntries = 10000
+
+// SettingsCache は UserRepository を包み、設定をプロセス内に TTL の間だけ置く。
+// cron は期限切れの本ごとに所持者の設定を読むので、同じユーザーの本が何冊あっても読み取りは TTL ごとに1回で済む。
+// 保存したら自分のプロセスの分は消すが、他のインスタンスには TTL が切れるまで古い設定が残るので、TTL は短くする
+type SettingsCache struct {
+	UserRepository
+	TTL time.Duration
+
+	mu      sync.Mutex
+	entries map[string]settingsCacheEntry
+}
+
+type settingsCacheEntry struct {
+	settings  UserSettings
+	expiresAt time.Time
+}
+
+// NewSettingsCache は repo の設定を ttl の間キャッシュする SettingsCache を返す
+func NewSettingsCache(repo UserRepository, ttl time.Duration) *SettingsCache {
+	return &SettingsCache{UserRepository: repo, TTL: ttl, entries: make(map[string]settingsCacheEntry)}
+}
+
+func (c *SettingsCache) GetSettings(ctx context.Context, userID string) (UserSettings, error) {
+	now := time.Now()
+	c.mu.Lock()
+	entry, ok := c.entries[userID]
+	c.mu.Unlock()
+	if ok && now.Before(entry.expiresAt) {
+		return entry.settings, nil
+	}
+
+	settings, err := c.UserRepository.GetSettings(ctx, userID)
+	if err != nil {
+		return UserSettings{}, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= settingsCacheMaxEntries {
+		for id, e := range c.entries {
+			if !now.Before(e.expiresAt) {
+				delete(c.entries, id)
+			}
+		}
+	}
+	c.entries[userID] = settingsCacheEntry{settings: settings, expiresAt: now.Add(c.TTL)}
+	return settings, nil
+}
+
+func (c *SettingsCache) SaveSettings(ctx context.Context, settings UserSettings) error {
+	if err := c.UserRepository.SaveSettings(ctx, settings); err != nil {
+		return err
+	}
+	c.forget(settings.UserID)
+	return nil
+}
+
+func (c *SettingsCache) CreateSettings(ctx context.Context, settings UserSettings) error {
+	if err := c.UserRepository.CreateSettings(ctx, settings); err != nil {
+		return err
+	}
+	c.forget(settings.UserID)
+	return nil
+}
+
+func (c *SettingsCache) forget(userID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, userID)
+}
@@ -0,0 +1,559 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	_ "github.com/jackc/pgx/v5/stdlib" // "pgx" ドライバー
+	_ "github.com/mattn/go-sqlite3"    // "sqlite3" ドライバー
+
+	"tundoku-killer/backend/internal/config"
+	"tundoku-killer/backend/internal/sqlmigrate"
+)
+
+// BookRepository・UserRepository の SQL (Postgres・SQLite) の実装。Firebase のプロジェクトを持たずに動かしたい人向け。
+// STORAGE_BACKEND=postgres なら DATABASE_URL に接続し、STORAGE_BACKEND=sqlite なら DATABASE_URL のファイル (既定 tundoku.db) を使う。
+// 起動時に internal/sqlmigrate のマイグレーションを適用する。
+// 検索に使う項目 (所持者・ステータス・期限) だけを列にして、本・設定・プロフィール全体は data 列に JSON で持つ
+
+// SQLRepositories は SQL のストレージの接続と、その上のリポジトリ
+type SQLRepositories struct {
+	DB       *sql.DB
+	Books    BookRepository
+	Users    UserRepository
+	Versions ShelfVersions
+}
+
+// OpenSQLRepositories は STORAGE_BACKEND の SQL のストレージに接続し、スキーマを最新にする
+func OpenSQLRepositories(ctx context.Context, c config.StorageConfig) (SQLRepositories, error) {
+	var (
+		driver  string
+		dialect sqlmigrate.Dialect
+		backend = c.Backend
+		dsn     = c.DatabaseURL
+	)
+	switch backend {
+	case "postgres":
+		driver, dialect = "pgx", sqlmigrate.Postgres
+	case "sqlite":
+		driver, dialect = "sqlite3", sqlmigrate.SQLite
+	default:
+		return SQLRepositories{}, fmt.Errorf("unknown STORAGE_BACKEND %q", backend)
+	}
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return SQLRepositories{}, err
+	}
+	if dialect == sqlmigrate.SQLite {
+		// SQLite は書き込みが1つずつなので、接続を1本にして "database is locked" を避ける
+		db.SetMaxOpenConns(1)
+	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return SQLRepositories{}, fmt.Errorf("error connecting to %s: %w", backend, err)
+	}
+
+	applied, err := sqlmigrate.Up(ctx, db, dialect)
+	if err != nil {
+		db.Close()
+		return SQLRepositories{}, err
+	}
+	if applied > 0 {
+		log.Printf("Applied %d %s migrations", applied, backend)
+	}
+
+	s := sqlStore{db: db, dialect: dialect}
+	return SQLRepositories{
+		DB:       db,
+		Books:    &sqlBookRepository{s},
+		Users:    &sqlUserRepository{s},
+		Versions: &sqlShelfVersions{s},
+	}, nil
+}
+
+// sqlStore は方言の違い (プレースホルダーと行ロック) を吸収する
+type sqlStore struct {
+	db      *sql.DB
+	dialect sqlmigrate.Dialect
+}
+
+// rebind は "?" のプレースホルダーを方言に合わせる (Postgres は $1, $2, ...)
+func (s sqlStore) rebind(query string) string {
+	if s.dialect != sqlmigrate.Postgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// forUpdate はトランザクション内で読んだ行をロックする句。SQLite はトランザクション全体が直列なので不要
+func (s sqlStore) forUpdate() string {
+	if s.dialect == sqlmigrate.Postgres {
+		return " FOR UPDATE"
+	}
+	return ""
+}
+
+// sqlBookRepository は BookRepository の SQL の実装
+type sqlBookRepository struct {
+	sqlStore
+}
+
+func (r *sqlBookRepository) Get(ctx context.Context, bookID string) (Book, error) {
+	return r.get(ctx, r.db, bookID, "")
+}
+
+// get は q (DB かトランザクション) から本を読み込む
+func (r *sqlBookRepository) get(ctx context.Context, q interface {
+	QueryRowContext(context.Context, string, ...interface{}) *sql.Row
+}, bookID, suffix string) (Book, error) {
+	var data []byte
+	err := q.QueryRowContext(ctx, r.rebind(`SELECT data FROM books WHERE book_id = ?`+suffix), bookID).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Book{}, ErrBookNotFound
+	}
+	if err != nil {
+		return Book{}, fmt.Errorf("error fetching book: %w", err)
+	}
+	var book Book
+	if err := json.Unmarshal(data, &book); err != nil {
+		return Book{}, fmt.Errorf("error parsing book %s: %w", bookID, err)
+	}
+	book.BookID = bookID
+	return book, nil
+}
+
+func (r *sqlBookRepository) List(ctx context.Context, userID string) ([]Book, error) {
+	return r.query(ctx, `SELECT book_id, data FROM books WHERE user_id = ? ORDER BY book_id`, userID)
+}
+
+// sqlInLimit は IN 句に一度に並べるIDの数。SQLite のプレースホルダーの上限 (999) より小さくする
+const sqlInLimit = 500
+
+func (r *sqlBookRepository) ListByIDs(ctx context.Context, bookIDs []string) ([]Book, error) {
+	return r.queryIn(ctx, `SELECT book_id, data FROM books WHERE book_id IN (%s) ORDER BY book_id`, bookIDs)
+}
+
+func (r *sqlBookRepository) ListByUsers(ctx context.Context, userIDs []string) ([]Book, error) {
+	return r.queryIn(ctx, `SELECT book_id, data FROM books WHERE user_id IN (%s) ORDER BY book_id`, userIDs)
+}
+
+// queryIn は query の %s に ids のプレースホルダーを並べ、sqlInLimit 件ずつに分けて実行する
+func (r *sqlBookRepository) queryIn(ctx context.Context, query string, ids []string) ([]Book, error) {
+	var books []Book
+	for start := 0; start < len(ids); start += sqlInLimit {
+		chunk := ids[start:min(start+sqlInLimit, len(ids))]
+		args := make([]interface{}, len(chunk))
+		for i, id := range chunk {
+			args[i] = id
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(chunk)), ", ")
+		found, err := r.query(ctx, fmt.Sprintf(query, placeholders), args...)
+		if err != nil {
+			return nil, fmt.Errorf("error fetching books: %w", err)
+		}
+		books = append(books, found...)
+	}
+	return books, nil
+}
+
+func (r *sqlBookRepository) ListUserIDs(ctx context.Context) ([]string, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT DISTINCT user_id FROM books WHERE user_id <> '' ORDER BY user_id`)
+	if err != nil {
+		return nil, fmt.Errorf("error listing users: %w", err)
+	}
+	defer rows.Close()
+
+	var userIDs []string
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+	return userIDs, rows.Err()
+}
+
+// query は book_id と data を返すクエリを実行して本の一覧にする。読めない本はログに残して飛ばす
+func (r *sqlBookRepository) query(ctx context.Context, query string, args ...interface{}) ([]Book, error) {
+	rows, err := r.db.QueryContext(ctx, r.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var books []Book
+	for rows.Next() {
+		var (
+			bookID string
+			data   []byte
+		)
+		if err := rows.Scan(&bookID, &data); err != nil {
+			return nil, err
+		}
+		var book Book
+		if err := json.Unmarshal(data, &book); err != nil {
+			log.Printf("Error parsing book data: %v", err)
+			continue
+		}
+		book.BookID = bookID
+		books = append(books, book)
+	}
+	return books, rows.Err()
+}
+
+func (r *sqlBookRepository) Create(ctx context.Context, book Book) (Book, error) {
+	book.BookID = uuid.NewString()
+	data, err := json.Marshal(book)
+	if err != nil {
+		return Book{}, err
+	}
+	_, err = r.db.ExecContext(ctx, r.rebind(`INSERT INTO books (book_id, user_id, status, deadline, data) VALUES (?, ?, ?, ?, ?)`),
+		book.BookID, book.UserID, book.Status, book.Deadline.UnixMilli(), string(data))
+	if err != nil {
+		return Book{}, fmt.Errorf("error saving book: %w", err)
+	}
+	return book, nil
+}
+
+func (r *sqlBookRepository) Update(ctx context.Context, book Book) error {
+	return r.put(ctx, r.db, book)
+}
+
+// put は本を挿入するか、全項目を上書きする
+func (r *sqlBookRepository) put(ctx context.Context, q interface {
+	ExecContext(context.Context, string, ...interface{}) (sql.Result, error)
+}, book Book) error {
+	data, err := json.Marshal(book)
+	if err != nil {
+		return err
+	}
+	_, err = q.ExecContext(ctx, r.rebind(`INSERT INTO books (book_id, user_id, status, deadline, data) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (book_id) DO UPDATE SET user_id = excluded.user_id, status = excluded.status, deadline = excluded.deadline, data = excluded.data`),
+		book.BookID, book.UserID, book.Status, book.Deadline.UnixMilli(), string(data))
+	if err != nil {
+		return fmt.Errorf("error updating book: %w", err)
+	}
+	return nil
+}
+
+// Patch は行をロックして読み込み、書き換えてから全体を書き戻す
+func (r *sqlBookRepository) Patch(ctx context.Context, bookID string, patch BookPatch) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	book, err := r.get(ctx, tx, bookID, r.forUpdate())
+	if err != nil {
+		return err
+	}
+	patch.apply(&book)
+	if err := r.put(ctx, tx, book); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *sqlBookRepository) MoveDeadline(ctx context.Context, bookID string, from, to time.Time) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	book, err := r.get(ctx, tx, bookID, r.forUpdate())
+	if err != nil {
+		return err
+	}
+	if !book.Deadline.Equal(from) {
+		return ErrDeadlineChanged
+	}
+	book.Deadline = to
+	if err := r.put(ctx, tx, book); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *sqlBookRepository) PatchAll(ctx context.Context, patches map[string]BookPatch) map[string]error {
+	errs := make(map[string]error)
+	for bookID, patch := range patches {
+		if err := r.Patch(ctx, bookID, patch); err != nil {
+			errs[bookID] = err
+		}
+	}
+	return errs
+}
+
+func (r *sqlBookRepository) Delete(ctx context.Context, bookID string) error {
+	if _, err := r.db.ExecContext(ctx, r.rebind(`DELETE FROM books WHERE book_id = ?`), bookID); err != nil {
+		return fmt.Errorf("error deleting book: %w", err)
+	}
+	return nil
+}
+
+// QueryOverdue は (status, deadline, book_id) のインデックスを使い、期限・ID の順に返す
+func (r *sqlBookRepository) QueryOverdue(ctx context.Context, now time.Time, afterID string, limit int) ([]Book, error) {
+	if afterID == "" {
+		return r.query(ctx, `SELECT book_id, data FROM books
+			WHERE status IN ('unread', 'insulted') AND deadline < ?
+			ORDER BY deadline, book_id LIMIT ?`, now.UnixMilli(), limit)
+	}
+
+	// 再開位置の本が削除されていた場合は最初からやり直す
+	var after int64
+	err := r.db.QueryRowContext(ctx, r.rebind(`SELECT deadline FROM books WHERE book_id = ?`), afterID).Scan(&after)
+	if errors.Is(err, sql.ErrNoRows) {
+		return r.QueryOverdue(ctx, now, "", limit)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r.query(ctx, `SELECT book_id, data FROM books
+		WHERE status IN ('unread', 'insulted') AND deadline < ? AND (deadline > ? OR (deadline = ? AND book_id > ?))
+		ORDER BY deadline, book_id LIMIT ?`, now.UnixMilli(), after, after, afterID, limit)
+}
+
+// QueryCompletedBefore は読了日時を列に持っていないため、読了済みの本を ID 順に読みながら data の completedAt で絞り込む
+func (r *sqlBookRepository) QueryCompletedBefore(ctx context.Context, before time.Time, limit int) ([]Book, error) {
+	const page = 500
+	var (
+		matched []Book
+		afterID string
+	)
+	for len(matched) < limit {
+		books, err := r.query(ctx, `SELECT book_id, data FROM books
+			WHERE status = 'completed' AND book_id > ?
+			ORDER BY book_id LIMIT ?`, afterID, page)
+		if err != nil {
+			return nil, fmt.Errorf("error querying completed books: %w", err)
+		}
+		for _, book := range books {
+			if book.CompletedAt != nil && book.CompletedAt.Before(before) {
+				matched = append(matched, book)
+			}
+		}
+		if len(books) < page {
+			break
+		}
+		afterID = books[len(books)-1].BookID
+	}
+	if len(matched) > limit {
+		matched = matched[:limit]
+	}
+	sort.SliceStable(matched, func(i, j int) bool { return matched[i].CompletedAt.Before(*matched[j].CompletedAt) })
+	return matched, nil
+}
+
+// apply は BookPatch を本に反映する
+func (p BookPatch) apply(book *Book) {
+	if p.Status != nil {
+		book.Status = *p.Status
+	}
+	if p.CompletedAt != nil {
+		completedAt := *p.CompletedAt
+		book.CompletedAt = &completedAt
+	}
+	book.InsultLevel += p.InsultLevelIncr
+	if p.LastInsultCycle != nil {
+		book.LastInsultCycle = *p.LastInsultCycle
+	}
+	if p.Pledge != nil {
+		pledge := *p.Pledge
+		book.Pledge = &pledge
+	}
+	if p.Price != nil {
+		book.Price = *p.Price
+	}
+	if p.PurchasedAt != nil {
+		purchasedAt := *p.PurchasedAt
+		book.PurchasedAt = &purchasedAt
+	}
+	if p.PurchaseStore != nil {
+		book.PurchaseStore = *p.PurchaseStore
+	}
+	if p.LibraryReminderCycle != nil && book.Library != nil {
+		book.Library.LastReminderCycle = *p.LibraryReminderCycle
+	}
+	if p.LibraryNudgedAt != nil {
+		nudgedAt := *p.LibraryNudgedAt
+		book.LibraryNudgedAt = &nudgedAt
+	}
+	if p.PriceWatch != nil {
+		watch := *p.PriceWatch
+		book.PriceWatch = &watch
+	} else if p.ClearPriceWatch {
+		book.PriceWatch = nil
+	}
+	if p.DependsOn != nil {
+		book.DependsOn = nil
+		if len(*p.DependsOn) > 0 {
+			book.DependsOn = append([]string(nil), *p.DependsOn...)
+		}
+	}
+}
+
+// sqlShelfVersions は ShelfVersions の SQL の実装
+type sqlShelfVersions struct {
+	sqlStore
+}
+
+func (v *sqlShelfVersions) Get(ctx context.Context, userID string) (ShelfVersion, error) {
+	var version, updatedAt int64
+	err := v.db.QueryRowContext(ctx, v.rebind(`SELECT version, updated_at FROM shelf_versions WHERE user_id = ?`), userID).
+		Scan(&version, &updatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ShelfVersion{}, nil
+	}
+	if err != nil {
+		return ShelfVersion{}, fmt.Errorf("error fetching shelf version: %w", err)
+	}
+	return ShelfVersion{Version: version, UpdatedAt: time.UnixMilli(updatedAt)}, nil
+}
+
+func (v *sqlShelfVersions) Bump(ctx context.Context, userIDs ...string) error {
+	now := time.Now().UnixMilli()
+	for _, userID := range userIDs {
+		_, err := v.db.ExecContext(ctx, v.rebind(`INSERT INTO shelf_versions (user_id, version, updated_at) VALUES (?, 1, ?)
+			ON CONFLICT (user_id) DO UPDATE SET version = shelf_versions.version + 1, updated_at = excluded.updated_at`),
+			userID, now)
+		if err != nil {
+			return fmt.Errorf("error bumping shelf version: %w", err)
+		}
+	}
+	return nil
+}
+
+// sqlUserRepository は UserRepository の SQL の実装
+type sqlUserRepository struct {
+	sqlStore
+}
+
+func (r *sqlUserRepository) GetSettings(ctx context.Context, userID string) (UserSettings, error) {
+	settings := UserSettings{UserID: userID}
+	found, err := r.getJSON(ctx, "user_settings", userID, &settings)
+	if err != nil {
+		return UserSettings{}, fmt.Errorf("error fetching settings: %w", err)
+	}
+	if found {
+		settings.UserID = userID
+	}
+	return settings, nil
+}
+
+func (r *sqlUserRepository) SaveSettings(ctx context.Context, settings UserSettings) error {
+	if err := r.putJSON(ctx, "user_settings", settings.UserID, settings, true); err != nil {
+		return fmt.Errorf("error saving settings: %w", err)
+	}
+	return nil
+}
+
+func (r *sqlUserRepository) CreateSettings(ctx context.Context, settings UserSettings) error {
+	if err := r.putJSON(ctx, "user_settings", settings.UserID, settings, false); err != nil {
+		return fmt.Errorf("error creating settings: %w", err)
+	}
+	return nil
+}
+
+func (r *sqlUserRepository) GetProfile(ctx context.Context, userID string) (UserProfile, error) {
+	var profile UserProfile
+	found, err := r.getJSON(ctx, "users", userID, &profile)
+	if err != nil {
+		return UserProfile{}, fmt.Errorf("error fetching profile: %w", err)
+	}
+	if !found {
+		return UserProfile{}, ErrProfileNotFound
+	}
+	profile.UserID = userID
+	return profile, nil
+}
+
+func (r *sqlUserRepository) ListProfiles(ctx context.Context) ([]UserProfile, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT user_id, data FROM users ORDER BY user_id`)
+	if err != nil {
+		return nil, fmt.Errorf("error listing profiles: %w", err)
+	}
+	defer rows.Close()
+
+	var profiles []UserProfile
+	for rows.Next() {
+		var (
+			userID string
+			data   []byte
+		)
+		if err := rows.Scan(&userID, &data); err != nil {
+			return nil, err
+		}
+		var profile UserProfile
+		if err := json.Unmarshal(data, &profile); err != nil {
+			log.Printf("Error parsing profile %s: %v", userID, err)
+			continue
+		}
+		profile.UserID = userID
+		profiles = append(profiles, profile)
+	}
+	return profiles, rows.Err()
+}
+
+func (r *sqlUserRepository) SaveProfile(ctx context.Context, profile UserProfile) error {
+	if err := r.putJSON(ctx, "users", profile.UserID, profile, true); err != nil {
+		return fmt.Errorf("error saving profile: %w", err)
+	}
+	return nil
+}
+
+func (r *sqlUserRepository) CreateProfile(ctx context.Context, profile UserProfile) error {
+	if err := r.putJSON(ctx, "users", profile.UserID, profile, false); err != nil {
+		return fmt.Errorf("error creating profile: %w", err)
+	}
+	return nil
+}
+
+// getJSON は table の userID の行の data を v に読み込む。行が無ければ false
+func (r *sqlUserRepository) getJSON(ctx context.Context, table, userID string, v interface{}) (bool, error) {
+	var data []byte
+	err := r.db.QueryRowContext(ctx, r.rebind(`SELECT data FROM `+table+` WHERE user_id = ?`), userID).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, json.Unmarshal(data, v)
+}
+
+// putJSON は table の userID の行に v を JSON で書き込む。overwrite が false なら既にある行は変えない
+func (r *sqlUserRepository) putJSON(ctx context.Context, table, userID string, v interface{}, overwrite bool) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	conflict := `DO NOTHING`
+	if overwrite {
+		conflict = `DO UPDATE SET data = excluded.data`
+	}
+	_, err = r.db.ExecContext(ctx, r.rebind(`INSERT INTO `+table+` (user_id, data) VALUES (?, ?) ON CONFLICT (user_id) `+conflict), userID, string(data))
+	return err
+}
@@ -0,0 +1,378 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"tundoku-killer/backend/internal/config"
+	"tundoku-killer/backend/internal/sqlmigrate"
+)
+
+// openSQLite はテストごとに新しい SQLite のファイルでリポジトリを開く
+func openSQLite(t *testing.T) SQLRepositories {
+	t.Helper()
+	repos, err := OpenSQLRepositories(context.Background(), config.StorageConfig{
+		Backend:     "sqlite",
+		DatabaseURL: filepath.Join(t.TempDir(), "tundoku.db"),
+	})
+	if err != nil {
+		t.Fatalf("OpenSQLRepositories: %v", err)
+	}
+	t.Cleanup(func() { repos.DB.Close() })
+	return repos
+}
+
+func ptr[T any](v T) *T { return &v }
+
+func TestBookPatchApply(t *testing.T) {
+	completedAt := time.Date(2024, 3, 4, 5, 6, 7, 0, time.UTC)
+	base := func() Book {
+		return Book{
+			Status:      "unread",
+			InsultLevel: 2,
+			Library:     &LibraryLoan{SystemID: "Tokyo_Setagaya"},
+			DependsOn:   []string{"a", "b"},
+			PriceWatch:  &PriceWatch{Threshold: 1000},
+		}
+	}
+	tests := []struct {
+		name  string
+		patch BookPatch
+		want  func(*Book)
+	}{
+		{"empty patch changes nothing", BookPatch{}, func(*Book) {}},
+		{"status and completion", BookPatch{Status: ptr("completed"), CompletedAt: &completedAt}, func(b *Book) {
+			b.Status = "completed"
+			b.CompletedAt = &completedAt
+		}},
+		{"insult level is incremented", BookPatch{InsultLevelIncr: 1, LastInsultCycle: ptr("2024-03-04")}, func(b *Book) {
+			b.InsultLevel = 3
+			b.LastInsultCycle = "2024-03-04"
+		}},
+		{"purchase", BookPatch{Price: ptr(1200), PurchasedAt: &completedAt, PurchaseStore: ptr("Amazon")}, func(b *Book) {
+			b.Price = 1200
+			b.PurchasedAt = &completedAt
+			b.PurchaseStore = "Amazon"
+		}},
+		{"library reminder cycle", BookPatch{LibraryReminderCycle: ptr("2024-03-04")}, func(b *Book) {
+			b.Library.LastReminderCycle = "2024-03-04"
+		}},
+		{"empty dependencies clear them", BookPatch{DependsOn: &[]string{}}, func(b *Book) {
+			b.DependsOn = nil
+		}},
+		{"dependencies are replaced", BookPatch{DependsOn: &[]string{"c"}}, func(b *Book) {
+			b.DependsOn = []string{"c"}
+		}},
+		{"price watch is replaced", BookPatch{PriceWatch: &PriceWatch{Threshold: 800, AlertedPrice: 790}}, func(b *Book) {
+			b.PriceWatch = &PriceWatch{Threshold: 800, AlertedPrice: 790}
+		}},
+		{"price watch is cleared", BookPatch{ClearPriceWatch: true}, func(b *Book) {
+			b.PriceWatch = nil
+		}},
+		{"pledge", BookPatch{Pledge: &Pledge{Amount: 1000, State: PledgeOwed}}, func(b *Book) {
+			b.Pledge = &Pledge{Amount: 1000, State: PledgeOwed}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, want := base(), base()
+			tt.patch.apply(&got)
+			tt.want(&want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %+v\nwant %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestBookPatchApplyDoesNotAlias(t *testing.T) {
+	deps := []string{"a"}
+	watch := PriceWatch{Threshold: 1000}
+	var book Book
+	BookPatch{DependsOn: &deps, PriceWatch: &watch}.apply(&book)
+	deps[0] = "changed"
+	watch.Threshold = 1
+	if book.DependsOn[0] != "a" || book.PriceWatch.Threshold != 1000 {
+		t.Errorf("patched book shares memory with the patch: %+v", book)
+	}
+}
+
+func TestSQLBookRepository(t *testing.T) {
+	ctx := context.Background()
+	repo := openSQLite(t).Books
+
+	deadline := time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC)
+	book, err := repo.Create(ctx, Book{Title: "積読", UserID: "u1", Status: "unread", Deadline: deadline})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if book.BookID == "" {
+		t.Fatal("Create did not assign a BookID")
+	}
+
+	got, err := repo.Get(ctx, book.BookID)
+	if err != nil || got.Title != "積読" || !got.Deadline.Equal(deadline) {
+		t.Fatalf("Get = %+v, %v", got, err)
+	}
+	if _, err := repo.Get(ctx, "missing"); !errors.Is(err, ErrBookNotFound) {
+		t.Errorf("Get(missing) error = %v, want ErrBookNotFound", err)
+	}
+
+	if err := repo.Patch(ctx, book.BookID, BookPatch{Status: ptr("insulted"), InsultLevelIncr: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Patch(ctx, "missing", BookPatch{Status: ptr("insulted")}); !errors.Is(err, ErrBookNotFound) {
+		t.Errorf("Patch(missing) error = %v, want ErrBookNotFound", err)
+	}
+	got, _ = repo.Get(ctx, book.BookID)
+	if got.Status != "insulted" || got.InsultLevel != 1 {
+		t.Errorf("after Patch = %+v", got)
+	}
+
+	later := deadline.Add(24 * time.Hour)
+	if err := repo.MoveDeadline(ctx, book.BookID, deadline, later); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.MoveDeadline(ctx, book.BookID, deadline, later.Add(time.Hour)); !errors.Is(err, ErrDeadlineChanged) {
+		t.Errorf("MoveDeadline from a stale deadline error = %v, want ErrDeadlineChanged", err)
+	}
+	got, _ = repo.Get(ctx, book.BookID)
+	if !got.Deadline.Equal(later) {
+		t.Errorf("deadline = %v, want %v", got.Deadline, later)
+	}
+
+	if err := repo.Delete(ctx, book.BookID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Get(ctx, book.BookID); !errors.Is(err, ErrBookNotFound) {
+		t.Errorf("Get after Delete error = %v", err)
+	}
+}
+
+func TestSQLBookRepositoryLists(t *testing.T) {
+	ctx := context.Background()
+	repo := openSQLite(t).Books
+
+	ids := map[string]string{}
+	for _, b := range []Book{
+		{Title: "A", UserID: "u2"},
+		{Title: "B", UserID: "u1"},
+		{Title: "C", UserID: "u1"},
+		{Title: "D", UserID: "u3"},
+	} {
+		created, err := repo.Create(ctx, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[b.Title] = created.BookID
+	}
+
+	userIDs, err := repo.ListUserIDs(ctx)
+	if err != nil || !reflect.DeepEqual(userIDs, []string{"u1", "u2", "u3"}) {
+		t.Errorf("ListUserIDs = %v, %v", userIDs, err)
+	}
+
+	titles := func(books []Book) []string {
+		var out []string
+		for _, b := range books {
+			out = append(out, b.Title)
+		}
+		sort.Strings(out)
+		return out
+	}
+	books, err := repo.List(ctx, "u1")
+	if err != nil || !reflect.DeepEqual(titles(books), []string{"B", "C"}) {
+		t.Errorf("List(u1) = %v, %v", titles(books), err)
+	}
+	books, err = repo.ListByUsers(ctx, []string{"u1", "u3", "nobody"})
+	if err != nil || !reflect.DeepEqual(titles(books), []string{"B", "C", "D"}) {
+		t.Errorf("ListByUsers = %v, %v", titles(books), err)
+	}
+	books, err = repo.ListByIDs(ctx, []string{ids["A"], ids["D"], "missing"})
+	if err != nil || !reflect.DeepEqual(titles(books), []string{"A", "D"}) {
+		t.Errorf("ListByIDs = %v, %v", titles(books), err)
+	}
+	if books, err := repo.ListByIDs(ctx, nil); err != nil || len(books) != 0 {
+		t.Errorf("ListByIDs(nil) = %v, %v", books, err)
+	}
+}
+
+func TestSQLBookRepositoryListByUsersManyIDs(t *testing.T) {
+	ctx := context.Background()
+	repo := openSQLite(t).Books
+	if _, err := repo.Create(ctx, Book{Title: "A", UserID: "u0"}); err != nil {
+		t.Fatal(err)
+	}
+	// IN 句の上限を超える数のIDは分けて問い合わせる
+	userIDs := make([]string, sqlInLimit*2+1)
+	for i := range userIDs {
+		userIDs[i] = "x"
+	}
+	userIDs[len(userIDs)-1] = "u0"
+	books, err := repo.ListByUsers(ctx, userIDs)
+	if err != nil || len(books) != 1 {
+		t.Errorf("ListByUsers = %d books, %v; want 1", len(books), err)
+	}
+}
+
+func TestSQLBookRepositoryQueryOverdue(t *testing.T) {
+	ctx := context.Background()
+	repo := openSQLite(t).Books
+	now := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+
+	var overdue []string
+	for i, b := range []Book{
+		{Title: "期限切れ1", Status: "unread", Deadline: now.Add(-3 * time.Hour)},
+		{Title: "期限切れ2", Status: "insulted", Deadline: now.Add(-2 * time.Hour)},
+		{Title: "期限切れ3", Status: "unread", Deadline: now.Add(-2 * time.Hour)},
+		{Title: "期限前", Status: "unread", Deadline: now.Add(time.Hour)},
+		{Title: "読了", Status: "completed", Deadline: now.Add(-time.Hour)},
+	} {
+		b.UserID = "u1"
+		created, err := repo.Create(ctx, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i < 3 {
+			overdue = append(overdue, created.BookID)
+		}
+	}
+
+	// ページを読み進めて、期限切れの本を1冊ずつ、期限の古い順に漏れなく返す
+	var got []string
+	cursor := ""
+	for page := 0; page < 10; page++ {
+		books, err := repo.QueryOverdue(ctx, now, cursor, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(books) == 0 {
+			break
+		}
+		got = append(got, books[0].BookID)
+		cursor = books[0].BookID
+	}
+	if len(got) != 3 || got[0] != overdue[0] {
+		t.Fatalf("QueryOverdue pages = %v, want 3 books starting with %s", got, overdue[0])
+	}
+	sort.Strings(got[1:])
+	want := append([]string(nil), overdue...)
+	sort.Strings(want[1:])
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("QueryOverdue pages = %v, want %v", got, want)
+	}
+
+	// 再開位置の本が消えていたら最初からやり直す
+	books, err := repo.QueryOverdue(ctx, now, "deleted", 10)
+	if err != nil || len(books) != 3 {
+		t.Errorf("QueryOverdue after a deleted cursor = %d books, %v", len(books), err)
+	}
+}
+
+func TestSQLBookRepositoryQueryCompletedBefore(t *testing.T) {
+	ctx := context.Background()
+	repo := openSQLite(t).Books
+	cutoff := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, b := range []Book{
+		{Title: "新しい", Status: "completed", CompletedAt: ptr(cutoff.Add(time.Hour))},
+		{Title: "古い2", Status: "completed", CompletedAt: ptr(cutoff.Add(-time.Hour))},
+		{Title: "古い1", Status: "completed", CompletedAt: ptr(cutoff.Add(-48 * time.Hour))},
+		{Title: "日時なし", Status: "completed"},
+		{Title: "未読", Status: "unread", CompletedAt: ptr(cutoff.Add(-time.Hour))},
+	} {
+		b.UserID = "u1"
+		if _, err := repo.Create(ctx, b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	books, err := repo.QueryCompletedBefore(ctx, cutoff, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var titles []string
+	for _, b := range books {
+		titles = append(titles, b.Title)
+	}
+	if !reflect.DeepEqual(titles, []string{"古い1", "古い2"}) {
+		t.Errorf("QueryCompletedBefore = %v, want [古い1 古い2]", titles)
+	}
+	if books, _ := repo.QueryCompletedBefore(ctx, cutoff, 1); len(books) != 1 {
+		t.Errorf("QueryCompletedBefore with limit 1 = %d books", len(books))
+	}
+}
+
+func TestSQLUserRepository(t *testing.T) {
+	ctx := context.Background()
+	repo := openSQLite(t).Users
+
+	settings, err := repo.GetSettings(ctx, "u1")
+	if err != nil || settings.UserID != "u1" {
+		t.Fatalf("GetSettings before saving = %+v, %v", settings, err)
+	}
+
+	if _, err := repo.GetProfile(ctx, "u1"); !errors.Is(err, ErrProfileNotFound) {
+		t.Errorf("GetProfile before saving error = %v", err)
+	}
+	if err := repo.CreateProfile(ctx, UserProfile{UserID: "u1", DisplayName: "最初"}); err != nil {
+		t.Fatal(err)
+	}
+	// 既にあれば CreateProfile は何もしない
+	if err := repo.CreateProfile(ctx, UserProfile{UserID: "u1", DisplayName: "二度目"}); err != nil {
+		t.Fatal(err)
+	}
+	if profile, _ := repo.GetProfile(ctx, "u1"); profile.DisplayName != "最初" {
+		t.Errorf("DisplayName after a second CreateProfile = %q", profile.DisplayName)
+	}
+	if err := repo.SaveProfile(ctx, UserProfile{UserID: "u1", DisplayName: "上書き"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.SaveProfile(ctx, UserProfile{UserID: "u2", DisplayName: "二人目"}); err != nil {
+		t.Fatal(err)
+	}
+
+	profiles, err := repo.ListProfiles(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, p := range profiles {
+		names = append(names, p.UserID+":"+p.DisplayName)
+	}
+	if !reflect.DeepEqual(names, []string{"u1:上書き", "u2:二人目"}) {
+		t.Errorf("ListProfiles = %v", names)
+	}
+}
+
+func TestSQLShelfVersions(t *testing.T) {
+	ctx := context.Background()
+	versions := openSQLite(t).Versions
+
+	if v, err := versions.Get(ctx, "u1"); err != nil || v.Version != 0 {
+		t.Fatalf("Get before Bump = %+v, %v", v, err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := versions.Bump(ctx, "u1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if v, _ := versions.Get(ctx, "u1"); v.Version != 2 || v.UpdatedAt.IsZero() {
+		t.Errorf("Get after two Bumps = %+v", v)
+	}
+}
+
+func TestRebind(t *testing.T) {
+	query := `SELECT data FROM books WHERE user_id = ? AND status = ?`
+	if got := (sqlStore{dialect: sqlmigrate.SQLite}).rebind(query); got != query {
+		t.Errorf("SQLite rebind = %q", got)
+	}
+	if got, want := (sqlStore{dialect: sqlmigrate.Postgres}).rebind(query), `SELECT data FROM books WHERE user_id = $1 AND status = $2`; got != want {
+		t.Errorf("Postgres rebind = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,68 @@
+package store
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrProfileNotFound はプロフィールが無いときに UserRepository が返すエラー
+var ErrProfileNotFound = errors.New("profile not found")
+
+// UserSettings はユーザーごとの設定。userSettings/{userId} に保存する。
+// ドキュメントが無いユーザーはゼロ値 (どこにも公開しない) として扱う
+type UserSettings struct {
+	UserID string `json:"userId" firestore:"userId"`
+	// 他のユーザーに表示する名前。空なら "名無しの積読家"
+	DisplayName string `json:"displayName" firestore:"displayName"`
+	// 友達のリーダーボードに自分の成績を表示するか
+	LeaderboardVisible bool `json:"leaderboardVisible" firestore:"leaderboardVisible"`
+	// 公開の「恥の壁」に期限切れの本を載せるか。"" (載せない)・"anonymous" (名前を伏せる)・"named" (表示名で載せる)
+	ShameWall string `json:"shameWall" firestore:"shameWall"`
+	// 難しいモード。読了にする前に、本の内容のクイズ (/v1/books/quiz) に正解しなければならない
+	HardMode bool `json:"hardMode,omitempty" firestore:"hardMode,omitempty"`
+	// 購入禁止モード。未読の本がこの冊数より多いと、本の登録を止める。0 なら止めない
+	PurchaseBanLimit int `json:"purchaseBanLimit,omitempty" firestore:"purchaseBanLimit,omitempty"`
+	// 未読の本の上限。登録すると未読の本がこの冊数を超えるなら、押し切れずに断る。0 なら上限なし
+	UnreadHardCap int `json:"unreadHardCap,omitempty" firestore:"unreadHardCap,omitempty"`
+	// 煽り文に入れてほしくない語句・話題。当たる煽り文は送らない
+	BlockedTerms []string `json:"blockedTerms,omitempty" firestore:"blockedTerms,omitempty"`
+	// 近所の図書館 (カーリルの図書館システムID)。ウィッシュリストの本があれば、買う前に借りるよう勧める
+	LibrarySystems []string `json:"librarySystems,omitempty" firestore:"librarySystems,omitempty"`
+	// 読み終えた本を「あなたに似た読者」の推薦に使ってよいか。有効にした人どうしでだけ推薦し合う
+	ShareReadingHistory bool      `json:"shareReadingHistory,omitempty" firestore:"shareReadingHistory,omitempty"`
+	UpdatedAt           time.Time `json:"updatedAt" firestore:"updatedAt"`
+
+	// ProfileName はプロフィール (users/{uid}) の表示名。DisplayName が空のときに使う。保存はしない
+	ProfileName string `json:"-" firestore:"-"`
+}
+
+// defaultDisplayName は表示名を設定していないユーザーの名前
+const defaultDisplayName = "名無しの積読家"
+
+// Name は他のユーザーに見せる表示名を返す。設定の表示名、プロフィールの表示名の順に使う
+func (s UserSettings) Name() string {
+	switch {
+	case s.DisplayName != "":
+		return s.DisplayName
+	case s.ProfileName != "":
+		return s.ProfileName
+	}
+	return defaultDisplayName
+}
+
+// UserProfile はユーザーのプロフィール (表示名とアイコン)。users/{uid} に保存する
+type UserProfile struct {
+	UserID      string `json:"userId" firestore:"userId"`
+	DisplayName string `json:"displayName" firestore:"displayName"`
+	PictureURL  string `json:"pictureUrl,omitempty" firestore:"pictureUrl,omitempty"`
+	Provider    string `json:"provider" firestore:"provider"` // 最初にログインした方法 ("line" か "google")
+	// Email は Google でできたアカウントの確認済みのメールアドレス。LINE をつないでいなければ通知の送り先になる
+	Email string `json:"email,omitempty" firestore:"email,omitempty"`
+	// LineUserID は Google などでできたアカウントにつないだ LINE のユーザーID。LINE の送信先になる
+	LineUserID string    `json:"lineUserId,omitempty" firestore:"lineUserId,omitempty"`
+	CreatedAt  time.Time `json:"createdAt" firestore:"createdAt"`
+	UpdatedAt  time.Time `json:"updatedAt" firestore:"updatedAt"`
+	// DisabledAt は運用者がアカウントを停止した日時。停止中はログインできず、LINE・メールの通知も送らない
+	DisabledAt     *time.Time `json:"disabledAt,omitempty" firestore:"disabledAt,omitempty"`
+	DisabledReason string     `json:"disabledReason,omitempty" firestore:"disabledReason,omitempty"`
+}
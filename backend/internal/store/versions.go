@@ -0,0 +1,155 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ShelfVersion はユーザーの本棚 (本の集合) のバージョン。本を1冊でも書き換えると進む。
+// 一覧の ETag・Last-Modified に使い、変わっていなければ一覧を読まずに 304 を返す
+type ShelfVersion struct {
+	Version   int64     `json:"version" firestore:"version"`
+	UpdatedAt time.Time `json:"updatedAt" firestore:"updatedAt"`
+}
+
+// ShelfVersions は本棚のバージョンの保存先
+type ShelfVersions interface {
+	// Get は userID の本棚のバージョンを返す。1度も進めていなければゼロ値
+	Get(ctx context.Context, userID string) (ShelfVersion, error)
+	// Bump は userIDs の本棚のバージョンを1つ進める
+	Bump(ctx context.Context, userIDs ...string) error
+}
+
+// VersionedBookRepository は BookRepository を包み、本を書き換えるたびに所持者の本棚のバージョンを進める。
+// Patch・Delete は本のIDしか受け取らないので、所持者を知るために先に1度読む
+type VersionedBookRepository struct {
+	BookRepository
+	Versions ShelfVersions
+}
+
+func (r VersionedBookRepository) Create(ctx context.Context, book Book) (Book, error) {
+	book, err := r.BookRepository.Create(ctx, book)
+	if err != nil {
+		return Book{}, err
+	}
+	r.bump(ctx, book.UserID)
+	return book, nil
+}
+
+func (r VersionedBookRepository) Update(ctx context.Context, book Book) error {
+	if err := r.BookRepository.Update(ctx, book); err != nil {
+		return err
+	}
+	r.bump(ctx, book.UserID)
+	return nil
+}
+
+func (r VersionedBookRepository) Patch(ctx context.Context, bookID string, patch BookPatch) error {
+	book, err := r.BookRepository.Get(ctx, bookID)
+	if err != nil {
+		return err
+	}
+	if err := r.BookRepository.Patch(ctx, bookID, patch); err != nil {
+		return err
+	}
+	r.bump(ctx, book.UserID)
+	return nil
+}
+
+func (r VersionedBookRepository) MoveDeadline(ctx context.Context, bookID string, from, to time.Time) error {
+	book, err := r.BookRepository.Get(ctx, bookID)
+	if err != nil {
+		return err
+	}
+	if err := r.BookRepository.MoveDeadline(ctx, bookID, from, to); err != nil {
+		return err
+	}
+	r.bump(ctx, book.UserID)
+	return nil
+}
+
+func (r VersionedBookRepository) PatchAll(ctx context.Context, patches map[string]BookPatch) map[string]error {
+	errs := r.BookRepository.PatchAll(ctx, patches)
+	owners := make(map[string]bool)
+	for bookID := range patches {
+		if errs[bookID] != nil {
+			continue
+		}
+		book, err := r.BookRepository.Get(ctx, bookID)
+		if err != nil {
+			log.Printf("Error fetching book %s to bump its shelf version: %v", bookID, err)
+			continue
+		}
+		owners[book.UserID] = true
+	}
+	for userID := range owners {
+		r.bump(ctx, userID)
+	}
+	return errs
+}
+
+func (r VersionedBookRepository) Delete(ctx context.Context, bookID string) error {
+	book, err := r.BookRepository.Get(ctx, bookID)
+	if err == ErrBookNotFound {
+		return r.BookRepository.Delete(ctx, bookID)
+	}
+	if err != nil {
+		return err
+	}
+	if err := r.BookRepository.Delete(ctx, bookID); err != nil {
+		return err
+	}
+	r.bump(ctx, book.UserID)
+	return nil
+}
+
+// bump はバージョンを進める。失敗しても書き換え自体は成功しているので、ログに残すだけにする
+func (r VersionedBookRepository) bump(ctx context.Context, userID string) {
+	if err := r.Versions.Bump(ctx, userID); err != nil {
+		log.Printf("Error bumping shelf version for %s: %v", userID, err)
+	}
+}
+
+// firestoreShelfVersions は ShelfVersions の Firestore の実装。shelfVersions/{userId} に保存する
+type firestoreShelfVersions struct {
+	client *firestore.Client
+}
+
+func NewFirestoreShelfVersions(client *firestore.Client) ShelfVersions {
+	return &firestoreShelfVersions{client: client}
+}
+
+func (v *firestoreShelfVersions) Get(ctx context.Context, userID string) (ShelfVersion, error) {
+	doc, err := v.client.Collection("shelfVersions").Doc(userID).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return ShelfVersion{}, nil
+	}
+	if err != nil {
+		return ShelfVersion{}, fmt.Errorf("error fetching shelf version: %w", err)
+	}
+	var version ShelfVersion
+	if err := doc.DataTo(&version); err != nil {
+		return ShelfVersion{}, fmt.Errorf("error parsing shelf version: %w", err)
+	}
+	return version, nil
+}
+
+func (v *firestoreShelfVersions) Bump(ctx context.Context, userIDs ...string) error {
+	now := time.Now()
+	for _, userID := range userIDs {
+		_, err := v.client.Collection("shelfVersions").Doc(userID).Set(ctx, map[string]interface{}{
+			"version":   firestore.Increment(1),
+			"updatedAt": now,
+		}, firestore.MergeAll)
+		if err != nil {
+			return fmt.Errorf("error bumping shelf version: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+// Package validation はリクエストの入力チェックを行い、フィールド単位のエラーを組み立てる
+package validation
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// FieldError は1つのフィールドに対する検証エラー
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Errors は検証エラーの一覧。error として返せる
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, fe := range e {
+		msgs[i] = fe.Field + ": " + fe.Message
+	}
+	return "validation failed: " + strings.Join(msgs, "; ")
+}
+
+// Validator は検証エラーを溜めていく。ゼロ値でそのまま使える
+type Validator struct {
+	errs Errors
+}
+
+// Check は ok が false のとき field にエラーを追加する
+func (v *Validator) Check(ok bool, field, message string) {
+	if !ok {
+		v.errs = append(v.errs, FieldError{Field: field, Message: message})
+	}
+}
+
+// Required は value が空白のみでないことを確認する
+func (v *Validator) Required(field, value string) {
+	v.Check(strings.TrimSpace(value) != "", field, "is required")
+}
+
+// MaxLength は value が max 文字 (バイトではなくルーン数) 以下であることを確認する
+func (v *Validator) MaxLength(field, value string, max int) {
+	v.Check(utf8.RuneCountInString(value) <= max, field, fmt.Sprintf("must be at most %d characters", max))
+}
+
+// OneOf は value が allowed のいずれかであることを確認する
+func (v *Validator) OneOf(field, value string, allowed ...string) {
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	v.Check(false, field, "must be one of "+strings.Join(allowed, ", "))
+}
+
+// Range は n が min 以上 max 以下であることを確認する
+func (v *Validator) Range(field string, n, min, max int) {
+	v.Check(n >= min && n <= max, field, fmt.Sprintf("must be between %d and %d", min, max))
+}
+
+// Future は t が設定されていて now より後であることを確認する
+func (v *Validator) Future(field string, t, now time.Time) {
+	if t.IsZero() {
+		v.Check(false, field, "is required")
+		return
+	}
+	v.Check(t.After(now), field, "must be in the future")
+}
+
+// Err は溜まったエラーを返す。エラーがなければ nil
+func (v *Validator) Err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return v.errs
+}